@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/plan"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+var (
+	// applyCfg holds the configuration for the apply command
+	applyCfg *config.Config
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Create merge requests for the updates of a plan file",
+	Long: `Create merge requests for exactly the updates listed in a plan file
+written by "img-upgr check --plan". The repository is cloned using the
+IMG_UPGR_GL_REPO environment variable.
+
+Examples:
+  img-upgr check --plan plan.json   Detect updates and write them to plan.json
+  img-upgr apply --plan plan.json   Create merge requests for the reviewed plan`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		if err := runApplyCommand(ctx); err != nil {
+			logger.Error("Apply command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+// runApplyCommand is the main function for the apply command
+func runApplyCommand(ctx context.Context) error {
+	// Load the plan before touching the repository
+	updatePlan, err := plan.Read(applyCfg.PlanFile)
+	if err != nil {
+		return err
+	}
+
+	if applyCfg.GitLabRepo == "" {
+		return fmt.Errorf("%s must be set to apply a plan", config.EnvGitLabRepo)
+	}
+
+	if updatePlan.GitLabRepo != "" && updatePlan.GitLabRepo != applyCfg.GitLabRepo {
+		return fmt.Errorf("plan was created for %s but %s is configured", updatePlan.GitLabRepo, applyCfg.GitLabRepo)
+	}
+
+	if len(updatePlan.Updates) == 0 {
+		logger.Info("Plan %s contains no updates", applyCfg.PlanFile)
+		return nil
+	}
+
+	// Initialize and validate configuration
+	if err := initializeAndValidate(ctx, applyCfg); err != nil {
+		return fmt.Errorf("initialization failed: %w", err)
+	}
+
+	// Clean up repository when done
+	defer gitlab.CleanupRepository(applyCfg)
+
+	updates := resolvePlanUpdates(applyCfg, updatePlan)
+	if len(updates) == 0 {
+		logger.Info("No planned updates are applicable anymore")
+		return nil
+	}
+
+	logger.Info("Applying %d planned updates", len(updates))
+	if _, err := createMergeRequestsForUpdates(ctx, applyCfg, updates); err != nil {
+		return fmt.Errorf("failed to create merge requests: %w", err)
+	}
+
+	return nil
+}
+
+// resolvePlanUpdates converts planned updates back to updates on the cloned repository,
+// skipping those whose file no longer references the old image
+func resolvePlanUpdates(cfg *config.Config, updatePlan *plan.Plan) []result.UpdateCandidate {
+	var updates []result.UpdateCandidate
+	for _, planned := range updatePlan.Updates {
+		filePath := filepath.FromSlash(planned.File)
+		if !filepath.IsAbs(filePath) && cfg.TempDir != "" {
+			filePath = filepath.Join(cfg.TempDir, filePath)
+		}
+
+		content, err := repofs.ReadFile(filePath)
+		if err != nil {
+			logger.Warn("Skipping %s in %s: %v", planned.ServiceName, planned.File, err)
+			continue
+		}
+
+		update := result.UpdateCandidate{
+			FilePath:     filePath,
+			ServiceName:  planned.ServiceName,
+			OldImage:     planned.OldImage,
+			NewImage:     planned.NewImage,
+			Repository:   planned.Repository,
+			OldTag:       planned.OldTag,
+			NewTag:       planned.NewTag,
+			Kind:         planned.Kind,
+			Major:        planned.Major,
+			Downgrade:    planned.Downgrade,
+			Verification: planned.Verification,
+		}
+
+		if !strings.Contains(string(content), oldValue(update)) {
+			logger.Warn("Skipping %s in %s: %s is no longer referenced", planned.ServiceName, planned.File, oldValue(update))
+			continue
+		}
+
+		updates = append(updates, update)
+	}
+
+	return updates
+}
+
+func init() {
+	applyCfg = config.New()
+	applyCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(applyCmd)
+
+	applyCmd.Flags().StringVar(&applyCfg.PlanFile, "plan", "", "Plan file written by \"img-upgr check --plan\"")
+	_ = applyCmd.MarkFlagRequired("plan")
+	applyCmd.Flags().StringVar(&applyCfg.WorkDir, "workdir", applyCfg.WorkDir,
+		"Keep the cloned repository in this directory between runs and only fetch changes")
+	applyCmd.Flags().BoolVar(&applyCfg.APIOnly, "api-only", applyCfg.APIOnly,
+		"Fetch files and push changes through the GitLab API instead of cloning the repository")
+	applyCmd.Flags().IntVar(&applyCfg.MRLimit, "mr-limit", applyCfg.MRLimit,
+		"Maximum number of img-upgr merge requests open at the same time in the project (0 for no limit)")
+	applyCmd.Flags().IntVar(&applyCfg.MRRunLimit, "mr-run-limit", applyCfg.MRRunLimit,
+		"Maximum number of merge requests created by a single run (0 for no limit)")
+	applyCmd.Flags().IntVar(&applyCfg.MRRetries, "mr-retries", applyCfg.MRRetries,
+		"Number of retries of an update whose branch or merge request failed")
+	applyCmd.Flags().BoolVar(&applyCfg.BestEffort, "best-effort", applyCfg.BestEffort,
+		"Exit successfully even when some merge requests failed")
+	applyCmd.Flags().BoolVar(&applyCfg.Draft, "draft", applyCfg.Draft,
+		"Create merge requests as drafts, which must be marked ready before merging")
+	applyCmd.Flags().BoolVar(&applyCfg.MRSquash, "squash", applyCfg.MRSquash,
+		"Squash the commits of merge requests when they are merged")
+	applyCmd.Flags().BoolVar(&applyCfg.MRRemoveSourceBranch, "remove-source-branch", applyCfg.MRRemoveSourceBranch,
+		"Delete the branches of merge requests when they are merged")
+	applyCmd.Flags().BoolVar(&applyCfg.VerifyPull, "verify-pull", applyCfg.VerifyPull,
+		"Request the manifest of suggested tags before creating their merge requests")
+	applyCmd.Flags().StringVar(&applyCfg.GroupBy, "group-by", applyCfg.GroupBy,
+		"Group updates into merge requests (none for one per update, directory for one per directory, image for one per image across files)")
+	applyCmd.Flags().IntVar(&applyCfg.GroupDepth, "group-depth", applyCfg.GroupDepth,
+		"Number of leading directories grouping updates with --group-by directory (0 for the file directory)")
+	applyCmd.Flags().StringVar(&applyCfg.BranchConflict, "branch-conflict", applyCfg.BranchConflict,
+		"Handling of an existing branch of an update: reuse it, force to reset it, or suffix to push to a new one")
+}
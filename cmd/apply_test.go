@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitops"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/plan"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+)
+
+func TestRunApplyCommandRejectsMismatchedPlans(t *testing.T) {
+	const repo = "https://gitlab.example.com/group/project"
+	writePlan := func(gitlabRepo string) string {
+		p := plan.New(gitlabRepo)
+		p.Updates = append(p.Updates, plan.Update{File: "compose.yml", ServiceName: "web", OldImage: "nginx:1.25.0", NewImage: "nginx:1.27.0"})
+		path := filepath.Join(t.TempDir(), "plan.json")
+		if err := p.Write(path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	outdated := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(outdated, []byte(`{"version": 0, "updates": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		planFile   string
+		gitlabRepo string
+		wantErr    string
+	}{
+		{"other repository", writePlan("https://gitlab.example.com/group/other"), repo, "plan was created for https://gitlab.example.com/group/other"},
+		{"no repository configured", writePlan(repo), "", config.EnvGitLabRepo + " must be set"},
+		{"unsupported version", outdated, repo, "unsupported plan version 0"},
+		{"missing plan", filepath.Join(t.TempDir(), "missing.json"), repo, "failed to read plan file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := applyCfg
+			t.Cleanup(func() { applyCfg = previous })
+			applyCfg = config.New()
+			applyCfg.PlanFile = tt.planFile
+			applyCfg.GitLabRepo = tt.gitlabRepo
+
+			// The plan is rejected before the repository is cloned
+			err := runApplyCommand(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("runApplyCommand() error = %v, want %q", err, tt.wantErr)
+			}
+			if applyCfg.TempDir != "" {
+				t.Errorf("repository cloned to %s for a rejected plan", applyCfg.TempDir)
+			}
+		})
+	}
+}
+
+func TestResolvePlanUpdates(t *testing.T) {
+	cfg := config.New()
+	cfg.TempDir = t.TempDir()
+	files := map[string]string{
+		"compose.yml":      "services:\n  web:\n    image: nginx:1.25.0\n  cache:\n    image: redis:7.4.1\n",
+		"flux/podinfo.yml": "spec:\n  chart:\n    spec:\n      chart: podinfo\n      version: 6.5.0\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(cfg.TempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := plan.New("")
+	p.Updates = []plan.Update{
+		{File: "compose.yml", ServiceName: "web", Repository: "nginx", OldImage: "nginx:1.25.0", NewImage: "nginx:1.27.0", OldTag: "1.25.0", NewTag: "1.27.0"},
+		// Updated since the plan was written
+		{File: "compose.yml", ServiceName: "cache", Repository: "redis", OldImage: "redis:7.0.0", NewImage: "redis:7.4.1", OldTag: "7.0.0", NewTag: "7.4.1"},
+		// Deleted since the plan was written
+		{File: "old/compose.yml", ServiceName: "db", Repository: "postgres", OldImage: "postgres:15.0", NewImage: "postgres:15.4", OldTag: "15.0", NewTag: "15.4"},
+		// Charts are matched on their version
+		{File: "flux/podinfo.yml", ServiceName: "HelmRelease/apps/podinfo", Repository: "podinfo", OldImage: "podinfo:6.5.0", NewImage: "podinfo:6.6.0", OldTag: "6.5.0", NewTag: "6.6.0", Kind: gitops.KindChart},
+	}
+
+	updates := resolvePlanUpdates(cfg, p)
+	var got []string
+	for _, update := range updates {
+		got = append(got, update.ServiceName)
+		if !strings.HasPrefix(update.FilePath, cfg.TempDir) {
+			t.Errorf("FilePath = %s, want a path of the cloned repository", update.FilePath)
+		}
+	}
+	if want := "web, HelmRelease/apps/podinfo"; strings.Join(got, ", ") != want {
+		t.Errorf("resolvePlanUpdates() = %v, want %s", got, want)
+	}
+}
+
+func TestResolvePlanUpdatesAPIOnly(t *testing.T) {
+	cfg := config.New()
+	cfg.APIOnly = true
+	cfg.TempDir = filepath.Join(t.TempDir(), "repo")
+	// API-only runs hold the files of the repository in memory
+	repofs.Mount(cfg.TempDir, map[string][]byte{
+		"compose.yml": []byte("services:\n  web:\n    image: nginx:1.25.0\n"),
+	})
+	t.Cleanup(func() { repofs.Unmount(cfg.TempDir) })
+
+	p := plan.New("")
+	p.Updates = []plan.Update{{File: "compose.yml", ServiceName: "web", Repository: "nginx", OldImage: "nginx:1.25.0", NewImage: "nginx:1.27.0", OldTag: "1.25.0", NewTag: "1.27.0"}}
+	if updates := resolvePlanUpdates(cfg, p); len(updates) != 1 {
+		t.Errorf("resolvePlanUpdates() = %+v, want the update of the mounted file", updates)
+	}
+}
+
+func TestHandleUpdatesWritesEmptyPlan(t *testing.T) {
+	previous := checkCfg
+	t.Cleanup(func() { checkCfg = previous })
+	checkCfg = config.New()
+	checkCfg.GitLabRepo = "https://gitlab.example.com/group/project"
+	checkCfg.PlanFile = filepath.Join(t.TempDir(), "plan.json")
+
+	// The plan of an earlier run is replaced rather than applied again
+	stale := plan.New(checkCfg.GitLabRepo)
+	stale.Updates = append(stale.Updates, plan.Update{File: "compose.yml", ServiceName: "web", OldImage: "nginx:1.25.0", NewImage: "nginx:1.27.0"})
+	if err := stale.Write(checkCfg.PlanFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := handleUpdates(context.Background(), nil); err != nil {
+		t.Fatalf("handleUpdates() error = %v", err)
+	}
+	p, err := plan.Read(checkCfg.PlanFile)
+	if err != nil {
+		t.Fatalf("plan.Read() error = %v", err)
+	}
+	if len(p.Updates) != 0 || p.GitLabRepo != checkCfg.GitLabRepo {
+		t.Errorf("plan = %+v, want an empty plan of %s", p, checkCfg.GitLabRepo)
+	}
+}
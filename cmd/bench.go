@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/bench"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/validation"
+)
+
+// benchFormats are the output formats of the bench command
+var benchFormats = []string{"text", "json"}
+
+var (
+	// benchCfg holds the configuration for the bench command
+	benchCfg *config.Config
+	// benchFormat is the output format of the report
+	benchFormat string
+	// benchIterations is the number of times the scan is repeated
+	benchIterations int
+	// benchStubTags is the number of tags published by the stub registry
+	benchStubTags int
+	// benchLatency is the simulated latency of the requests to the stub registry
+	benchLatency time.Duration
+	// benchRecord is the catalog the answers of the registries are recorded to
+	benchRecord string
+	// benchCPUProfile and benchMemProfile are the pprof profiles written
+	benchCPUProfile string
+	benchMemProfile string
+)
+
+var benchCmd = &cobra.Command{
+	Use:   "bench [directory]",
+	Short: "Measure the phases of a scan to tune concurrency and caching",
+	Long: `Scan the files of a local directory like the check command, without cloning
+the GitLab repository or creating merge requests, and report the time and heap
+allocations of each phase: finding the files, parsing them and checking their
+images. The scan is repeated --iterations times.
+
+Registries are stubbed by default, answering every repository with --stub-tags
+versions after --latency. With --tag-catalog, the tags recorded in a catalog are
+replayed instead. With --record, the real registries are queried and their
+answers recorded to a catalog, which later runs replay with --tag-catalog.
+
+Examples:
+  img-upgr bench deploy/                              Benchmark deploy/ against a stub registry
+  img-upgr bench deploy/ --latency 50ms --concurrency 16
+  img-upgr bench deploy/ --record tags.json -n 1      Record the answers of the registries
+  img-upgr bench deploy/ --tag-catalog tags.json      Replay the recorded answers
+  img-upgr bench deploy/ --cpuprofile cpu.out         Also write a CPU profile for go tool pprof`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		if err := runBenchCommand(ctx, benchCfg, args); err != nil {
+			logger.Error("Bench command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+// runBenchCommand scans the directory the given number of times and prints the measures
+// of its phases
+func runBenchCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	if !validation.IsValidChoice(benchFormat, benchFormats) {
+		return fmt.Errorf("invalid output format %q, must be one of: %s", benchFormat, strings.Join(benchFormats, ", "))
+	}
+	if benchIterations < 1 {
+		return fmt.Errorf("--iterations must be at least 1, got %d", benchIterations)
+	}
+	if benchRecord != "" && cfg.TagCatalog != "" {
+		return fmt.Errorf("--record and --tag-catalog cannot be used together")
+	}
+
+	// The local files are measured, the repository is never cloned
+	cfg.GitLabRepo = ""
+	// Platforms are not known to the stub and catalog, the tags are compared alone
+	cfg.Platform = ""
+	if err := cfg.ResolveSecrets(ctx); err != nil {
+		return err
+	}
+
+	client, catalog, name, err := newBenchRegistry(cfg)
+	if err != nil {
+		return err
+	}
+	counter := bench.NewCounter(client)
+
+	if benchCPUProfile != "" {
+		stop, err := startCPUProfile(benchCPUProfile)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	// Logging every file and image would be measured along with the scan
+	if logger.GetLevel() == logger.INFO.String() {
+		logger.SetLevel(logger.WARN)
+		defer logger.SetLevel(logger.INFO)
+	}
+
+	report := &bench.Report{Registry: name, Concurrency: cfg.Concurrency, Iterations: benchIterations}
+	for range benchIterations {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := benchScan(ctx, cfg, args, counter, report); err != nil {
+			return err
+		}
+	}
+	report.Directory = cfg.ScanDir
+	report.RegistryCalls = counter.Calls() / int64(benchIterations)
+
+	if catalog != nil {
+		if err := catalog.Write(benchRecord); err != nil {
+			return err
+		}
+		logger.Info("Recorded the tags of %d repositories to %s", len(catalog.Repositories), benchRecord)
+	}
+	if benchMemProfile != "" {
+		if err := writeMemProfile(benchMemProfile); err != nil {
+			return err
+		}
+	}
+
+	if benchFormat == "json" {
+		return report.WriteJSON(os.Stdout)
+	}
+	return report.WriteText(os.Stdout)
+}
+
+// newBenchRegistry returns the registry client of the benchmark and its description, with
+// the catalog its answers are recorded to when recording
+func newBenchRegistry(cfg *config.Config) (registry.Client, *registry.Catalog, string, error) {
+	switch {
+	case cfg.TagCatalog != "":
+		resolver, err := newRegistryClient(cfg)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return resolver, nil, "catalog " + cfg.TagCatalog, nil
+	case benchRecord != "":
+		resolver, err := newRegistryClient(cfg)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("failed to configure registries: %w", err)
+		}
+		catalog := registry.NewCatalog()
+		return registry.NewRecorder(resolver, catalog), catalog, "registries recorded to " + benchRecord, nil
+	}
+	return bench.NewStub(benchStubTags, benchLatency), nil,
+		fmt.Sprintf("stub with %d tags and %s latency", benchStubTags, benchLatency), nil
+}
+
+// benchScan runs an iteration of the scan, measuring each of its phases
+func benchScan(ctx context.Context, cfg *config.Config, args []string, client registry.Client, report *bench.Report) error {
+	var files []string
+	err := report.Measure("discover", "files", func() (int, error) {
+		var err error
+		files, err = determineFilesToScan(cfg, args)
+		return len(files), err
+	})
+	if err != nil {
+		return err
+	}
+
+	var images []listedImage
+	err = report.Measure("parse", "files", func() (int, error) {
+		images = listImages(cfg, files)
+		return len(files), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = report.Measure("check", "images", func() (int, error) {
+		_, _, err := processComposeFilesWithContext(ctx, cfg, nil, files, client)
+		return len(images), err
+	})
+	if err != nil {
+		return err
+	}
+
+	report.Files = len(files)
+	report.Images = len(images)
+	report.Bytes = 0
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			report.Bytes += info.Size()
+		}
+	}
+	return nil
+}
+
+// startCPUProfile starts writing a CPU profile to a file, returning the function stopping it
+func startCPUProfile(path string) (func(), error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CPU profile: %w", err)
+	}
+	if err := pprof.StartCPUProfile(file); err != nil {
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close CPU profile: %v", err)
+		}
+		return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close CPU profile: %v", err)
+		}
+	}, nil
+}
+
+// writeMemProfile writes a heap profile of the allocations to a file
+func writeMemProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create memory profile: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to close memory profile: %v", err)
+		}
+	}()
+
+	// Collect the garbage so the profile holds up-to-date statistics
+	runtime.GC()
+	if err := pprof.Lookup("allocs").WriteTo(file, 0); err != nil {
+		return fmt.Errorf("failed to write memory profile: %w", err)
+	}
+	return nil
+}
+
+// init registers the bench command
+func init() {
+	benchCfg = config.New()
+	benchCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(benchCmd)
+
+	benchCmd.Flags().StringVarP(&benchFormat, "output", "o", "text", "Output format ("+strings.Join(benchFormats, ", ")+")")
+	benchCmd.Flags().IntVarP(&benchIterations, "iterations", "n", 3, "Number of times the scan is repeated")
+	benchCmd.Flags().IntVar(&benchCfg.Concurrency, "concurrency", benchCfg.Concurrency, "Number of compose files processed in parallel")
+	benchCmd.Flags().IntVar(&benchStubTags, "stub-tags", 100, "Number of tags the stub registry publishes for every repository")
+	benchCmd.Flags().DurationVar(&benchLatency, "latency", 0, "Simulated latency of each request to the stub registry")
+	benchCmd.Flags().StringVar(&benchCfg.TagCatalog, "tag-catalog", benchCfg.TagCatalog,
+		"Replay the tags of a catalog written by export-tags or --record instead of the stub registry")
+	benchCmd.Flags().StringVar(&benchRecord, "record", "", "Query the registries and record their answers to this catalog file")
+	benchCmd.Flags().StringVar(&benchCPUProfile, "cpuprofile", "", "Write a CPU profile of the benchmark to this file")
+	benchCmd.Flags().StringVar(&benchMemProfile, "memprofile", "", "Write a memory profile of the benchmark to this file")
+}
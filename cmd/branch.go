@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+// maxBranchSuffix is the highest suffix tried for a free branch name
+const maxBranchSuffix = 20
+
+// branchAction is what a run does with the branch of an update
+type branchAction int
+
+const (
+	// branchCreate creates a new branch
+	branchCreate branchAction = iota
+	// branchReuse opens the merge request from the existing branch as it is
+	branchReuse
+	// branchForce resets the existing branch to the target branch and pushes the update again
+	branchForce
+)
+
+// updateBranchName returns the deterministic branch of an update, such as img-upgr/nginx-1.25.4,
+// so that reruns proposing the same update find its branch again
+func updateBranchName(update result.UpdateCandidate) string {
+	return fmt.Sprintf("%s%s-%s", gitlab.BranchPrefix, sanitizeBranchComponent(update.ServiceName), sanitizeBranchComponent(update.NewTag))
+}
+
+// resolveUpdateBranch returns the branch to push an update to and what to do with it when
+// the branch already exists, according to the configured branch conflict handling
+func resolveUpdateBranch(cfg *config.Config, name string, exists func(string) (bool, error)) (string, branchAction, error) {
+	found, err := exists(name)
+	if err != nil {
+		return "", branchCreate, fmt.Errorf("failed to check branch %s: %w", name, err)
+	}
+	if !found {
+		return name, branchCreate, nil
+	}
+
+	switch cfg.BranchConflict {
+	case "force":
+		return name, branchForce, nil
+	case "suffix":
+		for i := 2; i <= maxBranchSuffix; i++ {
+			candidate := fmt.Sprintf("%s-%d", name, i)
+			if found, err := exists(candidate); err != nil {
+				return "", branchCreate, fmt.Errorf("failed to check branch %s: %w", candidate, err)
+			} else if !found {
+				return candidate, branchCreate, nil
+			}
+		}
+		return "", branchCreate, fmt.Errorf("branches %s to %s-%d already exist", name, name, maxBranchSuffix)
+	default:
+		return name, branchReuse, nil
+	}
+}
+
+// branchExists returns a lookup of the branches of the GitLab project of the configuration
+func branchExists(ctx context.Context, cfg *config.Config) func(string) (bool, error) {
+	return func(name string) (bool, error) {
+		gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+		if !ok {
+			return false, fmt.Errorf("invalid GitLab client type")
+		}
+		return gitlabClient.BranchExistsWithContext(ctx, name)
+	}
+}
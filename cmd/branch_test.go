@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+func TestUpdateBranchName(t *testing.T) {
+	tests := []struct {
+		service string
+		tag     string
+		want    string
+	}{
+		{"web", "1.25.4", "img-upgr/web-1.25.4"},
+		{"web", "1.25.4-alpine_3.19", "img-upgr/web-1.25.4-alpine_3.19"},
+		{"app", "1.2.3+build.5", "img-upgr/app-1.2.3-build.5"},
+		{"app", "release/2.0", "img-upgr/app-release-2.0"},
+		{"my service", "v1~rc:1", "img-upgr/my-service-v1-rc-1"},
+	}
+	for _, tt := range tests {
+		update := result.UpdateCandidate{ServiceName: tt.service, NewTag: tt.tag}
+		if got := updateBranchName(update); got != tt.want {
+			t.Errorf("updateBranchName(%s, %s) = %s, want %s", tt.service, tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestResolveUpdateBranch(t *testing.T) {
+	const name = "img-upgr/web-1.25.4"
+	// taken returns the existing branches: name followed by the suffixes 2 to n
+	taken := func(n int) []string {
+		branches := []string{name}
+		for i := 2; i <= n; i++ {
+			branches = append(branches, fmt.Sprintf("%s-%d", name, i))
+		}
+		return branches
+	}
+
+	tests := []struct {
+		name       string
+		conflict   string
+		existing   []string
+		want       string
+		wantAction branchAction
+		wantErr    bool
+	}{
+		{"new branch", "reuse", nil, name, branchCreate, false},
+		{"new branch with suffixes", "suffix", nil, name, branchCreate, false},
+		{"reuse", "reuse", taken(1), name, branchReuse, false},
+		{"default reuses", "", taken(1), name, branchReuse, false},
+		{"force", "force", taken(1), name, branchForce, false},
+		{"first suffix", "suffix", taken(1), name + "-2", branchCreate, false},
+		{"next suffix", "suffix", taken(4), name + "-5", branchCreate, false},
+		{"free suffix between taken ones", "suffix", []string{name, name + "-2", name + "-4"}, name + "-3", branchCreate, false},
+		{"last suffix", "suffix", taken(maxBranchSuffix - 1), fmt.Sprintf("%s-%d", name, maxBranchSuffix), branchCreate, false},
+		{"suffixes exhausted", "suffix", taken(maxBranchSuffix), "", branchCreate, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.BranchConflict = tt.conflict
+
+			var checked []string
+			exists := func(branch string) (bool, error) {
+				checked = append(checked, branch)
+				return slices.Contains(tt.existing, branch), nil
+			}
+
+			got, action, err := resolveUpdateBranch(cfg, name, exists)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveUpdateBranch() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want || action != tt.wantAction {
+				t.Errorf("resolveUpdateBranch() = %s, %v, want %s, %v", got, action, tt.want, tt.wantAction)
+			}
+			if len(checked) > maxBranchSuffix {
+				t.Errorf("checked %d branches, want at most %d", len(checked), maxBranchSuffix)
+			}
+		})
+	}
+}
+
+func TestResolveUpdateBranchLookupError(t *testing.T) {
+	cfg := config.New()
+	cfg.BranchConflict = "suffix"
+	failure := errors.New("status 500")
+
+	// A failed lookup is not taken as a free branch
+	for _, failing := range []string{"img-upgr/web-1.25.4", "img-upgr/web-1.25.4-2"} {
+		_, _, err := resolveUpdateBranch(cfg, "img-upgr/web-1.25.4", func(branch string) (bool, error) {
+			if branch == failing {
+				return false, failure
+			}
+			return true, nil
+		})
+		if !errors.Is(err, failure) {
+			t.Errorf("resolveUpdateBranch() with %s failing error = %v, want %v", failing, err, failure)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/changelog"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+// releaseNotes returns the release notes published between the old and new version of
+// an update as a collapsible Markdown section, or an empty string when the changelog is
+// disabled or the source repository of the image is unknown
+func releaseNotes(ctx context.Context, cfg *config.Config, update result.UpdateCandidate, maxLength int) string {
+	if !cfg.Changelog {
+		return ""
+	}
+
+	sourceURL, ok := changelog.ResolveSource(update.Repository, cfg.ChangelogSources)
+	if !ok {
+		logger.Debug("No source repository known for %s, skipping release notes", update.Repository)
+		return ""
+	}
+
+	source, err := changelog.ParseSource(sourceURL)
+	if err != nil {
+		logger.Warn("Skipping release notes of %s: %v", update.Repository, err)
+		return ""
+	}
+
+	releases, err := changelog.NewClient(cfg.GitHubToken).Between(ctx, source, update.OldTag, update.NewTag)
+	if err != nil {
+		logger.Warn("Could not fetch release notes of %s: %v", update.Repository, err)
+		return ""
+	}
+	if len(releases) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("\n<details>\n<summary>Release notes of %s (%d releases)</summary>\n\n%s\n</details>\n",
+		update.Repository, len(releases), changelog.Format(releases, maxLength))
+}
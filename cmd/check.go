@@ -2,23 +2,40 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"text/template"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/changelog"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/compose"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/docker"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitops"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/plan"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/policy"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/progress"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/state"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/terraform"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/trace"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/update"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/validation"
 )
 
 var (
@@ -26,17 +43,6 @@ var (
 	checkCfg *config.Config
 )
 
-// UpdateInfo represents information about an image update
-type UpdateInfo struct {
-	FilePath    string
-	ServiceName string
-	OldImage    string
-	NewImage    string
-	Repository  string
-	OldTag      string
-	NewTag      string
-}
-
 var checkCmd = &cobra.Command{
 	Use:   "check [file]",
 	Short: "Check docker-compose file for image updates",
@@ -46,26 +52,18 @@ The scan directory is specified using the IMG_UPGR_SCANDIR environment variable.
 
 Examples:
   img-upgr check            Check compose files using environment variables
-  img-upgr check --dry-run  Check for updates without creating merge requests`,
+  img-upgr check --dry-run  Check for updates without creating merge requests
+  img-upgr check --plan plan.json  Write found updates to a plan file for "img-upgr apply"`,
 	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		// Create a context that can be cancelled
-		ctx, cancel := context.WithCancel(context.Background())
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
 		defer cancel()
 
-		// Set up signal handling for graceful shutdown
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		go func() {
-			<-sigChan
-			logger.Info("Received interrupt signal, shutting down gracefully...")
-			cancel()
-		}()
-
 		// Run the check command with context
 		if err := runCheckCommand(ctx, args); err != nil {
 			logger.Error("Check command failed: %v", err)
-			os.Exit(1)
+			exit(1)
 		}
 	},
 }
@@ -73,7 +71,7 @@ Examples:
 // runCheckCommand is the main function for the check command
 func runCheckCommand(ctx context.Context, args []string) error {
 	// Initialize and validate configuration
-	if err := initializeAndValidate(); err != nil {
+	if err := initializeAndValidate(ctx, checkCfg); err != nil {
 		return fmt.Errorf("initialization failed: %w", err)
 	}
 
@@ -81,51 +79,133 @@ func runCheckCommand(ctx context.Context, args []string) error {
 	defer gitlab.CleanupRepository(checkCfg)
 
 	// Determine the files to scan
-	composeFiles, err := determineFilesToScan(args)
+	composeFiles, err := determineFilesToScan(checkCfg, args)
 	if err != nil {
 		return fmt.Errorf("failed to determine files to scan: %w", err)
 	}
 
-	// Create Docker client
-	dockerClient := docker.NewClient()
+	// Create registry client
+	registryClient, err := newRegistryClient(checkCfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure registries: %w", err)
+	}
+
+	// Load the state of the previous run if a store is configured
+	store, err := openStateStore(checkCfg)
+	if err != nil {
+		return fmt.Errorf("failed to open state store: %w", err)
+	}
+	var st *state.State
+	if store != nil {
+		if st, err = store.Load(ctx); err != nil {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
+	}
 
 	// Process files and collect updates
-	updates, err := processComposeFilesWithContext(ctx, composeFiles, dockerClient)
+	progressCtx, stopProgress := startProgress(ctx, checkCfg)
+	updates, fileErrors, err := processComposeFilesWithContext(progressCtx, checkCfg, st, composeFiles, registryClient)
+	stopProgress()
 	if err != nil {
 		return fmt.Errorf("error processing compose files: %w", err)
 	}
 
+	// Flag the images of series past their end of life
+	var notices []result.EndOfLifeNotice
+	if checkCfg.EndOfLife {
+		notices, updates = checkEndOfLife(ctx, checkCfg, composeFiles, updates, registryClient)
+	}
+
+	// Only suggest images whose signature verifies, if verification is configured
+	updates, verifyErrors := verifyUpdates(ctx, checkCfg, updates)
+	fileErrors = append(fileErrors, verifyErrors...)
+
+	// Report the results, including the files that failed
+	res := result.New(updates, fileErrors)
+	res.EndOfLife = notices
+	if err := printResult(checkCfg, res); err != nil {
+		return fmt.Errorf("failed to print results: %w", err)
+	}
+
+	if err := registryClient.SaveETagCache(); err != nil {
+		logger.Warn("Failed to save tag cache: %v", err)
+	}
+
+	// Track the images without a valid upgrade path in issues
+	if checkCfg.Issues && !checkCfg.DryRun && checkCfg.PlanFile == "" && checkCfg.GitLabClient != nil {
+		if err := reportIssues(ctx, checkCfg, res); err != nil {
+			logger.Warn("Failed to report problems in issues: %v", err)
+		}
+	}
+
 	// Handle found updates
-	return handleUpdates(ctx, updates)
+	proposed, err := handleUpdates(ctx, updates)
+
+	// Record what was seen for the next run once the merge requests are open
+	saveState(ctx, checkCfg, store, st, composeFiles, proposed)
+
+	// Delete the branches of merge requests merged or closed since, even after failures
+	if checkCfg.Cleanup && !checkCfg.DryRun && checkCfg.PlanFile == "" && checkCfg.GitLabClient != nil {
+		if cleanupErr := cleanupBranches(ctx, checkCfg); cleanupErr != nil {
+			logger.Warn("Failed to clean up branches: %v", cleanupErr)
+		}
+	}
+
+	return err
 }
 
-// initializeAndValidate initializes and validates the configuration
-func initializeAndValidate() error {
+// initializeAndValidate initializes and validates the configuration. The repository is
+// cleaned up when it was cloned but the configuration turns out invalid, as callers only
+// clean it up once initialized.
+func initializeAndValidate(ctx context.Context, cfg *config.Config) (err error) {
 	// Comprehensive validation of all configuration
 	logger.Debug("Validating configuration...")
+	defer func() {
+		if err != nil {
+			gitlab.CleanupRepository(cfg)
+		}
+	}()
+
+	if err := cfg.ResolveSecrets(ctx); err != nil {
+		return err
+	}
+	if err := cfg.UseProjectToken(); err != nil {
+		return err
+	}
 
 	// First validate GitLab configuration if we need to clone the repo
-	if checkCfg.GitLabRepo != "" {
-		if err := checkCfg.ValidateGitLab(); err != nil {
+	if cfg.GitLabRepo != "" {
+		if err := cfg.ValidateGitLab(); err != nil {
 			return fmt.Errorf("GitLab configuration validation failed: %w", err)
 		}
 
 		// Initialize GitLab client
-		gitlabClient, err := gitlab.NewClient(checkCfg)
+		gitlabClient, err := gitlab.NewClient(cfg)
 		if err != nil {
 			return fmt.Errorf("error initializing GitLab client: %w", err)
 		}
-		checkCfg.GitLabClient = gitlabClient
+		cfg.GitLabClient = gitlabClient
 
-		// Clone repository before validating scan directory
-		logger.Info("Cloning repository: %s", checkCfg.GitLabRepo)
-		if err := gitlab.CloneRepository(checkCfg); err != nil {
-			return fmt.Errorf("error cloning repository: %w", err)
+		// Fetch the repository before validating scan directory
+		if cfg.APIOnly {
+			if err := gitlab.MirrorRepository(ctx, cfg); err != nil {
+				return fmt.Errorf("error fetching repository: %w", err)
+			}
+		} else {
+			logger.Info("Cloning repository: %s", cfg.GitLabRepo)
+			if err := gitlab.CloneRepository(ctx, cfg); err != nil {
+				return fmt.Errorf("error cloning repository: %w", err)
+			}
 		}
 	}
 
+	// Fill the settings left unset from the repository configuration file
+	if err := applyRepoConfig(cfg); err != nil {
+		return err
+	}
+
 	// Now validate all configuration (after repository is cloned if needed)
-	if err := checkCfg.ValidateAll(); err != nil {
+	if err := validateConfig(cfg); err != nil {
 		return fmt.Errorf("configuration validation failed: %w", err)
 	}
 
@@ -133,29 +213,166 @@ func initializeAndValidate() error {
 	return nil
 }
 
+// applyRepoConfig applies the configuration file found at the root of the cloned
+// repository, or of the scan directory when working on local files
+func applyRepoConfig(cfg *config.Config) error {
+	root := cfg.TempDir
+	if root == "" {
+		root = cfg.ScanDir
+	}
+	if root == "" {
+		root = "."
+	}
+	if info, err := repofs.Stat(root); err == nil && !info.IsDir() {
+		root = filepath.Dir(root)
+	}
+
+	repoConfig, err := config.LoadRepoConfig(root)
+	if err != nil {
+		return err
+	}
+	repoConfig.ApplyTo(cfg)
+	return nil
+}
+
+// openStateStore returns the configured state store, or nil if none is configured
+func openStateStore(cfg *config.Config) (state.Store, error) {
+	if cfg.StateStore == "" {
+		return nil, nil
+	}
+
+	// Snippet stores are referenced as gitlab-snippet:<id>
+	if idStr, ok := strings.CutPrefix(cfg.StateStore, "gitlab-snippet:"); ok {
+		snippetID, err := strconv.Atoi(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snippet ID: %s", idStr)
+		}
+
+		gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+		if !ok {
+			return nil, fmt.Errorf("a snippet state store requires %s to be set", config.EnvGitLabRepo)
+		}
+
+		logger.Debug("Using GitLab snippet %d as state store", snippetID)
+		return gitlab.NewSnippetStore(gitlabClient, snippetID), nil
+	}
+
+	logger.Debug("Using %s as state store", cfg.StateStore)
+	return state.NewFileStore(cfg.StateStore), nil
+}
+
+// newRegistryClient creates a registry client routing images to the configured
+// registry adapters, falling back to Docker Hub
+func newRegistryClient(cfg *config.Config) (*registry.Resolver, error) {
+	// Air-gapped runs answer every repository from an exported catalog
+	if cfg.TagCatalog != "" {
+		catalog, err := registry.LoadCatalog(cfg.TagCatalog)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("Reading tags from catalog %s exported on %s", cfg.TagCatalog, catalog.ExportedAt.Format(time.RFC3339))
+		return catalog.Resolver(), nil
+	}
+
+	registryTypes, err := cfg.RegistryTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	mirrors, err := cfg.RegistryMirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := cfg.HostLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := registry.NewResolver(newDockerHubClient(cfg))
+	resolver.SetMirrors(registry.NewMirrors(mirrors))
+	resolver.SetHostLimits(limits)
+	if cfg.TagCache != "" {
+		resolver.SetETagCache(registry.LoadETagCache(cfg.TagCache))
+	}
+
+	// Cloud registries are detected from their host names
+	resolver.RegisterPattern(registry.ECRHostPattern, func(host string) (registry.Client, error) {
+		return registry.NewECRClient(host)
+	})
+	resolver.RegisterPattern(registry.GoogleHostPattern, func(host string) (registry.Client, error) {
+		return registry.NewGoogleClient(host)
+	})
+	// Other hosts are read through the OCI distribution API, anonymously unless the
+	// Docker CLI has credentials for them
+	resolver.RegisterPattern(registry.AnyHostPattern, func(host string) (registry.Client, error) {
+		return registry.NewOCIClient(host, registry.LoadDockerCredentials(host)), nil
+	})
+
+	for host, registryType := range registryTypes {
+		baseURL := "https://" + host
+		credentials := registry.LoadDockerCredentials(host)
+
+		logger.Debug("Using %s adapter for registry %s", registryType, host)
+		switch registryType {
+		case registry.TypeHarbor:
+			resolver.Register(host, registry.NewHarborClient(baseURL, credentials))
+		case registry.TypeArtifactory:
+			resolver.Register(host, registry.NewArtifactoryClient(baseURL, credentials))
+		}
+	}
+
+	return resolver, nil
+}
+
+// newDockerHubClient creates the Docker Hub client, authenticated with the Docker Hub
+// account when one is set and listing at most the configured number of tags
+func newDockerHubClient(cfg *config.Config) *docker.Client {
+	options := []docker.ClientOption{docker.WithMaxTags(cfg.MaxTags)}
+	if cfg.DockerHubUser != "" && cfg.DockerHubToken != "" {
+		options = append(options, docker.WithCredentials(cfg.DockerHubUser, cfg.DockerHubToken))
+	}
+	return docker.NewClient(options...)
+}
+
+// suggestedRepository returns the repository to write back to compose files,
+// rewritten through the registry mirrors when image rewriting is enabled
+func suggestedRepository(cfg *config.Config, repo string) string {
+	if !cfg.RewriteImages {
+		return repo
+	}
+
+	mirrors, err := cfg.RegistryMirrors()
+	if err != nil {
+		return repo
+	}
+
+	return registry.NewMirrors(mirrors).Rewrite(repo)
+}
+
 // determineFilesToScan determines which files to scan based on arguments and configuration
-func determineFilesToScan(args []string) ([]string, error) {
+func determineFilesToScan(cfg *config.Config, args []string) ([]string, error) {
 	// Determine the file or directory to scan
 	var scanPath string
 	if len(args) > 0 {
 		// User specified a path
 		scanPath = args[0]
-	} else if checkCfg.ScanDir != "" {
+	} else if cfg.ScanDir != "" {
 		// Use the scan directory from config
-		scanPath = checkCfg.ScanDir
+		scanPath = cfg.ScanDir
 	} else {
 		// Default to docker-compose.yml in repo root
 		scanPath = "docker-compose.yml"
 	}
 
 	// If path is not absolute and we have a temp dir, make it relative to temp dir
-	if !filepath.IsAbs(scanPath) && checkCfg.TempDir != "" {
-		scanPath = filepath.Join(checkCfg.TempDir, scanPath)
+	if !filepath.IsAbs(scanPath) && cfg.TempDir != "" {
+		scanPath = filepath.Join(cfg.TempDir, scanPath)
 		logger.Debug("Using path relative to cloned repo: %s", scanPath)
 	}
 
 	// Check if path exists
-	fileInfo, err := os.Stat(scanPath)
+	fileInfo, err := repofs.Stat(scanPath)
 	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("path does not exist: %s", scanPath)
 	} else if err != nil {
@@ -164,10 +381,12 @@ func determineFilesToScan(args []string) ([]string, error) {
 
 	// Handle directory or file
 	var composeFiles []string
+	root := filepath.Dir(scanPath)
 	if fileInfo.IsDir() {
+		root = scanPath
 		// It's a directory, use FindComposeFiles
-		checkCfg.ScanDir = scanPath
-		files, err := checkCfg.FindComposeFiles()
+		cfg.ScanDir = scanPath
+		files, err := cfg.FindComposeFiles()
 		if err != nil {
 			return nil, fmt.Errorf("error finding compose files: %w", err)
 		}
@@ -182,79 +401,378 @@ func determineFilesToScan(args []string) ([]string, error) {
 		return nil, fmt.Errorf("no compose files found in %s", scanPath)
 	}
 
+	// Follow include directives so images of included fragments are checked too, within
+	// the scanned directory and the same exclusions
+	composeFiles, err = compose.ExpandIncludes(composeFiles, root, func(path string) bool {
+		return cfg.IsExcluded(root, path)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error resolving compose includes: %w", err)
+	}
+
 	return composeFiles, nil
 }
 
-// processComposeFilesWithContext processes each compose file and returns updates
-func processComposeFilesWithContext(ctx context.Context, composeFiles []string, dockerClient *docker.Client) ([]UpdateInfo, error) {
-	var updates []UpdateInfo
-	var mu sync.Mutex // Mutex for thread-safe updates to the updates slice
+// startProgress draws the progress of the scan on stderr when it is a terminal and output
+// is not quiet, returning the context carrying it and the function erasing it
+func startProgress(ctx context.Context, cfg *config.Config) (context.Context, func()) {
+	if !cfg.Progress || IsQuiet() || !logger.IsTerminal(os.Stderr) {
+		return ctx, func() {}
+	}
+
+	bar := progress.New(os.Stderr, "Checking images")
+	logger.SetStatusLine(bar)
+	return progress.WithBar(ctx, bar), func() {
+		logger.SetStatusLine(nil)
+		bar.Stop()
+	}
+}
+
+// processComposeFilesWithContext processes the compose files concurrently and returns
+// the updates found along with the errors encountered for each file
+func processComposeFilesWithContext(ctx context.Context, cfg *config.Config, st *state.State, composeFiles []string, registryClient registry.Client) ([]result.UpdateCandidate, []result.FileError, error) {
+	// Results are stored per file so output keeps the order of the files
+	fileUpdates := make([][]result.UpdateCandidate, len(composeFiles))
+	fileErrors := make([][]result.FileError, len(composeFiles))
 
-	// Process each compose file
-	for _, composeFilePath := range composeFiles {
+	concurrency := cfg.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	semaphore := make(chan struct{}, concurrency)
+
+	bar := progress.FromContext(ctx)
+	bar.SetTotal(len(composeFiles))
+
+	// GitOps manifests are indexed first so references can be resolved across files
+	var manifests *gitops.Index
+	if cfg.GitOps {
+		manifests = loadManifests(composeFiles)
+	}
+	charts := gitops.NewChartClient(registryClient)
+
+	var wg sync.WaitGroup
+	for i, composeFilePath := range composeFiles {
 		// Check for context cancellation
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
+			wg.Wait()
+			return nil, nil, ctx.Err()
+		case semaphore <- struct{}{}:
 		}
 
-		logger.Info("Processing compose file: %s", composeFilePath)
+		wg.Add(1)
+		go func(i int, composeFilePath string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			defer bar.FileDone()
+
+			ctx, span := trace.Start(ctx, "check file", trace.String("img_upgr.file", repoRelativePath(cfg, composeFilePath)))
+			defer func() {
+				span.SetAttributes(trace.Int("img_upgr.updates", len(fileUpdates[i])), trace.Int("img_upgr.errors", len(fileErrors[i])))
+				span.End(nil)
+			}()
+
+			if manifest, ok := manifests.Manifest(composeFilePath); ok {
+				fileUpdates[i], fileErrors[i] = checkManifestFile(ctx, cfg, st, manifest, manifests, charts, registryClient)
+				return
+			}
+			if cfg.Terraform && strings.HasSuffix(composeFilePath, config.TerraformFileExtension) {
+				fileUpdates[i], fileErrors[i] = checkTerraformFile(ctx, cfg, st, composeFilePath, registryClient)
+				return
+			}
+			if cfg.GitOps && !cfg.IsComposeFile(composeFilePath) {
+				logger.Debug("Skipping %s: neither a compose file nor a GitOps manifest", composeFilePath)
+				return
+			}
+			fileUpdates[i], fileErrors[i] = checkComposeFile(ctx, cfg, st, composeFilePath, registryClient)
+		}(i, composeFilePath)
+	}
+	wg.Wait()
 
-		// Parse compose file
-		composeFile, err := compose.ParseComposeFile(composeFilePath)
-		if err != nil {
-			logger.Error("Error parsing compose file %s: %v", composeFilePath, err)
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var updates []result.UpdateCandidate
+	var errors []result.FileError
+	for i := range composeFiles {
+		updates = append(updates, fileUpdates[i]...)
+		errors = append(errors, fileErrors[i]...)
+	}
+
+	return updates, errors, nil
+}
+
+// checkComposeFile parses a single compose file and checks its images
+func checkComposeFile(ctx context.Context, cfg *config.Config, st *state.State, composeFilePath string, registryClient registry.Client) ([]result.UpdateCandidate, []result.FileError) {
+	logger.Info("Processing compose file: %s", composeFilePath)
+
+	// Parse compose file
+	composeFile, err := compose.ParseComposeFile(composeFilePath)
+	if err != nil {
+		logger.Error("Error parsing compose file %s: %v", composeFilePath, err)
+		return nil, []result.FileError{{FilePath: composeFilePath, Error: err.Error()}}
+	}
+
+	// Check each image
+	images := composeFile.GetImages()
+	if len(images) == 0 {
+		logger.Info("No images found in compose file %s", composeFilePath)
+		return nil, nil
+	}
+
+	PrintInfo("Found %d services with images in %s", len(images), filepath.Base(composeFilePath))
+
+	// Process each image
+	updates, errors, err := processImagesInFile(ctx, cfg, st, composeFilePath, images, composeFile.GetConstraints(), registryClient)
+	if err != nil {
+		logger.Error("Error processing images in %s: %v", composeFilePath, err)
+		errors = append(errors, result.FileError{FilePath: composeFilePath, Error: err.Error()})
+	}
+	setLines(updates, composeFile.GetImageLines())
+
+	return updates, errors
+}
+
+// loadManifests parses the files holding Kubernetes resources into a GitOps index
+func loadManifests(files []string) *gitops.Index {
+	index := gitops.NewIndex()
+	for _, file := range files {
+		manifest, err := gitops.ParseFile(file)
+		if err != nil || !manifest.IsManifest() {
 			continue
 		}
+		logger.Debug("Found GitOps manifest: %s", file)
+		index.Add(manifest)
+	}
+	return index
+}
+
+// checkManifestFile checks the chart versions and policy-marked images of a GitOps manifest
+func checkManifestFile(ctx context.Context, cfg *config.Config, st *state.State, manifest *gitops.Manifest, index *gitops.Index, charts *gitops.ChartClient, registryClient registry.Client) ([]result.UpdateCandidate, []result.FileError) {
+	filePath := manifest.Path
+	if len(manifest.References) == 0 {
+		logger.Debug("No versioned references found in manifest %s", filePath)
+		return nil, nil
+	}
 
-		// Check each image
-		images := composeFile.GetImages()
-		if len(images) == 0 {
-			logger.Info("No images found in compose file %s", composeFilePath)
+	logger.Info("Processing GitOps manifest: %s", filePath)
+
+	var updates []result.UpdateCandidate
+	var errors []result.FileError
+	images := make(map[string]string)
+	kinds := make(map[string]string)
+	lines := make(map[string]int)
+
+	for _, ref := range manifest.References {
+		if ref.Kind == gitops.KindChart {
+			chartUpdate, err := checkChartReference(ctx, index, charts, filePath, ref)
+			if err != nil {
+				logger.Error("  Error checking %s: %v", ref.ID, err)
+				errors = append(errors, result.FileError{FilePath: filePath, ServiceName: ref.ID, Error: err.Error()})
+			} else if chartUpdate != nil {
+				updates = append(updates, *chartUpdate)
+			}
 			continue
 		}
 
-		PrintInfo("Found %d services with images in %s", len(images), filepath.Base(composeFilePath))
-
-		// Process each image
-		fileUpdates, err := processImagesInFile(ctx, composeFilePath, images, dockerClient)
+		image, err := index.Image(ref)
 		if err != nil {
-			logger.Error("Error processing images in %s: %v", composeFilePath, err)
+			logger.Error("  Error resolving %s: %v", ref.ID, err)
+			errors = append(errors, result.FileError{FilePath: filePath, ServiceName: ref.ID, Error: err.Error()})
 			continue
 		}
+		images[ref.ID] = image
+		kinds[ref.ID] = ref.Kind
+		lines[ref.ID] = ref.Line()
+	}
+
+	if len(images) == 0 {
+		return updates, errors
+	}
+
+	imageUpdates, imageErrors, err := processImagesInFile(ctx, cfg, st, filePath, images, nil, registryClient)
+	if err != nil {
+		errors = append(errors, result.FileError{FilePath: filePath, Error: err.Error()})
+	}
+	errors = append(errors, imageErrors...)
+
+	for _, imageUpdate := range imageUpdates {
+		imageUpdate.Kind = kinds[imageUpdate.ServiceName]
+		imageUpdate.Line = lines[imageUpdate.ServiceName]
+		// Tag-only fields cannot hold a digest
+		if imageUpdate.Kind == gitops.KindImageTag && strings.Contains(imageUpdate.NewTag, "@") {
+			logger.Debug("  Skipping digest update of tag field %s", imageUpdate.ServiceName)
+			continue
+		}
+		updates = append(updates, imageUpdate)
+	}
+
+	return updates, errors
+}
+
+// checkChartReference checks a Helm chart version against the versions published in its repository
+func checkChartReference(ctx context.Context, index *gitops.Index, charts *gitops.ChartClient, filePath string, ref gitops.Reference) (*result.UpdateCandidate, error) {
+	PrintInfo("Checking chart for %s: %s %s", ref.ID, ref.Chart, ref.Value)
+
+	repoURL, err := index.ChartRepository(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := charts.FetchVersions(ctx, repoURL, ref.Chart)
+	if err != nil {
+		return nil, err
+	}
 
-		// Add file updates to overall updates
-		mu.Lock()
-		updates = append(updates, fileUpdates...)
-		mu.Unlock()
+	latest, hasUpdate, err := update.CheckVersion(ref.Value, versions)
+	if err != nil {
+		// Version ranges such as 6.x are resolved by the GitOps controller itself
+		PrintInfo("  Skipping %s: %v", ref.ID, err)
+		return nil, nil
+	}
+	if latest == nil || !hasUpdate {
+		PrintInfo("  ✓ Chart is up to date")
+		return nil, nil
 	}
 
-	return updates, nil
+	green := color.New(color.FgGreen).SprintFunc()
+	PrintInfo("  %s Update available: %s → %s", green("✓"), ref.Value, latest.FullTag)
+
+	return &result.UpdateCandidate{
+		FilePath:    filePath,
+		ServiceName: ref.ID,
+		OldImage:    ref.Chart + ":" + ref.Value,
+		NewImage:    ref.Chart + ":" + latest.FullTag,
+		Repository:  ref.Chart,
+		OldTag:      ref.Value,
+		NewTag:      latest.FullTag,
+		Kind:        gitops.KindChart,
+		Line:        ref.Line(),
+	}, nil
 }
 
-// processImagesInFile processes all images in a single compose file
-func processImagesInFile(ctx context.Context, filePath string, images map[string]string, dockerClient *docker.Client) ([]UpdateInfo, error) {
-	var updates []UpdateInfo
+// checkTerraformFile checks the images of docker and kubernetes provider resources in a Terraform file
+func checkTerraformFile(ctx context.Context, cfg *config.Config, st *state.State, filePath string, registryClient registry.Client) ([]result.UpdateCandidate, []result.FileError) {
+	references, err := terraform.ParseFile(filePath)
+	if err != nil {
+		logger.Error("Error parsing Terraform file %s: %v", filePath, err)
+		return nil, []result.FileError{{FilePath: filePath, Error: err.Error()}}
+	}
+	if len(references) == 0 {
+		logger.Debug("No images found in Terraform file %s", filePath)
+		return nil, nil
+	}
+
+	logger.Info("Processing Terraform file: %s", filePath)
+
+	images := make(map[string]string)
+	lines := make(map[string]int)
+	for _, ref := range references {
+		images[ref.ID] = ref.Image
+		lines[ref.ID] = ref.Line
+	}
+
+	updates, errors, err := processImagesInFile(ctx, cfg, st, filePath, images, nil, registryClient)
+	if err != nil {
+		errors = append(errors, result.FileError{FilePath: filePath, Error: err.Error()})
+	}
+	for i := range updates {
+		updates[i].Kind = terraform.Kind
+	}
+	setLines(updates, lines)
+
+	return updates, errors
+}
+
+// setLines sets the line of each update from the lines of the images of its file, by service
+func setLines(updates []result.UpdateCandidate, lines map[string]int) {
+	for i := range updates {
+		updates[i].Line = lines[updates[i].ServiceName]
+	}
+}
+
+// pruneUsages drops the recorded usages of the repository that its scan did not find
+// again, in files it scanned or that were deleted, so registry webhooks do not target
+// services that moved or were removed
+func pruneUsages(cfg *config.Config, st *state.State, composeFiles []string) {
+	if cfg.GitLabRepo == "" {
+		return
+	}
+
+	scanned := make(map[string]bool, len(composeFiles))
+	for _, file := range composeFiles {
+		scanned[repoRelativePath(cfg, file)] = true
+	}
+	st.PruneUsages(cfg.GitLabRepo, func(usage state.Usage) bool {
+		if scanned[usage.File] {
+			return true
+		}
+		_, err := repofs.Stat(filepath.Join(cfg.TempDir, filepath.FromSlash(usage.File)))
+		return cfg.TempDir != "" && os.IsNotExist(err)
+	})
+}
+
+// processImagesInFile processes all images in a single compose file, constraints holding
+// the version ranges set in the file by service
+func processImagesInFile(ctx context.Context, cfg *config.Config, st *state.State, filePath string, images map[string]string, constraints map[string]string, registryClient registry.Client) ([]result.UpdateCandidate, []result.FileError, error) {
+	var updates []result.UpdateCandidate
+	var errors []result.FileError
+	bar := progress.FromContext(ctx)
 
 	for serviceName, imageName := range images {
 		// Check for context cancellation
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return nil, nil, ctx.Err()
 		default:
 		}
 
 		PrintInfo("Checking image for service %s: %s", serviceName, imageName)
 
-		info, err := update.CheckImage(imageName, dockerClient)
+		// Remember where the image is used so registry webhooks can target this service
+		if cfg.GitLabRepo != "" {
+			st.RecordUsage(stateKey(imageName), state.Usage{
+				Repository: cfg.GitLabRepo,
+				File:       repoRelativePath(cfg, filePath),
+				Service:    serviceName,
+			})
+		}
+
+		opts, err := updateOptions(cfg, constraints, serviceName, imageName)
+		if err != nil {
+			logger.Error("  Error checking %s: %v", serviceName, err)
+			errors = append(errors, result.FileError{FilePath: filePath, ServiceName: serviceName, Error: err.Error()})
+			continue
+		}
+
+		// Images pinned to a digest are compared by their tag when pinning digests
+		checkedImage := imageName
+		if cfg.PinDigest {
+			checkedImage = update.WithoutDigest(imageName)
+		}
+
+		info, err := update.CheckImageWithContext(ctx, checkedImage, registryClient, opts)
+		bar.ImageDone()
 		if err != nil {
 			if strings.Contains(err.Error(), "no tag found") ||
 				strings.Contains(err.Error(), "tag not semver-like") {
+				if cfg.TrackDigests || cfg.PinDigest {
+					digestUpdate, err := checkDigestUpdate(ctx, st, filePath, serviceName, imageName, registryClient)
+					if err != nil {
+						logger.Error("  Error checking digest of %s: %v", serviceName, err)
+						errors = append(errors, result.FileError{FilePath: filePath, ServiceName: serviceName, Error: err.Error()})
+					} else if digestUpdate != nil {
+						updates = append(updates, *digestUpdate)
+					}
+					continue
+				}
 				PrintInfo("  Skipping %s: %v", serviceName, err)
 				continue
 			}
 			logger.Error("  Error checking %s: %v", serviceName, err)
+			errors = append(errors, result.FileError{FilePath: filePath, ServiceName: serviceName, Error: err.Error()})
 			continue
 		}
 
@@ -266,151 +784,931 @@ func processImagesInFile(ctx context.Context, filePath string, images map[string
 			continue
 		}
 
+		// Compare the latest tag with the previous run. Current images are recorded now,
+		// updates once their merge request is open.
+		previous, seen := st.Get(stateKey(imageName))
+		if !info.HasUpdate {
+			st.RecordLatestTag(stateKey(imageName), info.LatestTag)
+		}
+
+		if info.HasUpdate && cfg.NewOnly && seen && previous.LatestTag == info.LatestTag {
+			PrintInfo("  Skipping %s: update to %s was already available in the previous run", serviceName, info.LatestTag)
+			continue
+		}
+
 		if info.HasUpdate {
+			newImage := fmt.Sprintf("%s:%s", suggestedRepository(cfg, info.Repository), info.LatestTag)
+			suggested := fmt.Sprintf("%s:%s", info.Repository, info.LatestTag)
+
+			// Pin the new tag to the digest of its manifest list, covering every platform
+			if cfg.PinDigest {
+				digest, err := registry.FetchTagDigest(ctx, registryClient, info.Repository, info.LatestTag)
+				if err == nil && digest == "" {
+					err = fmt.Errorf("registry did not report a digest for %s", suggested)
+				}
+				if err != nil {
+					logger.Error("  Error pinning %s to a digest: %v", serviceName, err)
+					errors = append(errors, result.FileError{FilePath: filePath, ServiceName: serviceName, Error: err.Error()})
+					continue
+				}
+				newImage += "@" + digest
+				suggested += "@" + digest
+			}
+
 			// Add to updates list for merge request creation
-			updates = append(updates, UpdateInfo{
+			updates = append(updates, result.UpdateCandidate{
 				FilePath:    filePath,
 				ServiceName: serviceName,
 				OldImage:    imageName,
-				NewImage:    fmt.Sprintf("%s:%s", info.Repository, info.LatestTag),
+				NewImage:    newImage,
 				Repository:  info.Repository,
 				OldTag:      info.Tag,
 				NewTag:      info.LatestTag,
+				Major:       info.IsMajor,
+				Downgrade:   info.IsDowngrade,
 			})
 			green := color.New(color.FgGreen).SprintFunc()
-			PrintInfo("  %s Update available: %s → %s", green("✓"), info.Tag, info.LatestTag)
-			PrintInfo("     Suggested image: %s:%s", info.Repository, info.LatestTag)
+			if info.IsDowngrade {
+				yellow := color.New(color.FgYellow).SprintFunc()
+				PrintInfo("  %s Downgrade proposed: %s is newer than the latest allowed %s", yellow("↓"), info.Tag, info.LatestTag)
+			} else if info.IsMajor {
+				yellow := color.New(color.FgYellow).SprintFunc()
+				PrintInfo("  %s Major update available: %s → %s", yellow("!"), info.Tag, info.LatestTag)
+			} else {
+				PrintInfo("  %s Update available: %s → %s", green("✓"), info.Tag, info.LatestTag)
+			}
+			PrintInfo("     Suggested image: %s", suggested)
+		} else if cfg.PinDigest && checkedImage != imageName {
+			// The tag is current, follow the content published behind it
+			digestUpdate, err := checkDigestUpdate(ctx, st, filePath, serviceName, imageName, registryClient)
+			if err != nil {
+				logger.Error("  Error checking digest of %s: %v", serviceName, err)
+				errors = append(errors, result.FileError{FilePath: filePath, ServiceName: serviceName, Error: err.Error()})
+			} else if digestUpdate != nil {
+				updates = append(updates, *digestUpdate)
+			}
+		} else if info.IsDowngrade {
+			PrintInfo("  ✓ Keeping %s, newer than the latest allowed %s (use --allow-downgrade to roll it back)", info.Tag, info.LatestTag)
 		} else {
 			PrintInfo("  ✓ Image is up to date")
 		}
 	}
 
-	return updates, nil
+	return updates, errors, nil
 }
 
-// handleUpdates processes any updates that were found
-func handleUpdates(ctx context.Context, updates []UpdateInfo) error {
-	// Process updates if any were found
-	if len(updates) > 0 {
-		logger.Info("Found %d updates across all files", len(updates))
+// updateOptions returns the options restricting the updates of a service: its version
+// range, the major version opt-in and the required platform
+func updateOptions(cfg *config.Config, constraints map[string]string, serviceName, imageName string) (update.Options, error) {
+	constraint, err := versionConstraint(cfg, constraints, serviceName, imageName)
+	if err != nil {
+		return update.Options{}, err
+	}
 
-		// Create merge requests for updates if not in dry run mode
-		if !checkCfg.DryRun {
-			if err := createMergeRequestsForUpdates(ctx, checkCfg, updates); err != nil {
-				return fmt.Errorf("failed to create merge requests: %w", err)
-			}
-		} else {
-			logger.Info("Dry run mode: skipping merge request creation")
+	platform, err := cfg.RequiredPlatform()
+	if err != nil {
+		return update.Options{}, err
+	}
+
+	repo := update.ImageRepository(imageName)
+	excludeTags, err := cfg.ExcludedTags(repo)
+	if err != nil {
+		return update.Options{}, err
+	}
+
+	lts, err := cfg.LTSRule(repo)
+	if err != nil {
+		return update.Options{}, err
+	}
+
+	return update.Options{
+		Constraint:     constraint,
+		AllowMajor:     cfg.AllowMajor,
+		Platform:       platform,
+		AllowDowngrade: cfg.AllowDowngrade,
+		ExcludeTags:    excludeTags,
+		Channel:        cfg.Channel(repo),
+		LTS:            lts,
+		EarlyExit:      cfg.TagEarlyExit,
+		Filter:         cfg.TagFilter,
+	}, nil
+}
+
+// versionConstraint returns the version range of a service: the range set in its file,
+// or the range of the service or of its image repository in the repository configuration
+func versionConstraint(cfg *config.Config, constraints map[string]string, serviceName, imageName string) (*semver.Constraints, error) {
+	value, ok := constraints[serviceName]
+	if !ok {
+		value, ok = cfg.Constraints[serviceName]
+	}
+	if !ok {
+		value, ok = repositoryConstraint(cfg.Constraints, update.ImageRepository(imageName))
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	constraint, err := semver.NewConstraint(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint %q: %w", value, err)
+	}
+	PrintVerbose("  Keeping %s within %s", serviceName, value)
+	return constraint, nil
+}
+
+// repositoryConstraint returns the version range set for an image repository, however
+// the repository is written in the configuration
+func repositoryConstraint(constraints map[string]string, repo string) (string, bool) {
+	for name, value := range constraints {
+		if registry.SameRepository(name, repo) {
+			return value, true
 		}
-	} else {
+	}
+	return "", false
+}
+
+// checkDigestUpdate checks an image on a mutable tag for content changes and returns
+// the update pinning it to the new digest, if any
+func checkDigestUpdate(ctx context.Context, st *state.State, filePath, serviceName, imageName string, registryClient registry.Client) (*result.UpdateCandidate, error) {
+	info, err := update.CheckDigestWithContext(ctx, imageName, registryClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remember the published digest and compare it with the previous run
+	previous, seen := st.Get(digestStateKey(imageName))
+	st.RecordDigest(digestStateKey(imageName), info.LatestDigest)
+
+	// Without a pinned digest there is nothing to rewrite, only report the change
+	if info.Digest == "" {
+		if seen && previous.Digest != "" && previous.Digest != info.LatestDigest {
+			PrintInfo("  Image content changed for %s:%s since the previous run: %s → %s", info.Repository, info.Tag,
+				update.ShortDigest(previous.Digest), update.ShortDigest(info.LatestDigest))
+			return nil, nil
+		}
+		PrintInfo("  Skipping %s: %s:%s is not pinned to a digest", serviceName, info.Repository, info.Tag)
+		return nil, nil
+	}
+
+	if !info.HasUpdate {
+		PrintInfo("  ✓ Image content is unchanged")
+		return nil, nil
+	}
+
+	green := color.New(color.FgGreen).SprintFunc()
+	PrintInfo("  %s Image content changed for tag %s: %s → %s", green("✓"), info.Tag,
+		update.ShortDigest(info.Digest), update.ShortDigest(info.LatestDigest))
+
+	return &result.UpdateCandidate{
+		FilePath:    filePath,
+		ServiceName: serviceName,
+		OldImage:    imageName,
+		NewImage:    info.Reference(info.LatestDigest),
+		Repository:  info.Repository,
+		OldTag:      info.Tag + "@" + update.ShortDigest(info.Digest),
+		NewTag:      info.Tag + "@" + update.ShortDigest(info.LatestDigest),
+	}, nil
+}
+
+// lockHolder names this run on the lock branch
+func lockHolder() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+	return fmt.Sprintf("img-upgr on %s (pid %d)", host, os.Getpid())
+}
+
+// validateConfig validates the configuration and its output format, which needs a reporter
+// registered in the result package
+func validateConfig(cfg *config.Config) error {
+	if err := cfg.ValidateAll(); err != nil {
+		return err
+	}
+
+	if !validation.IsValidOutputFormat(cfg.OutputFormat, result.Formats()) {
+		validationErrors := &validation.ValidationErrors{}
+		validationErrors.Add("OutputFormat", fmt.Sprintf("invalid output format: %s (valid formats: %s)",
+			cfg.OutputFormat, strings.Join(result.Formats(), ", ")))
+		return validationErrors
+	}
+	return nil
+}
+
+// printResult prints the result with the reporter of the configured output format
+func printResult(cfg *config.Config, res *result.ScanResult) error {
+	reporter, err := result.ReporterFor(cfg.OutputFormat)
+	if err != nil {
+		return err
+	}
+
+	// Show paths relative to the repository or the scan directory
+	res.Root = cfg.GetScanPath()
+	if cfg.TempDir != "" && res.Root == "" {
+		res.Root = cfg.TempDir
+	}
+	if err := reporter.Report(os.Stdout, res); err != nil {
+		return err
+	}
+
+	return writeReportFiles(cfg, res)
+}
+
+// writeReportFiles writes the result to the configured report files, the JSON report and
+// the dotenv counts that GitLab CI jobs keep as artifacts
+func writeReportFiles(cfg *config.Config, res *result.ScanResult) error {
+	for format, path := range map[string]string{"json": cfg.ReportFile, "dotenv": cfg.DotenvFile} {
+		if path == "" {
+			continue
+		}
+		if err := result.WriteFile(path, format, res); err != nil {
+			return err
+		}
+		logger.Debug("Wrote %s report to %s", format, path)
+	}
+	return nil
+}
+
+// handleUpdates processes any updates that were found and returns those proposed by an
+// open merge request
+func handleUpdates(ctx context.Context, updates []result.UpdateCandidate) ([]result.UpdateCandidate, error) {
+	if len(updates) == 0 {
 		logger.Info("No updates found across all files")
+	} else {
+		logger.Info("Found %d updates across all files", len(updates))
+	}
+
+	// Write the plan file instead of creating merge requests if requested, even an empty
+	// one so that apply does not pick up the plan of an earlier run
+	if checkCfg.PlanFile != "" {
+		return nil, writePlan(checkCfg, updates)
+	}
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	// Only simulate the merge requests in dry run mode
+	if checkCfg.DryRun {
+		if err := simulateMergeRequests(ctx, checkCfg, updates); err != nil {
+			return nil, fmt.Errorf("dry run failed: %w", err)
+		}
+		return nil, nil
+	}
+
+	updates = filterDeclinedUpdates(ctx, checkCfg, updates)
+	proposed, err := createMergeRequestsForUpdates(ctx, checkCfg, updates)
+	if err != nil {
+		return proposed, fmt.Errorf("failed to create merge requests: %w", err)
 	}
+	return proposed, nil
+}
+
+// saveState records the updates proposed by merge requests and saves the state for the
+// next run. Nothing is saved in dry run and plan modes, so updates that were only simulated
+// or planned, like those whose merge request failed, are proposed again with --new-only.
+func saveState(ctx context.Context, cfg *config.Config, store state.Store, st *state.State, composeFiles []string, proposed []result.UpdateCandidate) {
+	if store == nil || cfg.DryRun || cfg.PlanFile != "" {
+		return
+	}
+
+	recordProposed(st, proposed)
+	pruneUsages(cfg, st, composeFiles)
+	if err := store.Save(context.WithoutCancel(ctx), st); err != nil {
+		logger.Warn("Failed to save state: %v", err)
+	}
+}
+
+// recordProposed records the new tags of the updates proposed by merge requests as the
+// latest tags seen, so --new-only skips them until a newer tag is published
+func recordProposed(st *state.State, proposed []result.UpdateCandidate) {
+	for _, candidate := range proposed {
+		// Digests are followed per tag and charts are not recorded
+		if candidate.Kind == gitops.KindChart || strings.Contains(candidate.NewTag, "@") {
+			continue
+		}
+		st.RecordLatestTag(stateKey(candidate.OldImage), candidate.NewTag)
+	}
+}
 
+// stateKey returns the state entry of an image, its canonical repository so that nginx
+// and docker.io/library/nginx share what was seen of them
+func stateKey(image string) string {
+	return reference.Normalize(update.ImageRepository(image))
+}
+
+// digestStateKey returns the state entry of the digest published behind the tag of an image
+func digestStateKey(image string) string {
+	return stateKey(image) + ":" + update.ImageTag(image)
+}
+
+// writePlan saves the found updates to the configured plan file
+func writePlan(cfg *config.Config, updates []result.UpdateCandidate) error {
+	updatePlan := plan.New(cfg.GitLabRepo)
+	for _, update := range updates {
+		updatePlan.Updates = append(updatePlan.Updates, plan.Update{
+			File:         repoRelativePath(cfg, update.FilePath),
+			ServiceName:  update.ServiceName,
+			OldImage:     update.OldImage,
+			NewImage:     update.NewImage,
+			Repository:   update.Repository,
+			OldTag:       update.OldTag,
+			NewTag:       update.NewTag,
+			Kind:         update.Kind,
+			Major:        update.Major,
+			Downgrade:    update.Downgrade,
+			Verification: update.Verification,
+		})
+	}
+
+	if err := updatePlan.Write(cfg.PlanFile); err != nil {
+		return err
+	}
+
+	logger.Info("Wrote %d planned updates to %s", len(updatePlan.Updates), cfg.PlanFile)
 	return nil
 }
 
-// createMergeRequestsWithContext creates merge requests for the found updates
-func createMergeRequestsForUpdates(ctx context.Context, cfg *config.Config, updates []UpdateInfo) error {
+// repoRelativePath returns a slash-separated path relative to the cloned repository
+// root, or the path with slash separators when no repository was cloned
+func repoRelativePath(cfg *config.Config, path string) string {
+	if cfg.TempDir == "" {
+		return filepath.ToSlash(path)
+	}
+
+	relPath, err := filepath.Rel(cfg.TempDir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+
+	return filepath.ToSlash(relPath)
+}
+
+// filterDeclinedUpdates drops updates whose merge request was previously closed without being merged
+func filterDeclinedUpdates(ctx context.Context, cfg *config.Config, updates []result.UpdateCandidate) []result.UpdateCandidate {
+	if cfg.ReopenDeclined {
+		return updates
+	}
+
+	gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+	if !ok {
+		logger.Warn("GitLab client not initialized, declined updates will not be skipped")
+		return updates
+	}
+
+	closed, err := gitlabClient.ListMergeRequestsWithContext(ctx, "closed", gitlab.BranchPrefix)
+	if err != nil {
+		logger.Warn("Could not list closed merge requests, declined updates will not be skipped: %v", err)
+		return updates
+	}
+
+	// Collect the updates proposed by closed merge requests
+	declined := make(map[string]bool)
+	for _, mr := range closed {
+		for _, marker := range gitlab.ParseUpdateMarkers(mr.Description) {
+			declined[marker.Key()] = true
+		}
+	}
+
+	var remaining []result.UpdateCandidate
+	for _, update := range updates {
+		if declined[updateMarker(cfg, update).Key()] {
+			logger.Info("Skipping %s: update %s → %s was previously declined (use --reopen-declined to propose it again)",
+				update.ServiceName, update.OldTag, update.NewTag)
+			continue
+		}
+		remaining = append(remaining, update)
+	}
+
+	return remaining
+}
+
+// updateMarker builds the merge request marker identifying an update
+func updateMarker(cfg *config.Config, update result.UpdateCandidate) gitlab.UpdateMarker {
+	return gitlab.UpdateMarker{
+		File:       cfg.GetRelativePath(update.FilePath),
+		Service:    update.ServiceName,
+		Repository: update.Repository,
+		OldTag:     update.OldTag,
+		NewTag:     update.NewTag,
+		Major:      update.Major,
+	}
+}
+
+// createMergeRequestsForUpdates creates merge requests for the found updates, returning
+// the updates proposed by an open merge request and an error listing the ones that still
+// failed after their retries unless best effort
+func createMergeRequestsForUpdates(ctx context.Context, cfg *config.Config, updates []result.UpdateCandidate) ([]result.UpdateCandidate, error) {
+	// Read the repository configuration for per-path target branches
+	repoConfig, err := config.LoadRepoConfig(cfg.TempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Updates are still reported during a freeze, only merge requests are held back
+	if mergeRequestsFrozen(cfg, repoConfig, len(updates)) {
+		return nil, nil
+	}
+
+	// Tags deleted since they were listed would make merge requests that cannot deploy
+	updates = checkPullable(cfg, updates)
+
+	gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+	if !ok {
+		return nil, fmt.Errorf("invalid GitLab client type")
+	}
+	warnMergeSettings(ctx, gitlabClient, cfg)
+
+	// Replicas and overlapping runs would open the same merge requests
+	if cfg.Lock {
+		lock, err := gitlabClient.AcquireLockWithContext(ctx, lockHolder(), cfg.LockTTL)
+		var locked *gitlab.LockedError
+		if errors.As(err, &locked) {
+			logger.Warn("Skipping merge requests, %v", err)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock the repository: %w", err)
+		}
+		defer func() {
+			if err := lock.Release(context.WithoutCancel(ctx)); err != nil {
+				logger.Warn("Failed to release the lock: %v", err)
+			}
+		}()
+
+		// Branches are no longer pushed once another run took the lock over
+		ctx = lock.Context()
+	}
+
+	// Updates applied by hand since the scan would only make empty commits
+	updates = skipAppliedUpdates(ctx, cfg, gitlabClient, repoConfig, updates)
+
+	// Monorepos get one merge request per directory, or per image used across files,
+	// instead of one per update
+	if cfg.GroupBy != "none" {
+		return createGroupedMergeRequests(ctx, cfg, repoConfig, updates)
+	}
+
+	// Look up merge requests left open by previous runs
+	openMergeRequests, openCount, err := listOpenMergeRequests(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	budget := newMergeRequestBudget(cfg, openCount)
+	defer budget.report()
+
+	// New merge requests are given to the owners of the files they update
+	owners, err := newOwnerAssignment(cfg, repoConfig, openCount)
+	if err != nil {
+		return nil, err
+	}
+
+	// Failures are retried, then reported together once every update was tried
+	var failures mergeRequestFailures
+	var proposed []result.UpdateCandidate
+
 	// Process each image update individually
 	for _, update := range updates {
 		// Check for context cancellation
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return proposed, context.Cause(ctx)
 		default:
 		}
 
-		// Create a unique branch name for each image update
-		timestamp := time.Now().Format("20060102-150405")
-		serviceSanitized := strings.ReplaceAll(update.ServiceName, "/", "-")
-		branchName := fmt.Sprintf("img-upgr/%s-%s", serviceSanitized, timestamp)
+		name := fmt.Sprintf("%s (%s → %s)", update.ServiceName, update.OldTag, update.NewTag)
 
-		// Get default branch from repository
-		defaultBranch, err := gitlab.GetDefaultBranch(cfg)
-		if err != nil {
-			logger.Error("Error getting default branch: %v", err)
+		// Refresh an existing merge request for the same image instead of opening another one
+		if mr, ok := openMergeRequests[updateMarker(cfg, update).ImageKey()]; ok {
+			existing, _ := gitlab.ParseUpdateMarker(mr.Description)
+			if existing.NewTag == update.NewTag {
+				logger.Info("Merge request !%d already proposes %s → %s for %s", mr.IID, update.OldTag, update.NewTag, update.ServiceName)
+				proposed = append(proposed, update)
+				continue
+			}
+
+			logger.Info("Refreshing merge request !%d for %s: %s → %s", mr.IID, update.ServiceName, existing.NewTag, update.NewTag)
+			if err := retryMergeRequest(ctx, cfg, name, func(ctx context.Context) error {
+				return refreshMergeRequest(ctx, cfg, mr, update)
+			}); err != nil {
+				failures.add(name, fmt.Errorf("refreshing merge request !%d: %w", mr.IID, err))
+				continue
+			}
+
+			logger.Info("Refreshed merge request successfully for %s", update.ServiceName)
+			proposed = append(proposed, update)
 			continue
 		}
 
-		// Create branch in local repository
-		logger.Info("Creating branch %s for updating %s from default branch %s", branchName, update.ServiceName, defaultBranch)
-		if err := gitlab.CreateBranchInRepo(cfg, branchName, defaultBranch); err != nil {
-			logger.Error("Error creating branch: %v", err)
+		// Respect the limits on open merge requests
+		if !budget.take(fmt.Sprintf("%s: %s → %s", update.ServiceName, update.OldTag, update.NewTag)) {
 			continue
 		}
 
-		// Read file content
-		filePath := update.FilePath
-		content, err := os.ReadFile(filePath)
-		if err != nil {
-			logger.Error("Error reading file %s: %v", filePath, err)
+		if err := retryMergeRequest(ctx, cfg, name, proposeUpdate(cfg, gitlabClient, repoConfig, owners, update)); err != nil {
+			failures.add(name, err)
 			continue
 		}
 
-		// Update content with only this specific image
-		logger.Info("Updating %s: %s → %s", update.ServiceName, update.OldImage, update.NewImage)
-		newContent := strings.ReplaceAll(string(content), update.OldImage, update.NewImage)
+		logger.Info("Created merge request successfully for %s", update.ServiceName)
+		proposed = append(proposed, update)
+	}
 
-		// Write updated content back to file
-		if err := os.WriteFile(filePath, []byte(newContent), 0644); err != nil {
-			logger.Error("Error writing file %s: %v", filePath, err)
-			continue
+	return proposed, failures.result(cfg)
+}
+
+// proposeUpdate returns the steps pushing the branch of an update and opening its merge
+// request. A branch pushed by a failed attempt is not pushed again by the next ones.
+func proposeUpdate(cfg *config.Config, gitlabClient *gitlab.Client, repoConfig *config.RepoConfig, owners *ownerAssignment, update result.UpdateCandidate) func(ctx context.Context) error {
+	var branchName, targetBranch string
+	pushed := false
+	var creation mergeRequestCreation
+
+	return func(ctx context.Context) error {
+		if !pushed {
+			// Get the target branch of the file, defaulting to the default branch of the repository
+			var err error
+			if targetBranch, err = targetBranchFor(ctx, cfg, repoConfig, update); err != nil {
+				return fmt.Errorf("failed to get target branch: %w", err)
+			}
+
+			// Branches are named after the update so reruns find them again, the branch
+			// left by a failed attempt is reset
+			action := branchForce
+			if branchName == "" {
+				if branchName, action, err = resolveUpdateBranch(cfg, updateBranchName(update), branchExists(ctx, cfg)); err != nil {
+					return err
+				}
+			}
+
+			// Create the branch with only this specific image updated
+			if action == branchReuse {
+				logger.Info("Reusing existing branch %s for updating %s", branchName, update.ServiceName)
+			} else {
+				logger.Info("Creating branch %s for updating %s from branch %s", branchName, update.ServiceName, targetBranch)
+				if _, err := pushUpdates(ctx, cfg, branchName, targetBranch, action == branchForce, []result.UpdateCandidate{update}, func([]result.UpdateCandidate) string {
+					return formatCommitMessage(cfg, update)
+				}); err != nil {
+					return fmt.Errorf("failed to push update: %w", err)
+				}
+			}
+			pushed = true
 		}
 
-		// Commit changes
-		relPath := cfg.GetRelativePath(filePath)
-		commitMsg := fmt.Sprintf("Update Docker image for %s in %s", update.ServiceName, filepath.Base(filePath))
-		logger.Info("Committing changes to %s", relPath)
-		if err := gitlab.CommitAndPushChanges(cfg, commitMsg); err != nil {
-			logger.Error("Error committing changes: %v", err)
-			continue
+		// Create merge request with specific title and description for this image
+		title := formatMergeRequestTitle(update)
+		description := formatMergeRequestDescription(ctx, cfg, update)
+
+		opts := mergeRequestOptions(cfg, updateBump(update))
+		owners.apply(ctx, cfg, gitlabClient, &opts, []result.UpdateCandidate{update})
+
+		logger.Info("Creating merge request for %s targeting %s", update.ServiceName, targetBranch)
+		return creation.create(ctx, gitlabClient, branchName, targetBranch, title, description, opts)
+	}
+}
+
+// mergeRequestsFrozen reports whether merge requests are frozen by a freeze file in the
+// repository or a freeze window, logging why the updates are not proposed
+func mergeRequestsFrozen(cfg *config.Config, repoConfig *config.RepoConfig, updateCount int) bool {
+	reason, frozen := config.FreezeReason(cfg, repoConfig, cfg.TempDir, time.Now())
+	if frozen {
+		logger.Warn("Merge requests are frozen: %s. Not proposing %d updates", reason, updateCount)
+	}
+	return frozen
+}
+
+// targetBranchFor returns the branch the merge request of an update targets: the branch
+// mapped to its file in the repository configuration, or the default branch
+func targetBranchFor(ctx context.Context, cfg *config.Config, repoConfig *config.RepoConfig, update result.UpdateCandidate) (string, error) {
+	relPath := repoRelativePath(cfg, update.FilePath)
+	if branch, ok := repoConfig.TargetBranch(relPath); ok {
+		logger.Debug("Using target branch %s for %s from %s", branch, relPath, config.RepoConfigFile)
+		return branch, nil
+	}
+
+	return gitlab.GetDefaultBranch(ctx, cfg)
+}
+
+// listOpenMergeRequests returns open img-upgr merge requests keyed by the image they update,
+// along with the total number of open img-upgr merge requests. Failing to list them is an
+// error only when the number of open merge requests is limited
+func listOpenMergeRequests(ctx context.Context, cfg *config.Config) (map[string]gitlab.MergeRequestResponse, int, error) {
+	openMergeRequests := make(map[string]gitlab.MergeRequestResponse)
+
+	gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+	if !ok {
+		return openMergeRequests, 0, openMergeRequestsError(cfg, fmt.Errorf("GitLab client not initialized"))
+	}
+
+	mergeRequests, err := gitlabClient.ListMergeRequestsWithContext(ctx, "opened", gitlab.BranchPrefix)
+	if err != nil {
+		return openMergeRequests, 0, openMergeRequestsError(cfg, err)
+	}
+
+	// Grouped merge requests hold several markers and are only refreshed as a group
+	for _, mr := range mergeRequests {
+		if markers := gitlab.ParseUpdateMarkers(mr.Description); len(markers) == 1 {
+			openMergeRequests[markers[0].ImageKey()] = mr
 		}
+	}
+
+	return openMergeRequests, len(mergeRequests), nil
+}
+
+// refreshMergeRequest rewrites the branch of an open merge request with a newer update
+// and updates its title and description accordingly
+func refreshMergeRequest(ctx context.Context, cfg *config.Config, mr gitlab.MergeRequestResponse, update result.UpdateCandidate) error {
+	gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+	if !ok {
+		return fmt.Errorf("invalid GitLab client type")
+	}
+
+	// Replace the previous commit of the merge request branch with the newer update
+	pipeline := previousPipeline(ctx, cfg, gitlabClient, mr)
+	if _, err := pushUpdates(ctx, cfg, mr.SourceBranch, mr.TargetBranch, true, []result.UpdateCandidate{update}, func([]result.UpdateCandidate) string {
+		return formatCommitMessage(cfg, update)
+	}); err != nil {
+		return err
+	}
+
+	// Update title and description to match the new proposal
+	title := keepDraft(mr, formatMergeRequestTitle(update))
+	description := formatMergeRequestDescription(ctx, cfg, update)
+	if _, err := gitlabClient.UpdateMergeRequestWithContext(ctx, mr.IID, title, description); err != nil {
+		return fmt.Errorf("failed to update merge request: %w", err)
+	}
 
-		// Get current branch name
-		currentBranch, err := gitlab.GetCurrentBranch(cfg)
+	postRefreshNote(ctx, cfg, gitlabClient, mr, []gitlab.UpdateMarker{updateMarker(cfg, update)}, pipeline)
+	return nil
+}
+
+// keepDraft returns the title of a refreshed merge request, keeping drafts as drafts since
+// GitLab marks a merge request ready when the draft prefix is removed from its title
+func keepDraft(mr gitlab.MergeRequestResponse, title string) string {
+	if mr.Draft {
+		return "Draft: " + title
+	}
+	return title
+}
+
+// applyUpdateToFile replaces the old image reference with the new one in the file of an update
+func applyUpdateToFile(update result.UpdateCandidate) error {
+	content, err := os.ReadFile(update.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	updated, err := applyUpdateToContent(content, update)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(update.FilePath, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// applyUpdateToContent returns the content of the file of an update with the update applied
+func applyUpdateToContent(content []byte, update result.UpdateCandidate) ([]byte, error) {
+	switch update.Kind {
+	case gitops.KindChart, gitops.KindImageTag:
+		return gitops.ReplaceReference(content, update.ServiceName, update.OldTag, update.NewTag)
+	case gitops.KindImage:
+		return gitops.ReplaceReference(content, update.ServiceName, update.OldImage, update.NewImage)
+	case terraform.Kind:
+		return terraform.ReplaceReference(content, update.ServiceName, update.OldImage, update.NewImage)
+	default:
+		updated, err := compose.ReplaceServiceImage(content, update.ServiceName, update.OldImage, update.NewImage)
 		if err != nil {
-			logger.Error("Error getting current branch: %v", err)
-			continue
+			return nil, err
 		}
+		// The file is parsed again so a rewrite breaking it never reaches a merge request
+		if err := compose.VerifyServiceImage(updated, update.ServiceName, update.NewImage); err != nil {
+			return nil, err
+		}
+		return updated, nil
+	}
+}
 
-		// Get default branch for merge request target
-		defaultBranch, err = gitlab.GetDefaultBranch(cfg)
+// skipAppliedUpdates drops the updates whose new image is already used on the branch
+// their merge request targets. Files that cannot be read keep their updates, which then
+// go through the usual path.
+func skipAppliedUpdates(ctx context.Context, cfg *config.Config, gitlabClient *gitlab.Client, repoConfig *config.RepoConfig, updates []result.UpdateCandidate) []result.UpdateCandidate {
+	contents := make(map[string][]byte)
+	var pending []result.UpdateCandidate
+	for _, update := range updates {
+		targetBranch, err := targetBranchFor(ctx, cfg, repoConfig, update)
 		if err != nil {
-			logger.Error("Error getting default branch: %v", err)
+			pending = append(pending, update)
 			continue
 		}
 
-		// Create merge request with specific title and description for this image
-		title := fmt.Sprintf("Update %s from %s to %s", update.ServiceName, update.OldTag, update.NewTag)
-		description := formatMergeRequestDescription(update)
+		relPath := repoRelativePath(cfg, update.FilePath)
+		key := targetBranch + "\x00" + relPath
+		content, ok := contents[key]
+		if !ok {
+			file, err := gitlabClient.GetFileWithContext(ctx, targetBranch, relPath)
+			if err != nil {
+				logger.Warn("Could not read %s on %s to check for applied updates, keeping its updates: %v", relPath, targetBranch, err)
+			}
+			content = []byte(file)
+			contents[key] = content
+		}
 
-		logger.Info("Creating merge request for %s targeting %s", update.ServiceName, defaultBranch)
-		gitlabClient, err := gitlab.NewClient(cfg)
-		if err != nil {
-			logger.Error("Error creating GitLab client: %v", err)
+		if len(content) > 0 && updateApplied(content, update) {
+			logger.Info("Skipping %s: %s already uses %s on %s", update.ServiceName, relPath, update.NewImage, targetBranch)
 			continue
 		}
+		pending = append(pending, update)
+	}
+	return pending
+}
 
-		_, err = gitlabClient.CreateMergeRequest(currentBranch, defaultBranch, title, description)
-		if err != nil {
-			logger.Error("Error creating merge request: %v", err)
-			continue
+// updateApplied reports whether the content of the file of an update already holds its
+// new value, that is whether the reverse update applies
+func updateApplied(content []byte, update result.UpdateCandidate) bool {
+	reverse := update
+	reverse.OldImage, reverse.NewImage = update.NewImage, update.OldImage
+	reverse.OldTag, reverse.NewTag = update.NewTag, update.OldTag
+
+	_, err := applyUpdateToContent(content, reverse)
+	return err == nil
+}
+
+// oldValue returns the text an update replaces in its file
+func oldValue(update result.UpdateCandidate) string {
+	if update.Kind == gitops.KindChart || update.Kind == gitops.KindImageTag {
+		return update.OldTag
+	}
+	return update.OldImage
+}
+
+// commitMessageData holds the fields available to commit message templates
+type commitMessageData struct {
+	result.UpdateCandidate
+	Type  string
+	Scope string
+	File  string
+}
+
+// formatCommitMessage builds the commit message for an update using the configured style or template
+func formatCommitMessage(cfg *config.Config, update result.UpdateCandidate) string {
+	if cfg.CommitTemplate != "" {
+		message, err := renderCommitTemplate(cfg, update)
+		if err == nil {
+			return message
 		}
+		logger.Warn("Invalid commit template, falling back to %s style: %v", cfg.CommitStyle, err)
+	}
 
-		logger.Info("Created merge request successfully for %s", update.ServiceName)
+	switch cfg.CommitStyle {
+	case "conventional":
+		prefix := cfg.CommitType
+		if cfg.CommitScope != "" {
+			prefix += "(" + cfg.CommitScope + ")"
+		}
+		return fmt.Sprintf("%s: update %s from %s to %s", prefix, update.Repository, update.OldTag, update.NewTag)
+	default:
+		return fmt.Sprintf("Update Docker image for %s in %s", update.ServiceName, filepath.Base(update.FilePath))
 	}
+}
 
-	return nil
+// renderCommitTemplate renders the user-provided commit message template for an update
+func renderCommitTemplate(cfg *config.Config, update result.UpdateCandidate) (string, error) {
+	tmpl, err := template.New("commit").Parse(cfg.CommitTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	err = tmpl.Execute(&sb, commitMessageData{
+		UpdateCandidate: update,
+		Type:            cfg.CommitType,
+		Scope:           cfg.CommitScope,
+		File:            filepath.Base(update.FilePath),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
+
+// sanitizeBranchComponent replaces the characters that are not safe in branch names
+func sanitizeBranchComponent(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+}
+
+// mergeRequestOptions returns the settings of a new merge request from the policies
+// matching the bump of its updates, major updates being labeled so they stand out.
+// Draft merge requests are never merged automatically.
+func mergeRequestOptions(cfg *config.Config, bump policy.Bump) gitlab.MergeRequestOptions {
+	decision := policy.Decide(cfg.Policies, bump)
+	opts := gitlab.MergeRequestOptions{
+		Labels:    decision.Labels,
+		Assignees: decision.Assignees,
+		Reviewers: decision.Reviewers,
+		Draft:     decision.Draft || cfg.Draft,
+		AutoMerge: decision.AutoMerge && !cfg.Draft,
+
+		Squash:             cfg.MRSquash,
+		RemoveSourceBranch: cfg.MRRemoveSourceBranch,
+	}
+	if bump == policy.BumpMajor && cfg.MajorLabel != "" && !slices.Contains(opts.Labels, cfg.MajorLabel) {
+		opts.Labels = append(opts.Labels, cfg.MajorLabel)
+	}
+	return opts
 }
 
+// mergeSettingsMismatches returns how the merge request settings disagree with the merge
+// settings of the project, which GitLab enforces over the ones of merge requests
+func mergeSettingsMismatches(cfg *config.Config, project *gitlab.ProjectResponse) []string {
+	var mismatches []string
+	if cfg.MRMergeMethod != "" && project.MergeMethod != "" && cfg.MRMergeMethod != project.MergeMethod {
+		mismatches = append(mismatches, fmt.Sprintf("%s is %s but the project merges with %s",
+			config.EnvMRMergeMethod, cfg.MRMergeMethod, project.MergeMethod))
+	}
+	if cfg.MRSquash && project.SquashOption == "never" {
+		mismatches = append(mismatches, fmt.Sprintf("%s is set but the project never squashes commits", config.EnvMRSquash))
+	}
+	if !cfg.MRSquash && project.SquashOption == "always" {
+		mismatches = append(mismatches, fmt.Sprintf("the project always squashes commits, set %s to reflect it", config.EnvMRSquash))
+	}
+	return mismatches
+}
+
+// warnMergeSettings logs the mismatches between the merge request settings and the
+// merge settings of the project
+func warnMergeSettings(ctx context.Context, gitlabClient *gitlab.Client, cfg *config.Config) {
+	if cfg.MRMergeMethod == "" && !cfg.MRSquash {
+		return
+	}
+
+	project, err := gitlabClient.GetProjectWithContext(ctx)
+	if err != nil {
+		logger.Warn("Could not read the merge settings of the project: %v", err)
+		return
+	}
+	for _, mismatch := range mergeSettingsMismatches(cfg, project) {
+		logger.Warn("Merge settings: %s", mismatch)
+	}
+}
+
+// updateBump returns the severity of the version change of an update
+func updateBump(update result.UpdateCandidate) policy.Bump {
+	if update.Major {
+		return policy.BumpMajor
+	}
+	return policy.Classify(update.OldTag, update.NewTag)
+}
+
+// formatMergeRequestTitle builds the merge request title for an update
+func formatMergeRequestTitle(update result.UpdateCandidate) string {
+	title := fmt.Sprintf("Update %s from %s to %s", update.ServiceName, update.OldTag, update.NewTag)
+	if update.Downgrade {
+		title = fmt.Sprintf("Downgrade %s from %s to %s", update.ServiceName, update.OldTag, update.NewTag)
+	}
+	if update.Major {
+		title += " (major)"
+	}
+	return title
+}
+
+// majorUpdateWarning is the section added to the description of merge requests with major updates
+const majorUpdateWarning = "\n> **Warning:** this is a major version update which may contain breaking changes.\n" +
+	"> Review the release notes and test the new version before merging.\n"
+
+// downgradeWarning is the section added to the description of merge requests rolling back a tag
+const downgradeWarning = "\n> **Warning:** this rolls the image back to an older version than the current one.\n" +
+	"> Make sure data written by the current version can be read by the older one before merging.\n"
+
 // formatMergeRequestDescription builds a detailed description for the merge request
-func formatMergeRequestDescription(update UpdateInfo) string {
+func formatMergeRequestDescription(ctx context.Context, cfg *config.Config, update result.UpdateCandidate) string {
 	description := "Automated update of Docker image by img-upgr\n\n"
 	description += fmt.Sprintf("Service: `%s`\n", update.ServiceName)
 	description += fmt.Sprintf("File: `%s`\n", filepath.Base(update.FilePath))
 	description += fmt.Sprintf("Update: `%s` → `%s`\n", update.OldTag, update.NewTag)
 	description += fmt.Sprintf("Repository: `%s`\n", update.Repository)
-	description += fmt.Sprintf("\nGenerated: %s", time.Now().Format(time.RFC3339))
+	description += registryLinks(cfg, update)
+	description += imageDetails(cfg, update)
+	if update.Major {
+		description += majorUpdateWarning
+	}
+	if update.Verification != "" {
+		description += fmt.Sprintf("Provenance: cosign %s\n", update.Verification)
+	}
+	if update.Downgrade {
+		description += downgradeWarning
+	}
+	description += sbomDiff(ctx, cfg, update, sbomMaxPackages)
+	description += releaseNotes(ctx, cfg, update, changelog.DefaultMaxLength)
+	description += fmt.Sprintf("\nGenerated: %s by run `%s`", time.Now().Format(time.RFC3339), runid.FromContext(ctx))
+	description += "\n\n" + updateMarker(cfg, update).String()
 
 	return description
 }
@@ -422,8 +1720,104 @@ func init() {
 	rootCmd.AddCommand(checkCmd)
 
 	// Output format flag
-	checkCmd.Flags().StringVarP(&checkCfg.OutputFormat, "output", "o", "text", "Output format (text, json)")
+	checkCmd.Flags().StringVarP(&checkCfg.OutputFormat, "output", "o", "text", "Output format ("+strings.Join(result.Formats(), ", ")+")")
+	checkCmd.Flags().StringVar(&checkCfg.ReportFile, "report-file", checkCfg.ReportFile, "Also write the result as JSON to this file, e.g. report.json for CI artifacts")
+	checkCmd.Flags().StringVar(&checkCfg.DotenvFile, "dotenv-file", checkCfg.DotenvFile, "Also write the counts of the result to this dotenv file for GitLab CI reports")
+
+	// File discovery flags
+	checkCmd.Flags().BoolVar(&checkCfg.GitOps, "gitops", checkCfg.GitOps,
+		"Also check Flux HelmRelease, Argo CD Application and image policy marked manifests")
+	checkCmd.Flags().BoolVar(&checkCfg.Terraform, "terraform", checkCfg.Terraform,
+		"Also check images of docker and kubernetes provider resources in Terraform files")
+	checkCmd.Flags().StringSliceVar(&checkCfg.ComposePatterns, "compose-pattern", checkCfg.ComposePatterns,
+		"Glob patterns of compose files replacing the default docker-compose/compose name matching (e.g. \"stack.yml\", \"deploy/*.yaml\")")
+	checkCmd.Flags().StringSliceVar(&checkCfg.Exclude, "exclude", checkCfg.Exclude,
+		"Glob patterns of paths to exclude, relative to the scan directory (e.g. \"**/test/**\")")
+	checkCmd.Flags().StringSliceVar(&checkCfg.SkipDirs, "skip-dir", checkCfg.SkipDirs,
+		"Additional directory names to skip at any depth")
+	checkCmd.Flags().BoolVar(&checkCfg.Gitignore, "gitignore", checkCfg.Gitignore, "Skip files ignored by .gitignore")
 
 	// Behavior flags
-	checkCmd.Flags().BoolVar(&checkCfg.DryRun, "dry-run", false, "Check for updates but don't create merge requests")
+	checkCmd.Flags().IntVar(&checkCfg.Concurrency, "concurrency", checkCfg.Concurrency, "Number of compose files processed in parallel")
+	checkCmd.Flags().BoolVar(&checkCfg.Progress, "progress", checkCfg.Progress, "Draw the progress of the scan on stderr when it is a terminal")
+	checkCmd.Flags().StringVar(&checkCfg.WorkDir, "workdir", checkCfg.WorkDir,
+		"Keep the cloned repository in this directory between runs and only fetch changes")
+	checkCmd.Flags().BoolVar(&checkCfg.APIOnly, "api-only", checkCfg.APIOnly,
+		"Fetch files and push changes through the GitLab API instead of cloning the repository")
+	checkCmd.Flags().BoolVar(&checkCfg.DryRun, "dry-run", false, "Check for updates and validate the merge requests against GitLab without creating them")
+	checkCmd.Flags().BoolVar(&checkCfg.AllowMajor, "allow-major", checkCfg.AllowMajor,
+		"Also propose updates to a new major version, on separate labeled merge requests")
+	checkCmd.Flags().BoolVar(&checkCfg.AllowDowngrade, "allow-downgrade", checkCfg.AllowDowngrade,
+		"Propose rolling back tags newer than the latest allowed version, such as release candidates set by hand")
+	checkCmd.Flags().StringVar(&checkCfg.Platform, "platform", checkCfg.Platform,
+		"Only suggest tags published for this platform (e.g. linux/arm64)")
+	checkCmd.Flags().StringVar(&checkCfg.CosignKey, "cosign-key", checkCfg.CosignKey,
+		"Only suggest tags whose cosign signature verifies with this public key")
+	checkCmd.Flags().StringVar(&checkCfg.SBOM, "sbom", checkCfg.SBOM,
+		"Summarize the package changes of updates in merge requests from SBOMs (attestation, syft or auto)")
+	checkCmd.Flags().BoolVar(&checkCfg.EndOfLife, "eol", checkCfg.EndOfLife,
+		"Flag images whose release series reached its end of life according to endoflife.date")
+	checkCmd.Flags().BoolVar(&checkCfg.EOLUpgrade, "eol-upgrade", checkCfg.EOLUpgrade,
+		"Also propose updating end of life images to the next supported series (with --eol)")
+	checkCmd.Flags().BoolVar(&checkCfg.Changelog, "changelog", checkCfg.Changelog,
+		"Embed the release notes between the old and new versions in merge request descriptions")
+	checkCmd.Flags().BoolVar(&checkCfg.TrackDigests, "track-digests", false,
+		"Report content changes of digest-pinned images on mutable tags such as latest")
+	checkCmd.Flags().BoolVar(&checkCfg.PinDigest, "pin-digest", checkCfg.PinDigest,
+		"Write suggested images pinned to the digest of their tag, and update the digest of pinned images whose tag is current")
+	checkCmd.Flags().StringVar(&checkCfg.TagCatalog, "tag-catalog", checkCfg.TagCatalog,
+		"Read tags from a catalog written by export-tags, or an OCI layout directory, instead of the registries")
+	checkCmd.Flags().BoolVar(&checkCfg.RewriteImages, "rewrite-images", checkCfg.RewriteImages,
+		"Write suggested images using the registry mirror hosts")
+	checkCmd.Flags().BoolVar(&checkCfg.VerifyPull, "verify-pull", checkCfg.VerifyPull,
+		"Request the manifest of suggested tags before creating their merge requests")
+	checkCmd.Flags().StringVar(&checkCfg.StateStore, "state", checkCfg.StateStore,
+		"State store recording the previous run (path to a JSON file or gitlab-snippet:<id>)")
+	checkCmd.Flags().BoolVar(&checkCfg.NewOnly, "new-only", false,
+		"Only report updates that became available since the previous run (requires --state)")
+	checkCmd.Flags().StringVar(&checkCfg.PlanFile, "plan", "", "Write found updates to a plan file instead of creating merge requests")
+	checkCmd.Flags().BoolVar(&checkCfg.ReopenDeclined, "reopen-declined", false,
+		"Propose updates again even if their merge request was previously closed without merging")
+	checkCmd.Flags().BoolVar(&checkCfg.Issues, "issues", checkCfg.Issues,
+		"Open or update a GitLab issue for each image that cannot be checked or reached its end of life")
+
+	// Merge request limit flags
+	checkCmd.Flags().IntVar(&checkCfg.MRLimit, "mr-limit", checkCfg.MRLimit,
+		"Maximum number of img-upgr merge requests open at the same time in the project (0 for no limit)")
+	checkCmd.Flags().IntVar(&checkCfg.MRRunLimit, "mr-run-limit", checkCfg.MRRunLimit,
+		"Maximum number of merge requests created by a single run (0 for no limit)")
+	checkCmd.Flags().IntVar(&checkCfg.MRRetries, "mr-retries", checkCfg.MRRetries,
+		"Number of retries of an update whose branch or merge request failed")
+	checkCmd.Flags().BoolVar(&checkCfg.BestEffort, "best-effort", checkCfg.BestEffort,
+		"Exit successfully even when some merge requests failed")
+	checkCmd.Flags().BoolVar(&checkCfg.Draft, "draft", checkCfg.Draft,
+		"Create merge requests as drafts, which must be marked ready before merging")
+	checkCmd.Flags().BoolVar(&checkCfg.MRSquash, "squash", checkCfg.MRSquash,
+		"Squash the commits of merge requests when they are merged")
+	checkCmd.Flags().BoolVar(&checkCfg.MRRemoveSourceBranch, "remove-source-branch", checkCfg.MRRemoveSourceBranch,
+		"Delete the branches of merge requests when they are merged")
+	checkCmd.Flags().BoolVar(&checkCfg.Cleanup, "cleanup", checkCfg.Cleanup,
+		"Delete img-upgr branches left without an open merge request after the run")
+
+	// Merge request grouping flags
+	checkCmd.Flags().StringVar(&checkCfg.GroupBy, "group-by", checkCfg.GroupBy,
+		"Group updates into merge requests (none for one per update, directory for one per directory, image for one per image across files)")
+	checkCmd.Flags().IntVar(&checkCfg.GroupDepth, "group-depth", checkCfg.GroupDepth,
+		"Number of leading directories grouping updates with --group-by directory, e.g. 2 for apps/<name> (0 for the file directory)")
+	checkCmd.Flags().StringVar(&checkCfg.BranchConflict, "branch-conflict", checkCfg.BranchConflict,
+		"Handling of an existing branch of an update: reuse it, force to reset it, or suffix to push to a new one")
+
+	// Commit signing flags
+	checkCmd.Flags().StringVar(&checkCfg.SigningKey, "signing-key", checkCfg.SigningKey,
+		"Path to a GPG or SSH private key used to sign commits")
+	checkCmd.Flags().StringVar(&checkCfg.SigningFormat, "signing-format", checkCfg.SigningFormat,
+		"Commit signature format (gpg, ssh)")
+
+	// Commit message flags
+	checkCmd.Flags().StringVar(&checkCfg.CommitStyle, "commit-style", checkCfg.CommitStyle,
+		"Commit message style (default, conventional)")
+	checkCmd.Flags().StringVar(&checkCfg.CommitType, "commit-type", checkCfg.CommitType,
+		"Conventional Commits type used with --commit-style conventional")
+	checkCmd.Flags().StringVar(&checkCfg.CommitScope, "commit-scope", checkCfg.CommitScope,
+		"Conventional Commits scope used with --commit-style conventional (empty for none)")
 }
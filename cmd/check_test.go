@@ -0,0 +1,460 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitops"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/state"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/validation"
+)
+
+// stubRegistry answers the tags of repositories, failing for those without tags, after
+// a delay per repository
+type stubRegistry struct {
+	tags   map[string][]string
+	delays map[string]time.Duration
+	// onFetch is called before each request, if set
+	onFetch func()
+	calls   atomic.Int64
+}
+
+func (s *stubRegistry) FetchAllTags(repo string) ([]string, error) {
+	s.calls.Add(1)
+	if s.onFetch != nil {
+		s.onFetch()
+	}
+	time.Sleep(s.delays[repo])
+	tags, ok := s.tags[repo]
+	if !ok {
+		return nil, fmt.Errorf("repository %s not found", repo)
+	}
+	return tags, nil
+}
+
+func (s *stubRegistry) FetchTagDigest(repo, tag string) (string, error) {
+	return "sha256:" + strings.Repeat("0", 64), nil
+}
+
+// writeComposeFiles writes compose files named after their position and returns their
+// paths in order
+func writeComposeFiles(t *testing.T, contents ...string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	files := make([]string, 0, len(contents))
+	for i, content := range contents {
+		path := filepath.Join(dir, fmt.Sprintf("compose-%d.yml", i))
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	return files
+}
+
+// newCheckConfig returns the configuration of a check of local files
+func newCheckConfig(concurrency int) *config.Config {
+	cfg := config.New()
+	cfg.Concurrency = concurrency
+	cfg.Progress = false
+	return cfg
+}
+
+func TestProcessComposeFiles(t *testing.T) {
+	files := writeComposeFiles(t,
+		"services:\n  web:\n    image: nginx:1.25.0\n",
+		"services:\n  cache:\n    image: redis:7.0.0\n",
+		"services: [not a mapping\n",
+		"services:\n  db:\n    image: postgres:16.0.0\n",
+		"services:\n  app:\n    image: missing/app:1.0.0\n",
+	)
+	registry := &stubRegistry{
+		tags: map[string][]string{
+			"nginx":    {"1.25.0", "1.27.0"},
+			"redis":    {"7.0.0", "7.4.1"},
+			"postgres": {"16.0.0", "16.4.0"},
+		},
+		// The first files take the longest so that they finish last
+		delays: map[string]time.Duration{
+			"nginx": 30 * time.Millisecond,
+			"redis": 20 * time.Millisecond,
+		},
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			updates, fileErrors, err := processComposeFilesWithContext(context.Background(), newCheckConfig(concurrency), nil, files, registry)
+			if err != nil {
+				t.Fatalf("processComposeFilesWithContext() error = %v", err)
+			}
+
+			// Updates keep the order of the files whatever the order they finish in
+			var got []string
+			for _, update := range updates {
+				got = append(got, filepath.Base(update.FilePath)+" "+update.Repository+":"+update.NewTag)
+				if update.Line != 3 {
+					t.Errorf("line of %s = %d, want the line of its image", update.ServiceName, update.Line)
+				}
+			}
+			want := []string{"compose-0.yml nginx:1.27.0", "compose-1.yml redis:7.4.1", "compose-3.yml postgres:16.4.0"}
+			if strings.Join(got, ", ") != strings.Join(want, ", ") {
+				t.Errorf("updates = %v, want %v", got, want)
+			}
+
+			// The errors of every file are collected, in the order of the files
+			var failed []string
+			for _, fileError := range fileErrors {
+				failed = append(failed, filepath.Base(fileError.FilePath))
+			}
+			if strings.Join(failed, ", ") != "compose-2.yml, compose-4.yml" {
+				t.Errorf("errors = %+v, want the unparsable file and the missing repository", fileErrors)
+			}
+		})
+	}
+}
+
+func TestProcessComposeFilesCancelled(t *testing.T) {
+	contents := make([]string, 20)
+	for i := range contents {
+		contents[i] = fmt.Sprintf("services:\n  app:\n    image: app%d:1.0.0\n", i)
+	}
+	files := writeComposeFiles(t, contents...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var once sync.Once
+	registry := &stubRegistry{
+		tags:    map[string][]string{},
+		delays:  map[string]time.Duration{},
+		onFetch: func() { once.Do(cancel) },
+	}
+
+	updates, fileErrors, err := processComposeFilesWithContext(ctx, newCheckConfig(2), nil, files, registry)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("processComposeFilesWithContext() error = %v, want %v", err, context.Canceled)
+	}
+	if updates != nil || fileErrors != nil {
+		t.Errorf("cancelled check returned updates %+v and errors %+v", updates, fileErrors)
+	}
+	// No file is started once the check is cancelled
+	if calls := registry.calls.Load(); calls >= int64(len(files)) {
+		t.Errorf("registry called %d times after cancellation, want fewer than %d", calls, len(files))
+	}
+}
+
+func TestPruneUsages(t *testing.T) {
+	cfg := newCheckConfig(1)
+	cfg.GitLabRepo = "https://gitlab.example.com/group/project"
+	cfg.TempDir = t.TempDir()
+	for _, name := range []string{"compose.yml", "infra/compose.yml"} {
+		path := filepath.Join(cfg.TempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := state.New()
+	for _, usage := range []state.Usage{
+		{Repository: cfg.GitLabRepo, File: "compose.yml", Service: "web"},
+		{Repository: cfg.GitLabRepo, File: "compose.yml", Service: "removed"},
+		{Repository: cfg.GitLabRepo, File: "deleted/compose.yml", Service: "web"},
+		{Repository: cfg.GitLabRepo, File: "infra/compose.yml", Service: "web"},
+	} {
+		previous.RecordUsage("nginx:1.25.0", usage)
+	}
+	data, err := previous.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := state.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The scan of compose.yml finds web again, infra/compose.yml is not scanned
+	st.RecordUsage("nginx:1.25.0", state.Usage{Repository: cfg.GitLabRepo, File: "compose.yml", Service: "web"})
+	pruneUsages(cfg, st, []string{filepath.Join(cfg.TempDir, "compose.yml")})
+
+	entry, _ := st.Get("nginx:1.25.0")
+	var got []string
+	for _, usage := range entry.Usages {
+		got = append(got, usage.File+" "+usage.Service)
+	}
+	if want := "compose.yml web, infra/compose.yml web"; strings.Join(got, ", ") != want {
+		t.Errorf("usages = %v, want %s", got, want)
+	}
+}
+
+func TestSkipAppliedUpdates(t *testing.T) {
+	oldDigest, newDigest := "sha256:"+strings.Repeat("a", 64), "sha256:"+strings.Repeat("b", 64)
+	cfg, fake := newFakeGitLab(t, map[string]string{
+		"compose.yml": "services:\n  web:\n    image: nginx:1.27.0\n  cache:\n    image: redis:7.0.0\n",
+		"pinned.yml":  "services:\n  app:\n    image: app:1.0@" + newDigest + "\n",
+		"flux.yml": "apiVersion: helm.toolkit.fluxcd.io/v2\nkind: HelmRelease\nmetadata:\n  name: podinfo\n  namespace: apps\n" +
+			"spec:\n  chart:\n    spec:\n      chart: podinfo\n      version: 6.6.0\n      sourceRef:\n        kind: HelmRepository\n        name: podinfo\n",
+	})
+	file := func(name string) string { return filepath.Join(cfg.TempDir, name) }
+
+	web := nginxUpdate(cfg)
+	cache := result.UpdateCandidate{
+		FilePath: file("compose.yml"), ServiceName: "cache", Repository: "redis",
+		OldImage: "redis:7.0.0", NewImage: "redis:7.4.1", OldTag: "7.0.0", NewTag: "7.4.1",
+	}
+	pinned := result.UpdateCandidate{
+		FilePath: file("pinned.yml"), ServiceName: "app", Repository: "app",
+		OldImage: "app:1.0@" + oldDigest, NewImage: "app:1.0@" + newDigest, OldTag: "1.0", NewTag: "1.0",
+	}
+	chart := result.UpdateCandidate{
+		FilePath: file("flux.yml"), ServiceName: "HelmRelease/apps/podinfo", Repository: "podinfo", Kind: gitops.KindChart,
+		OldImage: "podinfo:6.5.0", NewImage: "podinfo:6.6.0", OldTag: "6.5.0", NewTag: "6.6.0",
+	}
+	newChart := chart
+	newChart.OldTag, newChart.NewTag = "6.6.0", "6.7.0"
+	// The file of these updates cannot be read on the target branch
+	missing := result.UpdateCandidate{
+		FilePath: file("missing.yml"), ServiceName: "db", Repository: "postgres",
+		OldImage: "postgres:16.0.0", NewImage: "postgres:16.4.0", OldTag: "16.0.0", NewTag: "16.4.0",
+	}
+	missingCache := missing
+	missingCache.ServiceName = "cache"
+
+	pending := skipAppliedUpdates(context.Background(), cfg, cfg.GitLabClient.(*gitlab.Client), nil,
+		[]result.UpdateCandidate{web, cache, pinned, chart, newChart, missing, missingCache})
+
+	var got []string
+	for _, update := range pending {
+		got = append(got, update.ServiceName+" "+update.NewTag)
+	}
+	if want := "cache 7.4.1, HelmRelease/apps/podinfo 6.7.0, db 16.4.0, cache 16.4.0"; strings.Join(got, ", ") != want {
+		t.Errorf("pending updates = %v, want %s", got, want)
+	}
+
+	// Each file is read once whatever the number of its updates
+	for name, reads := range fake.reads {
+		if reads != 1 {
+			t.Errorf("%s read %d times, want once", name, reads)
+		}
+	}
+}
+
+func TestValidateConfigOutputFormat(t *testing.T) {
+	for _, format := range result.Formats() {
+		cfg := newCheckConfig(1)
+		cfg.OutputFormat = format
+		if err := validateConfig(cfg); err != nil {
+			t.Errorf("validateConfig() with output format %s error = %v", format, err)
+		}
+	}
+
+	cfg := newCheckConfig(1)
+	cfg.OutputFormat = "html"
+	var validationErrors *validation.ValidationErrors
+	if err := validateConfig(cfg); !errors.As(err, &validationErrors) || validationErrors.Errors[0].Field != "OutputFormat" {
+		t.Errorf("validateConfig() with output format html error = %v, want an OutputFormat error", err)
+	}
+}
+
+func TestSaveStateAfterMergeRequests(t *testing.T) {
+	useRetryWait(t, time.Millisecond)
+	compose := "services:\n  web:\n    image: nginx:1.25.0\n  cache:\n    image: redis:7.0.0\n"
+
+	tests := []struct {
+		name string
+		// failMergeRequests fails the merge request of nginx, proposed first
+		failMergeRequests int
+		dryRun            bool
+		planFile          bool
+		wantSaved         bool
+		wantTags          map[string]string
+	}{
+		{"merge requests opened", 0, false, false, true, map[string]string{"docker.io/library/nginx": "1.27.0", "docker.io/library/redis": "7.4.1"}},
+		{"failed merge request", 1, false, false, true, map[string]string{"docker.io/library/redis": "7.4.1"}},
+		{"dry run", 0, true, false, false, nil},
+		{"plan", 0, false, true, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, fake := newFakeGitLab(t, map[string]string{"compose.yml": compose})
+			cfg.MRRetries = 0
+			cfg.BestEffort = true
+			cfg.DryRun = tt.dryRun
+			if tt.planFile {
+				cfg.PlanFile = filepath.Join(t.TempDir(), "plan.json")
+			}
+			fake.failMergeRequests = tt.failMergeRequests
+			previous := checkCfg
+			t.Cleanup(func() { checkCfg = previous })
+			checkCfg = cfg
+
+			cache := result.UpdateCandidate{
+				FilePath: filepath.Join(cfg.TempDir, "compose.yml"), ServiceName: "cache", Repository: "redis",
+				OldImage: "redis:7.0.0", NewImage: "redis:7.4.1", OldTag: "7.0.0", NewTag: "7.4.1",
+			}
+			proposed, err := handleUpdates(context.Background(), []result.UpdateCandidate{nginxUpdate(cfg), cache})
+			if err != nil {
+				t.Fatalf("handleUpdates() error = %v", err)
+			}
+
+			path := filepath.Join(t.TempDir(), "state.json")
+			store := state.NewFileStore(path)
+			saveState(context.Background(), cfg, store, state.New(), nil, proposed)
+
+			if _, err := os.Stat(path); (err == nil) != tt.wantSaved {
+				t.Fatalf("state saved = %v, want %v", err == nil, tt.wantSaved)
+			}
+			st, err := store.Load(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for image, entry := range st.Images {
+				got[image] = entry.LatestTag
+			}
+			if len(got) != len(tt.wantTags) {
+				t.Errorf("recorded tags = %v, want %v", got, tt.wantTags)
+			}
+			for image, tag := range tt.wantTags {
+				if got[image] != tag {
+					t.Errorf("recorded tags = %v, want %v", got, tt.wantTags)
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshMergeRequest(t *testing.T) {
+	useRetryWait(t, time.Millisecond)
+	compose := "services:\n  web:\n    image: nginx:1.25.0\n"
+
+	// mergeRequest returns a merge request of img-upgr proposing nginx at a tag
+	mergeRequest := func(cfg *config.Config, state, tag string) gitlab.MergeRequestResponse {
+		proposal := nginxUpdate(cfg)
+		proposal.NewTag, proposal.NewImage = tag, "nginx:"+tag
+		return gitlab.MergeRequestResponse{
+			IID: 7, State: state, Title: "Update web from 1.25.0 to " + tag, Description: updateMarker(cfg, proposal).String(),
+			SourceBranch: updateBranchName(proposal), TargetBranch: "main",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		state  string
+		tag    string
+		draft  bool
+		want   string
+		wantMR bool
+	}{
+		// The open merge request already proposes the update, nothing is pushed
+		{"up to date", "opened", "1.27.0", false, "", false},
+		// A newer version rewrites the branch of the merge request with a new commit
+		{"newer version", "opened", "1.26.0", false, "Update web from 1.25.0 to 1.27.0", false},
+		{"newer version of a draft", "opened", "1.26.0", true, "Draft: Update web from 1.25.0 to 1.27.0", false},
+		// A closed merge request is left alone and a new one is opened
+		{"closed", "closed", "1.26.0", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, fake := newFakeGitLab(t, map[string]string{"compose.yml": compose})
+			cfg.MRRetries = 0
+			cfg.RefreshNotes = false
+			mr := mergeRequest(cfg, tt.state, tt.tag)
+			mr.Draft = tt.draft
+			if tt.state == "opened" {
+				fake.openMergeRequests = []gitlab.MergeRequestResponse{mr}
+			} else {
+				fake.closedMergeRequests = []gitlab.MergeRequestResponse{mr}
+			}
+			update := nginxUpdate(cfg)
+
+			proposed, err := createMergeRequestsForUpdates(context.Background(), cfg, []result.UpdateCandidate{update})
+			if err != nil {
+				t.Fatalf("createMergeRequestsForUpdates() error = %v", err)
+			}
+			if len(proposed) != 1 || proposed[0].NewTag != "1.27.0" {
+				t.Errorf("proposed = %+v, want nginx 1.27.0", proposed)
+			}
+
+			title, updated := fake.updatedMergeRequests[mr.IID]
+			if updated != (tt.want != "") || title != tt.want {
+				t.Errorf("merge request !%d updated = %v with title %q, want %q", mr.IID, updated, title, tt.want)
+			}
+			if opened := len(fake.mergeRequests) > 0; opened != tt.wantMR {
+				t.Errorf("merge requests opened = %v, want %v", fake.mergeRequests, tt.wantMR)
+			}
+
+			// The branch of a refreshed merge request holds the newer update
+			files, pushed := fake.commits[mr.SourceBranch]
+			if tt.want != "" && (!pushed || len(files) != 1 || !strings.Contains(files[0].Content, "nginx:1.27.0")) {
+				t.Errorf("commits = %+v, want %s rewritten with nginx:1.27.0", fake.commits, mr.SourceBranch)
+			}
+			if tt.want == "" && !tt.wantMR && len(fake.commits) != 0 {
+				t.Errorf("commits = %+v, want none for an up to date merge request", fake.commits)
+			}
+		})
+	}
+}
+
+func TestFormatCommitMessage(t *testing.T) {
+	update := result.UpdateCandidate{
+		FilePath: "/repo/apps/compose.yml", ServiceName: "web", Repository: "nginx",
+		OldImage: "nginx:1.25.0", NewImage: "nginx:1.27.0", OldTag: "1.25.0", NewTag: "1.27.0",
+	}
+
+	tests := []struct {
+		name     string
+		style    string
+		scope    string
+		template string
+		want     string
+		// wantErr is whether renderCommitTemplate fails on the template
+		wantErr bool
+	}{
+		{"default", "default", "deps", "", "Update Docker image for web in compose.yml", false},
+		{"conventional", "conventional", "deps", "", "chore(deps): update nginx from 1.25.0 to 1.27.0", false},
+		{"conventional without scope", "conventional", "", "", "chore: update nginx from 1.25.0 to 1.27.0", false},
+		{"template", "default", "deps", "{{.Type}}({{.Scope}}): bump {{.ServiceName}} in {{.File}} to {{.NewImage}}",
+			"chore(deps): bump web in compose.yml to nginx:1.27.0", false},
+		{"template over conventional", "conventional", "deps", "Bump {{.Repository}} {{.OldTag}} → {{.NewTag}}",
+			"Bump nginx 1.25.0 → 1.27.0", false},
+		// Templates that do not parse or fail to execute fall back to the style
+		{"invalid template", "conventional", "deps", "{{.NewTag", "chore(deps): update nginx from 1.25.0 to 1.27.0", true},
+		{"unknown field", "default", "deps", "Bump {{.Version}}", "Update Docker image for web in compose.yml", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.CommitStyle = tt.style
+			cfg.CommitType = "chore"
+			cfg.CommitScope = tt.scope
+			cfg.CommitTemplate = tt.template
+
+			if got := formatCommitMessage(cfg, update); got != tt.want {
+				t.Errorf("formatCommitMessage() = %q, want %q", got, tt.want)
+			}
+			if tt.template == "" {
+				return
+			}
+			got, err := renderCommitTemplate(cfg, update)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderCommitTemplate() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("renderCommitTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
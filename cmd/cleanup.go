@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+var (
+	// cleanupCfg holds the configuration for the cleanup command
+	cleanupCfg *config.Config
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Delete img-upgr branches left without an open merge request",
+	Long: `Delete the img-upgr/ branches of IMG_UPGR_GL_REPO whose merge requests were
+merged or closed, and the ones that never got a merge request once their last
+commit is older than --min-age, leaving branches of open merge requests and
+protected branches untouched.
+
+Examples:
+  img-upgr cleanup            Delete the orphaned branches
+  img-upgr cleanup --dry-run  List the branches that would be deleted`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		if err := runCleanupCommand(ctx, cleanupCfg); err != nil {
+			logger.Error("Cleanup command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+// runCleanupCommand connects to the GitLab project and deletes its orphaned branches
+func runCleanupCommand(ctx context.Context, cfg *config.Config) error {
+	if cfg.GitLabRepo == "" {
+		return fmt.Errorf("%s must be set", config.EnvGitLabRepo)
+	}
+	if err := cfg.ResolveSecrets(ctx); err != nil {
+		return err
+	}
+	if err := cfg.UseProjectToken(); err != nil {
+		return err
+	}
+
+	gitlabClient, err := gitlab.NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("error initializing GitLab client: %w", err)
+	}
+	cfg.GitLabClient = gitlabClient
+
+	return cleanupBranches(ctx, cfg)
+}
+
+// cleanupBranches deletes the img-upgr branches without an open merge request: the ones
+// of merged or closed merge requests, and the ones without any merge request whose last
+// commit is older than the minimum age, as a run may still be about to open it
+func cleanupBranches(ctx context.Context, cfg *config.Config) error {
+	gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+	if !ok {
+		return fmt.Errorf("invalid GitLab client type")
+	}
+
+	branches, err := gitlabClient.ListBranchesWithContext(ctx, gitlab.BranchPrefix)
+	if err != nil {
+		return err
+	}
+
+	mergeRequests, err := gitlabClient.ListMergeRequestsWithContext(ctx, "all", gitlab.BranchPrefix)
+	if err != nil {
+		return err
+	}
+
+	// A branch may have several merge requests, any open one keeps it
+	open := make(map[string]bool)
+	finished := make(map[string]string)
+	for _, mr := range mergeRequests {
+		if mr.State == "opened" || mr.State == "locked" {
+			open[mr.SourceBranch] = true
+		} else {
+			finished[mr.SourceBranch] = fmt.Sprintf("merge request !%d is %s", mr.IID, mr.State)
+		}
+	}
+
+	deleted := 0
+	for _, branch := range branches {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		reason, orphaned := finished[branch.Name]
+		switch {
+		case branch.Protected || open[branch.Name]:
+			continue
+		case !orphaned && time.Since(branch.Commit.CommittedDate) < cfg.CleanupMinAge:
+			logger.Debug("Keeping branch %s without merge request, last commit on %s", branch.Name, branch.Commit.CommittedDate.Format(time.RFC3339))
+			continue
+		case !orphaned:
+			reason = "no merge request"
+		}
+
+		if cfg.DryRun {
+			logger.Info("Would delete branch %s: %s", branch.Name, reason)
+			continue
+		}
+
+		logger.Info("Deleting branch %s: %s", branch.Name, reason)
+		if err := gitlabClient.DeleteBranchWithContext(ctx, branch.Name); err != nil {
+			logger.Error("Error deleting branch %s: %v", branch.Name, err)
+			continue
+		}
+		deleted++
+	}
+
+	logger.Info("Deleted %d of %d img-upgr branches", deleted, len(branches))
+	return nil
+}
+
+// init registers the cleanup command
+func init() {
+	cleanupCfg = config.New()
+	cleanupCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(cleanupCmd)
+
+	cleanupCmd.Flags().BoolVar(&cleanupCfg.DryRun, "dry-run", false, "List the branches that would be deleted without deleting them")
+	cleanupCmd.Flags().DurationVar(&cleanupCfg.CleanupMinAge, "min-age", cleanupCfg.CleanupMinAge,
+		"Age of the last commit of a branch without any merge request before it is deleted")
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitops"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+// imageDetails returns the size of the old and new images of an update and the platforms
+// of the new one, warning about platforms the new tag is no longer published for. It
+// returns an empty string when the registry does not describe the images.
+func imageDetails(cfg *config.Config, update result.UpdateCandidate) string {
+	// Chart versions are not images
+	if update.Kind == gitops.KindChart {
+		return ""
+	}
+
+	resolver, err := newRegistryClient(cfg)
+	if err != nil {
+		logger.Debug("Skipping image details of %s: %v", update.Repository, err)
+		return ""
+	}
+
+	newDetails, err := resolver.FetchImageDetails(update.Repository, update.NewTag)
+	if err != nil {
+		logger.Debug("Could not fetch details of %s:%s: %v", update.Repository, update.NewTag, err)
+		return ""
+	}
+	oldDetails, err := resolver.FetchImageDetails(update.Repository, update.OldTag)
+	if err != nil {
+		logger.Debug("Could not fetch details of %s:%s: %v", update.Repository, update.OldTag, err)
+		oldDetails = nil
+	}
+
+	var sb strings.Builder
+	if newDetails.Size > 0 {
+		if oldDetails != nil && oldDetails.Size > 0 {
+			fmt.Fprintf(&sb, "Image size: `%s` → `%s`\n", registry.FormatSize(oldDetails.Size), registry.FormatSize(newDetails.Size))
+		} else {
+			fmt.Fprintf(&sb, "Image size: `%s`\n", registry.FormatSize(newDetails.Size))
+		}
+	}
+	if len(newDetails.Platforms) > 0 {
+		platforms := make([]string, 0, len(newDetails.Platforms))
+		for _, platform := range newDetails.Platforms {
+			platforms = append(platforms, "`"+platform.String()+"`")
+		}
+		fmt.Fprintf(&sb, "Platforms: %s\n", strings.Join(platforms, ", "))
+	}
+	if missing := newDetails.MissingPlatforms(oldDetails); len(missing) > 0 {
+		platforms := make([]string, 0, len(missing))
+		for _, platform := range missing {
+			platforms = append(platforms, "`"+platform.String()+"`")
+		}
+		fmt.Fprintf(&sb, "\n> **Warning:** `%s` is no longer published for %s, which `%s` supported.\n",
+			update.NewTag, strings.Join(platforms, ", "), update.OldTag)
+	}
+
+	return sb.String()
+}
+
+// registryLinks returns links to the pages of the old and new tags of an update on the
+// web UI of their registry, or a single link when the registry has no page per tag
+func registryLinks(cfg *config.Config, update result.UpdateCandidate) string {
+	if update.Kind == gitops.KindChart {
+		return ""
+	}
+
+	resolver, err := newRegistryClient(cfg)
+	if err != nil {
+		return ""
+	}
+
+	oldURL, err := resolver.TagURL(update.Repository, update.OldTag)
+	if err != nil {
+		logger.Debug("No registry link for %s:%s: %v", update.Repository, update.OldTag, err)
+		return ""
+	}
+	newURL, err := resolver.TagURL(update.Repository, update.NewTag)
+	if err != nil {
+		logger.Debug("No registry link for %s:%s: %v", update.Repository, update.NewTag, err)
+		return ""
+	}
+
+	if oldURL == newURL {
+		return fmt.Sprintf("Registry: [`%s`](%s)\n", update.Repository, newURL)
+	}
+	return fmt.Sprintf("Registry: [`%s`](%s) → [`%s`](%s)\n", update.OldTag, oldURL, update.NewTag, newURL)
+}
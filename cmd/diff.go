@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/policy"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+// diffCfg holds the configuration for the diff command
+var diffCfg *config.Config
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <old-ref> <new-ref>",
+	Short: "Compare two tags of an image",
+	Long: `Compare two tags of the same image repository like merge request descriptions
+do: the version change, the dates the tags were published on when the registry
+lists them (Docker Hub and Harbor), the image sizes and platforms, and the
+package changes when SBOMs are enabled with IMG_UPGR_SBOM or --sbom.
+
+Examples:
+  img-upgr diff nginx:1.25.3 nginx:1.27.0
+  img-upgr diff ghcr.io/org/app:v1.4.0 ghcr.io/org/app:v2.0.0 --sbom auto`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		if err := runDiffCommand(ctx, diffCfg, args[0], args[1]); err != nil {
+			logger.Error("Diff command failed: %v", err)
+			exit(ExitCodeError)
+		}
+	},
+}
+
+// runDiffCommand prints the comparison of two tags of an image repository
+func runDiffCommand(ctx context.Context, cfg *config.Config, oldImage, newImage string) error {
+	update, err := diffUpdate(oldImage, newImage)
+	if err != nil {
+		return err
+	}
+	if err := cfg.ResolveSecrets(ctx); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprint(os.Stdout, formatImageDiff(ctx, cfg, update))
+	return err
+}
+
+// diffUpdate returns the update from one tag of an image repository to another, as
+// compared by the diff command
+func diffUpdate(oldImage, newImage string) (result.UpdateCandidate, error) {
+	oldRef, err := reference.Parse(oldImage)
+	if err != nil {
+		return result.UpdateCandidate{}, err
+	}
+	newRef, err := reference.Parse(newImage)
+	if err != nil {
+		return result.UpdateCandidate{}, err
+	}
+	if oldRef.Tag == "" || newRef.Tag == "" {
+		return result.UpdateCandidate{}, fmt.Errorf("both image references need a tag")
+	}
+	if !registry.SameRepository(oldRef.Repository(), newRef.Repository()) {
+		return result.UpdateCandidate{}, fmt.Errorf("%s and %s are not tags of the same repository", oldImage, newImage)
+	}
+
+	update := result.UpdateCandidate{
+		OldImage:   oldImage,
+		NewImage:   newImage,
+		Repository: oldRef.Repository(),
+		OldTag:     oldRef.Tag,
+		NewTag:     newRef.Tag,
+	}
+	update.Major = policy.Classify(update.OldTag, update.NewTag) == policy.BumpMajor
+	return update, nil
+}
+
+// formatImageDiff describes the differences between the old and new image of an update
+// with the sections of merge request descriptions
+func formatImageDiff(ctx context.Context, cfg *config.Config, update result.UpdateCandidate) string {
+	description := fmt.Sprintf("Repository: `%s`\n", update.Repository)
+	description += fmt.Sprintf("Change: `%s` → `%s` (%s)\n", update.OldTag, update.NewTag, updateBump(update))
+	description += publishDates(cfg, update)
+	description += registryLinks(cfg, update)
+	description += imageDetails(cfg, update)
+	description += sbomDiff(ctx, cfg, update, sbomMaxPackages)
+	return description
+}
+
+// publishDates returns the dates the old and new tags of an update were last pushed, or
+// an empty string when the registry does not list them
+func publishDates(cfg *config.Config, update result.UpdateCandidate) string {
+	resolver, err := newRegistryClient(cfg)
+	if err != nil {
+		logger.Debug("Skipping publish dates of %s: %v", update.Repository, err)
+		return ""
+	}
+
+	infos, err := resolver.FetchTagInfo(update.Repository)
+	if err != nil {
+		logger.Debug("Could not list the tags of %s: %v", update.Repository, err)
+		return ""
+	}
+
+	var oldDate, newDate time.Time
+	for _, info := range infos {
+		switch info.Name {
+		case update.OldTag:
+			oldDate = info.LastUpdated
+		case update.NewTag:
+			newDate = info.LastUpdated
+		}
+	}
+	if oldDate.IsZero() || newDate.IsZero() {
+		return ""
+	}
+
+	days := int(math.Round(newDate.Sub(oldDate).Hours() / 24))
+	return fmt.Sprintf("Published: %s → %s (%+d days)\n", oldDate.Format(time.DateOnly), newDate.Format(time.DateOnly), days)
+}
+
+// init registers the diff command
+func init() {
+	diffCfg = config.New()
+	diffCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVar(&diffCfg.SBOM, "sbom", diffCfg.SBOM,
+		"Compare the packages of the images from their SBOMs (attestation, syft or auto)")
+	diffCmd.Flags().StringVar(&diffCfg.TagCatalog, "tag-catalog", diffCfg.TagCatalog,
+		"Read tags from a catalog written by export-tags instead of the registries")
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+// useFakeSyft puts first in PATH a syft listing the packages of the old tag 1.4.0 of an
+// image, and those of the newer tags
+func useFakeSyft(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\nfor arg; do last=$arg; done\ncase \"$last\" in\n" +
+		`*:1.4.0) echo '{"artifacts": [{"name": "openssl", "version": "3.0.13", "type": "apk"}, {"name": "zlib", "version": "1.3", "type": "apk"}]}' ;;` + "\n" +
+		`*) echo '{"artifacts": [{"name": "openssl", "version": "3.0.14", "type": "apk"}, {"name": "curl", "version": "8.9.0", "type": "apk"}]}' ;;` + "\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "syft"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestFormatImageDiff(t *testing.T) {
+	useFakeSyft(t)
+
+	// The registries are answered by an empty catalog so that no request leaves the test
+	catalog := filepath.Join(t.TempDir(), "catalog.json")
+	if err := os.WriteFile(catalog, []byte(`{"version": 1, "exported_at": "2026-01-01T00:00:00Z", "repositories": {}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		update result.UpdateCandidate
+		sbom   string
+		want   string
+	}{
+		{
+			name:   "tag only",
+			update: result.UpdateCandidate{Repository: "ghcr.io/org/app", OldTag: "1.4.0", NewTag: "1.5.0"},
+			want: "Repository: `ghcr.io/org/app`\n" +
+				"Change: `1.4.0` → `1.5.0` (minor)\n" +
+				"Registry: [`ghcr.io/org/app`](https://ghcr.io/org/app)\n",
+		},
+		{
+			name:   "digest",
+			update: result.UpdateCandidate{Repository: "quay.io/org/app", OldTag: "1.4.0@sha256:aaaa", NewTag: "1.4.0@sha256:bbbb"},
+			want: "Repository: `quay.io/org/app`\n" +
+				"Change: `1.4.0@sha256:aaaa` → `1.4.0@sha256:bbbb` (other)\n" +
+				"Registry: [`quay.io/org/app`](https://quay.io/repository/org/app?tab=tags&tag=1.4.0)\n",
+		},
+		{
+			name:   "added and removed packages",
+			update: result.UpdateCandidate{Repository: "ghcr.io/org/app", OldTag: "1.4.0", NewTag: "2.0.0", Major: true},
+			sbom:   "syft",
+			want: "Repository: `ghcr.io/org/app`\n" +
+				"Change: `1.4.0` → `2.0.0` (major)\n" +
+				"Registry: [`ghcr.io/org/app`](https://ghcr.io/org/app)\n" +
+				"\n<details>\n<summary>Unverified SBOM package changes of ghcr.io/org/app (1 added, 1 removed, 1 changed)</summary>\n\n" +
+				"1 added, 1 removed, 1 changed packages\n" +
+				"\n**Changed:**\n\n- `openssl` 3.0.13 → 3.0.14\n" +
+				"\n**Added:**\n\n- `curl` 8.9.0\n" +
+				"\n**Removed:**\n\n- `zlib` 1.3\n" +
+				"\n</details>\n",
+		},
+		{
+			name:   "no package changes",
+			update: result.UpdateCandidate{Repository: "ghcr.io/org/app", OldTag: "1.5.0", NewTag: "1.5.1"},
+			sbom:   "syft",
+			want: "Repository: `ghcr.io/org/app`\n" +
+				"Change: `1.5.0` → `1.5.1` (patch)\n" +
+				"Registry: [`ghcr.io/org/app`](https://ghcr.io/org/app)\n" +
+				"\nUnverified SBOM: no package changes between `1.5.0` and `1.5.1`\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.TagCatalog = catalog
+			cfg.SBOM = tt.sbom
+
+			if got := formatImageDiff(context.Background(), cfg, tt.update); got != tt.want {
+				t.Errorf("formatImageDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/version"
+)
+
+var (
+	// completionOutput is the file the completion script is written to, stdout when empty
+	completionOutput string
+
+	// completionNoDescriptions disables the descriptions of completions
+	completionNoDescriptions bool
+
+	// manDir is the directory man pages are written to
+	manDir string
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "Generate the shell completion script",
+	Long: `Generate the completion script of img-upgr for the given shell.
+
+Examples:
+  img-upgr completion bash > /etc/bash_completion.d/img-upgr
+  img-upgr completion zsh -o "${fpath[1]}/_img-upgr"
+  img-upgr completion fish -o ~/.config/fish/completions/img-upgr.fish
+  img-upgr completion powershell | Out-String | Invoke-Expression`,
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runCompletionCommand(cmd.Root(), args[0]); err != nil {
+			logger.Error("Completion command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+var docsCmd = &cobra.Command{
+	Use:   "docs",
+	Short: "Generate documentation",
+	Long:  "Generate documentation of the img-upgr commands for installation on the system",
+}
+
+var docsManCmd = &cobra.Command{
+	Use:   "man",
+	Short: "Generate man pages",
+	Long: `Generate a man page for img-upgr and each of its commands. Set
+SOURCE_DATE_EPOCH for reproducible pages.
+
+Examples:
+  img-upgr docs man --dir /usr/local/share/man/man1`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDocsManCommand(cmd.Root()); err != nil {
+			logger.Error("Docs command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+var docsSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the structured output",
+	Long: `Print the JSON Schema of the results printed by check and scan with -o json or
+-o yaml and written to IMG_UPGR_REPORT_FILE. The results carry the schema_version
+of the schema they follow, which only changes when fields are removed, renamed or
+change type.
+
+Examples:
+  img-upgr docs schema > img-upgr.schema.json`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, err := os.Stdout.Write(result.Schema); err != nil {
+			logger.Error("Docs command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+// runCompletionCommand writes the completion script of a shell
+func runCompletionCommand(root *cobra.Command, shell string) error {
+	out := io.Writer(os.Stdout)
+	if completionOutput != "" {
+		file, err := os.Create(completionOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", completionOutput, err)
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				logger.Warn("Failed to close %s: %v", completionOutput, err)
+			}
+		}()
+		out = file
+	}
+
+	includeDescriptions := !completionNoDescriptions
+	switch shell {
+	case "bash":
+		return root.GenBashCompletionV2(out, includeDescriptions)
+	case "zsh":
+		if includeDescriptions {
+			return root.GenZshCompletion(out)
+		}
+		return root.GenZshCompletionNoDesc(out)
+	case "fish":
+		return root.GenFishCompletion(out, includeDescriptions)
+	case "powershell":
+		if includeDescriptions {
+			return root.GenPowerShellCompletionWithDesc(out)
+		}
+		return root.GenPowerShellCompletion(out)
+	default:
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+}
+
+// runDocsManCommand writes the man pages of all commands
+func runDocsManCommand(root *cobra.Command) error {
+	if err := os.MkdirAll(manDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", manDir, err)
+	}
+
+	header := &doc.GenManHeader{
+		Title:   "IMG-UPGR",
+		Section: "1",
+		Source:  "img-upgr " + version.GetVersion(),
+		Manual:  "img-upgr manual",
+	}
+	root.DisableAutoGenTag = true
+	if err := doc.GenManTree(root, header, manDir); err != nil {
+		return fmt.Errorf("failed to generate man pages: %w", err)
+	}
+
+	logger.Info("Wrote man pages to %s", manDir)
+	return nil
+}
+
+// init registers the completion and documentation commands
+func init() {
+	// Replace the default completion command to support writing to a file
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.AddCommand(completionCmd)
+	completionCmd.Flags().StringVarP(&completionOutput, "output", "o", "", "Write the script to a file instead of stdout")
+	completionCmd.Flags().BoolVar(&completionNoDescriptions, "no-descriptions", false, "Disable completion descriptions")
+
+	rootCmd.AddCommand(docsCmd)
+	docsCmd.AddCommand(docsManCmd)
+	docsManCmd.Flags().StringVar(&manDir, "dir", ".", "Directory the man pages are written to")
+	docsCmd.AddCommand(docsSchemaCmd)
+}
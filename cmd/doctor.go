@@ -0,0 +1,361 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/docker"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/validation"
+)
+
+// Doctor check statuses
+const (
+	doctorOK   = "ok"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+// doctorTokenExpiryWarning is how long before its expiry the GitLab token is reported
+const doctorTokenExpiryWarning = 14 * 24 * time.Hour
+
+var (
+	// doctorCfg holds the configuration for the doctor command
+	doctorCfg *config.Config
+
+	// doctorPing checks that a registry answers, replaced in tests
+	doctorPing = registry.Ping
+
+	// doctorRateLimit reads the rate limit of Docker Hub, replaced in tests
+	doctorRateLimit = func(ctx context.Context, cfg *config.Config) (*docker.RateLimit, error) {
+		return newDockerHubClient(cfg).RateLimitWithContext(ctx)
+	}
+)
+
+// doctorResult is the outcome of a single diagnostic
+type doctorResult struct {
+	Name        string
+	Status      string
+	Detail      string
+	Remediation string
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the configuration and connectivity",
+	Long: `Check the configuration from the environment, the availability of git,
+the access to the GitLab API and the scopes of the token, and the
+reachability and rate limits of the registries, printing how to fix
+every problem found. Exits with a non-zero code when a check fails.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		if code := runDoctorCommand(ctx, os.Stdout, doctorCfg); code != ExitCodeSuccess {
+			exit(code)
+		}
+	},
+}
+
+// runDoctorCommand runs all diagnostics, prints their results to w and returns the exit
+// code, an error if a check failed
+func runDoctorCommand(ctx context.Context, w io.Writer, cfg *config.Config) int {
+	var results []doctorResult
+	results = append(results, checkDoctorSecrets(ctx, cfg))
+	results = append(results, checkDoctorConfiguration(cfg)...)
+	results = append(results, checkDoctorGit(cfg))
+	results = append(results, checkDoctorGitLab(ctx, cfg)...)
+	results = append(results, checkDoctorDockerHub(ctx, cfg))
+	results = append(results, checkDoctorRegistries(ctx, cfg)...)
+
+	if !printDoctorResults(w, results) {
+		return ExitCodeError
+	}
+	return ExitCodeSuccess
+}
+
+// checkDoctorSecrets reads the secret files and the Vault and Kubernetes secrets the tokens reference
+func checkDoctorSecrets(ctx context.Context, cfg *config.Config) doctorResult {
+	err := cfg.ResolveSecrets(ctx)
+	if err == nil {
+		err = cfg.UseProjectToken()
+	}
+	if err != nil {
+		return doctorResult{
+			Name:        "secrets",
+			Status:      doctorFail,
+			Detail:      err.Error(),
+			Remediation: "Check the *_FILE paths, VAULT_ADDR and VAULT_TOKEN, or the access of the service account of the pod to the Kubernetes secret",
+		}
+	}
+	return doctorResult{Name: "secrets", Status: doctorOK, Detail: "tokens are readable"}
+}
+
+// checkDoctorConfiguration validates the settings read from the environment
+func checkDoctorConfiguration(cfg *config.Config) []doctorResult {
+	// The scan directory is relative to the repository when one is cloned
+	validated := *cfg
+	if validated.GitLabRepo != "" {
+		validated.ScanDir = ""
+		validated.CreateMR = true
+	}
+
+	var results []doctorResult
+	err := validateConfig(&validated)
+	var validationErrors *validation.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		for _, validationErr := range validationErrors.Errors {
+			results = append(results, doctorResult{
+				Name:        "configuration",
+				Status:      doctorFail,
+				Detail:      fmt.Sprintf("%s: %s", validationErr.Field, validationErr.Message),
+				Remediation: fmt.Sprintf("Fix the %s* environment variable or flag for %s", config.EnvPrefix, validationErr.Field),
+			})
+		}
+		return results
+	} else if err != nil {
+		return []doctorResult{{
+			Name:        "configuration",
+			Status:      doctorFail,
+			Detail:      err.Error(),
+			Remediation: fmt.Sprintf("Fix the %s* environment variables", config.EnvPrefix),
+		}}
+	}
+
+	return []doctorResult{{Name: "configuration", Status: doctorOK, Detail: "environment variables are valid"}}
+}
+
+// checkDoctorGit checks that git is installed when the repository is cloned
+func checkDoctorGit(cfg *config.Config) doctorResult {
+	if cfg.APIOnly {
+		return doctorResult{Name: "git", Status: doctorOK, Detail: "not needed in API-only mode"}
+	}
+
+	output, err := exec.Command("git", "--version").Output()
+	if err != nil {
+		return doctorResult{
+			Name:        "git",
+			Status:      doctorFail,
+			Detail:      fmt.Sprintf("git is not available: %v", err),
+			Remediation: fmt.Sprintf("Install git and add it to PATH, or set %s=true to work through the GitLab API only", config.EnvAPIOnly),
+		}
+	}
+
+	return doctorResult{Name: "git", Status: doctorOK, Detail: strings.TrimSpace(string(output))}
+}
+
+// checkDoctorGitLab checks the access to the project and the scopes of the token
+func checkDoctorGitLab(ctx context.Context, cfg *config.Config) []doctorResult {
+	if cfg.GitLabRepo == "" || cfg.GitLabToken == "" {
+		return []doctorResult{{
+			Name:        "gitlab",
+			Status:      doctorWarn,
+			Detail:      "no repository configured, merge requests cannot be created",
+			Remediation: fmt.Sprintf("Set %s, %s, %s and %s to create merge requests", config.EnvGitLabRepo, config.EnvGitLabUser, config.EnvGitLabToken, config.EnvGitLabEmail),
+		}}
+	}
+
+	client, err := gitlab.NewClient(cfg, gitlab.WithRetry(0, 0))
+	if err != nil {
+		return []doctorResult{{Name: "gitlab", Status: doctorFail, Detail: err.Error(),
+			Remediation: fmt.Sprintf("Check %s", config.EnvGitLabRepo)}}
+	}
+
+	results := []doctorResult{checkDoctorProject(ctx, cfg, client)}
+	if cfg.MRMergeMethod != "" || cfg.MRSquash {
+		results = append(results, checkDoctorMergeSettings(ctx, cfg, client))
+	}
+
+	// Token scopes
+	token, err := client.GetTokenInfoWithContext(ctx)
+	if err != nil {
+		return append(results, doctorResult{
+			Name:        "gitlab token",
+			Status:      doctorWarn,
+			Detail:      fmt.Sprintf("could not read the token scopes: %v", err),
+			Remediation: "Make sure the token is a personal, project or group access token with the api scope",
+		})
+	}
+
+	switch {
+	case !token.Active:
+		results = append(results, doctorResult{Name: "gitlab token", Status: doctorFail,
+			Detail:      fmt.Sprintf("token %s is revoked or expired", token.Name),
+			Remediation: fmt.Sprintf("Create a new token with the api scope and set it in %s", config.EnvGitLabToken)})
+	case !slices.Contains(token.Scopes, "api"):
+		results = append(results, doctorResult{Name: "gitlab token", Status: doctorFail,
+			Detail:      fmt.Sprintf("token %s has scopes %s but merge requests need the api scope", token.Name, strings.Join(token.Scopes, ", ")),
+			Remediation: "Create a token with the api scope, it also covers cloning and pushing"})
+	default:
+		result := doctorResult{Name: "gitlab token", Status: doctorOK,
+			Detail: fmt.Sprintf("token %s has scopes %s", token.Name, strings.Join(token.Scopes, ", "))}
+		if expiresAt, err := time.Parse(time.DateOnly, token.ExpiresAt); err == nil {
+			result.Detail += fmt.Sprintf(", expires on %s", token.ExpiresAt)
+			if time.Until(expiresAt) < doctorTokenExpiryWarning {
+				result.Status = doctorWarn
+				result.Remediation = "Rotate the token before it expires"
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// checkDoctorProject checks that the project is reachable with the token
+func checkDoctorProject(ctx context.Context, cfg *config.Config, client *gitlab.Client) doctorResult {
+	branch, err := client.GetDefaultBranchWithContext(ctx)
+	if err == nil {
+		return doctorResult{Name: "gitlab", Status: doctorOK,
+			Detail: fmt.Sprintf("project %s is reachable, default branch %s", cfg.GitLabRepo, branch)}
+	}
+
+	result := doctorResult{Name: "gitlab", Status: doctorFail, Detail: err.Error()}
+	var apiErr *gitlab.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized:
+		result.Remediation = fmt.Sprintf("The token is invalid or expired, set a valid one in %s", config.EnvGitLabToken)
+	case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusForbidden):
+		result.Remediation = fmt.Sprintf("Check %s and that the token user is at least a developer of the project", config.EnvGitLabRepo)
+	default:
+		result.Remediation = "Check the network access to the GitLab instance, including proxies (HTTPS_PROXY)"
+	}
+	return result
+}
+
+// checkDoctorMergeSettings checks that the merge request settings match the merge settings of the project
+func checkDoctorMergeSettings(ctx context.Context, cfg *config.Config, client *gitlab.Client) doctorResult {
+	project, err := client.GetProjectWithContext(ctx)
+	if err != nil {
+		return doctorResult{Name: "merge settings", Status: doctorWarn, Detail: err.Error()}
+	}
+
+	if mismatches := mergeSettingsMismatches(cfg, project); len(mismatches) > 0 {
+		return doctorResult{Name: "merge settings", Status: doctorWarn, Detail: strings.Join(mismatches, "; "),
+			Remediation: fmt.Sprintf("Align %s and %s with the merge request settings of the project", config.EnvMRMergeMethod, config.EnvMRSquash)}
+	}
+	return doctorResult{Name: "merge settings", Status: doctorOK,
+		Detail: fmt.Sprintf("project merges with %s, squash option %s", project.MergeMethod, project.SquashOption)}
+}
+
+// checkDoctorDockerHub checks that Docker Hub is reachable and reports its rate limit
+func checkDoctorDockerHub(ctx context.Context, cfg *config.Config) doctorResult {
+	rateLimit, err := doctorRateLimit(ctx, cfg)
+	if err != nil {
+		return doctorResult{
+			Name:        "docker hub",
+			Status:      doctorFail,
+			Detail:      fmt.Sprintf("Docker Hub is not reachable: %v", err),
+			Remediation: fmt.Sprintf("Check the network access to hub.docker.com, or route images through a mirror with %s", config.EnvMirrors),
+		}
+	}
+
+	if rateLimit == nil {
+		return doctorResult{Name: "docker hub", Status: doctorOK, Detail: "reachable, no rate limit reported"}
+	}
+
+	result := doctorResult{Name: "docker hub", Status: doctorOK,
+		Detail: fmt.Sprintf("reachable, %d of %d requests left", rateLimit.Remaining, rateLimit.Limit)}
+	if rateLimit.Remaining == 0 {
+		result.Status = doctorWarn
+		result.Remediation = "Wait for the rate limit to reset or use a registry mirror"
+		if !rateLimit.Reset.IsZero() {
+			result.Remediation = fmt.Sprintf("Wait until %s or use a registry mirror", rateLimit.Reset.Format(time.RFC3339))
+		}
+	}
+	return result
+}
+
+// checkDoctorRegistries checks that the configured registries and mirrors are reachable
+func checkDoctorRegistries(ctx context.Context, cfg *config.Config) []doctorResult {
+	hosts := make(map[string]bool)
+	if registryTypes, err := cfg.RegistryTypes(); err == nil {
+		for host := range registryTypes {
+			hosts[host] = true
+		}
+	}
+	if mirrors, err := cfg.RegistryMirrors(); err == nil {
+		for _, target := range mirrors {
+			host, _, _ := strings.Cut(target, "/")
+			hosts[host] = true
+		}
+	}
+
+	names := make([]string, 0, len(hosts))
+	for host := range hosts {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+
+	var results []doctorResult
+	for _, host := range names {
+		if err := doctorPing(ctx, host); err != nil {
+			results = append(results, doctorResult{
+				Name:        "registry " + host,
+				Status:      doctorFail,
+				Detail:      fmt.Sprintf("not reachable: %v", err),
+				Remediation: fmt.Sprintf("Check the host name in %s or %s and the network access to it", config.EnvRegistries, config.EnvMirrors),
+			})
+			continue
+		}
+		results = append(results, doctorResult{Name: "registry " + host, Status: doctorOK, Detail: "reachable"})
+	}
+	return results
+}
+
+// printDoctorResults prints the results of the diagnostics followed by a summary of
+// their statuses, and returns false if one failed
+func printDoctorResults(w io.Writer, results []doctorResult) bool {
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+
+	var warnings, failures int
+	for _, result := range results {
+		mark := green("✓")
+		switch result.Status {
+		case doctorWarn:
+			mark = yellow("!")
+			warnings++
+		case doctorFail:
+			mark = red("✗")
+			failures++
+		}
+
+		_, _ = fmt.Fprintf(w, "%s %-14s %s\n", mark, result.Name, result.Detail)
+		if result.Remediation != "" {
+			_, _ = fmt.Fprintf(w, "  %-14s → %s\n", "", result.Remediation)
+		}
+	}
+
+	summary := fmt.Sprintf("%d checks, %d passed, %d warnings, %d failed", len(results), len(results)-warnings-failures, warnings, failures)
+	if failures > 0 {
+		_, _ = fmt.Fprintf(w, "\n%s, see the remediation above\n", summary)
+		return false
+	}
+	_, _ = fmt.Fprintf(w, "\n%s\n", summary)
+	return true
+}
+
+// init registers the doctor command
+func init() {
+	doctorCfg = config.New()
+	doctorCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(doctorCmd)
+}
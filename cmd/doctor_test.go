@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/docker"
+)
+
+// useDoctorRegistries answers the registry checks of the doctor with the registries up,
+// and Docker Hub with the requests left
+func useDoctorRegistries(t *testing.T, up map[string]bool, remaining int) {
+	t.Helper()
+	ping, rateLimit := doctorPing, doctorRateLimit
+	t.Cleanup(func() { doctorPing, doctorRateLimit = ping, rateLimit })
+
+	doctorPing = func(ctx context.Context, host string) error {
+		if !up[host] {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	doctorRateLimit = func(ctx context.Context, cfg *config.Config) (*docker.RateLimit, error) {
+		return &docker.RateLimit{Limit: 100, Remaining: remaining}, nil
+	}
+}
+
+// useConfigFile loads a configuration file for a test, cleared after it
+func useConfigFile(t *testing.T, content string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.LoadFile(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		empty := filepath.Join(dir, "empty.yaml")
+		if err := os.WriteFile(empty, nil, 0600); err == nil {
+			_ = config.LoadFile(empty, "")
+		}
+	})
+}
+
+func TestRunDoctorCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		// file is the configuration file of the run
+		file         string
+		noRepository bool
+		revokedToken bool
+		noGit        bool
+		remaining    int
+		// want are the lines of the output
+		want     []string
+		wantCode int
+	}{
+		{
+			name:      "healthy",
+			file:      "registry_mirrors: docker.io=mirror.example.com/dockerhub\n",
+			remaining: 50,
+			want: []string{"✓ secrets", "✓ configuration", "✓ git            not needed in API-only mode",
+				"✓ gitlab         project", "✓ gitlab token   token bot has scopes api", "✓ docker hub     reachable, 50 of 100 requests left",
+				"✓ registry mirror.example.com reachable", "7 checks, 7 passed, 0 warnings, 0 failed"},
+			wantCode: ExitCodeSuccess,
+		},
+		{
+			name:         "warnings only",
+			file:         "log_level: debug\n",
+			noRepository: true,
+			want: []string{"! gitlab         no repository configured", "! docker hub     reachable, 0 of 100 requests left",
+				"5 checks, 3 passed, 2 warnings, 0 failed"},
+			wantCode: ExitCodeSuccess,
+		},
+		{
+			name:      "invalid configuration",
+			file:      "output_format: xml\n",
+			remaining: 50,
+			want:      []string{"✗ configuration  OutputFormat: invalid output format: xml", "1 failed, see the remediation above"},
+			wantCode:  ExitCodeError,
+		},
+		{
+			name:      "registry down",
+			file:      "registries: down.example.com=harbor\n",
+			remaining: 50,
+			want: []string{"✗ registry down.example.com not reachable: connection refused",
+				"  " + strings.Repeat(" ", 14) + " → Check the host name", "7 checks, 6 passed, 0 warnings, 1 failed"},
+			wantCode: ExitCodeError,
+		},
+		{
+			name:         "revoked token",
+			revokedToken: true,
+			remaining:    50,
+			want:         []string{"✗ gitlab token   token bot is revoked or expired", "6 checks, 5 passed, 0 warnings, 1 failed"},
+			wantCode:     ExitCodeError,
+		},
+		{
+			name:      "git missing",
+			noGit:     true,
+			remaining: 50,
+			want:      []string{"✗ git            git is not available", "6 checks, 5 passed, 0 warnings, 1 failed"},
+			wantCode:  ExitCodeError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfigFile(t, tt.file)
+			useDoctorRegistries(t, map[string]bool{"mirror.example.com": true}, tt.remaining)
+			fakeCfg, fake := newFakeGitLab(t, nil)
+			fake.revokedToken = tt.revokedToken
+
+			cfg := config.New()
+			cfg.LoadFromEnv()
+			cfg.APIOnly = !tt.noGit
+			if tt.noGit {
+				t.Setenv("PATH", t.TempDir())
+			}
+			if !tt.noRepository {
+				cfg.GitLabRepo, cfg.GitLabUser = fakeCfg.GitLabRepo, fakeCfg.GitLabUser
+				cfg.GitLabToken, cfg.GitLabEmail = fakeCfg.GitLabToken, fakeCfg.GitLabEmail
+			}
+
+			var out bytes.Buffer
+			if code := runDoctorCommand(context.Background(), &out, cfg); code != tt.wantCode {
+				t.Errorf("runDoctorCommand() = %d, want %d", code, tt.wantCode)
+			}
+			for _, line := range tt.want {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("output =\n%s\nwant %q", out.String(), line)
+				}
+			}
+		})
+	}
+}
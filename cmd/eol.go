@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/compose"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/eol"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/update"
+)
+
+// checkEndOfLife flags the images of the compose files whose release series reached its
+// end of life. With EOLUpgrade, updates to the next supported series are added to the
+// updates unless an update of the same kind is already proposed for the service.
+func checkEndOfLife(ctx context.Context, cfg *config.Config, files []string, updates []result.UpdateCandidate, registryClient registry.Client) ([]result.EndOfLifeNotice, []result.UpdateCandidate) {
+	client := eol.NewClient()
+	platform, _ := cfg.RequiredPlatform()
+
+	var notices []result.EndOfLifeNotice
+	for _, filePath := range files {
+		if strings.HasSuffix(filePath, config.TerraformFileExtension) || (cfg.GitOps && !cfg.IsComposeFile(filePath)) {
+			continue
+		}
+
+		composeFile, err := compose.ParseComposeFile(filePath)
+		if err != nil {
+			// Already reported by the update check
+			continue
+		}
+
+		images := composeFile.GetImages()
+		lines := composeFile.GetImageLines()
+		serviceNames := make([]string, 0, len(images))
+		for serviceName := range images {
+			serviceNames = append(serviceNames, serviceName)
+		}
+		sort.Strings(serviceNames)
+
+		for _, serviceName := range serviceNames {
+			imageName := images[serviceName]
+			notice, next := endOfLifeStatus(ctx, client, filePath, serviceName, imageName)
+			if notice == nil {
+				continue
+			}
+			notice.Line = lines[serviceName]
+			notices = append(notices, *notice)
+
+			if !cfg.EOLUpgrade || next == "" {
+				continue
+			}
+			if upgrade := endOfLifeUpgrade(ctx, cfg, notice, next, platform, registryClient); upgrade != nil && !hasUpdate(updates, *upgrade) {
+				PrintInfo("  Suggesting %s of the supported series %s for %s", upgrade.NewTag, next, serviceName)
+				updates = append(updates, *upgrade)
+			}
+		}
+	}
+
+	return notices, updates
+}
+
+// endOfLifeStatus returns the notice of an image whose series reached its end of life
+// and the next supported series, or nil when the image is supported or unknown
+func endOfLifeStatus(ctx context.Context, client *eol.Client, filePath, serviceName, imageName string) (*result.EndOfLifeNotice, string) {
+	product, ok := eol.Product(update.ImageRepository(imageName))
+	if !ok {
+		return nil, ""
+	}
+
+	status, err := client.Check(ctx, product, update.ImageTag(imageName))
+	if err != nil {
+		logger.Warn("Could not check the end of life of %s: %v", imageName, err)
+		return nil, ""
+	}
+	if status == nil || !status.Cycle.Ended {
+		return nil, ""
+	}
+
+	notice := &result.EndOfLifeNotice{
+		FilePath:    filePath,
+		ServiceName: serviceName,
+		Image:       imageName,
+		Product:     product,
+		Cycle:       status.Cycle.Cycle,
+	}
+	if !status.Cycle.EOL.IsZero() {
+		notice.EOL = status.Cycle.EOL.Format("2006-01-02")
+	}
+	if status.Next != nil {
+		notice.NextCycle = status.Next.Cycle
+	}
+
+	PrintInfo("  ! %s %s of service %s reached its end of life", product, status.Cycle.Cycle, serviceName)
+	return notice, notice.NextCycle
+}
+
+// endOfLifeUpgrade returns the update of an end of life image to the latest tag of the
+// next supported series, nil if the registry has none
+func endOfLifeUpgrade(ctx context.Context, cfg *config.Config, notice *result.EndOfLifeNotice, next string, platform *registry.Platform, registryClient registry.Client) *result.UpdateCandidate {
+	constraint, err := semver.NewConstraint(next + ".x")
+	if err != nil {
+		logger.Debug("Cannot suggest series %s of %s: %v", next, notice.Image, err)
+		return nil
+	}
+
+	excludeTags, err := cfg.ExcludedTags(update.ImageRepository(notice.Image))
+	if err != nil {
+		logger.Debug("Cannot suggest series %s of %s: %v", next, notice.Image, err)
+		return nil
+	}
+
+	info, err := update.CheckImageWithContext(ctx, notice.Image, registryClient, update.Options{Constraint: constraint, AllowMajor: true, Platform: platform, ExcludeTags: excludeTags})
+	if err != nil || !info.HasUpdate {
+		logger.Debug("No tag of series %s found for %s", next, notice.Image)
+		return nil
+	}
+
+	return &result.UpdateCandidate{
+		FilePath:    notice.FilePath,
+		ServiceName: notice.ServiceName,
+		OldImage:    notice.Image,
+		NewImage:    fmt.Sprintf("%s:%s", suggestedRepository(cfg, info.Repository), info.LatestTag),
+		Repository:  info.Repository,
+		OldTag:      info.Tag,
+		NewTag:      info.LatestTag,
+		Major:       info.IsMajor,
+		Line:        notice.Line,
+	}
+}
+
+// hasUpdate reports whether an update of the same file, service and kind is in updates
+func hasUpdate(updates []result.UpdateCandidate, candidate result.UpdateCandidate) bool {
+	for _, existing := range updates {
+		if existing.FilePath == candidate.FilePath && existing.ServiceName == candidate.ServiceName && existing.Major == candidate.Major {
+			return true
+		}
+	}
+	return false
+}
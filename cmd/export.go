@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+)
+
+// defaultCatalogFile is the file export-tags writes by default
+const defaultCatalogFile = "img-upgr-tags.json"
+
+var (
+	// exportCfg holds the configuration for the export-tags command
+	exportCfg *config.Config
+	// exportOutput is the catalog file written
+	exportOutput string
+	// exportRepositories are the repositories exported instead of the ones of the scanned files
+	exportRepositories []string
+)
+
+var exportTagsCmd = &cobra.Command{
+	Use:   "export-tags [file or directory]",
+	Short: "Export the tags of the scanned images for air-gapped runs",
+	Long: `Scan the files like the check command and write every tag of the images found,
+and the digests of the pinned ones, to a catalog file. Run it on a host with
+access to the registries, then copy the file to the air-gapped environment and
+point IMG_UPGR_TAG_CATALOG or --tag-catalog to it. With --repository, the given
+repositories are exported instead, with the digests and last updates of all
+their tags when the registry lists them (Docker Hub and Harbor).
+
+Examples:
+  img-upgr export-tags                         Export the tags of the images of IMG_UPGR_SCANDIR
+  img-upgr export-tags deploy/ -o tags.json    Export the tags of the images below deploy/
+  img-upgr export-tags -r nginx -r redis       Export the tags of two repositories`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		if err := runExportTagsCommand(ctx, exportCfg, args); err != nil {
+			logger.Error("Export command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+// runExportTagsCommand checks the scanned files against the registries and records the
+// tags and digests looked up in a catalog
+func runExportTagsCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	if cfg.TagCatalog != "" {
+		return fmt.Errorf("tags cannot be exported from a catalog, unset %s", config.EnvTagCatalog)
+	}
+
+	if len(exportRepositories) > 0 {
+		if len(args) > 0 {
+			return fmt.Errorf("a path cannot be scanned when repositories are given")
+		}
+		if err := cfg.ResolveSecrets(ctx); err != nil {
+			return err
+		}
+		return exportRepositoryTags(ctx, cfg, exportRepositories)
+	}
+
+	if err := initializeAndValidate(ctx, cfg); err != nil {
+		return fmt.Errorf("initialization failed: %w", err)
+	}
+	defer gitlab.CleanupRepository(cfg)
+
+	files, err := determineFilesToScan(cfg, args)
+	if err != nil {
+		return fmt.Errorf("failed to determine files to scan: %w", err)
+	}
+
+	resolver, err := newRegistryClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure registries: %w", err)
+	}
+
+	// Every tag is recorded, the platform is checked by the air-gapped run if at all
+	cfg.Platform = ""
+
+	catalog := registry.NewCatalog()
+	_, fileErrors, err := processComposeFilesWithContext(ctx, cfg, nil, files, registry.NewRecorder(resolver, catalog))
+	if err != nil {
+		return fmt.Errorf("error processing files: %w", err)
+	}
+	for _, fileError := range fileErrors {
+		logger.Warn("Tags of %s in %s not exported: %s", fileError.ServiceName, fileError.FilePath, fileError.Error)
+	}
+
+	if err := catalog.Write(exportOutput); err != nil {
+		return err
+	}
+	logger.Info("Exported the tags of %d repositories to %s", len(catalog.Repositories), exportOutput)
+	return nil
+}
+
+// exportRepositoryTags writes the tags of a list of repositories to the catalog file,
+// failing only when none of them could be exported
+func exportRepositoryTags(ctx context.Context, cfg *config.Config, repositories []string) error {
+	resolver, err := newRegistryClient(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure registries: %w", err)
+	}
+
+	catalog := registry.NewCatalog()
+	for _, repo := range repositories {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		infos, err := resolver.FetchTagInfo(repo)
+		if err != nil {
+			logger.Warn("Tags of %s not exported: %v", repo, err)
+			continue
+		}
+		catalog.RecordTagInfo(repo, infos)
+	}
+
+	if len(catalog.Repositories) == 0 {
+		return fmt.Errorf("none of the %d repositories could be exported", len(repositories))
+	}
+	if err := catalog.Write(exportOutput); err != nil {
+		return err
+	}
+	logger.Info("Exported the tags of %d of %d repositories to %s", len(catalog.Repositories), len(repositories), exportOutput)
+	return nil
+}
+
+// init registers the export-tags command
+func init() {
+	exportCfg = config.New()
+	exportCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(exportTagsCmd)
+
+	exportTagsCmd.Flags().StringVarP(&exportOutput, "output", "o", defaultCatalogFile, "Catalog file to write")
+	exportTagsCmd.Flags().StringSliceVarP(&exportRepositories, "repository", "r", nil,
+		"Repository to export instead of the images of the scanned files, can be repeated or comma-separated")
+	exportTagsCmd.Flags().BoolVar(&exportCfg.TrackDigests, "track-digests", exportCfg.TrackDigests,
+		"Also export the digests of digest-pinned images on mutable tags")
+}
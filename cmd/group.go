@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/changelog"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/policy"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+)
+
+// updateGroup is a set of updates proposed together in a single merge request
+type updateGroup struct {
+	Directory string
+	// Image is the image updated to by the group, empty for directory groups
+	Image        string
+	TargetBranch string
+	Branch       string
+	// Major groups hold the major updates of the directory, proposed separately
+	Major   bool
+	Updates []result.UpdateCandidate
+}
+
+// groupDirectory returns the directory grouping an update: the directory of its file
+// relative to the repository root, truncated to the configured depth
+func groupDirectory(cfg *config.Config, update result.UpdateCandidate) string {
+	dir := path.Dir(repoRelativePath(cfg, update.FilePath))
+	if cfg.GroupDepth <= 0 || dir == "." {
+		return dir
+	}
+
+	parts := strings.Split(dir, "/")
+	if len(parts) > cfg.GroupDepth {
+		parts = parts[:cfg.GroupDepth]
+	}
+	return strings.Join(parts, "/")
+}
+
+// groupImage returns the image grouping an update: its repository in short canonical form
+// and its new tag, so every file using the image is updated together however it is written
+func groupImage(update result.UpdateCandidate) string {
+	return reference.Familiarize(update.Repository) + ":" + update.NewTag
+}
+
+// groupUpdates splits updates into groups sharing a target branch and, depending on the
+// grouping mode, a directory or the image they update to
+func groupUpdates(ctx context.Context, cfg *config.Config, repoConfig *config.RepoConfig, updates []result.UpdateCandidate) ([]*updateGroup, error) {
+	groups := make(map[string]*updateGroup)
+	var keys []string
+
+	for _, update := range updates {
+		targetBranch, err := targetBranchFor(ctx, cfg, repoConfig, update)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get target branch: %w", err)
+		}
+
+		var dir, image string
+		if cfg.GroupBy == "image" {
+			image = groupImage(update)
+		} else {
+			dir = groupDirectory(cfg, update)
+		}
+		key := fmt.Sprintf("%s\x00%s\x00%s\x00%t", dir, image, targetBranch, update.Major)
+		group, ok := groups[key]
+		if !ok {
+			group = &updateGroup{Directory: dir, Image: image, TargetBranch: targetBranch, Major: update.Major}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		group.Updates = append(group.Updates, update)
+	}
+
+	sort.Strings(keys)
+
+	// Branch names are stable so later runs find the merge request of a group again
+	branches := make(map[string]int)
+	sorted := make([]*updateGroup, 0, len(keys))
+	for _, key := range keys {
+		group := groups[key]
+		name := "root"
+		if group.Image != "" {
+			name = "image-" + sanitizeBranchComponent(strings.NewReplacer("/", "-", ":", "-").Replace(group.Image))
+		} else if group.Directory != "." {
+			name = sanitizeBranchComponent(strings.ReplaceAll(group.Directory, "/", "-"))
+		}
+		if group.Major {
+			name += "-major"
+		}
+		branches[name]++
+		if branches[name] > 1 {
+			name += "-" + sanitizeBranchComponent(group.TargetBranch)
+		}
+		group.Branch = gitlab.BranchPrefix + "group-" + name
+		sorted = append(sorted, group)
+	}
+
+	return sorted, nil
+}
+
+// createGroupedMergeRequests opens or refreshes one merge request per group of updates,
+// returning the updates proposed by an open merge request
+func createGroupedMergeRequests(ctx context.Context, cfg *config.Config, repoConfig *config.RepoConfig, updates []result.UpdateCandidate) ([]result.UpdateCandidate, error) {
+	gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+	if !ok {
+		return nil, fmt.Errorf("invalid GitLab client type")
+	}
+
+	groups, err := groupUpdates(ctx, cfg, repoConfig, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open merge requests of groups are found by their branch
+	openMergeRequests := make(map[string]gitlab.MergeRequestResponse)
+	mergeRequests, err := gitlabClient.ListMergeRequestsWithContext(ctx, "opened", gitlab.BranchPrefix)
+	if err != nil {
+		if err := openMergeRequestsError(cfg, err); err != nil {
+			return nil, err
+		}
+	}
+	for _, mr := range mergeRequests {
+		openMergeRequests[mr.SourceBranch] = mr
+	}
+	budget := newMergeRequestBudget(cfg, len(mergeRequests))
+	defer budget.report()
+
+	// New merge requests are given to the owners of the files they update
+	owners, err := newOwnerAssignment(cfg, repoConfig, len(mergeRequests))
+	if err != nil {
+		return nil, err
+	}
+
+	// Failures are retried, then reported together once every group was tried
+	var failures mergeRequestFailures
+	var proposed []result.UpdateCandidate
+
+	for _, group := range groups {
+		// Check for context cancellation
+		select {
+		case <-ctx.Done():
+			return proposed, context.Cause(ctx)
+		default:
+		}
+
+		mr, exists := openMergeRequests[group.Branch]
+		if exists && sameMarkers(gitlab.ParseUpdateMarkers(mr.Description), groupMarkers(cfg, group.Updates)) {
+			logger.Info("Merge request !%d already proposes the %d updates of %s", mr.IID, len(group.Updates), groupName(group))
+			proposed = append(proposed, group.Updates...)
+			continue
+		}
+
+		// Respect the limits on open merge requests, refreshing existing ones is always allowed
+		if !exists && !budget.take(fmt.Sprintf("%s: %d updates", groupName(group), len(group.Updates))) {
+			continue
+		}
+
+		var creation mergeRequestCreation
+		if err := retryMergeRequest(ctx, cfg, groupName(group), func(ctx context.Context) error {
+			return proposeGroup(ctx, cfg, gitlabClient, owners, &creation, group, mr, exists)
+		}); err != nil {
+			failures.add(groupName(group), err)
+			continue
+		}
+		proposed = append(proposed, group.Updates...)
+	}
+
+	return proposed, failures.result(cfg)
+}
+
+// proposeGroup pushes the branch of a group and opens its merge request, or refreshes
+// the open merge request of the group
+func proposeGroup(ctx context.Context, cfg *config.Config, gitlabClient *gitlab.Client, owners *ownerAssignment, creation *mergeRequestCreation, group *updateGroup, mr gitlab.MergeRequestResponse, exists bool) error {
+	var pipeline *gitlab.PipelineResponse
+	if exists {
+		pipeline = previousPipeline(ctx, cfg, gitlabClient, mr)
+	}
+
+	applied, err := pushUpdates(ctx, cfg, group.Branch, group.TargetBranch, true, group.Updates, func(applied []result.UpdateCandidate) string {
+		return formatGroupCommitMessage(cfg, &updateGroup{Directory: group.Directory, Image: group.Image, Major: group.Major, Updates: applied})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prepare branch %s: %w", group.Branch, err)
+	}
+	group.Updates = applied
+
+	title := formatGroupMergeRequestTitle(group)
+	description := formatGroupMergeRequestDescription(ctx, cfg, group)
+
+	if exists {
+		logger.Info("Refreshing merge request !%d for %s", mr.IID, groupName(group))
+		if _, err := gitlabClient.UpdateMergeRequestWithContext(ctx, mr.IID, keepDraft(mr, title), description); err != nil {
+			return fmt.Errorf("failed to update merge request !%d: %w", mr.IID, err)
+		}
+		postRefreshNote(ctx, cfg, gitlabClient, mr, groupMarkers(cfg, group.Updates), pipeline)
+		return nil
+	}
+
+	opts := mergeRequestOptions(cfg, groupBump(group))
+	owners.apply(ctx, cfg, gitlabClient, &opts, group.Updates)
+
+	logger.Info("Creating merge request for %s targeting %s", groupName(group), group.TargetBranch)
+	if err := creation.create(ctx, gitlabClient, group.Branch, group.TargetBranch, title, description, opts); err != nil {
+		return err
+	}
+
+	logger.Info("Created merge request successfully for %s", groupName(group))
+	return nil
+}
+
+// groupBump returns the most severe bump of the updates of a group
+func groupBump(group *updateGroup) policy.Bump {
+	bumps := make([]policy.Bump, 0, len(group.Updates))
+	for _, update := range group.Updates {
+		bumps = append(bumps, updateBump(update))
+	}
+	return policy.Highest(bumps...)
+}
+
+// groupMarkers returns the markers of the updates of a group
+func groupMarkers(cfg *config.Config, updates []result.UpdateCandidate) []gitlab.UpdateMarker {
+	markers := make([]gitlab.UpdateMarker, 0, len(updates))
+	for _, update := range updates {
+		markers = append(markers, updateMarker(cfg, update))
+	}
+	return markers
+}
+
+// sameMarkers returns true if both lists propose the same updates, in any order
+func sameMarkers(a, b []gitlab.UpdateMarker) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	keys := make(map[string]int)
+	for _, marker := range a {
+		keys[marker.Key()]++
+	}
+	for _, marker := range b {
+		keys[marker.Key()]--
+		if keys[marker.Key()] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// groupName returns the name of a group used in titles and commit messages
+func groupName(group *updateGroup) string {
+	if group.Image != "" {
+		return group.Image
+	}
+	if group.Directory == "." {
+		return "repository root"
+	}
+	return group.Directory
+}
+
+// formatGroupCommitMessage builds the commit message of a group using the configured style
+func formatGroupCommitMessage(cfg *config.Config, group *updateGroup) string {
+	if cfg.CommitStyle == "conventional" {
+		prefix := cfg.CommitType
+		if cfg.CommitScope != "" {
+			prefix += "(" + cfg.CommitScope + ")"
+		}
+		if group.Image != "" {
+			return fmt.Sprintf("%s: update %d files to %s", prefix, len(group.Updates), group.Image)
+		}
+		return fmt.Sprintf("%s: update %d images in %s", prefix, len(group.Updates), groupName(group))
+	}
+	if group.Image != "" {
+		return fmt.Sprintf("Update Docker images to %s", group.Image)
+	}
+	return fmt.Sprintf("Update Docker images in %s", groupName(group))
+}
+
+// formatGroupMergeRequestTitle builds the title of the merge request of a group
+func formatGroupMergeRequestTitle(group *updateGroup) string {
+	var title string
+	if group.Image != "" {
+		title = fmt.Sprintf("Update %d services to %s", len(group.Updates), group.Image)
+		if len(group.Updates) == 1 {
+			update := group.Updates[0]
+			title = fmt.Sprintf("Update %s from %s to %s", update.ServiceName, update.OldTag, group.Image)
+		}
+	} else if len(group.Updates) == 1 {
+		update := group.Updates[0]
+		title = fmt.Sprintf("Update %s in %s from %s to %s", update.ServiceName, groupName(group), update.OldTag, update.NewTag)
+	} else {
+		title = fmt.Sprintf("Update %d images in %s", len(group.Updates), groupName(group))
+	}
+	if group.Major {
+		title += " (major)"
+	}
+	return title
+}
+
+// formatGroupMergeRequestDescription lists the updates of a group and embeds their markers
+func formatGroupMergeRequestDescription(ctx context.Context, cfg *config.Config, group *updateGroup) string {
+	description := "Automated update of Docker images by img-upgr\n\n"
+	if group.Image != "" {
+		description += fmt.Sprintf("Image: `%s`\n\n", group.Image)
+	} else {
+		description += fmt.Sprintf("Directory: `%s`\n\n", group.Directory)
+	}
+	description += "| Service | File | Repository | Update |\n"
+	description += "| --- | --- | --- | --- |\n"
+	for _, update := range group.Updates {
+		description += fmt.Sprintf("| `%s` | `%s` | `%s` | `%s` → `%s` |\n",
+			update.ServiceName, path.Base(repoRelativePath(cfg, update.FilePath)), update.Repository, update.OldTag, update.NewTag)
+	}
+	for _, update := range group.Updates {
+		details := registryLinks(cfg, update) + imageDetails(cfg, update)
+		if update.Verification != "" {
+			details += fmt.Sprintf("Provenance: cosign %s\n", update.Verification)
+		}
+		if details != "" {
+			description += fmt.Sprintf("\n**%s** (`%s`):\n\n%s", update.ServiceName, update.Repository, details)
+		}
+	}
+	if group.Major {
+		description += majorUpdateWarning
+	}
+	// The package changes and release notes of the updates share the length of a single update
+	for _, update := range group.Updates {
+		description += sbomDiff(ctx, cfg, update, sbomMaxPackages/len(group.Updates)+1)
+	}
+	for _, update := range group.Updates {
+		description += releaseNotes(ctx, cfg, update, changelog.DefaultMaxLength/len(group.Updates))
+	}
+	description += fmt.Sprintf("\nGenerated: %s by run `%s`\n", time.Now().Format(time.RFC3339), runid.FromContext(ctx))
+	for _, marker := range groupMarkers(cfg, group.Updates) {
+		description += "\n" + marker.String()
+	}
+
+	return description
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+func TestGroupDirectory(t *testing.T) {
+	tests := []struct {
+		file  string
+		depth int
+		want  string
+	}{
+		{"/repo/docker-compose.yml", 0, "."},
+		{"/repo/docker-compose.yml", 2, "."},
+		{"/repo/apps/web/docker-compose.yml", 0, "apps/web"},
+		{"/repo/apps/web/docker-compose.yml", 1, "apps"},
+		{"/repo/apps/web/docker-compose.yml", 2, "apps/web"},
+		// A depth larger than the path keeps the whole directory
+		{"/repo/apps/web/docker-compose.yml", 5, "apps/web"},
+		{"/repo/apps/web/prod/docker-compose.yml", 2, "apps/web"},
+	}
+	for _, tt := range tests {
+		cfg := config.New()
+		cfg.TempDir = "/repo"
+		cfg.GroupDepth = tt.depth
+		update := result.UpdateCandidate{FilePath: tt.file}
+		if got := groupDirectory(cfg, update); got != tt.want {
+			t.Errorf("groupDirectory(%s, depth %d) = %s, want %s", tt.file, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestGroupUpdatesByDirectory(t *testing.T) {
+	update := func(file, service string, major bool) result.UpdateCandidate {
+		return result.UpdateCandidate{FilePath: "/repo/" + file, ServiceName: service, Repository: "library/nginx", OldTag: "1.25", NewTag: "1.27", Major: major}
+	}
+	updates := []result.UpdateCandidate{
+		update("docker-compose.yml", "proxy", false),
+		update("apps/web/docker-compose.yml", "web", false),
+		update("apps/web/prod/docker-compose.yml", "web-prod", false),
+		update("apps/api/docker-compose.yml", "api", false),
+		update("apps/api/docker-compose.yml", "db", true),
+		update("legacy/apps/docker-compose.yml", "old", false),
+	}
+	repoConfig := &config.RepoConfig{TargetBranches: []config.TargetBranchRule{
+		{Path: "legacy/", Branch: "release"},
+		{Path: "**", Branch: "main"},
+	}}
+
+	type group struct {
+		Directory, TargetBranch, Branch string
+		Major                           bool
+		Services                        []string
+	}
+	tests := []struct {
+		name  string
+		depth int
+		want  []group
+	}{
+		{"full directories", 0, []group{
+			{".", "main", "img-upgr/group-root", false, []string{"proxy"}},
+			{"apps/api", "main", "img-upgr/group-apps-api", false, []string{"api"}},
+			{"apps/api", "main", "img-upgr/group-apps-api-major", true, []string{"db"}},
+			{"apps/web", "main", "img-upgr/group-apps-web", false, []string{"web"}},
+			{"apps/web/prod", "main", "img-upgr/group-apps-web-prod", false, []string{"web-prod"}},
+			{"legacy/apps", "release", "img-upgr/group-legacy-apps", false, []string{"old"}},
+		}},
+		{"depth 1", 1, []group{
+			{".", "main", "img-upgr/group-root", false, []string{"proxy"}},
+			{"apps", "main", "img-upgr/group-apps", false, []string{"web", "web-prod", "api"}},
+			{"apps", "main", "img-upgr/group-apps-major", true, []string{"db"}},
+			{"legacy", "release", "img-upgr/group-legacy", false, []string{"old"}},
+		}},
+		{"depth larger than the paths", 10, []group{
+			{".", "main", "img-upgr/group-root", false, []string{"proxy"}},
+			{"apps/api", "main", "img-upgr/group-apps-api", false, []string{"api"}},
+			{"apps/api", "main", "img-upgr/group-apps-api-major", true, []string{"db"}},
+			{"apps/web", "main", "img-upgr/group-apps-web", false, []string{"web"}},
+			{"apps/web/prod", "main", "img-upgr/group-apps-web-prod", false, []string{"web-prod"}},
+			{"legacy/apps", "release", "img-upgr/group-legacy-apps", false, []string{"old"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.TempDir = "/repo"
+			cfg.GroupBy = "directory"
+			cfg.GroupDepth = tt.depth
+
+			groups, err := groupUpdates(t.Context(), cfg, repoConfig, updates)
+			if err != nil {
+				t.Fatalf("groupUpdates() error = %v", err)
+			}
+			got := make([]group, 0, len(groups))
+			for _, g := range groups {
+				var services []string
+				for _, update := range g.Updates {
+					services = append(services, update.ServiceName)
+				}
+				got = append(got, group{g.Directory, g.TargetBranch, g.Branch, g.Major, services})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupUpdates() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupUpdatesBranchPerTarget(t *testing.T) {
+	cfg := config.New()
+	cfg.TempDir = "/repo"
+	cfg.GroupBy = "directory"
+	cfg.GroupDepth = 1
+	// The same directory targeting two branches gets a branch per target
+	repoConfig := &config.RepoConfig{TargetBranches: []config.TargetBranchRule{
+		{Path: "apps/legacy/", Branch: "release/1.x"},
+		{Path: "**", Branch: "main"},
+	}}
+	updates := []result.UpdateCandidate{
+		{FilePath: "/repo/apps/web/docker-compose.yml", ServiceName: "web"},
+		{FilePath: "/repo/apps/legacy/docker-compose.yml", ServiceName: "old"},
+	}
+
+	groups, err := groupUpdates(t.Context(), cfg, repoConfig, updates)
+	if err != nil {
+		t.Fatalf("groupUpdates() error = %v", err)
+	}
+	var branches []string
+	for _, group := range groups {
+		branches = append(branches, group.Branch)
+	}
+	if want := []string{"img-upgr/group-apps", "img-upgr/group-apps-release-1.x"}; !reflect.DeepEqual(branches, want) {
+		t.Errorf("branches = %v, want %v", branches, want)
+	}
+}
+
+func TestSameMarkers(t *testing.T) {
+	web := gitlab.UpdateMarker{File: "docker-compose.yml", Service: "web", Repository: "nginx", OldTag: "1.25", NewTag: "1.27"}
+	api := gitlab.UpdateMarker{File: "docker-compose.yml", Service: "api", Repository: "node", OldTag: "20", NewTag: "22"}
+	newer := web
+	newer.NewTag = "1.28"
+
+	tests := []struct {
+		name string
+		a, b []gitlab.UpdateMarker
+		want bool
+	}{
+		{"empty", nil, nil, true},
+		{"same order", []gitlab.UpdateMarker{web, api}, []gitlab.UpdateMarker{web, api}, true},
+		{"other order", []gitlab.UpdateMarker{web, api}, []gitlab.UpdateMarker{api, web}, true},
+		{"missing update", []gitlab.UpdateMarker{web, api}, []gitlab.UpdateMarker{web}, false},
+		{"other new tag", []gitlab.UpdateMarker{web, api}, []gitlab.UpdateMarker{newer, api}, false},
+		{"duplicates", []gitlab.UpdateMarker{web, web}, []gitlab.UpdateMarker{web, api}, false},
+	}
+	for _, tt := range tests {
+		if got := sameMarkers(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameMarkers(%s) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFormatGroup(t *testing.T) {
+	web := result.UpdateCandidate{FilePath: "/repo/apps/web/docker-compose.yml", ServiceName: "web", Repository: "library/nginx", OldTag: "1.25", NewTag: "1.27"}
+	api := result.UpdateCandidate{FilePath: "/repo/apps/api/docker-compose.yml", ServiceName: "api", Repository: "library/node", OldTag: "20", NewTag: "22"}
+
+	tests := []struct {
+		name         string
+		group        *updateGroup
+		style        string
+		wantTitle    string
+		wantCommit   string
+		wantHeadline string
+	}{
+		{"directory", &updateGroup{Directory: "apps", Updates: []result.UpdateCandidate{web, api}}, "",
+			"Update 2 images in apps", "Update Docker images in apps", "Directory: `apps`"},
+		{"single update", &updateGroup{Directory: "apps", Updates: []result.UpdateCandidate{web}}, "",
+			"Update web in apps from 1.25 to 1.27", "Update Docker images in apps", "Directory: `apps`"},
+		{"root", &updateGroup{Directory: ".", Updates: []result.UpdateCandidate{web, api}, Major: true}, "",
+			"Update 2 images in repository root (major)", "Update Docker images in repository root", "Directory: `.`"},
+		{"image", &updateGroup{Image: "nginx:1.27", Updates: []result.UpdateCandidate{web, web}}, "",
+			"Update 2 services to nginx:1.27", "Update Docker images to nginx:1.27", "Image: `nginx:1.27`"},
+		{"single image update", &updateGroup{Image: "nginx:1.27", Updates: []result.UpdateCandidate{web}}, "",
+			"Update web from 1.25 to nginx:1.27", "Update Docker images to nginx:1.27", "Image: `nginx:1.27`"},
+		{"conventional directory", &updateGroup{Directory: "apps", Updates: []result.UpdateCandidate{web, api}}, "conventional",
+			"Update 2 images in apps", "chore(deps): update 2 images in apps", "Directory: `apps`"},
+		{"conventional image", &updateGroup{Image: "nginx:1.27", Updates: []result.UpdateCandidate{web, web}}, "conventional",
+			"Update 2 services to nginx:1.27", "chore(deps): update 2 files to nginx:1.27", "Image: `nginx:1.27`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.TempDir = "/repo"
+			cfg.CommitStyle = tt.style
+			cfg.CommitType = "chore"
+			cfg.CommitScope = "deps"
+
+			if got := formatGroupMergeRequestTitle(tt.group); got != tt.wantTitle {
+				t.Errorf("formatGroupMergeRequestTitle() = %q, want %q", got, tt.wantTitle)
+			}
+			if got := formatGroupCommitMessage(cfg, tt.group); got != tt.wantCommit {
+				t.Errorf("formatGroupCommitMessage() = %q, want %q", got, tt.wantCommit)
+			}
+
+			description := formatGroupMergeRequestDescription(t.Context(), cfg, tt.group)
+			if !strings.Contains(description, tt.wantHeadline) {
+				t.Errorf("formatGroupMergeRequestDescription() = %q, want %q", description, tt.wantHeadline)
+			}
+			for _, update := range tt.group.Updates {
+				row := "| `" + update.ServiceName + "` | `docker-compose.yml` | `" + update.Repository + "` | `" + update.OldTag + "` → `" + update.NewTag + "` |"
+				if !strings.Contains(description, row) {
+					t.Errorf("formatGroupMergeRequestDescription() = %q, want the row %q", description, row)
+				}
+			}
+			if strings.Contains(description, majorUpdateWarning) != tt.group.Major {
+				t.Errorf("formatGroupMergeRequestDescription() major warning = %t, want %t", !tt.group.Major, tt.group.Major)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/compose"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+)
+
+var (
+	// initForce overwrites an existing configuration file
+	initForce bool
+	// initYes accepts the detected settings without asking
+	initYes bool
+	// initTargetBranch is the branch the merge requests target
+	initTargetBranch string
+	// initRegistries are host=type pairs set on the command line
+	initRegistries []string
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init [directory]",
+	Short: "Generate a repository configuration file",
+	Long: `Generate a ` + config.RepoConfigFile + ` file at the root of a repository.
+
+The compose files of the directory are detected, and the hosts of the
+registries their images come from. In a terminal, the registry type of each
+host without a known adapter is asked for; use --yes or --registry to generate
+the file without questions. Registry adapters decide where credentials are
+sent, so they are printed for ` + config.EnvRegistries + ` instead of being written
+to the file.
+
+Examples:
+  img-upgr init                                        Configure the current directory
+  img-upgr init ./infra --target-branch develop        Send merge requests to develop
+  img-upgr init --yes --registry harbor.example.com=harbor`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+
+		if err := runInitCommand(dir); err != nil {
+			logger.Error("Init command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+// initSettings holds what is detected and chosen for the configuration file
+type initSettings struct {
+	ComposeFiles []string
+	Registries   map[string]string
+	// Unconfigured lists the hosts that need an adapter but have none
+	Unconfigured []string
+	TargetBranch string
+}
+
+// runInitCommand is the main function for the init command
+func runInitCommand(dir string) error {
+	path := filepath.Join(dir, config.RepoConfigFile)
+	if _, err := os.Stat(path); err == nil && !initForce {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+	}
+
+	settings, err := detectInitSettings(dir)
+	if err != nil {
+		return err
+	}
+	settings.TargetBranch = initTargetBranch
+
+	// Set the registries given on the command line
+	for _, pair := range initRegistries {
+		host, registryType, ok := strings.Cut(pair, "=")
+		if !ok || !slices.Contains(registry.ValidTypes, registryType) {
+			return fmt.Errorf("invalid registry %q, expected host=type with type one of: %s",
+				pair, strings.Join(registry.ValidTypes, ", "))
+		}
+		settings.Registries[host] = registryType
+		settings.Unconfigured = slices.DeleteFunc(settings.Unconfigured, func(h string) bool { return h == host })
+	}
+
+	if !initYes && isTerminal(os.Stdin) {
+		if err := askRegistryTypes(os.Stdin, os.Stdout, settings); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(path, renderRepoConfig(settings), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	PrintInfo("Wrote %s with %d compose files", path, len(settings.ComposeFiles))
+	if registries := formatRegistries(settings); registries != "" {
+		PrintInfo("Set %s=%s in the environment of the runs", config.EnvRegistries, registries)
+	}
+	for _, host := range settings.Unconfigured {
+		logger.Warn("No adapter configured for registry %s, set its type in %s", host, config.EnvRegistries)
+	}
+	return nil
+}
+
+// detectInitSettings finds the compose files of a directory and the registries of their images
+func detectInitSettings(dir string) (*initSettings, error) {
+	scanCfg := config.New()
+	scanCfg.ScanDir = dir
+
+	composeFiles, err := scanCfg.FindComposeFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find compose files: %w", err)
+	}
+
+	settings := &initSettings{Registries: make(map[string]string)}
+	hosts := make(map[string]bool)
+	for _, file := range composeFiles {
+		relPath, err := filepath.Rel(dir, file)
+		if err != nil {
+			relPath = file
+		}
+		settings.ComposeFiles = append(settings.ComposeFiles, filepath.ToSlash(relPath))
+
+		composeFile, err := compose.ParseComposeFile(file)
+		if err != nil {
+			logger.Warn("Skipping %s: %v", relPath, err)
+			continue
+		}
+		for _, image := range composeFile.GetImages() {
+			if host, _ := registry.SplitHost(image); host != "" {
+				hosts[host] = true
+			}
+		}
+	}
+	sort.Strings(settings.ComposeFiles)
+
+	for host := range hosts {
+		if !needsRegistryAdapter(host) {
+			continue
+		}
+		if registryType := guessRegistryType(host); registryType != "" {
+			settings.Registries[host] = registryType
+			continue
+		}
+		settings.Unconfigured = append(settings.Unconfigured, host)
+	}
+	sort.Strings(settings.Unconfigured)
+
+	return settings, nil
+}
+
+// needsRegistryAdapter reports whether a host is neither Docker Hub nor a cloud registry detected automatically
+func needsRegistryAdapter(host string) bool {
+	return !registry.IsDockerHub(host) &&
+		!registry.ECRHostPattern.MatchString(host) &&
+		!registry.GoogleHostPattern.MatchString(host)
+}
+
+// guessRegistryType guesses the adapter of a registry from its host name
+func guessRegistryType(host string) string {
+	host = strings.ToLower(host)
+	switch {
+	case strings.Contains(host, registry.TypeHarbor):
+		return registry.TypeHarbor
+	case strings.Contains(host, registry.TypeArtifactory), strings.Contains(host, "jfrog"):
+		return registry.TypeArtifactory
+	}
+	return ""
+}
+
+// askRegistryTypes asks the registry type of every host without a known adapter
+func askRegistryTypes(in io.Reader, out io.Writer, settings *initSettings) error {
+	reader := bufio.NewReader(in)
+
+	var unconfigured []string
+	for _, host := range settings.Unconfigured {
+		for {
+			_, _ = fmt.Fprintf(out, "Registry type of %s (%s, Enter to skip): ", host, strings.Join(registry.ValidTypes, ", "))
+
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("failed to read registry type: %w", err)
+			}
+
+			registryType := strings.ToLower(strings.TrimSpace(line))
+			if registryType == "" {
+				unconfigured = append(unconfigured, host)
+				break
+			}
+			if slices.Contains(registry.ValidTypes, registryType) {
+				settings.Registries[host] = registryType
+				break
+			}
+			_, _ = fmt.Fprintf(out, "Unknown registry type: %s\n", registryType)
+		}
+	}
+	settings.Unconfigured = unconfigured
+
+	return nil
+}
+
+// renderRepoConfig renders the configuration file, commenting out the settings left to their defaults
+func renderRepoConfig(settings *initSettings) []byte {
+	var b strings.Builder
+	b.WriteString("# img-upgr repository configuration, settings from the environment take precedence\n")
+
+	b.WriteString("\n# Branch targeted by the merge requests of matching files, first match wins\n")
+	if settings.TargetBranch != "" {
+		fmt.Fprintf(&b, "target_branches:\n  - path: \"**\"\n    branch: %s\n", settings.TargetBranch)
+	} else {
+		b.WriteString("# target_branches:\n#   - path: prod/\n#     branch: release\n")
+	}
+
+	b.WriteString("\n# Compose files to check, by default the docker-compose, compose and docker-stack files\n")
+	if len(settings.ComposeFiles) > 0 {
+		b.WriteString("# Detected:\n")
+		for _, file := range settings.ComposeFiles {
+			fmt.Fprintf(&b, "#   %s\n", file)
+		}
+	}
+	b.WriteString("# compose_patterns:\n#   - \"deploy/**/*.yml\"\n")
+
+	b.WriteString("\n# Paths to skip, in addition to the ignored files of .gitignore\n")
+	b.WriteString("# exclude:\n#   - legacy/\n")
+
+	// Registries decide where credentials are sent, so they are only listed for the environment
+	b.WriteString("\n# Registry adapters and mirrors are not read from this file, set them in the environment:\n")
+	if registries := formatRegistries(settings); registries != "" {
+		fmt.Fprintf(&b, "# %s=%s\n", config.EnvRegistries, registries)
+	} else {
+		fmt.Fprintf(&b, "# %s=harbor.example.com=harbor\n", config.EnvRegistries)
+	}
+	fmt.Fprintf(&b, "# %s=docker.io=mirror.example.com/hub\n", config.EnvMirrors)
+
+	return []byte(b.String())
+}
+
+// formatRegistries formats the registries of the settings as the value of the registries
+// variable, sorted by host
+func formatRegistries(settings *initSettings) string {
+	pairs := make([]string, 0, len(settings.Registries))
+	for host, registryType := range settings.Registries {
+		pairs = append(pairs, host+"="+registryType)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// init registers the init command and its flags
+func init() {
+	rootCmd.AddCommand(initCmd)
+
+	initCmd.Flags().BoolVarP(&initForce, "force", "f", false, "Overwrite an existing configuration file")
+	initCmd.Flags().BoolVarP(&initYes, "yes", "y", false, "Use the detected settings without asking")
+	initCmd.Flags().StringVar(&initTargetBranch, "target-branch", "", "Branch targeted by all merge requests")
+	initCmd.Flags().StringSliceVar(&initRegistries, "registry", nil, "Registry adapter as host=type, can be repeated")
+}
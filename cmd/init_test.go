@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+)
+
+func TestAskRegistryTypes(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		wantRegistries   map[string]string
+		wantUnconfigured []string
+		wantPrompts      int
+		wantErr          bool
+	}{
+		{"types", "harbor\nartifactory\n", map[string]string{"a.example.com": "harbor", "b.example.com": "artifactory"}, nil, 2, false},
+		{"case and spaces", "  Harbor \n\n", map[string]string{"a.example.com": "harbor"}, []string{"b.example.com"}, 2, false},
+		{"skipped", "\n\n", map[string]string{}, []string{"a.example.com", "b.example.com"}, 2, false},
+		{"unknown type asked again", "quay\nharbor\nartifactory\n", map[string]string{"a.example.com": "harbor", "b.example.com": "artifactory"}, nil, 3, false},
+		{"last line without newline", "harbor\nartifactory", map[string]string{"a.example.com": "harbor", "b.example.com": "artifactory"}, nil, 2, false},
+		{"input closed", "harbor\n", nil, nil, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := &initSettings{
+				Registries:   make(map[string]string),
+				Unconfigured: []string{"a.example.com", "b.example.com"},
+			}
+			var out bytes.Buffer
+
+			err := askRegistryTypes(strings.NewReader(tt.input), &out, settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("askRegistryTypes() error = %v, want error %v", err, tt.wantErr)
+			}
+			if prompts := strings.Count(out.String(), "Registry type of"); prompts != tt.wantPrompts {
+				t.Errorf("prompts = %d, want %d: %q", prompts, tt.wantPrompts, out.String())
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(settings.Registries, tt.wantRegistries) {
+				t.Errorf("Registries = %v, want %v", settings.Registries, tt.wantRegistries)
+			}
+			if !reflect.DeepEqual(settings.Unconfigured, tt.wantUnconfigured) {
+				t.Errorf("Unconfigured = %v, want %v", settings.Unconfigured, tt.wantUnconfigured)
+			}
+		})
+	}
+}
+
+func TestRenderRepoConfig(t *testing.T) {
+	settings := &initSettings{
+		ComposeFiles: []string{"docker-compose.yml"},
+		Registries:   map[string]string{"harbor.example.com": registry.TypeHarbor, "jfrog.example.com:8443": registry.TypeArtifactory},
+		TargetBranch: "develop",
+	}
+	content := string(renderRepoConfig(settings))
+
+	// Registries are printed for the environment, never written as settings of the file
+	want := "# " + config.EnvRegistries + "=harbor.example.com=harbor,jfrog.example.com:8443=artifactory\n"
+	if !strings.Contains(content, want) {
+		t.Errorf("renderRepoConfig() = %q, want %q", content, want)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, config.RepoConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repoConfig, err := config.LoadRepoConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadRepoConfig() of the rendered file error = %v", err)
+	}
+	if len(repoConfig.Registries) != 0 || len(repoConfig.Mirrors) != 0 {
+		t.Errorf("rendered registries = %v, mirrors = %v, want none", repoConfig.Registries, repoConfig.Mirrors)
+	}
+	if branch, ok := repoConfig.TargetBranch("docker-compose.yml"); !ok || branch != "develop" {
+		t.Errorf("TargetBranch() = %s, %t, want develop", branch, ok)
+	}
+}
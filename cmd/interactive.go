@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+var (
+	// interactiveCfg holds the configuration for the interactive command
+	interactiveCfg *config.Config
+)
+
+var interactiveCmd = &cobra.Command{
+	Use:   "interactive [file]",
+	Short: "Select which found updates to apply",
+	Long: `Check docker-compose files for image updates and pick the ones to apply
+from a numbered checkbox list in the terminal.
+
+The list is a line prompt rather than a full-screen UI driven by the arrow keys:
+every update starts checked, type the numbers or ranges of the updates to toggle
+(e.g. 1 3 5-7), a for all, n for none or q to quit, and press Enter on an empty
+line to confirm.
+
+When IMG_UPGR_GL_REPO is set, the repository is cloned and a merge request is
+opened for each selected update. Otherwise the selected updates are written
+directly to the local compose files.
+
+Examples:
+  img-upgr interactive                       Pick updates for the configured repository
+  img-upgr interactive ./docker-compose.yml  Pick updates to apply to a local file`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		if err := runInteractiveCommand(ctx, args); err != nil {
+			logger.Error("Interactive command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+// runInteractiveCommand is the main function for the interactive command
+func runInteractiveCommand(ctx context.Context, args []string) error {
+	if !isTerminal(os.Stdin) {
+		return fmt.Errorf("interactive mode requires a terminal on standard input")
+	}
+
+	// Initialize and validate configuration
+	if err := initializeAndValidate(ctx, interactiveCfg); err != nil {
+		return fmt.Errorf("initialization failed: %w", err)
+	}
+
+	// Clean up repository when done
+	defer gitlab.CleanupRepository(interactiveCfg)
+
+	// Determine the files to scan
+	composeFiles, err := determineFilesToScan(interactiveCfg, args)
+	if err != nil {
+		return fmt.Errorf("failed to determine files to scan: %w", err)
+	}
+
+	// Create registry client
+	registryClient, err := newRegistryClient(interactiveCfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure registries: %w", err)
+	}
+
+	// Process files and collect updates
+	updates, _, err := processComposeFilesWithContext(ctx, interactiveCfg, nil, composeFiles, registryClient)
+	if err != nil {
+		return fmt.Errorf("error processing compose files: %w", err)
+	}
+
+	if len(updates) == 0 {
+		logger.Info("No updates found across all files")
+		return nil
+	}
+
+	selected, err := selectUpdates(os.Stdin, os.Stdout, interactiveCfg, updates)
+	if err != nil {
+		return err
+	}
+
+	if len(selected) == 0 {
+		logger.Info("No updates selected")
+		return nil
+	}
+
+	// Open merge requests when working on a cloned repository
+	if interactiveCfg.ClonedRepo {
+		if _, err := createMergeRequestsForUpdates(ctx, interactiveCfg, selected); err != nil {
+			return fmt.Errorf("failed to create merge requests: %w", err)
+		}
+		return nil
+	}
+
+	// Otherwise edit the local files in place
+	for _, update := range selected {
+		logger.Info("Updating %s: %s → %s", update.ServiceName, update.OldImage, update.NewImage)
+		if err := applyUpdateToFile(update); err != nil {
+			logger.Error("Error updating file %s: %v", update.FilePath, err)
+		}
+	}
+
+	return nil
+}
+
+// selectUpdates shows the updates as a numbered checkbox list and lets the user toggle
+// them by number or range until the selection is confirmed with an empty line
+func selectUpdates(in io.Reader, out io.Writer, cfg *config.Config, updates []result.UpdateCandidate) ([]result.UpdateCandidate, error) {
+	checked := make([]bool, len(updates))
+	for i := range checked {
+		checked[i] = true
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		_, _ = fmt.Fprintln(out)
+		for i, update := range updates {
+			box := "[ ]"
+			if checked[i] {
+				box = "[x]"
+			}
+			_, _ = fmt.Fprintf(out, "%s %2d) %s (%s): %s → %s\n", box, i+1,
+				update.ServiceName, cfg.GetRelativePath(update.FilePath), update.OldTag, update.NewTag)
+		}
+		_, _ = fmt.Fprint(out, "\nToggle numbers or ranges (e.g. 1 3 5-7), 'a' for all, 'n' for none, 'q' to quit, Enter to confirm: ")
+
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("failed to read selection: %w", err)
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			break
+		}
+
+		for _, field := range fields {
+			switch strings.ToLower(field) {
+			case "a":
+				setAll(checked, true)
+			case "n":
+				setAll(checked, false)
+			case "q":
+				return nil, nil
+			default:
+				first, last, ok := parseChoice(field, len(updates))
+				if !ok {
+					_, _ = fmt.Fprintf(out, "Ignoring invalid choice: %s\n", field)
+					continue
+				}
+				for i := first; i <= last; i++ {
+					checked[i-1] = !checked[i-1]
+				}
+			}
+		}
+	}
+
+	var selected []result.UpdateCandidate
+	for i, update := range updates {
+		if checked[i] {
+			selected = append(selected, update)
+		}
+	}
+
+	return selected, nil
+}
+
+// parseChoice returns the first and last numbers of a choice, a number or a range
+// such as 2-4, within the n entries of the list
+func parseChoice(choice string, n int) (int, int, bool) {
+	start, end, isRange := strings.Cut(choice, "-")
+	first, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, false
+	}
+	last := first
+	if isRange {
+		if last, err = strconv.Atoi(end); err != nil {
+			return 0, 0, false
+		}
+	}
+	if first < 1 || last < first || last > n {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// setAll sets every entry of a checkbox list to the given value
+func setAll(checked []bool, value bool) {
+	for i := range checked {
+		checked[i] = value
+	}
+}
+
+// isTerminal reports whether the file is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func init() {
+	interactiveCfg = config.New()
+	interactiveCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(interactiveCmd)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+func TestSelectUpdates(t *testing.T) {
+	var updates []result.UpdateCandidate
+	for _, service := range []string{"web", "api", "cache", "db", "queue"} {
+		updates = append(updates, result.UpdateCandidate{FilePath: "/repo/compose.yml", ServiceName: service, OldTag: "1.0.0", NewTag: "1.1.0"})
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+		// wantInvalid are the choices reported as invalid
+		wantInvalid []string
+		wantErr     bool
+	}{
+		{"all checked by default", "\n", []string{"web", "api", "cache", "db", "queue"}, nil, false},
+		{"none", "n\n\n", nil, nil, false},
+		{"none then all", "n\na\n\n", []string{"web", "api", "cache", "db", "queue"}, nil, false},
+		{"toggle numbers", "1 3\n\n", []string{"api", "db", "queue"}, nil, false},
+		{"toggle twice", "2\n2\n\n", []string{"web", "api", "cache", "db", "queue"}, nil, false},
+		{"range", "n 2-4\n\n", []string{"api", "cache", "db"}, nil, false},
+		{"single entry range", "n 5-5\n\n", []string{"queue"}, nil, false},
+		{"case insensitive", "N\n\n", nil, nil, false},
+		{"invalid choices ignored", "n 0 6 x 4-2 3-9 1-\n1\n\n", []string{"web"}, []string{"0", "6", "x", "4-2", "3-9", "1-"}, false},
+		{"quit", "1\nq\n", nil, nil, false},
+		{"end of input before confirmation", "n 1\n", nil, nil, true},
+		{"end of input", "", nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			selected, err := selectUpdates(strings.NewReader(tt.input), &out, config.New(), updates)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectUpdates() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for _, update := range selected {
+				got = append(got, update.ServiceName)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectUpdates() = %v, want %v", got, tt.want)
+			}
+			for _, choice := range tt.wantInvalid {
+				if !strings.Contains(out.String(), "Ignoring invalid choice: "+choice+"\n") {
+					t.Errorf("output = %q, want %s reported as invalid", out.String(), choice)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+const (
+	// problemEndOfLife and problemError are the kinds of problems reported in issues
+	problemEndOfLife = "eol"
+	problemError     = "error"
+)
+
+// imageProblem is an image without a valid upgrade path, reported in a GitLab issue
+type imageProblem struct {
+	marker      gitlab.IssueMarker
+	title       string
+	description string
+}
+
+// imageProblems returns the problems of a result: the images of series past their end
+// of life, and the files and images that could not be checked
+func imageProblems(cfg *config.Config, res *result.ScanResult) []imageProblem {
+	var problems []imageProblem
+
+	for _, notice := range res.EndOfLife {
+		relPath := repoRelativePath(cfg, notice.FilePath)
+		marker := gitlab.IssueMarker{File: relPath, Service: notice.ServiceName, Problem: problemEndOfLife}
+
+		description := fmt.Sprintf("Service `%s` in `%s` uses `%s`: %s.\n", notice.ServiceName, relPath, notice.Image, notice.Message())
+		if notice.NextCycle == "" {
+			description += "\nNo newer series is supported, the image has to be replaced.\n"
+		}
+		problems = append(problems, imageProblem{
+			marker:      marker,
+			title:       fmt.Sprintf("%s %s of %s reached its end of life", notice.Product, notice.Cycle, notice.ServiceName),
+			description: description + "\n" + marker.String(),
+		})
+	}
+
+	for _, fileError := range res.Errors {
+		relPath := repoRelativePath(cfg, fileError.FilePath)
+		marker := gitlab.IssueMarker{File: relPath, Service: fileError.ServiceName, Problem: problemError}
+
+		title := fmt.Sprintf("Cannot check %s", filepath.Base(relPath))
+		description := fmt.Sprintf("img-upgr could not check `%s`:\n\n```\n%s\n```\n", relPath, fileError.Error)
+		if fileError.ServiceName != "" {
+			title = fmt.Sprintf("Cannot check the image of %s in %s", fileError.ServiceName, filepath.Base(relPath))
+			description = fmt.Sprintf("img-upgr could not check the image of service `%s` in `%s`:\n\n```\n%s\n```\n", fileError.ServiceName, relPath, fileError.Error)
+		}
+		problems = append(problems, imageProblem{
+			marker:      marker,
+			title:       title,
+			description: description + "\n" + marker.String(),
+		})
+	}
+
+	return problems
+}
+
+// reportIssues opens an issue for each problem of a result, or updates the issue opened
+// for it by a previous run, so images without a valid upgrade path are tracked
+func reportIssues(ctx context.Context, cfg *config.Config, res *result.ScanResult) error {
+	problems := imageProblems(cfg, res)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+	if !ok {
+		return fmt.Errorf("invalid GitLab client type")
+	}
+
+	issues, err := gitlabClient.ListIssuesWithContext(ctx, gitlab.IssueLabel)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]gitlab.IssueResponse)
+	for _, issue := range issues {
+		if marker, ok := gitlab.ParseIssueMarker(issue.Description); ok {
+			existing[marker.Key()] = issue
+		}
+	}
+
+	for _, problem := range problems {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		issue, found := existing[problem.marker.Key()]
+		switch {
+		case !found:
+			_, err = gitlabClient.CreateIssueWithContext(ctx, problem.title, problem.description, []string{gitlab.IssueLabel})
+		case issue.Title != problem.title || issue.Description != problem.description:
+			_, err = gitlabClient.UpdateIssueWithContext(ctx, issue.IID, problem.title, problem.description)
+		default:
+			logger.Debug("Issue #%d is up to date: %s", issue.IID, issue.Title)
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to report %s: %v", problem.title, err)
+		}
+	}
+
+	return nil
+}
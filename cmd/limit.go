@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// mergeRequestBudget enforces the limits on open merge requests and on merge
+// requests created by a run, and remembers the updates deferred to later runs
+type mergeRequestBudget struct {
+	remaining int
+	unlimited bool
+	deferred  []string
+}
+
+// newMergeRequestBudget computes how many merge requests a run may open given
+// the number of img-upgr merge requests already open in the project
+func newMergeRequestBudget(cfg *config.Config, openCount int) *mergeRequestBudget {
+	budget := &mergeRequestBudget{unlimited: cfg.MRLimit == 0 && cfg.MRRunLimit == 0}
+	if budget.unlimited {
+		return budget
+	}
+
+	budget.remaining = -1
+	if cfg.MRLimit > 0 {
+		budget.remaining = max(cfg.MRLimit-openCount, 0)
+		logger.Debug("%d of %d merge requests already open", openCount, cfg.MRLimit)
+	}
+	if cfg.MRRunLimit > 0 && (budget.remaining < 0 || cfg.MRRunLimit < budget.remaining) {
+		budget.remaining = cfg.MRRunLimit
+	}
+
+	logger.Info("Up to %d new merge requests can be created in this run", budget.remaining)
+	return budget
+}
+
+// openMergeRequestsError handles a failure to list the open merge requests: the limit on
+// open merge requests cannot be enforced without them, so the run stops when one is set
+func openMergeRequestsError(cfg *config.Config, err error) error {
+	if cfg.MRLimit > 0 {
+		return fmt.Errorf("failed to list open merge requests to enforce the limit of %d: %w", cfg.MRLimit, err)
+	}
+
+	logger.Warn("Could not list open merge requests, existing merge requests will not be refreshed: %v", err)
+	return nil
+}
+
+// take reserves a merge request, returning false and deferring the update when the limit is reached
+func (b *mergeRequestBudget) take(name string) bool {
+	if b.unlimited {
+		return true
+	}
+	if b.remaining <= 0 {
+		b.deferred = append(b.deferred, name)
+		return false
+	}
+
+	b.remaining--
+	return true
+}
+
+// report logs the updates deferred because of the limits
+func (b *mergeRequestBudget) report() {
+	if len(b.deferred) == 0 {
+		return
+	}
+
+	logger.Warn("Merge request limit reached, %d updates deferred to a later run:", len(b.deferred))
+	for _, name := range b.deferred {
+		logger.Warn("  %s", name)
+	}
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+)
+
+func TestMergeRequestBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		runLimit  int
+		openCount int
+		want      int
+	}{
+		{"unlimited", 0, 0, 10, -1},
+		{"open limit", 5, 0, 2, 3},
+		{"open limit reached", 5, 0, 5, 0},
+		{"open limit exceeded", 5, 0, 8, 0},
+		{"run limit", 0, 2, 10, 2},
+		{"run limit below open limit", 5, 2, 1, 2},
+		{"open limit below run limit", 5, 4, 3, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.MRLimit = tt.limit
+			cfg.MRRunLimit = tt.runLimit
+			budget := newMergeRequestBudget(cfg, tt.openCount)
+
+			// Take more merge requests than any limit allows and count those granted
+			granted := 0
+			for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+				if budget.take(name) {
+					granted++
+				}
+			}
+
+			want := tt.want
+			if want < 0 {
+				want = 6
+			}
+			if granted != want {
+				t.Errorf("granted %d merge requests, want %d", granted, want)
+			}
+			if len(budget.deferred) != 6-want {
+				t.Errorf("deferred = %v, want %d updates", budget.deferred, 6-want)
+			}
+			if want < 6 && !slices.Contains(budget.deferred, "f") {
+				t.Errorf("deferred = %v, want the last update deferred", budget.deferred)
+			}
+		})
+	}
+}
+
+func TestOpenMergeRequestsError(t *testing.T) {
+	listErr := errors.New("503 Service Unavailable")
+
+	cfg := config.New()
+	if err := openMergeRequestsError(cfg, listErr); err != nil {
+		t.Errorf("openMergeRequestsError() without limit = %v, want the failure ignored", err)
+	}
+
+	// The limit cannot be enforced without knowing how many merge requests are open
+	cfg.MRLimit = 5
+	if err := openMergeRequestsError(cfg, listErr); !errors.Is(err, listErr) {
+		t.Errorf("openMergeRequestsError() with limit = %v, want %v", err, listErr)
+	}
+
+	// The limit of a run alone does not depend on the open merge requests
+	cfg.MRLimit = 0
+	cfg.MRRunLimit = 2
+	if err := openMergeRequestsError(cfg, listErr); err != nil {
+		t.Errorf("openMergeRequestsError() with run limit = %v, want the failure ignored", err)
+	}
+}
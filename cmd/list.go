@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/compose"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitops"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/terraform"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/validation"
+)
+
+// listFormats are the output formats of the list command
+var listFormats = []string{"text", "json"}
+
+var (
+	// listCfg holds the configuration for the list command
+	listCfg *config.Config
+	// listFormat is the output format of the inventory
+	listFormat string
+)
+
+// listedImage is an image used by a service, as printed by the list command
+type listedImage struct {
+	File    string `json:"file"`
+	Service string `json:"service"`
+	Image   string `json:"image"`
+	Kind    string `json:"kind,omitempty"`
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list [file or directory]",
+	Short: "List the images of the scanned files without checking for updates",
+	Long: `Scan the files like the check command and print the image of every service,
+without querying any registry. The inventory is printed as text, one service per
+line, or as JSON to feed other tools.
+
+Examples:
+  img-upgr list                   List the images of IMG_UPGR_SCANDIR
+  img-upgr list deploy/ -o json   List the images below deploy/ as JSON`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		if err := runListCommand(ctx, listCfg, args); err != nil {
+			logger.Error("List command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+// runListCommand prints the images of the scanned files
+func runListCommand(ctx context.Context, cfg *config.Config, args []string) error {
+	if !validation.IsValidChoice(listFormat, listFormats) {
+		return fmt.Errorf("invalid output format %q, must be one of: %s", listFormat, strings.Join(listFormats, ", "))
+	}
+
+	if err := initializeAndValidate(ctx, cfg); err != nil {
+		return fmt.Errorf("initialization failed: %w", err)
+	}
+	defer gitlab.CleanupRepository(cfg)
+
+	files, err := determineFilesToScan(cfg, args)
+	if err != nil {
+		return fmt.Errorf("failed to determine files to scan: %w", err)
+	}
+
+	images := listImages(cfg, files)
+	if listFormat == "json" {
+		return writeImagesJSON(os.Stdout, images)
+	}
+	return writeImagesText(os.Stdout, images)
+}
+
+// listImages returns the images of the services of the files, in the order of the files
+// and by service name. Files that cannot be parsed are only logged.
+func listImages(cfg *config.Config, files []string) []listedImage {
+	var manifests *gitops.Index
+	if cfg.GitOps {
+		manifests = loadManifests(files)
+	}
+
+	var listed []listedImage
+	for _, filePath := range files {
+		images := make(map[string]string)
+		kinds := make(map[string]string)
+
+		switch manifest, isManifest := manifests.Manifest(filePath); {
+		case isManifest:
+			for _, ref := range manifest.References {
+				// Chart versions are not images
+				if ref.Kind == gitops.KindChart {
+					continue
+				}
+				image, err := manifests.Image(ref)
+				if err != nil {
+					logger.Warn("Could not resolve %s in %s: %v", ref.ID, filePath, err)
+					continue
+				}
+				images[ref.ID] = image
+				kinds[ref.ID] = ref.Kind
+			}
+		case cfg.Terraform && strings.HasSuffix(filePath, config.TerraformFileExtension):
+			references, err := terraform.ParseFile(filePath)
+			if err != nil {
+				logger.Warn("Could not parse Terraform file %s: %v", filePath, err)
+				continue
+			}
+			for _, ref := range references {
+				images[ref.ID] = ref.Image
+				kinds[ref.ID] = terraform.Kind
+			}
+		case cfg.GitOps && !cfg.IsComposeFile(filePath):
+			logger.Debug("Skipping %s: neither a compose file nor a GitOps manifest", filePath)
+			continue
+		default:
+			composeFile, err := compose.ParseComposeFile(filePath)
+			if err != nil {
+				logger.Warn("Could not parse compose file %s: %v", filePath, err)
+				continue
+			}
+			images = composeFile.GetImages()
+		}
+
+		services := make([]string, 0, len(images))
+		for service := range images {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+
+		relPath := repoRelativePath(cfg, filePath)
+		for _, service := range services {
+			listed = append(listed, listedImage{File: relPath, Service: service, Image: images[service], Kind: kinds[service]})
+		}
+	}
+	return listed
+}
+
+// writeImagesText writes the images as aligned columns, one service per line
+func writeImagesText(w io.Writer, images []listedImage) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, image := range images {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\n", image.File, image.Service, image.Image); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// writeImagesJSON writes the images as an indented JSON array
+func writeImagesJSON(w io.Writer, images []listedImage) error {
+	if images == nil {
+		images = []listedImage{}
+	}
+
+	data, err := json.MarshalIndent(images, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode images: %w", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// init registers the list command
+func init() {
+	listCfg = config.New()
+	listCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(listCmd)
+
+	listCmd.Flags().StringVarP(&listFormat, "output", "o", "text", "Output format ("+strings.Join(listFormats, ", ")+")")
+}
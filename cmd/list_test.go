@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteImages(t *testing.T) {
+	images := []listedImage{
+		{File: "docker-compose.yml", Service: "web", Image: "nginx:1.25.0"},
+		{File: "apps/api/compose.yml", Service: "api", Image: "registry.example.com/api:2.0.0"},
+		{File: "clusters/prod/release.yaml", Service: "HelmRelease/flux-system/podinfo", Image: "podinfo:6.5.0", Kind: "chart"},
+	}
+
+	tests := []struct {
+		name     string
+		images   []listedImage
+		wantText string
+		wantJSON string
+	}{
+		{
+			name:     "no images",
+			images:   nil,
+			wantText: "",
+			wantJSON: "[]\n",
+		},
+		{
+			name:     "single image",
+			images:   images[:1],
+			wantText: "docker-compose.yml  web  nginx:1.25.0\n",
+			wantJSON: `[
+  {
+    "file": "docker-compose.yml",
+    "service": "web",
+    "image": "nginx:1.25.0"
+  }
+]
+`,
+		},
+		{
+			name:   "aligned columns",
+			images: images,
+			wantText: "docker-compose.yml          web                              nginx:1.25.0\n" +
+				"apps/api/compose.yml        api                              registry.example.com/api:2.0.0\n" +
+				"clusters/prod/release.yaml  HelmRelease/flux-system/podinfo  podinfo:6.5.0\n",
+			wantJSON: `[
+  {
+    "file": "docker-compose.yml",
+    "service": "web",
+    "image": "nginx:1.25.0"
+  },
+  {
+    "file": "apps/api/compose.yml",
+    "service": "api",
+    "image": "registry.example.com/api:2.0.0"
+  },
+  {
+    "file": "clusters/prod/release.yaml",
+    "service": "HelmRelease/flux-system/podinfo",
+    "image": "podinfo:6.5.0",
+    "kind": "chart"
+  }
+]
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var text bytes.Buffer
+			if err := writeImagesText(&text, tt.images); err != nil {
+				t.Fatalf("writeImagesText() error = %v", err)
+			}
+			if text.String() != tt.wantText {
+				t.Errorf("writeImagesText() =\n%s\nwant\n%s", text.String(), tt.wantText)
+			}
+
+			var encoded bytes.Buffer
+			if err := writeImagesJSON(&encoded, tt.images); err != nil {
+				t.Fatalf("writeImagesJSON() error = %v", err)
+			}
+			if encoded.String() != tt.wantJSON {
+				t.Errorf("writeImagesJSON() =\n%s\nwant\n%s", encoded.String(), tt.wantJSON)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// previousPipeline returns the latest pipeline of a merge request about to be refreshed,
+// nil if refresh notes are disabled or the pipeline cannot be read
+func previousPipeline(ctx context.Context, cfg *config.Config, gitlabClient *gitlab.Client, mr gitlab.MergeRequestResponse) *gitlab.PipelineResponse {
+	if !cfg.RefreshNotes {
+		return nil
+	}
+
+	pipeline, err := gitlabClient.LatestMergeRequestPipelineWithContext(ctx, mr.IID)
+	if err != nil {
+		logger.Debug("Could not read the pipeline of merge request !%d: %v", mr.IID, err)
+		return nil
+	}
+	return pipeline
+}
+
+// postRefreshNote comments on a refreshed merge request with what changed since the
+// previous run. Failures are only logged, the merge request being refreshed already.
+func postRefreshNote(ctx context.Context, cfg *config.Config, gitlabClient *gitlab.Client, mr gitlab.MergeRequestResponse, markers []gitlab.UpdateMarker, pipeline *gitlab.PipelineResponse) {
+	if !cfg.RefreshNotes {
+		return
+	}
+
+	note := formatRefreshNote(gitlab.ParseUpdateMarkers(mr.Description), markers, pipeline)
+	if err := gitlabClient.CreateMergeRequestNoteWithContext(ctx, mr.IID, note); err != nil {
+		logger.Warn("Could not comment on merge request !%d: %v", mr.IID, err)
+	}
+}
+
+// formatRefreshNote describes how the updates proposed by a merge request changed from
+// the previous markers to the current ones, and the status of the pipeline of the
+// previous proposal if known
+func formatRefreshNote(previous, current []gitlab.UpdateMarker, pipeline *gitlab.PipelineResponse) string {
+	var b strings.Builder
+	b.WriteString("img-upgr refreshed this merge request:\n\n")
+
+	// Updates are matched by the service they change
+	serviceKey := func(m gitlab.UpdateMarker) string { return m.File + "|" + m.Service }
+	proposed := make(map[string]gitlab.UpdateMarker, len(previous))
+	for _, marker := range previous {
+		proposed[serviceKey(marker)] = marker
+	}
+
+	for _, marker := range current {
+		old, ok := proposed[serviceKey(marker)]
+		delete(proposed, serviceKey(marker))
+		switch {
+		case !ok:
+			fmt.Fprintf(&b, "- `%s`: `%s` → `%s` added\n", marker.Service, marker.OldTag, marker.NewTag)
+		case old.NewTag != marker.NewTag:
+			fmt.Fprintf(&b, "- `%s`: `%s` → `%s`, superseding `%s`\n", marker.Service, marker.OldTag, marker.NewTag, old.NewTag)
+		default:
+			fmt.Fprintf(&b, "- `%s`: `%s` → `%s` unchanged\n", marker.Service, marker.OldTag, marker.NewTag)
+		}
+	}
+	for _, marker := range previous {
+		if _, dropped := proposed[serviceKey(marker)]; dropped {
+			fmt.Fprintf(&b, "- `%s`: `%s` → `%s` dropped\n", marker.Service, marker.OldTag, marker.NewTag)
+		}
+	}
+
+	if pipeline != nil {
+		fmt.Fprintf(&b, "\nThe pipeline of the previous proposal, [#%d](%s), ended with status `%s`.\n", pipeline.ID, pipeline.WebURL, pipeline.Status)
+	}
+	return b.String()
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/update"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/validation"
+)
+
+// ExitCodeOutdated is the exit code of the outdated command when a newer tag is available
+const ExitCodeOutdated = 2
+
+// outdatedFormats are the output formats of the outdated command
+var outdatedFormats = []string{"text", "json"}
+
+var (
+	// outdatedCfg holds the configuration for the outdated command
+	outdatedCfg *config.Config
+	// outdatedImage is the image reference checked
+	outdatedImage string
+	// outdatedConstraint is the version range the latest tag must satisfy
+	outdatedConstraint string
+	// outdatedFormat is the output format of the result
+	outdatedFormat string
+)
+
+// outdatedResult is the result of the outdated command
+type outdatedResult struct {
+	Image      string `json:"image"`
+	Repository string `json:"repository"`
+	Current    string `json:"current"`
+	Latest     string `json:"latest,omitempty"`
+	Outdated   bool   `json:"outdated"`
+	Major      bool   `json:"major,omitempty"`
+	Downgrade  bool   `json:"downgrade,omitempty"`
+}
+
+var outdatedCmd = &cobra.Command{
+	Use:   "outdated --image <reference>",
+	Short: "Check a single image reference for a newer tag",
+	Long: `Check one image reference given on the command line, without any compose file,
+and print its current and latest tags. The versioning settings of the environment
+apply, such as IMG_UPGR_EXCLUDE_TAGS, channels and LTS rules, so they can be tried
+on one image.
+
+The command exits with 0 when the image is up to date, 2 when a newer tag is
+available and 1 on errors.
+
+Examples:
+  img-upgr outdated --image nginx:1.25.3
+  img-upgr outdated --image ghcr.io/org/app:v2.1.0 --allow-major -o json
+  img-upgr outdated --image postgres:15.4 --constraint "<16"`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		res, err := runOutdatedCommand(ctx, outdatedCfg, outdatedImage)
+		if err != nil {
+			logger.Error("Outdated command failed: %v", err)
+			exit(ExitCodeError)
+		}
+		if res.Outdated {
+			exit(ExitCodeOutdated)
+		}
+	},
+}
+
+// runOutdatedCommand checks an image reference against its registry and prints the result
+func runOutdatedCommand(ctx context.Context, cfg *config.Config, image string) (*outdatedResult, error) {
+	if !validation.IsValidChoice(outdatedFormat, outdatedFormats) {
+		return nil, fmt.Errorf("invalid output format %q, must be one of: %s", outdatedFormat, strings.Join(outdatedFormats, ", "))
+	}
+	if err := cfg.ResolveSecrets(ctx); err != nil {
+		return nil, err
+	}
+
+	var constraints map[string]string
+	if outdatedConstraint != "" {
+		constraints = map[string]string{image: outdatedConstraint}
+	}
+	opts, err := updateOptions(cfg, constraints, image, image)
+	if err != nil {
+		return nil, err
+	}
+
+	registryClient, err := newRegistryClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure registries: %w", err)
+	}
+
+	info, err := update.CheckImageWithContext(ctx, image, registryClient, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &outdatedResult{
+		Image:      image,
+		Repository: info.Repository,
+		Current:    info.Tag,
+		Latest:     info.LatestTag,
+		Outdated:   info.HasUpdate,
+		Major:      info.HasUpdate && info.IsMajor,
+		Downgrade:  info.HasUpdate && info.IsDowngrade,
+	}
+
+	if outdatedFormat == "json" {
+		return res, writeOutdatedJSON(os.Stdout, res)
+	}
+	return res, writeOutdatedText(os.Stdout, res)
+}
+
+// writeOutdatedText writes the current and latest tags of the image
+func writeOutdatedText(w io.Writer, res *outdatedResult) error {
+	latest := res.Latest
+	switch {
+	case latest == "":
+		latest = "no matching version found"
+	case res.Downgrade:
+		latest += " (downgrade)"
+	case res.Major:
+		latest += " (major update)"
+	case !res.Outdated:
+		latest += " (up to date)"
+	}
+
+	_, err := fmt.Fprintf(w, "Image:   %s\nCurrent: %s\nLatest:  %s\n", res.Image, res.Current, latest)
+	return err
+}
+
+// writeOutdatedJSON writes the result as indented JSON
+func writeOutdatedJSON(w io.Writer, res *outdatedResult) error {
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// init registers the outdated command
+func init() {
+	outdatedCfg = config.New()
+	outdatedCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(outdatedCmd)
+
+	outdatedCmd.Flags().StringVar(&outdatedImage, "image", "", "Image reference to check, such as nginx:1.25.3")
+	_ = outdatedCmd.MarkFlagRequired("image")
+	outdatedCmd.Flags().StringVar(&outdatedConstraint, "constraint", "", "Version range the latest tag must satisfy (e.g. \"~1.25\" or \"<16\")")
+	outdatedCmd.Flags().StringVarP(&outdatedFormat, "output", "o", "text", "Output format ("+strings.Join(outdatedFormats, ", ")+")")
+	outdatedCmd.Flags().BoolVar(&outdatedCfg.AllowMajor, "allow-major", outdatedCfg.AllowMajor,
+		"Also consider tags of a new major version")
+	outdatedCmd.Flags().BoolVar(&outdatedCfg.AllowDowngrade, "allow-downgrade", outdatedCfg.AllowDowngrade,
+		"Report a rollback when the tag is newer than the latest allowed version")
+	outdatedCmd.Flags().StringVar(&outdatedCfg.Platform, "platform", outdatedCfg.Platform,
+		"Only consider tags published for this platform (e.g. linux/arm64)")
+	outdatedCmd.Flags().StringVar(&outdatedCfg.TagCatalog, "tag-catalog", outdatedCfg.TagCatalog,
+		"Read tags from a catalog written by export-tags instead of the registries")
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteOutdated(t *testing.T) {
+	tests := []struct {
+		name     string
+		res      outdatedResult
+		wantText string
+		wantJSON string
+	}{
+		{
+			name:     "up to date",
+			res:      outdatedResult{Image: "nginx:1.27.0", Repository: "nginx", Current: "1.27.0", Latest: "1.27.0"},
+			wantText: "Image:   nginx:1.27.0\nCurrent: 1.27.0\nLatest:  1.27.0 (up to date)\n",
+			wantJSON: `{
+  "image": "nginx:1.27.0",
+  "repository": "nginx",
+  "current": "1.27.0",
+  "latest": "1.27.0",
+  "outdated": false
+}
+`,
+		},
+		{
+			name:     "no matching version",
+			res:      outdatedResult{Image: "nginx:mainline", Repository: "nginx", Current: "mainline"},
+			wantText: "Image:   nginx:mainline\nCurrent: mainline\nLatest:  no matching version found\n",
+			wantJSON: `{
+  "image": "nginx:mainline",
+  "repository": "nginx",
+  "current": "mainline",
+  "outdated": false
+}
+`,
+		},
+		{
+			name:     "outdated",
+			res:      outdatedResult{Image: "nginx:1.25.0", Repository: "nginx", Current: "1.25.0", Latest: "1.27.0", Outdated: true},
+			wantText: "Image:   nginx:1.25.0\nCurrent: 1.25.0\nLatest:  1.27.0\n",
+			wantJSON: `{
+  "image": "nginx:1.25.0",
+  "repository": "nginx",
+  "current": "1.25.0",
+  "latest": "1.27.0",
+  "outdated": true
+}
+`,
+		},
+		{
+			name:     "major update",
+			res:      outdatedResult{Image: "postgres:15.4", Repository: "postgres", Current: "15.4", Latest: "16.2", Outdated: true, Major: true},
+			wantText: "Image:   postgres:15.4\nCurrent: 15.4\nLatest:  16.2 (major update)\n",
+			wantJSON: `{
+  "image": "postgres:15.4",
+  "repository": "postgres",
+  "current": "15.4",
+  "latest": "16.2",
+  "outdated": true,
+  "major": true
+}
+`,
+		},
+		{
+			name:     "downgrade",
+			res:      outdatedResult{Image: "redis:7.4.1-rc1", Repository: "redis", Current: "7.4.1-rc1", Latest: "7.4.0", Outdated: true, Downgrade: true},
+			wantText: "Image:   redis:7.4.1-rc1\nCurrent: 7.4.1-rc1\nLatest:  7.4.0 (downgrade)\n",
+			wantJSON: `{
+  "image": "redis:7.4.1-rc1",
+  "repository": "redis",
+  "current": "7.4.1-rc1",
+  "latest": "7.4.0",
+  "outdated": true,
+  "downgrade": true
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var text bytes.Buffer
+			if err := writeOutdatedText(&text, &tt.res); err != nil {
+				t.Fatalf("writeOutdatedText() error = %v", err)
+			}
+			if text.String() != tt.wantText {
+				t.Errorf("writeOutdatedText() =\n%s\nwant\n%s", text.String(), tt.wantText)
+			}
+
+			var encoded bytes.Buffer
+			if err := writeOutdatedJSON(&encoded, &tt.res); err != nil {
+				t.Fatalf("writeOutdatedJSON() error = %v", err)
+			}
+			if encoded.String() != tt.wantJSON {
+				t.Errorf("writeOutdatedJSON() =\n%s\nwant\n%s", encoded.String(), tt.wantJSON)
+			}
+		})
+	}
+}
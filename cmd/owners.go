@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+// ownerAssignment gives the merge requests to the owners of the files they update, from
+// the repository configuration or the CODEOWNERS file. A nil ownerAssignment assigns nobody.
+type ownerAssignment struct {
+	role       string
+	rotation   bool
+	repoConfig *config.RepoConfig
+	codeOwners *config.CodeOwners
+
+	// members caches the members of the groups owning files, nil for owners that are users
+	members map[string][]string
+	// start and turns pick the owner of each merge request in turn, starting from the
+	// number of open merge requests so rotation carries over between runs
+	start int
+	turns map[string]int
+}
+
+// newOwnerAssignment returns the assignment of merge requests to owners, nil if the
+// owners of updated files are not assigned
+func newOwnerAssignment(cfg *config.Config, repoConfig *config.RepoConfig, openCount int) (*ownerAssignment, error) {
+	if cfg.CodeOwners == "none" {
+		return nil, nil
+	}
+
+	codeOwners, err := config.LoadCodeOwners(cfg.TempDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ownerAssignment{
+		role:       cfg.CodeOwners,
+		rotation:   cfg.OwnerRotation,
+		repoConfig: repoConfig,
+		codeOwners: codeOwners,
+		members:    make(map[string][]string),
+		start:      openCount,
+		turns:      make(map[string]int),
+	}, nil
+}
+
+// owners returns the owners of the files of updates, the owners set in the repository
+// configuration taking precedence over the CODEOWNERS file
+func (a *ownerAssignment) owners(cfg *config.Config, updates []result.UpdateCandidate) []string {
+	var owners []string
+	for _, update := range updates {
+		relPath := repoRelativePath(cfg, update.FilePath)
+		fileOwners, ok := a.repoConfig.FileOwners(relPath)
+		if !ok {
+			fileOwners = a.codeOwners.Owners(relPath)
+		}
+		for _, owner := range fileOwners {
+			if !slices.Contains(owners, owner) {
+				owners = append(owners, owner)
+			}
+		}
+	}
+	return owners
+}
+
+// expand replaces the groups among owners by their members, as merge requests can only
+// be given to users
+func (a *ownerAssignment) expand(ctx context.Context, gitlabClient *gitlab.Client, owners []string) []string {
+	var users []string
+	for _, owner := range owners {
+		members, cached := a.members[owner]
+		if !cached {
+			var found bool
+			var err error
+			if members, found, err = gitlabClient.GroupMembersWithContext(ctx, owner); err != nil {
+				logger.Warn("Could not look up the members of %s, assigning it as a user: %v", owner, err)
+			}
+			if !found {
+				members = nil
+			}
+			a.members[owner] = members
+		}
+
+		if members == nil {
+			members = []string{owner}
+		}
+		for _, user := range members {
+			if !slices.Contains(users, user) {
+				users = append(users, user)
+			}
+		}
+	}
+	return users
+}
+
+// apply adds the owners of the files of updates to the assignees or reviewers of a new
+// merge request, only one of them in turn when rotating
+func (a *ownerAssignment) apply(ctx context.Context, cfg *config.Config, gitlabClient *gitlab.Client, opts *gitlab.MergeRequestOptions, updates []result.UpdateCandidate) {
+	if a == nil {
+		return
+	}
+
+	owners := a.expand(ctx, gitlabClient, a.owners(cfg, updates))
+	if len(owners) == 0 {
+		return
+	}
+
+	if a.rotation {
+		key := strings.Join(owners, ",")
+		owners = []string{owners[(a.start+a.turns[key])%len(owners)]}
+		a.turns[key]++
+	}
+	logger.Debug("Giving the merge request to the owners of the updated files as %s: %s", a.role, strings.Join(owners, ", "))
+
+	switch a.role {
+	case "assignees":
+		for _, owner := range owners {
+			if !slices.Contains(opts.Assignees, owner) {
+				opts.Assignees = append(opts.Assignees, owner)
+			}
+		}
+	case "reviewers":
+		for _, owner := range owners {
+			if !slices.Contains(opts.Reviewers, owner) {
+				opts.Reviewers = append(opts.Reviewers, owner)
+			}
+		}
+	}
+}
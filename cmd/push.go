@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/compose"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+)
+
+// pushUpdates applies updates on a branch created from baseBranch, or reset onto it
+// when reset is set, and pushes them as a single commit. Updates that cannot be
+// applied are dropped, the applied ones are returned and passed to message to build
+// the commit message. The pre-update and post-update hooks run around the updates,
+// a failing one leaving the branch unpushed.
+func pushUpdates(ctx context.Context, cfg *config.Config, branch, baseBranch string, reset bool,
+	updates []result.UpdateCandidate, message func([]result.UpdateCandidate) string) ([]result.UpdateCandidate, error) {
+	if cfg.APIOnly {
+		return pushUpdatesWithAPI(ctx, cfg, branch, baseBranch, reset, updates, message)
+	}
+
+	// Prepare the branch in the cloned repository
+	var err error
+	if reset {
+		err = gitlab.ResetBranchInRepo(ctx, cfg, branch, baseBranch)
+	} else {
+		err = gitlab.CreateBranchInRepo(ctx, cfg, branch, baseBranch)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare branch: %w", err)
+	}
+
+	if err := runUpdateHook(ctx, cfg, "pre-update", cfg.PreUpdateHook, branch, baseBranch, updates); err != nil {
+		return nil, err
+	}
+
+	var applied []result.UpdateCandidate
+	for _, update := range updates {
+		logger.Info("Updating %s: %s → %s", update.ServiceName, update.OldImage, update.NewImage)
+		if err := applyUpdateToFile(update); err != nil {
+			logger.Error("Error updating file %s: %v", update.FilePath, err)
+			continue
+		}
+		applied = append(applied, update)
+	}
+	if len(applied) == 0 {
+		return nil, fmt.Errorf("no update could be applied")
+	}
+
+	if err := validateComposeFiles(ctx, cfg, branch, applied); err != nil {
+		return nil, err
+	}
+	if err := runUpdateHook(ctx, cfg, "post-update", cfg.PostUpdateHook, branch, baseBranch, applied); err != nil {
+		return nil, err
+	}
+
+	// A reset branch replaces the previous commit on the remote branch
+	commit := gitlab.CommitAndPushChanges
+	if reset {
+		commit = gitlab.CommitAndForcePushChanges
+	}
+	files := updatedFiles(cfg, applied)
+	if err := commit(ctx, cfg, commitMessage(ctx, cfg, message(applied), files), files); err != nil {
+		return nil, fmt.Errorf("failed to commit changes: %w", err)
+	}
+
+	// Files left by the hooks are not committed and must not reach the next branch
+	discardChanges(ctx, cfg, branch)
+	return applied, nil
+}
+
+// pushUpdatesWithAPI applies updates in memory to the files of baseBranch and commits
+// them through the GitLab API, creating or replacing the branch in the same request
+func pushUpdatesWithAPI(ctx context.Context, cfg *config.Config, branch, baseBranch string, reset bool,
+	updates []result.UpdateCandidate, message func([]result.UpdateCandidate) string) ([]result.UpdateCandidate, error) {
+	gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+	if !ok {
+		return nil, fmt.Errorf("invalid GitLab client type")
+	}
+
+	// Files are read from the base branch so the commit applies on its latest state
+	contents := make(map[string]string)
+	var paths []string
+	var applied []result.UpdateCandidate
+	for _, update := range updates {
+		relPath := repoRelativePath(cfg, update.FilePath)
+		content, ok := contents[relPath]
+		if !ok {
+			var err error
+			if content, err = gitlabClient.GetFileWithContext(ctx, baseBranch, relPath); err != nil {
+				logger.Error("Error fetching file %s: %v", relPath, err)
+				continue
+			}
+		}
+
+		logger.Info("Updating %s: %s → %s", update.ServiceName, update.OldImage, update.NewImage)
+		updated, err := applyUpdateToContent([]byte(content), update)
+		if err != nil {
+			logger.Error("Error updating file %s: %v", relPath, err)
+			continue
+		}
+
+		if !ok {
+			paths = append(paths, relPath)
+		}
+		contents[relPath] = string(updated)
+		applied = append(applied, update)
+	}
+	if len(applied) == 0 {
+		return nil, fmt.Errorf("no update could be applied")
+	}
+
+	files := make([]gitlab.FileChange, 0, len(paths))
+	for _, path := range paths {
+		files = append(files, gitlab.FileChange{Path: path, Content: contents[path]})
+	}
+
+	if err := gitlabClient.CommitFilesWithContext(ctx, branch, baseBranch, commitMessage(ctx, cfg, message(applied), paths), files, reset); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// runUpdateHook runs a hook in the cloned repository with the branch and updates in its
+// environment. When it fails, the changes made on the branch are discarded.
+func runUpdateHook(ctx context.Context, cfg *config.Config, hook, command, branch, baseBranch string, updates []result.UpdateCandidate) error {
+	var images []string
+	for _, update := range updates {
+		images = append(images, update.NewImage)
+	}
+
+	err := gitlab.RunHook(ctx, cfg, hook, command, []string{
+		"IMG_UPGR_HOOK_BRANCH=" + branch,
+		"IMG_UPGR_HOOK_BASE_BRANCH=" + baseBranch,
+		"IMG_UPGR_HOOK_FILES=" + strings.Join(updatedFiles(cfg, updates), " "),
+		"IMG_UPGR_HOOK_IMAGES=" + strings.Join(images, " "),
+	})
+	if err == nil {
+		return nil
+	}
+
+	discardChanges(ctx, cfg, branch)
+	return err
+}
+
+// validateComposeFiles checks the compose files of the applied updates with docker compose
+// config when enabled, discarding the changes of the branch when one is invalid. The
+// check is skipped when docker is not installed.
+func validateComposeFiles(ctx context.Context, cfg *config.Config, branch string, applied []result.UpdateCandidate) error {
+	if !cfg.ComposeConfig {
+		return nil
+	}
+
+	var checked []string
+	for _, update := range applied {
+		if update.Kind != "" || slices.Contains(checked, update.FilePath) {
+			continue
+		}
+		checked = append(checked, update.FilePath)
+
+		err := compose.ValidateWithDocker(ctx, update.FilePath)
+		if errors.Is(err, compose.ErrNoDocker) {
+			logger.Warn("Not checking compose files with docker compose config: %v", err)
+			return nil
+		}
+		if err != nil {
+			discardChanges(ctx, cfg, branch)
+			return fmt.Errorf("%s is invalid after the update: %w", repoRelativePath(cfg, update.FilePath), err)
+		}
+	}
+	return nil
+}
+
+// discardChanges drops the changes made on a branch that is not pushed, so they are not
+// carried over to the next one
+func discardChanges(ctx context.Context, cfg *config.Config, branch string) {
+	if err := gitlab.DiscardChangesInRepo(ctx, cfg); err != nil {
+		logger.Warn("Failed to discard the changes of %s: %v", branch, err)
+	}
+}
+
+// updatedFiles returns the paths of the files of updates relative to the root of the
+// repository, in the order of the updates
+func updatedFiles(cfg *config.Config, updates []result.UpdateCandidate) []string {
+	var files []string
+	for _, update := range updates {
+		if relPath := repoRelativePath(cfg, update.FilePath); !slices.Contains(files, relPath) {
+			files = append(files, relPath)
+		}
+	}
+	return files
+}
+
+// commitMessage appends the updated files to the body of a commit message, followed by
+// the trailers of the run ID and of the configured co-authors
+func commitMessage(ctx context.Context, cfg *config.Config, message string, files []string) string {
+	message += "\n\nUpdated files:\n- " + strings.Join(files, "\n- ")
+	message += "\n\nImg-Upgr-Run-Id: " + runid.FromContext(ctx)
+	for _, coAuthor := range cfg.CoAuthors {
+		message += "\nCo-authored-by: " + coAuthor
+	}
+	return message
+}
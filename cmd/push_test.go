@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+func TestPushUpdatesWithAPI(t *testing.T) {
+	message := func(applied []result.UpdateCandidate) string { return "chore: update images" }
+
+	t.Run("updates committed from the base branch", func(t *testing.T) {
+		cfg, fake := newFakeGitLab(t, map[string]string{
+			"compose.yml":        "services:\n  web:\n    image: nginx:1.25.0\n  cache:\n    image: redis:7.0.0\n",
+			"deploy/compose.yml": "services:\n  db:\n    image: postgres:16.0.0\n",
+		})
+		web := nginxUpdate(cfg)
+		cache := result.UpdateCandidate{
+			FilePath: filepath.Join(cfg.TempDir, "compose.yml"), ServiceName: "cache",
+			OldImage: "redis:7.0.0", NewImage: "redis:7.4.1", Repository: "redis", OldTag: "7.0.0", NewTag: "7.4.1",
+		}
+		db := result.UpdateCandidate{
+			FilePath: filepath.Join(cfg.TempDir, "deploy", "compose.yml"), ServiceName: "db",
+			OldImage: "postgres:16.0.0", NewImage: "postgres:16.4.0", Repository: "postgres", OldTag: "16.0.0", NewTag: "16.4.0",
+		}
+		// An update whose file is not on the base branch is dropped
+		missing := result.UpdateCandidate{
+			FilePath: filepath.Join(cfg.TempDir, "missing.yml"), ServiceName: "app",
+			OldImage: "app:1.0.0", NewImage: "app:1.1.0", Repository: "app", OldTag: "1.0.0", NewTag: "1.1.0",
+		}
+
+		applied, err := pushUpdatesWithAPI(context.Background(), cfg, "img-upgr/batch", "main", false,
+			[]result.UpdateCandidate{web, missing, db, cache}, message)
+		if err != nil {
+			t.Fatalf("pushUpdatesWithAPI() error = %v", err)
+		}
+		if len(applied) != 3 {
+			t.Errorf("applied = %+v, want the updates of nginx, postgres and redis", applied)
+		}
+
+		// Updates of the same file are merged into one action, in the order of the updates
+		files := fake.commits["img-upgr/batch"]
+		if len(files) != 2 || files[0].Path != "compose.yml" || files[1].Path != "deploy/compose.yml" {
+			t.Fatalf("committed files = %+v, want compose.yml then deploy/compose.yml", files)
+		}
+		if !strings.Contains(files[0].Content, "nginx:1.27.0") || !strings.Contains(files[0].Content, "redis:7.4.1") {
+			t.Errorf("compose.yml = %q, want both updates", files[0].Content)
+		}
+		if !strings.Contains(files[1].Content, "postgres:16.4.0") {
+			t.Errorf("deploy/compose.yml = %q, want postgres:16.4.0", files[1].Content)
+		}
+	})
+
+	t.Run("no update applicable", func(t *testing.T) {
+		cfg, fake := newFakeGitLab(t, map[string]string{"compose.yml": "services:\n  web:\n    image: nginx:1.26.0\n"})
+
+		_, err := pushUpdatesWithAPI(context.Background(), cfg, "img-upgr/nginx", "main", false,
+			[]result.UpdateCandidate{nginxUpdate(cfg)}, message)
+		if err == nil || !strings.Contains(err.Error(), "no update could be applied") {
+			t.Errorf("pushUpdatesWithAPI() error = %v, want no update applied", err)
+		}
+		if len(fake.commits) != 0 {
+			t.Errorf("commits = %+v, want none", fake.commits)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/trace"
+)
+
+// mergeRequestRetryWait is the wait before retrying a failed merge request, doubled on each attempt
+var mergeRequestRetryWait = 5 * time.Second
+
+const (
+	// mergeRequestGracePeriod is how long the merge request in progress gets to finish
+	// once the run is interrupted, below the 30 seconds Kubernetes waits after SIGTERM
+	mergeRequestGracePeriod = 20 * time.Second
+)
+
+// retryMergeRequest runs the steps proposing an update, retrying them with an exponential
+// backoff up to the configured number of retries. Failed hooks are not retried since
+// they would fail again on the same updates. The steps run with a context outliving ctx
+// by the grace period, so an interrupted run does not leave a branch half-pushed.
+func retryMergeRequest(ctx context.Context, cfg *config.Config, name string, run func(ctx context.Context) error) (err error) {
+	ctx, span := trace.Start(ctx, "merge request", trace.String("img_upgr.update", name))
+	defer func() { span.End(err) }()
+
+	wait := mergeRequestRetryWait
+	for attempt := 0; ; attempt++ {
+		attemptCtx, done := gracefulContext(ctx, name)
+		err := run(attemptCtx)
+		done()
+		var hookErr *gitlab.HookError
+		if err == nil || attempt >= cfg.MRRetries || ctx.Err() != nil || errors.As(err, &hookErr) {
+			span.SetAttributes(trace.Int("img_upgr.attempts", attempt+1))
+			return err
+		}
+
+		logger.Warn("Merge request for %s failed, retrying in %s (%d/%d): %v", name, wait, attempt+1, cfg.MRRetries, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// gracefulContext returns a context cancelled the grace period after ctx, and the function
+// releasing it
+func gracefulContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	graceful, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		logger.Warn("Interrupted, giving the merge request for %s %s to finish", name, mergeRequestGracePeriod)
+		time.AfterFunc(mergeRequestGracePeriod, cancel)
+	})
+	return graceful, func() {
+		stop()
+		cancel()
+	}
+}
+
+// mergeRequestCreation opens a merge request across the attempts of retryMergeRequest.
+// An attempt failing once GitLab created the merge request, such as on a timeout, is
+// followed by one refused with 409 Conflict as the branch has its merge request: that
+// attempt succeeds. A conflict on the first request is a merge request of another run.
+type mergeRequestCreation struct {
+	// requested is set once a request may have created the merge request
+	requested bool
+}
+
+// create opens the merge request of a branch
+func (c *mergeRequestCreation) create(ctx context.Context, gitlabClient *gitlab.Client, sourceBranch, targetBranch, title, description string, opts gitlab.MergeRequestOptions) error {
+	_, err := gitlabClient.CreateMergeRequestWithOptions(ctx, sourceBranch, targetBranch, title, description, opts)
+	if err != nil && c.requested && gitlab.IsConflict(err) {
+		logger.Info("Merge request of branch %s was opened by a previous attempt", sourceBranch)
+		return nil
+	}
+	c.requested = c.requested || !gitlab.IsConflict(err)
+	if err != nil {
+		return fmt.Errorf("failed to create merge request: %w", err)
+	}
+	return nil
+}
+
+// mergeRequestFailures collects the updates whose merge request could not be created
+// or refreshed, to report them together at the end of a run
+type mergeRequestFailures struct {
+	failures []string
+}
+
+// add records the failure of the merge request of an update
+func (f *mergeRequestFailures) add(name string, err error) {
+	logger.Error("Merge request for %s failed: %v", name, err)
+	f.failures = append(f.failures, fmt.Sprintf("%s: %v", name, err))
+}
+
+// result logs the failures and returns an error when any merge request failed,
+// unless the run is best effort
+func (f *mergeRequestFailures) result(cfg *config.Config) error {
+	if len(f.failures) == 0 {
+		return nil
+	}
+
+	logger.Error("%d merge requests failed:", len(f.failures))
+	for _, failure := range f.failures {
+		logger.Error("  %s", failure)
+	}
+
+	if cfg.BestEffort {
+		logger.Warn("Best effort mode: ignoring the failed merge requests")
+		return nil
+	}
+	return fmt.Errorf("%d merge requests failed: %s", len(f.failures), strings.Join(f.failures, "; "))
+}
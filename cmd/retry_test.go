@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+// fakeGitLab is the API of a project group/project whose default branch main holds
+// files, recording the commits and merge requests created
+type fakeGitLab struct {
+	t     *testing.T
+	mu    sync.Mutex
+	files map[string]string
+	// reads counts the reads of each file
+	reads map[string]int
+	// commits are the files committed, by branch
+	commits map[string][]gitlab.FileChange
+	// mergeRequests are the source branches of the merge requests opened
+	mergeRequests []string
+	// failMergeRequests answers that many merge request creations with 502 Bad Gateway,
+	// after creating them
+	failMergeRequests int
+	// openMergeRequests are listed as the open merge requests of the project
+	openMergeRequests []gitlab.MergeRequestResponse
+	// closedMergeRequests are listed as the closed merge requests of the project
+	closedMergeRequests []gitlab.MergeRequestResponse
+	// updatedMergeRequests are the titles the merge requests were updated with, by IID
+	updatedMergeRequests map[int]string
+	// revokedToken reports the token of the bot as revoked
+	revokedToken bool
+}
+
+func (g *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/api/v4/projects/group%2Fproject")
+	switch {
+	case r.Method == http.MethodGet && path == "/api/v4/personal_access_tokens/self":
+		_, _ = fmt.Fprintf(w, `{"name": "bot", "active": %t, "scopes": ["api"]}`, !g.revokedToken)
+	case r.Method == http.MethodGet && path == "":
+		_, _ = fmt.Fprint(w, `{"id": 1, "default_branch": "main"}`)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/repository/branches/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(path, "/repository/branches/"))
+		if _, ok := g.commits[name]; !ok && name != "main" {
+			http.Error(w, `{"message": "404 Branch Not Found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"name": %q}`, name)
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/repository/files/"):
+		name, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "/repository/files/"), "/raw"))
+		g.reads[name]++
+		content, ok := g.files[name]
+		if !ok || r.URL.Query().Get("ref") != "main" {
+			http.Error(w, `{"message": "404 File Not Found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprint(w, content)
+	case r.Method == http.MethodPost && path == "/repository/commits":
+		var body struct {
+			Branch  string `json:"branch"`
+			Actions []struct {
+				FilePath string `json:"file_path"`
+				Content  string `json:"content"`
+			} `json:"actions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			g.t.Errorf("invalid commit: %v", err)
+		}
+		var files []gitlab.FileChange
+		for _, action := range body.Actions {
+			files = append(files, gitlab.FileChange{Path: action.FilePath, Content: action.Content})
+		}
+		g.commits[body.Branch] = files
+		_, _ = fmt.Fprint(w, `{"id": "0123456789abcdef"}`)
+	case r.Method == http.MethodGet && path == "/merge_requests":
+		mergeRequests := []gitlab.MergeRequestResponse{}
+		if r.URL.Query().Get("state") == "opened" {
+			mergeRequests = append(mergeRequests, g.openMergeRequests...)
+		}
+		_ = json.NewEncoder(w).Encode(mergeRequests)
+	case r.Method == http.MethodPost && path == "/merge_requests":
+		var body struct {
+			SourceBranch string `json:"source_branch"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			g.t.Errorf("invalid merge request: %v", err)
+		}
+		for _, branch := range g.mergeRequests {
+			if branch == body.SourceBranch {
+				http.Error(w, `{"message": ["Another open merge request already exists for this source branch"]}`, http.StatusConflict)
+				return
+			}
+		}
+		g.mergeRequests = append(g.mergeRequests, body.SourceBranch)
+		if g.failMergeRequests > 0 {
+			g.failMergeRequests--
+			http.Error(w, `{"message": "502 Bad Gateway"}`, http.StatusBadGateway)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"id": 1, "iid": %d}`, len(g.mergeRequests))
+	case r.Method == http.MethodPut && strings.HasPrefix(path, "/merge_requests/"):
+		var iid int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(path, "/merge_requests/"), "%d", &iid); err != nil {
+			g.t.Errorf("invalid merge request update %s", path)
+		}
+		var body struct {
+			Title string `json:"title"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			g.t.Errorf("invalid merge request update: %v", err)
+		}
+		g.updatedMergeRequests[iid] = body.Title
+		_, _ = fmt.Fprintf(w, `{"id": 1, "iid": %d, "title": %q}`, iid, body.Title)
+	default:
+		g.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newFakeGitLab returns the configuration of a run creating merge requests through the
+// API of a fake GitLab project holding files
+func newFakeGitLab(t *testing.T, files map[string]string) (*config.Config, *fakeGitLab) {
+	t.Helper()
+	fake := &fakeGitLab{t: t, files: files, reads: make(map[string]int), commits: make(map[string][]gitlab.FileChange),
+		updatedMergeRequests: make(map[int]string)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	cfg := config.New()
+	cfg.GitLabRepo = server.URL + "/group/project.git"
+	cfg.GitLabUser = "bot"
+	cfg.GitLabToken = "secret"
+	cfg.GitLabEmail = "bot@example.com"
+	cfg.APIOnly = true
+	cfg.CreateMR = true
+	cfg.Progress = false
+	cfg.TempDir = t.TempDir()
+
+	client, err := gitlab.NewClient(cfg, gitlab.WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.GitLabClient = client
+	return cfg, fake
+}
+
+// useRetryWait shortens the wait between merge request attempts for a test
+func useRetryWait(t *testing.T, wait time.Duration) {
+	t.Helper()
+	previous := mergeRequestRetryWait
+	mergeRequestRetryWait = wait
+	t.Cleanup(func() { mergeRequestRetryWait = previous })
+}
+
+func TestRetryMergeRequest(t *testing.T) {
+	useRetryWait(t, time.Millisecond)
+	failure := errors.New("GitLab API error (status 500)")
+
+	tests := []struct {
+		name         string
+		retries      int
+		failures     int
+		err          error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success", 2, 0, failure, 1, false},
+		{"success after failures", 2, 2, failure, 3, false},
+		{"retries exhausted", 2, 5, failure, 3, true},
+		{"no retries", 0, 1, failure, 1, true},
+		{"failed hook not retried", 2, 1, &gitlab.HookError{Hook: "post-update", Err: failure}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.MRRetries = tt.retries
+
+			attempts := 0
+			err := retryMergeRequest(context.Background(), cfg, "nginx", func(ctx context.Context) error {
+				attempts++
+				if attempts <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("retryMergeRequest() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, failure) {
+				t.Errorf("retryMergeRequest() error = %v, want the error of the last attempt", err)
+			}
+		})
+	}
+}
+
+func TestRetryMergeRequestBackoff(t *testing.T) {
+	useRetryWait(t, 20*time.Millisecond)
+	cfg := config.New()
+	cfg.MRRetries = 2
+
+	// The wait doubles after each failed attempt
+	var times []time.Time
+	_ = retryMergeRequest(context.Background(), cfg, "nginx", func(ctx context.Context) error {
+		times = append(times, time.Now())
+		return errors.New("failed")
+	})
+	if len(times) != 3 {
+		t.Fatalf("attempts = %d, want 3", len(times))
+	}
+	if first, second := times[1].Sub(times[0]), times[2].Sub(times[1]); first < 20*time.Millisecond || second < 40*time.Millisecond {
+		t.Errorf("waits = %s, %s, want at least 20ms then 40ms", first, second)
+	}
+
+	// A cancelled run is not retried
+	useRetryWait(t, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	attempts := 0
+	err := retryMergeRequest(ctx, cfg, "nginx", func(ctx context.Context) error {
+		attempts++
+		cancel()
+		return errors.New("failed")
+	})
+	if err == nil || attempts != 1 || time.Since(start) > time.Second {
+		t.Errorf("retryMergeRequest() error = %v after %d attempts in %s, want the failure at once", err, attempts, time.Since(start))
+	}
+}
+
+func TestMergeRequestFailures(t *testing.T) {
+	cfg := config.New()
+
+	var failures mergeRequestFailures
+	if err := failures.result(cfg); err != nil {
+		t.Errorf("result() without failures = %v, want nil", err)
+	}
+
+	failures.add("nginx (1.25.0 → 1.27.0)", errors.New("push rejected"))
+	failures.add("redis (7.0.0 → 7.4.1)", errors.New("status 500"))
+	err := failures.result(cfg)
+	if err == nil || !strings.HasPrefix(err.Error(), "2 merge requests failed") ||
+		!strings.Contains(err.Error(), "nginx (1.25.0 → 1.27.0): push rejected") || !strings.Contains(err.Error(), "redis (7.0.0 → 7.4.1): status 500") {
+		t.Errorf("result() = %v, want both failures", err)
+	}
+
+	cfg.BestEffort = true
+	if err := failures.result(cfg); err != nil {
+		t.Errorf("result() in best effort mode = %v, want nil", err)
+	}
+}
+
+// nginxUpdate returns the update of nginx in compose.yml of a run
+func nginxUpdate(cfg *config.Config) result.UpdateCandidate {
+	return result.UpdateCandidate{
+		FilePath:    filepath.Join(cfg.TempDir, "compose.yml"),
+		ServiceName: "web",
+		OldImage:    "nginx:1.25.0",
+		NewImage:    "nginx:1.27.0",
+		Repository:  "nginx",
+		OldTag:      "1.25.0",
+		NewTag:      "1.27.0",
+	}
+}
+
+func TestProposeUpdate(t *testing.T) {
+	useRetryWait(t, time.Millisecond)
+
+	t.Run("merge request opened by a failed attempt", func(t *testing.T) {
+		cfg, fake := newFakeGitLab(t, map[string]string{"compose.yml": "services:\n  web:\n    image: nginx:1.25.0\n"})
+		cfg.MRRetries = 2
+		fake.failMergeRequests = 1
+		update := nginxUpdate(cfg)
+
+		err := retryMergeRequest(context.Background(), cfg, "web", proposeUpdate(cfg, cfg.GitLabClient.(*gitlab.Client), nil, nil, update))
+		if err != nil {
+			t.Fatalf("proposeUpdate() error = %v, want the conflict of the retry taken as success", err)
+		}
+		if len(fake.mergeRequests) != 1 {
+			t.Errorf("merge requests = %v, want one", fake.mergeRequests)
+		}
+
+		// The branch is pushed once, by the first attempt
+		files := fake.commits[fake.mergeRequests[0]]
+		if len(fake.commits) != 1 || len(files) != 1 || files[0].Path != "compose.yml" || !strings.Contains(files[0].Content, "nginx:1.27.0") {
+			t.Errorf("commits = %+v, want compose.yml updated to nginx:1.27.0 once", fake.commits)
+		}
+	})
+
+	t.Run("merge request opened by another run", func(t *testing.T) {
+		cfg, fake := newFakeGitLab(t, map[string]string{"compose.yml": "services:\n  web:\n    image: nginx:1.25.0\n"})
+		update := nginxUpdate(cfg)
+		fake.mergeRequests = []string{updateBranchName(update)}
+		cfg.MRRetries = 2
+
+		err := retryMergeRequest(context.Background(), cfg, "web", proposeUpdate(cfg, cfg.GitLabClient.(*gitlab.Client), nil, nil, update))
+		if !gitlab.IsConflict(err) {
+			t.Errorf("proposeUpdate() error = %v, want the conflict of the first attempt", err)
+		}
+	})
+}
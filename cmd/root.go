@@ -1,12 +1,22 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/audit"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/secret"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/trace"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/transport"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/version"
 )
 
@@ -15,12 +25,30 @@ const (
 	ExitCodeSuccess = 0
 	// ExitCodeError indicates an error occurred
 	ExitCodeError = 1
+
+	// tracingShutdownTimeout is how long the remaining spans get to be exported on exit
+	tracingShutdownTimeout = 5 * time.Second
 )
 
 var (
+	// configFile is the configuration file given with --config
+	configFile string
+	// configProfile is the profile of the configuration file given with --profile
+	configProfile string
+	// configFileErr is the error of loading the configuration file, which is read before
+	// the commands load their settings from the environment
+	configFileErr = loadConfigFile(os.Args[1:])
+
 	// rootCfg holds the application configuration
 	rootCfg *config.Config
 
+	// auditLog records the changes of the run, nil when no audit log is set
+	auditLog *audit.Log
+	// tracing exports the spans of the run, nil when no collector is set
+	tracing *trace.Exporter
+	// runSpan is the root span of the command, parenting the spans of its operations
+	runSpan *trace.Span
+
 	// rootCmd represents the base command when called without any subcommands
 	rootCmd = &cobra.Command{
 		Use:   "img-upgr",
@@ -28,9 +56,51 @@ var (
 		Long: `A CLI tool to check for newer versions of Docker images in docker-compose files.
 It parses semver-like tags and checks Docker Hub for newer versions.`,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Trace the logs, changes and results back to this run
+			if rootCfg.RunID != "" {
+				if err := runid.Set(rootCfg.RunID); err != nil {
+					logger.Error("%v", err)
+					os.Exit(ExitCodeError)
+				}
+			}
+			logger.SetRunID(runid.ID())
+
 			// Configure logger based on flags
 			rootCfg.ConfigureLogger()
+			if _, err := rootCfg.OpenLogFile(); err != nil {
+				logger.Error("%v", err)
+				os.Exit(ExitCodeError)
+			}
+
+			// Share the connections to each host between the clients
+			transport.Configure(rootCfg.HTTPIdleConns, rootCfg.HTTP2)
+
+			// Record the changes made for compliance reviews
+			var err error
+			if auditLog, err = openAuditLog(context.Background(), rootCfg); err != nil {
+				logger.Error("%v", err)
+				os.Exit(ExitCodeError)
+			}
+
+			// Export the spans of the run to the OpenTelemetry collector
+			if tracing, err = startTracing(rootCfg); err != nil {
+				logger.Error("%v", err)
+				os.Exit(ExitCodeError)
+			}
+			_, runSpan = trace.Start(context.Background(), cmd.CommandPath(), trace.String("img_upgr.run_id", runid.ID()))
+			if runSpan != nil {
+				logger.Debug("Trace ID %s", runSpan.TraceID())
+			}
 
+			if configFileErr != nil {
+				logger.Error("%v", configFileErr)
+				os.Exit(ExitCodeError)
+			}
+			if unknown := config.UnknownFileKeys(); len(unknown) > 0 {
+				logger.Warn("Unknown settings in the configuration file: %s", strings.Join(unknown, ", "))
+			}
+
+			logger.Debug("Run ID %s", runid.ID())
 			if rootCfg.Verbose {
 				PrintVerbose("Running with verbose logging")
 				PrintVerbose("Version information: %s", version.GetInfo())
@@ -42,11 +112,83 @@ It parses semver-like tags and checks Docker Hub for newer versions.`,
 // Execute adds all child commands to the root command and sets flags appropriately.
 // It returns an exit code that can be used with os.Exit.
 func Execute() int {
+	code := ExitCodeSuccess
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return ExitCodeError
+		code = ExitCodeError
+	}
+	finishRun(code)
+	return code
+}
+
+// exit ends the run and exits with code, for the commands failing or reporting their
+// result with the exit code
+func exit(code int) {
+	finishRun(code)
+	os.Exit(code)
+}
+
+// openAuditLog opens the audit log and records the changes of the run in it, nil if no
+// audit log is set
+func openAuditLog(ctx context.Context, cfg *config.Config) (*audit.Log, error) {
+	if cfg.AuditLog == "" {
+		return nil, nil
+	}
+
+	token := cfg.AuditToken
+	if secret.IsReference(token) {
+		resolved, err := secret.NewResolver().Resolve(ctx, token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", config.EnvAuditToken, err)
+		}
+		token = resolved
+	}
+	return audit.Open(cfg.AuditLog, token)
+}
+
+// startTracing exports the spans of the run to the OpenTelemetry collector, nil if no
+// collector is set
+func startTracing(cfg *config.Config) (*trace.Exporter, error) {
+	if cfg.OTLPEndpoint == "" {
+		return nil, nil
+	}
+
+	headers, err := trace.ParseHeaders(cfg.OTLPHeaders)
+	if err != nil {
+		return nil, err
+	}
+	serviceName := os.Getenv(config.EnvOTELServiceName)
+	if serviceName == "" {
+		serviceName = config.DefaultServiceName
+	}
+	return trace.Configure(cfg.OTLPEndpoint, headers,
+		trace.String("service.name", serviceName),
+		trace.String("service.version", version.GetVersion()))
+}
+
+// finishRun closes the audit log, ends the root span of the run and exports the
+// remaining spans
+func finishRun(code int) {
+	if auditLog != nil {
+		if err := auditLog.Close(); err != nil {
+			logger.Warn("Failed to close the audit log: %v", err)
+		}
+	}
+
+	if code != ExitCodeSuccess {
+		runSpan.End(fmt.Errorf("exit code %d", code))
+	} else {
+		runSpan.End(nil)
+	}
+	if tracing == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		logger.Warn("Failed to export traces: %v", err)
 	}
-	return ExitCodeSuccess
 }
 
 // init initializes the root command and sets up configuration and flags
@@ -57,8 +199,18 @@ func init() {
 	// Define persistent flags that are global to the application
 	rootCmd.PersistentFlags().BoolVarP(&rootCfg.Verbose, "verbose", "v", false, "Enable verbose output")
 	rootCmd.PersistentFlags().BoolVarP(&rootCfg.Quiet, "quiet", "q", false, "Suppress all output except errors and updates")
+	rootCmd.PersistentFlags().BoolVar(&rootCfg.NoColor, "no-color", rootCfg.NoColor, "Disable colors, which are only used on terminals and when NO_COLOR is not set")
 	rootCmd.PersistentFlags().StringVar(&rootCfg.LogLevel, "log-level", rootCfg.LogLevel,
 		"Set log level (DEBUG, INFO, WARN, ERROR, FATAL)")
+	rootCmd.PersistentFlags().StringVar(&rootCfg.LogFile, "log-file", rootCfg.LogFile, "Also write the logs to a file, rotated by size")
+	rootCmd.PersistentFlags().BoolVar(&rootCfg.LogFileOnly, "log-file-only", rootCfg.LogFileOnly, "Write the logs to the log file only, not to the console")
+	rootCmd.PersistentFlags().IntVar(&rootCfg.LogMaxSize, "log-max-size", rootCfg.LogMaxSize, "Size in megabytes the log file is rotated at, 0 to never rotate it")
+	rootCmd.PersistentFlags().IntVar(&rootCfg.LogBackups, "log-backups", rootCfg.LogBackups, "Number of rotated log files kept")
+	rootCmd.PersistentFlags().StringVar(&rootCfg.AuditLog, "audit-log", rootCfg.AuditLog, "Append the changes made and the GitLab API errors to this file, or post them to this http(s) URL")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "",
+		"Configuration file, read for the settings not set in the environment (default "+config.DefaultConfigFile()+")")
+	rootCmd.PersistentFlags().StringVar(&configProfile, "profile", "",
+		"Profile of the configuration file whose settings override its top-level ones")
 
 	// Create a custom version command that uses our detailed version output
 	versionCmd := &cobra.Command{
@@ -74,6 +226,61 @@ func init() {
 	rootCmd.AddCommand(versionCmd)
 }
 
+// loadConfigFile loads the configuration file given with --config, or in IMG_UPGR_CONFIG,
+// or the one of the user configuration directory if it exists, with the profile given
+// with --profile or in IMG_UPGR_PROFILE. Flags are not parsed yet when the commands read
+// their settings, so --config and --profile are looked up in the arguments.
+func loadConfigFile(args []string) error {
+	path := os.Getenv(config.EnvConfigFile)
+	profile := os.Getenv(config.EnvProfile)
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if value, ok := strings.CutPrefix(arg, "--config="); ok {
+			path = value
+		} else if arg == "--config" && i+1 < len(args) {
+			path = args[i+1]
+		} else if value, ok := strings.CutPrefix(arg, "--profile="); ok {
+			profile = value
+		} else if arg == "--profile" && i+1 < len(args) {
+			profile = args[i+1]
+		}
+	}
+
+	if path == "" {
+		path = config.DefaultConfigFile()
+		if _, err := os.Stat(path); path == "" || err != nil {
+			if profile != "" {
+				return fmt.Errorf("profile %q needs a configuration file, none found at %s", profile, path)
+			}
+			return nil
+		}
+	}
+	return config.LoadFile(path, profile)
+}
+
+// newSignalContext returns a context that is cancelled when the process receives SIGINT or
+// SIGTERM, holding the root span of the run
+func newSignalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(trace.ContextWithSpan(context.Background(), runSpan))
+
+	// Set up signal handling for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigChan:
+			logger.Info("Received interrupt signal, shutting down gracefully...")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigChan)
+	}()
+
+	return ctx, cancel
+}
+
 // GetConfig returns the root configuration
 func GetConfig() *config.Config {
 	return rootCfg
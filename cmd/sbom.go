@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitops"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/sbom"
+)
+
+// sbomMaxPackages is the number of packages listed in the SBOM diff of a merge request
+const sbomMaxPackages = 50
+
+// sbomDiff returns the packages added, removed and changed between the old and new image
+// of an update as a collapsible Markdown section, or an empty string when SBOMs are
+// disabled, the update is a chart or either SBOM cannot be obtained. The diff is labeled
+// unverified unless both SBOMs come from attestations verified with the signature settings.
+func sbomDiff(ctx context.Context, cfg *config.Config, update result.UpdateCandidate, maxPackages int) string {
+	if cfg.SBOM == "" || update.Kind == gitops.KindChart {
+		return ""
+	}
+
+	fetcher := sbom.NewFetcher(cfg.SBOM, cfg.SignatureVerifier())
+	oldImage := fmt.Sprintf("%s:%s", update.Repository, update.OldTag)
+	oldPackages, oldVerified, err := fetcher.Packages(ctx, oldImage)
+	if err != nil {
+		logger.Warn("Skipping SBOM diff of %s: %v", update.Repository, err)
+		return ""
+	}
+	newImage := fmt.Sprintf("%s:%s", update.Repository, update.NewTag)
+	newPackages, newVerified, err := fetcher.Packages(ctx, newImage)
+	if err != nil {
+		logger.Warn("Skipping SBOM diff of %s: %v", update.Repository, err)
+		return ""
+	}
+
+	label := "SBOM"
+	if !oldVerified || !newVerified {
+		label = "Unverified SBOM"
+	}
+
+	diff := sbom.Compare(oldPackages, newPackages)
+	if diff.Empty() {
+		return fmt.Sprintf("\n%s: no package changes between `%s` and `%s`\n", label, update.OldTag, update.NewTag)
+	}
+
+	return fmt.Sprintf("\n<details>\n<summary>%s package changes of %s (%d added, %d removed, %d changed)</summary>\n\n%s\n</details>\n",
+		label, update.Repository, len(diff.Added), len(diff.Removed), len(diff.Changed), sbom.Format(diff, maxPackages))
+}
@@ -1,32 +1,22 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/compose"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
-	"gitlab.com/sdko-core/appli/img-upgr/pkg/docker"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/update"
 )
 
-// UpdatedImage represents an image that has an update available
-type UpdatedImage struct {
-	ServiceName string // Name of the service in docker-compose
-	FilePath    string // Path to the docker-compose file
-	OldImage    string // Full old image name with tag
-	NewImage    string // Full new image name with tag
-	Repository  string // Image repository name
-	OldTag      string // Old image tag
-	NewTag      string // New image tag
-}
-
 // scanCmd represents the scan command
 var scanCmd = &cobra.Command{
 	Use:   "scan [directory]",
@@ -45,18 +35,29 @@ func runScanCmd(cmd *cobra.Command, args []string) {
 		cfg.ScanDir = args[0]
 	}
 
+	// Create a context that is cancelled on interrupt
+	ctx, cancel := newSignalContext()
+	defer cancel()
+
 	// Setup GitLab and clone repository
-	if err := setupGitLab(); err != nil {
+	if err := setupGitLab(ctx); err != nil {
 		logger.Fatal("GitLab setup failed: %v", err)
 	}
 	defer gitlab.CleanupRepository(cfg)
 
 	// Find and process compose files
-	updatedImages, err := processComposeFiles()
+	res, err := processComposeFiles(ctx)
 	if err != nil {
 		logger.Error("Error processing compose files: %v", err)
-		os.Exit(1)
+		exit(1)
+	}
+
+	// Report the results, including the files that failed
+	if err := printResult(cfg, res); err != nil {
+		logger.Error("Failed to print results: %v", err)
+		exit(1)
 	}
+	updatedImages := res.Updates
 
 	// Handle updates if found
 	if len(updatedImages) == 0 {
@@ -66,17 +67,32 @@ func runScanCmd(cmd *cobra.Command, args []string) {
 
 	PrintInfo("Found %d images to update", len(updatedImages))
 
-	// Create merge requests if requested
+	// Create merge requests if requested, unless they are frozen
 	if cfg.CreateMR {
-		createMergeRequests(updatedImages)
+		repoConfig, err := config.LoadRepoConfig(cfg.TempDir)
+		if err != nil {
+			logger.Error("Error loading repository configuration: %v", err)
+			return
+		}
+		if mergeRequestsFrozen(cfg, repoConfig, len(updatedImages)) {
+			return
+		}
+		createMergeRequests(ctx, updatedImages)
 	}
 }
 
 // setupGitLab validates GitLab configuration, initializes the client and clones the repository
-func setupGitLab() error {
+func setupGitLab(ctx context.Context) error {
 	// Comprehensive validation of all configuration
 	logger.Debug("Validating configuration...")
 
+	if err := cfg.ResolveSecrets(ctx); err != nil {
+		return err
+	}
+	if err := cfg.UseProjectToken(); err != nil {
+		return err
+	}
+
 	// First validate GitLab configuration (required for cloning)
 	if err := cfg.ValidateGitLab(); err != nil {
 		return fmt.Errorf("GitLab configuration validation failed: %w", err)
@@ -91,12 +107,17 @@ func setupGitLab() error {
 
 	// Clone repository before validating scan directory
 	logger.Info("Cloning repository: %s", cfg.GitLabRepo)
-	if err := gitlab.CloneRepository(cfg); err != nil {
+	if err := gitlab.CloneRepository(ctx, cfg); err != nil {
 		return fmt.Errorf("error cloning repository: %w", err)
 	}
 
+	// Fill the settings left unset from the repository configuration file
+	if err := applyRepoConfig(cfg); err != nil {
+		return err
+	}
+
 	// Now validate all configuration (after repository is cloned)
-	if err := cfg.ValidateAll(); err != nil {
+	if err := validateConfig(cfg); err != nil {
 		return fmt.Errorf("configuration validation failed: %w", err)
 	}
 
@@ -105,7 +126,7 @@ func setupGitLab() error {
 }
 
 // processComposeFiles finds and processes all docker-compose files in the scan directory
-func processComposeFiles() ([]UpdatedImage, error) {
+func processComposeFiles(ctx context.Context) (*result.ScanResult, error) {
 	// Find all docker-compose files
 	composeFiles, err := cfg.FindComposeFiles()
 	if err != nil {
@@ -113,57 +134,81 @@ func processComposeFiles() ([]UpdatedImage, error) {
 	}
 
 	if len(composeFiles) == 0 {
-		fmt.Println("No docker-compose files found in", cfg.ScanDir)
-		return nil, nil
+		PrintInfo("No docker-compose files found in %s", cfg.ScanDir)
+		return result.New(nil, nil), nil
 	}
 
-	PrintInfo("Found %d docker-compose files in %s", len(composeFiles), cfg.ScanDir)
+	// Follow include directives so images of included fragments are checked too, within
+	// the scanned directory and the same exclusions
+	composeFiles, err = compose.ExpandIncludes(composeFiles, cfg.ScanDir, func(path string) bool {
+		return cfg.IsExcluded(cfg.ScanDir, path)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve compose includes: %w", err)
+	}
 
-	// Create Docker client
-	dockerClient := docker.NewClient()
+	PrintInfo("Found %d docker-compose files in %s", len(composeFiles), cfg.ScanDir)
 
-	// Track updates
-	var updatedImages []UpdatedImage
+	// Create registry client
+	registryClient, err := newRegistryClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure registries: %w", err)
+	}
 
-	// Process each compose file
+	// Process each compose file, keeping the failures for the report
+	res := result.New(nil, nil)
 	for _, filePath := range composeFiles {
-		images, err := processComposeFile(filePath, dockerClient)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		images, fileErrors, err := processComposeFile(ctx, filePath, registryClient)
 		if err != nil {
 			logger.Warn("Error processing %s: %v", filePath, err)
+			res.Errors = append(res.Errors, result.FileError{FilePath: filePath, Error: err.Error()})
 			continue
 		}
-		updatedImages = append(updatedImages, images...)
+		res.Updates = append(res.Updates, images...)
+		res.Errors = append(res.Errors, fileErrors...)
+	}
+
+	if err := registryClient.SaveETagCache(); err != nil {
+		logger.Warn("Failed to save tag cache: %v", err)
 	}
 
-	return updatedImages, nil
+	return res, nil
 }
 
-// processComposeFile processes a single docker-compose file and returns any images that need updates
-func processComposeFile(filePath string, dockerClient *docker.Client) ([]UpdatedImage, error) {
+// processComposeFile processes a single docker-compose file and returns any images that need
+// updates, along with the images that could not be checked
+func processComposeFile(ctx context.Context, filePath string, registryClient registry.Client) ([]result.UpdateCandidate, []result.FileError, error) {
 	PrintInfo("Checking file: %s", filePath)
 
 	// Parse compose file
 	composeFile, err := compose.ParseComposeFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing file: %w", err)
+		return nil, nil, fmt.Errorf("error parsing file: %w", err)
 	}
 
 	// Check each image
 	images := composeFile.GetImages()
 	if len(images) == 0 {
 		PrintInfo("  No images found in %s", filePath)
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	PrintInfo("  Found %d services with images", len(images))
 
-	var updatedImages []UpdatedImage
+	var updatedImages []result.UpdateCandidate
+	var fileErrors []result.FileError
 
 	// Process each image
+	constraints := composeFile.GetConstraints()
 	for serviceName, imageName := range images {
-		image, err := checkImageForUpdates(serviceName, imageName, filePath, dockerClient)
+		image, err := checkImageForUpdates(ctx, serviceName, imageName, filePath, constraints, registryClient)
 		if err != nil {
 			logger.Debug("    Error checking %s: %v", serviceName, err)
+			fileErrors = append(fileErrors, result.FileError{FilePath: filePath, ServiceName: serviceName, Error: err.Error()})
 			continue
 		}
 
@@ -172,14 +217,21 @@ func processComposeFile(filePath string, dockerClient *docker.Client) ([]Updated
 		}
 	}
 
-	return updatedImages, nil
+	setLines(updatedImages, composeFile.GetImageLines())
+
+	return updatedImages, fileErrors, nil
 }
 
-// checkImageForUpdates checks if an image has updates available
-func checkImageForUpdates(serviceName, imageName, filePath string, dockerClient *docker.Client) (*UpdatedImage, error) {
+// checkImageForUpdates checks if an image has updates available within its version range
+func checkImageForUpdates(ctx context.Context, serviceName, imageName, filePath string, constraints map[string]string, registryClient registry.Client) (*result.UpdateCandidate, error) {
 	PrintInfo("  Checking image for service %s: %s", serviceName, imageName)
 
-	info, err := update.CheckImage(imageName, dockerClient)
+	opts, err := updateOptions(cfg, constraints, serviceName, imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := update.CheckImageWithContext(ctx, imageName, registryClient, opts)
 	if err != nil {
 		if strings.Contains(err.Error(), "no tag found") ||
 			strings.Contains(err.Error(), "tag not semver-like") {
@@ -198,26 +250,36 @@ func checkImageForUpdates(serviceName, imageName, filePath string, dockerClient
 	}
 
 	if !info.HasUpdate {
-		PrintVerbose("    ✓ Image is up to date")
+		if info.IsDowngrade {
+			PrintVerbose("    ✓ Keeping %s, newer than the latest allowed %s", info.Tag, info.LatestTag)
+		} else {
+			PrintVerbose("    ✓ Image is up to date")
+		}
 		return nil, nil
 	}
 
-	PrintInfo("    ✓ Update available: %s → %s", info.Tag, info.LatestTag)
+	if info.IsDowngrade {
+		PrintInfo("    ↓ Downgrade proposed: %s → %s", info.Tag, info.LatestTag)
+	} else {
+		PrintInfo("    ✓ Update available: %s → %s", info.Tag, info.LatestTag)
+	}
 	PrintInfo("      Suggested image: %s:%s", info.Repository, info.LatestTag)
 
-	return &UpdatedImage{
+	return &result.UpdateCandidate{
 		ServiceName: serviceName,
 		FilePath:    filePath,
 		OldImage:    imageName,
-		NewImage:    fmt.Sprintf("%s:%s", info.Repository, info.LatestTag),
+		NewImage:    fmt.Sprintf("%s:%s", suggestedRepository(cfg, info.Repository), info.LatestTag),
 		Repository:  info.Repository,
 		OldTag:      info.Tag,
 		NewTag:      info.LatestTag,
+		Major:       info.IsMajor,
+		Downgrade:   info.IsDowngrade,
 	}, nil
 }
 
 // createMergeRequests creates merge requests for each updated image
-func createMergeRequests(updates []UpdatedImage) {
+func createMergeRequests(ctx context.Context, updates []result.UpdateCandidate) {
 	// Verify GitLab client exists
 	if cfg.GitLabClient == nil {
 		logger.Error("GitLab client not initialized")
@@ -232,7 +294,12 @@ func createMergeRequests(updates []UpdatedImage) {
 
 	// Process each image update individually
 	for _, update := range updates {
-		if err := createMergeRequestForUpdate(update); err != nil {
+		if ctx.Err() != nil {
+			logger.Error("Interrupted, not creating the remaining merge requests")
+			return
+		}
+
+		if err := createMergeRequestForUpdate(ctx, update); err != nil {
 			logger.Error("Failed to create merge request for %s: %v",
 				update.ServiceName, err)
 			continue
@@ -243,13 +310,36 @@ func createMergeRequests(updates []UpdatedImage) {
 }
 
 // createMergeRequestForUpdate creates a merge request for a single image update
-func createMergeRequestForUpdate(update UpdatedImage) error {
-	// Create a unique branch name
-	branchName := generateBranchName(update.ServiceName)
+func createMergeRequestForUpdate(ctx context.Context, update result.UpdateCandidate) error {
+	// Branches are named after the update so reruns find them again
+	branchName, action, err := resolveUpdateBranch(cfg, updateBranchName(update), branchExists(ctx, cfg))
+	if err != nil {
+		return err
+	}
 
-	// Create branch in local repository
+	if action == branchReuse {
+		PrintInfo("Reusing existing branch %s for updating %s", branchName, update.ServiceName)
+	} else if err := pushBranchForUpdate(ctx, branchName, action == branchForce, update); err != nil {
+		return err
+	}
+
+	// Create merge request
+	if err := submitMergeRequest(ctx, branchName, update); err != nil {
+		return fmt.Errorf("failed to create merge request: %w", err)
+	}
+
+	return nil
+}
+
+// pushBranchForUpdate creates the branch of an update, or resets an existing one, and
+// pushes the updated file to it
+func pushBranchForUpdate(ctx context.Context, branchName string, reset bool, update result.UpdateCandidate) error {
 	PrintInfo("Creating branch %s for updating %s", branchName, update.ServiceName)
-	if err := gitlab.CreateBranchInRepo(cfg, branchName, cfg.TargetBranch); err != nil {
+	prepare, commit := gitlab.CreateBranchInRepo, gitlab.CommitAndPushChanges
+	if reset {
+		prepare, commit = gitlab.ResetBranchInRepo, gitlab.CommitAndForcePushChanges
+	}
+	if err := prepare(ctx, cfg, branchName, cfg.TargetBranch); err != nil {
 		return fmt.Errorf("failed to create branch: %w", err)
 	}
 
@@ -265,69 +355,25 @@ func createMergeRequestForUpdate(update UpdatedImage) error {
 	commitMsg := fmt.Sprintf("Update Docker image for %s in %s",
 		update.ServiceName, filepath.Base(update.FilePath))
 
-	if err := gitlab.CommitAndPushChanges(cfg, commitMsg); err != nil {
+	files := updatedFiles(cfg, []result.UpdateCandidate{update})
+	if err := commit(ctx, cfg, commitMessage(ctx, cfg, commitMsg, files), files); err != nil {
 		return fmt.Errorf("failed to commit changes: %w", err)
 	}
-
-	// Create merge request
-	if err := submitMergeRequest(update); err != nil {
-		return fmt.Errorf("failed to create merge request: %w", err)
-	}
-
 	return nil
 }
 
-// generateBranchName creates a unique branch name for an update
-func generateBranchName(serviceName string) string {
-	timestamp := time.Now().Format("20060102-150405")
-	sanitizedName := sanitizeBranchName(serviceName)
-	return fmt.Sprintf("img-upgr/%s-%s", sanitizedName, timestamp)
-}
-
-// sanitizeBranchName removes characters that are not allowed in branch names
-func sanitizeBranchName(name string) string {
-	// Replace slashes with hyphens
-	name = strings.ReplaceAll(name, "/", "-")
-
-	// Replace other potentially problematic characters
-	name = strings.ReplaceAll(name, ":", "-")
-	name = strings.ReplaceAll(name, ".", "-")
-
-	return name
-}
-
 // updateFileContent updates the image reference in the file
-func updateFileContent(update UpdatedImage) error {
-	// Read file content
-	content, err := os.ReadFile(update.FilePath)
-	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
-	}
-
-	// Update content with only this specific image
-	newContent := strings.ReplaceAll(string(content), update.OldImage, update.NewImage)
-
-	// Write updated content back to file
-	if err := os.WriteFile(update.FilePath, []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
-	}
-
-	return nil
+func updateFileContent(update result.UpdateCandidate) error {
+	return compose.UpdateServiceImage(update.FilePath, update.ServiceName, update.OldImage, update.NewImage)
 }
 
 // submitMergeRequest creates and submits a merge request for the changes
-func submitMergeRequest(update UpdatedImage) error {
-	// Get current branch name
-	currentBranch, err := gitlab.GetCurrentBranch(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to get current branch: %w", err)
-	}
-
+func submitMergeRequest(ctx context.Context, branchName string, update result.UpdateCandidate) error {
 	// Create merge request title and description
 	title := fmt.Sprintf("Update %s from %s to %s",
 		update.ServiceName, update.OldTag, update.NewTag)
 
-	description := buildMergeRequestDescription(update)
+	description := buildMergeRequestDescription(ctx, update)
 
 	PrintInfo("Creating merge request for %s", update.ServiceName)
 
@@ -338,8 +384,8 @@ func submitMergeRequest(update UpdatedImage) error {
 	}
 
 	// Create the merge request
-	_, err = gitlabClient.CreateMergeRequest(
-		currentBranch, cfg.TargetBranch, title, description)
+	_, err := gitlabClient.CreateMergeRequestWithContext(ctx,
+		branchName, cfg.TargetBranch, title, description)
 	if err != nil {
 		return fmt.Errorf("failed to create merge request: %w", err)
 	}
@@ -348,11 +394,12 @@ func submitMergeRequest(update UpdatedImage) error {
 }
 
 // buildMergeRequestDescription creates a description for the merge request
-func buildMergeRequestDescription(update UpdatedImage) string {
+func buildMergeRequestDescription(ctx context.Context, update result.UpdateCandidate) string {
 	description := "Automated update of Docker image by img-upgr\n\n"
 	description += fmt.Sprintf("Service: `%s`\n", update.ServiceName)
 	description += fmt.Sprintf("File: `%s`\n", filepath.Base(update.FilePath))
 	description += fmt.Sprintf("Update: `%s` → `%s`\n", update.OldTag, update.NewTag)
+	description += fmt.Sprintf("\nGenerated by run `%s`\n", runid.FromContext(ctx))
 
 	return description
 }
@@ -368,5 +415,21 @@ func init() {
 
 	// Add command-specific flags
 	scanCmd.Flags().BoolVar(&cfg.CreateMR, "create-mr", false, "Create merge requests for updates")
+	scanCmd.Flags().BoolVar(&cfg.AllowMajor, "allow-major", cfg.AllowMajor, "Also propose updates to a new major version")
+	scanCmd.Flags().BoolVar(&cfg.AllowDowngrade, "allow-downgrade", cfg.AllowDowngrade, "Propose rolling back tags newer than the latest allowed version")
+	scanCmd.Flags().StringVar(&cfg.TagCatalog, "tag-catalog", cfg.TagCatalog, "Read tags from a catalog written by export-tags instead of the registries")
+	scanCmd.Flags().StringVar(&cfg.Platform, "platform", cfg.Platform, "Only suggest tags published for this platform (e.g. linux/arm64)")
 	scanCmd.Flags().StringVar(&cfg.TargetBranch, "target-branch", cfg.TargetBranch, "Target branch for merge requests")
+	scanCmd.Flags().StringVarP(&cfg.OutputFormat, "output", "o", cfg.OutputFormat, "Output format ("+strings.Join(result.Formats(), ", ")+")")
+	scanCmd.Flags().StringVar(&cfg.ReportFile, "report-file", cfg.ReportFile, "Also write the result as JSON to this file, e.g. report.json for CI artifacts")
+	scanCmd.Flags().StringVar(&cfg.DotenvFile, "dotenv-file", cfg.DotenvFile, "Also write the counts of the result to this dotenv file for GitLab CI reports")
+
+	// File discovery flags
+	scanCmd.Flags().StringSliceVar(&cfg.ComposePatterns, "compose-pattern", cfg.ComposePatterns,
+		"Glob patterns of compose files replacing the default docker-compose/compose name matching (e.g. \"stack.yml\", \"deploy/*.yaml\")")
+	scanCmd.Flags().StringSliceVar(&cfg.Exclude, "exclude", cfg.Exclude,
+		"Glob patterns of paths to exclude, relative to the scan directory (e.g. \"**/test/**\")")
+	scanCmd.Flags().StringSliceVar(&cfg.SkipDirs, "skip-dir", cfg.SkipDirs,
+		"Additional directory names to skip at any depth")
+	scanCmd.Flags().BoolVar(&cfg.Gitignore, "gitignore", cfg.Gitignore, "Skip files ignored by .gitignore")
 }
@@ -0,0 +1,474 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/trace"
+)
+
+const (
+	// maxScanRequestSize is the largest accepted scan request body
+	maxScanRequestSize = 64 << 10
+
+	// serverShutdownTimeout is how long running scans get to finish on shutdown
+	serverShutdownTimeout = 30 * time.Second
+)
+
+var (
+	// serveCfg holds the configuration for the serve command
+	serveCfg *config.Config
+
+	// serveOnce scans the configured repository once instead of serving
+	serveOnce bool
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run an HTTP API to trigger scans",
+	Long: `Run an HTTP server scanning repositories on demand.
+
+POST /scan checks a repository and returns the result as JSON:
+
+  {"repo": "https://gitlab.example.com/group/project", "scan_dir": "deploy", "create_mr": true}
+
+Every field is optional, the defaults are read from the environment like for
+the check command. The repository must be on the host of IMG_UPGR_GL_REPO as
+the token of the bot is sent to it. GET /healthz reports whether the server is
+up, GET /readyz whether it accepts scans and fails once it is shutting down.
+When IMG_UPGR_SERVE_TOKEN is set, requests must send it as a bearer token. It
+is required unless the server listens on a loopback address.
+
+When IMG_UPGR_WEBHOOK_SECRET is set, POST /webhook/gitlab accepts GitLab push
+and tag push events sent with this secret token. Pushes to the default branch
+of a repository rescan it, tag pushes of a project rescan the repositories
+consuming its images listed in IMG_UPGR_WEBHOOK_CONSUMERS. The scans run in
+the background and create merge requests for the updates found.
+
+POST /webhook/registry accepts Harbor push webhooks with the secret as
+Authorization header. Docker Hub cannot set headers, POST
+/webhook/registry/dockerhub also accepts the secret as token query parameter,
+which proxies and access logs may record. The services using the pushed
+repositories, as recorded in the IMG_UPGR_STATE store by previous runs, are
+checked again.
+
+On SIGTERM the server stops accepting requests and lets the running scans and
+merge requests finish. With --once, the repository of IMG_UPGR_GL_REPO is
+scanned a single time without serving, for a Kubernetes CronJob: the exit code
+is 1 when the scan fails or has errors, 0 otherwise.
+
+Examples:
+  IMG_UPGR_SERVE_TOKEN=secret img-upgr serve   Listen on :8080 with a token
+  img-upgr serve --listen 127.0.0.1:9000       Listen on the loopback port 9000
+  img-upgr serve --once                        Scan once and exit`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Create a context that is cancelled on interrupt
+		ctx, cancel := newSignalContext()
+		defer cancel()
+
+		if serveOnce {
+			exit(runServeOnce(ctx, serveCfg))
+		}
+		if err := runServeCommand(ctx, serveCfg); err != nil {
+			logger.Error("Serve command failed: %v", err)
+			exit(1)
+		}
+	},
+}
+
+// scanRequest is the body of a scan request, empty fields keeping the server configuration
+type scanRequest struct {
+	Repo            string   `json:"repo"`
+	ScanDir         string   `json:"scan_dir"`
+	TargetBranch    string   `json:"target_branch"`
+	CreateMR        bool     `json:"create_mr"`
+	ComposePatterns []string `json:"compose_patterns"`
+	Exclude         []string `json:"exclude"`
+	GitOps          *bool    `json:"gitops"`
+	Terraform       *bool    `json:"terraform"`
+}
+
+// scanServer serves scan requests with a base configuration
+type scanServer struct {
+	cfg *config.Config
+	// repoLocks serializes the scans of a repository, which share their work directory
+	repoLocks sync.Map
+	// webhooks runs the scans triggered by GitLab webhooks
+	webhooks *webhookScans
+	// ready is set while the server listens and is not shutting down
+	ready atomic.Bool
+}
+
+// runServeCommand runs the HTTP server until the context is cancelled
+func runServeCommand(ctx context.Context, cfg *config.Config) error {
+	if cfg.GitLabRepo == "" {
+		return fmt.Errorf("%s must be set to the repository, or a repository of the GitLab instance, to scan", config.EnvGitLabRepo)
+	}
+	if _, err := url.Parse(cfg.GitLabRepo); err != nil {
+		return fmt.Errorf("invalid repository URL: %w", err)
+	}
+	if err := cfg.ResolveSecrets(ctx); err != nil {
+		return err
+	}
+	// Scans create merge requests with the token of the bot, only local clients may
+	// trigger them without authenticating
+	if cfg.ServeToken == "" {
+		if !isLoopback(cfg.Listen) {
+			return fmt.Errorf("%s must be set to listen on %s, or listen on a loopback address such as 127.0.0.1:8080", config.EnvServeToken, cfg.Listen)
+		}
+		logger.Warn("%s is not set, any local process can trigger scans", config.EnvServeToken)
+	}
+
+	if cfg.WebhookSecret == "" {
+		logger.Debug("%s is not set, GitLab webhooks are disabled", config.EnvWebhookSecret)
+	}
+
+	scans := newScanServer(cfg)
+	server := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           scans.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("Listening on %s", cfg.Listen)
+		serveErr <- server.Serve(listener)
+	}()
+	scans.ready.Store(true)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	scans.ready.Store(false)
+	logger.Info("Shutting down, waiting for running scans")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down: %w", err)
+	}
+	if err := scans.webhooks.wait(shutdownCtx); err != nil {
+		return fmt.Errorf("webhook scans did not finish: %w", err)
+	}
+	return nil
+}
+
+// isLoopback reports whether a listen address only accepts local connections
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// newScanServer creates a server scanning with copies of cfg
+func newScanServer(cfg *config.Config) *scanServer {
+	return &scanServer{cfg: cfg, webhooks: newWebhookScans()}
+}
+
+// routes returns the handler of the API
+func (s *scanServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+	mux.Handle("POST /scan", s.authenticate(http.HandlerFunc(s.handleScan)))
+	mux.Handle("POST /webhook/gitlab", s.authenticateWebhook(http.HandlerFunc(s.handleGitLabWebhook)))
+	mux.Handle("POST /webhook/registry", s.authenticateRegistryWebhook(http.HandlerFunc(s.handleRegistryWebhook), false))
+	mux.Handle("POST /webhook/registry/dockerhub", s.authenticateRegistryWebhook(http.HandlerFunc(s.handleRegistryWebhook), true))
+	return mux
+}
+
+// authenticate requires the configured bearer token, if any
+func (s *scanServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.ServeToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.ServeToken)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleScan checks the requested repository and returns the result
+func (s *scanServer) handleScan(w http.ResponseWriter, r *http.Request) {
+	var req scanRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxScanRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	cfg, err := s.requestConfig(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	unlock := s.lockRepository(cfg.GitLabRepo)
+	defer unlock()
+
+	// Each scan is a run of its own, traced by its ID in the logs, merge requests and result
+	runID := runid.New()
+	logger.Info("Scanning %s in run %s", cfg.GitLabRepo, runID)
+	res, err := runServerScan(runid.NewContext(r.Context(), runID), cfg, nil)
+	if err != nil {
+		logger.Error("Scan of %s in run %s failed: %v", cfg.GitLabRepo, runID, err)
+		if res != nil {
+			// The updates found are reported along with the merge requests that failed
+			writeJSON(w, http.StatusBadGateway, scanFailure{ScanResult: res, Error: err.Error()})
+			return
+		}
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// lockRepository waits until no other scan runs on a repository, as scans of the
+// same repository would share their work directory, and returns the unlock function
+func (s *scanServer) lockRepository(repo string) func() {
+	lock, _ := s.repoLocks.LoadOrStore(repoKey(repo), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// repoKey identifies a repository whether its URL has the .git suffix or not
+func repoKey(repo string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(repo, "/"), ".git"))
+}
+
+// requestConfig returns a copy of the server configuration with the settings of a request
+func (s *scanServer) requestConfig(req scanRequest) (*config.Config, error) {
+	cfg := s.cfg.Clone()
+	cfg.GitLabClient = nil
+	cfg.TempDir = ""
+	cfg.ClonedRepo = false
+	cfg.PlanFile = ""
+	cfg.StateStore = ""
+	cfg.CreateMR = req.CreateMR
+	cfg.DryRun = !req.CreateMR
+
+	if req.Repo != "" {
+		if err := checkSameHost(s.cfg.GitLabRepo, req.Repo); err != nil {
+			return nil, err
+		}
+		cfg.GitLabRepo = req.Repo
+	}
+	if err := cfg.UseProjectToken(); err != nil {
+		return nil, err
+	}
+
+	if req.ScanDir != "" {
+		if !filepath.IsLocal(filepath.FromSlash(req.ScanDir)) {
+			return nil, fmt.Errorf("scan_dir must be a path inside the repository: %s", req.ScanDir)
+		}
+		cfg.ScanDir = req.ScanDir
+	}
+
+	if req.TargetBranch != "" {
+		cfg.TargetBranch = req.TargetBranch
+	}
+	if req.ComposePatterns != nil {
+		cfg.ComposePatterns = req.ComposePatterns
+	}
+	if req.Exclude != nil {
+		cfg.Exclude = req.Exclude
+	}
+	if req.GitOps != nil {
+		cfg.GitOps = *req.GitOps
+	}
+	if req.Terraform != nil {
+		cfg.Terraform = *req.Terraform
+	}
+
+	return cfg, nil
+}
+
+// checkSameHost makes sure a requested repository is on the host of the configured
+// one, so that the token is never sent to another server
+func checkSameHost(configured, requested string) error {
+	configuredURL, err := url.Parse(configured)
+	if err != nil {
+		return fmt.Errorf("invalid configured repository URL: %w", err)
+	}
+	requestedURL, err := url.Parse(requested)
+	if err != nil {
+		return fmt.Errorf("invalid repository URL: %w", err)
+	}
+
+	if requestedURL.Scheme != configuredURL.Scheme || !strings.EqualFold(requestedURL.Host, configuredURL.Host) {
+		return fmt.Errorf("repository must be on %s://%s", configuredURL.Scheme, configuredURL.Host)
+	}
+	return nil
+}
+
+// runServeOnce scans the configured repository a single time and returns the exit code
+func runServeOnce(ctx context.Context, cfg *config.Config) int {
+	if err := cfg.ResolveSecrets(ctx); err != nil {
+		logger.Error("Serve command failed: %v", err)
+		return ExitCodeError
+	}
+
+	res, err := runServerScan(ctx, cfg, nil)
+	if res != nil {
+		if err := printResult(cfg, res); err != nil {
+			logger.Error("Failed to print the result: %v", err)
+			return ExitCodeError
+		}
+	}
+	if err != nil {
+		logger.Error("Scan failed: %v", err)
+		return ExitCodeError
+	}
+	if res.HasErrors() {
+		logger.Error("Scan finished with %d errors", len(res.Errors))
+		return ExitCodeError
+	}
+	return ExitCodeSuccess
+}
+
+// runServerScan checks a repository like the check command, creating merge requests
+// if requested, and returns the result with repository-relative paths. A target
+// restricts the scan to some files and images. The result is returned even when
+// merge requests fail, along with the error.
+func runServerScan(ctx context.Context, cfg *config.Config, target *scanTarget) (res *result.ScanResult, err error) {
+	ctx, span := trace.Start(ctx, "scan", trace.String("img_upgr.repository", cfg.GitLabRepo), trace.String("img_upgr.run_id", runid.FromContext(ctx)))
+	defer func() { span.End(err) }()
+
+	if err := initializeAndValidate(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("initialization failed: %w", err)
+	}
+	defer gitlab.CleanupRepository(cfg)
+
+	composeFiles, err := determineFilesToScan(cfg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine files to scan: %w", err)
+	}
+	if target != nil {
+		composeFiles = target.filterFiles(cfg, composeFiles)
+	}
+
+	registryClient, err := newRegistryClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure registries: %w", err)
+	}
+
+	updates, fileErrors, err := processComposeFilesWithContext(ctx, cfg, nil, composeFiles, registryClient)
+	if err != nil {
+		return nil, fmt.Errorf("error processing compose files: %w", err)
+	}
+	var notices []result.EndOfLifeNotice
+	if cfg.EndOfLife {
+		notices, updates = checkEndOfLife(ctx, cfg, composeFiles, updates, registryClient)
+	}
+	if target != nil {
+		updates = target.filterUpdates(updates)
+	}
+
+	// The result is returned along with failed merge requests, the updates were found
+	var mrErr error
+	if cfg.CreateMR && len(updates) > 0 {
+		if _, err := createMergeRequestsForUpdates(ctx, cfg, filterDeclinedUpdates(ctx, cfg, updates)); err != nil {
+			mrErr = fmt.Errorf("failed to create merge requests: %w", err)
+		}
+	}
+
+	// The clone is removed once the request is served, only relative paths make sense
+	for i := range updates {
+		updates[i].FilePath = repoRelativePath(cfg, updates[i].FilePath)
+	}
+	for i := range fileErrors {
+		fileErrors[i].FilePath = repoRelativePath(cfg, fileErrors[i].FilePath)
+	}
+	for i := range notices {
+		notices[i].FilePath = repoRelativePath(cfg, notices[i].FilePath)
+	}
+
+	res = result.New(updates, fileErrors)
+	res.RunID = runid.FromContext(ctx)
+	res.EndOfLife = notices
+	if res.Updates == nil {
+		res.Updates = []result.UpdateCandidate{}
+	}
+	if res.Errors == nil {
+		res.Errors = []result.FileError{}
+	}
+	return res, mrErr
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warn("Failed to write response: %v", err)
+	}
+}
+
+// scanFailure is the response of a scan whose merge requests failed, holding its result
+type scanFailure struct {
+	*result.ScanResult
+	Error string `json:"error"`
+}
+
+// writeError writes an error as a JSON response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// init registers the serve command and its flags
+func init() {
+	serveCfg = config.New()
+	serveCfg.LoadFromEnv()
+
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().StringVar(&serveCfg.Listen, "listen", serveCfg.Listen, "Address to listen on")
+	serveCmd.Flags().BoolVar(&serveCfg.APIOnly, "api-only", serveCfg.APIOnly, "Use the GitLab API instead of cloning with git")
+	serveCmd.Flags().StringVar(&serveCfg.WorkDir, "workdir", serveCfg.WorkDir, "Keep clones in this directory and fetch them incrementally")
+	serveCmd.Flags().BoolVar(&serveOnce, "once", false, "Scan the configured repository once and exit, for a Kubernetes CronJob")
+}
@@ -0,0 +1,291 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+)
+
+// newTestRepository creates a git repository holding files and returns its file:// URL
+func newTestRepository(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q", "-b", "main"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, output)
+		}
+	}
+	return "file://" + filepath.ToSlash(dir)
+}
+
+// newTestCatalog writes a catalog of repositories and their tags and returns its path
+func newTestCatalog(t *testing.T, tags map[string][]string) string {
+	t.Helper()
+	catalog := registry.NewCatalog()
+	for repo, repoTags := range tags {
+		catalog.RecordTags(repo, repoTags)
+	}
+	path := filepath.Join(t.TempDir(), "tags.json")
+	if err := catalog.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestServer returns a scan server of a repository answered from a catalog
+func newTestServer(t *testing.T, repo string) *scanServer {
+	t.Helper()
+	cfg := config.New()
+	cfg.GitLabRepo = repo
+	cfg.GitLabUser = "bot"
+	cfg.GitLabToken = "secret"
+	cfg.GitLabEmail = "bot@example.com"
+	cfg.Progress = false
+	cfg.TagCatalog = newTestCatalog(t, map[string][]string{"nginx": {"1.25.0", "1.25.3", "1.27.0"}})
+	cfg.ServeToken = "serve-token"
+	return newScanServer(cfg)
+}
+
+func TestAuthenticate(t *testing.T) {
+	server := newScanServer(config.New())
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{"no token configured", "", "", http.StatusNoContent},
+		{"valid token", "secret", "Bearer secret", http.StatusNoContent},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"not a bearer token", "secret", "secret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.cfg.ServeToken = tt.token
+			req := httptest.NewRequest(http.MethodPost, "/scan", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			server.authenticate(next).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:8080": true,
+		"[::1]:8080":     true,
+		"localhost:8080": true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.5:8080":  false,
+		"8080":           false,
+	}
+	for listen, want := range tests {
+		if got := isLoopback(listen); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", listen, got, want)
+		}
+	}
+}
+
+func TestHandleScanRejectsRequests(t *testing.T) {
+	server := newTestServer(t, "https://gitlab.example.com/group/project")
+	handler := server.routes()
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"invalid JSON", `{"repo":`, http.StatusBadRequest},
+		{"unknown field", `{"branch": "main"}`, http.StatusBadRequest},
+		{"repository on another host", `{"repo": "https://evil.example.com/group/project"}`, http.StatusBadRequest},
+		{"repository over another scheme", `{"repo": "http://gitlab.example.com/group/project"}`, http.StatusBadRequest},
+		{"scan directory outside the repository", `{"scan_dir": "../etc"}`, http.StatusBadRequest},
+		{"absolute scan directory", `{"scan_dir": "/etc"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/scan", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer serve-token")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/scan", strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated scan status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestHandleScan(t *testing.T) {
+	repo := newTestRepository(t, map[string]string{
+		"deploy/docker-compose.yml": "services:\n  web:\n    image: nginx:1.25.0\n",
+		"other/docker-compose.yml":  "services:\n  proxy:\n    image: nginx:1.25.3\n",
+	})
+	server := newTestServer(t, repo)
+
+	req := httptest.NewRequest(http.MethodPost, "/scan", strings.NewReader(`{"scan_dir": "deploy"}`))
+	req.Header.Set("Authorization", "Bearer serve-token")
+	rec := httptest.NewRecorder()
+	server.routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var res result.ScanResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid result %s: %v", rec.Body.String(), err)
+	}
+	if len(res.Updates) != 1 || res.Updates[0].FilePath != "deploy/docker-compose.yml" || res.Updates[0].NewTag != "1.27.0" {
+		t.Errorf("updates = %+v, want nginx 1.27.0 in deploy/docker-compose.yml", res.Updates)
+	}
+
+	// Each scan is a run of its own
+	if res.RunID == "" || res.RunID == runid.ID() {
+		t.Errorf("run ID = %q, want a run ID of the scan rather than %q of the server", res.RunID, runid.ID())
+	}
+
+	// The request settings do not leak into the server configuration
+	if server.cfg.ScanDir != "" || server.cfg.TempDir != "" || server.cfg.GitLabClient != nil {
+		t.Errorf("scan changed the server configuration: %+v", server.cfg)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	server := newTestServer(t, "https://gitlab.example.com/group/project")
+	handler := server.routes()
+
+	tests := []struct {
+		name       string
+		ready      bool
+		wantCode   int
+		wantStatus string
+	}{
+		{"not listening yet", false, http.StatusServiceUnavailable, "draining"},
+		{"listening", true, http.StatusOK, "ready"},
+		{"shutting down", false, http.StatusServiceUnavailable, "draining"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.ready.Store(tt.ready)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+			}
+			if rec.Code != tt.wantCode || body["status"] != tt.wantStatus {
+				t.Errorf("GET /readyz = %d %v, want %d %s", rec.Code, body, tt.wantCode, tt.wantStatus)
+			}
+		})
+	}
+
+	// The liveness probe succeeds whether the server is ready or not
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /healthz = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRunServeOnce(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantCode    int
+		wantUpdates int
+	}{
+		{"updates found", map[string]string{"docker-compose.yml": "services:\n  web:\n    image: nginx:1.25.0\n"}, ExitCodeSuccess, 1},
+		{"up to date", map[string]string{"docker-compose.yml": "services:\n  web:\n    image: nginx:1.27.0\n"}, ExitCodeSuccess, 0},
+		{"scan errors", map[string]string{"docker-compose.yml": "services:\n  web:\n    image: nginx:1.25.0\n", "apps/docker-compose.yml": "services: [\n"}, ExitCodeError, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestServer(t, newTestRepository(t, tt.files)).cfg
+			cfg.OutputFormat = "json"
+			cfg.ReportFile = filepath.Join(t.TempDir(), "report.json")
+
+			// A single scan is run and the function returns instead of serving
+			if code := runServeOnce(context.Background(), cfg); code != tt.wantCode {
+				t.Errorf("runServeOnce() = %d, want %d", code, tt.wantCode)
+			}
+
+			data, err := os.ReadFile(cfg.ReportFile)
+			if err != nil {
+				t.Fatalf("report of the scan not written: %v", err)
+			}
+			var res result.ScanResult
+			if err := json.Unmarshal(data, &res); err != nil {
+				t.Fatalf("invalid report %s: %v", data, err)
+			}
+			if len(res.Updates) != tt.wantUpdates {
+				t.Errorf("updates = %+v, want %d", res.Updates, tt.wantUpdates)
+			}
+		})
+	}
+
+	// A repository that cannot be cloned fails the run
+	cfg := newTestServer(t, "file://"+filepath.ToSlash(filepath.Join(t.TempDir(), "missing"))).cfg
+	cfg.GitRetries = 0
+	if code := runServeOnce(context.Background(), cfg); code != ExitCodeError {
+		t.Errorf("runServeOnce() of a missing repository = %d, want %d", code, ExitCodeError)
+	}
+}
+
+func TestServeRequiresToken(t *testing.T) {
+	cfg := config.New()
+	cfg.GitLabRepo = "https://gitlab.example.com/group/project"
+	cfg.Listen = ":0"
+
+	err := runServeCommand(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), config.EnvServeToken) {
+		t.Errorf("runServeCommand() error = %v, want %s required", err, config.EnvServeToken)
+	}
+}
@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+// plannedMergeRequest is a merge request a dry run would create or refresh
+type plannedMergeRequest struct {
+	Action       string
+	SourceBranch string
+	TargetBranch string
+	Title        string
+	// Problem explains why the merge request would fail, empty when it would succeed
+	Problem string
+	// Note gives details about the action, such as the merge request refreshed
+	Note string
+}
+
+// branchChecker looks up branches in the GitLab project, remembering the answers
+type branchChecker struct {
+	client *gitlab.Client
+	known  map[string]bool
+}
+
+// exists reports whether a branch exists, or the error looking it up
+func (b *branchChecker) exists(ctx context.Context, name string) (bool, error) {
+	if exists, ok := b.known[name]; ok {
+		return exists, nil
+	}
+
+	exists, err := b.client.BranchExistsWithContext(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	b.known[name] = exists
+	return exists, nil
+}
+
+// checkTarget returns why a merge request could not target a branch, empty if it can
+func (b *branchChecker) checkTarget(ctx context.Context, branch string) string {
+	exists, err := b.exists(ctx, branch)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("could not check target branch %s: %v", branch, err)
+	case !exists:
+		return fmt.Sprintf("target branch %s does not exist", branch)
+	}
+	return ""
+}
+
+// simulateMergeRequests validates the merge requests of the updates against GitLab without
+// changing anything: it checks the token scopes, the target branches and the source branch
+// names, then reports the merge requests that would be created and why any would fail
+func simulateMergeRequests(ctx context.Context, cfg *config.Config, updates []result.UpdateCandidate) error {
+	gitlabClient, ok := cfg.GitLabClient.(*gitlab.Client)
+	if !ok {
+		logger.Info("Dry run mode: skipping merge request creation")
+		return nil
+	}
+
+	logger.Info("Dry run mode: validating merge requests against GitLab without creating them")
+	if err := checkTokenScopes(ctx, gitlabClient); err != nil {
+		return fmt.Errorf("merge requests would fail: %w", err)
+	}
+
+	repoConfig, err := config.LoadRepoConfig(cfg.TempDir)
+	if err != nil {
+		return err
+	}
+	if mergeRequestsFrozen(cfg, repoConfig, len(updates)) {
+		return nil
+	}
+
+	updates = filterDeclinedUpdates(ctx, cfg, updates)
+	branches := &branchChecker{client: gitlabClient, known: make(map[string]bool)}
+
+	var planned []plannedMergeRequest
+	if cfg.GroupBy != "none" {
+		planned, err = planGroupedMergeRequests(ctx, cfg, repoConfig, branches, updates)
+	} else {
+		planned, err = planMergeRequests(ctx, cfg, repoConfig, branches, updates)
+	}
+	if err != nil {
+		return err
+	}
+
+	return reportPlannedMergeRequests(planned)
+}
+
+// checkTokenScopes verifies that the token is active and may create merge requests
+func checkTokenScopes(ctx context.Context, client *gitlab.Client) error {
+	token, err := client.GetTokenInfoWithContext(ctx)
+	if err != nil {
+		logger.Warn("Could not read the token scopes: %v", err)
+		return nil
+	}
+
+	switch {
+	case !token.Active:
+		return fmt.Errorf("token %s is revoked or expired", token.Name)
+	case !slices.Contains(token.Scopes, "api"):
+		return fmt.Errorf("token %s has scopes %s but merge requests need the api scope", token.Name, strings.Join(token.Scopes, ", "))
+	}
+
+	logger.Info("Token %s has scopes %s", token.Name, strings.Join(token.Scopes, ", "))
+	return nil
+}
+
+// planMergeRequests plans one merge request per update, as createMergeRequestsForUpdates does
+func planMergeRequests(ctx context.Context, cfg *config.Config, repoConfig *config.RepoConfig, branches *branchChecker, updates []result.UpdateCandidate) ([]plannedMergeRequest, error) {
+	openMergeRequests, openCount, err := listOpenMergeRequests(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	budget := newMergeRequestBudget(cfg, openCount)
+	defer budget.report()
+
+	planned := make([]plannedMergeRequest, 0, len(updates))
+	for _, update := range updates {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		title := formatMergeRequestTitle(update)
+		if mr, ok := openMergeRequests[updateMarker(cfg, update).ImageKey()]; ok {
+			existing, _ := gitlab.ParseUpdateMarker(mr.Description)
+			action := "refresh"
+			if existing.NewTag == update.NewTag {
+				action = "up to date"
+			}
+			planned = append(planned, plannedMergeRequest{Action: action, SourceBranch: mr.SourceBranch, TargetBranch: mr.TargetBranch,
+				Title: title, Note: fmt.Sprintf("merge request !%d proposes %s", mr.IID, existing.NewTag)})
+			continue
+		}
+
+		if !budget.take(fmt.Sprintf("%s: %s → %s", update.ServiceName, update.OldTag, update.NewTag)) {
+			planned = append(planned, plannedMergeRequest{Action: "deferred", Title: title, Note: "merge request limit reached"})
+			continue
+		}
+
+		mr := plannedMergeRequest{Action: "create", SourceBranch: updateBranchName(update), Title: title}
+
+		targetBranch, err := targetBranchFor(ctx, cfg, repoConfig, update)
+		if err != nil {
+			mr.Problem = fmt.Sprintf("could not get target branch: %v", err)
+			planned = append(planned, mr)
+			continue
+		}
+		mr.TargetBranch = targetBranch
+		if mr.Problem = branches.checkTarget(ctx, targetBranch); mr.Problem != "" {
+			planned = append(planned, mr)
+			continue
+		}
+
+		branchName, action, err := resolveUpdateBranch(cfg, mr.SourceBranch, func(name string) (bool, error) {
+			return branches.exists(ctx, name)
+		})
+		switch {
+		case err != nil:
+			mr.Problem = err.Error()
+		case action == branchReuse:
+			mr.Note = fmt.Sprintf("existing branch %s would be reused as it is", branchName)
+		case action == branchForce:
+			mr.Note = fmt.Sprintf("existing branch %s would be reset", branchName)
+		case branchName != mr.SourceBranch:
+			mr.Note = fmt.Sprintf("branch %s exists, using %s", mr.SourceBranch, branchName)
+		}
+		mr.SourceBranch = branchName
+		planned = append(planned, mr)
+	}
+
+	return planned, nil
+}
+
+// planGroupedMergeRequests plans one merge request per group, as createGroupedMergeRequests does
+func planGroupedMergeRequests(ctx context.Context, cfg *config.Config, repoConfig *config.RepoConfig, branches *branchChecker, updates []result.UpdateCandidate) ([]plannedMergeRequest, error) {
+	groups, err := groupUpdates(ctx, cfg, repoConfig, updates)
+	if err != nil {
+		return nil, err
+	}
+
+	openMergeRequests := make(map[string]gitlab.MergeRequestResponse)
+	mergeRequests, err := branches.client.ListMergeRequestsWithContext(ctx, "opened", gitlab.BranchPrefix)
+	if err != nil {
+		if err := openMergeRequestsError(cfg, err); err != nil {
+			return nil, err
+		}
+	}
+	for _, mr := range mergeRequests {
+		openMergeRequests[mr.SourceBranch] = mr
+	}
+	budget := newMergeRequestBudget(cfg, len(mergeRequests))
+	defer budget.report()
+
+	planned := make([]plannedMergeRequest, 0, len(groups))
+	for _, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		mr := plannedMergeRequest{Action: "create", SourceBranch: group.Branch, TargetBranch: group.TargetBranch, Title: formatGroupMergeRequestTitle(group)}
+		existing, exists := openMergeRequests[group.Branch]
+		switch {
+		case exists && sameMarkers(gitlab.ParseUpdateMarkers(existing.Description), groupMarkers(cfg, group.Updates)):
+			mr.Action = "up to date"
+			mr.Note = fmt.Sprintf("merge request !%d proposes the %d updates", existing.IID, len(group.Updates))
+		case exists:
+			mr.Action = "refresh"
+			mr.Note = fmt.Sprintf("merge request !%d", existing.IID)
+		case !budget.take(fmt.Sprintf("%s: %d updates", groupName(group), len(group.Updates))):
+			mr.Action = "deferred"
+			mr.Note = "merge request limit reached"
+		}
+		if mr.Action == "up to date" || mr.Action == "deferred" {
+			planned = append(planned, mr)
+			continue
+		}
+
+		mr.Problem = branches.checkTarget(ctx, group.TargetBranch)
+
+		// Group branches are stable and force pushed, a leftover branch is replaced
+		if mr.Problem == "" && !exists {
+			if found, err := branches.exists(ctx, group.Branch); err != nil {
+				mr.Problem = fmt.Sprintf("could not check source branch %s: %v", group.Branch, err)
+			} else if found {
+				mr.Note = fmt.Sprintf("existing branch %s without merge request would be overwritten", group.Branch)
+			}
+		}
+		planned = append(planned, mr)
+	}
+
+	return planned, nil
+}
+
+// reportPlannedMergeRequests logs the planned merge requests, returning an error when
+// any of them would fail
+func reportPlannedMergeRequests(planned []plannedMergeRequest) error {
+	failures := 0
+	for _, mr := range planned {
+		line := fmt.Sprintf("  [%s] %s", mr.Action, mr.Title)
+		if mr.SourceBranch != "" {
+			line += fmt.Sprintf(" (%s → %s)", mr.SourceBranch, mr.TargetBranch)
+		}
+		if mr.Note != "" {
+			line += ": " + mr.Note
+		}
+
+		if mr.Problem != "" {
+			failures++
+			logger.Error("%s would fail: %s", line, mr.Problem)
+			continue
+		}
+		logger.Info("%s", line)
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d merge requests would fail", failures, len(planned))
+	}
+	logger.Info("Dry run: %d merge requests validated", len(planned))
+	return nil
+}
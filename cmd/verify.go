@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitops"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+)
+
+// verifyUpdates keeps the image updates whose new tag has a valid cosign signature or
+// attestation, recording the verification in the update. Updates failing verification
+// are returned as errors. Chart and digest updates are not verified.
+func verifyUpdates(ctx context.Context, cfg *config.Config, updates []result.UpdateCandidate) ([]result.UpdateCandidate, []result.FileError) {
+	verifier := cfg.SignatureVerifier()
+	if verifier == nil {
+		return updates, nil
+	}
+
+	var verified []result.UpdateCandidate
+	var errors []result.FileError
+	for _, update := range updates {
+		if update.Kind == gitops.KindChart || strings.Contains(update.NewTag, "@") {
+			verified = append(verified, update)
+			continue
+		}
+
+		// Signatures are stored next to the image in its original registry
+		image := fmt.Sprintf("%s:%s", update.Repository, update.NewTag)
+		description, err := verifier.Verify(ctx, image)
+		if err != nil {
+			PrintInfo("  ✗ Not suggesting %s for %s: %v", update.NewTag, update.ServiceName, err)
+			errors = append(errors, result.FileError{FilePath: update.FilePath, ServiceName: update.ServiceName, Error: err.Error()})
+			continue
+		}
+
+		PrintVerbose("  %s: %s", image, description)
+		update.Verification = description
+		verified = append(verified, update)
+	}
+
+	return verified, errors
+}
+
+// checkPullable keeps the image updates whose new tag can still be pulled, requesting
+// its manifest from the registry. Tags deleted since they were listed are skipped.
+// Chart and digest updates are not checked.
+func checkPullable(cfg *config.Config, updates []result.UpdateCandidate) []result.UpdateCandidate {
+	if !cfg.VerifyPull {
+		return updates
+	}
+
+	resolver, err := newRegistryClient(cfg)
+	if err != nil {
+		logger.Warn("Not checking that suggested tags can be pulled: %v", err)
+		return updates
+	}
+
+	var pullable []result.UpdateCandidate
+	for _, update := range updates {
+		if update.Kind == gitops.KindChart || strings.Contains(update.NewTag, "@") {
+			pullable = append(pullable, update)
+			continue
+		}
+
+		if err := resolver.CheckPullable(update.Repository, update.NewTag); err != nil {
+			logger.Warn("Skipping %s: %s:%s cannot be pulled: %v", update.ServiceName, update.Repository, update.NewTag, err)
+			continue
+		}
+		pullable = append(pullable, update)
+	}
+
+	return pullable
+}
@@ -0,0 +1,371 @@
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/gitlab"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/result"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/state"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/update"
+)
+
+const (
+	// maxWebhookSize is the largest accepted webhook body, push events list their commits
+	maxWebhookSize = 1 << 20
+
+	// gitlabEventPush and gitlabEventTagPush are the object kinds of the handled GitLab events
+	gitlabEventPush    = "push"
+	gitlabEventTagPush = "tag_push"
+
+	// deletedRef is the commit SHA GitLab sends as "after" when a ref is deleted
+	deletedRef = "0000000000000000000000000000000000000000"
+)
+
+// gitlabPushEvent holds the fields of GitLab push and tag push events used to trigger scans
+type gitlabPushEvent struct {
+	ObjectKind string `json:"object_kind"`
+	Ref        string `json:"ref"`
+	After      string `json:"after"`
+	Project    struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitHTTPURL        string `json:"git_http_url"`
+		DefaultBranch     string `json:"default_branch"`
+	} `json:"project"`
+}
+
+// webhookScans runs the scans triggered by webhooks in the background, GitLab
+// giving up on webhooks that are not answered within seconds
+type webhookScans struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// pending holds the repositories with a scan waiting to start, further events
+	// for them are covered by that scan
+	pending sync.Map
+}
+
+// newWebhookScans creates the runner of webhook-triggered scans
+func newWebhookScans() *webhookScans {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &webhookScans{ctx: ctx, cancel: cancel}
+}
+
+// wait waits for the running scans until the context is done, then cancels them
+func (w *webhookScans) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		return ctx.Err()
+	}
+}
+
+// authenticateWebhook requires the configured secret in the X-Gitlab-Token header
+func (s *scanServer) authenticateWebhook(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Gitlab-Token")
+		if s.cfg.WebhookSecret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.WebhookSecret)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid webhook token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateRegistryWebhook requires the configured secret as the Authorization header,
+// as sent by Harbor. Docker Hub cannot set headers, its route also accepts the secret as
+// the token query parameter, which proxies and access logs may record: the header is
+// preferred whenever it is sent.
+func (s *scanServer) authenticateRegistryWebhook(next http.Handler, queryToken bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" && queryToken {
+			token = r.URL.Query().Get("token")
+		}
+		if s.cfg.WebhookSecret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.WebhookSecret)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid webhook token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleGitLabWebhook schedules the scans of the repositories affected by a push or tag push
+func (s *scanServer) handleGitLabWebhook(w http.ResponseWriter, r *http.Request) {
+	var event gitlabPushEvent
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookSize)).Decode(&event); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid event: %w", err))
+		return
+	}
+
+	repos, err := s.affectedRepositories(event)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	runs := make(map[string]string)
+	for _, repo := range repos {
+		if runID := s.scheduleWebhookScan(repo, nil); runID != "" {
+			runs[repo] = runID
+		}
+	}
+	writeScheduled(w, repos, runs)
+}
+
+// affectedRepositories returns the repositories to rescan for an event: the pushed
+// repository for pushes to its default branch, and the repositories consuming the
+// images of a project for its tag pushes
+func (s *scanServer) affectedRepositories(event gitlabPushEvent) ([]string, error) {
+	if event.After == deletedRef {
+		logger.Debug("Ignoring deletion of %s in %s", event.Ref, event.Project.PathWithNamespace)
+		return []string{}, nil
+	}
+
+	switch event.ObjectKind {
+	case gitlabEventPush:
+		branch, _ := strings.CutPrefix(event.Ref, "refs/heads/")
+		// Pushes of img-upgr itself and to other branches do not change what is deployed
+		if branch != event.Project.DefaultBranch || strings.HasPrefix(branch, gitlab.BranchPrefix) {
+			logger.Debug("Ignoring push to %s of %s", branch, event.Project.PathWithNamespace)
+			return []string{}, nil
+		}
+		if err := checkSameHost(s.cfg.GitLabRepo, event.Project.GitHTTPURL); err != nil {
+			return nil, err
+		}
+		return []string{event.Project.GitHTTPURL}, nil
+
+	case gitlabEventTagPush:
+		consumers, err := s.cfg.ConsumerRepositories()
+		if err != nil {
+			return nil, err
+		}
+		repos := consumers[strings.Trim(event.Project.PathWithNamespace, "/")]
+		if len(repos) == 0 {
+			logger.Debug("No consumers configured for the images of %s", event.Project.PathWithNamespace)
+			return []string{}, nil
+		}
+		for _, repo := range repos {
+			if err := checkSameHost(s.cfg.GitLabRepo, repo); err != nil {
+				return nil, err
+			}
+		}
+		return repos, nil
+
+	default:
+		logger.Debug("Ignoring %s event", event.ObjectKind)
+		return []string{}, nil
+	}
+}
+
+// scheduleWebhookScan scans a repository, or the target files and images of a repository,
+// in the background and creates the merge requests of the updates found, unless the
+// same scan is already waiting to start. The run ID of the scan is returned, empty if
+// it cannot be scheduled.
+func (s *scanServer) scheduleWebhookScan(repo string, target *scanTarget) string {
+	key := repoKey(repo)
+	if target != nil {
+		key += "\x00" + strings.Join(target.files, "\x00")
+	}
+	runID := runid.New()
+	if queuedID, queued := s.webhooks.pending.LoadOrStore(key, runID); queued {
+		logger.Debug("Scan of %s already scheduled in run %s", repo, queuedID)
+		return queuedID.(string)
+	}
+
+	cfg, err := s.requestConfig(scanRequest{Repo: repo, CreateMR: true})
+	if err != nil {
+		s.webhooks.pending.Delete(key)
+		logger.Error("Cannot scan %s: %v", repo, err)
+		return ""
+	}
+
+	s.webhooks.wg.Add(1)
+	go func() {
+		defer s.webhooks.wg.Done()
+
+		unlock := s.lockRepository(cfg.GitLabRepo)
+		defer unlock()
+		s.webhooks.pending.Delete(key)
+
+		logger.Info("Scanning %s after webhook in run %s", repo, runID)
+		res, err := runServerScan(runid.NewContext(s.webhooks.ctx, runID), cfg, target)
+		if res != nil {
+			logger.Info("Scan of %s in run %s found %d updates and %d errors", repo, runID, len(res.Updates), len(res.Errors))
+		}
+		if err != nil {
+			logger.Error("Scan of %s in run %s failed: %v", repo, runID, err)
+		}
+	}()
+	return runID
+}
+
+// scanTarget restricts a scan to the files using some images
+type scanTarget struct {
+	// files are the slash-separated paths of the files relative to the repository root
+	files []string
+	// images are the repositories whose updates are kept
+	images []string
+}
+
+// filterFiles keeps the target files among the files found by a scan
+func (t *scanTarget) filterFiles(cfg *config.Config, files []string) []string {
+	var targeted []string
+	for _, file := range files {
+		if slices.Contains(t.files, repoRelativePath(cfg, file)) {
+			targeted = append(targeted, file)
+		}
+	}
+	return targeted
+}
+
+// filterUpdates keeps the updates of the target images
+func (t *scanTarget) filterUpdates(updates []result.UpdateCandidate) []result.UpdateCandidate {
+	var targeted []result.UpdateCandidate
+	for _, candidate := range updates {
+		for _, image := range t.images {
+			if registry.SameRepository(candidate.Repository, image) {
+				targeted = append(targeted, candidate)
+				break
+			}
+		}
+	}
+	return targeted
+}
+
+// handleRegistryWebhook rechecks the services using the repositories of a registry push,
+// as recorded in the state store by previous runs
+func (s *scanServer) handleRegistryWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to read event: %w", err))
+		return
+	}
+
+	event, err := registry.ParsePushEvent(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(event.Repositories) == 0 {
+		writeScheduled(w, []string{}, nil)
+		return
+	}
+
+	st, err := s.loadState(r.Context())
+	if err != nil {
+		logger.Error("Cannot map %s to services: %v", strings.Join(event.Repositories, ", "), err)
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	logger.Info("Registry push of %s %s", strings.Join(event.Repositories, ", "), strings.Join(event.Tags, ", "))
+	targets := usageTargets(st, event.Repositories)
+
+	repos := make([]string, 0, len(targets))
+	runs := make(map[string]string)
+	for repo, target := range targets {
+		if err := checkSameHost(s.cfg.GitLabRepo, repo); err != nil {
+			logger.Warn("Not scanning %s: %v", repo, err)
+			continue
+		}
+		if runID := s.scheduleWebhookScan(repo, target); runID != "" {
+			runs[repo] = runID
+		}
+		repos = append(repos, repo)
+	}
+	if len(repos) == 0 {
+		logger.Info("No service is known to use %s", strings.Join(event.Repositories, ", "))
+	}
+
+	sort.Strings(repos)
+	writeScheduled(w, repos, runs)
+}
+
+// usageTargets returns the scans of the repositories using images of the given
+// repositories, one per repository restricted to the files of the services using them
+func usageTargets(st *state.State, images []string) map[string]*scanTarget {
+	usages := st.Usages(func(image string) bool {
+		for _, repo := range images {
+			if registry.SameRepository(update.ImageRepository(image), repo) {
+				return true
+			}
+		}
+		return false
+	})
+
+	targets := make(map[string]*scanTarget)
+	for _, usage := range usages {
+		target, ok := targets[usage.Repository]
+		if !ok {
+			target = &scanTarget{images: images}
+			targets[usage.Repository] = target
+		}
+		if !slices.Contains(target.files, usage.File) {
+			target.files = append(target.files, usage.File)
+		}
+	}
+	for _, target := range targets {
+		sort.Strings(target.files)
+	}
+	return targets
+}
+
+// loadState loads the state recorded by previous runs from the configured store
+func (s *scanServer) loadState(ctx context.Context) (*state.State, error) {
+	if s.cfg.StateStore == "" {
+		return nil, fmt.Errorf("%s must be set to find the services using pushed images", config.EnvStateStore)
+	}
+
+	cfg := s.cfg.Clone()
+	if cfg.GitLabClient == nil {
+		if err := cfg.UseProjectToken(); err != nil {
+			return nil, err
+		}
+		gitlabClient, err := gitlab.NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GitLabClient = gitlabClient
+	}
+
+	store, err := openStateStore(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store: %w", err)
+	}
+	st, err := store.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	return st, nil
+}
+
+// writeScheduled answers a webhook with the repositories scheduled for a scan and the
+// run IDs of their scans, found in their logs and merge requests
+func writeScheduled(w http.ResponseWriter, repos []string, runs map[string]string) {
+	if runs == nil {
+		runs = map[string]string{}
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"scheduled": repos, "runs": runs})
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/state"
+)
+
+func TestAuthenticateRegistryWebhook(t *testing.T) {
+	server := newTestServer(t, "https://gitlab.example.com/group/project")
+	server.cfg.WebhookSecret = "hook-secret"
+	handler := server.routes()
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		want          int
+	}{
+		{"header", "/webhook/registry", "hook-secret", http.StatusBadRequest},
+		{"bearer header", "/webhook/registry", "Bearer hook-secret", http.StatusBadRequest},
+		{"wrong header", "/webhook/registry", "other", http.StatusUnauthorized},
+		{"query token refused", "/webhook/registry?token=hook-secret", "", http.StatusUnauthorized},
+		{"docker hub query token", "/webhook/registry/dockerhub?token=hook-secret", "", http.StatusBadRequest},
+		{"docker hub header preferred", "/webhook/registry/dockerhub?token=hook-secret", "other", http.StatusUnauthorized},
+		{"docker hub wrong query token", "/webhook/registry/dockerhub?token=other", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Authenticated requests reach the handler, which rejects the empty body
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader("{}"))
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleRegistryWebhook(t *testing.T) {
+	repo := newTestRepository(t, map[string]string{
+		"deploy/docker-compose.yml": "services:\n  web:\n    image: nginx:1.25.0\n",
+	})
+	server := newTestServer(t, repo)
+	server.cfg.WebhookSecret = "hook-secret"
+
+	// Previous runs recorded the services using nginx and redis
+	server.cfg.StateStore = filepath.Join(t.TempDir(), "state.json")
+	st := state.New()
+	st.RecordUsage("nginx:1.25.0", state.Usage{Repository: repo, File: "deploy/docker-compose.yml", Service: "web"})
+	st.RecordUsage("docker.io/library/nginx:1.24", state.Usage{Repository: repo, File: "legacy/compose.yml", Service: "proxy"})
+	st.RecordUsage("redis:7.0.0", state.Usage{Repository: repo, File: "cache/compose.yml", Service: "cache"})
+	st.RecordUsage("nginx:1.25.0", state.Usage{Repository: "https://evil.example.com/group/project", File: "compose.yml", Service: "web"})
+	if err := state.NewFileStore(server.cfg.StateStore).Save(context.Background(), st); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(body string) (int, map[string]any) {
+		req := httptest.NewRequest(http.MethodPost, "/webhook/registry", strings.NewReader(body))
+		req.Header.Set("Authorization", "hook-secret")
+		rec := httptest.NewRecorder()
+		server.routes().ServeHTTP(rec, req)
+
+		var response map[string]any
+		if rec.Code == http.StatusAccepted {
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response %s: %v", rec.Body.String(), err)
+			}
+		}
+		return rec.Code, response
+	}
+
+	// A Harbor push of nginx schedules one scan of the repository using it
+	code, response := post(`{"type": "PUSH_ARTIFACT", "event_data": {"resources": [{"tag": "1.27.0", "resource_url": "docker.io/library/nginx:1.27.0"}]}}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", code, http.StatusAccepted)
+	}
+	if scheduled := response["scheduled"]; !reflect.DeepEqual(scheduled, []any{repo}) {
+		t.Errorf("scheduled = %v, want %s only", scheduled, repo)
+	}
+	if runs, _ := response["runs"].(map[string]any); runs[repo] == "" || runs[repo] == nil {
+		t.Errorf("runs = %v, want the run of %s", response["runs"], repo)
+	}
+
+	server.webhooks.cancel()
+	_ = server.webhooks.wait(context.Background())
+
+	// The scan targets the files using nginx
+	targets := usageTargets(st, []string{"docker.io/library/nginx"})
+	if target := targets[repo]; target == nil || !reflect.DeepEqual(target.files, []string{"deploy/docker-compose.yml", "legacy/compose.yml"}) {
+		t.Errorf("target of %s = %+v, want the files using nginx", repo, target)
+	}
+
+	// Pushes of images no service uses and other events schedule nothing
+	for _, body := range []string{
+		`{"push_data": {"tag": "2.0"}, "repository": {"repo_name": "team/unused"}}`,
+		`{"type": "DELETE_ARTIFACT", "event_data": {"resources": []}}`,
+	} {
+		code, response := post(body)
+		if code != http.StatusAccepted || len(response["scheduled"].([]any)) != 0 {
+			t.Errorf("%s: status = %d, scheduled = %v, want nothing", body, code, response["scheduled"])
+		}
+	}
+
+	if code, _ := post(`{"unknown": true}`); code != http.StatusBadRequest {
+		t.Errorf("unsupported payload status = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestAffectedRepositories(t *testing.T) {
+	const repo = "https://gitlab.example.com/group/app.git"
+	server := newTestServer(t, repo)
+	server.cfg.WebhookConsumers = "group/base-images=https://gitlab.example.com/group/app.git," +
+		"group/base-images=https://gitlab.example.com/group/api.git," +
+		"group/evil=https://evil.example.com/group/app.git"
+
+	// event decodes a sample GitLab payload
+	event := func(kind, ref, after, project, httpURL string) gitlabPushEvent {
+		payload := `{
+			"object_kind": "` + kind + `",
+			"ref": "` + ref + `",
+			"before": "95790bf891e76fee5e1747ab589903a6a1f80f22",
+			"after": "` + after + `",
+			"user_username": "jsmith",
+			"project": {
+				"path_with_namespace": "` + project + `",
+				"git_http_url": "` + httpURL + `",
+				"default_branch": "main"
+			},
+			"commits": [{"id": "` + after + `", "message": "Update compose"}]
+		}`
+		var e gitlabPushEvent
+		if err := json.Unmarshal([]byte(payload), &e); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	const sha = "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"
+
+	tests := []struct {
+		name    string
+		event   gitlabPushEvent
+		want    []string
+		wantErr bool
+	}{
+		{"push to the default branch", event("push", "refs/heads/main", sha, "group/app", repo), []string{repo}, false},
+		{"push to another branch", event("push", "refs/heads/feature", sha, "group/app", repo), []string{}, false},
+		{"push of img-upgr", event("push", "refs/heads/img-upgr/web-1.27", sha, "group/app", repo), []string{}, false},
+		{"deleted default branch", event("push", "refs/heads/main", deletedRef, "group/app", repo), []string{}, false},
+		{"push of another host", event("push", "refs/heads/main", sha, "group/app", "https://evil.example.com/group/app.git"), nil, true},
+		{"tag push", event("tag_push", "refs/tags/v1.2.0", sha, "group/base-images", "https://gitlab.example.com/group/base-images.git"),
+			[]string{repo, "https://gitlab.example.com/group/api.git"}, false},
+		{"tag push with slashes", event("tag_push", "refs/tags/v1.2.0", sha, "/group/base-images/", "https://gitlab.example.com/group/base-images.git"),
+			[]string{repo, "https://gitlab.example.com/group/api.git"}, false},
+		{"tag push without consumers", event("tag_push", "refs/tags/v1.2.0", sha, "group/other", "https://gitlab.example.com/group/other.git"), []string{}, false},
+		{"deleted tag", event("tag_push", "refs/tags/v1.2.0", deletedRef, "group/base-images", "https://gitlab.example.com/group/base-images.git"), []string{}, false},
+		{"consumer on another host", event("tag_push", "refs/tags/v1.2.0", sha, "group/evil", "https://gitlab.example.com/group/evil.git"), nil, true},
+		{"other event", event("merge_request", "", sha, "group/app", repo), []string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := server.affectedRepositories(tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("affectedRepositories() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("affectedRepositories() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Package audit records the changes img-upgr makes to GitLab projects and the API errors
+// it meets, as JSON lines appended to a file or posted to an endpoint for compliance
+// reviews. Every event carries the ID of the run that made it, see package runid.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/transport"
+)
+
+// Action is the kind of an audit event
+type Action string
+
+// Actions recorded in the audit log
+const (
+	// BranchCreated is a branch created through the API
+	BranchCreated Action = "branch_created"
+	// BranchPushed is a commit pushed to a branch, creating it if missing
+	BranchPushed Action = "branch_pushed"
+	// BranchDeleted is a branch deleted through the API
+	BranchDeleted Action = "branch_deleted"
+	// FileModified is a file changed by a commit
+	FileModified Action = "file_modified"
+	// MergeRequestOpened is a merge request created
+	MergeRequestOpened Action = "merge_request_opened"
+	// MergeRequestUpdated is a merge request whose title or description changed
+	MergeRequestUpdated Action = "merge_request_updated"
+	// APIError is a request the GitLab API failed
+	APIError Action = "api_error"
+)
+
+// postTimeout is the timeout of posting an event to the audit endpoint
+const postTimeout = 10 * time.Second
+
+// Event is an entry of the audit log
+type Event struct {
+	Time         time.Time `json:"time"`
+	RunID        string    `json:"run_id"`
+	Action       Action    `json:"action"`
+	Project      string    `json:"project,omitempty"`
+	Branch       string    `json:"branch,omitempty"`
+	File         string    `json:"file,omitempty"`
+	MergeRequest int       `json:"merge_request,omitempty"`
+	Method       string    `json:"method,omitempty"`
+	URL          string    `json:"url,omitempty"`
+	Status       int       `json:"status,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Log writes the events of a run to a file or an endpoint
+type Log struct {
+	runID string
+	// file is the log file, nil when posting to endpoint
+	file     *os.File
+	endpoint string
+	token    string
+	client   *http.Client
+
+	mu sync.Mutex
+}
+
+var (
+	// current is the audit log of the process, nil when none is configured
+	current *Log
+	// currentMu guards current
+	currentMu sync.RWMutex
+)
+
+// Open opens the audit log target, a file the events are appended to or an http(s) URL
+// they are posted to with the bearer token if set, and records the following events in it
+func Open(target, token string) (*Log, error) {
+	runID := runid.ID()
+	l := &Log{runID: runID}
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		l.endpoint = target
+		l.token = token
+		l.client = &http.Client{Timeout: postTimeout, Transport: transport.Shared()}
+	} else {
+		file, err := os.OpenFile(target, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		l.file = file
+	}
+
+	currentMu.Lock()
+	current = l
+	currentMu.Unlock()
+
+	logger.Info("Recording actions of run %s in audit log %s", runID, target)
+	return l, nil
+}
+
+// RunID returns the ID of the run
+func (l *Log) RunID() string {
+	return l.runID
+}
+
+// Close stops recording events and closes the log file
+func (l *Log) Close() error {
+	currentMu.Lock()
+	if current == l {
+		current = nil
+	}
+	currentMu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Record records an event in the audit log of the process, if any. Events without a run
+// ID get the ID of the process. Failing to record it is logged rather than returned so
+// that an unavailable log does not stop the run.
+func Record(event Event) {
+	currentMu.RLock()
+	l := current
+	currentMu.RUnlock()
+	if l == nil {
+		return
+	}
+
+	if err := l.write(event); err != nil {
+		logger.Warn("Failed to record %s in the audit log: %v", event.Action, err)
+	}
+}
+
+// write stamps an event and writes it as a JSON line
+func (l *Log) write(event Event) error {
+	event.Time = time.Now().UTC()
+	if event.RunID == "" {
+		event.RunID = l.runID
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		_, err := l.file.Write(append(data, '\n'))
+		return err
+	}
+	return l.post(data)
+}
+
+// post sends an event to the endpoint
+func (l *Log) post(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), postTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.token != "" {
+		req.Header.Set("Authorization", "Bearer "+l.token)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("audit endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte(`{"action":"branch_created"}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events are recorded only while the log is open
+	Record(Event{Action: BranchDeleted})
+	log, err := Open(path, "")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	Record(Event{Action: FileModified, Project: "group/project", Branch: "img-upgr/nginx-1.27", File: "compose.yml"})
+	Record(Event{Action: MergeRequestOpened, MergeRequest: 7})
+	Record(Event{Action: BranchPushed, RunID: "scan-1", Branch: "img-upgr/redis-7.4"})
+	if err := log.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	Record(Event{Action: BranchDeleted})
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q is not an event: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != 4 || events[0].Action != BranchCreated {
+		t.Fatalf("log holds %+v, want the existing event and three appended", events)
+	}
+	for _, event := range events[1:3] {
+		if event.RunID != log.RunID() || event.Time.IsZero() {
+			t.Errorf("event %+v not stamped with run %s and a time", event, log.RunID())
+		}
+	}
+	if events[1].File != "compose.yml" || events[2].MergeRequest != 7 {
+		t.Errorf("events = %+v, want the recorded file and merge request", events[1:])
+	}
+	// Events of another run, such as a scan of a server, keep its ID
+	if events[3].RunID != "scan-1" {
+		t.Errorf("event %+v not stamped with run scan-1", events[3])
+	}
+}
+
+func TestLogEndpoint(t *testing.T) {
+	var received []Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("invalid event: %v", err)
+		}
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	log, err := Open(server.URL, "secret")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer log.Close()
+
+	Record(Event{Action: APIError, Method: http.MethodPost, Status: http.StatusForbidden})
+	if len(received) != 1 || received[0].Action != APIError || received[0].RunID != log.RunID() {
+		t.Errorf("endpoint received %+v, want the API error of run %s", received, log.RunID())
+	}
+}
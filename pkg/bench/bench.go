@@ -0,0 +1,135 @@
+// Package bench measures the phases of a scan, their time and allocations, to guide the
+// tuning of the concurrency and caching of img-upgr. Registries are stubbed or answered
+// from a tag catalog so that the measures do not depend on the network.
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Phase is the measure of a phase of the scan over every iteration
+type Phase struct {
+	Name string `json:"name"`
+	// Runs is the number of times the phase ran
+	Runs     int           `json:"runs"`
+	Duration time.Duration `json:"duration_ns"`
+	// Allocs and AllocBytes are the heap allocations made during the phase
+	Allocs     uint64 `json:"allocs"`
+	AllocBytes uint64 `json:"alloc_bytes"`
+	// Items is the number of files or images processed, Unit naming them
+	Items int    `json:"items"`
+	Unit  string `json:"unit"`
+}
+
+// PerRun returns the mean duration of a run of the phase
+func (p *Phase) PerRun() time.Duration {
+	if p.Runs == 0 {
+		return 0
+	}
+	return p.Duration / time.Duration(p.Runs)
+}
+
+// Throughput returns the items processed per second
+func (p *Phase) Throughput() float64 {
+	if p.Duration <= 0 {
+		return 0
+	}
+	return float64(p.Items) / p.Duration.Seconds()
+}
+
+// AllocsPerItem returns the mean number of allocations per item processed
+func (p *Phase) AllocsPerItem() uint64 {
+	if p.Items == 0 {
+		return 0
+	}
+	return p.Allocs / uint64(p.Items)
+}
+
+// Report holds the measures of a benchmark
+type Report struct {
+	Directory   string `json:"directory"`
+	Registry    string `json:"registry"`
+	Concurrency int    `json:"concurrency"`
+	Iterations  int    `json:"iterations"`
+	Files       int    `json:"files"`
+	Bytes       int64  `json:"bytes"`
+	Images      int    `json:"images"`
+	// RegistryCalls is the number of registry requests of every iteration
+	RegistryCalls int64    `json:"registry_calls"`
+	Phases        []*Phase `json:"phases"`
+}
+
+// Measure runs a phase of the scan, which returns the number of items it processed, and
+// adds its time and allocations to the phase of that name
+func (r *Report) Measure(name, unit string, run func() (int, error)) error {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	items, err := run()
+	duration := time.Since(start)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	phase := r.Phase(name)
+	if phase == nil {
+		phase = &Phase{Name: name, Unit: unit}
+		r.Phases = append(r.Phases, phase)
+	}
+	phase.Runs++
+	phase.Duration += duration
+	phase.Allocs += after.Mallocs - before.Mallocs
+	phase.AllocBytes += after.TotalAlloc - before.TotalAlloc
+	phase.Items += items
+	return nil
+}
+
+// Phase returns the phase of a name, nil if it was not measured
+func (r *Report) Phase(name string) *Phase {
+	for _, phase := range r.Phases {
+		if phase.Name == name {
+			return phase
+		}
+	}
+	return nil
+}
+
+// WriteText writes the report as a table of the phases
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Directory:     %s\n", r.Directory)
+	fmt.Fprintf(&b, "Registry:      %s (%d calls)\n", r.Registry, r.RegistryCalls)
+	fmt.Fprintf(&b, "Files:         %d (%d bytes, %d images)\n", r.Files, r.Bytes, r.Images)
+	fmt.Fprintf(&b, "Concurrency:   %d\n", r.Concurrency)
+	fmt.Fprintf(&b, "Iterations:    %d\n\n", r.Iterations)
+
+	// The table is laid out in the builder, which never fails, so that only the write
+	// to w can
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tTIME/RUN\tTOTAL\tTHROUGHPUT\tALLOCS\tBYTES\tALLOCS/ITEM")
+	for _, phase := range r.Phases {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f %s/s\t%d\t%d\t%d\n", phase.Name,
+			phase.PerRun().Round(time.Microsecond), phase.Duration.Round(time.Microsecond),
+			phase.Throughput(), phase.Unit, phase.Allocs, phase.AllocBytes, phase.AllocsPerItem())
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,94 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+// sink keeps the allocations of the measured functions on the heap
+var sink []byte
+
+func TestMeasure(t *testing.T) {
+	report := &Report{Iterations: 2}
+	for range 2 {
+		err := report.Measure("parse", "files", func() (int, error) {
+			time.Sleep(time.Millisecond)
+			sink = make([]byte, 1<<16)
+			return 3, nil
+		})
+		if err != nil {
+			t.Fatalf("Measure() error = %v", err)
+		}
+	}
+	if err := report.Measure("check", "images", func() (int, error) { return 0, errors.New("cancelled") }); err == nil || err.Error() != "check: cancelled" {
+		t.Errorf("Measure() error = %v, want the error of the phase", err)
+	}
+
+	if len(report.Phases) != 1 {
+		t.Fatalf("phases = %+v, want only parse as check failed", report.Phases)
+	}
+	parse := report.Phase("parse")
+	if parse.Runs != 2 || parse.Items != 6 || parse.PerRun() < time.Millisecond {
+		t.Errorf("parse = %+v, want 2 runs of 3 files of at least 1ms", parse)
+	}
+	if parse.Allocs == 0 || parse.AllocBytes < 2<<16 {
+		t.Errorf("parse allocated %d times %d bytes, want the two buffers", parse.Allocs, parse.AllocBytes)
+	}
+	if throughput := parse.Throughput(); throughput <= 0 || throughput > 3000 {
+		t.Errorf("Throughput() = %f, want at most 3 files per millisecond", throughput)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "files/s") {
+		t.Errorf("WriteText() = %q, want the throughput of parse", text.String())
+	}
+	if err := report.WriteText(failingWriter{}); err == nil {
+		t.Error("WriteText() to a failing writer succeeded")
+	}
+
+	var encoded bytes.Buffer
+	if err := report.WriteJSON(&encoded); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(encoded.Bytes(), &decoded); err != nil || decoded.Phases[0].Items != 6 {
+		t.Errorf("WriteJSON() = %s, %v", encoded.String(), err)
+	}
+}
+
+func TestStub(t *testing.T) {
+	counter := NewCounter(NewStub(120, 0))
+
+	tags, err := counter.FetchAllTags("library/nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 121 || tags[0] != "1.0.0" || tags[119] != "2.1.9" || tags[120] != "latest" {
+		t.Errorf("FetchAllTags() = %v, want 120 versions and latest", tags)
+	}
+
+	first, _ := counter.FetchTagDigest("library/nginx", "1.0.0")
+	second, _ := counter.FetchTagDigest("library/nginx", "1.0.0")
+	other, _ := counter.FetchTagDigest("library/redis", "1.0.0")
+	if !strings.HasPrefix(first, "sha256:") || first != second || first == other {
+		t.Errorf("digests = %s, %s, %s, want stable digests per repository", first, second, other)
+	}
+
+	if counter.Calls() != 4 {
+		t.Errorf("Calls() = %d, want 4", counter.Calls())
+	}
+}
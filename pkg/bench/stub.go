@@ -0,0 +1,70 @@
+package bench
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+)
+
+// Stub is a registry answering every repository with the same versioned tags after a
+// simulated latency, so scans can be measured without the network
+type Stub struct {
+	tags    []string
+	latency time.Duration
+}
+
+// NewStub creates a stub registry publishing count semantic version tags, from 1.0.0 up,
+// and latest, each request taking latency
+func NewStub(count int, latency time.Duration) *Stub {
+	tags := make([]string, 0, count+1)
+	for i := range count {
+		tags = append(tags, fmt.Sprintf("%d.%d.%d", i/100+1, i/10%10, i%10))
+	}
+	tags = append(tags, "latest")
+	return &Stub{tags: tags, latency: latency}
+}
+
+// FetchAllTags returns the tags of the stub
+func (s *Stub) FetchAllTags(repo string) ([]string, error) {
+	time.Sleep(s.latency)
+	return append([]string(nil), s.tags...), nil
+}
+
+// FetchTagDigest returns a digest derived from the repository and tag
+func (s *Stub) FetchTagDigest(repo, tag string) (string, error) {
+	time.Sleep(s.latency)
+	sum := sha256.Sum256([]byte(repo + ":" + tag))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// Counter is a registry client counting the requests sent to another one
+type Counter struct {
+	client registry.Client
+	calls  atomic.Int64
+}
+
+// NewCounter creates a client counting the requests sent to client
+func NewCounter(client registry.Client) *Counter {
+	return &Counter{client: client}
+}
+
+// Calls returns the number of requests sent
+func (c *Counter) Calls() int64 {
+	return c.calls.Load()
+}
+
+// FetchAllTags counts and sends a request for the tags of a repository
+func (c *Counter) FetchAllTags(repo string) ([]string, error) {
+	c.calls.Add(1)
+	return c.client.FetchAllTags(repo)
+}
+
+// FetchTagDigest counts and sends a request for the digest of a tag
+func (c *Counter) FetchTagDigest(repo, tag string) (string, error) {
+	c.calls.Add(1)
+	return c.client.FetchTagDigest(repo, tag)
+}
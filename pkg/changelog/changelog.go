@@ -0,0 +1,302 @@
+// Package changelog fetches the release notes published between two versions of
+// an image from the GitHub or GitLab releases of its source repository.
+package changelog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+)
+
+const (
+	// DefaultTimeout is the default timeout for HTTP requests
+	DefaultTimeout = 15 * time.Second
+
+	// DefaultMaxLength is the default length of a formatted changelog
+	DefaultMaxLength = 4000
+
+	// GitHubAPIBaseURL is the base URL of the GitHub API
+	GitHubAPIBaseURL = "https://api.github.com"
+
+	// releasesPerPage is the number of releases requested, older releases are rarely needed
+	releasesPerPage = 100
+)
+
+var (
+	// versionPattern extracts the version of an image tag such as 1.25.3-alpine or apache-2.4
+	versionPattern = regexp.MustCompile(`\d+\.\d+(?:\.\d+)?`)
+
+	// releasePattern matches the tags of final releases such as v1.2.3 or release-1.2
+	releasePattern = regexp.MustCompile(`^[^\d]*(\d+\.\d+(?:\.\d+)?)$`)
+)
+
+// KnownSources maps well-known images to their source repository
+var KnownSources = map[string]string{
+	"traefik":                            "https://github.com/traefik/traefik",
+	"caddy":                              "https://github.com/caddyserver/caddy",
+	"redis":                              "https://github.com/redis/redis",
+	"nginx":                              "https://github.com/nginx/nginx",
+	"haproxy":                            "https://github.com/haproxy/haproxy",
+	"node":                               "https://github.com/nodejs/node",
+	"golang":                             "https://github.com/golang/go",
+	"registry":                           "https://github.com/distribution/distribution",
+	"grafana/grafana":                    "https://github.com/grafana/grafana",
+	"grafana/loki":                       "https://github.com/grafana/loki",
+	"prom/prometheus":                    "https://github.com/prometheus/prometheus",
+	"prom/alertmanager":                  "https://github.com/prometheus/alertmanager",
+	"prom/node-exporter":                 "https://github.com/prometheus/node_exporter",
+	"gitea/gitea":                        "https://github.com/go-gitea/gitea",
+	"minio/minio":                        "https://github.com/minio/minio",
+	"vaultwarden/server":                 "https://github.com/dani-garcia/vaultwarden",
+	"portainer/portainer-ce":             "https://github.com/portainer/portainer",
+	"louislam/uptime-kuma":               "https://github.com/louislam/uptime-kuma",
+	"hashicorp/vault":                    "https://github.com/hashicorp/vault",
+	"hashicorp/consul":                   "https://github.com/hashicorp/consul",
+	"gitlab/gitlab-runner":               "https://gitlab.com/gitlab-org/gitlab-runner",
+	"mattermost/mattermost-team-edition": "https://github.com/mattermost/mattermost",
+}
+
+// Release is a published release of a source repository
+type Release struct {
+	Tag     string
+	Name    string
+	Body    string
+	URL     string
+	Version *semver.Version
+}
+
+// Source is a repository publishing releases on GitHub or GitLab
+type Source struct {
+	// Host is the host of the repository, github.com or a GitLab instance
+	Host string
+	// Project is the path of the repository on its host
+	Project string
+}
+
+// ParseSource parses the URL of a GitHub or GitLab repository
+func ParseSource(sourceURL string) (*Source, error) {
+	parsed, err := url.Parse(sourceURL)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid source repository URL: %s", sourceURL)
+	}
+
+	project := strings.TrimSuffix(strings.Trim(parsed.Path, "/"), ".git")
+	if strings.Count(project, "/") < 1 {
+		return nil, fmt.Errorf("source repository URL has no project path: %s", sourceURL)
+	}
+
+	return &Source{Host: strings.ToLower(parsed.Host), Project: project}, nil
+}
+
+// ResolveSource returns the source repository URL of an image repository, looked up in
+// the configured sources and then in KnownSources. Docker Hub images match with or
+// without their docker.io/ and library/ prefixes.
+func ResolveSource(repository string, sources map[string]string) (string, bool) {
+	if source, ok := sources[repository]; ok {
+		return source, true
+	}
+
+	name := reference.Familiarize(repository)
+
+	if source, ok := sources[name]; ok {
+		return source, true
+	}
+	source, ok := KnownSources[name]
+	return source, ok
+}
+
+// IsGitHub reports whether the source is hosted on GitHub
+func (s *Source) IsGitHub() bool {
+	return s.Host == "github.com"
+}
+
+// Client fetches releases from GitHub and GitLab
+type Client struct {
+	httpClient    *http.Client
+	githubBaseURL string
+	githubToken   string
+}
+
+// NewClient creates a client, using the token for GitHub requests if set
+func NewClient(githubToken string) *Client {
+	return &Client{
+		httpClient:    &http.Client{Timeout: DefaultTimeout},
+		githubBaseURL: GitHubAPIBaseURL,
+		githubToken:   githubToken,
+	}
+}
+
+// releaseResponse holds the fields of GitHub and GitLab releases
+type releaseResponse struct {
+	TagName     string `json:"tag_name"`
+	Name        string `json:"name"`
+	Body        string `json:"body"`
+	Description string `json:"description"`
+	HTMLURL     string `json:"html_url"`
+	Draft       bool   `json:"draft"`
+	Links       struct {
+		Self string `json:"self"`
+	} `json:"_links"`
+}
+
+// Between returns the final releases of a source newer than the version of oldTag up
+// to the version of newTag, newest first. Image tag prefixes and suffixes such as
+// -alpine are ignored, as are pre-releases.
+func (c *Client) Between(ctx context.Context, source *Source, oldTag, newTag string) ([]Release, error) {
+	oldVer, err := parseVersion(oldTag)
+	if err != nil {
+		return nil, err
+	}
+	newVer, err := parseVersion(newTag)
+	if err != nil {
+		return nil, err
+	}
+
+	releases, err := c.fetchReleases(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	var between []Release
+	for _, release := range releases {
+		if release.Version.GreaterThan(oldVer) && !release.Version.GreaterThan(newVer) {
+			between = append(between, release)
+		}
+	}
+
+	sort.Slice(between, func(i, j int) bool {
+		return between[i].Version.GreaterThan(between[j].Version)
+	})
+	return between, nil
+}
+
+// fetchReleases fetches the latest releases of a source
+func (c *Client) fetchReleases(ctx context.Context, source *Source) ([]Release, error) {
+	var apiURL string
+	if source.IsGitHub() {
+		apiURL = fmt.Sprintf("%s/repos/%s/releases?per_page=%d", c.githubBaseURL, source.Project, releasesPerPage)
+	} else {
+		apiURL = fmt.Sprintf("https://%s/api/v4/projects/%s/releases?per_page=%d",
+			source.Host, url.PathEscape(source.Project), releasesPerPage)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	if source.IsGitHub() {
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if c.githubToken != "" {
+			req.Header.Set("Authorization", "Bearer "+c.githubToken)
+		}
+	}
+
+	logger.Debug("Fetching releases of %s/%s", source.Host, source.Project)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching releases: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code fetching releases of %s: %d", source.Project, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+
+	var parsed []releaseResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("JSON parse error: %w", err)
+	}
+
+	releases := make([]Release, 0, len(parsed))
+	for _, r := range parsed {
+		if r.Draft {
+			continue
+		}
+		match := releasePattern.FindStringSubmatch(r.TagName)
+		if match == nil {
+			continue
+		}
+		version, err := semver.NewVersion(match[1])
+		if err != nil {
+			continue
+		}
+
+		release := Release{Tag: r.TagName, Name: r.Name, Body: r.Body, URL: r.HTMLURL, Version: version}
+		if !source.IsGitHub() {
+			release.Body = r.Description
+			release.URL = r.Links.Self
+		}
+		releases = append(releases, release)
+	}
+
+	return releases, nil
+}
+
+// parseVersion parses the version of an image tag
+func parseVersion(tag string) (*semver.Version, error) {
+	match := versionPattern.FindString(tag)
+	if match == "" {
+		return nil, fmt.Errorf("no version in %s", tag)
+	}
+	version, err := semver.NewVersion(match)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version in %s: %w", tag, err)
+	}
+	return version, nil
+}
+
+// Format formats releases as Markdown, truncated to about maxLength characters
+func Format(releases []Release, maxLength int) string {
+	var sb strings.Builder
+	for i, release := range releases {
+		title := release.Tag
+		if release.Name != "" && release.Name != release.Tag {
+			title += " - " + release.Name
+		}
+
+		section := fmt.Sprintf("#### [%s](%s)\n\n%s\n\n", title, release.URL, strings.TrimSpace(release.Body))
+		if sb.Len()+len(section) > maxLength {
+			remaining := maxLength - sb.Len()
+			if remaining > 200 {
+				sb.WriteString(truncate(section, remaining))
+				sb.WriteString("\n\n")
+			}
+			fmt.Fprintf(&sb, "*Truncated %d of %d releases, see the release pages for the full notes.*\n", len(releases)-i, len(releases))
+			break
+		}
+		sb.WriteString(section)
+	}
+	return sb.String()
+}
+
+// truncate cuts text to at most length bytes at a line boundary if possible
+func truncate(text string, length int) string {
+	if len(text) <= length {
+		return text
+	}
+	cut := text[:length]
+	if idx := strings.LastIndex(cut, "\n"); idx > length/2 {
+		cut = cut[:idx]
+	}
+	return strings.ToValidUTF8(cut, "") + "\n\n…"
+}
@@ -0,0 +1,118 @@
+package changelog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	testCases := []struct {
+		url     string
+		host    string
+		project string
+		wantErr bool
+	}{
+		{url: "https://github.com/traefik/traefik", host: "github.com", project: "traefik/traefik"},
+		{url: "https://gitlab.example.com/group/sub/app.git", host: "gitlab.example.com", project: "group/sub/app"},
+		{url: "https://github.com/traefik", wantErr: true},
+		{url: "traefik/traefik", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		source, err := ParseSource(tc.url)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseSource(%q) expected an error", tc.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ParseSource(%q) error = %v", tc.url, err)
+		}
+		if source.Host != tc.host || source.Project != tc.project {
+			t.Errorf("ParseSource(%q) = %s %s, want %s %s", tc.url, source.Host, source.Project, tc.host, tc.project)
+		}
+	}
+}
+
+func TestResolveSource(t *testing.T) {
+	sources := map[string]string{"registry.example.com/app": "https://gitlab.example.com/group/app"}
+
+	testCases := []struct {
+		repository string
+		want       string
+		found      bool
+	}{
+		{"registry.example.com/app", "https://gitlab.example.com/group/app", true},
+		{"docker.io/library/traefik", "https://github.com/traefik/traefik", true},
+		{"grafana/grafana", "https://github.com/grafana/grafana", true},
+		{"registry.example.com/other", "", false},
+	}
+
+	for _, tc := range testCases {
+		got, found := ResolveSource(tc.repository, sources)
+		if got != tc.want || found != tc.found {
+			t.Errorf("ResolveSource(%q) = %q %v, want %q %v", tc.repository, got, found, tc.want, tc.found)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/traefik/traefik/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		_, _ = w.Write([]byte(`[
+			{"tag_name": "v3.1.0-rc1", "body": "candidate"},
+			{"tag_name": "v3.0.2", "body": "fix two", "html_url": "https://github.com/traefik/traefik/releases/v3.0.2"},
+			{"tag_name": "v3.0.1", "body": "fix one"},
+			{"tag_name": "v3.0.0", "body": "major"},
+			{"tag_name": "v3.0.3", "body": "draft", "draft": true}
+		]`))
+	}))
+	defer server.Close()
+
+	client := NewClient("secret")
+	client.githubBaseURL = server.URL
+
+	source := &Source{Host: "github.com", Project: "traefik/traefik"}
+	releases, err := client.Between(context.Background(), source, "v3.0.0", "3.0.2-alpine")
+	if err != nil {
+		t.Fatalf("Between() error = %v", err)
+	}
+
+	var tags []string
+	for _, release := range releases {
+		tags = append(tags, release.Tag)
+	}
+	if got := strings.Join(tags, ","); got != "v3.0.2,v3.0.1" {
+		t.Errorf("Between() = %s, want v3.0.2,v3.0.1", got)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	releases := []Release{
+		{Tag: "v1.2.0", Body: strings.Repeat("a line of notes\n", 50)},
+		{Tag: "v1.1.0", Body: "short"},
+	}
+
+	formatted := Format(releases, 2000)
+	if !strings.Contains(formatted, "#### [v1.2.0]") || !strings.Contains(formatted, "#### [v1.1.0]") {
+		t.Errorf("Format() is missing releases:\n%s", formatted)
+	}
+
+	truncated := Format(releases, 400)
+	if len(truncated) > 600 {
+		t.Errorf("Format() returned %d characters, want about 400", len(truncated))
+	}
+	if !strings.Contains(truncated, "Truncated 2 of 2 releases") {
+		t.Errorf("Format() does not mention the truncated releases:\n%s", truncated)
+	}
+}
@@ -0,0 +1,78 @@
+package compose
+
+import (
+	"fmt"
+	"os"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/yamledit"
+	"gopkg.in/yaml.v3"
+)
+
+// UpdateServiceImage replaces the image of a service in a compose file. Only the
+// image scalar is rewritten in place so comments, formatting, anchors and aliases
+// are preserved. When the image comes from an anchor (an aliased value or a merged
+// mapping such as `<<: *defaults`), the anchor definition is updated.
+func UpdateServiceImage(filename, serviceName, oldImage, newImage string) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	updated, err := ReplaceServiceImage(content, serviceName, oldImage, newImage)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filename, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return nil
+}
+
+// ReplaceServiceImage returns content with the image of a service replaced
+func ReplaceServiceImage(content []byte, serviceName, oldImage, newImage string) ([]byte, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	if len(document.Content) == 0 {
+		return nil, fmt.Errorf("empty compose file")
+	}
+
+	services := yamledit.MappingValue(document.Content[0], "services")
+	if services == nil {
+		return nil, fmt.Errorf("no services found")
+	}
+	service := yamledit.MappingValue(services, serviceName)
+	if service == nil {
+		return nil, fmt.Errorf("service %s not found", serviceName)
+	}
+	image := yamledit.MappingValue(service, "image")
+	if image == nil || image.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("service %s has no image", serviceName)
+	}
+	if image.Value != oldImage {
+		return nil, fmt.Errorf("service %s uses image %s, expected %s", serviceName, image.Value, oldImage)
+	}
+
+	return yamledit.ReplaceScalar(content, image, oldImage, newImage)
+}
+
+// VerifyServiceImage checks that content, as rewritten by ReplaceServiceImage, is still a
+// valid compose file whose service uses the new image
+func VerifyServiceImage(content []byte, serviceName, newImage string) error {
+	compose, err := ParseCompose(content)
+	if err != nil {
+		return fmt.Errorf("updated file is invalid: %w", err)
+	}
+
+	service, ok := compose.Services[serviceName]
+	if !ok {
+		return fmt.Errorf("service %s not found in the updated file", serviceName)
+	}
+	if service.Image != newImage {
+		return fmt.Errorf("service %s uses image %s after the update, expected %s", serviceName, service.Image, newImage)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const stackFile = `version: "3.8"
+
+x-defaults: &defaults
+  image: registry.example.com/app:1.0.0 # pinned
+  deploy: &deploy
+    mode: replicated
+    replicas: 2
+    update_config:
+      parallelism: 1
+
+x-proxy-image: &proxy-image "traefik:2.10"
+
+services:
+  api:
+    <<: *defaults
+    command: api
+  worker:
+    <<: *defaults
+    image: registry.example.com/worker:1.0.0
+    deploy:
+      <<: *deploy
+      replicas: 4
+  proxy:
+    image: *proxy-image
+    deploy:
+      placement:
+        constraints: [node.role == manager]
+  db:
+    image: 'postgres:15'
+`
+
+func TestParseStackFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "docker-stack.yml")
+	if err := os.WriteFile(path, []byte(stackFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	composeFile, err := ParseComposeFile(path)
+	if err != nil {
+		t.Fatalf("ParseComposeFile() error = %v", err)
+	}
+
+	want := map[string]string{
+		"api":    "registry.example.com/app:1.0.0",
+		"worker": "registry.example.com/worker:1.0.0",
+		"proxy":  "traefik:2.10",
+		"db":     "postgres:15",
+	}
+	images := composeFile.GetImages()
+	if len(images) != len(want) {
+		t.Fatalf("GetImages() = %v, want %v", images, want)
+	}
+	for service, image := range want {
+		if images[service] != image {
+			t.Errorf("GetImages()[%s] = %s, want %s", service, images[service], image)
+		}
+	}
+}
+
+func TestReplaceServiceImage(t *testing.T) {
+	tests := []struct {
+		service  string
+		oldImage string
+		newImage string
+		old      string
+		new      string
+	}{
+		{"api", "registry.example.com/app:1.0.0", "registry.example.com/app:1.1.0",
+			"  image: registry.example.com/app:1.0.0 # pinned\n", "  image: registry.example.com/app:1.1.0 # pinned\n"},
+		{"worker", "registry.example.com/worker:1.0.0", "registry.example.com/worker:2.0.0",
+			"    image: registry.example.com/worker:1.0.0\n", "    image: registry.example.com/worker:2.0.0\n"},
+		{"proxy", "traefik:2.10", "traefik:2.11",
+			`x-proxy-image: &proxy-image "traefik:2.10"`, `x-proxy-image: &proxy-image "traefik:2.11"`},
+		{"db", "postgres:15", "postgres:16", "image: 'postgres:15'", "image: 'postgres:16'"},
+	}
+
+	for _, tt := range tests {
+		updated, err := ReplaceServiceImage([]byte(stackFile), tt.service, tt.oldImage, tt.newImage)
+		if err != nil {
+			t.Fatalf("ReplaceServiceImage(%s) error = %v", tt.service, err)
+		}
+
+		want := replaceOnce(t, stackFile, tt.old, tt.new)
+		if string(updated) != want {
+			t.Errorf("ReplaceServiceImage(%s) =\n%s\nwant\n%s", tt.service, updated, want)
+		}
+	}
+
+	if _, err := ReplaceServiceImage([]byte(stackFile), "api", "registry.example.com/app:0.9.0", "x"); err == nil {
+		t.Error("ReplaceServiceImage() with a stale image should fail")
+	}
+	if _, err := ReplaceServiceImage([]byte(stackFile), "missing", "x", "y"); err == nil {
+		t.Error("ReplaceServiceImage() with an unknown service should fail")
+	}
+}
+
+func TestVerifyServiceImage(t *testing.T) {
+	// Images set through a merged mapping or an alias are resolved
+	for service, image := range map[string]string{"api": "registry.example.com/app:1.0.0", "proxy": "traefik:2.10"} {
+		if err := VerifyServiceImage([]byte(stackFile), service, image); err != nil {
+			t.Errorf("VerifyServiceImage(%s) error = %v", service, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"invalid YAML", stackFile + "\n  : [\n"},
+		{"other image", replaceOnce(t, stackFile, "registry.example.com/app:1.0.0", "registry.example.com/app:1.0.1")},
+	}
+	for _, tt := range tests {
+		if err := VerifyServiceImage([]byte(tt.content), "api", "registry.example.com/app:1.0.0"); err == nil {
+			t.Errorf("VerifyServiceImage() with %s should fail", tt.name)
+		}
+	}
+	if err := VerifyServiceImage([]byte(stackFile), "missing", "x"); err == nil {
+		t.Error("VerifyServiceImage() with an unknown service should fail")
+	}
+}
+
+// replaceOnce replaces a substring that must occur exactly once
+func replaceOnce(t *testing.T, s, old, new string) string {
+	t.Helper()
+
+	if count := strings.Count(s, old); count != 1 {
+		t.Fatalf("%q occurs %d times, want 1", old, count)
+	}
+	return strings.Replace(s, old, new, 1)
+}
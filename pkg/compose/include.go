@@ -0,0 +1,116 @@
+package compose
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// remoteInclude matches include paths pointing outside of the repository: URLs such as
+// oci:// or https:// references and scp-like git remotes such as git@host:group/repo
+var remoteInclude = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*://|[\w.-]+@[\w.-]+:)`)
+
+// ExpandIncludes returns the given compose files followed by the files they include,
+// recursively. Include paths are resolved relative to the including file and each
+// file is returned once, which also stops include cycles. Remote includes, included
+// files outside of root and those reported by excluded are not followed.
+func ExpandIncludes(files []string, root string, excluded func(path string) bool) ([]string, error) {
+	seen := make(map[string]bool)
+	var expanded []string
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", root, err)
+	}
+
+	for _, file := range files {
+		if err := expandIncludes(file, root, excluded, seen, &expanded); err != nil {
+			return nil, err
+		}
+	}
+
+	return expanded, nil
+}
+
+// expandIncludes adds a file and its includes unless it was already seen
+func expandIncludes(file, root string, excluded func(path string) bool, seen map[string]bool, expanded *[]string) error {
+	absPath, err := filepath.Abs(file)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", file, err)
+	}
+
+	if seen[absPath] {
+		return nil
+	}
+	seen[absPath] = true
+	*expanded = append(*expanded, file)
+
+	composeFile, err := ParseComposeFile(file)
+	if err != nil {
+		// Missing or invalid files are reported when they are processed
+		return nil
+	}
+
+	for _, include := range composeFile.Include {
+		for _, includePath := range include.Paths {
+			if remoteInclude.MatchString(includePath) {
+				logger.Debug("Not following remote include of %s: %s", file, includePath)
+				continue
+			}
+
+			// Compose files use slash separators on every platform
+			includePath = filepath.FromSlash(includePath)
+			if !filepath.IsAbs(includePath) {
+				includePath = filepath.Join(filepath.Dir(file), includePath)
+			}
+
+			absInclude, err := filepath.Abs(includePath)
+			if err != nil {
+				return fmt.Errorf("failed to resolve %s: %w", includePath, err)
+			}
+			if !isInside(root, absInclude) {
+				logger.Warn("Not following include of %s outside of %s: %s", file, root, includePath)
+				continue
+			}
+			if excluded != nil && excluded(absInclude) {
+				logger.Debug("Not following excluded include of %s: %s", file, includePath)
+				continue
+			}
+			logger.Debug("Following include of %s: %s", file, includePath)
+
+			if err := expandIncludes(includePath, root, excluded, seen, expanded); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isInside reports whether an absolute path is under root, symbolic links of files on the
+// disk being resolved so they cannot lead out of it
+func isInside(root, path string) bool {
+	if !isUnder(root, path) {
+		return false
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return true
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		// Missing files and files held in memory are checked by their path only
+		return true
+	}
+	return isUnder(resolvedRoot, resolved)
+}
+
+// isUnder reports whether path is root or lexically below it
+func isUnder(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
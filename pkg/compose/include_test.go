@@ -0,0 +1,98 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes files given by slash-separated path under root
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExpandIncludes(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"compose.yml":    "include:\n  - db/compose.yml\n  - path:\n      - cache.yml\n      - compose.yml\nservices:\n  web:\n    image: nginx:1.25\n",
+		"db/compose.yml": "include:\n  - path: ../compose.yml\nservices:\n  db:\n    image: postgres:15\n",
+		"cache.yml":      "services:\n  cache:\n    image: redis:7\n",
+	})
+
+	expanded, err := ExpandIncludes([]string{filepath.Join(root, "compose.yml"), filepath.Join(root, "cache.yml")}, root, nil)
+	if err != nil {
+		t.Fatalf("ExpandIncludes() error = %v", err)
+	}
+
+	want := []string{"compose.yml", "db/compose.yml", "cache.yml"}
+	if len(expanded) != len(want) {
+		t.Fatalf("ExpandIncludes() = %v, want %v", expanded, want)
+	}
+	for i, name := range want {
+		got, _ := filepath.Rel(root, expanded[i])
+		if filepath.ToSlash(got) != name {
+			t.Errorf("ExpandIncludes()[%d] = %s, want %s", i, got, name)
+		}
+	}
+}
+
+func TestExpandIncludesNotFollowed(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "repo")
+	writeFiles(t, dir, map[string]string{
+		"repo/compose.yml": "include:\n" +
+			"  - oci://registry.example.com/fragments/db:1.0\n" +
+			"  - https://gitlab.example.com/group/fragments.git#main:compose.yml\n" +
+			"  - git@gitlab.example.com:group/fragments.git\n" +
+			"  - ../outside.yml\n" +
+			"  - " + filepath.ToSlash(filepath.Join(dir, "outside.yml")) + "\n" +
+			"  - test/compose.yml\n" +
+			"  - cache.yml\n" +
+			"services:\n  web:\n    image: nginx:1.25\n",
+		"repo/test/compose.yml": "services:\n  test:\n    image: busybox:1.36\n",
+		"repo/cache.yml":        "services:\n  cache:\n    image: redis:7\n",
+		"outside.yml":           "services:\n  secret:\n    image: alpine:3.19\n",
+	})
+
+	excluded := func(path string) bool {
+		return filepath.Base(filepath.Dir(path)) == "test"
+	}
+	expanded, err := ExpandIncludes([]string{filepath.Join(root, "compose.yml")}, root, excluded)
+	if err != nil {
+		t.Fatalf("ExpandIncludes() error = %v", err)
+	}
+
+	// Remote includes, files outside of the root and excluded files are not followed
+	if len(expanded) != 2 || filepath.Base(expanded[1]) != "cache.yml" {
+		t.Errorf("ExpandIncludes() = %v, want compose.yml and cache.yml", expanded)
+	}
+}
+
+func TestExpandIncludesSymlinkOutsideRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "repo")
+	writeFiles(t, dir, map[string]string{
+		"repo/compose.yml": "include:\n  - link.yml\nservices:\n  web:\n    image: nginx:1.25\n",
+		"outside.yml":      "services:\n  secret:\n    image: alpine:3.19\n",
+	})
+	if err := os.Symlink(filepath.Join(dir, "outside.yml"), filepath.Join(root, "link.yml")); err != nil {
+		t.Skipf("symbolic links not supported: %v", err)
+	}
+
+	expanded, err := ExpandIncludes([]string{filepath.Join(root, "compose.yml")}, root, nil)
+	if err != nil {
+		t.Fatalf("ExpandIncludes() error = %v", err)
+	}
+	if len(expanded) != 1 {
+		t.Errorf("ExpandIncludes() = %v, want the symbolic link out of the root not followed", expanded)
+	}
+}
@@ -2,28 +2,115 @@ package compose
 
 import (
 	"fmt"
-	"os"
+	"strings"
 
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
 	"gopkg.in/yaml.v3"
 )
 
 // ComposeFile represents a docker-compose.yml file
 type ComposeFile struct {
+	Include  []Include          `yaml:"include"`
 	Services map[string]Service `yaml:"services"`
 }
 
+// Include represents an entry of the top-level include list. It is either a
+// path or a mapping whose path holds one or more compose files.
+type Include struct {
+	Paths []string
+}
+
+// UnmarshalYAML decodes the short and long include syntax
+func (i *Include) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		i.Paths = []string{value.Value}
+		return nil
+	}
+
+	var long struct {
+		Path yaml.Node `yaml:"path"`
+	}
+	if err := value.Decode(&long); err != nil {
+		return err
+	}
+
+	switch long.Path.Kind {
+	case yaml.ScalarNode:
+		i.Paths = []string{long.Path.Value}
+	case yaml.SequenceNode:
+		if err := long.Path.Decode(&i.Paths); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("include entry at line %d has no path", value.Line)
+	}
+	return nil
+}
+
+// ConstraintLabel is the service label holding the version range its image is kept in
+const ConstraintLabel = "img-upgr.constraint"
+
 // Service represents a service in a docker-compose file
 type Service struct {
-	Image string `yaml:"image"`
+	Image  string `yaml:"image"`
+	Labels Labels `yaml:"labels"`
+	// Line is the 1-based line of the image in the file
+	Line int `yaml:"-"`
+}
+
+// UnmarshalYAML decodes a service and records the line of its image
+func (s *Service) UnmarshalYAML(value *yaml.Node) error {
+	type plain Service
+	if err := value.Decode((*plain)(s)); err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if value.Content[i].Value == "image" {
+			s.Line = value.Content[i+1].Line
+		}
+	}
+	return nil
+}
+
+// Labels are the labels of a service, written as a mapping or as a list of key=value
+type Labels map[string]string
+
+// UnmarshalYAML decodes both label syntaxes
+func (l *Labels) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		var labels map[string]string
+		if err := value.Decode(&labels); err != nil {
+			return err
+		}
+		*l = labels
+		return nil
+	}
+
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = make(Labels, len(list))
+	for _, entry := range list {
+		key, val, _ := strings.Cut(entry, "=")
+		(*l)[key] = val
+	}
+	return nil
 }
 
 // ParseComposeFile parses a docker-compose file
 func ParseComposeFile(filename string) (*ComposeFile, error) {
-	data, err := os.ReadFile(filename)
+	data, err := repofs.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
+	return ParseCompose(data)
+}
+
+// ParseCompose parses the content of a docker-compose file
+func ParseCompose(data []byte) (*ComposeFile, error) {
 	var compose ComposeFile
 	if err := yaml.Unmarshal(data, &compose); err != nil {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
@@ -42,3 +129,25 @@ func (c *ComposeFile) GetImages() map[string]string {
 	}
 	return images
 }
+
+// GetImageLines returns the line of the image of each service
+func (c *ComposeFile) GetImageLines() map[string]int {
+	lines := make(map[string]int)
+	for serviceName, service := range c.Services {
+		if service.Image != "" {
+			lines[serviceName] = service.Line
+		}
+	}
+	return lines
+}
+
+// GetConstraints returns the version ranges set with the constraint label, by service
+func (c *ComposeFile) GetConstraints() map[string]string {
+	constraints := make(map[string]string)
+	for serviceName, service := range c.Services {
+		if constraint := service.Labels[ConstraintLabel]; constraint != "" {
+			constraints[serviceName] = constraint
+		}
+	}
+	return constraints
+}
@@ -0,0 +1,70 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGetConstraints(t *testing.T) {
+	content := `services:
+  web:
+    image: nginx:1.25.3
+    labels:
+      img-upgr.constraint: "~1.25"
+      traefik.enable: true
+  db:
+    image: postgres:15.4.0
+    labels:
+      - "img-upgr.constraint=>=15 <16"
+  cache:
+    image: redis:7.2.0
+`
+	path := filepath.Join(t.TempDir(), "docker-compose.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	composeFile, err := ParseComposeFile(path)
+	if err != nil {
+		t.Fatalf("ParseComposeFile() error = %v", err)
+	}
+
+	constraints := composeFile.GetConstraints()
+	want := map[string]string{"web": "~1.25", "db": ">=15 <16"}
+	if len(constraints) != len(want) {
+		t.Fatalf("GetConstraints() = %v, want %v", constraints, want)
+	}
+	for service, constraint := range want {
+		if constraints[service] != constraint {
+			t.Errorf("constraint of %s = %q, want %q", service, constraints[service], constraint)
+		}
+	}
+}
+
+func TestGetImageLines(t *testing.T) {
+	content := `services:
+  web:
+    labels:
+      traefik.enable: true
+    image: nginx:1.25.3
+  db:
+    image: postgres:15.4.0
+  build:
+    build: .
+`
+	composeFile, err := ParseCompose([]byte(content))
+	if err != nil {
+		t.Fatalf("ParseCompose() error = %v", err)
+	}
+
+	lines := composeFile.GetImageLines()
+	want := map[string]int{"web": 5, "db": 7}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("GetImageLines() = %v, want %v", lines, want)
+	}
+	if composeFile.Services["web"].Labels["traefik.enable"] != "true" {
+		t.Errorf("labels = %v, want them decoded", composeFile.Services["web"].Labels)
+	}
+}
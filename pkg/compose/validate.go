@@ -0,0 +1,32 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNoDocker is returned by ValidateWithDocker when the docker CLI is not installed
+var ErrNoDocker = errors.New("docker not found")
+
+// ValidateWithDocker checks a compose file with docker compose config, which resolves
+// its includes, extends and env files from the directory of the file
+func ValidateWithDocker(ctx context.Context, filename string) error {
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return ErrNoDocker
+	}
+
+	cmd := exec.CommandContext(ctx, docker, "compose", "-f", filepath.Base(filename), "config", "--quiet")
+	cmd.Dir = filepath.Dir(filename)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return fmt.Errorf("docker compose config failed: %w: %s", err, message)
+		}
+		return fmt.Errorf("docker compose config failed: %w", err)
+	}
+	return nil
+}
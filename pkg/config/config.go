@@ -1,12 +1,33 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"net/mail"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/policy"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/sbom"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/secret"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/signature"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/trace"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/track"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/transport"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/validation"
 )
 
@@ -20,27 +41,200 @@ const (
 	// DefaultTargetBranch is the default target branch for merge requests
 	DefaultTargetBranch = "main"
 
+	// DefaultGitRetries is the default number of retries of git network operations
+	DefaultGitRetries = 3
+
+	// DefaultConcurrency is the default number of compose files processed in parallel
+	DefaultConcurrency = 4
+
+	// DefaultLogMaxSize is the default size in megabytes a log file is rotated at
+	DefaultLogMaxSize = 10
+
+	// DefaultLogBackups is the default number of rotated log files kept
+	DefaultLogBackups = 3
+
+	// DefaultGroupBy is the default grouping of updates into merge requests
+	DefaultGroupBy = "none"
+
+	// DefaultCodeOwners is the default role of the owners of updated files in merge requests
+	DefaultCodeOwners = "none"
+
+	// DefaultMRRetries is the default number of retries of an update whose merge request failed
+	DefaultMRRetries = 2
+
+	// DefaultCleanupMinAge is the default age of the last commit of a branch without any
+	// merge request before the cleanup deletes it
+	DefaultCleanupMinAge = 24 * time.Hour
+
+	// DefaultLockTTL is the default age after which the lock of a run that died is taken over
+	DefaultLockTTL = time.Hour
+
+	// DefaultHookTimeout is the default timeout of the pre-update and post-update hooks
+	DefaultHookTimeout = 5 * time.Minute
+
+	// DefaultBranchConflict is the default handling of an existing branch of an update
+	DefaultBranchConflict = "reuse"
+
+	// DefaultSigningFormat is the default commit signing format
+	DefaultSigningFormat = "gpg"
+
+	// DefaultCommitStyle is the default commit message style
+	DefaultCommitStyle = "default"
+
+	// DefaultCommitType is the default Conventional Commits type
+	DefaultCommitType = "chore"
+
+	// DefaultCommitScope is the default Conventional Commits scope
+	DefaultCommitScope = "deps"
+
+	// DefaultMajorLabel is the default label of merge requests proposing major updates
+	DefaultMajorLabel = "major-update"
+
+	// DefaultListen is the default address of the HTTP API server
+	DefaultListen = ":8080"
+
+	// DefaultServiceName is the default service name of the exported traces
+	DefaultServiceName = "img-upgr"
+
 	// EnvPrefix is the prefix for all environment variables
 	EnvPrefix = "IMG_UPGR_"
+
+	// SecretFileSuffix is appended to the variables of secrets to read them from a file
+	SecretFileSuffix = "_FILE"
 )
 
 // Environment variable names
 const (
-	EnvScanDir       = EnvPrefix + "SCANDIR"
-	EnvLogLevel      = EnvPrefix + "LOG_LEVEL"
-	EnvGitLabUser    = EnvPrefix + "GL_USER"
-	EnvGitLabToken   = EnvPrefix + "GL_TOKEN"
-	EnvGitLabRepo    = EnvPrefix + "GL_REPO"
-	EnvGitLabProject = EnvPrefix + "GL_PROJECT_ID"
-	EnvGitLabEmail   = EnvPrefix + "GL_EMAIL"
-	EnvOutputFormat  = EnvPrefix + "OUTPUT_FORMAT"
+	EnvScanDir         = EnvPrefix + "SCANDIR"
+	EnvLogLevel        = EnvPrefix + "LOG_LEVEL"
+	EnvGitLabUser      = EnvPrefix + "GL_USER"
+	EnvGitLabToken     = EnvPrefix + "GL_TOKEN"
+	EnvProjectTokens   = EnvPrefix + "GL_PROJECT_TOKENS"
+	EnvGitLabRepo      = EnvPrefix + "GL_REPO"
+	EnvGitLabProject   = EnvPrefix + "GL_PROJECT_ID"
+	EnvGitLabEmail     = EnvPrefix + "GL_EMAIL"
+	EnvAPIOnly         = EnvPrefix + "API_ONLY"
+	EnvGitTimeout      = EnvPrefix + "GIT_TIMEOUT"
+	EnvGitRetries      = EnvPrefix + "GIT_RETRIES"
+	EnvPreUpdateHook   = EnvPrefix + "PRE_UPDATE_HOOK"
+	EnvPostUpdateHook  = EnvPrefix + "POST_UPDATE_HOOK"
+	EnvHookTimeout     = EnvPrefix + "HOOK_TIMEOUT"
+	EnvComposeConfig   = EnvPrefix + "COMPOSE_CONFIG"
+	EnvWorkDir         = EnvPrefix + "WORKDIR"
+	EnvOutputFormat    = EnvPrefix + "OUTPUT_FORMAT"
+	EnvReportFile      = EnvPrefix + "REPORT_FILE"
+	EnvDotenvFile      = EnvPrefix + "DOTENV_FILE"
+	EnvIssues          = EnvPrefix + "ISSUES"
+	EnvSigningKey      = EnvPrefix + "SIGNING_KEY"
+	EnvSigningKeyID    = EnvPrefix + "SIGNING_KEY_ID"
+	EnvSigningFormat   = EnvPrefix + "SIGNING_FORMAT"
+	EnvStateStore      = EnvPrefix + "STATE"
+	EnvConcurrency     = EnvPrefix + "CONCURRENCY"
+	EnvExclude         = EnvPrefix + "EXCLUDE"
+	EnvComposePatterns = EnvPrefix + "COMPOSE_PATTERNS"
+	EnvSkipDirs        = EnvPrefix + "SKIP_DIRS"
+	EnvGitignore       = EnvPrefix + "GITIGNORE"
+	EnvGitOps          = EnvPrefix + "GITOPS"
+	EnvTerraform       = EnvPrefix + "TERRAFORM"
+	EnvRegistries      = EnvPrefix + "REGISTRIES"
+	EnvMirrors         = EnvPrefix + "REGISTRY_MIRRORS"
+	EnvDockerHubUser   = EnvPrefix + "DOCKERHUB_USER"
+	EnvDockerHubToken  = EnvPrefix + "DOCKERHUB_TOKEN"
+	EnvRegistryLimits  = EnvPrefix + "REGISTRY_LIMITS"
+	EnvTagCatalog      = EnvPrefix + "TAG_CATALOG"
+	EnvMaxTags         = EnvPrefix + "MAX_TAGS"
+	EnvTagEarlyExit    = EnvPrefix + "TAG_EARLY_EXIT"
+	EnvTagFilter       = EnvPrefix + "TAG_FILTER"
+	EnvTagCache        = EnvPrefix + "TAG_CACHE"
+	EnvHTTPIdleConns   = EnvPrefix + "HTTP_IDLE_CONNS"
+	EnvHTTP2           = EnvPrefix + "HTTP2"
+	EnvConfigFile      = EnvPrefix + "CONFIG"
+	EnvProfile         = EnvPrefix + "PROFILE"
+	EnvProgress        = EnvPrefix + "PROGRESS"
+	EnvNoColor         = EnvPrefix + "NO_COLOR"
+	EnvRunID           = EnvPrefix + "RUN_ID"
+	EnvLogFile         = EnvPrefix + "LOG_FILE"
+	EnvLogFileOnly     = EnvPrefix + "LOG_FILE_ONLY"
+	EnvLogMaxSize      = EnvPrefix + "LOG_MAX_SIZE"
+	EnvLogBackups      = EnvPrefix + "LOG_BACKUPS"
+	EnvAuditLog        = EnvPrefix + "AUDIT_LOG"
+	EnvAuditToken      = EnvPrefix + "AUDIT_TOKEN"
+	EnvOTLPEndpoint    = EnvPrefix + "OTLP_ENDPOINT"
+	EnvOTLPHeaders     = EnvPrefix + "OTLP_HEADERS"
+	EnvRewriteImages   = EnvPrefix + "REWRITE_IMAGES"
+	EnvVerifyPull      = EnvPrefix + "VERIFY_PULL"
+	EnvPinDigest       = EnvPrefix + "PIN_DIGEST"
+	EnvMRLimit         = EnvPrefix + "MR_LIMIT"
+	EnvMRRunLimit      = EnvPrefix + "MR_RUN_LIMIT"
+	EnvMRRetries       = EnvPrefix + "MR_RETRIES"
+	EnvLock            = EnvPrefix + "LOCK"
+	EnvLockTTL         = EnvPrefix + "LOCK_TTL"
+	EnvBestEffort      = EnvPrefix + "BEST_EFFORT"
+	EnvDraft           = EnvPrefix + "DRAFT"
+	EnvRefreshNotes    = EnvPrefix + "REFRESH_NOTES"
+	EnvMRSquash        = EnvPrefix + "MR_SQUASH"
+	EnvMRRemoveBranch  = EnvPrefix + "MR_REMOVE_SOURCE_BRANCH"
+	EnvMRMergeMethod   = EnvPrefix + "MR_MERGE_METHOD"
+	EnvCodeOwners      = EnvPrefix + "CODE_OWNERS"
+	EnvOwnerRotation   = EnvPrefix + "OWNER_ROTATION"
+	EnvGroupBy         = EnvPrefix + "GROUP_BY"
+	EnvGroupDepth      = EnvPrefix + "GROUP_DEPTH"
+	EnvBranchConflict  = EnvPrefix + "BRANCH_CONFLICT"
+	EnvCleanup         = EnvPrefix + "CLEANUP"
+	EnvCleanupMinAge   = EnvPrefix + "CLEANUP_MIN_AGE"
+	EnvCommitStyle     = EnvPrefix + "COMMIT_STYLE"
+	EnvCommitType      = EnvPrefix + "COMMIT_TYPE"
+	EnvCommitScope     = EnvPrefix + "COMMIT_SCOPE"
+	EnvCommitTemplate  = EnvPrefix + "COMMIT_TEMPLATE"
+	EnvCommitAuthor    = EnvPrefix + "COMMIT_AUTHOR"
+	EnvCoAuthors       = EnvPrefix + "CO_AUTHORS"
+	EnvListen          = EnvPrefix + "LISTEN"
+	EnvServeToken      = EnvPrefix + "SERVE_TOKEN"
+	EnvWebhookSecret   = EnvPrefix + "WEBHOOK_SECRET"
+	EnvConsumers       = EnvPrefix + "WEBHOOK_CONSUMERS"
+	EnvAllowMajor      = EnvPrefix + "ALLOW_MAJOR"
+	EnvAllowDowngrade  = EnvPrefix + "ALLOW_DOWNGRADE"
+	EnvMajorLabel      = EnvPrefix + "MAJOR_LABEL"
+	EnvChangelog       = EnvPrefix + "CHANGELOG"
+	EnvPlatform        = EnvPrefix + "PLATFORM"
+	EnvCosignKey       = EnvPrefix + "COSIGN_KEY"
+	EnvCosignIdentity  = EnvPrefix + "COSIGN_IDENTITY"
+	EnvCosignIssuer    = EnvPrefix + "COSIGN_ISSUER"
+	EnvCosignAttest    = EnvPrefix + "COSIGN_ATTESTATION"
+	EnvSBOM            = EnvPrefix + "SBOM"
+	EnvEndOfLife       = EnvPrefix + "EOL"
+	EnvEOLUpgrade      = EnvPrefix + "EOL_UPGRADE"
+	EnvFreeze          = EnvPrefix + "FREEZE"
+	EnvGitHubToken     = EnvPrefix + "GITHUB_TOKEN"
+)
+
+// Standard OpenTelemetry variables, read when the img-upgr ones are not set
+const (
+	EnvOTELEndpoint    = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	EnvOTELHeaders     = "OTEL_EXPORTER_OTLP_HEADERS"
+	EnvOTELServiceName = "OTEL_SERVICE_NAME"
 )
 
 // ValidLogLevels contains the list of valid log levels
 var ValidLogLevels = []string{"DEBUG", "INFO", "WARN", "WARNING", "ERROR", "FATAL"}
 
-// ValidOutputFormats contains the list of valid output formats
-var ValidOutputFormats = []string{"text", "json", "yaml"}
+// ValidSigningFormats contains the list of valid commit signing formats
+var ValidSigningFormats = []string{"gpg", "ssh"}
+
+// ValidCommitStyles contains the list of valid commit message styles
+var ValidCommitStyles = []string{"default", "conventional"}
+
+// ValidGroupModes contains the list of valid merge request grouping modes
+var ValidGroupModes = []string{"none", "directory", "image"}
+
+// ValidBranchConflicts contains the list of valid ways of handling an existing branch of an update
+var ValidBranchConflicts = []string{"reuse", "force", "suffix"}
+
+// ValidMergeMethods contains the list of valid merge methods of GitLab projects
+var ValidMergeMethods = []string{"merge", "rebase_merge", "ff"}
+
+// ValidCodeOwnerRoles contains the list of roles the owners of updated files can be given
+var ValidCodeOwnerRoles = []string{"none", "assignees", "reviewers"}
 
 // GitLabClient is an interface for GitLab API client to avoid import cycle
 type GitLabClient interface {
@@ -52,18 +246,165 @@ type Config struct {
 	// General settings
 	Verbose  bool
 	Quiet    bool
+	NoColor  bool
 	LogLevel string
+	RunID    string
+
+	// Log file, rotated at a size in megabytes, written alongside the console or only
+	LogFile     string
+	LogFileOnly bool
+	LogMaxSize  int
+	LogBackups  int
+
+	// Audit log of the changes made, a file or an endpoint posted to with the token
+	AuditLog   string
+	AuditToken string
+
+	// OpenTelemetry collector the traces are exported to, with the headers of its requests
+	OTLPEndpoint string
+	OTLPHeaders  string
 
 	// Check command settings
-	OutputFormat string
-	DryRun       bool
+	OutputFormat   string
+	ReportFile     string
+	DotenvFile     string
+	DryRun         bool
+	Concurrency    int
+	Progress       bool
+	ReopenDeclined bool
+	Issues         bool
+	PlanFile       string
+	TrackDigests   bool
+	PinDigest      bool
+	StateStore     string
+	NewOnly        bool
+
+	// Registry settings, as comma-separated host=type and source=target pairs
+	Registries    string
+	Mirrors       string
+	RewriteImages bool
+
+	// Request the manifest of suggested tags before creating their merge requests
+	VerifyPull bool
+
+	// Limits of the requests sent to each registry, as comma-separated host=max[/interval] pairs
+	RegistryLimits string
+
+	// Limit of the tags listed per Docker Hub repository, zero for no limit, whether
+	// listing stops at the current version of an image, and whether registries filtering
+	// tags by name only list the tags with the prefix of the current version
+	MaxTags      int
+	TagEarlyExit bool
+	TagFilter    bool
+
+	// File keeping the pages of tag lists with their ETag between runs, so registries
+	// answer the unchanged ones with a 304
+	TagCache string
+
+	// Idle connections kept per host by the HTTP transport shared by the registry and
+	// GitLab clients, and whether HTTP/2 is negotiated with the hosts supporting it
+	HTTPIdleConns int
+	HTTP2         bool
+
+	// Docker Hub account authenticating the requests to Docker Hub, instead of the
+	// credentials of the Docker CLI
+	DockerHubUser  string
+	DockerHubToken string
+
+	// Catalog of tags exported by export-tags, or OCI layout, read instead of the registries
+	TagCatalog string
+
+	// Version ranges by service name or image repository, major updates opt-in and
+	// downgrades of tags newer than the latest allowed version
+	Constraints    map[string]string
+	AllowMajor     bool
+	MajorLabel     string
+	AllowDowngrade bool
+
+	// Patterns of the tags never suggested by image repository, AnyRepository applying to all
+	ExcludeTags map[string][]string
+
+	// Floating tags, such as stable, capping the suggested versions by image repository,
+	// AnyRepository applying to all
+	Channels map[string]string
+
+	// Release tracks followed by image repository, AnyRepository applying to all, and the
+	// long-term support releases of images added to the built-in ones
+	Tracks      map[string]string
+	LTSReleases map[string]string
+
+	// Merge request handling by bump, set in the repository configuration file
+	Policies []policy.Rule
+
+	// Platform the suggested tags must be published for, as os/arch[/variant]
+	Platform string
+
+	// Cosign verification of the suggested images, against a public key or the identity
+	// of keyless signatures, optionally of an attestation of the given predicate type
+	CosignKey         string
+	CosignIdentity    string
+	CosignIssuer      string
+	CosignAttestation string
+
+	// Source of the SBOMs of the old and new images whose package differences are
+	// summarized in merge requests, disabled when empty
+	SBOM string
+
+	// End of life checks of the release series of images, and upgrades to the next
+	// supported series
+	EndOfLife  bool
+	EOLUpgrade bool
+
+	// Freeze windows during which no merge requests are created, as comma-separated
+	// from..until dates
+	Freeze string
+
+	// Release notes of the updates embedded in merge requests, with the source
+	// repositories of images by image repository
+	Changelog        bool
+	ChangelogSources map[string]string
+	GitHubToken      string
 
 	// Scan command settings
-	ScanDir      string
-	CreateMR     bool
-	TargetBranch string
-	TempDir      string
-	ClonedRepo   bool
+	ScanDir         string
+	ComposePatterns []string
+	Exclude         []string
+	SkipDirs        []string
+	Gitignore       bool
+	GitOps          bool
+	Terraform       bool
+	CreateMR        bool
+	TargetBranch    string
+	GroupBy         string
+	GroupDepth      int
+	BranchConflict  string
+	Cleanup         bool
+	CleanupMinAge   time.Duration
+	MRLimit         int
+	MRRunLimit      int
+	MRRetries       int
+	Lock            bool
+	LockTTL         time.Duration
+	BestEffort      bool
+	Draft           bool
+	RefreshNotes    bool
+	APIOnly         bool
+	GitTimeout      time.Duration
+	GitRetries      int
+	WorkDir         string
+	TempDir         string
+	ClonedRepo      bool
+
+	// Squash and source branch removal of the merge requests, and the merge method
+	// the project is expected to use, checked against its settings
+	MRSquash             bool
+	MRRemoveSourceBranch bool
+	MRMergeMethod        string
+
+	// CodeOwners sets whether the owners of the updated files become the assignees or
+	// the reviewers of merge requests, and OwnerRotation picks one of them in turn
+	CodeOwners    string
+	OwnerRotation bool
 
 	// GitLab settings
 	GitLabUser      string
@@ -72,23 +413,82 @@ type Config struct {
 	GitLabProjectID string
 	GitLabEmail     string
 
+	// GitLabProjectTokens are comma-separated path=token pairs of the tokens of projects,
+	// or of the projects of a group, used instead of GitLabToken
+	GitLabProjectTokens string
+
+	// Commit signing settings
+	SigningKey    string
+	SigningKeyID  string
+	SigningFormat string
+	// GPGHome is the temporary keyring holding the GPG signing key during a run
+	GPGHome string
+
+	// Commit message settings, the author and co-authors being "Name <email>" identities
+	CommitStyle    string
+	CommitType     string
+	CommitScope    string
+	CommitTemplate string
+	CommitAuthor   string
+	CoAuthors      []string
+
+	// Shell commands run in the clone before and after the updates of a branch are
+	// applied, and whether edited compose files are checked with docker compose config,
+	// a failure aborting its merge request
+	PreUpdateHook  string
+	PostUpdateHook string
+	HookTimeout    time.Duration
+	ComposeConfig  bool
+
+	// Server settings, consumers being comma-separated project=repository pairs
+	Listen           string
+	ServeToken       string
+	WebhookSecret    string
+	WebhookConsumers string
+
 	// GitLab client (set after initialization)
 	GitLabClient interface{}
+
+	// secretErrors holds the errors of reading the secret files of *_FILE variables
+	secretErrors []error
 }
 
 // New creates a new Config with default values
 func New() *Config {
 	return &Config{
-		Verbose:      false,
-		Quiet:        false,
-		LogLevel:     DefaultLogLevel,
-		OutputFormat: DefaultOutputFormat,
-		DryRun:       false,
-		ScanDir:      "",
-		CreateMR:     false,
-		TargetBranch: DefaultTargetBranch,
-		TempDir:      "",
-		ClonedRepo:   false,
+		Verbose:        false,
+		Quiet:          false,
+		LogLevel:       DefaultLogLevel,
+		OutputFormat:   DefaultOutputFormat,
+		DryRun:         false,
+		ScanDir:        "",
+		Gitignore:      true,
+		CreateMR:       false,
+		TargetBranch:   DefaultTargetBranch,
+		GroupBy:        DefaultGroupBy,
+		CodeOwners:     DefaultCodeOwners,
+		BranchConflict: DefaultBranchConflict,
+		CleanupMinAge:  DefaultCleanupMinAge,
+		TempDir:        "",
+		ClonedRepo:     false,
+		Concurrency:    DefaultConcurrency,
+		Progress:       true,
+		RefreshNotes:   true,
+		TagFilter:      true,
+		HTTPIdleConns:  transport.DefaultMaxIdleConnsPerHost,
+		HTTP2:          true,
+		LogMaxSize:     DefaultLogMaxSize,
+		LogBackups:     DefaultLogBackups,
+		GitRetries:     DefaultGitRetries,
+		HookTimeout:    DefaultHookTimeout,
+		MRRetries:      DefaultMRRetries,
+		LockTTL:        DefaultLockTTL,
+		SigningFormat:  DefaultSigningFormat,
+		CommitStyle:    DefaultCommitStyle,
+		CommitType:     DefaultCommitType,
+		CommitScope:    DefaultCommitScope,
+		MajorLabel:     DefaultMajorLabel,
+		Listen:         DefaultListen,
 	}
 }
 
@@ -96,32 +496,306 @@ func New() *Config {
 func (c *Config) LoadFromEnv() {
 	// Scan settings
 	c.ScanDir = getEnvOrDefault(EnvScanDir, c.ScanDir)
+	c.ComposePatterns = getEnvList(EnvComposePatterns, c.ComposePatterns)
+	c.Exclude = getEnvList(EnvExclude, c.Exclude)
+	c.SkipDirs = getEnvList(EnvSkipDirs, c.SkipDirs)
+	c.Gitignore = getEnvBool(EnvGitignore, c.Gitignore)
+	c.GitOps = getEnvBool(EnvGitOps, c.GitOps)
+	c.Terraform = getEnvBool(EnvTerraform, c.Terraform)
 
 	// GitLab settings
 	c.GitLabUser = getEnvOrDefault(EnvGitLabUser, c.GitLabUser)
-	c.GitLabToken = getEnvOrDefault(EnvGitLabToken, c.GitLabToken)
+	c.GitLabToken = c.getEnvSecret(EnvGitLabToken, c.GitLabToken)
+	c.GitLabProjectTokens = c.getEnvSecret(EnvProjectTokens, c.GitLabProjectTokens)
 	c.GitLabRepo = getEnvOrDefault(EnvGitLabRepo, c.GitLabRepo)
 	c.GitLabProjectID = getEnvOrDefault(EnvGitLabProject, c.GitLabProjectID)
 	c.GitLabEmail = getEnvOrDefault(EnvGitLabEmail, c.GitLabEmail)
+	c.APIOnly = getEnvBool(EnvAPIOnly, c.APIOnly)
+	c.GitTimeout = getEnvDuration(EnvGitTimeout, c.GitTimeout)
+	c.GitRetries = getEnvInt(EnvGitRetries, c.GitRetries)
+	c.WorkDir = getEnvOrDefault(EnvWorkDir, c.WorkDir)
+	c.PreUpdateHook = getEnvOrDefault(EnvPreUpdateHook, c.PreUpdateHook)
+	c.PostUpdateHook = getEnvOrDefault(EnvPostUpdateHook, c.PostUpdateHook)
+	c.HookTimeout = getEnvDuration(EnvHookTimeout, c.HookTimeout)
+	c.ComposeConfig = getEnvBool(EnvComposeConfig, c.ComposeConfig)
+
+	// Registry settings
+	c.Registries = getEnvOrDefault(EnvRegistries, c.Registries)
+	c.Mirrors = getEnvOrDefault(EnvMirrors, c.Mirrors)
+	c.RegistryLimits = getEnvOrDefault(EnvRegistryLimits, c.RegistryLimits)
+	c.MaxTags = getEnvInt(EnvMaxTags, c.MaxTags)
+	c.TagEarlyExit = getEnvBool(EnvTagEarlyExit, c.TagEarlyExit)
+	c.TagFilter = getEnvBool(EnvTagFilter, c.TagFilter)
+	c.TagCache = getEnvOrDefault(EnvTagCache, c.TagCache)
+	c.DockerHubUser = getEnvOrDefault(EnvDockerHubUser, c.DockerHubUser)
+	c.DockerHubToken = c.getEnvSecret(EnvDockerHubToken, c.DockerHubToken)
+	c.TagCatalog = getEnvOrDefault(EnvTagCatalog, c.TagCatalog)
+	c.RewriteImages = getEnvBool(EnvRewriteImages, c.RewriteImages)
+	c.VerifyPull = getEnvBool(EnvVerifyPull, c.VerifyPull)
+	c.PinDigest = getEnvBool(EnvPinDigest, c.PinDigest)
+
+	// Version settings
+	c.AllowMajor = getEnvBool(EnvAllowMajor, c.AllowMajor)
+	c.AllowDowngrade = getEnvBool(EnvAllowDowngrade, c.AllowDowngrade)
+	c.MajorLabel = getEnvOrDefault(EnvMajorLabel, c.MajorLabel)
+	c.Platform = getEnvOrDefault(EnvPlatform, c.Platform)
+	c.CosignKey = getEnvOrDefault(EnvCosignKey, c.CosignKey)
+	c.CosignIdentity = getEnvOrDefault(EnvCosignIdentity, c.CosignIdentity)
+	c.CosignIssuer = getEnvOrDefault(EnvCosignIssuer, c.CosignIssuer)
+	c.CosignAttestation = getEnvOrDefault(EnvCosignAttest, c.CosignAttestation)
+	c.SBOM = getEnvOrDefault(EnvSBOM, c.SBOM)
+	c.EndOfLife = getEnvBool(EnvEndOfLife, c.EndOfLife)
+	c.EOLUpgrade = getEnvBool(EnvEOLUpgrade, c.EOLUpgrade)
+	c.Freeze = getEnvOrDefault(EnvFreeze, c.Freeze)
+	c.Changelog = getEnvBool(EnvChangelog, c.Changelog)
+	c.GitHubToken = c.getEnvSecret(EnvGitHubToken, c.GitHubToken)
+
+	// State settings
+	c.StateStore = getEnvOrDefault(EnvStateStore, c.StateStore)
+
+	// Merge request limits
+	c.MRLimit = getEnvInt(EnvMRLimit, c.MRLimit)
+	c.MRRunLimit = getEnvInt(EnvMRRunLimit, c.MRRunLimit)
+	c.MRRetries = getEnvInt(EnvMRRetries, c.MRRetries)
+	c.Lock = getEnvBool(EnvLock, c.Lock)
+	c.LockTTL = getEnvDuration(EnvLockTTL, c.LockTTL)
+	c.BestEffort = getEnvBool(EnvBestEffort, c.BestEffort)
+	c.Draft = getEnvBool(EnvDraft, c.Draft)
+	c.RefreshNotes = getEnvBool(EnvRefreshNotes, c.RefreshNotes)
+	c.MRSquash = getEnvBool(EnvMRSquash, c.MRSquash)
+	c.MRRemoveSourceBranch = getEnvBool(EnvMRRemoveBranch, c.MRRemoveSourceBranch)
+	c.MRMergeMethod = getEnvOrDefault(EnvMRMergeMethod, c.MRMergeMethod)
+	c.CodeOwners = getEnvOrDefault(EnvCodeOwners, c.CodeOwners)
+	c.OwnerRotation = getEnvBool(EnvOwnerRotation, c.OwnerRotation)
+
+	// Merge request grouping settings
+	c.GroupBy = getEnvOrDefault(EnvGroupBy, c.GroupBy)
+	c.GroupDepth = getEnvInt(EnvGroupDepth, c.GroupDepth)
+	c.BranchConflict = getEnvOrDefault(EnvBranchConflict, c.BranchConflict)
+	c.Cleanup = getEnvBool(EnvCleanup, c.Cleanup)
+	c.CleanupMinAge = getEnvDuration(EnvCleanupMinAge, c.CleanupMinAge)
+
+	// Commit signing settings
+	c.SigningKey = getEnvOrDefault(EnvSigningKey, c.SigningKey)
+	c.SigningKeyID = getEnvOrDefault(EnvSigningKeyID, c.SigningKeyID)
+	c.SigningFormat = getEnvOrDefault(EnvSigningFormat, c.SigningFormat)
+
+	// Commit message settings
+	c.CommitStyle = getEnvOrDefault(EnvCommitStyle, c.CommitStyle)
+	c.CommitType = getEnvOrDefault(EnvCommitType, c.CommitType)
+	c.CommitScope = getEnvOrDefault(EnvCommitScope, c.CommitScope)
+	c.CommitTemplate = getEnvOrDefault(EnvCommitTemplate, c.CommitTemplate)
+	c.CommitAuthor = getEnvOrDefault(EnvCommitAuthor, c.CommitAuthor)
+	c.CoAuthors = getEnvList(EnvCoAuthors, c.CoAuthors)
 
 	// Logging settings
 	c.LogLevel = getEnvOrDefault(EnvLogLevel, c.LogLevel)
-
-	// Output format
+	c.NoColor = getEnvBool(EnvNoColor, c.NoColor)
+	c.RunID = getEnvOrDefault(EnvRunID, c.RunID)
+	c.LogFile = getEnvOrDefault(EnvLogFile, c.LogFile)
+	c.LogFileOnly = getEnvBool(EnvLogFileOnly, c.LogFileOnly)
+	c.LogMaxSize = getEnvInt(EnvLogMaxSize, c.LogMaxSize)
+	c.LogBackups = getEnvInt(EnvLogBackups, c.LogBackups)
+	c.AuditLog = getEnvOrDefault(EnvAuditLog, c.AuditLog)
+	c.AuditToken = c.getEnvSecret(EnvAuditToken, c.AuditToken)
+	c.OTLPEndpoint = getEnvOrDefault(EnvOTLPEndpoint, getEnvOrDefault(EnvOTELEndpoint, c.OTLPEndpoint))
+	c.OTLPHeaders = c.getEnvSecret(EnvOTLPHeaders, getEnvOrDefault(EnvOTELHeaders, c.OTLPHeaders))
+
+	// Output format, and the files the result is also written to for CI artifacts
 	c.OutputFormat = getEnvOrDefault(EnvOutputFormat, c.OutputFormat)
+	c.ReportFile = getEnvOrDefault(EnvReportFile, c.ReportFile)
+	c.DotenvFile = getEnvOrDefault(EnvDotenvFile, c.DotenvFile)
+
+	// Issues opened for the images without a valid upgrade path
+	c.Issues = getEnvBool(EnvIssues, c.Issues)
+
+	// Processing settings
+	c.Concurrency = getEnvInt(EnvConcurrency, c.Concurrency)
+	c.Progress = getEnvBool(EnvProgress, c.Progress)
+	c.HTTPIdleConns = getEnvInt(EnvHTTPIdleConns, c.HTTPIdleConns)
+	c.HTTP2 = getEnvBool(EnvHTTP2, c.HTTP2)
+
+	// Server settings
+	c.Listen = getEnvOrDefault(EnvListen, c.Listen)
+	c.ServeToken = c.getEnvSecret(EnvServeToken, c.ServeToken)
+	c.WebhookSecret = c.getEnvSecret(EnvWebhookSecret, c.WebhookSecret)
+	c.WebhookConsumers = getEnvOrDefault(EnvConsumers, c.WebhookConsumers)
 
 	// Configure logger based on settings
 	c.ConfigureLogger()
 }
 
-// getEnvOrDefault returns the environment variable value or the default if not set
+// getEnvOrDefault returns the environment variable value or the default if not set,
+// variables not set being read from the configuration file if one was loaded
 func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+	if value := lookupEnv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
+// getEnvSecret returns the environment variable value or the content of the file named by
+// its _FILE variant, such as IMG_UPGR_GL_TOKEN_FILE, or the default if neither is set.
+// The value may reference a secret resolved by ResolveSecrets.
+func (c *Config) getEnvSecret(key, defaultValue string) string {
+	if value := lookupEnv(key); value != "" {
+		return value
+	}
+
+	path := lookupEnv(key + SecretFileSuffix)
+	if path == "" {
+		return defaultValue
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.secretErrors = append(c.secretErrors, fmt.Errorf("failed to read %s%s: %w", key, SecretFileSuffix, err))
+		return defaultValue
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+// ResolveSecrets replaces the secret settings referencing a Vault or Kubernetes secret,
+// such as vault:secret/data/img-upgr#gl_token, by the secret. It is run by the commands
+// rather than when the settings are loaded, so only the commands needing them connect.
+func (c *Config) ResolveSecrets(ctx context.Context) error {
+	if len(c.secretErrors) > 0 {
+		return errors.Join(c.secretErrors...)
+	}
+
+	secrets := map[string]*string{
+		EnvGitLabToken:    &c.GitLabToken,
+		EnvGitHubToken:    &c.GitHubToken,
+		EnvDockerHubToken: &c.DockerHubToken,
+		EnvServeToken:     &c.ServeToken,
+		EnvWebhookSecret:  &c.WebhookSecret,
+	}
+
+	var resolver *secret.Resolver
+	for key, value := range secrets {
+		if !secret.IsReference(*value) {
+			continue
+		}
+		if resolver == nil {
+			resolver = secret.NewResolver()
+		}
+		resolved, err := resolver.Resolve(ctx, *value)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", key, err)
+		}
+		*value = resolved
+	}
+
+	// Each project token can reference a secret of its own
+	tokens, err := c.ProjectTokens()
+	if err != nil {
+		return err
+	}
+	resolvedTokens := false
+	for project, token := range tokens {
+		if !secret.IsReference(token) {
+			continue
+		}
+		if resolver == nil {
+			resolver = secret.NewResolver()
+		}
+		resolved, err := resolver.Resolve(ctx, token)
+		if err != nil {
+			return fmt.Errorf("failed to resolve the token of %s in %s: %w", project, EnvProjectTokens, err)
+		}
+		tokens[project] = resolved
+		resolvedTokens = true
+	}
+	if resolvedTokens {
+		c.GitLabProjectTokens = formatPairs(tokens)
+	}
+	return nil
+}
+
+// ProjectTokens parses the GitLab tokens set per project into a map of project or group
+// path to token
+func (c *Config) ProjectTokens() (map[string]string, error) {
+	tokens, err := parsePairs(c.GitLabProjectTokens, "path=token")
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", EnvProjectTokens, err)
+	}
+	return tokens, nil
+}
+
+// UseProjectToken replaces the GitLab token by the one set for the project of GitLabRepo,
+// or else for its closest group, keeping GitLabToken for the projects without one. It is
+// run once the secrets are resolved.
+func (c *Config) UseProjectToken() error {
+	tokens, err := c.ProjectTokens()
+	if err != nil || len(tokens) == 0 {
+		return err
+	}
+
+	parsedURL, err := url.Parse(c.GitLabRepo)
+	if err != nil {
+		return fmt.Errorf("invalid repository URL: %w", err)
+	}
+	project := strings.ToLower(strings.Trim(strings.TrimSuffix(parsedURL.Path, ".git"), "/"))
+
+	// The longest matching path is the most specific one
+	match := ""
+	for key := range tokens {
+		prefix := strings.ToLower(strings.Trim(key, "/"))
+		if (project == prefix || strings.HasPrefix(project, prefix+"/")) && len(key) > len(match) {
+			match = key
+		}
+	}
+	if match == "" {
+		return nil
+	}
+
+	logger.Debug("Using the GitLab token set for %s in %s", match, EnvProjectTokens)
+	c.GitLabToken = tokens[match]
+	return nil
+}
+
+// getEnvBool returns the environment variable parsed as a boolean or the default if not set or invalid
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(lookupEnv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvInt returns the environment variable parsed as an integer or the default if not set or invalid
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(lookupEnv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDuration returns the environment variable parsed as a duration or the default if not set or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(lookupEnv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvList returns the environment variable split on commas or the default if not set
+func getEnvList(key string, defaultValue []string) []string {
+	value := lookupEnv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // Validate performs comprehensive validation of all configuration settings
 func (c *Config) Validate() error {
 	// Create a validation errors collection
@@ -132,10 +806,27 @@ func (c *Config) Validate() error {
 		validationErrors.Add("LogLevel", err.Error())
 	}
 
-	// Validate output format
-	if !validation.IsValidOutputFormat(c.OutputFormat, ValidOutputFormats) {
-		validationErrors.Add("OutputFormat", fmt.Sprintf("invalid output format: %s (valid formats: %s)",
-			c.OutputFormat, strings.Join(ValidOutputFormats, ", ")))
+	// Validate log file rotation
+	if c.LogMaxSize < 0 {
+		validationErrors.Add("LogMaxSize", fmt.Sprintf("log file size must not be negative, got %d", c.LogMaxSize))
+	}
+	if c.LogBackups < 0 {
+		validationErrors.Add("LogBackups", fmt.Sprintf("log file backups must not be negative, got %d", c.LogBackups))
+	}
+	if c.RunID != "" {
+		if err := runid.Validate(c.RunID); err != nil {
+			validationErrors.Add("RunID", err.Error())
+		}
+	}
+
+	// Validate the OpenTelemetry collector
+	if c.OTLPEndpoint != "" {
+		if _, err := trace.TracesURL(c.OTLPEndpoint); err != nil {
+			validationErrors.Add("OTLPEndpoint", err.Error())
+		}
+	}
+	if _, err := trace.ParseHeaders(c.OTLPHeaders); err != nil {
+		validationErrors.Add("OTLPHeaders", err.Error())
 	}
 
 	// Validate scan directory if set
@@ -146,6 +837,170 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	// Validate commit signing settings if a signing key is configured
+	if c.SigningKey != "" {
+		if !validation.IsValidChoice(c.SigningFormat, ValidSigningFormats) {
+			validationErrors.Add("SigningFormat", fmt.Sprintf("invalid signing format: %s (valid formats: %s)",
+				c.SigningFormat, strings.Join(ValidSigningFormats, ", ")))
+		}
+		if err := validation.ValidateFile(c.SigningKey); err != nil {
+			validationErrors.Add("SigningKey", err.Error())
+		}
+	}
+
+	// Commits created through the API cannot be signed
+	if c.APIOnly && c.SigningKey != "" {
+		validationErrors.Add("APIOnly", "commit signing requires a git clone and cannot be used in API-only mode")
+	}
+
+	// Hooks and docker compose run in the clone, which API-only mode does not have
+	if c.APIOnly && (c.PreUpdateHook != "" || c.PostUpdateHook != "") {
+		validationErrors.Add("APIOnly", "update hooks require a git clone and cannot be used in API-only mode")
+	}
+	if c.APIOnly && c.ComposeConfig {
+		validationErrors.Add("APIOnly", "docker compose config requires a git clone and cannot be used in API-only mode")
+	}
+	if c.HookTimeout < 0 {
+		validationErrors.Add("HookTimeout", fmt.Sprintf("hook timeout must not be negative, got %s", c.HookTimeout))
+	}
+
+	// Validate compose file and exclude patterns
+	for _, pattern := range c.ComposePatterns {
+		if _, err := path.Match(filepath.ToSlash(pattern), ""); err != nil {
+			validationErrors.Add("ComposePatterns", fmt.Sprintf("invalid compose file pattern: %s", pattern))
+		}
+	}
+	for _, pattern := range c.Exclude {
+		if _, err := path.Match(filepath.ToSlash(pattern), ""); err != nil {
+			validationErrors.Add("Exclude", fmt.Sprintf("invalid exclude pattern: %s", pattern))
+		}
+	}
+
+	// Validate git command limits
+	if c.GitTimeout < 0 {
+		validationErrors.Add("GitTimeout", fmt.Sprintf("git timeout must not be negative, got %s", c.GitTimeout))
+	}
+	if c.GitRetries < 0 {
+		validationErrors.Add("GitRetries", fmt.Sprintf("git retries must not be negative, got %d", c.GitRetries))
+	}
+
+	// Validate concurrency
+	if c.Concurrency < 1 {
+		validationErrors.Add("Concurrency", fmt.Sprintf("concurrency must be at least 1, got %d", c.Concurrency))
+	}
+	if c.HTTPIdleConns < 1 {
+		validationErrors.Add("HTTPIdleConns", fmt.Sprintf("idle connections per host must be at least 1, got %d", c.HTTPIdleConns))
+	}
+
+	// Validate merge request limits
+	if c.MRLimit < 0 {
+		validationErrors.Add("MRLimit", fmt.Sprintf("merge request limit must not be negative, got %d", c.MRLimit))
+	}
+	if c.MRRunLimit < 0 {
+		validationErrors.Add("MRRunLimit", fmt.Sprintf("merge request run limit must not be negative, got %d", c.MRRunLimit))
+	}
+	if c.MRRetries < 0 {
+		validationErrors.Add("MRRetries", fmt.Sprintf("merge request retries must not be negative, got %d", c.MRRetries))
+	}
+	if c.LockTTL <= 0 {
+		validationErrors.Add("LockTTL", fmt.Sprintf("lock TTL must be positive, got %s", c.LockTTL))
+	}
+
+	// Validate merge request grouping
+	if !validation.IsValidChoice(c.GroupBy, ValidGroupModes) {
+		validationErrors.Add("GroupBy", fmt.Sprintf("invalid grouping: %s (valid modes: %s)",
+			c.GroupBy, strings.Join(ValidGroupModes, ", ")))
+	}
+	if c.MaxTags < 0 {
+		validationErrors.Add("MaxTags", fmt.Sprintf("maximum number of tags must not be negative, got %d", c.MaxTags))
+	}
+	if c.GroupDepth < 0 {
+		validationErrors.Add("GroupDepth", fmt.Sprintf("group depth must not be negative, got %d", c.GroupDepth))
+	}
+	if c.CleanupMinAge < 0 {
+		validationErrors.Add("CleanupMinAge", fmt.Sprintf("cleanup minimum age must not be negative, got %s", c.CleanupMinAge))
+	}
+	if !validation.IsValidChoice(c.BranchConflict, ValidBranchConflicts) {
+		validationErrors.Add("BranchConflict", fmt.Sprintf("invalid branch conflict handling: %s (valid values: %s)",
+			c.BranchConflict, strings.Join(ValidBranchConflicts, ", ")))
+	}
+
+	// Validate commit message settings
+	if c.MRMergeMethod != "" && !validation.IsValidChoice(c.MRMergeMethod, ValidMergeMethods) {
+		validationErrors.Add("MRMergeMethod", fmt.Sprintf("invalid merge method: %s (valid methods: %s)",
+			c.MRMergeMethod, strings.Join(ValidMergeMethods, ", ")))
+	}
+	if !validation.IsValidChoice(c.CodeOwners, ValidCodeOwnerRoles) {
+		validationErrors.Add("CodeOwners", fmt.Sprintf("invalid code owner role: %s (valid roles: %s)",
+			c.CodeOwners, strings.Join(ValidCodeOwnerRoles, ", ")))
+	}
+
+	if !validation.IsValidChoice(c.CommitStyle, ValidCommitStyles) {
+		validationErrors.Add("CommitStyle", fmt.Sprintf("invalid commit style: %s (valid styles: %s)",
+			c.CommitStyle, strings.Join(ValidCommitStyles, ", ")))
+	}
+	if c.CommitTemplate != "" {
+		if _, err := template.New("commit").Parse(c.CommitTemplate); err != nil {
+			validationErrors.Add("CommitTemplate", fmt.Sprintf("invalid commit template: %v", err))
+		}
+	}
+	if c.CommitAuthor != "" {
+		if _, _, err := ParseIdentity(c.CommitAuthor); err != nil {
+			validationErrors.Add("CommitAuthor", err.Error())
+		}
+	}
+	for _, coAuthor := range c.CoAuthors {
+		if _, _, err := ParseIdentity(coAuthor); err != nil {
+			validationErrors.Add("CoAuthors", err.Error())
+		}
+	}
+
+	// Validate registry adapters
+	if _, err := c.RegistryTypes(); err != nil {
+		validationErrors.Add("Registries", err.Error())
+	}
+
+	// Validate webhook consumers
+	if _, err := c.ConsumerRepositories(); err != nil {
+		validationErrors.Add("WebhookConsumers", err.Error())
+	}
+
+	// Validate registry mirrors
+	if _, err := c.RegistryMirrors(); err != nil {
+		validationErrors.Add("Mirrors", err.Error())
+	}
+	if _, err := c.HostLimits(); err != nil {
+		validationErrors.Add("RegistryLimits", err.Error())
+	}
+
+	if _, err := c.RequiredPlatform(); err != nil {
+		validationErrors.Add("Platform", err.Error())
+	}
+
+	// Validate signature verification, either with a key or a keyless identity
+	switch {
+	case c.CosignKey != "" && (c.CosignIdentity != "" || c.CosignIssuer != ""):
+		validationErrors.Add("CosignKey", fmt.Sprintf("%s cannot be combined with %s or %s", EnvCosignKey, EnvCosignIdentity, EnvCosignIssuer))
+	case (c.CosignIdentity == "") != (c.CosignIssuer == ""):
+		validationErrors.Add("CosignIdentity", fmt.Sprintf("%s and %s must be set together", EnvCosignIdentity, EnvCosignIssuer))
+	case c.CosignAttestation != "" && c.SignatureVerifier() == nil:
+		validationErrors.Add("CosignAttestation", fmt.Sprintf("%s requires %s or %s", EnvCosignAttest, EnvCosignKey, EnvCosignIdentity))
+	}
+
+	if c.SBOM != "" && !validation.IsValidChoice(c.SBOM, sbom.Sources) {
+		validationErrors.Add("SBOM", fmt.Sprintf("invalid SBOM source: %s (valid sources: %s)",
+			c.SBOM, strings.Join(sbom.Sources, ", ")))
+	}
+
+	if _, err := c.FreezeWindows(); err != nil {
+		validationErrors.Add("Freeze", err.Error())
+	}
+
+	// New-only reporting compares against the previous run
+	if c.NewOnly && c.StateStore == "" {
+		validationErrors.Add("StateStore", "a state store must be configured to report only new updates")
+	}
+
 	// Validate target branch if creating merge requests
 	if c.CreateMR && c.TargetBranch == "" {
 		validationErrors.Add("TargetBranch", "target branch must be specified when creating merge requests")
@@ -196,6 +1051,175 @@ func (c *Config) ValidateGitLab() error {
 	return nil
 }
 
+// RegistryTypes parses the configured registries into a map of host to registry type
+func (c *Config) RegistryTypes() (map[string]string, error) {
+	registries, err := parsePairs(c.Registries, "host=type")
+	if err != nil {
+		return nil, err
+	}
+
+	for host, registryType := range registries {
+		if !validation.IsValidChoice(registryType, registry.ValidTypes) {
+			return nil, fmt.Errorf("invalid registry type for %s: %s (valid types: %s)",
+				host, registryType, strings.Join(registry.ValidTypes, ", "))
+		}
+	}
+
+	return registries, nil
+}
+
+// RequiredPlatform parses the platform suggested tags must be published for, nil if unset
+func (c *Config) RequiredPlatform() (*registry.Platform, error) {
+	if c.Platform == "" {
+		return nil, nil
+	}
+
+	platform, err := registry.ParsePlatform(c.Platform)
+	if err != nil {
+		return nil, err
+	}
+	return &platform, nil
+}
+
+// ExcludedTags compiles the patterns of the tags never suggested for an image repository,
+// its own ones and the ones set for any repository
+func (c *Config) ExcludedTags(repo string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for name, values := range c.ExcludeTags {
+		if name != AnyRepository && !registry.SameRepository(name, repo) {
+			continue
+		}
+		for _, value := range values {
+			pattern, err := CompileTagPattern(value)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// Channel returns the floating tag whose version caps the suggested versions of an image
+// repository, empty if it has none. A repository's own channel takes precedence.
+func (c *Config) Channel(repo string) string {
+	for name, channel := range c.Channels {
+		if name != AnyRepository && registry.SameRepository(name, repo) {
+			return channel
+		}
+	}
+	return c.Channels[AnyRepository]
+}
+
+// LTSRule returns the long-term support releases an image repository is kept on, nil
+// when it follows any version. Following the LTS track of an image without known LTS
+// releases is an error, unless the track is only set for any repository.
+func (c *Config) LTSRule(repo string) (*track.Rule, error) {
+	name, value := AnyRepository, c.Tracks[AnyRepository]
+	for key, tr := range c.Tracks {
+		if key != AnyRepository && registry.SameRepository(key, repo) {
+			name, value = key, tr
+			break
+		}
+	}
+	if value != track.LTS {
+		return nil, nil
+	}
+
+	spec, ok := track.Known(repo, c.LTSReleases)
+	if !ok {
+		if name == AnyRepository {
+			logger.Debug("No LTS releases known for %s, suggesting any version", repo)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("no LTS releases known for %s, set them in lts_releases", repo)
+	}
+	return track.Parse(spec)
+}
+
+// CompileTagPattern compiles a tag pattern, matched against the whole tag
+func CompileTagPattern(value string) (*regexp.Regexp, error) {
+	pattern, err := regexp.Compile("^(?:" + value + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag pattern %q: %w", value, err)
+	}
+	return pattern, nil
+}
+
+// SignatureVerifier returns the verifier of the suggested images, nil when neither a key
+// nor a keyless identity is configured
+func (c *Config) SignatureVerifier() *signature.Verifier {
+	if c.CosignKey == "" && c.CosignIdentity == "" {
+		return nil
+	}
+	return signature.NewVerifier(c.CosignKey, c.CosignIdentity, c.CosignIssuer, c.CosignAttestation)
+}
+
+// RegistryMirrors parses the configured mirrors into a map of source prefix to target prefix
+func (c *Config) RegistryMirrors() (map[string]string, error) {
+	return parsePairs(c.Mirrors, "source=target")
+}
+
+// HostLimits parses the configured registry limits into a map of host to limit, * setting
+// the limit of the other hosts
+func (c *Config) HostLimits() (map[string]registry.HostLimit, error) {
+	pairs, err := parsePairs(c.RegistryLimits, "host=max[/interval]")
+	if err != nil {
+		return nil, err
+	}
+
+	limits := make(map[string]registry.HostLimit, len(pairs))
+	for host, value := range pairs {
+		limit, err := registry.ParseHostLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid limit for %s: %w", host, err)
+		}
+		limits[host] = limit
+	}
+	return limits, nil
+}
+
+// ConsumerRepositories parses the webhook consumers into a map of project path to the
+// repositories using its images. A project can be listed several times.
+func (c *Config) ConsumerRepositories() (map[string][]string, error) {
+	consumers := make(map[string][]string)
+	if strings.TrimSpace(c.WebhookConsumers) == "" {
+		return consumers, nil
+	}
+
+	for _, entry := range strings.Split(c.WebhookConsumers, ",") {
+		project, repo, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || project == "" || repo == "" {
+			return nil, fmt.Errorf("invalid entry: %q (expected project=repository)", entry)
+		}
+		if err := validation.ValidateURL(repo); err != nil {
+			return nil, fmt.Errorf("invalid repository of %s: %w", project, err)
+		}
+		project = strings.Trim(project, "/")
+		consumers[project] = append(consumers[project], repo)
+	}
+
+	return consumers, nil
+}
+
+// parsePairs parses comma-separated key=value pairs
+func parsePairs(value, format string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	if strings.TrimSpace(value) == "" {
+		return pairs, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || key == "" || val == "" {
+			return nil, fmt.Errorf("invalid entry: %q (expected %s)", entry, format)
+		}
+		pairs[key] = val
+	}
+
+	return pairs, nil
+}
+
 // GetScanPath returns the full path to the scan directory
 func (c *Config) GetScanPath() string {
 	if c.ScanDir == "" {
@@ -214,12 +1238,15 @@ var ComposeFilePatterns = struct {
 	Names      []string
 	Extensions []string
 }{
-	Names:      []string{"docker-compose", "compose"},
+	Names:      []string{"docker-compose", "compose", "docker-stack"},
 	Extensions: []string{".yml", ".yaml"},
 }
 
+// TerraformFileExtension is the extension of the Terraform files checked when enabled
+const TerraformFileExtension = ".tf"
+
 // DirectoriesToSkip contains directories to skip when scanning
-var DirectoriesToSkip = []string{".git", "node_modules", "vendor"}
+var DirectoriesToSkip = []string{".git", "node_modules", "vendor", ".terraform"}
 
 // FindComposeFiles finds all docker-compose files in the given directory
 func (c *Config) FindComposeFiles() ([]string, error) {
@@ -239,8 +1266,9 @@ func (c *Config) FindComposeFiles() ([]string, error) {
 
 	// Find all docker-compose files recursively
 	var composeFiles []string
-	err := c.walkDirectory(scanPath, func(path string, info os.FileInfo) bool {
-		if isComposeFile(info.Name()) {
+	err := c.walkDirectory(scanPath, func(path, relPath string, info os.FileInfo) bool {
+		if c.isComposeFile(relPath, info.Name()) || (c.GitOps && isYAMLFile(info.Name())) ||
+			(c.Terraform && strings.HasSuffix(info.Name(), TerraformFileExtension)) {
 			logger.Debug("Found compose file: %s", path)
 			composeFiles = append(composeFiles, path)
 			return true
@@ -257,30 +1285,165 @@ func (c *Config) FindComposeFiles() ([]string, error) {
 }
 
 // walkDirectory walks through a directory and applies a filter function to each file
-func (c *Config) walkDirectory(root string, filter func(path string, info os.FileInfo) bool) error {
-	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+// that is not excluded by the skipped directories, exclude patterns or .gitignore rules
+func (c *Config) walkDirectory(root string, filter func(path, relPath string, info os.FileInfo) bool) error {
+	matcher := &ignoreMatcher{excludes: slashPatterns(c.Exclude)}
+
+	return repofs.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, path)
 		if err != nil {
 			return err
 		}
+		relPath = filepath.ToSlash(relPath)
 
 		// Skip directories that should be ignored
 		if info.IsDir() {
-			for _, skipDir := range DirectoriesToSkip {
-				if info.Name() == skipDir {
-					return filepath.SkipDir
+			if relPath != "." && (c.isSkippedDir(info.Name()) || matcher.ignored(relPath, true)) {
+				logger.Debug("Skipping directory: %s", path)
+				return filepath.SkipDir
+			}
+			if c.Gitignore {
+				if err := matcher.loadGitignore(path, relPath); err != nil {
+					logger.Warn("Failed to read %s in %s: %v", GitignoreFile, path, err)
 				}
 			}
 			return nil
 		}
 
+		if matcher.ignored(relPath, false) {
+			logger.Debug("Skipping excluded file: %s", path)
+			return nil
+		}
+
 		// Apply filter to files
-		filter(path, info)
+		filter(path, relPath, info)
 		return nil
 	})
 }
 
-// isComposeFile returns true if the filename is a docker-compose file
-func isComposeFile(filename string) bool {
+// IsExcluded returns true if a file is outside of root or would be skipped when walking
+// root: in a skipped directory, matching an exclude pattern or ignored by a .gitignore
+// file of its directories
+func (c *Config) IsExcluded(root, file string) bool {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return true
+	}
+	file, err = filepath.Abs(file)
+	if err != nil {
+		return true
+	}
+	relPath, err := filepath.Rel(root, file)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return true
+	}
+	relPath = filepath.ToSlash(relPath)
+
+	matcher := &ignoreMatcher{excludes: slashPatterns(c.Exclude)}
+	dir, relDir := root, "."
+	dirs := strings.Split(relPath, "/")
+	for i, name := range dirs {
+		if c.Gitignore {
+			if err := matcher.loadGitignore(dir, relDir); err != nil {
+				logger.Warn("Failed to read %s in %s: %v", GitignoreFile, dir, err)
+			}
+		}
+		if i == len(dirs)-1 {
+			break
+		}
+
+		dir, relDir = filepath.Join(dir, name), path.Join(relDir, name)
+		if c.isSkippedDir(name) || matcher.ignored(relDir, true) {
+			return true
+		}
+	}
+
+	return matcher.ignored(relPath, false)
+}
+
+// IsRepositoryFile returns true if a file of the repository, given by its slash-separated
+// path relative to the repository root, may be read by a scan: YAML files, including the
+// repository configuration, Terraform files and ignore rules outside of skipped directories.
+// It is used to select the files to download when the repository is not cloned.
+func (c *Config) IsRepositoryFile(relPath string) bool {
+	dirs := strings.Split(relPath, "/")
+	filename := dirs[len(dirs)-1]
+	for _, dir := range dirs[:len(dirs)-1] {
+		if c.isSkippedDir(dir) {
+			return false
+		}
+	}
+
+	return isYAMLFile(filename) || strings.HasSuffix(filename, TerraformFileExtension) ||
+		filename == GitignoreFile
+}
+
+// slashPatterns returns patterns with slash separators, as they are matched against
+// slash-separated paths whatever the separator used on the command line
+func slashPatterns(patterns []string) []string {
+	result := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		result = append(result, filepath.ToSlash(pattern))
+	}
+	return result
+}
+
+// isSkippedDir returns true if a directory name is skipped by default or by configuration
+func (c *Config) isSkippedDir(name string) bool {
+	for _, skipDir := range DirectoriesToSkip {
+		if name == skipDir {
+			return true
+		}
+	}
+	for _, skipDir := range c.SkipDirs {
+		if name == skipDir {
+			return true
+		}
+	}
+	return false
+}
+
+// isComposeFile returns true if the file is a compose file. When compose patterns are
+// configured they replace the default name matching: patterns containing a slash are
+// matched against the path relative to the scan directory, others against the file name.
+func (c *Config) isComposeFile(relPath, filename string) bool {
+	if len(c.ComposePatterns) == 0 {
+		return isDefaultComposeFile(filename)
+	}
+
+	for _, pattern := range slashPatterns(c.ComposePatterns) {
+		if strings.Contains(pattern, "/") {
+			if matchGlob(pattern, relPath) {
+				return true
+			}
+		} else if matched, err := path.Match(pattern, filename); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IsComposeFile returns true if a file found in the scan directory matches the compose file patterns
+func (c *Config) IsComposeFile(path string) bool {
+	return c.isComposeFile(c.GetRelativePath(path), filepath.Base(path))
+}
+
+// isYAMLFile returns true if the filename has a YAML extension
+func isYAMLFile(filename string) bool {
+	for _, ext := range ComposeFilePatterns.Extensions {
+		if strings.HasSuffix(filename, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDefaultComposeFile returns true if the filename is a docker-compose file
+func isDefaultComposeFile(filename string) bool {
 	// Check if the filename contains any of the compose patterns
 	hasComposeInName := false
 	for _, pattern := range ComposeFilePatterns.Names {
@@ -303,7 +1466,8 @@ func isComposeFile(filename string) bool {
 	return hasComposeInName && hasYamlExtension
 }
 
-// GetRelativePath returns a path relative to the scan directory
+// GetRelativePath returns a path relative to the scan directory. The path is
+// slash-separated on every platform so it can be used in merge requests and plans.
 func (c *Config) GetRelativePath(path string) string {
 	if c.ScanDir == "" {
 		return path
@@ -319,7 +1483,7 @@ func (c *Config) GetRelativePath(path string) string {
 		return path
 	}
 
-	return relPath
+	return filepath.ToSlash(relPath)
 }
 
 // ConfigureLogger configures the logger based on the current settings
@@ -338,6 +1502,9 @@ func (c *Config) ConfigureLogger() {
 	// Configure the logger
 	logger.SetLevel(logLevel)
 	logger.SetQuiet(c.Quiet)
+	if c.NoColor {
+		logger.DisableColors()
+	}
 
 	// Log the configuration if not in quiet mode
 	if !c.Quiet {
@@ -345,6 +1512,47 @@ func (c *Config) ConfigureLogger() {
 	}
 }
 
+// OpenLogFile opens the rotated log file and writes the logs to it, nil if no log file is set
+func (c *Config) OpenLogFile() (*logger.RotatingFile, error) {
+	if c.LogFile == "" {
+		return nil, nil
+	}
+	if c.LogMaxSize < 0 || c.LogBackups < 0 {
+		return nil, fmt.Errorf("log file size and backups must not be negative")
+	}
+
+	file, err := logger.OpenRotatingFile(c.LogFile, int64(c.LogMaxSize)*1024*1024, c.LogBackups)
+	if err != nil {
+		return nil, err
+	}
+	logger.SetFile(file, c.LogFileOnly)
+	return file, nil
+}
+
+// Clone returns a copy of the configuration whose slices and maps are not shared with c,
+// so that concurrent scans can each change their own
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Constraints = maps.Clone(c.Constraints)
+	clone.Channels = maps.Clone(c.Channels)
+	clone.Tracks = maps.Clone(c.Tracks)
+	clone.LTSReleases = maps.Clone(c.LTSReleases)
+	clone.ChangelogSources = maps.Clone(c.ChangelogSources)
+	if c.ExcludeTags != nil {
+		clone.ExcludeTags = make(map[string][]string, len(c.ExcludeTags))
+		for repo, patterns := range c.ExcludeTags {
+			clone.ExcludeTags[repo] = slices.Clone(patterns)
+		}
+	}
+	clone.Policies = slices.Clone(c.Policies)
+	clone.ComposePatterns = slices.Clone(c.ComposePatterns)
+	clone.Exclude = slices.Clone(c.Exclude)
+	clone.SkipDirs = slices.Clone(c.SkipDirs)
+	clone.CoAuthors = slices.Clone(c.CoAuthors)
+	clone.secretErrors = slices.Clone(c.secretErrors)
+	return &clone
+}
+
 // String returns a string representation of the configuration
 func (c *Config) String() string {
 	return fmt.Sprintf(
@@ -370,3 +1578,21 @@ func (c *Config) ValidateAll() error {
 
 	return nil
 }
+
+// ParseIdentity parses a commit identity written as "Name <email>"
+func ParseIdentity(value string) (name, email string, err error) {
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Name == "" {
+		return "", "", fmt.Errorf("invalid identity %q, expected \"Name <email>\"", value)
+	}
+	return address.Name, address.Address, nil
+}
+
+// Author returns the name and email commits are authored with: the commit author when
+// set, otherwise the GitLab user
+func (c *Config) Author() (name, email string) {
+	if name, email, err := ParseIdentity(c.CommitAuthor); err == nil {
+		return name, email
+	}
+	return c.GitLabUser, c.GitLabEmail
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGetRelativePath(t *testing.T) {
+	root := t.TempDir()
+	cfg := New()
+	cfg.ScanDir = root
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{filepath.Join(root, "docker-compose.yml"), "docker-compose.yml"},
+		{filepath.Join(root, "apps", "web", "compose.yaml"), "apps/web/compose.yaml"},
+	}
+	for _, tt := range tests {
+		if got := cfg.GetRelativePath(tt.path); got != tt.want {
+			t.Errorf("GetRelativePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestIsRepositoryFile(t *testing.T) {
+	cfg := New()
+	cfg.SkipDirs = []string{"third_party"}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"docker-compose.yml", true},
+		{"deploy/main.tf", true},
+		{"apps/.gitignore", true},
+		{".img-upgr.yml", true},
+		{"README.md", false},
+		{"node_modules/pkg/compose.yml", false},
+		{"third_party/app/compose.yaml", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.IsRepositoryFile(tt.path); got != tt.want {
+			t.Errorf("IsRepositoryFile(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestConsumerRepositories(t *testing.T) {
+	cfg := New()
+	cfg.WebhookConsumers = "group/api=https://gitlab.example.com/ops/deploy, /group/api/=https://gitlab.example.com/ops/staging,group/web=https://gitlab.example.com/ops/deploy"
+
+	consumers, err := cfg.ConsumerRepositories()
+	if err != nil {
+		t.Fatalf("ConsumerRepositories() error = %v", err)
+	}
+	if got := consumers["group/api"]; len(got) != 2 || got[1] != "https://gitlab.example.com/ops/staging" {
+		t.Errorf("consumers of group/api = %v", got)
+	}
+	if got := consumers["group/web"]; len(got) != 1 {
+		t.Errorf("consumers of group/web = %v", got)
+	}
+
+	cfg.WebhookConsumers = "group/api"
+	if _, err := cfg.ConsumerRepositories(); err == nil {
+		t.Error("ConsumerRepositories() accepted an entry without repository")
+	}
+}
+
+func TestUseProjectToken(t *testing.T) {
+	tests := []struct {
+		repo string
+		want string
+	}{
+		{"https://gitlab.example.com/group/api.git", "api-token"},
+		{"https://gitlab.example.com/Group/Web", "group-token"},
+		{"https://gitlab.example.com/group/sub/app.git", "sub-token"},
+		{"https://gitlab.example.com/other/app.git", "global-token"},
+		{"https://gitlab.example.com/groupware/app.git", "global-token"},
+	}
+	for _, tt := range tests {
+		cfg := New()
+		cfg.GitLabToken = "global-token"
+		cfg.GitLabProjectTokens = "group=group-token, group/api=api-token,/group/sub/=sub-token"
+		cfg.GitLabRepo = tt.repo
+
+		if err := cfg.UseProjectToken(); err != nil {
+			t.Fatalf("UseProjectToken() error = %v", err)
+		}
+		if cfg.GitLabToken != tt.want {
+			t.Errorf("token of %s = %q, want %q", tt.repo, cfg.GitLabToken, tt.want)
+		}
+	}
+
+	cfg := New()
+	cfg.GitLabProjectTokens = "group/api"
+	if err := cfg.UseProjectToken(); err == nil {
+		t.Error("UseProjectToken() accepted an entry without token")
+	}
+}
+
+func TestAuthor(t *testing.T) {
+	cfg := New()
+	cfg.GitLabUser = "deploy-token"
+	cfg.GitLabEmail = "deploy@example.com"
+
+	if name, email := cfg.Author(); name != "deploy-token" || email != "deploy@example.com" {
+		t.Errorf("Author() = %s, %s, want the GitLab user", name, email)
+	}
+
+	cfg.CommitAuthor = "img-upgr bot <bot@example.com>"
+	if name, email := cfg.Author(); name != "img-upgr bot" || email != "bot@example.com" {
+		t.Errorf("Author() = %s, %s, want the commit author", name, email)
+	}
+
+	for _, invalid := range []string{"bot@example.com", "img-upgr bot", "img-upgr bot <bot>"} {
+		if _, _, err := ParseIdentity(invalid); err == nil {
+			t.Errorf("ParseIdentity(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	cfg := New()
+	cfg.Exclude = []string{"vendor/*"}
+	cfg.Constraints = map[string]string{"web": "~1.25"}
+	cfg.ExcludeTags = map[string][]string{"nginx": {"-rc"}}
+
+	clone := cfg.Clone()
+	clone.Exclude[0] = "test/*"
+	clone.Constraints["web"] = "~1.27"
+	clone.ExcludeTags["nginx"][0] = "-beta"
+
+	if cfg.Exclude[0] != "vendor/*" || cfg.Constraints["web"] != "~1.25" || cfg.ExcludeTags["nginx"][0] != "-rc" {
+		t.Errorf("changing the clone changed the configuration: %v %v %v", cfg.Exclude, cfg.Constraints, cfg.ExcludeTags)
+	}
+}
+
+func TestIsComposeFilePatterns(t *testing.T) {
+	cfg := New()
+	if !cfg.isComposeFile("app/docker-compose.yml", "docker-compose.yml") {
+		t.Error("default patterns should match docker-compose.yml")
+	}
+	if cfg.isComposeFile("stack.yml", "stack.yml") {
+		t.Error("default patterns should not match stack.yml")
+	}
+
+	cfg.ComposePatterns = []string{"stack.yml", "deploy/*.yaml"}
+	tests := []struct {
+		relPath string
+		want    bool
+	}{
+		{"stack.yml", true},
+		{"app/stack.yml", true},
+		{"deploy/production.yaml", true},
+		{"app/deploy/production.yaml", false},
+		{"docker-compose.yml", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.isComposeFile(tt.relPath, filepath.Base(tt.relPath)); got != tt.want {
+			t.Errorf("isComposeFile(%q) = %v, want %v", tt.relPath, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// ProfilesKey is the key of the configuration file holding the named profiles
+const ProfilesKey = "profiles"
+
+// ConfigFileName is the name of the configuration file of the CLI below the user configuration directory
+const ConfigFileName = "img-upgr/config.yaml"
+
+var (
+	// fileValues holds the settings of the configuration file by environment variable name,
+	// used when the variable is not set
+	fileValues map[string]string
+	// usedKeys records the settings looked up, to report unknown keys of the file
+	usedKeys = make(map[string]bool)
+	fileMu   sync.Mutex
+)
+
+// DefaultConfigFile returns the path of the configuration file in the user configuration
+// directory, such as ~/.config/img-upgr/config.yaml
+func DefaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, ConfigFileName)
+}
+
+// LoadFile reads the settings of a configuration file, consulted for every setting whose
+// environment variable is not set so that flags override the environment, which overrides
+// the file. Keys are the names of the environment variables, with or without their prefix
+// and in any case, such as gl_repo or mr_limit. Lists are joined with commas and maps
+// written as comma-separated key=value pairs, like registries: {harbor.example.com: harbor}.
+//
+// The settings of the named profile, when not empty, are read from the profiles mapping
+// of the file and override the top-level ones, so one file serves several GitLab
+// instances or environments.
+func LoadFile(path, profile string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("failed to parse configuration file %s: %w", path, err)
+	}
+
+	profiles, ok := settings[ProfilesKey].(map[string]interface{})
+	if !ok && settings[ProfilesKey] != nil {
+		return fmt.Errorf("invalid %s in %s: expected a mapping of profile names to settings", ProfilesKey, path)
+	}
+	delete(settings, ProfilesKey)
+
+	values, err := fileSettings(settings, path)
+	if err != nil {
+		return err
+	}
+	if profile != "" {
+		profileSettings, ok := profiles[profile].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("profile %q not found in %s", profile, path)
+		}
+		profileValues, err := fileSettings(profileSettings, path)
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", profile, err)
+		}
+		maps.Copy(values, profileValues)
+		logger.Debug("Using profile %s of %s", profile, path)
+	}
+
+	// Credentials in the file must not be readable by other users
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o077 != 0 && (values[EnvGitLabToken] != "" || values[EnvProjectTokens] != "") {
+		logger.Warn("Configuration file %s holds a token but is readable by other users, restrict it with chmod 600", path)
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	fileValues = values
+	logger.Debug("Loaded %d settings from %s", len(values), path)
+	return nil
+}
+
+// fileSettings returns the settings of a configuration file by environment variable name
+func fileSettings(settings map[string]interface{}, path string) (map[string]string, error) {
+	values := make(map[string]string, len(settings))
+	for key, value := range settings {
+		name := EnvPrefix + strings.TrimPrefix(strings.ToUpper(strings.ReplaceAll(key, "-", "_")), EnvPrefix)
+		formatted, err := formatFileValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s in %s: %w", key, path, err)
+		}
+		values[name] = formatted
+	}
+	return values, nil
+}
+
+// formatFileValue formats a value of the configuration file as an environment variable value
+func formatFileValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			formatted, err := formatFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, formatted)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, item := range v {
+			formatted, err := formatFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, key+"="+formatted)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	case string:
+		return v, nil
+	case bool, int, float64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// UnknownFileKeys returns the settings of the configuration file that no configuration
+// read, such as misspelled keys
+func UnknownFileKeys() []string {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	var unknown []string
+	for name := range fileValues {
+		if !usedKeys[name] {
+			unknown = append(unknown, strings.ToLower(strings.TrimPrefix(name, EnvPrefix)))
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// lookupEnv returns the value of an environment variable, falling back to the configuration file
+func lookupEnv(key string) string {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+	usedKeys[key] = true
+
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fileValues[key]
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadFile(t *testing.T) {
+	t.Cleanup(func() {
+		fileValues = nil
+		usedKeys = make(map[string]bool)
+	})
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `gl_repo: https://gitlab.example.com/group/project.git
+MR-LIMIT: 5
+log_level: debug
+registries:
+  quay.example.com: harbor
+  harbor.example.com: harbor
+webhook_consumers: [group/api=https://a, group/web=https://b]
+mr_limt: 3
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(path, ""); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+
+	t.Setenv(EnvLogLevel, "WARN")
+	cfg := New()
+	cfg.LoadFromEnv()
+
+	if cfg.GitLabRepo != "https://gitlab.example.com/group/project.git" {
+		t.Errorf("GitLabRepo = %q", cfg.GitLabRepo)
+	}
+	if cfg.MRLimit != 5 {
+		t.Errorf("MRLimit = %d, want 5", cfg.MRLimit)
+	}
+	if cfg.LogLevel != "WARN" {
+		t.Errorf("LogLevel = %q, the environment should override the file", cfg.LogLevel)
+	}
+	if cfg.Registries != "harbor.example.com=harbor,quay.example.com=harbor" {
+		t.Errorf("Registries = %q", cfg.Registries)
+	}
+	if cfg.WebhookConsumers != "group/api=https://a,group/web=https://b" {
+		t.Errorf("WebhookConsumers = %q", cfg.WebhookConsumers)
+	}
+	if unknown := UnknownFileKeys(); !reflect.DeepEqual(unknown, []string{"mr_limt"}) {
+		t.Errorf("UnknownFileKeys() = %v, want [mr_limt]", unknown)
+	}
+}
+
+func TestLoadFileProfile(t *testing.T) {
+	t.Cleanup(func() {
+		fileValues = nil
+		usedKeys = make(map[string]bool)
+	})
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `gl_repo: https://gitlab.example.com/ops/deploy.git
+mr_limit: 5
+profiles:
+  staging:
+    gl_repo: https://gitlab.staging.example.com/ops/deploy.git
+    gl_token: glpat-staging
+  production:
+    allow_major: false
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(path, "staging"); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+
+	cfg := New()
+	cfg.LoadFromEnv()
+	if cfg.GitLabRepo != "https://gitlab.staging.example.com/ops/deploy.git" || cfg.GitLabToken != "glpat-staging" {
+		t.Errorf("GitLabRepo, GitLabToken = %q, %q, want the settings of the profile", cfg.GitLabRepo, cfg.GitLabToken)
+	}
+	if cfg.MRLimit != 5 {
+		t.Errorf("MRLimit = %d, want the top-level setting", cfg.MRLimit)
+	}
+	if unknown := UnknownFileKeys(); len(unknown) != 0 {
+		t.Errorf("UnknownFileKeys() = %v, want profiles to be known", unknown)
+	}
+
+	if err := LoadFile(path, "qa"); err == nil {
+		t.Error("LoadFile() accepted an unknown profile")
+	}
+}
+
+func TestLoadFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("gl_repo: [unterminated\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadFile(path, ""); err == nil {
+		t.Error("LoadFile() accepted invalid YAML")
+	}
+	if err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml"), ""); err == nil {
+		t.Error("LoadFile() accepted a missing file")
+	}
+}
+
+func TestGetEnvSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("glpat-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvGitLabToken, "")
+	t.Setenv(EnvGitLabToken+SecretFileSuffix, path)
+	t.Setenv(EnvGitHubToken, "ghp-env")
+	t.Setenv(EnvGitHubToken+SecretFileSuffix, path)
+	t.Setenv(EnvServeToken+SecretFileSuffix, filepath.Join(t.TempDir(), "missing"))
+
+	cfg := New()
+	cfg.LoadFromEnv()
+
+	if cfg.GitLabToken != "glpat-file" {
+		t.Errorf("GitLabToken = %q, want glpat-file", cfg.GitLabToken)
+	}
+	if cfg.GitHubToken != "ghp-env" {
+		t.Errorf("GitHubToken = %q, the variable should override its file", cfg.GitHubToken)
+	}
+	if err := cfg.ResolveSecrets(context.Background()); err == nil {
+		t.Error("ResolveSecrets() accepted a missing secret file")
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+)
+
+const (
+	// FreezeFile is the name of the file freezing merge requests while it is present
+	// at the root of the target repository, its content being the reason
+	FreezeFile = ".img-upgr-freeze"
+
+	// freezeDateLayout is the layout of the dates of freeze windows
+	freezeDateLayout = "2006-01-02"
+)
+
+// FreezeWindow is a range of days during which no merge requests are created,
+// both days included
+type FreezeWindow struct {
+	From   string `yaml:"from"`
+	Until  string `yaml:"until"`
+	Reason string `yaml:"reason"`
+}
+
+// Validate checks the dates of the window
+func (w FreezeWindow) Validate() error {
+	from, err := time.Parse(freezeDateLayout, w.From)
+	if err != nil {
+		return fmt.Errorf("invalid from date %q, must be YYYY-MM-DD", w.From)
+	}
+	until, err := time.Parse(freezeDateLayout, w.Until)
+	if err != nil {
+		return fmt.Errorf("invalid until date %q, must be YYYY-MM-DD", w.Until)
+	}
+	if until.Before(from) {
+		return fmt.Errorf("until date %s is before from date %s", w.Until, w.From)
+	}
+	return nil
+}
+
+// Contains reports whether a time falls within the window, in the location of the time
+func (w FreezeWindow) Contains(now time.Time) bool {
+	from, err := time.ParseInLocation(freezeDateLayout, w.From, now.Location())
+	if err != nil {
+		return false
+	}
+	until, err := time.ParseInLocation(freezeDateLayout, w.Until, now.Location())
+	if err != nil {
+		return false
+	}
+	return !now.Before(from) && now.Before(until.AddDate(0, 0, 1))
+}
+
+// String describes the window
+func (w FreezeWindow) String() string {
+	description := fmt.Sprintf("freeze from %s until %s", w.From, w.Until)
+	if w.Reason != "" {
+		description += " (" + w.Reason + ")"
+	}
+	return description
+}
+
+// FreezeWindows parses the configured comma-separated from..until freeze windows
+func (c *Config) FreezeWindows() ([]FreezeWindow, error) {
+	var windows []FreezeWindow
+	for _, value := range strings.Split(c.Freeze, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		from, until, found := strings.Cut(value, "..")
+		if !found {
+			return nil, fmt.Errorf("invalid freeze window %q, must be from..until", value)
+		}
+		window := FreezeWindow{From: strings.TrimSpace(from), Until: strings.TrimSpace(until)}
+		if err := window.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid freeze window %q: %w", value, err)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// FreezeReason returns why merge requests are frozen at the given time: a freeze file
+// at the root of the repository in dir, or a freeze window of the environment or of
+// the repository configuration. It returns false when merge requests can be created.
+func FreezeReason(cfg *Config, repoConfig *RepoConfig, dir string, now time.Time) (string, bool) {
+	if dir != "" {
+		if data, err := repofs.ReadFile(filepath.Join(dir, FreezeFile)); err == nil {
+			reason := fmt.Sprintf("%s present in the repository", FreezeFile)
+			if content := strings.TrimSpace(string(data)); content != "" {
+				reason += " (" + content + ")"
+			}
+			return reason, true
+		}
+	}
+
+	// Windows were validated with the configuration
+	windows, _ := cfg.FreezeWindows()
+	if repoConfig != nil {
+		windows = append(windows, repoConfig.Freeze...)
+	}
+	for _, window := range windows {
+		if window.Contains(now) {
+			return window.String(), true
+		}
+	}
+	return "", false
+}
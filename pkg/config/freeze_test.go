@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFreezeWindows(t *testing.T) {
+	cfg := New()
+	cfg.Freeze = "2026-12-20..2027-01-05, 2026-06-01..2026-06-01"
+
+	windows, err := cfg.FreezeWindows()
+	if err != nil {
+		t.Fatalf("FreezeWindows() error = %v", err)
+	}
+	if len(windows) != 2 {
+		t.Fatalf("FreezeWindows() = %v, want 2 windows", windows)
+	}
+
+	for _, invalid := range []string{"2026-12-20", "2026-12-20..soon", "2027-01-05..2026-12-20"} {
+		cfg.Freeze = invalid
+		if _, err := cfg.FreezeWindows(); err == nil {
+			t.Errorf("FreezeWindows(%q) expected an error", invalid)
+		}
+	}
+}
+
+func TestFreezeReason(t *testing.T) {
+	cfg := New()
+	cfg.Freeze = "2026-12-20..2027-01-05"
+	repoConfig := &RepoConfig{Freeze: []FreezeWindow{{From: "2026-06-01", Until: "2026-06-01", Reason: "migration"}}}
+
+	testCases := []struct {
+		now    time.Time
+		frozen bool
+	}{
+		{now: time.Date(2026, 12, 19, 23, 59, 0, 0, time.UTC), frozen: false},
+		{now: time.Date(2026, 12, 20, 0, 0, 0, 0, time.UTC), frozen: true},
+		{now: time.Date(2027, 1, 5, 23, 59, 0, 0, time.UTC), frozen: true},
+		{now: time.Date(2027, 1, 6, 0, 0, 0, 0, time.UTC), frozen: false},
+		{now: time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC), frozen: true},
+	}
+
+	for _, tc := range testCases {
+		if _, frozen := FreezeReason(cfg, repoConfig, "", tc.now); frozen != tc.frozen {
+			t.Errorf("FreezeReason(%s) frozen = %v, want %v", tc.now, frozen, tc.frozen)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, FreezeFile), []byte("release week\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reason, frozen := FreezeReason(New(), nil, dir, time.Now())
+	if !frozen || reason != ".img-upgr-freeze present in the repository (release week)" {
+		t.Errorf("FreezeReason() with a freeze file = %q %v", reason, frozen)
+	}
+}
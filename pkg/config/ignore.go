@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+)
+
+// GitignoreFile is the name of the files holding git ignore rules
+const GitignoreFile = ".gitignore"
+
+// ignoreRule is a single pattern of a .gitignore file
+type ignoreRule struct {
+	pattern string
+	base    string
+	negate  bool
+	dirOnly bool
+}
+
+// matches reports whether the rule matches a slash-separated path relative to the scan root
+func (r ignoreRule) matches(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	// Rules only apply below the directory holding the .gitignore file
+	if r.base != "" {
+		if !strings.HasPrefix(relPath, r.base+"/") {
+			return false
+		}
+		relPath = strings.TrimPrefix(relPath, r.base+"/")
+	}
+
+	return matchGlob(r.pattern, relPath)
+}
+
+// ignoreMatcher holds the exclusion rules applied while walking the scan directory
+type ignoreMatcher struct {
+	excludes []string
+	rules    []ignoreRule
+}
+
+// ignored reports whether a slash-separated path relative to the scan root is excluded
+func (m *ignoreMatcher) ignored(relPath string, isDir bool) bool {
+	for _, pattern := range m.excludes {
+		if matchGlob(pattern, relPath) {
+			return true
+		}
+	}
+
+	// The last matching rule wins, negated rules re-include paths
+	ignored := false
+	for _, rule := range m.rules {
+		if rule.matches(relPath, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// loadGitignore adds the rules of the .gitignore file in dir, relPath being dir relative to the scan root
+func (m *ignoreMatcher) loadGitignore(dir, relPath string) error {
+	file, err := repofs.Open(filepath.Join(dir, GitignoreFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	logger.Debug("Loading ignore rules from %s", filepath.Join(dir, GitignoreFile))
+
+	base := relPath
+	if base == "." {
+		base = ""
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text(), base); ok {
+			m.rules = append(m.rules, rule)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseIgnoreRule parses a .gitignore line, returning false for blank lines and comments
+func parseIgnoreRule(line, base string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, `\`)
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	// Patterns without a slash match at any depth, others are anchored to the base
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+// matchGlob matches a slash-separated path against a glob pattern where "**"
+// matches any number of path segments, including none
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchSegments matches path segments against pattern segments
+func matchSegments(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			// Collapse consecutive wildcards and try every possible split
+			for len(patterns) > 0 && patterns[0] == "**" {
+				patterns = patterns[1:]
+			}
+			if len(patterns) == 0 {
+				return true
+			}
+			for i := range names {
+				if matchSegments(patterns, names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(names) == 0 {
+			return false
+		}
+		matched, err := path.Match(patterns[0], names[0])
+		if err != nil || !matched {
+			return false
+		}
+		patterns = patterns[1:]
+		names = names[1:]
+	}
+
+	return len(names) == 0
+}
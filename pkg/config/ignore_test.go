@@ -0,0 +1,160 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"**/test/**", "test/docker-compose.yml", true},
+		{"**/test/**", "app/test/docker-compose.yml", true},
+		{"**/test/**", "app/test", true},
+		{"**/test/**", "app/testing/docker-compose.yml", false},
+		{"deploy/*.yml", "deploy/compose.yml", true},
+		{"deploy/*.yml", "deploy/prod/compose.yml", false},
+		{"**/*.yaml", "compose.yaml", true},
+		{"compose.yml", "app/compose.yml", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	matcher := &ignoreMatcher{excludes: []string{"**/fixtures/**"}}
+	for _, line := range []string{"# comment", "build/", "*.local.yml", "!keep.local.yml", "/root-only.yml"} {
+		if rule, ok := parseIgnoreRule(line, ""); ok {
+			matcher.rules = append(matcher.rules, rule)
+		}
+	}
+	if rule, ok := parseIgnoreRule("tmp.yml", "nested"); ok {
+		matcher.rules = append(matcher.rules, rule)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"app/fixtures/compose.yml", false, true},
+		{"build", true, true},
+		{"build", false, false},
+		{"app/compose.local.yml", false, true},
+		{"app/keep.local.yml", false, false},
+		{"root-only.yml", false, true},
+		{"app/root-only.yml", false, false},
+		{"nested/deep/tmp.yml", false, true},
+		{"tmp.yml", false, false},
+		{"app/compose.yml", false, false},
+	}
+
+	for _, tt := range tests {
+		if got := matcher.ignored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestFindComposeFilesExcludes(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":                        "generated/\n",
+		"docker-compose.yml":                "",
+		"app/compose.yaml":                  "",
+		"generated/docker-compose.yml":      "",
+		"app/test/docker-compose.yml":       "",
+		"third_party/docker-compose.yml":    "",
+		"app/sub/.gitignore":                "compose.yml\n",
+		"app/sub/compose.yml":               "",
+		"node_modules/x/docker-compose.yml": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := New()
+	cfg.ScanDir = root
+	cfg.Exclude = []string{"**/test/**"}
+	cfg.SkipDirs = []string{"third_party"}
+
+	found, err := cfg.FindComposeFiles()
+	if err != nil {
+		t.Fatalf("FindComposeFiles() error = %v", err)
+	}
+
+	var got []string
+	for _, path := range found {
+		got = append(got, filepath.ToSlash(cfg.GetRelativePath(path)))
+	}
+	sort.Strings(got)
+
+	want := []string{"app/compose.yaml", "docker-compose.yml"}
+	if len(got) != len(want) {
+		t.Fatalf("FindComposeFiles() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("FindComposeFiles() = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":          "generated/\n",
+		"app/sub/.gitignore":  "local.yml\n",
+		"app/sub/local.yml":   "",
+		"app/sub/compose.yml": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := New()
+	cfg.Exclude = []string{"**/test/**"}
+	cfg.SkipDirs = []string{"third_party"}
+
+	// The same files are excluded as when walking the root
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"docker-compose.yml", false},
+		{"app/sub/compose.yml", false},
+		{"app/sub/local.yml", true},
+		{"generated/compose.yml", true},
+		{"app/test/compose.yml", true},
+		{"third_party/compose.yml", true},
+		{"node_modules/x/compose.yml", true},
+		{"../compose.yml", true},
+	}
+	for _, tt := range tests {
+		if got := cfg.IsExcluded(root, filepath.Join(root, filepath.FromSlash(tt.path))); got != tt.want {
+			t.Errorf("IsExcluded(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+)
+
+// CodeOwnersPaths are the locations of the CODEOWNERS file in a repository, in the
+// order GitLab looks for it
+var CodeOwnersPaths = []string{"CODEOWNERS", "docs/CODEOWNERS", ".gitlab/CODEOWNERS"}
+
+// ownerRule gives the paths matching a pattern to their owners
+type ownerRule struct {
+	pattern string
+	owners  []string
+}
+
+// CodeOwners holds the rules of a CODEOWNERS file by section. A nil CodeOwners owns nothing.
+type CodeOwners struct {
+	sections [][]ownerRule
+}
+
+// LoadCodeOwners reads the CODEOWNERS file of the repository in dir, returning nil if
+// the repository has none
+func LoadCodeOwners(dir string) (*CodeOwners, error) {
+	for _, name := range CodeOwnersPaths {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		file, err := repofs.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+
+		logger.Debug("Loading code owners from %s", path)
+		return ParseCodeOwners(file)
+	}
+	return nil, nil
+}
+
+// ParseCodeOwners parses a CODEOWNERS file. Owners are GitLab usernames and group paths
+// without their @; email addresses and roles are skipped, as merge requests cannot be
+// assigned to them.
+func ParseCodeOwners(r io.Reader) (*CodeOwners, error) {
+	codeOwners := &CodeOwners{sections: [][]ownerRule{nil}}
+	var sectionOwners []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Sections such as "^[Docs][2] @docs-team" start a new set of rules, with
+		// default owners for the entries that list none
+		if header := strings.TrimPrefix(line, "^"); strings.HasPrefix(header, "[") {
+			end := strings.LastIndex(header, "]")
+			if end == -1 {
+				continue
+			}
+			codeOwners.sections = append(codeOwners.sections, nil)
+			sectionOwners = parseOwners(strings.Fields(header[end+1:]))
+			continue
+		}
+
+		fields := strings.Fields(line)
+		rule := ownerRule{pattern: ownerPattern(fields[0]), owners: parseOwners(fields[1:])}
+		if len(rule.owners) == 0 {
+			rule.owners = sectionOwners
+		}
+		last := len(codeOwners.sections) - 1
+		codeOwners.sections[last] = append(codeOwners.sections[last], rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read code owners: %w", err)
+	}
+	return codeOwners, nil
+}
+
+// parseOwners returns the usernames and groups of the owners of a rule
+func parseOwners(fields []string) []string {
+	var owners []string
+	for _, field := range fields {
+		// Emails have no leading @ and roles such as @@developer two
+		if !strings.HasPrefix(field, "@") || strings.HasPrefix(field, "@@") {
+			continue
+		}
+		owners = append(owners, strings.TrimPrefix(field, "@"))
+	}
+	return owners
+}
+
+// ownerPattern converts a CODEOWNERS pattern to a glob relative to the repository root.
+// Patterns without a leading slash match at any depth and a trailing slash matches
+// everything below a directory.
+func ownerPattern(pattern string) string {
+	if strings.HasPrefix(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		pattern = "**/" + pattern
+	}
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return pattern
+}
+
+// Owners returns the owners of a slash-separated path relative to the repository root.
+// The last matching rule of each section applies, like GitLab does.
+func (c *CodeOwners) Owners(relPath string) []string {
+	if c == nil {
+		return nil
+	}
+
+	var owners []string
+	for _, rules := range c.sections {
+		var matched []string
+		for _, rule := range rules {
+			// A pattern naming a directory also matches the files below it
+			if matchGlob(rule.pattern, relPath) || matchGlob(rule.pattern+"/**", relPath) {
+				matched = rule.owners
+			}
+		}
+		for _, owner := range matched {
+			if !slices.Contains(owners, owner) {
+				owners = append(owners, owner)
+			}
+		}
+	}
+	return owners
+}
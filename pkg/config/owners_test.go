@@ -0,0 +1,68 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCodeOwners(t *testing.T) {
+	content := `# Fallback owners
+* @platform
+/deploy/ @ops-team
+*.yml @alice ops@example.com
+/deploy/prod/ @bob @@maintainer
+
+[Databases] @dba/core
+**/postgres/
+/deploy/redis/ @carol
+`
+	codeOwners, err := ParseCodeOwners(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseCodeOwners() error = %v", err)
+	}
+
+	tests := map[string][]string{
+		"README.md":                         {"platform"},
+		"deploy/docker-compose.yml":         {"alice"},
+		"deploy/staging/Dockerfile":         {"ops-team"},
+		"deploy/prod/docker-compose.yml":    {"bob"},
+		"apps/postgres/docker-compose.yml":  {"alice", "dba/core"},
+		"deploy/redis/compose.yaml":         {"ops-team", "carol"},
+		"deploy/prod/postgres/compose.yaml": {"bob", "dba/core"},
+	}
+	for path, want := range tests {
+		if got := codeOwners.Owners(path); !slices.Equal(got, want) {
+			t.Errorf("Owners(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestLoadCodeOwners(t *testing.T) {
+	dir := t.TempDir()
+
+	// A repository without CODEOWNERS owns nothing
+	codeOwners, err := LoadCodeOwners(dir)
+	if err != nil || codeOwners != nil {
+		t.Fatalf("LoadCodeOwners() = %v, %v, want nil", codeOwners, err)
+	}
+	if owners := codeOwners.Owners("docker-compose.yml"); owners != nil {
+		t.Errorf("Owners() without CODEOWNERS = %v, want none", owners)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, ".gitlab"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".gitlab", "CODEOWNERS"), []byte("* @team\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	codeOwners, err = LoadCodeOwners(dir)
+	if err != nil {
+		t.Fatalf("LoadCodeOwners() error = %v", err)
+	}
+	if owners := codeOwners.Owners("docker-compose.yml"); !slices.Equal(owners, []string{"team"}) {
+		t.Errorf("Owners() = %v, want [team]", owners)
+	}
+}
@@ -0,0 +1,289 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/policy"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/track"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfigFile is the name of the configuration file read from the root of the target repository
+const RepoConfigFile = ".img-upgr.yml"
+
+// AnyRepository sets the excluded tags of every image repository
+const AnyRepository = "*"
+
+// RepoConfig holds the settings a repository declares for img-upgr
+type RepoConfig struct {
+	// TargetBranches maps files to the branch their merge requests target
+	TargetBranches []TargetBranchRule `yaml:"target_branches"`
+
+	// ComposePatterns and Exclude are globs of the compose files to check and of
+	// the paths to skip, used when not set in the environment
+	ComposePatterns []string `yaml:"compose_patterns"`
+	Exclude         []string `yaml:"exclude"`
+
+	// Registries and Mirrors decide the hosts registry credentials are sent to, so they
+	// are only set in the environment. They are read to warn that they are ignored.
+	Registries map[string]string `yaml:"registries"`
+	Mirrors    map[string]string `yaml:"mirrors"`
+
+	// Constraints maps service names or image repositories to the version range
+	// their updates must stay in, such as "~1.25" or ">=15 <16"
+	Constraints map[string]string `yaml:"constraints"`
+
+	// ExcludeTags maps image repositories, or AnyRepository for all of them, to regular
+	// expressions of the tags never suggested, such as ".*-nightly"
+	ExcludeTags map[string][]string `yaml:"exclude_tags"`
+
+	// Channels maps image repositories, or AnyRepository for all of them, to a floating tag
+	// such as stable or lts. Only versions up to the one it points to are suggested.
+	Channels map[string]string `yaml:"channels"`
+
+	// Tracks maps image repositories, or AnyRepository for all of them, to the release
+	// track they follow: any, or lts for long-term support releases only. LTSReleases adds
+	// the LTS releases of images to the built-in ones, such as "even" or "8,11,17,21".
+	Tracks      map[string]string `yaml:"tracks"`
+	LTSReleases map[string]string `yaml:"lts_releases"`
+
+	// Sources maps image repositories to the GitHub or GitLab repository publishing
+	// their release notes
+	Sources map[string]string `yaml:"sources"`
+
+	// Freeze lists the windows during which no merge requests are created
+	Freeze []FreezeWindow `yaml:"freeze"`
+
+	// Policies set how merge requests are handled depending on the bump of their updates
+	Policies []policy.Rule `yaml:"policies"`
+
+	// Owners maps files to the GitLab users or groups owning them, used instead of the
+	// CODEOWNERS file of the repository
+	Owners []OwnerRule `yaml:"owners"`
+
+	// CommitAuthor and CoAuthors are the "Name <email>" identities the commits of the
+	// repository are authored and co-authored with, used when not set in the environment
+	CommitAuthor string   `yaml:"commit_author"`
+	CoAuthors    []string `yaml:"co_authors"`
+}
+
+// TargetBranchRule sends the updates of files matching Path to Branch. Path is a glob
+// relative to the repository root where ** matches any number of directories; a path
+// ending with a slash matches everything below that directory.
+type TargetBranchRule struct {
+	Path   string `yaml:"path"`
+	Branch string `yaml:"branch"`
+}
+
+// OwnerRule gives the files matching Path to Users, GitLab usernames or group paths.
+// Path is matched like the path of a TargetBranchRule.
+type OwnerRule struct {
+	Path  string   `yaml:"path"`
+	Users []string `yaml:"users"`
+}
+
+// LoadRepoConfig reads the repository configuration file in dir, returning an empty
+// configuration if the repository has none
+func LoadRepoConfig(dir string) (*RepoConfig, error) {
+	repoConfig := &RepoConfig{}
+
+	path := filepath.Join(dir, RepoConfigFile)
+	data, err := repofs.ReadFile(path)
+	if os.IsNotExist(err) {
+		return repoConfig, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", RepoConfigFile, err)
+	}
+
+	if err := yaml.Unmarshal(data, repoConfig); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", RepoConfigFile, err)
+	}
+
+	if err := repoConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", RepoConfigFile, err)
+	}
+	if len(repoConfig.Registries) > 0 || len(repoConfig.Mirrors) > 0 {
+		logger.Warn("Ignoring the registries and mirrors of %s, set them with %s and %s", RepoConfigFile, EnvRegistries, EnvMirrors)
+	}
+
+	logger.Debug("Loaded repository configuration from %s", path)
+	return repoConfig, nil
+}
+
+// Validate checks the repository configuration
+func (r *RepoConfig) Validate() error {
+	for i, rule := range r.TargetBranches {
+		if rule.Path == "" || rule.Branch == "" {
+			return fmt.Errorf("target_branches[%d]: path and branch are required", i)
+		}
+		if _, err := filepath.Match(rule.Path, ""); err != nil {
+			return fmt.Errorf("target_branches[%d]: invalid path pattern: %s", i, rule.Path)
+		}
+	}
+	for i, rule := range r.Owners {
+		if rule.Path == "" || len(rule.Users) == 0 {
+			return fmt.Errorf("owners[%d]: path and users are required", i)
+		}
+		if _, err := filepath.Match(rule.Path, ""); err != nil {
+			return fmt.Errorf("owners[%d]: invalid path pattern: %s", i, rule.Path)
+		}
+	}
+	for _, pattern := range slices.Concat(r.ComposePatterns, r.Exclude) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern: %s", pattern)
+		}
+	}
+	for name, constraint := range r.Constraints {
+		if _, err := semver.NewConstraint(constraint); err != nil {
+			return fmt.Errorf("constraints: invalid range %q for %s: %w", constraint, name, err)
+		}
+	}
+	for repo, patterns := range r.ExcludeTags {
+		for _, pattern := range patterns {
+			if _, err := CompileTagPattern(pattern); err != nil {
+				return fmt.Errorf("exclude_tags: %s: %w", repo, err)
+			}
+		}
+	}
+	for repo, channel := range r.Channels {
+		if channel == "" {
+			return fmt.Errorf("channels: empty tag for %s", repo)
+		}
+	}
+	for repo, value := range r.Tracks {
+		if !slices.Contains(track.ValidTracks, value) {
+			return fmt.Errorf("tracks: invalid track %q for %s, must be one of: %s",
+				value, repo, strings.Join(track.ValidTracks, ", "))
+		}
+	}
+	for repo, spec := range r.LTSReleases {
+		if _, err := track.Parse(spec); err != nil {
+			return fmt.Errorf("lts_releases: %s: %w", repo, err)
+		}
+	}
+	for i, window := range r.Freeze {
+		if err := window.Validate(); err != nil {
+			return fmt.Errorf("freeze[%d]: %w", i, err)
+		}
+	}
+	for i, rule := range r.Policies {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("policies[%d]: %w", i, err)
+		}
+	}
+	for image, source := range r.Sources {
+		if parsed, err := url.Parse(source); err != nil || parsed.Host == "" {
+			return fmt.Errorf("sources: invalid repository URL %q for %s", source, image)
+		}
+	}
+	for _, identity := range append([]string{r.CommitAuthor}, r.CoAuthors...) {
+		if identity == "" {
+			continue
+		}
+		if _, _, err := ParseIdentity(identity); err != nil {
+			return fmt.Errorf("commit authors: %w", err)
+		}
+	}
+	return nil
+}
+
+// ApplyTo copies the scan, update and commit settings into the configuration, keeping the
+// ones already set from the environment or flags
+func (r *RepoConfig) ApplyTo(cfg *Config) {
+	if len(cfg.ComposePatterns) == 0 {
+		cfg.ComposePatterns = r.ComposePatterns
+	}
+	if len(cfg.Exclude) == 0 {
+		cfg.Exclude = r.Exclude
+	}
+	if cfg.Constraints == nil {
+		cfg.Constraints = r.Constraints
+	}
+	if cfg.ExcludeTags == nil {
+		cfg.ExcludeTags = r.ExcludeTags
+	}
+	if cfg.Channels == nil {
+		cfg.Channels = r.Channels
+	}
+	if cfg.Tracks == nil {
+		cfg.Tracks = r.Tracks
+	}
+	if cfg.LTSReleases == nil {
+		cfg.LTSReleases = r.LTSReleases
+	}
+	if cfg.ChangelogSources == nil {
+		cfg.ChangelogSources = r.Sources
+	}
+	if cfg.Policies == nil {
+		cfg.Policies = r.Policies
+	}
+	if cfg.CommitAuthor == "" {
+		cfg.CommitAuthor = r.CommitAuthor
+	}
+	if cfg.CoAuthors == nil {
+		cfg.CoAuthors = r.CoAuthors
+	}
+}
+
+// formatPairs formats a map as comma-separated key=value pairs sorted by key
+func formatPairs(values map[string]string) string {
+	pairs := make([]string, 0, len(values))
+	for key, value := range values {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// TargetBranch returns the branch of the first rule matching a slash-separated path
+// relative to the repository root
+func (r *RepoConfig) TargetBranch(relPath string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+
+	for _, rule := range r.TargetBranches {
+		if matchPathRule(rule.Path, relPath) {
+			return rule.Branch, true
+		}
+	}
+	return "", false
+}
+
+// FileOwners returns the owners of the first rule matching a slash-separated path
+// relative to the repository root
+func (r *RepoConfig) FileOwners(relPath string) ([]string, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	for _, rule := range r.Owners {
+		if matchPathRule(rule.Path, relPath) {
+			owners := make([]string, 0, len(rule.Users))
+			for _, user := range rule.Users {
+				owners = append(owners, strings.TrimPrefix(user, "@"))
+			}
+			return owners, true
+		}
+	}
+	return nil, false
+}
+
+// matchPathRule matches a repository path against a rule path
+func matchPathRule(pattern, relPath string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return matchGlob(pattern, relPath)
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRepoConfigTargetBranch(t *testing.T) {
+	dir := t.TempDir()
+	content := `target_branches:
+  - path: prod/
+    branch: release
+  - path: "**/dev/*.yml"
+    branch: develop
+  - path: "**"
+    branch: main
+`
+	if err := os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repoConfig, err := LoadRepoConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadRepoConfig() error = %v", err)
+	}
+
+	tests := map[string]string{
+		"prod/docker-compose.yml":     "release",
+		"prod/db/compose.yml":         "release",
+		"apps/dev/docker-compose.yml": "develop",
+		"docker-compose.yml":          "main",
+	}
+	for path, want := range tests {
+		if got, ok := repoConfig.TargetBranch(path); !ok || got != want {
+			t.Errorf("TargetBranch(%q) = %q, %v, want %q", path, got, ok, want)
+		}
+	}
+
+	// A repository without configuration file has no rules
+	empty, err := LoadRepoConfig(t.TempDir())
+	if err != nil {
+		t.Fatalf("LoadRepoConfig() error = %v", err)
+	}
+	if _, ok := empty.TargetBranch("prod/docker-compose.yml"); ok {
+		t.Error("TargetBranch() without rules should not match")
+	}
+}
+
+func TestRepoConfigFileOwners(t *testing.T) {
+	dir := t.TempDir()
+	content := `owners:
+  - path: prod/
+    users: ["@ops-team", alice]
+  - path: "**"
+    users: [bob]
+`
+	if err := os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repoConfig, err := LoadRepoConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadRepoConfig() error = %v", err)
+	}
+	if owners, ok := repoConfig.FileOwners("prod/docker-compose.yml"); !ok || !slices.Equal(owners, []string{"ops-team", "alice"}) {
+		t.Errorf("FileOwners() = %v, %v, want [ops-team alice]", owners, ok)
+	}
+	if owners, ok := repoConfig.FileOwners("dev/docker-compose.yml"); !ok || !slices.Equal(owners, []string{"bob"}) {
+		t.Errorf("FileOwners() = %v, %v, want [bob]", owners, ok)
+	}
+
+	// Rules need users
+	if err := os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte("owners:\n  - path: prod/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRepoConfig(dir); err == nil {
+		t.Error("LoadRepoConfig() should reject owners without users")
+	}
+}
+
+func TestRepoConfigApplyTo(t *testing.T) {
+	dir := t.TempDir()
+	content := `compose_patterns:
+  - "deploy/**/*.yml"
+exclude:
+  - legacy/
+registries:
+  harbor.example.com: harbor
+  artifactory.example.com: artifactory
+mirrors:
+  docker.io: mirror.example.com/hub
+policies:
+  - bump: patch
+    auto_merge: true
+  - bump: major
+    draft: true
+    labels: [needs-review]
+commit_author: img-upgr bot <bot@example.com>
+co_authors:
+  - Jane Doe <jane@example.com>
+`
+	if err := os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repoConfig, err := LoadRepoConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadRepoConfig() error = %v", err)
+	}
+
+	cfg := New()
+	cfg.Exclude = []string{"archive/"}
+	repoConfig.ApplyTo(cfg)
+
+	if len(cfg.ComposePatterns) != 1 || cfg.ComposePatterns[0] != "deploy/**/*.yml" {
+		t.Errorf("ComposePatterns = %v, want the repository patterns", cfg.ComposePatterns)
+	}
+	if len(cfg.Exclude) != 1 || cfg.Exclude[0] != "archive/" {
+		t.Errorf("Exclude = %v, want the environment value to be kept", cfg.Exclude)
+	}
+	// Registries and mirrors decide where credentials are sent and are never taken from the repository
+	if cfg.Registries != "" || cfg.Mirrors != "" {
+		t.Errorf("Registries = %q, Mirrors = %q, want them to be ignored", cfg.Registries, cfg.Mirrors)
+	}
+	if len(cfg.Policies) != 2 || !cfg.Policies[0].AutoMerge || cfg.Policies[1].Labels[0] != "needs-review" {
+		t.Errorf("Policies = %+v, want the repository rules", cfg.Policies)
+	}
+	if name, email := cfg.Author(); name != "img-upgr bot" || email != "bot@example.com" {
+		t.Errorf("Author() = %s, %s, want the repository author", name, email)
+	}
+	if len(cfg.CoAuthors) != 1 || cfg.CoAuthors[0] != "Jane Doe <jane@example.com>" {
+		t.Errorf("CoAuthors = %v, want the repository co-authors", cfg.CoAuthors)
+	}
+
+	invalid := &RepoConfig{CoAuthors: []string{"jane@example.com"}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Validate() should reject a co-author without name")
+	}
+}
+
+func TestExcludedTags(t *testing.T) {
+	cfg := New()
+	cfg.ExcludeTags = map[string][]string{
+		AnyRepository:                   {".*-nightly"},
+		"docker.io/library/postgres":    {`.*\.beta\d+`},
+		"registry.example.com/team/app": {"dev-.*"},
+	}
+
+	patterns, err := cfg.ExcludedTags("postgres")
+	if err != nil {
+		t.Fatalf("ExcludedTags() error = %v", err)
+	}
+	if len(patterns) != 2 {
+		t.Fatalf("ExcludedTags() = %v, want the patterns of postgres and of any repository", patterns)
+	}
+
+	excluded := func(tag string) bool {
+		for _, pattern := range patterns {
+			if pattern.MatchString(tag) {
+				return true
+			}
+		}
+		return false
+	}
+	for tag, want := range map[string]bool{"16.1-nightly": true, "16.0.beta2": true, "16.1": false, "16.1-nightly-fix": false} {
+		if got := excluded(tag); got != want {
+			t.Errorf("tag %s excluded = %t, want %t", tag, got, want)
+		}
+	}
+
+	invalid := &RepoConfig{ExcludeTags: map[string][]string{"nginx": {"(unclosed"}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Validate() should reject an invalid tag pattern")
+	}
+}
+
+func TestChannel(t *testing.T) {
+	cfg := New()
+	cfg.Channels = map[string]string{AnyRepository: "stable", "docker.io/library/node": "lts"}
+
+	if got := cfg.Channel("node"); got != "lts" {
+		t.Errorf("Channel(node) = %q, want lts", got)
+	}
+	if got := cfg.Channel("grafana/grafana"); got != "stable" {
+		t.Errorf("Channel(grafana/grafana) = %q, want stable", got)
+	}
+}
+
+func TestLTSRule(t *testing.T) {
+	cfg := New()
+	cfg.Tracks = map[string]string{AnyRepository: "lts", "registry.example.com/app": "lts", "mongo": "any"}
+
+	rule, err := cfg.LTSRule("node")
+	if err != nil || rule == nil {
+		t.Fatalf("LTSRule(node) = %v, %v, want the built-in rule", rule, err)
+	}
+	if rule, err := cfg.LTSRule("mongo"); err != nil || rule != nil {
+		t.Errorf("LTSRule(mongo) = %v, %v, want any version", rule, err)
+	}
+	if rule, err := cfg.LTSRule("registry.example.com/other"); err != nil || rule != nil {
+		t.Errorf("LTSRule() of an unknown image on the default track = %v, %v, want any version", rule, err)
+	}
+	if _, err := cfg.LTSRule("registry.example.com/app"); err == nil {
+		t.Error("LTSRule() should fail for an unknown image set to the LTS track")
+	}
+
+	cfg.LTSReleases = map[string]string{"registry.example.com/app": "2,4"}
+	if rule, err := cfg.LTSRule("registry.example.com/app"); err != nil || rule == nil {
+		t.Errorf("LTSRule() = %v, %v, want the user rule", rule, err)
+	}
+}
@@ -1,15 +1,22 @@
 package docker
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/trace"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/transport"
 )
 
 const (
@@ -21,13 +28,35 @@ const (
 
 	// DockerHubAPIBaseURL is the base URL for Docker Hub API
 	DockerHubAPIBaseURL = "https://hub.docker.com/v2/repositories"
+
+	// DockerHubRegistryURL is the base URL of the Docker Hub registry API
+	DockerHubRegistryURL = "https://registry-1.docker.io/v2"
+
+	// DockerHubAuthURL is the URL issuing the tokens of the Docker Hub registry API
+	DockerHubAuthURL = "https://auth.docker.io/token"
+
+	// DockerHubLoginURL is the URL issuing the JWTs of the Docker Hub API
+	DockerHubLoginURL = "https://hub.docker.com/v2/users/login"
 )
 
+// dockerHubHosts are the hosts Docker Hub credentials are stored for in the Docker CLI config
+var dockerHubHosts = []string{"docker.io", "index.docker.io"}
+
 // DockerHubTag represents a tag in Docker Hub
 type DockerHubTag struct {
-	Name        string    `json:"name"`
-	LastUpdated time.Time `json:"last_updated,omitempty"`
-	FullSize    int64     `json:"full_size,omitempty"`
+	Name        string           `json:"name"`
+	LastUpdated time.Time        `json:"last_updated,omitempty"`
+	FullSize    int64            `json:"full_size,omitempty"`
+	Digest      string           `json:"digest,omitempty"`
+	Images      []DockerHubImage `json:"images,omitempty"`
+}
+
+// DockerHubImage represents the image of a platform published for a tag
+type DockerHubImage struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+	Size         int64  `json:"size"`
 }
 
 // DockerHubResponse represents the response from Docker Hub API
@@ -54,21 +83,53 @@ func WithPageSize(pageSize int) ClientOption {
 	}
 }
 
+// WithMaxTags stops listing the tags of a repository after the given number of the most
+// recently updated ones, zero listing all of them
+func WithMaxTags(maxTags int) ClientOption {
+	return func(c *Client) {
+		c.maxTags = maxTags
+	}
+}
+
+// WithCredentials authenticates the requests with a Docker Hub username and password or
+// personal access token, instead of the Docker CLI credentials of Docker Hub
+func WithCredentials(username, token string) ClientOption {
+	return func(c *Client) {
+		c.credentials = &registry.Credentials{Username: username, Password: token}
+	}
+}
+
 // Client is a Docker Hub API client
 type Client struct {
-	httpClient *http.Client
-	pageSize   int
-	baseURL    string
+	httpClient  *http.Client
+	pageSize    int
+	maxTags     int
+	baseURL     string
+	registryURL string
+	authURL     string
+	loginURL    string
+	credentials *registry.Credentials
+	// etags keeps the pages of tag lists between runs, nil to download them every time
+	etags *registry.ETagCache
+
+	// jwt authenticates the Docker Hub API requests once logged in, loginOnce logging in
+	// on the first request
+	jwt       string
+	loginOnce sync.Once
 }
 
 // NewClient creates a new Docker Hub client with the given options
 func NewClient(options ...ClientOption) *Client {
 	client := &Client{
 		httpClient: &http.Client{
-			Timeout: DefaultTimeout,
+			Timeout:   DefaultTimeout,
+			Transport: trace.Transport("registry", transport.Shared()),
 		},
-		pageSize: DefaultPageSize,
-		baseURL:  DockerHubAPIBaseURL,
+		pageSize:    DefaultPageSize,
+		baseURL:     DockerHubAPIBaseURL,
+		registryURL: DockerHubRegistryURL,
+		authURL:     DockerHubAuthURL,
+		loginURL:    DockerHubLoginURL,
 	}
 
 	// Apply options
@@ -76,9 +137,84 @@ func NewClient(options ...ClientOption) *Client {
 		option(client)
 	}
 
+	// Fall back to the Docker Hub credentials of the Docker CLI
+	for _, host := range dockerHubHosts {
+		if client.credentials == nil {
+			client.credentials = registry.LoadDockerCredentials(host)
+		}
+	}
+
 	return client
 }
 
+// newHubRequest creates a request to the Docker Hub API, authenticated with a JWT when
+// credentials are set so the rate limit of the account applies and its private
+// repositories are visible. Failed logins are logged once and requests sent anonymously.
+func (c *Client) newHubRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	if c.credentials != nil {
+		c.loginOnce.Do(func() {
+			jwt, err := c.login(ctx)
+			if err != nil {
+				logger.Warn("Could not log in to Docker Hub as %s, sending anonymous requests: %v", c.credentials.Username, err)
+				return
+			}
+			c.jwt = jwt
+		})
+	}
+	if c.jwt != "" {
+		req.Header.Set("Authorization", "Bearer "+c.jwt)
+	}
+	return req, nil
+}
+
+// login exchanges the credentials for a JWT of the Docker Hub API
+func (c *Client) login(ctx context.Context) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"username": c.credentials.Username,
+		"password": c.credentials.Password,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error encoding credentials: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.loginURL, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	logger.Debug("Logging in to Docker Hub as %s", c.credentials.Username)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error logging in: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code logging in: %d", resp.StatusCode)
+	}
+
+	var parsed struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", fmt.Errorf("JSON parse error: %w", err)
+	}
+	if parsed.Token == "" {
+		return "", fmt.Errorf("no token in login response")
+	}
+	return parsed.Token, nil
+}
+
 // RepositoryInfo contains parsed information about a Docker repository
 type RepositoryInfo struct {
 	Namespace string
@@ -109,6 +245,11 @@ func ParseRepositoryName(repo string) RepositoryInfo {
 	}
 }
 
+// SetETagCache sets the cache of the pages of tag lists, sent again only when they changed
+func (c *Client) SetETagCache(cache *registry.ETagCache) {
+	c.etags = cache
+}
+
 // FetchAllTags fetches all tags for a repository
 func (c *Client) FetchAllTags(repo string) ([]string, error) {
 	return c.FetchAllTagsWithContext(context.Background(), repo)
@@ -116,12 +257,74 @@ func (c *Client) FetchAllTags(repo string) ([]string, error) {
 
 // FetchAllTagsWithContext fetches all tags for a repository with context
 func (c *Client) FetchAllTagsWithContext(ctx context.Context, repo string) ([]string, error) {
+	return c.FetchTagsUntilWithContext(ctx, repo, nil)
+}
+
+// FetchTagsUntilWithContext fetches the tags of a repository from the most recently
+// updated one until stop returns true for a page, or all of them if stop is nil
+func (c *Client) FetchTagsUntilWithContext(ctx context.Context, repo string, stop func(page []registry.TagInfo) bool) ([]string, error) {
+	results, err := c.fetchTagPages(ctx, repo, "", stop)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(results))
+	for _, tag := range results {
+		tags = append(tags, tag.Name)
+	}
+	return tags, nil
+}
+
+// FetchTagsWithPrefixWithContext fetches the tags of a repository starting with prefix.
+// Docker Hub only lists the tags containing the name filter, so fewer pages are fetched.
+func (c *Client) FetchTagsWithPrefixWithContext(ctx context.Context, repo, prefix string) ([]string, error) {
+	results, err := c.fetchTagPages(ctx, repo, prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	for _, tag := range results {
+		if strings.HasPrefix(tag.Name, prefix) {
+			tags = append(tags, tag.Name)
+		}
+	}
+	return tags, nil
+}
+
+// FetchTagInfo fetches all tags of a repository with their digest and last update, which
+// Docker Hub lists along with the tags
+func (c *Client) FetchTagInfo(repo string) ([]registry.TagInfo, error) {
+	results, err := c.fetchTagPages(context.Background(), repo, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return tagInfos(results), nil
+}
+
+// tagInfos converts Docker Hub tags to the tags of the registry package
+func tagInfos(results []DockerHubTag) []registry.TagInfo {
+	tags := make([]registry.TagInfo, 0, len(results))
+	for _, tag := range results {
+		tags = append(tags, registry.TagInfo{Name: tag.Name, Digest: tag.Digest, LastUpdated: tag.LastUpdated})
+	}
+	return tags
+}
+
+// fetchTagPages fetches the pages of the tags of a repository from the most recently
+// updated one, until stop returns true for a page or the maximum number of tags is reached.
+// Only the tags containing name are listed when it is set.
+func (c *Client) fetchTagPages(ctx context.Context, repo, name string, stop func(page []registry.TagInfo) bool) ([]DockerHubTag, error) {
 	repoInfo := ParseRepositoryName(repo)
-	url := fmt.Sprintf("%s/%s/%s/tags?page_size=%d", c.baseURL, repoInfo.Namespace, repoInfo.Name, c.pageSize)
+	query := fmt.Sprintf("page_size=%d&ordering=last_updated", c.pageSize)
+	if name != "" {
+		query += "&name=" + url.QueryEscape(name)
+	}
+	url := fmt.Sprintf("%s/%s/%s/tags?%s", c.baseURL, repoInfo.Namespace, repoInfo.Name, query)
 
 	logger.Debug("Fetching tags for %s/%s", repoInfo.Namespace, repoInfo.Name)
 
-	var tags []string
+	var tags []DockerHubTag
 	pageCount := 0
 
 	for url != "" {
@@ -135,30 +338,9 @@ func (c *Client) FetchAllTagsWithContext(ctx context.Context, repo string) ([]st
 		pageCount++
 		logger.Debug("Fetching page %d from %s", pageCount, url)
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-		if err != nil {
-			return nil, fmt.Errorf("error creating request: %w", err)
-		}
-
-		resp, err := c.httpClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("error fetching tags: %w", err)
-		}
-
-		// Check response status
-		if resp.StatusCode != http.StatusOK {
-			if err := resp.Body.Close(); err != nil {
-				logger.Warn("Failed to close response body: %v", err)
-			}
-			return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-		}
-
-		body, err := io.ReadAll(resp.Body)
-		if err := resp.Body.Close(); err != nil {
-			logger.Warn("Failed to close response body: %v", err)
-		}
+		body, err := c.fetchTagPage(ctx, url)
 		if err != nil {
-			return nil, fmt.Errorf("error reading response: %w", err)
+			return nil, err
 		}
 
 		var parsed DockerHubResponse
@@ -166,20 +348,77 @@ func (c *Client) FetchAllTagsWithContext(ctx context.Context, repo string) ([]st
 			return nil, fmt.Errorf("JSON parse error: %w", err)
 		}
 
-		for _, tag := range parsed.Results {
-			tags = append(tags, tag.Name)
-		}
+		tags = append(tags, parsed.Results...)
 		url = parsed.Next
 		logger.Debug("Fetched %d tags so far", len(tags))
+
+		if c.maxTags > 0 && len(tags) >= c.maxTags {
+			if url != "" || len(tags) > c.maxTags {
+				logger.Info("Stopped listing the tags of %s at the %d most recently updated ones", repoInfo.FullName, c.maxTags)
+			}
+			tags = tags[:c.maxTags]
+			break
+		}
+		if url != "" && stop != nil && stop(tagInfos(parsed.Results)) {
+			logger.Debug("Stopped listing the tags of %s after %d pages", repoInfo.FullName, pageCount)
+			break
+		}
 	}
 
 	logger.Info("Found %d tags for %s", len(tags), repoInfo.FullName)
 	return tags, nil
 }
 
+// fetchTagPage fetches the body of a page of tags, sending the ETag of the cached page so
+// Docker Hub answers with a 304 when it did not change
+func (c *Client) fetchTagPage(ctx context.Context, url string) ([]byte, error) {
+	req, err := c.newHubRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	cached, found := c.etags.Page(url)
+	if found {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching tags: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	// Check response status
+	if resp.StatusCode == http.StatusNotModified && found {
+		logger.Debug("Tag page unchanged since the last run: %s", url)
+		c.etags.Store(url, cached)
+		return cached.Body, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		c.etags.Store(url, registry.CachedPage{ETag: etag, Body: body})
+	}
+	return body, nil
+}
+
 // FetchTagDetails fetches detailed information about a specific tag
 func (c *Client) FetchTagDetails(repo, tag string) (*DockerHubTag, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), c.httpClient.Timeout)
+	return c.FetchTagDetailsWithContext(context.Background(), repo, tag)
+}
+
+// FetchTagDetailsWithContext fetches detailed information about a specific tag with context
+func (c *Client) FetchTagDetailsWithContext(ctx context.Context, repo, tag string) (*DockerHubTag, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.httpClient.Timeout)
 	defer cancel()
 
 	repoInfo := ParseRepositoryName(repo)
@@ -187,9 +426,9 @@ func (c *Client) FetchTagDetails(repo, tag string) (*DockerHubTag, error) {
 
 	logger.Debug("Fetching details for tag %s in repository %s", tag, repoInfo.FullName)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	req, err := c.newHubRequest(ctx, url)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
+		return nil, err
 	}
 
 	resp, err := c.httpClient.Do(req)
@@ -223,3 +462,169 @@ func (c *Client) FetchTagDetails(repo, tag string) (*DockerHubTag, error) {
 
 	return &tagDetails, nil
 }
+
+// FetchTagDigest fetches the digest currently published for a tag
+func (c *Client) FetchTagDigest(repo, tag string) (string, error) {
+	return c.FetchTagDigestWithContext(context.Background(), repo, tag)
+}
+
+// FetchTagDigestWithContext fetches the digest currently published for a tag with context
+func (c *Client) FetchTagDigestWithContext(ctx context.Context, repo, tag string) (string, error) {
+	details, err := c.FetchTagDetailsWithContext(ctx, repo, tag)
+	if err != nil {
+		return "", err
+	}
+	return details.Digest, nil
+}
+
+// HeadManifest checks that the manifest of a tag can be pulled from the Docker Hub
+// registry, with a pull token issued for the repository
+func (c *Client) HeadManifest(repo, tag string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.httpClient.Timeout)
+	defer cancel()
+
+	repoInfo := ParseRepositoryName(repo)
+	token, err := c.pullToken(ctx, repoInfo.FullName)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/%s/manifests/%s", c.registryURL, repoInfo.FullName, tag)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Accept", registry.ManifestAcceptHeader)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	logger.Debug("Checking manifest of %s:%s", repoInfo.FullName, tag)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching manifest: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("manifest of %s:%s not found", repoInfo.FullName, tag)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// pullToken requests a token allowed to pull a repository, authenticated with the
+// credentials of Docker Hub when there are any
+func (c *Client) pullToken(ctx context.Context, repo string) (string, error) {
+	url := fmt.Sprintf("%s?service=registry.docker.io&scope=repository:%s:pull", c.authURL, repo)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("error creating request: %w", err)
+	}
+	if c.credentials != nil {
+		req.SetBasicAuth(c.credentials.Username, c.credentials.Password)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error requesting pull token: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code requesting pull token: %d", resp.StatusCode)
+	}
+
+	var parsed struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", fmt.Errorf("JSON parse error: %w", err)
+	}
+	return parsed.Token, nil
+}
+
+// FetchImageDetails fetches the size and platforms of the image of a tag
+func (c *Client) FetchImageDetails(repo, tag string) (*registry.ImageDetails, error) {
+	details, err := c.FetchTagDetails(repo, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	imageDetails := &registry.ImageDetails{Size: details.FullSize}
+	for _, image := range details.Images {
+		// Attestations are listed with an unknown platform
+		if image.OS == "unknown" {
+			continue
+		}
+		imageDetails.Platforms = append(imageDetails.Platforms, registry.Platform{
+			OS:           image.OS,
+			Architecture: image.Architecture,
+			Variant:      image.Variant,
+		})
+		if image.OS == "linux" && image.Architecture == "amd64" {
+			imageDetails.Size = image.Size
+		}
+	}
+	return imageDetails, nil
+}
+
+// TagURL returns the Docker Hub page of a tag, official images living under /_/
+func (c *Client) TagURL(repo, tag string) (string, error) {
+	info := ParseRepositoryName(repo)
+	if info.Namespace == "library" {
+		return fmt.Sprintf("https://hub.docker.com/_/%s/tags?name=%s", info.Name, url.QueryEscape(tag)), nil
+	}
+	return fmt.Sprintf("https://hub.docker.com/r/%s/%s/tags?name=%s", info.Namespace, info.Name, url.QueryEscape(tag)), nil
+}
+
+// RateLimit is the Docker Hub request quota reported for the caller
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// RateLimitWithContext sends a cheap request to Docker Hub and returns the rate limit it
+// reports, or nil if the response carries no rate limit headers
+func (c *Client) RateLimitWithContext(ctx context.Context) (*RateLimit, error) {
+	url := fmt.Sprintf("%s/library/alpine/tags?page_size=1", c.baseURL)
+	req, err := c.newHubRequest(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusTooManyRequests {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	limit, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return nil, nil
+	}
+	rateLimit := &RateLimit{Limit: limit}
+	rateLimit.Remaining, _ = strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		rateLimit.Reset = time.Unix(reset, 0)
+	}
+
+	return rateLimit, nil
+}
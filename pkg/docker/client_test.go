@@ -0,0 +1,156 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+)
+
+func TestClientLogsInWithCredentials(t *testing.T) {
+	var logins atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/users/login":
+			logins.Add(1)
+			var body map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["username"] != "bot" || body["password"] != "dckr_pat_x" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"token": "jwt"}`)
+		case "/v2/repositories/org/private/tags":
+			if r.Header.Get("Authorization") != "Bearer jwt" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"results": [{"name": "1.0.0"}, {"name": "1.1.0"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(WithCredentials("bot", "dckr_pat_x"))
+	client.baseURL = server.URL + "/v2/repositories"
+	client.loginURL = server.URL + "/v2/users/login"
+
+	for range 2 {
+		tags, err := client.FetchAllTags("org/private")
+		if err != nil || len(tags) != 2 {
+			t.Fatalf("FetchAllTags() = %v, %v, want the private tags", tags, err)
+		}
+	}
+	if got := logins.Load(); got != 1 {
+		t.Errorf("logged in %d times, want once", got)
+	}
+
+	// Failed logins fall back to anonymous requests
+	anonymous := NewClient(WithCredentials("bot", "wrong"))
+	anonymous.baseURL = server.URL + "/v2/repositories"
+	anonymous.loginURL = server.URL + "/v2/users/login"
+	if _, err := anonymous.FetchAllTags("org/private"); err == nil {
+		t.Error("FetchAllTags() without login should not see private repositories")
+	}
+}
+
+func TestFetchTagsLimits(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ordering") != "last_updated" {
+			t.Errorf("query = %s, want tags ordered by last update", r.URL.RawQuery)
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		next := fmt.Sprintf("%s%s?page_size=2&ordering=last_updated&page=%d", server.URL, r.URL.Path, page+1)
+		if page == 3 {
+			next = ""
+		}
+		_ = json.NewEncoder(w).Encode(DockerHubResponse{
+			Results: []DockerHubTag{{Name: fmt.Sprintf("1.%d.1", 4-page)}, {Name: fmt.Sprintf("1.%d.0", 4-page)}},
+			Next:    next,
+		})
+	}))
+	defer server.Close()
+
+	// Docker CLI credentials would log in to Docker Hub
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	client := NewClient(WithPageSize(2), WithMaxTags(3))
+	client.baseURL = server.URL
+	tags, err := client.FetchAllTags("library/nginx")
+	if err != nil || !slices.Equal(tags, []string{"1.3.1", "1.3.0", "1.2.1"}) {
+		t.Errorf("FetchAllTags() with a maximum = %v, %v, want the 3 most recent tags", tags, err)
+	}
+
+	client = NewClient(WithPageSize(2))
+	client.baseURL = server.URL
+	tags, err = client.FetchTagsUntilWithContext(context.Background(), "library/nginx", func(page []registry.TagInfo) bool {
+		return page[0].Name == "1.2.1"
+	})
+	if err != nil || len(tags) != 4 {
+		t.Errorf("FetchTagsUntilWithContext() = %v, %v, want the tags of the first 2 pages", tags, err)
+	}
+}
+
+func TestFetchTagsWithPrefix(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Docker Hub lists the tags containing the name
+		if r.URL.Query().Get("name") != "1.25." {
+			t.Errorf("query = %s, want tags named 1.25.", r.URL.RawQuery)
+		}
+		_ = json.NewEncoder(w).Encode(DockerHubResponse{
+			Results: []DockerHubTag{{Name: "1.25.4"}, {Name: "1.25.3"}, {Name: "mainline-1.25.4"}},
+		})
+	}))
+	defer server.Close()
+
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	client := NewClient()
+	client.baseURL = server.URL
+	tags, err := client.FetchTagsWithPrefixWithContext(context.Background(), "library/nginx", "1.25.")
+	if err != nil || !slices.Equal(tags, []string{"1.25.4", "1.25.3"}) {
+		t.Errorf("FetchTagsWithPrefixWithContext() = %v, %v, want the tags starting with 1.25.", tags, err)
+	}
+}
+
+func TestFetchTagsETagCache(t *testing.T) {
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `W/"tags"`)
+		if r.Header.Get("If-None-Match") == `W/"tags"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		_ = json.NewEncoder(w).Encode(DockerHubResponse{Results: []DockerHubTag{{Name: "1.25.4"}, {Name: "1.25.3"}}})
+	}))
+	defer server.Close()
+
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	cache := registry.LoadETagCache(filepath.Join(t.TempDir(), "tags.json"))
+	for range 2 {
+		client := NewClient()
+		client.baseURL = server.URL
+		client.SetETagCache(cache)
+		tags, err := client.FetchAllTags("library/nginx")
+		if err != nil || !slices.Equal(tags, []string{"1.25.4", "1.25.3"}) {
+			t.Errorf("FetchAllTags() = %v, %v", tags, err)
+		}
+	}
+	if downloads != 1 {
+		t.Errorf("tags downloaded %d times, want the unchanged page answered from the cache", downloads)
+	}
+}
@@ -0,0 +1,240 @@
+// Package eol tells whether the release series of an image has reached its end of
+// life, using the data published by endoflife.date.
+package eol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+)
+
+const (
+	// DefaultTimeout is the default timeout for HTTP requests
+	DefaultTimeout = 15 * time.Second
+
+	// APIBaseURL is the base URL of the endoflife.date API
+	APIBaseURL = "https://endoflife.date/api"
+
+	// dateLayout is the layout of the dates of the API
+	dateLayout = "2006-01-02"
+)
+
+// versionPattern extracts the version of an image tag such as 16.20.2-alpine or 3.11
+var versionPattern = regexp.MustCompile(`\d+(?:\.\d+)*`)
+
+// Products maps the official images to their endoflife.date product
+var Products = map[string]string{
+	"alpine":          "alpine",
+	"debian":          "debian",
+	"ubuntu":          "ubuntu",
+	"fedora":          "fedora",
+	"centos":          "centos",
+	"amazonlinux":     "amazon-linux",
+	"node":            "nodejs",
+	"python":          "python",
+	"golang":          "go",
+	"php":             "php",
+	"ruby":            "ruby",
+	"eclipse-temurin": "eclipse-temurin",
+	"postgres":        "postgresql",
+	"mysql":           "mysql",
+	"mariadb":         "mariadb",
+	"mongo":           "mongodb",
+	"redis":           "redis",
+	"nginx":           "nginx",
+	"haproxy":         "haproxy",
+	"rabbitmq":        "rabbitmq",
+	"elasticsearch":   "elasticsearch",
+	"traefik":         "traefik",
+	"tomcat":          "tomcat",
+}
+
+// Cycle is a release series of a product
+type Cycle struct {
+	Cycle    string
+	Codename string
+	// EOL is the end of life date of the series, zero if not announced
+	EOL time.Time
+	// Ended is true when the series reached its end of life
+	Ended bool
+}
+
+// cycleResponse is a release series as returned by the API, where eol is either
+// a boolean or a date
+type cycleResponse struct {
+	Cycle    json.RawMessage `json:"cycle"`
+	Codename string          `json:"codename"`
+	EOL      json.RawMessage `json:"eol"`
+}
+
+// Status is the end of life status of the series of an image tag
+type Status struct {
+	Product string
+	Cycle   Cycle
+	// Next is the oldest supported series newer than the current one, nil if none
+	Next *Cycle
+}
+
+// Product returns the endoflife.date product of an image repository
+func Product(repository string) (string, bool) {
+	product, ok := Products[reference.Familiarize(repository)]
+	return product, ok
+}
+
+// Client fetches the release series of products, caching them for the lifetime of the client
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	now        func() time.Time
+
+	mu     sync.Mutex
+	cycles map[string][]Cycle
+}
+
+// NewClient creates an endoflife.date client
+func NewClient() *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: DefaultTimeout},
+		baseURL:    APIBaseURL,
+		now:        time.Now,
+		cycles:     make(map[string][]Cycle),
+	}
+}
+
+// Check returns the status of the series of a tag, or nil when the tag matches
+// no series of the product
+func (c *Client) Check(ctx context.Context, product, tag string) (*Status, error) {
+	cycles, err := c.Cycles(ctx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	current := matchCycle(cycles, tag)
+	if current == nil {
+		return nil, nil
+	}
+
+	return &Status{Product: product, Cycle: *current, Next: nextSupported(cycles, *current)}, nil
+}
+
+// Cycles fetches the release series of a product, newest first
+func (c *Client) Cycles(ctx context.Context, product string) ([]Cycle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cycles, ok := c.cycles[product]; ok {
+		return cycles, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s.json", c.baseURL, product), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	logger.Debug("Fetching end of life data of %s", product)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching end of life data: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code fetching end of life data of %s: %d", product, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %w", err)
+	}
+
+	var parsed []cycleResponse
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil, fmt.Errorf("JSON parse error: %w", err)
+	}
+
+	now := c.now()
+	cycles := make([]Cycle, 0, len(parsed))
+	for _, r := range parsed {
+		cycle := Cycle{Cycle: strings.Trim(string(r.Cycle), `"`), Codename: r.Codename}
+
+		var ended bool
+		var date string
+		if err := json.Unmarshal(r.EOL, &ended); err == nil {
+			cycle.Ended = ended
+		} else if err := json.Unmarshal(r.EOL, &date); err == nil {
+			if eol, err := time.Parse(dateLayout, date); err == nil {
+				cycle.EOL = eol
+				cycle.Ended = !now.Before(eol)
+			}
+		}
+		cycles = append(cycles, cycle)
+	}
+
+	c.cycles[product] = cycles
+	return cycles, nil
+}
+
+// matchCycle returns the series of a tag: the longest series its version starts with,
+// or the series whose codename it contains such as debian:bookworm-slim
+func matchCycle(cycles []Cycle, tag string) *Cycle {
+	var match *Cycle
+	if version := versionPattern.FindString(tag); version != "" {
+		for i, cycle := range cycles {
+			if version != cycle.Cycle && !strings.HasPrefix(version, cycle.Cycle+".") {
+				continue
+			}
+			if match == nil || len(cycle.Cycle) > len(match.Cycle) {
+				match = &cycles[i]
+			}
+		}
+		if match != nil {
+			return match
+		}
+	}
+
+	lowerTag := strings.ToLower(tag)
+	for i, cycle := range cycles {
+		// Codenames such as "Jammy Jellyfish" are used by their first word in tags
+		codename, _, _ := strings.Cut(strings.ToLower(cycle.Codename), " ")
+		if codename != "" && strings.Contains(lowerTag, codename) {
+			return &cycles[i]
+		}
+	}
+	return nil
+}
+
+// nextSupported returns the oldest series newer than current that is still supported
+func nextSupported(cycles []Cycle, current Cycle) *Cycle {
+	currentVersion, err := semver.NewVersion(current.Cycle)
+	if err != nil {
+		return nil
+	}
+
+	var next *Cycle
+	var nextVersion *semver.Version
+	for i, cycle := range cycles {
+		version, err := semver.NewVersion(cycle.Cycle)
+		if err != nil || cycle.Ended || !version.GreaterThan(currentVersion) {
+			continue
+		}
+		if next == nil || version.LessThan(nextVersion) {
+			next = &cycles[i]
+			nextVersion = version
+		}
+	}
+	return next
+}
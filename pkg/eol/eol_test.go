@@ -0,0 +1,88 @@
+package eol
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/nodejs.json":
+			_, _ = w.Write([]byte(`[
+				{"cycle": "22", "eol": "2027-04-30"},
+				{"cycle": "20", "eol": "2026-04-30"},
+				{"cycle": "18", "eol": "2025-04-30"},
+				{"cycle": "16", "eol": "2023-09-11"}
+			]`))
+		case "/debian.json":
+			_, _ = w.Write([]byte(`[
+				{"cycle": "12", "codename": "Bookworm", "eol": false},
+				{"cycle": "11", "codename": "Bullseye", "eol": true}
+			]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.baseURL = server.URL
+	client.now = func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	testCases := []struct {
+		product string
+		tag     string
+		cycle   string
+		ended   bool
+		next    string
+	}{
+		{product: "nodejs", tag: "16.20.2-alpine", cycle: "16", ended: true, next: "18"},
+		{product: "nodejs", tag: "20.11.0", cycle: "20", ended: false, next: "22"},
+		{product: "debian", tag: "bullseye-slim", cycle: "11", ended: true, next: "12"},
+		{product: "debian", tag: "12.5", cycle: "12", ended: false},
+		{product: "nodejs", tag: "latest"},
+	}
+
+	for _, tc := range testCases {
+		status, err := client.Check(context.Background(), tc.product, tc.tag)
+		if err != nil {
+			t.Fatalf("Check(%s, %s) error = %v", tc.product, tc.tag, err)
+		}
+		if tc.cycle == "" {
+			if status != nil {
+				t.Errorf("Check(%s, %s) = %+v, want no series", tc.product, tc.tag, status)
+			}
+			continue
+		}
+		if status == nil || status.Cycle.Cycle != tc.cycle || status.Cycle.Ended != tc.ended {
+			t.Errorf("Check(%s, %s) = %+v, want series %s ended %v", tc.product, tc.tag, status, tc.cycle, tc.ended)
+			continue
+		}
+		next := ""
+		if status.Next != nil {
+			next = status.Next.Cycle
+		}
+		if next != tc.next {
+			t.Errorf("Check(%s, %s) next = %q, want %q", tc.product, tc.tag, next, tc.next)
+		}
+	}
+
+	if requests != 2 {
+		t.Errorf("fetched end of life data %d times, want once per product", requests)
+	}
+}
+
+func TestProduct(t *testing.T) {
+	if product, ok := Product("docker.io/library/node"); !ok || product != "nodejs" {
+		t.Errorf("Product(docker.io/library/node) = %q %v, want nodejs", product, ok)
+	}
+	if _, ok := Product("registry.example.com/node"); ok {
+		t.Error("Product() matched an image of another registry")
+	}
+}
@@ -1,18 +1,23 @@
 package gitlab
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/audit"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/trace"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/transport"
 )
 
 const (
@@ -28,6 +33,13 @@ type Client struct {
 	repository string
 	config     *config.Config
 	httpClient *http.Client
+
+	maxRetries int
+	retryWait  time.Duration
+	rateLimit  rateLimit
+
+	mu            sync.Mutex
+	defaultBranch string
 }
 
 // ClientOption defines a function that configures a Client
@@ -47,14 +59,59 @@ func WithHTTPClient(httpClient *http.Client) ClientOption {
 	}
 }
 
-// MergeRequestResponse represents the response from GitLab API when creating a merge request
+// ProjectResponse represents a project as returned by the GitLab API
+type ProjectResponse struct {
+	ID            int    `json:"id"`
+	DefaultBranch string `json:"default_branch"`
+	// MergeMethod is merge, rebase_merge or ff
+	MergeMethod string `json:"merge_method"`
+	// SquashOption is never, always, default_on or default_off
+	SquashOption string `json:"squash_option"`
+}
+
+// TreeEntry represents a file or directory of the repository tree
+type TreeEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+// TokenInfo represents the access token used by the client as returned by the GitLab API
+type TokenInfo struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Active    bool     `json:"active"`
+	ExpiresAt string   `json:"expires_at"`
+}
+
+// FileChange is the new content of a file committed through the API
+type FileChange struct {
+	Path    string
+	Content string
+}
+
+// BranchResponse represents a branch as returned by the GitLab API
+type BranchResponse struct {
+	Name      string `json:"name"`
+	Merged    bool   `json:"merged"`
+	Protected bool   `json:"protected"`
+	Commit    struct {
+		ID            string    `json:"id"`
+		CommittedDate time.Time `json:"committed_date"`
+	} `json:"commit"`
+}
+
+// MergeRequestResponse represents a merge request as returned by the GitLab API
 type MergeRequestResponse struct {
-	ID        int    `json:"id"`
-	IID       int    `json:"iid"`
-	WebURL    string `json:"web_url"`
-	Title     string `json:"title"`
-	State     string `json:"state"`
-	CreatedAt string `json:"created_at"`
+	ID           int    `json:"id"`
+	IID          int    `json:"iid"`
+	WebURL       string `json:"web_url"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	State        string `json:"state"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	CreatedAt    string `json:"created_at"`
+	Draft        bool   `json:"draft"`
 }
 
 // NewClient creates a new GitLab client
@@ -80,8 +137,11 @@ func NewClient(cfg *config.Config, options ...ClientOption) (*Client, error) {
 		repository: cfg.GitLabRepo,
 		config:     cfg,
 		httpClient: &http.Client{
-			Timeout: DefaultTimeout,
+			Timeout:   DefaultTimeout,
+			Transport: trace.Transport("gitlab", transport.Shared(), parsedURL.Host),
 		},
+		maxRetries: DefaultMaxRetries,
+		retryWait:  DefaultRetryWait,
 	}
 
 	// Apply options
@@ -114,32 +174,35 @@ func (e *APIError) Error() string {
 	return fmt.Sprintf("GitLab API error (status %d): %s", e.StatusCode, e.Message)
 }
 
+// IsConflict reports whether the GitLab API refused a request with 409 Conflict, such as
+// a merge request already open for its source branch
+func IsConflict(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict
+}
+
 // doRequest performs an HTTP request to the GitLab API and decodes the JSON response
 func (c *Client) doRequest(ctx context.Context, method, path string, body interface{}, result interface{}) error {
-	var reqBody io.Reader
+	_, err := c.doRequestWithHeader(ctx, method, path, body, result)
+	return err
+}
+
+// doRequestWithHeader performs an HTTP request to the GitLab API, decodes the JSON
+// response and returns the response headers
+func (c *Client) doRequestWithHeader(ctx context.Context, method, path string, body interface{}, result interface{}) (http.Header, error) {
+	var reqBody []byte
 	if body != nil {
 		jsonData, err := json.Marshal(body)
 		if err != nil {
-			return fmt.Errorf("error marshaling request body: %w", err)
+			return nil, fmt.Errorf("error marshaling request body: %w", err)
 		}
-		reqBody = bytes.NewBuffer(jsonData)
+		reqBody = jsonData
 	}
 
-	// Create request with context
-	req, err := http.NewRequestWithContext(ctx, method, path, reqBody)
+	resp, err := c.send(ctx, method, path, reqBody)
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
-	}
-
-	// Set headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("PRIVATE-TOKEN", c.token)
-
-	// Send request
-	logger.Debug("Sending %s request to %s", method, path)
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("error sending request: %w", err)
+		c.recordAudit(ctx, audit.Event{Action: audit.APIError, Method: method, URL: path, Error: err.Error()})
+		return nil, err
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -149,27 +212,28 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body interf
 
 	// Check response status
 	if resp.StatusCode >= 400 {
-		var errorResp map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&errorResp); err != nil {
-			return &APIError{
-				StatusCode: resp.StatusCode,
-				Message:    "failed to decode error response",
-			}
-		}
-		return &APIError{
-			StatusCode: resp.StatusCode,
-			Response:   errorResp,
-		}
+		err := decodeErrorResponse(resp)
+		c.recordAudit(ctx, audit.Event{Action: audit.APIError, Method: method, URL: path, Status: resp.StatusCode, Error: err.Error()})
+		return nil, err
 	}
 
 	// Parse response if result is provided
 	if result != nil {
 		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
-			return fmt.Errorf("error parsing response: %w", err)
+			return nil, fmt.Errorf("error parsing response: %w", err)
 		}
 	}
 
-	return nil
+	return resp.Header, nil
+}
+
+// recordAudit records an event of the project in the audit log, made by the run of ctx
+func (c *Client) recordAudit(ctx context.Context, event audit.Event) {
+	event.RunID = runid.FromContext(ctx)
+	if projectInfo, err := c.getProjectInfo(); err == nil {
+		event.Project = projectInfo.Path
+	}
+	audit.Record(event)
 }
 
 // getProjectInfo extracts and formats project path information from repository URL
@@ -191,8 +255,28 @@ func (c *Client) CreateMergeRequest(sourceBranch, targetBranch, title, descripti
 	return c.CreateMergeRequestWithContext(context.Background(), sourceBranch, targetBranch, title, description)
 }
 
+// MergeRequestOptions holds the optional settings of a new merge request
+type MergeRequestOptions struct {
+	Labels []string
+	// Assignees and Reviewers are GitLab usernames, unknown ones are skipped
+	Assignees []string
+	Reviewers []string
+	// Draft opens the merge request as a draft
+	Draft bool
+	// AutoMerge merges the merge request once its pipeline succeeds
+	AutoMerge bool
+	// Squash and RemoveSourceBranch are applied when the merge request is merged
+	Squash             bool
+	RemoveSourceBranch bool
+}
+
 // CreateMergeRequestWithContext creates a new merge request in GitLab with context
 func (c *Client) CreateMergeRequestWithContext(ctx context.Context, sourceBranch, targetBranch, title, description string) (*MergeRequestResponse, error) {
+	return c.CreateMergeRequestWithOptions(ctx, sourceBranch, targetBranch, title, description, MergeRequestOptions{})
+}
+
+// CreateMergeRequestWithOptions creates a new merge request in GitLab with optional settings
+func (c *Client) CreateMergeRequestWithOptions(ctx context.Context, sourceBranch, targetBranch, title, description string, opts MergeRequestOptions) (*MergeRequestResponse, error) {
 	logger.Info("Creating merge request from %s to %s: %s", sourceBranch, targetBranch, title)
 
 	// Get project info
@@ -207,12 +291,30 @@ func (c *Client) CreateMergeRequestWithContext(ctx context.Context, sourceBranch
 		c.baseURL, projectInfo.Encoded)
 
 	// Prepare request body
-	requestBody := map[string]string{
+	if opts.Draft {
+		title = "Draft: " + title
+	}
+	requestBody := map[string]interface{}{
 		"source_branch": sourceBranch,
 		"target_branch": targetBranch,
 		"title":         title,
 		"description":   description,
 	}
+	if len(opts.Labels) > 0 {
+		requestBody["labels"] = strings.Join(opts.Labels, ",")
+	}
+	if opts.Squash {
+		requestBody["squash"] = true
+	}
+	if opts.RemoveSourceBranch {
+		requestBody["remove_source_branch"] = true
+	}
+	if ids := c.userIDs(ctx, opts.Assignees); len(ids) > 0 {
+		requestBody["assignee_ids"] = ids
+	}
+	if ids := c.userIDs(ctx, opts.Reviewers); len(ids) > 0 {
+		requestBody["reviewer_ids"] = ids
+	}
 
 	// Send request
 	var mergeRequest MergeRequestResponse
@@ -222,9 +324,165 @@ func (c *Client) CreateMergeRequestWithContext(ctx context.Context, sourceBranch
 	}
 
 	logger.Info("Merge request created successfully: %s", mergeRequest.WebURL)
+	c.recordAudit(ctx, audit.Event{Action: audit.MergeRequestOpened, Branch: sourceBranch, MergeRequest: mergeRequest.IID})
+
+	// The merge request exists even if auto-merge cannot be set, so it is not an error
+	if opts.AutoMerge {
+		if err := c.SetAutoMergeWithContext(ctx, mergeRequest.IID); err != nil {
+			logger.Warn("Could not set merge request !%d to merge automatically: %v", mergeRequest.IID, err)
+		}
+	}
+	return &mergeRequest, nil
+}
+
+// SetAutoMergeWithContext sets a merge request to be merged once its pipeline succeeds
+func (c *Client) SetAutoMergeWithContext(ctx context.Context, iid int) error {
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return err
+	}
+
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/merge_requests/%d/merge",
+		c.baseURL, projectInfo.Encoded, iid)
+
+	// Squashing and removing the source branch follow the settings of the merge request
+	requestBody := map[string]bool{
+		"merge_when_pipeline_succeeds": true,
+	}
+	if err := c.doRequest(ctx, http.MethodPut, apiURL, requestBody, nil); err != nil {
+		return fmt.Errorf("failed to set auto-merge: %w", err)
+	}
+
+	logger.Info("Merge request !%d will be merged when its pipeline succeeds", iid)
+	return nil
+}
+
+// userIDs returns the IDs of GitLab users from their usernames, skipping the unknown ones
+func (c *Client) userIDs(ctx context.Context, usernames []string) []int {
+	var ids []int
+	for _, username := range usernames {
+		var users []struct {
+			ID int `json:"id"`
+		}
+		apiURL := fmt.Sprintf("%s/api/v4/users?username=%s", c.baseURL, url.QueryEscape(username))
+		if err := c.doRequest(ctx, http.MethodGet, apiURL, nil, &users); err != nil {
+			logger.Warn("Could not look up GitLab user %s: %v", username, err)
+			continue
+		}
+		if len(users) == 0 {
+			logger.Warn("GitLab user %s not found", username)
+			continue
+		}
+		ids = append(ids, users[0].ID)
+	}
+	return ids
+}
+
+// GroupMembersWithContext returns the usernames of the active members of a group,
+// including the inherited ones, and false if there is no such group
+func (c *Client) GroupMembersWithContext(ctx context.Context, group string) ([]string, bool, error) {
+	apiURL := fmt.Sprintf("%s/api/v4/groups/%s/members/all?per_page=100", c.baseURL, url.PathEscape(group))
+
+	members, err := getAllPages[struct {
+		Username string `json:"username"`
+		State    string `json:"state"`
+	}](ctx, c, apiURL)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list members of group %s: %w", group, err)
+	}
+
+	usernames := make([]string, 0, len(members))
+	for _, member := range members {
+		if member.State == "active" {
+			usernames = append(usernames, member.Username)
+		}
+	}
+	return usernames, true, nil
+}
+
+// UpdateMergeRequest updates the title and description of an existing merge request
+func (c *Client) UpdateMergeRequest(iid int, title, description string) (*MergeRequestResponse, error) {
+	return c.UpdateMergeRequestWithContext(context.Background(), iid, title, description)
+}
+
+// UpdateMergeRequestWithContext updates the title and description of an existing merge request with context
+func (c *Client) UpdateMergeRequestWithContext(ctx context.Context, iid int, title, description string) (*MergeRequestResponse, error) {
+	logger.Info("Updating merge request !%d: %s", iid, title)
+
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build API URL
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/merge_requests/%d",
+		c.baseURL, projectInfo.Encoded, iid)
+
+	// Prepare request body
+	requestBody := map[string]string{
+		"title":       title,
+		"description": description,
+	}
+
+	// Send request
+	var mergeRequest MergeRequestResponse
+	if err := c.doRequest(ctx, http.MethodPut, apiURL, requestBody, &mergeRequest); err != nil {
+		logger.Error("Failed to update merge request: %v", err)
+		return nil, fmt.Errorf("failed to update merge request: %w", err)
+	}
+
+	logger.Info("Merge request updated successfully: %s", mergeRequest.WebURL)
+	c.recordAudit(ctx, audit.Event{Action: audit.MergeRequestUpdated, Branch: mergeRequest.SourceBranch, MergeRequest: iid})
 	return &mergeRequest, nil
 }
 
+// ListMergeRequests lists merge requests of the project in the given state
+// (opened, closed, merged or all) whose source branch starts with branchPrefix
+func (c *Client) ListMergeRequests(state, branchPrefix string) ([]MergeRequestResponse, error) {
+	return c.ListMergeRequestsWithContext(context.Background(), state, branchPrefix)
+}
+
+// ListMergeRequestsWithContext lists merge requests of the project with context
+func (c *Client) ListMergeRequestsWithContext(ctx context.Context, state, branchPrefix string) ([]MergeRequestResponse, error) {
+	logger.Debug("Listing %s merge requests with source branch prefix %s", state, branchPrefix)
+
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build API URL
+	query := url.Values{}
+	query.Set("state", state)
+	query.Set("per_page", "100")
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/merge_requests?%s",
+		c.baseURL, projectInfo.Encoded, query.Encode())
+
+	// Fetch every page
+	mergeRequests, err := getAllPages[MergeRequestResponse](ctx, c, apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list merge requests: %w", err)
+	}
+
+	// Keep only merge requests opened from matching branches
+	var filtered []MergeRequestResponse
+	for _, mr := range mergeRequests {
+		if strings.HasPrefix(mr.SourceBranch, branchPrefix) {
+			filtered = append(filtered, mr)
+		}
+	}
+
+	logger.Debug("Found %d %s merge requests from %s branches", len(filtered), state, branchPrefix)
+	return filtered, nil
+}
+
 // extractProjectPath extracts the project path from a GitLab repository URL
 func extractProjectPath(repoURL string) string {
 	// Parse URL
@@ -276,6 +534,92 @@ func (c *Client) CreateBranchWithContext(ctx context.Context, name, ref string)
 	}
 
 	logger.Info("Branch %s created successfully", name)
+	c.recordAudit(ctx, audit.Event{Action: audit.BranchCreated, Branch: name})
+	return nil
+}
+
+// BranchExists reports whether a branch exists in GitLab
+func (c *Client) BranchExists(name string) (bool, error) {
+	return c.BranchExistsWithContext(context.Background(), name)
+}
+
+// BranchExistsWithContext reports whether a branch exists in GitLab with context
+func (c *Client) BranchExistsWithContext(ctx context.Context, name string) (bool, error) {
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return false, err
+	}
+
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/branches/%s",
+		c.baseURL, projectInfo.Encoded, url.PathEscape(name))
+
+	err = c.doRequest(ctx, http.MethodGet, apiURL, nil, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get branch %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// ListBranchesWithContext lists the branches of the project whose name starts with prefix
+func (c *Client) ListBranchesWithContext(ctx context.Context, prefix string) ([]BranchResponse, error) {
+	logger.Debug("Listing branches starting with %s", prefix)
+
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build API URL, the search anchored with ^ matching the start of names
+	query := url.Values{}
+	query.Set("search", "^"+prefix)
+	query.Set("per_page", "100")
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/branches?%s",
+		c.baseURL, projectInfo.Encoded, query.Encode())
+
+	// Fetch every page
+	branches, err := getAllPages[BranchResponse](ctx, c, apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	// The search is case insensitive, keep exact matches only
+	var filtered []BranchResponse
+	for _, branch := range branches {
+		if strings.HasPrefix(branch.Name, prefix) {
+			filtered = append(filtered, branch)
+		}
+	}
+	return filtered, nil
+}
+
+// DeleteBranch deletes a branch in GitLab
+func (c *Client) DeleteBranch(name string) error {
+	return c.DeleteBranchWithContext(context.Background(), name)
+}
+
+// DeleteBranchWithContext deletes a branch in GitLab with context
+func (c *Client) DeleteBranchWithContext(ctx context.Context, name string) error {
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return err
+	}
+
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/branches/%s",
+		c.baseURL, projectInfo.Encoded, url.PathEscape(name))
+
+	if err := c.doRequest(ctx, http.MethodDelete, apiURL, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete branch %s: %w", name, err)
+	}
+
+	logger.Info("Branch %s deleted successfully", name)
+	c.recordAudit(ctx, audit.Event{Action: audit.BranchDeleted, Branch: name})
 	return nil
 }
 
@@ -323,6 +667,7 @@ func (c *Client) CommitFileWithContext(ctx context.Context, branch, filePath, co
 	}
 
 	logger.Info("File %s committed successfully", filePath)
+	c.recordAudit(ctx, audit.Event{Action: audit.FileModified, Branch: branch, File: filePath})
 	return nil
 }
 
@@ -331,6 +676,11 @@ func (c *Client) GetFile(branch, filePath string) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), c.httpClient.Timeout)
 	defer cancel()
 
+	return c.GetFileWithContext(ctx, branch, filePath)
+}
+
+// GetFileWithContext retrieves a file from GitLab with context
+func (c *Client) GetFileWithContext(ctx context.Context, branch, filePath string) (string, error) {
 	// Get project info
 	projectInfo, err := c.getProjectInfo()
 	if err != nil {
@@ -344,17 +694,8 @@ func (c *Client) GetFile(branch, filePath string) (string, error) {
 	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/files/%s/raw?ref=%s",
 		c.baseURL, projectInfo.Encoded, encodedFilePath, url.QueryEscape(branch))
 
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %w", err)
-	}
-
-	// Set headers
-	req.Header.Set("PRIVATE-TOKEN", c.token)
-
 	// Send request
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.send(ctx, http.MethodGet, apiURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("error fetching file: %w", err)
 	}
@@ -384,3 +725,153 @@ func (c *Client) GetFile(branch, filePath string) (string, error) {
 
 	return string(content), nil
 }
+
+// GetDefaultBranchWithContext returns the default branch of the project
+func (c *Client) GetDefaultBranchWithContext(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.defaultBranch != "" {
+		return c.defaultBranch, nil
+	}
+
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return "", err
+	}
+
+	project, err := c.GetProjectWithContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if project.DefaultBranch == "" {
+		return "", fmt.Errorf("project %s has no default branch", projectInfo.Path)
+	}
+
+	logger.Debug("Default branch of %s is %s", projectInfo.Path, project.DefaultBranch)
+	c.defaultBranch = project.DefaultBranch
+	return c.defaultBranch, nil
+}
+
+// GetProjectWithContext returns the project, including its merge settings
+func (c *Client) GetProjectWithContext(ctx context.Context) (*ProjectResponse, error) {
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	var project ProjectResponse
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s", c.baseURL, projectInfo.Encoded)
+	if err := c.doRequest(ctx, http.MethodGet, apiURL, nil, &project); err != nil {
+		return nil, fmt.Errorf("failed to get project: %w", err)
+	}
+	return &project, nil
+}
+
+// ListRepositoryTreeWithContext lists every file of the repository at the given ref
+func (c *Client) ListRepositoryTreeWithContext(ctx context.Context, ref string) ([]TreeEntry, error) {
+	logger.Debug("Listing repository tree at %s", ref)
+
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build API URL
+	query := url.Values{}
+	query.Set("ref", ref)
+	query.Set("recursive", "true")
+	query.Set("per_page", "100")
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/tree?%s",
+		c.baseURL, projectInfo.Encoded, query.Encode())
+
+	// Fetch every page
+	entries, err := getAllPages[TreeEntry](ctx, c, apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list repository tree: %w", err)
+	}
+
+	var files []TreeEntry
+	for _, entry := range entries {
+		if entry.Type == "blob" {
+			files = append(files, entry)
+		}
+	}
+
+	return files, nil
+}
+
+// CommitFilesWithContext commits changes to several files in a single commit on branch.
+// A missing branch is created from startBranch. With force, an existing branch is
+// replaced by startBranch with the new commit on top.
+func (c *Client) CommitFilesWithContext(ctx context.Context, branch, startBranch, commitMessage string, files []FileChange, force bool) error {
+	logger.Info("Committing %d files on branch %s", len(files), branch)
+
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return err
+	}
+
+	// Build API URL
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/commits",
+		c.baseURL, projectInfo.Encoded)
+
+	// Prepare request body
+	type commitAction struct {
+		Action   string `json:"action"`
+		FilePath string `json:"file_path"`
+		Content  string `json:"content"`
+	}
+	requestBody := struct {
+		Branch        string         `json:"branch"`
+		StartBranch   string         `json:"start_branch,omitempty"`
+		CommitMessage string         `json:"commit_message"`
+		AuthorName    string         `json:"author_name,omitempty"`
+		AuthorEmail   string         `json:"author_email,omitempty"`
+		Force         bool           `json:"force,omitempty"`
+		Actions       []commitAction `json:"actions"`
+	}{
+		Branch:        branch,
+		StartBranch:   startBranch,
+		CommitMessage: commitMessage,
+		AuthorEmail:   c.config.GitLabEmail,
+		Force:         force,
+	}
+	if c.config.CommitAuthor != "" {
+		requestBody.AuthorName, requestBody.AuthorEmail = c.config.Author()
+	}
+	for _, file := range files {
+		requestBody.Actions = append(requestBody.Actions, commitAction{
+			Action:   "update",
+			FilePath: file.Path,
+			Content:  file.Content,
+		})
+	}
+
+	// Send request
+	if err := c.doRequest(ctx, http.MethodPost, apiURL, requestBody, nil); err != nil {
+		logger.Error("Failed to commit files: %v", err)
+		return fmt.Errorf("failed to commit files: %w", err)
+	}
+
+	logger.Info("Committed %d files on branch %s successfully", len(files), branch)
+	c.recordAudit(ctx, audit.Event{Action: audit.BranchPushed, Branch: branch})
+	for _, file := range files {
+		c.recordAudit(ctx, audit.Event{Action: audit.FileModified, Branch: branch, File: file.Path})
+	}
+	return nil
+}
+
+// GetTokenInfoWithContext returns the name, scopes and expiry of the access token
+func (c *Client) GetTokenInfoWithContext(ctx context.Context) (*TokenInfo, error) {
+	var token TokenInfo
+	apiURL := fmt.Sprintf("%s/api/v4/personal_access_tokens/self", c.baseURL)
+	if err := c.doRequest(ctx, http.MethodGet, apiURL, nil, &token); err != nil {
+		return nil, fmt.Errorf("failed to get token information: %w", err)
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,95 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// hookOutputLines is the number of last output lines of a failed hook kept in its error
+const hookOutputLines = 10
+
+// HookError is the failure of a pre-update or post-update hook
+type HookError struct {
+	Hook   string
+	Err    error
+	Output string
+}
+
+// Error returns the error message
+func (e *HookError) Error() string {
+	if e.Output != "" {
+		return fmt.Sprintf("%s hook failed: %v (output: %s)", e.Hook, e.Err, e.Output)
+	}
+	return fmt.Sprintf("%s hook failed: %v", e.Hook, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// RunHook runs the shell command of a hook in the cloned repository with the variables of
+// env added to the environment, killing it after the configured hook timeout. Only the
+// updated files are committed, other files the hook changes or creates are discarded.
+func RunHook(ctx context.Context, cfg *config.Config, hook, command string, env []string) error {
+	if command == "" {
+		return nil
+	}
+	if err := validateRepoCloned(cfg); err != nil {
+		return err
+	}
+
+	if cfg.HookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.HookTimeout)
+		defer cancel()
+	}
+
+	logger.Info("Running %s hook: %s", hook, command)
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = cfg.TempDir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.WaitDelay = gitWaitDelay
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", cfg.HookTimeout)
+		}
+		return &HookError{Hook: hook, Err: err, Output: lastLines(string(output), hookOutputLines)}
+	}
+
+	logger.Debug("%s hook output: %s", hook, strings.TrimSpace(string(output)))
+	return nil
+}
+
+// DiscardChangesInRepo drops the uncommitted changes of the cloned repository, such as
+// the updates of a branch whose hook failed
+func DiscardChangesInRepo(ctx context.Context, cfg *config.Config) error {
+	if err := validateRepoCloned(cfg); err != nil {
+		return err
+	}
+
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, "reset", "--hard"); err != nil {
+		return fmt.Errorf("failed to reset working tree: %w", err)
+	}
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, "clean", "-fd"); err != nil {
+		return fmt.Errorf("failed to clean working tree: %w", err)
+	}
+	return nil
+}
+
+// lastLines returns the last n lines of a command output
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,86 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+)
+
+func TestRunHook(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+
+	cfg := config.New()
+	cfg.TempDir = t.TempDir()
+	cfg.ClonedRepo = true
+
+	err := RunHook(context.Background(), cfg, "post-update", `echo "$IMG_UPGR_HOOK_FILES" > hook.txt`, []string{"IMG_UPGR_HOOK_FILES=docker-compose.yml"})
+	if err != nil {
+		t.Fatalf("RunHook() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(cfg.TempDir, "hook.txt"))
+	if err != nil {
+		t.Fatalf("hook did not run in the clone: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "docker-compose.yml" {
+		t.Errorf("hook environment = %q, want docker-compose.yml", got)
+	}
+
+	err = RunHook(context.Background(), cfg, "pre-update", "echo invalid compose file; exit 3", nil)
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) {
+		t.Fatalf("RunHook() error = %v, want a hook error", err)
+	}
+	if hookErr.Hook != "pre-update" || hookErr.Output != "invalid compose file" {
+		t.Errorf("RunHook() error = %+v, want the hook name and its output", hookErr)
+	}
+
+	cfg.HookTimeout = 50 * time.Millisecond
+	err = RunHook(context.Background(), cfg, "pre-update", "exec sleep 5", nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("RunHook() error = %v, want timeout", err)
+	}
+
+	if err := RunHook(context.Background(), cfg, "pre-update", "", nil); err != nil {
+		t.Errorf("RunHook() without command error = %v", err)
+	}
+}
+
+func TestDiscardChangesInRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	cfg := config.New()
+	cfg.TempDir = t.TempDir()
+	cfg.ClonedRepo = true
+
+	ctx := context.Background()
+	for _, args := range [][]string{
+		{"init"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--allow-empty", "-m", "init"},
+	} {
+		if err := runGitCommand(ctx, cfg, cfg.TempDir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(cfg.TempDir, "docker-compose.yml")
+	if err := os.WriteFile(file, []byte("services: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DiscardChangesInRepo(ctx, cfg); err != nil {
+		t.Fatalf("DiscardChangesInRepo() error = %v", err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("untracked file kept after discarding changes")
+	}
+}
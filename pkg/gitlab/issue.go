@@ -0,0 +1,164 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+const (
+	// IssueLabel is the label of the issues opened by img-upgr, used to find them again
+	IssueLabel = "img-upgr"
+
+	// issueMarkerStart delimits the hidden issue marker in issue descriptions
+	issueMarkerStart = "<!-- img-upgr-issue:"
+)
+
+// IssueResponse represents a GitLab issue
+type IssueResponse struct {
+	ID          int    `json:"id"`
+	IID         int    `json:"iid"`
+	WebURL      string `json:"web_url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	State       string `json:"state"`
+}
+
+// IssueMarker identifies the image problem reported by an issue. It is embedded as a
+// hidden comment in the issue description so later runs update the issue instead of
+// opening another one.
+type IssueMarker struct {
+	File    string `json:"file"`
+	Service string `json:"service,omitempty"`
+	// Problem is the kind of problem, such as eol or error
+	Problem string `json:"problem"`
+}
+
+// Key returns a string uniquely identifying the problem
+func (m IssueMarker) Key() string {
+	return fmt.Sprintf("%s|%s|%s", m.File, m.Service, m.Problem)
+}
+
+// String returns the marker formatted as a hidden markdown comment
+func (m IssueMarker) String() string {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return issueMarkerStart + string(data) + " " + markerEnd
+}
+
+// ParseIssueMarker extracts the issue marker from an issue description
+func ParseIssueMarker(description string) (*IssueMarker, bool) {
+	start := strings.Index(description, issueMarkerStart)
+	if start == -1 {
+		return nil, false
+	}
+
+	rest := description[start+len(issueMarkerStart):]
+	end := strings.Index(rest, markerEnd)
+	if end == -1 {
+		return nil, false
+	}
+
+	var marker IssueMarker
+	if err := json.Unmarshal([]byte(strings.TrimSpace(rest[:end])), &marker); err != nil {
+		return nil, false
+	}
+	return &marker, true
+}
+
+// ListIssuesWithContext lists the open issues of the project carrying a label
+func (c *Client) ListIssuesWithContext(ctx context.Context, label string) ([]IssueResponse, error) {
+	logger.Debug("Listing open issues labeled %s", label)
+
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build API URL
+	query := url.Values{}
+	query.Set("state", "opened")
+	query.Set("labels", label)
+	query.Set("per_page", "100")
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/issues?%s",
+		c.baseURL, projectInfo.Encoded, query.Encode())
+
+	// Fetch every page
+	issues, err := getAllPages[IssueResponse](ctx, c, apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list issues: %w", err)
+	}
+
+	logger.Debug("Found %d open issues labeled %s", len(issues), label)
+	return issues, nil
+}
+
+// CreateIssueWithContext opens an issue in the project with context
+func (c *Client) CreateIssueWithContext(ctx context.Context, title, description string, labels []string) (*IssueResponse, error) {
+	logger.Info("Creating issue: %s", title)
+
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build API URL
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/issues",
+		c.baseURL, projectInfo.Encoded)
+
+	// Prepare request body
+	requestBody := map[string]string{
+		"title":       title,
+		"description": description,
+	}
+	if len(labels) > 0 {
+		requestBody["labels"] = strings.Join(labels, ",")
+	}
+
+	// Send request
+	var issue IssueResponse
+	if err := c.doRequest(ctx, http.MethodPost, apiURL, requestBody, &issue); err != nil {
+		return nil, fmt.Errorf("failed to create issue: %w", err)
+	}
+
+	logger.Info("Issue created successfully: %s", issue.WebURL)
+	return &issue, nil
+}
+
+// UpdateIssueWithContext updates the title and description of an existing issue with context
+func (c *Client) UpdateIssueWithContext(ctx context.Context, iid int, title, description string) (*IssueResponse, error) {
+	logger.Info("Updating issue #%d: %s", iid, title)
+
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build API URL
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/issues/%d",
+		c.baseURL, projectInfo.Encoded, iid)
+
+	// Prepare request body
+	requestBody := map[string]string{
+		"title":       title,
+		"description": description,
+	}
+
+	// Send request
+	var issue IssueResponse
+	if err := c.doRequest(ctx, http.MethodPut, apiURL, requestBody, &issue); err != nil {
+		return nil, fmt.Errorf("failed to update issue: %w", err)
+	}
+
+	return &issue, nil
+}
@@ -0,0 +1,233 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/audit"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+const (
+	// LockBranch is the branch held by the run creating merge requests, outside of
+	// BranchPrefix so that it is never taken for an update branch
+	LockBranch = "img-upgr-lock"
+
+	// lockFile is the file committed on the lock branch, naming the holder of the lock
+	lockFile = ".img-upgr.lock"
+)
+
+// LockedError reports that another run holds the lock of the project
+type LockedError struct {
+	Holder string
+	Since  time.Time
+}
+
+// Error returns the error message
+func (e *LockedError) Error() string {
+	holder := e.Holder
+	if holder == "" {
+		holder = "another run"
+	}
+	return fmt.Sprintf("%s holds branch %s since %s", holder, LockBranch, e.Since.Format(time.RFC3339))
+}
+
+// Lock is a lock of the project held by this run, refreshed until it is released
+type Lock struct {
+	client *Client
+	holder string
+	stop   chan struct{}
+	done   chan struct{}
+	// ctx is cancelled when the lock is lost or released
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu sync.Mutex
+	// commit is the last commit of the lock branch made by this run
+	commit string
+	lost   bool
+}
+
+// AcquireLockWithContext takes the lock of the project by creating the lock branch from
+// the default branch, which GitLab only lets one run do. A lock older than ttl is left
+// by a run that died and is taken over. A lock held by another run is a *LockedError.
+// The lock is refreshed every third of ttl so that runs longer than ttl keep it, and
+// the context of the lock is cancelled if it is lost.
+func (c *Client) AcquireLockWithContext(ctx context.Context, holder string, ttl time.Duration) (*Lock, error) {
+	ref, err := c.GetDefaultBranchWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, createErr := c.commitLock(ctx, ref, holder, "")
+	if createErr != nil {
+		branch, err := c.getBranch(ctx, LockBranch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lock branch %s: %w", LockBranch, createErr)
+		}
+		locked := &LockedError{Holder: c.lockHolder(ctx), Since: branch.Commit.CommittedDate}
+		if time.Since(locked.Since) < ttl {
+			return nil, locked
+		}
+
+		// The stale lock is replaced only if its commit is still the head of the branch,
+		// so that of two runs taking it over at once, one fails instead of both deleting
+		// the lock of the other
+		logger.Warn("Taking over the lock left by %s, older than %s", locked.Holder, ttl)
+		commit, err = c.commitLock(ctx, ref, holder, branch.Commit.ID)
+		if err != nil {
+			logger.Debug("Failed to take over the lock: %v", err)
+			if branch, err := c.getBranch(ctx, LockBranch); err == nil {
+				locked.Since = branch.Commit.CommittedDate
+			}
+			locked.Holder = c.lockHolder(ctx)
+			return nil, locked
+		}
+	}
+
+	logger.Debug("Acquired lock branch %s", LockBranch)
+	lock := &Lock{client: c, holder: holder, commit: commit, stop: make(chan struct{}), done: make(chan struct{})}
+	lock.ctx, lock.cancel = context.WithCancelCause(ctx)
+	go lock.keepAlive(context.WithoutCancel(ctx), max(ttl/3, time.Millisecond))
+	return lock, nil
+}
+
+// Context returns a context of the run cancelled once the lock is lost, whose cause
+// tells why, so that no branch is pushed without holding the lock
+func (l *Lock) Context() context.Context {
+	return l.ctx
+}
+
+// keepAlive refreshes the lock at every interval until it is released or lost
+func (l *Lock) keepAlive(ctx context.Context, interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.refresh(ctx); err != nil {
+				logger.Warn("Lost lock branch %s: %v", LockBranch, err)
+				l.cancel(fmt.Errorf("lost lock branch %s: %w", LockBranch, err))
+				return
+			}
+		}
+	}
+}
+
+// refresh commits on the lock branch to renew its age, failing if another run took it
+// over since the last commit of this run
+func (l *Lock) refresh(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	commit, err := l.client.commitLock(ctx, "", l.holder, l.commit)
+	if err != nil {
+		l.lost = true
+		return err
+	}
+	l.commit = commit
+	logger.Debug("Refreshed lock branch %s", LockBranch)
+	return nil
+}
+
+// Release stops refreshing the lock and deletes the lock branch, unless another run
+// took it over
+func (l *Lock) Release(ctx context.Context) error {
+	close(l.stop)
+	<-l.done
+	defer l.cancel(nil)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lost {
+		return fmt.Errorf("lock branch %s was taken over by another run", LockBranch)
+	}
+	branch, err := l.client.getBranch(ctx, LockBranch)
+	if err != nil {
+		return err
+	}
+	if branch.Commit.ID != l.commit {
+		return fmt.Errorf("lock branch %s was taken over by another run", LockBranch)
+	}
+	return l.client.DeleteBranchWithContext(ctx, LockBranch)
+}
+
+// commitLock creates the lock branch from ref with a commit naming the holder,
+// failing if the branch exists. With a last commit, the lock file of the existing
+// branch is rewritten instead, failing if the branch moved past that commit. The
+// commit made is returned.
+func (c *Client) commitLock(ctx context.Context, ref, holder, lastCommit string) (string, error) {
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return "", err
+	}
+
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/commits",
+		c.baseURL, projectInfo.Encoded)
+
+	action := map[string]string{
+		"action":    "create",
+		"file_path": lockFile,
+		"content":   holder + "\n",
+	}
+	requestBody := map[string]interface{}{
+		"branch":         LockBranch,
+		"commit_message": "Lock held by " + holder,
+		"actions":        []map[string]string{action},
+	}
+	if lastCommit == "" {
+		requestBody["start_branch"] = ref
+	} else {
+		action["action"] = "update"
+		action["last_commit_id"] = lastCommit
+	}
+
+	var commit struct {
+		ID string `json:"id"`
+	}
+	if err := c.doRequest(ctx, http.MethodPost, apiURL, requestBody, &commit); err != nil {
+		return "", err
+	}
+	if lastCommit == "" {
+		c.recordAudit(ctx, audit.Event{Action: audit.BranchCreated, Branch: LockBranch})
+	}
+	return commit.ID, nil
+}
+
+// getBranch returns a branch of the project
+func (c *Client) getBranch(ctx context.Context, name string) (*BranchResponse, error) {
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/branches/%s",
+		c.baseURL, projectInfo.Encoded, url.PathEscape(name))
+
+	var branch BranchResponse
+	if err := c.doRequest(ctx, http.MethodGet, apiURL, nil, &branch); err != nil {
+		return nil, fmt.Errorf("failed to get branch %s: %w", name, err)
+	}
+	return &branch, nil
+}
+
+// lockHolder returns the holder named on the lock branch, empty if it cannot be read
+func (c *Client) lockHolder(ctx context.Context) string {
+	content, err := c.GetFileWithContext(ctx, LockBranch, lockFile)
+	if err != nil {
+		logger.Debug("Failed to read the holder of the lock: %v", err)
+		return ""
+	}
+	return strings.TrimSpace(content)
+}
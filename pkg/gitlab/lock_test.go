@@ -0,0 +1,264 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockServer is a project whose lock branch is created and rewritten by commits, which
+// GitLab refuses when the branch exists or moved past the last commit known to the client
+type lockServer struct {
+	t        *testing.T
+	mu       sync.Mutex
+	holder   string
+	head     string
+	lockedAt time.Time
+	commits  int
+}
+
+func (s *lockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.EscapedPath(), "/projects/group%2Fproject"):
+		_ = json.NewEncoder(w).Encode(ProjectResponse{ID: 1, DefaultBranch: "main"})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/repository/commits"):
+		var body struct {
+			Branch      string `json:"branch"`
+			StartBranch string `json:"start_branch"`
+			Actions     []struct {
+				Action       string `json:"action"`
+				Content      string `json:"content"`
+				LastCommitID string `json:"last_commit_id"`
+			} `json:"actions"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Branch != LockBranch {
+			s.t.Errorf("lock committed on %s, want %s", body.Branch, LockBranch)
+		}
+		action := body.Actions[0]
+		switch {
+		case action.Action == "create" && s.head != "":
+			http.Error(w, `{"message": "A branch called 'img-upgr-lock' already exists"}`, http.StatusBadRequest)
+			return
+		case action.Action == "create" && body.StartBranch != "main":
+			s.t.Errorf("lock branch created from %q, want main", body.StartBranch)
+		case action.Action == "update" && action.LastCommitID != s.head:
+			http.Error(w, `{"message": "You are attempting to update a file that has changed since you started editing it."}`, http.StatusBadRequest)
+			return
+		}
+		s.commits++
+		s.holder = strings.TrimSpace(action.Content)
+		s.head = fmt.Sprintf("commit%d", s.commits)
+		s.lockedAt = time.Now()
+		_, _ = fmt.Fprintf(w, `{"id": %q}`, s.head)
+	case strings.HasSuffix(r.URL.EscapedPath(), "/repository/branches/"+LockBranch):
+		if s.head == "" {
+			http.Error(w, `{"message": "404 Branch Not Found"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			s.holder, s.head = "", ""
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"name": %q, "commit": {"id": %q, "committed_date": %q}}`, LockBranch, s.head, s.lockedAt.Format(time.RFC3339Nano))
+	case strings.Contains(r.URL.EscapedPath(), "/repository/files/"):
+		_, _ = fmt.Fprintln(w, s.holder)
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// expire ages the lock past any TTL of the tests
+func (s *lockServer) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lockedAt = time.Now().Add(-2 * time.Hour)
+}
+
+// takeOver rewrites the lock branch as another run taking the lock over would
+func (s *lockServer) takeOver(holder string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commits++
+	s.holder = holder
+	s.head = fmt.Sprintf("commit%d", s.commits)
+	s.lockedAt = time.Now()
+}
+
+// state returns the holder and head commit of the lock branch
+func (s *lockServer) state() (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.holder, s.head
+}
+
+// newLockClient returns a client of the project of a lock server
+func newLockClient(t *testing.T) (*Client, *lockServer) {
+	t.Helper()
+	lockServer := &lockServer{t: t}
+	server := httptest.NewServer(lockServer)
+	t.Cleanup(server.Close)
+
+	client := newTestClient(server)
+	client.repository = server.URL + "/group/project.git"
+	return client, lockServer
+}
+
+func TestAcquireLock(t *testing.T) {
+	client, server := newLockClient(t)
+	ctx := context.Background()
+
+	first, err := client.AcquireLockWithContext(ctx, "first", time.Hour)
+	if err != nil {
+		t.Fatalf("AcquireLockWithContext() error = %v", err)
+	}
+
+	// A second run finds the lock held
+	_, err = client.AcquireLockWithContext(ctx, "second", time.Hour)
+	var locked *LockedError
+	if !errors.As(err, &locked) || locked.Holder != "first" {
+		t.Fatalf("AcquireLockWithContext() error = %v, want the lock held by first", err)
+	}
+
+	// A lock older than the TTL is taken over
+	server.expire()
+	second, err := client.AcquireLockWithContext(ctx, "second", time.Hour)
+	if err != nil {
+		t.Fatalf("AcquireLockWithContext() error = %v, want the stale lock taken over", err)
+	}
+	if holder, _ := server.state(); holder != "second" {
+		t.Errorf("lock held by %q, want second", holder)
+	}
+
+	// The run whose lock was taken over does not release the lock of the other
+	if err := first.Release(ctx); err == nil {
+		t.Error("Release() of a lock taken over succeeded")
+	}
+	if holder, _ := server.state(); holder != "second" {
+		t.Errorf("lock held by %q after release of first, want second", holder)
+	}
+
+	if err := second.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if holder, _ := server.state(); holder != "" {
+		t.Errorf("lock still held by %q after release", holder)
+	}
+}
+
+func TestAcquireLockConcurrentTakeover(t *testing.T) {
+	client, server := newLockClient(t)
+	ctx := context.Background()
+
+	stale, err := client.AcquireLockWithContext(ctx, "dead", time.Hour)
+	if err != nil {
+		t.Fatalf("AcquireLockWithContext() error = %v", err)
+	}
+	close(stale.stop)
+	server.expire()
+
+	// Runs taking over the stale lock at once cannot all get it
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var acquired []string
+	for _, holder := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := client.AcquireLockWithContext(ctx, holder, time.Hour)
+			var locked *LockedError
+			if err != nil && !errors.As(err, &locked) {
+				t.Errorf("AcquireLockWithContext(%s) error = %v", holder, err)
+			}
+			if lock != nil {
+				close(lock.stop)
+				mu.Lock()
+				acquired = append(acquired, holder)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(acquired) != 1 {
+		t.Fatalf("lock acquired by %v, want a single run", acquired)
+	}
+	if holder, _ := server.state(); holder != acquired[0] {
+		t.Errorf("lock held by %q, want %q", holder, acquired[0])
+	}
+}
+
+func TestLockRefresh(t *testing.T) {
+	client, server := newLockClient(t)
+	ctx := context.Background()
+
+	lock, err := client.AcquireLockWithContext(ctx, "long run", 30*time.Millisecond)
+	if err != nil {
+		t.Fatalf("AcquireLockWithContext() error = %v", err)
+	}
+	_, acquired := server.state()
+
+	// The lock is renewed while the run lasts longer than the TTL
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, head := server.state(); head != acquired {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("lock not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := client.AcquireLockWithContext(ctx, "other", time.Hour); err == nil {
+		t.Fatal("AcquireLockWithContext() took a refreshed lock")
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if holder, _ := server.state(); holder != "" {
+		t.Errorf("lock still held by %q after release", holder)
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	client, server := newLockClient(t)
+	ctx := context.Background()
+
+	lock, err := client.AcquireLockWithContext(ctx, "long run", 30*time.Millisecond)
+	if err != nil {
+		t.Fatalf("AcquireLockWithContext() error = %v", err)
+	}
+	if err := lock.Context().Err(); err != nil {
+		t.Fatalf("Context() of a held lock cancelled: %v", err)
+	}
+
+	// Another run taking the lock over cancels the run at the next refresh
+	server.takeOver("other")
+	select {
+	case <-lock.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Context() not cancelled after the lock was lost")
+	}
+	if cause := context.Cause(lock.Context()); cause == nil || !strings.Contains(cause.Error(), "lost lock branch") {
+		t.Errorf("Cause() = %v, want the lock lost", cause)
+	}
+
+	if err := lock.Release(ctx); err == nil {
+		t.Error("Release() of a lost lock succeeded")
+	}
+	if holder, _ := server.state(); holder != "other" {
+		t.Errorf("lock held by %q after release of a lost lock, want other", holder)
+	}
+}
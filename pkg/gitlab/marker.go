@@ -0,0 +1,87 @@
+package gitlab
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// BranchPrefix is the prefix of all branches created by img-upgr
+	BranchPrefix = "img-upgr/"
+
+	// markerStart and markerEnd delimit the hidden update marker in merge request descriptions
+	markerStart = "<!-- img-upgr:"
+	markerEnd   = "-->"
+)
+
+// UpdateMarker identifies the image update proposed by a merge request.
+// It is embedded as a hidden comment in the merge request description so
+// later runs can recognize merge requests they created.
+type UpdateMarker struct {
+	File       string `json:"file"`
+	Service    string `json:"service"`
+	Repository string `json:"repository"`
+	OldTag     string `json:"old_tag"`
+	NewTag     string `json:"new_tag"`
+	Major      bool   `json:"major,omitempty"`
+}
+
+// Key returns a string uniquely identifying the old → new update
+func (m UpdateMarker) Key() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", m.File, m.Service, m.Repository, m.OldTag, m.NewTag)
+}
+
+// ImageKey returns a string identifying the image reference being updated,
+// regardless of the proposed new tag. Major updates are proposed separately
+// from the updates within the current major version.
+func (m UpdateMarker) ImageKey() string {
+	key := fmt.Sprintf("%s|%s|%s|%s", m.File, m.Service, m.Repository, m.OldTag)
+	if m.Major {
+		key += "|major"
+	}
+	return key
+}
+
+// String returns the marker formatted as a hidden markdown comment
+func (m UpdateMarker) String() string {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	return markerStart + string(data) + " " + markerEnd
+}
+
+// ParseUpdateMarker extracts the first update marker from a merge request description
+func ParseUpdateMarker(description string) (*UpdateMarker, bool) {
+	markers := ParseUpdateMarkers(description)
+	if len(markers) == 0 {
+		return nil, false
+	}
+	return &markers[0], true
+}
+
+// ParseUpdateMarkers extracts all update markers from a merge request description.
+// Grouped merge requests hold one marker per update.
+func ParseUpdateMarkers(description string) []UpdateMarker {
+	var markers []UpdateMarker
+
+	for {
+		start := strings.Index(description, markerStart)
+		if start == -1 {
+			return markers
+		}
+
+		rest := description[start+len(markerStart):]
+		end := strings.Index(rest, markerEnd)
+		if end == -1 {
+			return markers
+		}
+
+		var marker UpdateMarker
+		if err := json.Unmarshal([]byte(strings.TrimSpace(rest[:end])), &marker); err == nil {
+			markers = append(markers, marker)
+		}
+		description = rest[end+len(markerEnd):]
+	}
+}
@@ -0,0 +1,112 @@
+package gitlab
+
+import (
+	"testing"
+)
+
+func TestUpdateMarkerRoundTrip(t *testing.T) {
+	marker := UpdateMarker{
+		File:       "apps/web/docker-compose.yml",
+		Service:    "web",
+		Repository: "nginx",
+		OldTag:     "1.25.3",
+		NewTag:     "1.25.4",
+	}
+
+	description := "Automated update of Docker image by img-upgr\n\n" + marker.String()
+
+	parsed, ok := ParseUpdateMarker(description)
+	if !ok {
+		t.Fatalf("ParseUpdateMarker() did not find marker in %q", description)
+	}
+
+	if *parsed != marker {
+		t.Errorf("ParseUpdateMarker() = %+v, want %+v", *parsed, marker)
+	}
+
+	if parsed.Key() != marker.Key() {
+		t.Errorf("Key() = %q, want %q", parsed.Key(), marker.Key())
+	}
+}
+
+func TestParseUpdateMarkerMissing(t *testing.T) {
+	testCases := []struct {
+		name        string
+		description string
+	}{
+		{
+			name:        "empty description",
+			description: "",
+		},
+		{
+			name:        "no marker",
+			description: "Manual update of nginx",
+		},
+		{
+			name:        "unterminated marker",
+			description: `<!-- img-upgr:{"service":"web"}`,
+		},
+		{
+			name:        "invalid JSON",
+			description: "<!-- img-upgr:not json -->",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if marker, ok := ParseUpdateMarker(tc.description); ok {
+				t.Errorf("ParseUpdateMarker(%q) = %+v, want no marker", tc.description, marker)
+			}
+		})
+	}
+}
+
+func TestParseUpdateMarkers(t *testing.T) {
+	web := UpdateMarker{File: "apps/web/docker-compose.yml", Service: "web", Repository: "nginx", OldTag: "1.25.3", NewTag: "1.25.4"}
+	db := UpdateMarker{File: "apps/web/docker-compose.yml", Service: "db", Repository: "postgres", OldTag: "15.4", NewTag: "15.5"}
+
+	description := "Grouped update\n\n" + web.String() + "\n<!-- img-upgr:invalid -->\n" + db.String()
+
+	markers := ParseUpdateMarkers(description)
+	if len(markers) != 2 {
+		t.Fatalf("ParseUpdateMarkers() = %+v, want 2 markers", markers)
+	}
+	if markers[0] != web || markers[1] != db {
+		t.Errorf("ParseUpdateMarkers() = %+v, want [%+v %+v]", markers, web, db)
+	}
+}
+
+func TestUpdateMarkerMajorImageKey(t *testing.T) {
+	minor := UpdateMarker{File: "docker-compose.yml", Service: "db", Repository: "postgres", OldTag: "15.4.0", NewTag: "15.5.0"}
+	major := UpdateMarker{File: "docker-compose.yml", Service: "db", Repository: "postgres", OldTag: "15.4.0", NewTag: "16.1.0", Major: true}
+
+	if minor.ImageKey() == major.ImageKey() {
+		t.Errorf("major and minor updates share the image key %q", minor.ImageKey())
+	}
+
+	parsed, ok := ParseUpdateMarker(major.String())
+	if !ok || *parsed != major {
+		t.Errorf("ParseUpdateMarker() = %+v, want %+v", parsed, major)
+	}
+}
+
+func TestIssueMarkerRoundTrip(t *testing.T) {
+	marker := IssueMarker{File: "apps/docker-compose.yml", Service: "db", Problem: "eol"}
+
+	description := "postgres 11 reached its end of life\n\n" + marker.String()
+	parsed, ok := ParseIssueMarker(description)
+	if !ok {
+		t.Fatalf("ParseIssueMarker() did not find marker in %q", description)
+	}
+	if *parsed != marker {
+		t.Errorf("ParseIssueMarker() = %+v, want %+v", *parsed, marker)
+	}
+
+	// Issue and update markers are told apart
+	if _, ok := ParseUpdateMarker(description); ok {
+		t.Error("ParseUpdateMarker() found an update marker in an issue description")
+	}
+	if _, ok := ParseIssueMarker(UpdateMarker{File: "a.yml"}.String()); ok {
+		t.Error("ParseIssueMarker() found an issue marker in a merge request description")
+	}
+}
@@ -0,0 +1,113 @@
+package gitlab
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+)
+
+// MirrorRepository fetches the files of the default branch a scan may read through the
+// GitLab API, as a clone-less alternative to CloneRepository. The files are held in
+// memory under cfg.TempDir, a directory that is not created, so that nothing is written
+// to the disk. Changes are later committed with CommitFilesWithContext.
+func MirrorRepository(ctx context.Context, cfg *config.Config) error {
+	client, ok := cfg.GitLabClient.(*Client)
+	if !ok {
+		return fmt.Errorf("GitLab client not initialized")
+	}
+
+	logger.Info("Fetching repository %s through the GitLab API", cfg.GitLabRepo)
+
+	branch, err := client.GetDefaultBranchWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries, err := client.ListRepositoryTreeWithContext(ctx, branch)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if cfg.IsRepositoryFile(entry.Path) {
+			files = append(files, entry.Path)
+		}
+	}
+
+	contents, err := downloadFiles(ctx, cfg, client, branch, files)
+	if err != nil {
+		return err
+	}
+
+	// The files are found under a directory of their own, which is never created
+	root := mirrorRoot()
+	repofs.Mount(root, contents)
+	cfg.TempDir = root
+	logger.Debug("Holding the files of %s in memory under %s", branch, root)
+
+	// Update scan directory to be inside the mirrored repository
+	updateScanDirectory(cfg, root)
+
+	logger.Info("Fetched %d of %d files from %s", len(files), len(entries), branch)
+	return nil
+}
+
+// downloadFiles fetches files of a branch concurrently, returning their contents by path
+func downloadFiles(ctx context.Context, cfg *config.Config, client *Client, branch string, files []string) (map[string][]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	semaphore := make(chan struct{}, max(cfg.Concurrency, 1))
+	errs := make(chan error, len(files))
+	contents := make(map[string][]byte, len(files))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, file := range files {
+		wg.Add(1)
+		go func(file string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			logger.Debug("Fetching %s", file)
+			content, err := client.GetFileWithContext(ctx, branch, file)
+			if err != nil {
+				errs <- fmt.Errorf("failed to fetch %s: %w", file, err)
+				cancel()
+				return
+			}
+
+			mu.Lock()
+			contents[file] = []byte(content)
+			mu.Unlock()
+		}(file)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	// Report the first failure, later ones are usually caused by the cancellation
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// mirrorRoot returns a path under the temporary directory for the files of a mirror, left
+// uncreated
+func mirrorRoot() string {
+	// crypto/rand never returns an error
+	data := make([]byte, 8)
+	_, _ = rand.Read(data)
+	return filepath.Join(os.TempDir(), "img-upgr-api-"+hex.EncodeToString(data))
+}
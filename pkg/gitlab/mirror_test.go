@@ -0,0 +1,94 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+)
+
+func TestMirrorRepository(t *testing.T) {
+	files := map[string]string{
+		"docker-compose.yml":                  "services: {}\n",
+		"deploy/main.tf":                      "",
+		"README.md":                           "",
+		"node_modules/pkg/docker-compose.yml": "",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.EscapedPath(), "/api/v4/projects/group%2Fproject")
+		switch {
+		case path == "":
+			_ = json.NewEncoder(w).Encode(ProjectResponse{ID: 1, DefaultBranch: "develop"})
+		case path == "/repository/tree":
+			if r.URL.Query().Get("ref") != "develop" {
+				t.Errorf("tree listed at %q, want develop", r.URL.Query().Get("ref"))
+			}
+			entries := []TreeEntry{{Path: "deploy", Type: "tree"}}
+			for name := range files {
+				entries = append(entries, TreeEntry{Path: name, Type: "blob"})
+			}
+			_ = json.NewEncoder(w).Encode(entries)
+		case strings.HasPrefix(path, "/repository/files/"):
+			name, _ := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v4/projects/group/project/repository/files/"), "/raw")
+			content, ok := files[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(content))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.New()
+	cfg.Terraform = true
+	cfg.GitLabClient = &Client{
+		baseURL:    server.URL,
+		repository: server.URL + "/group/project.git",
+		httpClient: server.Client(),
+	}
+	defer CleanupRepository(cfg)
+
+	if err := MirrorRepository(context.Background(), cfg); err != nil {
+		t.Fatalf("MirrorRepository() error = %v", err)
+	}
+
+	for name, want := range map[string]bool{
+		"docker-compose.yml":                  true,
+		"deploy/main.tf":                      true,
+		"README.md":                           false,
+		"node_modules/pkg/docker-compose.yml": false,
+	} {
+		_, err := repofs.Stat(filepath.Join(cfg.TempDir, filepath.FromSlash(name)))
+		if got := err == nil; got != want {
+			t.Errorf("%s downloaded = %v, want %v", name, got, want)
+		}
+	}
+	if content, err := repofs.ReadFile(filepath.Join(cfg.TempDir, "docker-compose.yml")); err != nil || string(content) != files["docker-compose.yml"] {
+		t.Errorf("docker-compose.yml = %q, %v, want %q", content, err, files["docker-compose.yml"])
+	}
+
+	// The files are held in memory, nothing is written to the disk
+	if _, err := os.Stat(cfg.TempDir); !os.IsNotExist(err) {
+		t.Errorf("mirror directory %s exists on the disk: %v", cfg.TempDir, err)
+	}
+
+	if cfg.ScanDir != cfg.TempDir {
+		t.Errorf("ScanDir = %q, want %q", cfg.ScanDir, cfg.TempDir)
+	}
+
+	CleanupRepository(cfg)
+	if repofs.IsMounted(cfg.TempDir) {
+		t.Error("files still held in memory after cleanup")
+	}
+}
@@ -0,0 +1,56 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// PipelineResponse represents a pipeline as returned by the GitLab API
+type PipelineResponse struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+	WebURL string `json:"web_url"`
+}
+
+// CreateMergeRequestNoteWithContext posts a comment on a merge request with context
+func (c *Client) CreateMergeRequestNoteWithContext(ctx context.Context, iid int, body string) error {
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return err
+	}
+
+	// Build API URL
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/merge_requests/%d/notes",
+		c.baseURL, projectInfo.Encoded, iid)
+
+	// Send request
+	if err := c.doRequest(ctx, http.MethodPost, apiURL, map[string]string{"body": body}, nil); err != nil {
+		return fmt.Errorf("failed to comment on merge request !%d: %w", iid, err)
+	}
+	return nil
+}
+
+// LatestMergeRequestPipelineWithContext returns the latest pipeline of a merge request,
+// nil if it has none
+func (c *Client) LatestMergeRequestPipelineWithContext(ctx context.Context, iid int) (*PipelineResponse, error) {
+	// Get project info
+	projectInfo, err := c.getProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	// Pipelines are listed from the latest one
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/merge_requests/%d/pipelines?per_page=1",
+		c.baseURL, projectInfo.Encoded, iid)
+
+	var pipelines []PipelineResponse
+	if err := c.doRequest(ctx, http.MethodGet, apiURL, nil, &pipelines); err != nil {
+		return nil, fmt.Errorf("failed to list pipelines of merge request !%d: %w", iid, err)
+	}
+	if len(pipelines) == 0 {
+		return nil, nil
+	}
+	return &pipelines[0], nil
+}
@@ -1,23 +1,42 @@
 package gitlab
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/audit"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/trace"
 )
 
 const (
 	// DefaultGitTimeout is the default timeout for git operations
 	DefaultGitTimeout = 60 * time.Second
 
-	// GitCredentialsFile is the default path for git credentials file
-	GitCredentialsFile = ".git-credentials"
+	// DefaultGitRetryWait is the wait before retrying a failed network operation, doubled on each attempt
+	DefaultGitRetryWait = 2 * time.Second
+
+	// gitWaitDelay bounds the wait for the output of a git command killed on timeout,
+	// as helpers like git-remote-https may keep its pipes open
+	gitWaitDelay = 5 * time.Second
+
+	// EnvGitUsername and EnvGitPassword pass the GitLab credentials to the credential
+	// helper of the git processes started by img-upgr only
+	EnvGitUsername = "IMG_UPGR_GIT_USERNAME"
+	EnvGitPassword = "IMG_UPGR_GIT_PASSWORD"
+
+	// SSHSigningKeyFile is the name of the SSH signing key copied into the clone's .git directory
+	SSHSigningKeyFile = "img-upgr-signing-key"
 )
 
 // GitError represents an error that occurred during a git operation
@@ -40,48 +59,93 @@ func (e *GitError) Unwrap() error {
 	return e.Err
 }
 
-// CloneRepository clones a GitLab repository to a temporary directory
-func CloneRepository(cfg *config.Config) error {
+// CloneRepository clones a GitLab repository to a temporary directory, or updates
+// its copy in the workspace when a work directory is configured
+func CloneRepository(ctx context.Context, cfg *config.Config) error {
 	logger.Info("Cloning repository %s", cfg.GitLabRepo)
 
-	// Create temporary directory
-	tempDir, err := os.MkdirTemp("", "img-upgr-*")
-	if err != nil {
-		return fmt.Errorf("failed to create temporary directory: %w", err)
+	var repoDir string
+	var err error
+	if cfg.WorkDir != "" {
+		repoDir, err = prepareWorkspace(ctx, cfg)
+	} else {
+		repoDir, err = cloneToTempDir(ctx, cfg)
 	}
-	cfg.TempDir = tempDir
-	logger.Debug("Created temporary directory: %s", tempDir)
-
-	// Set up git credentials
-	if err := setupGitCredentials(cfg); err != nil {
+	if err != nil {
 		return err
 	}
 
-	// Clone repository
-	logger.Info("Cloning repository %s to %s", cfg.GitLabRepo, tempDir)
-	if err := runGitCommand(tempDir, "clone", cfg.GitLabRepo, tempDir); err != nil {
-		return fmt.Errorf("failed to clone repository: %w", err)
+	// Configure git user in the repository
+	if err := configureGitUser(ctx, cfg, repoDir); err != nil {
+		CleanupRepository(cfg)
+		return err
 	}
-	logger.Debug("Repository cloned successfully")
 
-	// Configure git user in the repository
-	if err := configureGitUser(cfg, tempDir); err != nil {
+	// Configure commit signing if a signing key was provided
+	if err := configureCommitSigning(ctx, cfg, repoDir); err != nil {
+		CleanupRepository(cfg)
 		return err
 	}
 
 	// Update scan directory to be inside the cloned repository
-	updateScanDirectory(cfg, tempDir)
+	updateScanDirectory(cfg, repoDir)
 
 	cfg.ClonedRepo = true
 	logger.Info("Repository setup complete")
 	return nil
 }
 
-// CleanupRepository removes the temporary directory
+// cloneToTempDir clones the repository to a new temporary directory
+func cloneToTempDir(ctx context.Context, cfg *config.Config) (string, error) {
+	// Create temporary directory
+	tempDir, err := os.MkdirTemp("", "img-upgr-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	cfg.TempDir = tempDir
+	logger.Debug("Created temporary directory: %s", tempDir)
+
+	if err := cloneInto(ctx, cfg, tempDir); err != nil {
+		return "", err
+	}
+	return tempDir, nil
+}
+
+// cloneInto clones the repository into an empty directory
+func cloneInto(ctx context.Context, cfg *config.Config, dir string) error {
+	// Clone repository, starting over from an empty directory when retrying
+	logger.Info("Cloning repository %s to %s", cfg.GitLabRepo, dir)
+	err := retryGitOperation(ctx, cfg, "clone", func(attempt int) error {
+		if attempt > 0 {
+			if err := clearDirectory(dir); err != nil {
+				return err
+			}
+		}
+		return runRemoteGitCommand(ctx, cfg, dir, "clone", cfg.GitLabRepo, dir)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clone repository: %w", err)
+	}
+
+	logger.Debug("Repository cloned successfully")
+	return nil
+}
+
+// CleanupRepository removes the temporary directory. Repositories kept in the
+// workspace are left in place and unlocked for the next run.
 func CleanupRepository(cfg *config.Config) {
+	cleanupGPGHome(cfg)
 	if cfg.TempDir == "" {
 		return
 	}
+	if repofs.IsMounted(cfg.TempDir) {
+		repofs.Unmount(cfg.TempDir)
+		return
+	}
+	if inWorkspace(cfg, cfg.TempDir) {
+		unlockWorkspace(cfg.TempDir)
+		return
+	}
 
 	logger.Debug("Cleaning up temporary directory: %s", cfg.TempDir)
 	if err := os.RemoveAll(cfg.TempDir); err != nil {
@@ -91,8 +155,23 @@ func CleanupRepository(cfg *config.Config) {
 	}
 }
 
+// cleanupGPGHome stops the GPG agent of the temporary keyring and removes it
+func cleanupGPGHome(cfg *config.Config) {
+	if cfg.GPGHome == "" {
+		return
+	}
+
+	if output, err := exec.Command("gpgconf", "--homedir", cfg.GPGHome, "--kill", "gpg-agent").CombinedOutput(); err != nil {
+		logger.Debug("Failed to stop GPG agent: %v (output: %s)", err, output)
+	}
+	if err := os.RemoveAll(cfg.GPGHome); err != nil {
+		logger.Warn("Failed to clean up GPG home directory: %v", err)
+	}
+	cfg.GPGHome = ""
+}
+
 // CreateBranchInRepo creates a new branch in the cloned repository
-func CreateBranchInRepo(cfg *config.Config, branchName, baseBranch string) error {
+func CreateBranchInRepo(ctx context.Context, cfg *config.Config, branchName, baseBranch string) error {
 	logger.Debug("Creating branch %s from %s", branchName, baseBranch)
 	if err := validateRepoCloned(cfg); err != nil {
 		return err
@@ -100,19 +179,19 @@ func CreateBranchInRepo(cfg *config.Config, branchName, baseBranch string) error
 
 	// Checkout base branch
 	logger.Debug("Checking out base branch: %s", baseBranch)
-	if err := runGitCommand(cfg.TempDir, "checkout", baseBranch); err != nil {
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, "checkout", baseBranch); err != nil {
 		return fmt.Errorf("failed to checkout base branch: %w", err)
 	}
 
 	// Pull latest changes
 	logger.Debug("Pulling latest changes from origin/%s", baseBranch)
-	if err := runGitCommand(cfg.TempDir, "pull", "origin", baseBranch); err != nil {
+	if err := retryRemoteGitCommand(ctx, cfg, cfg.TempDir, "pull", "origin", baseBranch); err != nil {
 		return fmt.Errorf("failed to pull latest changes: %w", err)
 	}
 
 	// Create new branch
 	logger.Debug("Creating new branch: %s", branchName)
-	if err := runGitCommand(cfg.TempDir, "checkout", "-b", branchName); err != nil {
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, "checkout", "-b", branchName); err != nil {
 		return fmt.Errorf("failed to create branch: %w", err)
 	}
 
@@ -120,58 +199,121 @@ func CreateBranchInRepo(cfg *config.Config, branchName, baseBranch string) error
 	return nil
 }
 
-// CommitAndPushChanges commits and pushes changes to the remote repository
-func CommitAndPushChanges(cfg *config.Config, message string) error {
+// ResetBranchInRepo checks out an existing branch reset to the latest state of the base branch
+func ResetBranchInRepo(ctx context.Context, cfg *config.Config, branchName, baseBranch string) error {
+	logger.Debug("Resetting branch %s onto %s", branchName, baseBranch)
+	if err := validateRepoCloned(cfg); err != nil {
+		return err
+	}
+
+	// Checkout base branch
+	logger.Debug("Checking out base branch: %s", baseBranch)
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, "checkout", baseBranch); err != nil {
+		return fmt.Errorf("failed to checkout base branch: %w", err)
+	}
+
+	// Pull latest changes
+	logger.Debug("Pulling latest changes from origin/%s", baseBranch)
+	if err := retryRemoteGitCommand(ctx, cfg, cfg.TempDir, "pull", "origin", baseBranch); err != nil {
+		return fmt.Errorf("failed to pull latest changes: %w", err)
+	}
+
+	// Create or reset the branch at the base branch head
+	logger.Debug("Resetting branch: %s", branchName)
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, "checkout", "-B", branchName); err != nil {
+		return fmt.Errorf("failed to reset branch: %w", err)
+	}
+
+	logger.Info("Reset branch %s onto %s successfully", branchName, baseBranch)
+	return nil
+}
+
+// CommitAndPushChanges commits the changes of files, given relative to the root of the
+// repository, and pushes them to the remote repository
+func CommitAndPushChanges(ctx context.Context, cfg *config.Config, message string, files []string) error {
+	return commitAndPush(ctx, cfg, message, files, false)
+}
+
+// CommitAndForcePushChanges commits changes and force pushes them, replacing the remote branch history
+func CommitAndForcePushChanges(ctx context.Context, cfg *config.Config, message string, files []string) error {
+	return commitAndPush(ctx, cfg, message, files, true)
+}
+
+// commitAndPush commits the changes of files and pushes them to the remote repository.
+// Only these files are staged, so other files left in the clone, such as the outputs of
+// hooks, are never committed.
+func commitAndPush(ctx context.Context, cfg *config.Config, message string, files []string, force bool) error {
 	logger.Debug("Committing and pushing changes with message: %s", message)
 	if err := validateRepoCloned(cfg); err != nil {
 		return err
 	}
+	if len(files) == 0 {
+		return fmt.Errorf("no files to commit")
+	}
 
-	// Add all changes
-	logger.Debug("Adding all changes")
-	if err := runGitCommand(cfg.TempDir, "add", "."); err != nil {
+	// Add the changed files
+	logger.Debug("Adding changes of %s", strings.Join(files, ", "))
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, append([]string{"add", "--"}, files...)...); err != nil {
 		return fmt.Errorf("failed to add changes: %w", err)
 	}
 
-	// Commit changes
+	// Commit changes, authored by the commit author when one is set while the GitLab
+	// user stays the committer
 	logger.Debug("Committing changes with message: %s", message)
-	cmd := exec.Command("git", "commit", "-m", message)
-	cmd.Dir = cfg.TempDir
-	output, err := cmd.CombinedOutput()
-
-	if err != nil {
+	commitArgs := []string{"commit", "-m", message}
+	if cfg.CommitAuthor != "" {
+		name, email := cfg.Author()
+		commitArgs = append(commitArgs, fmt.Sprintf("--author=%s <%s>", name, email))
+	}
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, commitArgs...); err != nil {
 		// Check if there are no changes to commit
-		if strings.Contains(string(output), "nothing to commit") {
+		var gitErr *GitError
+		if errors.As(err, &gitErr) && strings.Contains(gitErr.Output, "nothing to commit") {
 			logger.Warn("No changes to commit")
 			return fmt.Errorf("no changes to commit")
 		}
-		return &GitError{
-			Operation: "commit",
-			Err:       err,
-			Output:    string(output),
-		}
+		return err
 	}
 	logger.Debug("Changes committed successfully")
 
 	// Push changes
-	logger.Debug("Pushing changes to origin")
-	if err := runGitCommand(cfg.TempDir, "push", "origin", "HEAD"); err != nil {
+	pushArgs := []string{"push", "origin", "HEAD"}
+	if force {
+		pushArgs = append(pushArgs, "--force")
+	}
+	logger.Debug("Pushing changes to origin (force: %v)", force)
+	if err := retryRemoteGitCommand(ctx, cfg, cfg.TempDir, pushArgs...); err != nil {
 		return fmt.Errorf("failed to push changes: %w", err)
 	}
 
 	logger.Info("Changes pushed successfully")
+	recordPush(ctx, cfg, files)
 	return nil
 }
 
+// recordPush records the pushed branch and its modified files in the audit log
+func recordPush(ctx context.Context, cfg *config.Config, files []string) {
+	project := extractProjectPath(cfg.GitLabRepo)
+	branch, err := GetCurrentBranch(ctx, cfg)
+	if err != nil {
+		logger.Debug("Failed to get the pushed branch: %v", err)
+	}
+	runID := runid.FromContext(ctx)
+	audit.Record(audit.Event{Action: audit.BranchPushed, RunID: runID, Project: project, Branch: branch})
+	for _, file := range files {
+		audit.Record(audit.Event{Action: audit.FileModified, RunID: runID, Project: project, Branch: branch, File: file})
+	}
+}
+
 // GetCurrentBranch returns the current branch name
-func GetCurrentBranch(cfg *config.Config) (string, error) {
+func GetCurrentBranch(ctx context.Context, cfg *config.Config) (string, error) {
 	logger.Debug("Getting current branch name")
 	if err := validateRepoCloned(cfg); err != nil {
 		return "", err
 	}
 
 	// Get current branch
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "HEAD")
 	cmd.Dir = cfg.TempDir
 	output, err := cmd.Output()
 	if err != nil {
@@ -183,58 +325,51 @@ func GetCurrentBranch(cfg *config.Config) (string, error) {
 	return branchName, nil
 }
 
-// GetDefaultBranch returns the default branch of the repository
-func GetDefaultBranch(cfg *config.Config) (string, error) {
+// GetDefaultBranch returns the default branch of the project, read from the GitLab API
+// and cached by the client. Without a client, or when the API cannot be reached, the
+// HEAD of the remote recorded by the clone is used.
+func GetDefaultBranch(ctx context.Context, cfg *config.Config) (string, error) {
 	logger.Debug("Getting default branch for repository")
-	if err := validateRepoCloned(cfg); err != nil {
-		return "", err
-	}
-
-	// First try to get the default branch from git remote show origin
-	cmd := exec.Command("git", "remote", "show", "origin")
-	cmd.Dir = cfg.TempDir
 
-	output, err := cmd.Output()
-	if err == nil {
-		// Parse the output to find the default branch
-		outputStr := string(output)
-		lines := strings.Split(outputStr, "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if strings.HasPrefix(line, "HEAD branch:") {
-				defaultBranch := strings.TrimSpace(strings.TrimPrefix(line, "HEAD branch:"))
-				logger.Debug("Found default branch from remote: %s", defaultBranch)
-				return defaultBranch, nil
-			}
+	var apiErr error
+	if client, ok := cfg.GitLabClient.(*Client); ok {
+		branch, err := client.GetDefaultBranchWithContext(ctx)
+		if err == nil {
+			return branch, nil
 		}
+		apiErr = err
+	} else {
+		apiErr = fmt.Errorf("GitLab client not initialized")
 	}
 
-	// If that fails, try to get the symbolic-ref of HEAD
-	cmd = exec.Command("git", "symbolic-ref", "refs/remotes/origin/HEAD", "--short")
-	cmd.Dir = cfg.TempDir
+	// Without a clone there is nothing else to read the default branch from
+	if cfg.APIOnly {
+		return "", apiErr
+	}
+	if err := validateRepoCloned(cfg); err != nil {
+		return "", fmt.Errorf("failed to get default branch: %w", apiErr)
+	}
 
-	output, err = cmd.Output()
-	if err == nil {
-		defaultBranch := strings.TrimSpace(string(output))
-		// Remove the origin/ prefix
-		defaultBranch = strings.TrimPrefix(defaultBranch, "origin/")
-		logger.Debug("Found default branch from symbolic ref: %s", defaultBranch)
-		return defaultBranch, nil
+	cmd := exec.CommandContext(ctx, "git", "symbolic-ref", "refs/remotes/origin/HEAD", "--short")
+	cmd.Dir = cfg.TempDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get default branch: %w (no origin/HEAD in the clone: %v)", apiErr, err)
 	}
 
-	// If all else fails, assume "main" as the default branch
-	logger.Warn("Could not determine default branch, using 'main' as fallback")
-	return "main", nil
+	defaultBranch := strings.TrimPrefix(strings.TrimSpace(string(output)), "origin/")
+	logger.Warn("Could not get the default branch from GitLab, using %s from the clone: %v", defaultBranch, apiErr)
+	return defaultBranch, nil
 }
 
 // GetRepoStatus returns the git status of the repository
-func GetRepoStatus(cfg *config.Config) (string, error) {
+func GetRepoStatus(ctx context.Context, cfg *config.Config) (string, error) {
 	logger.Debug("Getting repository status")
 	if err := validateRepoCloned(cfg); err != nil {
 		return "", err
 	}
 
-	cmd := exec.Command("git", "status", "--porcelain")
+	cmd := exec.CommandContext(ctx, "git", "status", "--porcelain")
 	cmd.Dir = cfg.TempDir
 	output, err := cmd.Output()
 	if err != nil {
@@ -245,8 +380,8 @@ func GetRepoStatus(cfg *config.Config) (string, error) {
 }
 
 // HasChanges checks if there are uncommitted changes in the repository
-func HasChanges(cfg *config.Config) (bool, error) {
-	status, err := GetRepoStatus(cfg)
+func HasChanges(ctx context.Context, cfg *config.Config) (bool, error) {
+	status, err := GetRepoStatus(ctx, cfg)
 	if err != nil {
 		return false, err
 	}
@@ -254,46 +389,79 @@ func HasChanges(cfg *config.Config) (bool, error) {
 	return strings.TrimSpace(status) != "", nil
 }
 
-// setupGitCredentials configures git to use stored credentials
-func setupGitCredentials(cfg *config.Config) error {
-	logger.Debug("Configuring git credentials")
-	if err := runGitCommand("", "config", "--global", "credential.helper", "store"); err != nil {
-		return fmt.Errorf("failed to configure git credentials: %w", err)
+// configureGitUser sets up the git user name and email in the repository
+func configureGitUser(ctx context.Context, cfg *config.Config, repoDir string) error {
+	// Set up git user name
+	logger.Debug("Setting git user name to %s", cfg.GitLabUser)
+	if err := runGitCommand(ctx, cfg, repoDir, "config", "user.name", cfg.GitLabUser); err != nil {
+		return fmt.Errorf("failed to set git user name: %w", err)
 	}
 
-	// Create credentials file
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get user home directory: %w", err)
+	// Set up git email
+	logger.Debug("Setting git user email to %s", cfg.GitLabEmail)
+	if err := runGitCommand(ctx, cfg, repoDir, "config", "user.email", cfg.GitLabEmail); err != nil {
+		return fmt.Errorf("failed to set git user email: %w", err)
 	}
 
-	credentialsFile := filepath.Join(homeDir, GitCredentialsFile)
-	logger.Debug("Writing credentials to %s", credentialsFile)
-
-	credentialURL := fmt.Sprintf("https://%s:%s@%s\n",
-		cfg.GitLabUser,
-		cfg.GitLabToken,
-		extractHostFromURL(cfg.GitLabRepo))
+	return nil
+}
 
-	if err := os.WriteFile(credentialsFile, []byte(credentialURL), 0600); err != nil {
-		return fmt.Errorf("failed to write git credentials: %w", err)
+// configureCommitSigning sets up GPG or SSH signing of commits in the repository
+func configureCommitSigning(ctx context.Context, cfg *config.Config, repoDir string) error {
+	if cfg.SigningKey == "" {
+		return nil
+	}
+
+	signingKey := cfg.SigningKeyID
+	// git names the GPG format openpgp
+	gitFormat := "openpgp"
+	switch cfg.SigningFormat {
+	case "ssh":
+		gitFormat = "ssh"
+		// ssh-keygen refuses keys readable by others, so use a private copy kept outside the work tree
+		keyData, err := os.ReadFile(cfg.SigningKey)
+		if err != nil {
+			return fmt.Errorf("failed to read SSH signing key: %w", err)
+		}
+		keyFile := filepath.Join(repoDir, ".git", SSHSigningKeyFile)
+		if err := os.WriteFile(keyFile, keyData, 0600); err != nil {
+			return fmt.Errorf("failed to write SSH signing key: %w", err)
+		}
+		signingKey = keyFile
+	default:
+		// Import the GPG key into a keyring of its own rather than the one of the current
+		// user, the git commands of the run being pointed at it
+		gpgHome, err := os.MkdirTemp("", "img-upgr-gnupg-*")
+		if err != nil {
+			return fmt.Errorf("failed to create GPG home directory: %w", err)
+		}
+		cfg.GPGHome = gpgHome
+
+		logger.Debug("Importing GPG signing key from %s into %s", cfg.SigningKey, gpgHome)
+		cmd := exec.CommandContext(ctx, "gpg", "--batch", "--homedir", gpgHome, "--import", cfg.SigningKey)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return &GitError{
+				Operation: "gpg import",
+				Err:       err,
+				Output:    string(output),
+			}
+		}
 	}
 
-	return nil
-}
+	logger.Debug("Enabling %s commit signing", cfg.SigningFormat)
+	if err := runGitCommand(ctx, cfg, repoDir, "config", "gpg.format", gitFormat); err != nil {
+		return fmt.Errorf("failed to set signing format: %w", err)
+	}
 
-// configureGitUser sets up the git user name and email in the repository
-func configureGitUser(cfg *config.Config, repoDir string) error {
-	// Set up git user name
-	logger.Debug("Setting git user name to %s", cfg.GitLabUser)
-	if err := runGitCommand(repoDir, "config", "user.name", cfg.GitLabUser); err != nil {
-		return fmt.Errorf("failed to set git user name: %w", err)
+	// Without an explicit key, gpg selects the key matching the committer email
+	if signingKey != "" {
+		if err := runGitCommand(ctx, cfg, repoDir, "config", "user.signingkey", signingKey); err != nil {
+			return fmt.Errorf("failed to set signing key: %w", err)
+		}
 	}
 
-	// Set up git email
-	logger.Debug("Setting git user email to %s", cfg.GitLabEmail)
-	if err := runGitCommand(repoDir, "config", "user.email", cfg.GitLabEmail); err != nil {
-		return fmt.Errorf("failed to set git user email: %w", err)
+	if err := runGitCommand(ctx, cfg, repoDir, "config", "commit.gpgsign", "true"); err != nil {
+		return fmt.Errorf("failed to enable commit signing: %w", err)
 	}
 
 	return nil
@@ -323,15 +491,94 @@ func validateRepoCloned(cfg *config.Config) error {
 	return nil
 }
 
-// runGitCommand runs a git command with the given arguments
-func runGitCommand(dir string, args ...string) error {
-	cmd := exec.Command("git", args...)
+// runGitCommand runs a git command with the given arguments and the configured timeout
+func runGitCommand(ctx context.Context, cfg *config.Config, dir string, args ...string) error {
+	return runCommand(ctx, cfg, args, func(ctx context.Context) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		if dir != "" {
+			cmd.Dir = dir
+		}
+		cmd.Env = gitEnv(cfg)
+		return cmd
+	})
+}
+
+// gitEnv returns the environment of git commands, pointing gpg at the temporary
+// keyring of the run when commits are signed with GPG
+func gitEnv(cfg *config.Config) []string {
+	env := os.Environ()
+	if cfg.GPGHome != "" {
+		env = append(env, "GNUPGHOME="+cfg.GPGHome)
+	}
+	return env
+}
+
+// runRemoteGitCommand runs a git command talking to the GitLab remote with the given arguments
+func runRemoteGitCommand(ctx context.Context, cfg *config.Config, dir string, args ...string) error {
+	return runCommand(ctx, cfg, args, func(ctx context.Context) *exec.Cmd {
+		return remoteGitCommand(ctx, cfg, dir, args...)
+	})
+}
+
+// retryRemoteGitCommand runs a git command talking to the GitLab remote, retrying it on network failures
+func retryRemoteGitCommand(ctx context.Context, cfg *config.Config, dir string, args ...string) error {
+	return retryGitOperation(ctx, cfg, args[0], func(int) error {
+		return runRemoteGitCommand(ctx, cfg, dir, args...)
+	})
+}
+
+// remoteGitCommand returns a git command authenticated against the GitLab host. The
+// credential helpers of the user are replaced by one answering with the GitLab credentials,
+// which are only passed through the environment of the command so they are neither written
+// to disk nor left in the git configuration of the user or the clone.
+func remoteGitCommand(ctx context.Context, cfg *config.Config, dir string, args ...string) *exec.Cmd {
+	helper := fmt.Sprintf(`!f() { test "$1" = get && echo "username=${%s}" && echo "password=${%s}"; }; f`,
+		EnvGitUsername, EnvGitPassword)
+
+	gitArgs := []string{"-c", "credential.helper="}
+	if remote := credentialURL(cfg.GitLabRepo); remote != "" {
+		gitArgs = append(gitArgs, "-c", fmt.Sprintf("credential.%s.helper=%s", remote, helper))
+	}
+
+	cmd := exec.CommandContext(ctx, "git", append(gitArgs, args...)...)
 	if dir != "" {
 		cmd.Dir = dir
 	}
+	cmd.Env = append(gitEnv(cfg),
+		EnvGitUsername+"="+cfg.GitLabUser,
+		EnvGitPassword+"="+cfg.GitLabToken,
+		// Fail instead of waiting for input when the credentials are rejected
+		"GIT_TERMINAL_PROMPT=0",
+	)
+
+	return cmd
+}
+
+// errGitTimeout is wrapped by the errors of git commands killed after the configured timeout
+var errGitTimeout = errors.New("timed out")
+
+// runCommand runs the git command built by newCmd, killing it after the configured
+// timeout or when ctx is done, and wraps its failure with the output. The timeout is
+// derived from ctx so the command never outlives the run.
+func runCommand(ctx context.Context, cfg *config.Config, args []string, newCmd func(ctx context.Context) *exec.Cmd) error {
+	// Spans are named after the subcommand, grouping the commands of each kind
+	ctx, span := trace.Start(ctx, "git "+args[0], trace.String("git.command", args[0]))
 
+	timeout := gitTimeout(cfg)
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := newCmd(cmdCtx)
+	cmd.WaitDelay = gitWaitDelay
 	output, err := cmd.CombinedOutput()
 	if err != nil {
+		// The end of the run is reported as is rather than as a timeout of the command
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w after %s", errGitTimeout, timeout)
+		}
+		span.End(err)
 		return &GitError{
 			Operation: "git " + strings.Join(args, " "),
 			Err:       err,
@@ -339,19 +586,91 @@ func runGitCommand(dir string, args ...string) error {
 		}
 	}
 
+	span.End(nil)
 	return nil
 }
 
-// extractHostFromURL extracts the host from a URL
-func extractHostFromURL(url string) string {
-	// Remove protocol
-	url = strings.TrimPrefix(url, "https://")
-	url = strings.TrimPrefix(url, "http://")
+// gitTimeout returns the configured timeout of git commands
+func gitTimeout(cfg *config.Config) time.Duration {
+	if cfg.GitTimeout > 0 {
+		return cfg.GitTimeout
+	}
+	return DefaultGitTimeout
+}
+
+// transientGitErrors are output fragments of git commands failing for network reasons
+var transientGitErrors = []string{
+	"Could not resolve host",
+	"Connection timed out",
+	"Connection reset",
+	"Connection refused",
+	"Failed to connect",
+	"Operation timed out",
+	"early EOF",
+	"remote end hung up unexpectedly",
+	"RPC failed",
+	"The requested URL returned error: 5",
+	"TLS connection was non-properly terminated",
+}
+
+// isTransientGitError returns true if a git command failed for a reason worth retrying
+func isTransientGitError(err error) bool {
+	if errors.Is(err, errGitTimeout) {
+		return true
+	}
+
+	var gitErr *GitError
+	if !errors.As(err, &gitErr) {
+		return false
+	}
+	for _, fragment := range transientGitErrors {
+		if strings.Contains(gitErr.Output, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryGitOperation runs a git network operation, retrying it with an exponential
+// backoff while it fails for transient reasons
+func retryGitOperation(ctx context.Context, cfg *config.Config, operation string, run func(attempt int) error) error {
+	wait := DefaultGitRetryWait
+	for attempt := 0; ; attempt++ {
+		err := run(attempt)
+		if err == nil || attempt >= cfg.GitRetries || ctx.Err() != nil || !isTransientGitError(err) {
+			return err
+		}
+
+		logger.Warn("git %s failed, retrying in %s (%d/%d): %v", operation, wait, attempt+1, cfg.GitRetries, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// clearDirectory removes the content of a directory
+func clearDirectory(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to clean directory: %w", err)
+		}
+	}
+	return nil
+}
 
-	// Remove path
-	if i := strings.Index(url, "/"); i != -1 {
-		url = url[:i]
+// credentialURL returns the scheme and host of a repository URL the credentials are scoped to
+func credentialURL(repoURL string) string {
+	parsedURL, err := url.Parse(repoURL)
+	if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
+		return ""
 	}
 
-	return url
+	return parsedURL.Scheme + "://" + parsedURL.Host
 }
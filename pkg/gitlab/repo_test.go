@@ -0,0 +1,329 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+)
+
+func TestRemoteGitCommandCredentials(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	cfg := config.New()
+	cfg.GitLabRepo = "https://gitlab.example.com/group/project.git"
+	cfg.GitLabUser = "img-upgr-bot"
+	cfg.GitLabToken = "glpat-secret"
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"gitlab.example.com", "username=img-upgr-bot\npassword=glpat-secret\n"},
+		{"other.example.com", ""},
+	}
+
+	for _, tt := range tests {
+		cmd := remoteGitCommand(context.Background(), cfg, t.TempDir(), "credential", "fill")
+		if strings.Contains(strings.Join(cmd.Args, " "), cfg.GitLabToken) {
+			t.Fatalf("token passed as argument: %v", cmd.Args)
+		}
+		cmd.Stdin = strings.NewReader("protocol=https\nhost=" + tt.host + "\n\n")
+
+		output, err := cmd.Output()
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("credential fill for %s = %q, want no credentials", tt.host, output)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("credential fill for %s error = %v", tt.host, err)
+		}
+		if !strings.Contains(string(output), tt.want) {
+			t.Errorf("credential fill for %s = %q, want %q", tt.host, output, tt.want)
+		}
+	}
+}
+
+func TestRunGitCommandTimeout(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	cfg := config.New()
+	cfg.GitTimeout = time.Nanosecond
+
+	err := runGitCommand(context.Background(), cfg, t.TempDir(), "init")
+	if !errors.Is(err, errGitTimeout) {
+		t.Fatalf("runGitCommand() error = %v, want timeout", err)
+	}
+	if !isTransientGitError(err) {
+		t.Errorf("timeout should be retried")
+	}
+}
+
+func TestRunGitCommandDeadline(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	// The deadline of the run applies even though the git timeout is longer
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	cfg := config.New()
+	cfg.GitTimeout = time.Hour
+	err := runGitCommand(ctx, cfg, t.TempDir(), "init")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("runGitCommand() error = %v, want the deadline of the run", err)
+	}
+	if isTransientGitError(err) {
+		t.Errorf("the end of the run should not be retried")
+	}
+}
+
+func TestRunGitCommandCancelled(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cfg := config.New()
+	cfg.GitRetries = 3
+	attempts := 0
+	err := retryGitOperation(ctx, cfg, "init", func(int) error {
+		attempts++
+		return runGitCommand(ctx, cfg, t.TempDir(), "init")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("retryGitOperation() error = %v, want cancellation", err)
+	}
+	if attempts != 1 {
+		t.Errorf("cancelled command attempted %d times, want 1", attempts)
+	}
+}
+
+func TestIsTransientGitError(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{"fatal: unable to access 'https://gitlab.example.com/': Could not resolve host: gitlab.example.com", true},
+		{"error: RPC failed; HTTP 502 curl 22 The requested URL returned error: 502", true},
+		{"fatal: the remote end hung up unexpectedly", true},
+		{"fatal: Authentication failed for 'https://gitlab.example.com/'", false},
+		{"! [rejected] HEAD -> main (non-fast-forward)", false},
+	}
+
+	for _, tt := range tests {
+		err := &GitError{Operation: "git push", Err: errors.New("exit status 128"), Output: tt.output}
+		if got := isTransientGitError(err); got != tt.want {
+			t.Errorf("isTransientGitError(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestGetDefaultBranch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	requests := 0
+	available := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if !available {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(ProjectResponse{ID: 1, DefaultBranch: "master"})
+	}))
+	defer server.Close()
+
+	newClient := func() *Client {
+		return &Client{
+			baseURL:    server.URL,
+			repository: server.URL + "/group/project.git",
+			httpClient: server.Client(),
+		}
+	}
+
+	// The clone of a repository whose remote HEAD is main
+	cfg := config.New()
+	cfg.TempDir = t.TempDir()
+	cfg.ClonedRepo = true
+	ctx := context.Background()
+	for _, args := range [][]string{
+		{"init"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--allow-empty", "-m", "init"},
+		{"update-ref", "refs/remotes/origin/main", "HEAD"},
+		{"symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/main"},
+	} {
+		if err := runGitCommand(ctx, cfg, cfg.TempDir, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg.GitLabClient = newClient()
+	for range 2 {
+		branch, err := GetDefaultBranch(ctx, cfg)
+		if err != nil || branch != "master" {
+			t.Fatalf("GetDefaultBranch() = %q, %v, want master from the API", branch, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("project requested %d times, want the default branch cached", requests)
+	}
+
+	available = false
+	cfg.GitLabClient = newClient()
+	if branch, err := GetDefaultBranch(ctx, cfg); err != nil || branch != "main" {
+		t.Errorf("GetDefaultBranch() = %q, %v, want main from the clone", branch, err)
+	}
+
+	cfg.APIOnly = true
+	if branch, err := GetDefaultBranch(ctx, cfg); err == nil {
+		t.Errorf("GetDefaultBranch() = %q, want an error without API nor clone", branch)
+	}
+}
+
+func TestCommitAndPushChangesStagesFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	ctx := context.Background()
+	cfg := config.New()
+	origin := t.TempDir()
+	cfg.TempDir = t.TempDir()
+	cfg.ClonedRepo = true
+
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, output)
+		}
+		return string(output)
+	}
+	git(origin, "init", "--bare")
+	git(cfg.TempDir, "init")
+	git(cfg.TempDir, "remote", "add", "origin", origin)
+	git(cfg.TempDir, "commit", "--allow-empty", "-m", "init")
+
+	for name, content := range map[string]string{"docker-compose.yml": "services: {}\n", "hook-output.txt": "ok\n"} {
+		if err := os.WriteFile(filepath.Join(cfg.TempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git(cfg.TempDir, "config", "user.name", "test")
+	git(cfg.TempDir, "config", "user.email", "test@example.com")
+	cfg.CommitAuthor = "img-upgr bot <bot@example.com>"
+	if err := CommitAndPushChanges(ctx, cfg, "Update", []string{"docker-compose.yml"}); err != nil {
+		t.Fatalf("CommitAndPushChanges() error = %v", err)
+	}
+
+	if files := strings.Fields(git(cfg.TempDir, "show", "--name-only", "--format=", "HEAD")); len(files) != 1 || files[0] != "docker-compose.yml" {
+		t.Errorf("committed files = %v, want only docker-compose.yml", files)
+	}
+	if identities := git(cfg.TempDir, "log", "-1", "--format=%an <%ae>|%cn <%ce>"); identities != "img-upgr bot <bot@example.com>|test <test@example.com>\n" {
+		t.Errorf("author|committer = %q, want the commit author and the configured user", identities)
+	}
+	if status := git(cfg.TempDir, "status", "--porcelain"); !strings.Contains(status, "?? hook-output.txt") {
+		t.Errorf("status = %q, want hook-output.txt left untracked", status)
+	}
+
+	if err := CommitAndPushChanges(ctx, cfg, "Update", nil); err == nil {
+		t.Error("CommitAndPushChanges() without files should fail")
+	}
+}
+
+func TestConfigureCommitSigningGPG(t *testing.T) {
+	for _, tool := range []string{"git", "gpg", "gpgconf"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+
+	// The keyring of the user must be left untouched
+	userHome := t.TempDir()
+	t.Setenv("GNUPGHOME", userHome)
+	gpg := func(home string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("gpg", append([]string{"--batch", "--homedir", home}, args...)...)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("gpg %v: %v: %s", args, err, output)
+		}
+		return string(output)
+	}
+
+	// Export a key generated in a keyring of its own
+	keyHome := t.TempDir()
+	gpg(keyHome, "--passphrase", "", "--quick-gen-key", "img-upgr bot <bot@example.com>", "ed25519", "sign", "never")
+	keyFile := filepath.Join(t.TempDir(), "signing.asc")
+	gpg(keyHome, "--armor", "--output", keyFile, "--export-secret-keys", "bot@example.com")
+	t.Cleanup(func() { _ = exec.Command("gpgconf", "--homedir", keyHome, "--kill", "gpg-agent").Run() })
+
+	ctx := context.Background()
+	cfg := config.New()
+	cfg.TempDir = t.TempDir()
+	cfg.ClonedRepo = true
+	cfg.GitLabUser = "img-upgr bot"
+	cfg.GitLabEmail = "bot@example.com"
+	cfg.SigningKey = keyFile
+	cfg.SigningFormat = "gpg"
+
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, "init"); err != nil {
+		t.Fatal(err)
+	}
+	if err := configureGitUser(ctx, cfg, cfg.TempDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := configureCommitSigning(ctx, cfg, cfg.TempDir); err != nil {
+		t.Fatalf("configureCommitSigning() error = %v", err)
+	}
+	gpgHome := cfg.GPGHome
+	if gpgHome == "" {
+		t.Fatal("no temporary GPG home directory")
+	}
+
+	// Commits of the run are signed with the key of the temporary keyring
+	if err := runGitCommand(ctx, cfg, cfg.TempDir, "commit", "--allow-empty", "-m", "init"); err != nil {
+		t.Fatalf("signed commit error = %v", err)
+	}
+	cmd := exec.Command("git", "cat-file", "commit", "HEAD")
+	cmd.Dir = cfg.TempDir
+	if output, err := cmd.Output(); err != nil || !strings.Contains(string(output), "-----BEGIN PGP SIGNATURE-----") {
+		t.Errorf("commit = %s, %v, want a GPG signature", output, err)
+	}
+
+	if keys := gpg(userHome, "--list-secret-keys"); strings.Contains(keys, "bot@example.com") {
+		t.Errorf("signing key imported into the keyring of the user: %s", keys)
+	}
+
+	CleanupRepository(cfg)
+	if _, err := os.Stat(gpgHome); !os.IsNotExist(err) {
+		t.Errorf("GPG home directory %s left after cleanup: %v", gpgHome, err)
+	}
+	if cfg.GPGHome != "" {
+		t.Errorf("GPGHome = %s after cleanup", cfg.GPGHome)
+	}
+}
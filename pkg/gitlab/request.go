@@ -0,0 +1,257 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+const (
+	// DefaultMaxRetries is the default number of retries of a failed request
+	DefaultMaxRetries = 3
+
+	// DefaultRetryWait is the default wait before the first retry, doubled on each attempt
+	DefaultRetryWait = time.Second
+
+	// maxRetryWait caps the wait between two attempts and before a rate limit reset
+	maxRetryWait = time.Minute
+)
+
+// WithRetry sets the number of retries of failed requests and the wait before the first retry
+func WithRetry(maxRetries int, wait time.Duration) ClientOption {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryWait = wait
+	}
+}
+
+// rateLimit tracks the RateLimit headers returned by GitLab so requests are held
+// back once the quota is exhausted instead of being rejected
+type rateLimit struct {
+	mu       sync.Mutex
+	resumeAt time.Time
+}
+
+// update records the reset time when the response reports no remaining requests
+func (r *rateLimit) update(header http.Header) {
+	if header.Get("RateLimit-Remaining") != "0" {
+		return
+	}
+	reset, err := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resumeAt = time.Unix(reset, 0)
+}
+
+// wait blocks until the rate limit is reset or the context is done
+func (r *rateLimit) wait(ctx context.Context) error {
+	r.mu.Lock()
+	delay := time.Until(r.resumeAt)
+	r.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	delay = min(delay, maxRetryWait)
+	logger.Info("GitLab API rate limit reached, waiting %s", delay.Round(time.Second))
+	return sleep(ctx, delay)
+}
+
+// send performs an HTTP request to the GitLab API. Requests rejected by the rate
+// limit are retried, as are server errors and network failures of idempotent
+// requests. The caller must close the body of the returned response.
+func (c *Client) send(ctx context.Context, method, apiURL string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.rateLimit.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+
+		// Create request with context
+		req, err := http.NewRequestWithContext(ctx, method, apiURL, reqBody)
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %w", err)
+		}
+
+		// Set headers
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("PRIVATE-TOKEN", c.token)
+
+		// Send request
+		logger.Debug("Sending %s request to %s", method, apiURL)
+		resp, err := c.httpClient.Do(req)
+
+		var wait time.Duration
+		if err != nil {
+			if ctx.Err() != nil || !isIdempotent(method) || attempt >= c.maxRetries {
+				return nil, fmt.Errorf("error sending request: %w", err)
+			}
+			wait = c.backoff(attempt)
+			logger.Warn("Request to GitLab failed, retrying in %s (%d/%d): %v", wait, attempt+1, c.maxRetries, err)
+		} else {
+			c.rateLimit.update(resp.Header)
+			if !isRetryable(method, resp.StatusCode) || attempt >= c.maxRetries {
+				return resp, nil
+			}
+
+			wait = retryAfter(resp.Header, time.Now())
+			if wait <= 0 {
+				wait = c.backoff(attempt)
+			}
+			logger.Warn("GitLab API returned status %d, retrying in %s (%d/%d)", resp.StatusCode, wait, attempt+1, c.maxRetries)
+
+			// Drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			if err := resp.Body.Close(); err != nil {
+				logger.Warn("Failed to close response body: %v", err)
+			}
+		}
+
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff returns the exponential wait before the retry following an attempt
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := float64(c.retryWait) * math.Pow(2, float64(attempt))
+	if wait > float64(maxRetryWait) {
+		return maxRetryWait
+	}
+	return time.Duration(wait)
+}
+
+// isIdempotent returns true for methods that can safely be sent again
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isRetryable returns true if a response status is worth another attempt. Rate
+// limited requests were not processed and are always retried, server errors only
+// for idempotent requests as the change may have been applied.
+func isRetryable(method string, statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return isIdempotent(method)
+	}
+	return false
+}
+
+// retryAfter returns the wait requested by the Retry-After or RateLimit-Reset
+// headers, or zero if the response does not specify one
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	var wait time.Duration
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			wait = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(value); err == nil {
+			wait = date.Sub(now)
+		}
+	} else if reset, err := strconv.ParseInt(header.Get("RateLimit-Reset"), 10, 64); err == nil {
+		wait = time.Unix(reset, 0).Sub(now)
+	}
+
+	return min(wait, maxRetryWait)
+}
+
+// sleep waits for the given duration or until the context is done
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// getAllPages performs a GET request and follows the pagination of the GitLab API,
+// returning the items of every page
+func getAllPages[T any](ctx context.Context, c *Client, apiURL string) ([]T, error) {
+	var items []T
+	for apiURL != "" {
+		var page []T
+		header, err := c.doRequestWithHeader(ctx, http.MethodGet, apiURL, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+
+		apiURL, err = nextPageURL(apiURL, header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+// nextPageURL returns the URL of the next page from the Link header, used by
+// keyset pagination, or the X-Next-Page header. It is empty on the last page.
+func nextPageURL(apiURL string, header http.Header) (string, error) {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		target, params, found := strings.Cut(link, ";")
+		if found && strings.Contains(params, `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(target), "<>"), nil
+		}
+	}
+
+	nextPage := header.Get("X-Next-Page")
+	if nextPage == "" {
+		return "", nil
+	}
+
+	parsedURL, err := url.Parse(apiURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid page URL: %w", err)
+	}
+	query := parsedURL.Query()
+	query.Set("page", nextPage)
+	parsedURL.RawQuery = query.Encode()
+	return parsedURL.String(), nil
+}
+
+// decodeErrorResponse builds the API error of a failed response
+func decodeErrorResponse(resp *http.Response) error {
+	var errorResp map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&errorResp); err != nil {
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    "failed to decode error response",
+		}
+	}
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Response:   errorResp,
+	}
+}
@@ -0,0 +1,353 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(server *httptest.Server) *Client {
+	return &Client{
+		baseURL:    server.URL,
+		token:      "token",
+		httpClient: server.Client(),
+		maxRetries: 2,
+		retryWait:  time.Millisecond,
+	}
+}
+
+func TestSendRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"iid": 7}`)
+	}))
+	defer server.Close()
+
+	var mr MergeRequestResponse
+	if err := newTestClient(server).doRequest(context.Background(), http.MethodGet, server.URL, nil, &mr); err != nil {
+		t.Fatalf("doRequest() error = %v", err)
+	}
+	if mr.IID != 7 || calls.Load() != 3 {
+		t.Errorf("doRequest() = %+v after %d calls, want IID 7 after 3 calls", mr, calls.Load())
+	}
+}
+
+func TestSendDoesNotRetryNonIdempotentServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	err := newTestClient(server).doRequest(context.Background(), http.MethodPost, server.URL, map[string]string{}, nil)
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("doRequest() error = %v, want status 502", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("POST sent %d times, want 1", calls.Load())
+	}
+}
+
+func TestSendRetriesRateLimitedRequests(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	if err := newTestClient(server).doRequest(context.Background(), http.MethodPost, server.URL, map[string]string{}, nil); err != nil {
+		t.Fatalf("doRequest() error = %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("POST sent %d times, want 2", calls.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"seconds", http.Header{"Retry-After": {"5"}}, 5 * time.Second},
+		{"date", http.Header{"Retry-After": {now.Add(10 * time.Second).UTC().Format(http.TimeFormat)}}, 10 * time.Second},
+		{"rate limit reset", http.Header{"Ratelimit-Reset": {strconv.FormatInt(now.Unix()+3, 10)}}, 3 * time.Second},
+		{"capped", http.Header{"Retry-After": {"3600"}}, maxRetryWait},
+		{"none", http.Header{}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := retryAfter(tt.header, now); got != tt.want {
+			t.Errorf("retryAfter(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetAllPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("X-Next-Page", "2")
+			_, _ = fmt.Fprint(w, `[{"iid": 1}, {"iid": 2}]`)
+		case "2":
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/?page=3>; rel="next", <http://%s/?page=1>; rel="first"`, r.Host, r.Host))
+			_, _ = fmt.Fprint(w, `[{"iid": 3}]`)
+		default:
+			_, _ = fmt.Fprint(w, `[{"iid": 4}]`)
+		}
+	}))
+	defer server.Close()
+
+	mergeRequests, err := getAllPages[MergeRequestResponse](context.Background(), newTestClient(server), server.URL+"/?per_page=2")
+	if err != nil {
+		t.Fatalf("getAllPages() error = %v", err)
+	}
+	if len(mergeRequests) != 4 {
+		t.Fatalf("getAllPages() returned %d items, want 4", len(mergeRequests))
+	}
+	for i, mr := range mergeRequests {
+		if mr.IID != i+1 {
+			t.Errorf("item %d has IID %d, want %d", i, mr.IID, i+1)
+		}
+	}
+}
+
+func TestBranchExists(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() == "/api/v4/projects/group%2Fproject/repository/branches/release%2F1.0" {
+			_, _ = fmt.Fprint(w, `{"name": "release/1.0"}`)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprint(w, `{"message": "404 Branch Not Found"}`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.repository = server.URL + "/group/project.git"
+
+	for branch, want := range map[string]bool{"release/1.0": true, "missing": false} {
+		exists, err := client.BranchExistsWithContext(context.Background(), branch)
+		if err != nil {
+			t.Fatalf("BranchExistsWithContext(%s) error = %v", branch, err)
+		}
+		if exists != want {
+			t.Errorf("BranchExistsWithContext(%s) = %v, want %v", branch, exists, want)
+		}
+	}
+}
+
+func TestListAndDeleteBranches(t *testing.T) {
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/group/project/repository/branches":
+			if search := r.URL.Query().Get("search"); search != "^img-upgr/" {
+				t.Errorf("search = %q, want ^img-upgr/", search)
+			}
+			_, _ = fmt.Fprint(w, `[{"name": "img-upgr/nginx-1.25.4", "commit": {"committed_date": "2026-01-02T03:04:05Z"}},
+				{"name": "IMG-UPGR/other"}]`)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.EscapedPath())
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.repository = server.URL + "/group/project.git"
+
+	branches, err := client.ListBranchesWithContext(context.Background(), "img-upgr/")
+	if err != nil {
+		t.Fatalf("ListBranchesWithContext() error = %v", err)
+	}
+	if len(branches) != 1 || branches[0].Name != "img-upgr/nginx-1.25.4" || branches[0].Commit.CommittedDate.Year() != 2026 {
+		t.Fatalf("ListBranchesWithContext() = %+v, want img-upgr/nginx-1.25.4 only", branches)
+	}
+
+	if err := client.DeleteBranchWithContext(context.Background(), branches[0].Name); err != nil {
+		t.Fatalf("DeleteBranchWithContext() error = %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "/api/v4/projects/group%2Fproject/repository/branches/img-upgr%2Fnginx-1.25.4" {
+		t.Errorf("deleted = %v, want the escaped branch", deleted)
+	}
+}
+
+func TestCreateMergeRequestWithOptions(t *testing.T) {
+	var created map[string]interface{}
+	autoMerged := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/users":
+			if r.URL.Query().Get("username") == "alice" {
+				_, _ = fmt.Fprint(w, `[{"id": 7}]`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `[]`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v4/projects/group/project/merge_requests":
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				t.Errorf("invalid request body: %v", err)
+			}
+			_, _ = fmt.Fprint(w, `{"iid": 12}`)
+		case r.Method == http.MethodPut && r.URL.Path == "/api/v4/projects/group/project/merge_requests/12/merge":
+			autoMerged = true
+			_, _ = fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.repository = server.URL + "/group/project.git"
+
+	opts := MergeRequestOptions{Labels: []string{"minor"}, Assignees: []string{"alice", "unknown"}, Draft: true, AutoMerge: true, Squash: true}
+	if _, err := client.CreateMergeRequestWithOptions(context.Background(), "img-upgr/nginx-1.26.0", "main", "Update nginx", "", opts); err != nil {
+		t.Fatalf("CreateMergeRequestWithOptions() error = %v", err)
+	}
+
+	if created["title"] != "Draft: Update nginx" || created["labels"] != "minor" || created["squash"] != true {
+		t.Errorf("created = %v, want a labeled and squashed draft", created)
+	}
+	if _, ok := created["remove_source_branch"]; ok {
+		t.Errorf("created = %v, want the project default for removing the source branch", created)
+	}
+	if ids, _ := created["assignee_ids"].([]interface{}); len(ids) != 1 || ids[0] != float64(7) {
+		t.Errorf("assignee_ids = %v, want [7]", created["assignee_ids"])
+	}
+	if !autoMerged {
+		t.Error("auto-merge was not set")
+	}
+}
+
+func TestIssues(t *testing.T) {
+	var created, updated map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/group/project/issues":
+			if r.URL.Query().Get("labels") != IssueLabel || r.URL.Query().Get("state") != "opened" {
+				t.Errorf("query = %s, want open issues labeled %s", r.URL.RawQuery, IssueLabel)
+			}
+			_, _ = fmt.Fprint(w, `[{"iid": 3, "title": "old"}]`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v4/projects/group/project/issues":
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				t.Errorf("invalid request body: %v", err)
+			}
+			_, _ = fmt.Fprint(w, `{"iid": 4}`)
+		case r.Method == http.MethodPut && r.URL.Path == "/api/v4/projects/group/project/issues/3":
+			if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+				t.Errorf("invalid request body: %v", err)
+			}
+			_, _ = fmt.Fprint(w, `{"iid": 3}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.repository = server.URL + "/group/project.git"
+	ctx := context.Background()
+
+	issues, err := client.ListIssuesWithContext(ctx, IssueLabel)
+	if err != nil || len(issues) != 1 || issues[0].IID != 3 {
+		t.Fatalf("ListIssuesWithContext() = %+v, %v", issues, err)
+	}
+	if _, err := client.CreateIssueWithContext(ctx, "new", "body", []string{IssueLabel, "ops"}); err != nil {
+		t.Fatalf("CreateIssueWithContext() error = %v", err)
+	}
+	if created["title"] != "new" || created["labels"] != "img-upgr,ops" {
+		t.Errorf("created issue = %v, want the title and labels", created)
+	}
+	if _, err := client.UpdateIssueWithContext(ctx, 3, "old", "changed"); err != nil {
+		t.Fatalf("UpdateIssueWithContext() error = %v", err)
+	}
+	if updated["description"] != "changed" {
+		t.Errorf("updated issue = %v, want the new description", updated)
+	}
+}
+
+func TestGroupMembers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v4/groups/team/backend/members/all" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = fmt.Fprint(w, `[{"username": "alice", "state": "active"}, {"username": "bob", "state": "blocked"}, {"username": "carol", "state": "active"}]`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	ctx := context.Background()
+
+	members, found, err := client.GroupMembersWithContext(ctx, "team/backend")
+	if err != nil || !found || !slices.Equal(members, []string{"alice", "carol"}) {
+		t.Errorf("GroupMembersWithContext() = %v, %v, %v, want the active members", members, found, err)
+	}
+
+	// Users are not groups
+	if _, found, err := client.GroupMembersWithContext(ctx, "alice"); err != nil || found {
+		t.Errorf("GroupMembersWithContext() for a user = %v, %v, want not found", found, err)
+	}
+}
+
+func TestMergeRequestNoteAndPipeline(t *testing.T) {
+	var note map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/group/project/merge_requests/5/pipelines":
+			_, _ = fmt.Fprint(w, `[{"id": 42, "status": "failed", "web_url": "https://gitlab.example.com/p/42"}]`)
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v4/projects/group/project/merge_requests/6/pipelines":
+			_, _ = fmt.Fprint(w, `[]`)
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v4/projects/group/project/merge_requests/5/notes":
+			if err := json.NewDecoder(r.Body).Decode(&note); err != nil {
+				t.Errorf("invalid request body: %v", err)
+			}
+			_, _ = fmt.Fprint(w, `{"id": 1}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.repository = server.URL + "/group/project.git"
+	ctx := context.Background()
+
+	pipeline, err := client.LatestMergeRequestPipelineWithContext(ctx, 5)
+	if err != nil || pipeline == nil || pipeline.ID != 42 || pipeline.Status != "failed" {
+		t.Fatalf("LatestMergeRequestPipelineWithContext() = %+v, %v, want pipeline 42", pipeline, err)
+	}
+	if pipeline, err := client.LatestMergeRequestPipelineWithContext(ctx, 6); err != nil || pipeline != nil {
+		t.Errorf("LatestMergeRequestPipelineWithContext() = %+v, %v, want no pipeline", pipeline, err)
+	}
+
+	if err := client.CreateMergeRequestNoteWithContext(ctx, 5, "refreshed"); err != nil {
+		t.Fatalf("CreateMergeRequestNoteWithContext() error = %v", err)
+	}
+	if note["body"] != "refreshed" {
+		t.Errorf("note = %v, want the body", note)
+	}
+}
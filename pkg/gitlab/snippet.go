@@ -0,0 +1,115 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/state"
+)
+
+// snippetResponse represents a project snippet as returned by the GitLab API
+type snippetResponse struct {
+	ID       int    `json:"id"`
+	FileName string `json:"file_name"`
+}
+
+// SnippetStore keeps the img-upgr state in a GitLab project snippet
+type SnippetStore struct {
+	client    *Client
+	snippetID int
+}
+
+// NewSnippetStore creates a state store backed by an existing project snippet
+func NewSnippetStore(client *Client, snippetID int) *SnippetStore {
+	return &SnippetStore{
+		client:    client,
+		snippetID: snippetID,
+	}
+}
+
+// snippetURL returns the API URL of the snippet
+func (s *SnippetStore) snippetURL() (string, error) {
+	projectInfo, err := s.client.getProjectInfo()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/api/v4/projects/%s/snippets/%d",
+		s.client.baseURL, projectInfo.Encoded, s.snippetID), nil
+}
+
+// Load reads the state from the snippet content
+func (s *SnippetStore) Load(ctx context.Context) (*state.State, error) {
+	logger.Debug("Loading state from snippet %d", s.snippetID)
+
+	apiURL, err := s.snippetURL()
+	if err != nil {
+		return nil, err
+	}
+
+	// Send request
+	resp, err := s.client.send(ctx, http.MethodGet, apiURL+"/raw", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching snippet: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode >= 400 {
+		return nil, &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("failed to get snippet %d", s.snippetID),
+		}
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snippet content: %w", err)
+	}
+
+	return state.Unmarshal(content)
+}
+
+// Save replaces the snippet content with the state
+func (s *SnippetStore) Save(ctx context.Context, st *state.State) error {
+	logger.Debug("Saving state to snippet %d", s.snippetID)
+
+	apiURL, err := s.snippetURL()
+	if err != nil {
+		return err
+	}
+
+	// The file name is needed to update the snippet file in place
+	var snippet snippetResponse
+	if err := s.client.doRequest(ctx, http.MethodGet, apiURL, nil, &snippet); err != nil {
+		return fmt.Errorf("failed to get snippet: %w", err)
+	}
+
+	data, err := st.Marshal()
+	if err != nil {
+		return err
+	}
+
+	// Prepare request body
+	requestBody := map[string]interface{}{
+		"files": []map[string]string{
+			{
+				"action":    "update",
+				"file_path": snippet.FileName,
+				"content":   string(data),
+			},
+		},
+	}
+
+	if err := s.client.doRequest(ctx, http.MethodPut, apiURL, requestBody, nil); err != nil {
+		return fmt.Errorf("failed to update snippet: %w", err)
+	}
+
+	return nil
+}
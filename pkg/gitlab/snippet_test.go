@@ -0,0 +1,88 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/state"
+)
+
+func TestSnippetStoreRoundTrip(t *testing.T) {
+	// The snippet holds a single state.json file whose content is replaced on save
+	var content string
+	var saves int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "token" {
+			http.Error(w, `{"message": "401 Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.EscapedPath() {
+		case "GET /api/v4/projects/group%2Fproject/snippets/42/raw":
+			_, _ = w.Write([]byte(content))
+		case "GET /api/v4/projects/group%2Fproject/snippets/42":
+			_ = json.NewEncoder(w).Encode(snippetResponse{ID: 42, FileName: "state.json"})
+		case "PUT /api/v4/projects/group%2Fproject/snippets/42":
+			var body struct {
+				Files []map[string]string `json:"files"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Files) != 1 {
+				t.Errorf("invalid snippet update: %v", err)
+			}
+			file := body.Files[0]
+			if file["action"] != "update" || file["file_path"] != "state.json" {
+				t.Errorf("snippet update = %v, want an update of state.json", file)
+			}
+			content = file["content"]
+			saves++
+			_, _ = w.Write([]byte(`{"id": 42}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server)
+	client.repository = server.URL + "/group/project.git"
+	store := NewSnippetStore(client, 42)
+	ctx := context.Background()
+
+	// An empty snippet is an empty state
+	st, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() of an empty snippet error = %v", err)
+	}
+	if len(st.Images) != 0 {
+		t.Errorf("Load() of an empty snippet = %+v", st.Images)
+	}
+
+	usage := state.Usage{Repository: client.repository, File: "compose.yml", Service: "web"}
+	st.RecordLatestTag("nginx:1.25", "1.27.0")
+	st.RecordUsage("nginx:1.25", usage)
+	if err := store.Save(ctx, st); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if saves != 1 {
+		t.Fatalf("snippet saved %d times, want 1", saves)
+	}
+
+	loaded, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	entry, ok := loaded.Get("nginx:1.25")
+	if !ok || entry.LatestTag != "1.27.0" || !reflect.DeepEqual(entry.Usages, []state.Usage{usage}) {
+		t.Errorf("loaded entry = %+v, want the saved one", entry)
+	}
+
+	// A missing snippet is an error rather than an empty state
+	if _, err := NewSnippetStore(client, 7).Load(ctx); err == nil {
+		t.Error("Load() of a missing snippet succeeded")
+	}
+	if err := NewSnippetStore(client, 7).Save(ctx, st); err == nil {
+		t.Error("Save() to a missing snippet succeeded")
+	}
+}
@@ -0,0 +1,232 @@
+package gitlab
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// workspaceLockRetry is how often a run waiting for a repository of the work directory
+// checks whether the run using it is done
+const workspaceLockRetry = time.Second
+
+var (
+	// workspaceLocks holds the lock files of the repositories of the work directory in use
+	workspaceLocks = make(map[string]*os.File)
+	// workspaceLocksMu guards workspaceLocks
+	workspaceLocksMu sync.Mutex
+)
+
+// prepareWorkspace returns the copy of the repository kept in the work directory,
+// fetching the latest changes into an existing copy or cloning it on the first run. The
+// copy is locked against other runs sharing the work directory until CleanupRepository.
+func prepareWorkspace(ctx context.Context, cfg *config.Config) (string, error) {
+	repoDir, err := workspaceRepoDir(cfg)
+	if err != nil {
+		return "", err
+	}
+	if err := lockWorkspace(ctx, repoDir); err != nil {
+		return "", err
+	}
+	cfg.TempDir = repoDir
+
+	if err := syncWorkspace(ctx, cfg, repoDir); err != nil {
+		unlockWorkspace(repoDir)
+		return "", err
+	}
+	return repoDir, nil
+}
+
+// syncWorkspace brings the copy of the repository up to date, cloning it again if needed
+func syncWorkspace(ctx context.Context, cfg *config.Config, repoDir string) error {
+	if isWorkspaceClone(ctx, cfg, repoDir) {
+		logger.Info("Updating repository %s in workspace %s", cfg.GitLabRepo, repoDir)
+		err := updateWorkspace(ctx, cfg, repoDir)
+		if err == nil {
+			return nil
+		}
+		logger.Warn("Failed to update workspace %s, cloning the repository again: %v", repoDir, err)
+	}
+
+	// Start over from an empty directory
+	if err := os.RemoveAll(repoDir); err != nil {
+		return fmt.Errorf("failed to clean workspace: %w", err)
+	}
+	if err := os.MkdirAll(repoDir, 0755); err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+	return cloneInto(ctx, cfg, repoDir)
+}
+
+// lockWorkspace waits until no other run, of this process or another one sharing the
+// work directory, uses the copy of a repository and locks it
+func lockWorkspace(ctx context.Context, repoDir string) error {
+	if err := os.MkdirAll(filepath.Dir(repoDir), 0755); err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+	file, err := os.OpenFile(repoDir+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open workspace lock: %w", err)
+	}
+
+	for waiting := false; ; waiting = true {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to lock workspace %s: %w", repoDir, err)
+		}
+		if locked {
+			break
+		}
+		if !waiting {
+			logger.Info("Waiting for another run using workspace %s", repoDir)
+		}
+
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return ctx.Err()
+		case <-time.After(workspaceLockRetry):
+		}
+	}
+
+	workspaceLocksMu.Lock()
+	workspaceLocks[repoDir] = file
+	workspaceLocksMu.Unlock()
+	return nil
+}
+
+// unlockWorkspace lets other runs use the copy of a repository
+func unlockWorkspace(repoDir string) {
+	workspaceLocksMu.Lock()
+	file, ok := workspaceLocks[repoDir]
+	delete(workspaceLocks, repoDir)
+	workspaceLocksMu.Unlock()
+
+	// Closing the file releases the lock
+	if ok {
+		if err := file.Close(); err != nil {
+			logger.Warn("Failed to unlock workspace %s: %v", repoDir, err)
+		}
+	}
+}
+
+// workspaceRepoDir returns the directory of the repository in the work directory,
+// named after the host and project path so several repositories can share it
+func workspaceRepoDir(cfg *config.Config) (string, error) {
+	parsedURL, err := url.Parse(cfg.GitLabRepo)
+	projectPath := extractProjectPath(cfg.GitLabRepo)
+	if err != nil || parsedURL.Host == "" || projectPath == "" {
+		return "", fmt.Errorf("cannot derive a workspace directory from repository URL %s", cfg.GitLabRepo)
+	}
+
+	// The directory is removed when its clone is broken and scan requests may set the
+	// repository URL, so its path must not climb out of the work directory
+	segments := strings.Split(projectPath, "/")
+	if slices.ContainsFunc(segments, func(segment string) bool {
+		return segment == "" || segment == "." || segment == ".." || strings.Contains(segment, `\`)
+	}) {
+		return "", fmt.Errorf("invalid project path %q in repository URL %s", projectPath, cfg.GitLabRepo)
+	}
+
+	workDir, err := filepath.Abs(cfg.WorkDir)
+	if err != nil {
+		return "", fmt.Errorf("invalid work directory: %w", err)
+	}
+
+	// Ports are kept in the directory name without the colon, which Windows does not allow
+	host := strings.ReplaceAll(parsedURL.Host, ":", "_")
+	if host == "." || host == ".." {
+		return "", fmt.Errorf("invalid host in repository URL %s", cfg.GitLabRepo)
+	}
+	repoDir := filepath.Join(workDir, host, filepath.FromSlash(projectPath))
+	if !inWorkspace(cfg, repoDir) {
+		return "", fmt.Errorf("repository URL %s leads outside of work directory %s", cfg.GitLabRepo, workDir)
+	}
+	return repoDir, nil
+}
+
+// inWorkspace returns true if a directory is inside the work directory
+func inWorkspace(cfg *config.Config, dir string) bool {
+	if cfg.WorkDir == "" {
+		return false
+	}
+
+	workDir, err := filepath.Abs(cfg.WorkDir)
+	if err != nil {
+		return false
+	}
+
+	relPath, err := filepath.Rel(workDir, dir)
+	return err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// isWorkspaceClone returns true if a directory holds a clone of the configured repository
+func isWorkspaceClone(ctx context.Context, cfg *config.Config, repoDir string) bool {
+	cmd := exec.CommandContext(ctx, "git", "remote", "get-url", "origin")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(output)) == cfg.GitLabRepo
+}
+
+// updateWorkspace fetches the latest changes of a clone kept in the workspace and resets
+// it to the state of a fresh clone: the default branch checked out without local changes
+// and no other local branch
+func updateWorkspace(ctx context.Context, cfg *config.Config, repoDir string) error {
+	if err := retryRemoteGitCommand(ctx, cfg, repoDir, "fetch", "--prune", "origin"); err != nil {
+		return fmt.Errorf("failed to fetch changes: %w", err)
+	}
+
+	// The default branch may have changed since the repository was cloned
+	if err := retryRemoteGitCommand(ctx, cfg, repoDir, "remote", "set-head", "origin", "--auto"); err != nil {
+		return fmt.Errorf("failed to update default branch: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "git", "symbolic-ref", "--short", "refs/remotes/origin/HEAD")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to get default branch: %w", err)
+	}
+	defaultBranch := strings.TrimPrefix(strings.TrimSpace(string(output)), "origin/")
+
+	// Reset the default branch and drop changes left by a previous run
+	if err := runGitCommand(ctx, cfg, repoDir, "checkout", "--force", "-B", defaultBranch, "origin/"+defaultBranch); err != nil {
+		return fmt.Errorf("failed to reset %s: %w", defaultBranch, err)
+	}
+	if err := runGitCommand(ctx, cfg, repoDir, "clean", "-ffdx"); err != nil {
+		return fmt.Errorf("failed to clean working tree: %w", err)
+	}
+
+	// Remove the other local branches so they are created again from the remote
+	cmd = exec.CommandContext(ctx, "git", "for-each-ref", "--format=%(refname:short)", "refs/heads")
+	cmd.Dir = repoDir
+	output, err = cmd.Output()
+	if err != nil {
+		return fmt.Errorf("failed to list local branches: %w", err)
+	}
+	for _, branch := range strings.Fields(string(output)) {
+		if branch == defaultBranch {
+			continue
+		}
+		if err := runGitCommand(ctx, cfg, repoDir, "branch", "-D", branch); err != nil {
+			return fmt.Errorf("failed to delete branch %s: %w", branch, err)
+		}
+	}
+
+	logger.Debug("Workspace %s is up to date with origin/%s", repoDir, defaultBranch)
+	return nil
+}
@@ -0,0 +1,163 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/config"
+)
+
+// git runs a git command for a test, failing it on error
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func TestUpdateWorkspace(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	// A bare repository stands for the GitLab remote
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	git(t, "", "init", "--bare", "--initial-branch=main", remote)
+	upstream := t.TempDir()
+	git(t, upstream, "clone", remote, ".")
+	git(t, upstream, "commit", "--allow-empty", "-m", "initial")
+	git(t, upstream, "push", "origin", "HEAD:main")
+
+	cfg := config.New()
+	cfg.GitLabRepo = remote
+	repoDir := t.TempDir()
+	if err := cloneInto(context.Background(), cfg, repoDir); err != nil {
+		t.Fatalf("cloneInto() error = %v", err)
+	}
+	if !isWorkspaceClone(context.Background(), cfg, repoDir) {
+		t.Fatal("isWorkspaceClone() = false for a clone of the repository")
+	}
+
+	// Leave the state of a previous run: a branch, a local change and an untracked file
+	git(t, repoDir, "checkout", "-b", "img-upgr/web-1")
+	if err := os.WriteFile(filepath.Join(repoDir, "leftover.yml"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The remote moves on meanwhile
+	git(t, upstream, "commit", "--allow-empty", "-m", "second")
+	git(t, upstream, "push", "origin", "HEAD:main")
+
+	if err := updateWorkspace(context.Background(), cfg, repoDir); err != nil {
+		t.Fatalf("updateWorkspace() error = %v", err)
+	}
+
+	if got, want := git(t, repoDir, "rev-parse", "HEAD"), git(t, upstream, "rev-parse", "HEAD"); got != want {
+		t.Errorf("HEAD = %s, want %s", got, want)
+	}
+	if got := git(t, repoDir, "rev-parse", "--abbrev-ref", "HEAD"); got != "main" {
+		t.Errorf("checked out branch = %s, want main", got)
+	}
+	if got := git(t, repoDir, "branch", "--list", "img-upgr/*"); got != "" {
+		t.Errorf("local branches left: %s", got)
+	}
+	if _, err := os.Stat(filepath.Join(repoDir, "leftover.yml")); !os.IsNotExist(err) {
+		t.Errorf("untracked file left in the working tree")
+	}
+}
+
+func TestInWorkspace(t *testing.T) {
+	cfg := config.New()
+	cfg.WorkDir = "/cache/img-upgr"
+
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"/cache/img-upgr/gitlab.example.com/group/project", true},
+		{"/cache/img-upgr-other/project", false},
+		{"/tmp/img-upgr-123", false},
+	}
+	for _, tt := range tests {
+		if got := inWorkspace(cfg, tt.dir); got != tt.want {
+			t.Errorf("inWorkspace(%q) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+
+	cfg.WorkDir = ""
+	if inWorkspace(cfg, "/cache/img-upgr/project") {
+		t.Error("inWorkspace() = true without a work directory")
+	}
+}
+
+func TestWorkspaceRepoDir(t *testing.T) {
+	workDir := t.TempDir()
+	tests := []struct {
+		repo string
+		want string
+	}{
+		{"https://gitlab.example.com/group/project.git", filepath.Join(workDir, "gitlab.example.com", "group", "project")},
+		{"https://gitlab.example.com:8443/group/sub/project", filepath.Join(workDir, "gitlab.example.com_8443", "group", "sub", "project")},
+	}
+
+	for _, tt := range tests {
+		cfg := config.New()
+		cfg.WorkDir = workDir
+		cfg.GitLabRepo = tt.repo
+		got, err := workspaceRepoDir(cfg)
+		if err != nil {
+			t.Fatalf("workspaceRepoDir(%q) error = %v", tt.repo, err)
+		}
+		if got != tt.want {
+			t.Errorf("workspaceRepoDir(%q) = %q, want %q", tt.repo, got, tt.want)
+		}
+	}
+
+	// Scan requests set the repository URL, whose directory is removed when broken
+	for _, repo := range []string{
+		"https://gitlab.example.com/group/../../../etc",
+		"https://gitlab.example.com/../project",
+		"https://gitlab.example.com/group/..",
+		"https://gitlab.example.com/group//project",
+		"https://gitlab.example.com/group/./project",
+		"https://../group/project",
+		"https://gitlab.example.com/",
+	} {
+		cfg := config.New()
+		cfg.WorkDir = workDir
+		cfg.GitLabRepo = repo
+		if got, err := workspaceRepoDir(cfg); err == nil {
+			t.Errorf("workspaceRepoDir(%q) = %q, want an error", repo, got)
+		}
+	}
+}
+
+func TestLockWorkspace(t *testing.T) {
+	repoDir := filepath.Join(t.TempDir(), "gitlab.example.com", "group", "project")
+	if err := lockWorkspace(context.Background(), repoDir); err != nil {
+		t.Fatalf("lockWorkspace() error = %v", err)
+	}
+
+	// Another run waits for the lock until it gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := lockWorkspace(ctx, repoDir); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second lockWorkspace() error = %v, want the deadline", err)
+	}
+
+	unlockWorkspace(repoDir)
+	if err := lockWorkspace(context.Background(), repoDir); err != nil {
+		t.Fatalf("lockWorkspace() after unlock error = %v", err)
+	}
+	unlockWorkspace(repoDir)
+}
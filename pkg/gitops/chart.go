@@ -0,0 +1,150 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTimeout is the timeout of Helm repository index requests
+const DefaultTimeout = 30 * time.Second
+
+// helmIndex represents the index.yaml of a Helm repository
+type helmIndex struct {
+	Entries map[string][]struct {
+		Version string `yaml:"version"`
+	} `yaml:"entries"`
+}
+
+// ChartClient lists the published versions of Helm charts from HTTP repositories
+// and, through the registry client, from OCI registries
+type ChartClient struct {
+	registryClient registry.Client
+	httpClient     *http.Client
+	indexes        map[string]*helmIndex
+	repoLocks      map[string]*sync.Mutex
+	mu             sync.Mutex
+}
+
+// NewChartClient creates a chart client using registryClient for OCI repositories
+func NewChartClient(registryClient registry.Client) *ChartClient {
+	return &ChartClient{
+		registryClient: registryClient,
+		httpClient:     &http.Client{Timeout: DefaultTimeout},
+		indexes:        make(map[string]*helmIndex),
+		repoLocks:      make(map[string]*sync.Mutex),
+	}
+}
+
+// FetchVersions returns the versions of a chart published in a repository
+func (c *ChartClient) FetchVersions(ctx context.Context, repoURL, chart string) ([]string, error) {
+	if isOCIRepository(repoURL) {
+		repo := strings.TrimSuffix(strings.TrimPrefix(repoURL, "oci://"), "/") + "/" + chart
+		logger.Debug("Fetching OCI chart tags for %s", repo)
+		return registry.FetchAllTags(ctx, c.registryClient, repo)
+	}
+	if !strings.HasPrefix(repoURL, "http://") && !strings.HasPrefix(repoURL, "https://") {
+		return nil, fmt.Errorf("unsupported chart repository URL %s", repoURL)
+	}
+
+	index, err := c.fetchIndex(ctx, repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, ok := index.Entries[chart]
+	if !ok {
+		return nil, fmt.Errorf("chart %s not found in %s", chart, repoURL)
+	}
+
+	versions := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		versions = append(versions, entry.Version)
+	}
+	return versions, nil
+}
+
+// fetchIndex downloads the index of an HTTP repository, once per run. Downloads of
+// the same repository are serialized while other repositories are fetched concurrently.
+func (c *ChartClient) fetchIndex(ctx context.Context, repoURL string) (*helmIndex, error) {
+	repoLock := c.repoLock(repoURL)
+	repoLock.Lock()
+	defer repoLock.Unlock()
+
+	if index := c.cachedIndex(repoURL); index != nil {
+		return index, nil
+	}
+
+	index, err := c.downloadIndex(ctx, repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.indexes[repoURL] = index
+	c.mu.Unlock()
+	return index, nil
+}
+
+// repoLock returns the lock serializing the index downloads of a repository
+func (c *ChartClient) repoLock(repoURL string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lock, ok := c.repoLocks[repoURL]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.repoLocks[repoURL] = lock
+	}
+	return lock
+}
+
+// cachedIndex returns the index of a repository downloaded earlier in the run, if any
+func (c *ChartClient) cachedIndex(repoURL string) *helmIndex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.indexes[repoURL]
+}
+
+// downloadIndex fetches and parses the index.yaml of an HTTP repository
+func (c *ChartClient) downloadIndex(ctx context.Context, repoURL string) (*helmIndex, error) {
+	indexURL := strings.TrimSuffix(repoURL, "/") + "/index.yaml"
+	logger.Debug("Fetching Helm repository index %s", indexURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, indexURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create repository index request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching repository index: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("repository index request failed with status code: %d", resp.StatusCode)
+	}
+
+	var index helmIndex
+	if err := yaml.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to parse repository index: %w", err)
+	}
+	return &index, nil
+}
+
+// isOCIRepository returns true for OCI chart repositories
+func isOCIRepository(repoURL string) bool {
+	return strings.HasPrefix(repoURL, "oci://")
+}
@@ -0,0 +1,166 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tagsRegistry answers the tags of OCI chart repositories
+type tagsRegistry map[string][]string
+
+func (r tagsRegistry) FetchAllTags(repo string) ([]string, error) {
+	tags, ok := r[repo]
+	if !ok {
+		return nil, fmt.Errorf("repository %s not found", repo)
+	}
+	return tags, nil
+}
+
+func (r tagsRegistry) FetchTagDigest(repo, tag string) (string, error) {
+	return "", fmt.Errorf("digests are not used for charts")
+}
+
+func TestFetchVersions(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/charts/index.yaml":
+			_, _ = w.Write([]byte("apiVersion: v1\nentries:\n  podinfo:\n    - version: 6.6.0\n    - version: 6.5.0\n"))
+		case "/invalid/index.yaml":
+			_, _ = w.Write([]byte("entries: [not a mapping\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewChartClient(tagsRegistry{"ghcr.io/example/charts/podinfo": {"6.6.0"}})
+	ctx := context.Background()
+
+	versions, err := client.FetchVersions(ctx, server.URL+"/charts/", "podinfo")
+	if err != nil {
+		t.Fatalf("FetchVersions() error = %v", err)
+	}
+	if want := []string{"6.6.0", "6.5.0"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("FetchVersions() = %v, want %v", versions, want)
+	}
+
+	// The index is downloaded once per repository
+	if _, err := client.FetchVersions(ctx, server.URL+"/charts/", "other"); err == nil || !strings.Contains(err.Error(), "chart other not found") {
+		t.Errorf("FetchVersions() of a missing chart error = %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("index requested %d times, want once", requests)
+	}
+
+	tests := []struct {
+		name    string
+		repoURL string
+		wantErr string
+	}{
+		{"missing index", server.URL + "/missing", "status code: 404"},
+		{"invalid index", server.URL + "/invalid", "failed to parse repository index"},
+		{"no scheme", "charts.example.com/stable", "unsupported chart repository URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := client.FetchVersions(ctx, tt.repoURL, "podinfo"); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("FetchVersions() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// OCI repositories are listed through the registry client
+	versions, err = client.FetchVersions(ctx, "oci://ghcr.io/example/charts/", "podinfo")
+	if err != nil || !reflect.DeepEqual(versions, []string{"6.6.0"}) {
+		t.Errorf("FetchVersions() of an OCI chart = %v, %v", versions, err)
+	}
+}
+
+func TestFetchVersionsCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("index requested with a cancelled context")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewChartClient(tagsRegistry{}).FetchVersions(ctx, server.URL, "podinfo"); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("FetchVersions() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestFetchVersionsConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	var slowRequests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow/index.yaml" {
+			slowRequests.Add(1)
+			<-release
+		}
+		_, _ = w.Write([]byte("entries:\n  podinfo:\n    - version: 6.6.0\n"))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewChartClient(tagsRegistry{})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.FetchVersions(ctx, server.URL+"/slow", "podinfo"); err != nil {
+				t.Errorf("FetchVersions() of the slow repository error = %v", err)
+			}
+		}()
+	}
+
+	// Another repository is fetched while the slow index is still downloading
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.FetchVersions(ctx, server.URL+"/fast", "podinfo")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("FetchVersions() of the fast repository error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fetching a repository waited for the download of another one")
+	}
+
+	release <- struct{}{}
+	wg.Wait()
+	if n := slowRequests.Load(); n != 1 {
+		t.Errorf("slow index requested %d times, want once", n)
+	}
+}
+
+func TestIsOCIRepository(t *testing.T) {
+	tests := []struct {
+		repoURL string
+		want    bool
+	}{
+		{"oci://ghcr.io/example/charts", true},
+		{"https://charts.example.com", false},
+		{"http://charts.example.com", false},
+		{"ghcr.io/example/charts", false},
+	}
+	for _, tt := range tests {
+		if got := isOCIRepository(tt.repoURL); got != tt.want {
+			t.Errorf("isOCIRepository(%q) = %v, want %v", tt.repoURL, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package gitops
+
+import (
+	"fmt"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/yamledit"
+)
+
+// ReplaceReference returns manifest content with the value of a reference replaced
+func ReplaceReference(content []byte, id, oldValue, newValue string) ([]byte, error) {
+	manifest, err := parse("", content)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range manifest.References {
+		if ref.ID != id {
+			continue
+		}
+		if ref.Value != oldValue {
+			return nil, fmt.Errorf("%s is set to %s, expected %s", id, ref.Value, oldValue)
+		}
+
+		return yamledit.ReplaceScalar(content, ref.node, oldValue, newValue)
+	}
+
+	return nil, fmt.Errorf("%s not found", id)
+}
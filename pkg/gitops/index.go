@@ -0,0 +1,93 @@
+package gitops
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Index collects the manifests of a scan so references can be resolved against
+// Flux sources declared in other files
+type Index struct {
+	manifests         map[string]*Manifest
+	helmRepositories  map[string]string
+	imageRepositories map[string]string
+	imagePolicies     map[string]string
+	mu                sync.RWMutex
+}
+
+// NewIndex creates an empty index
+func NewIndex() *Index {
+	return &Index{
+		manifests:         make(map[string]*Manifest),
+		helmRepositories:  make(map[string]string),
+		imageRepositories: make(map[string]string),
+		imagePolicies:     make(map[string]string),
+	}
+}
+
+// Add adds a manifest and its sources to the index
+func (i *Index) Add(manifest *Manifest) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.manifests[manifest.Path] = manifest
+	for key, url := range manifest.helmRepositories {
+		i.helmRepositories[key] = url
+	}
+	for key, image := range manifest.imageRepositories {
+		i.imageRepositories[key] = image
+	}
+	for key, repository := range manifest.imagePolicies {
+		i.imagePolicies[key] = repository
+	}
+}
+
+// Manifest returns the indexed manifest of a file
+func (i *Index) Manifest(path string) (*Manifest, bool) {
+	if i == nil {
+		return nil, false
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	manifest, ok := i.manifests[path]
+	return manifest, ok
+}
+
+// ChartRepository returns the repository URL of a chart reference
+func (i *Index) ChartRepository(ref Reference) (string, error) {
+	if ref.RepoURL != "" {
+		return ref.RepoURL, nil
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	url, ok := i.helmRepositories[ref.SourceRef]
+	if !ok || url == "" {
+		return "", fmt.Errorf("HelmRepository %s not found in scanned files", ref.SourceRef)
+	}
+	return url, nil
+}
+
+// Image returns the full image of an image reference, resolving the repository of
+// tag-only fields through their ImagePolicy and ImageRepository
+func (i *Index) Image(ref Reference) (string, error) {
+	if ref.Kind != KindImageTag {
+		return ref.Value, nil
+	}
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	repositoryRef, ok := i.imagePolicies[ref.Policy]
+	if !ok {
+		return "", fmt.Errorf("ImagePolicy %s not found in scanned files", ref.Policy)
+	}
+	image, ok := i.imageRepositories[repositoryRef]
+	if !ok || image == "" {
+		return "", fmt.Errorf("ImageRepository %s not found in scanned files", repositoryRef)
+	}
+	return image + ":" + ref.Value, nil
+}
@@ -0,0 +1,293 @@
+package gitops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/yamledit"
+	"gopkg.in/yaml.v3"
+)
+
+// Reference kinds
+const (
+	// KindChart is a Helm chart version of a HelmRelease or Application
+	KindChart = "chart"
+
+	// KindImage is a full image reference marked with a Flux image policy
+	KindImage = "image"
+
+	// KindImageTag is an image tag marked with a Flux image policy
+	KindImageTag = "image-tag"
+)
+
+// imagePolicyMarker is the key of the Flux image automation setter comments
+const imagePolicyMarker = "$imagepolicy"
+
+// Reference is a versioned value found in a GitOps manifest
+type Reference struct {
+	// ID identifies the reference within its file
+	ID string
+	// Kind is one of KindChart, KindImage or KindImageTag
+	Kind string
+	// Value is the current value of the field
+	Value string
+
+	// Chart, RepoURL and SourceRef describe chart references. SourceRef is the
+	// namespace/name of the Flux HelmRepository when RepoURL is not known yet.
+	Chart     string
+	RepoURL   string
+	SourceRef string
+
+	// Policy is the namespace/name of the Flux ImagePolicy of image references
+	Policy string
+
+	node *yaml.Node
+}
+
+// Line returns the 1-based line of the value of the reference in its file
+func (r Reference) Line() int {
+	if r.node == nil {
+		return 0
+	}
+	return r.node.Line
+}
+
+// Manifest holds the references and Flux sources found in a manifest file
+type Manifest struct {
+	Path       string
+	References []Reference
+
+	// helmRepositories maps namespace/name to the repository URL
+	helmRepositories map[string]string
+	// imageRepositories maps namespace/name of ImageRepository resources to their image
+	imageRepositories map[string]string
+	// imagePolicies maps namespace/name of ImagePolicy resources to their ImageRepository
+	imagePolicies map[string]string
+
+	hasResources bool
+}
+
+// IsManifest returns true if the file contains Kubernetes resources
+func (m *Manifest) IsManifest() bool {
+	return m.hasResources
+}
+
+// ParseFile parses the YAML documents of a manifest file
+func ParseFile(filename string) (*Manifest, error) {
+	data, err := repofs.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return parse(filename, data)
+}
+
+// parse parses the documents of manifest content
+func parse(filename string, data []byte) (*Manifest, error) {
+	manifest := &Manifest{
+		Path:              filename,
+		helmRepositories:  make(map[string]string),
+		imageRepositories: make(map[string]string),
+		imagePolicies:     make(map[string]string),
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var document yaml.Node
+		if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+
+		if len(document.Content) > 0 {
+			manifest.addDocument(document.Content[0])
+		}
+	}
+
+	return manifest, nil
+}
+
+// addDocument collects the references and sources of a single resource
+func (m *Manifest) addDocument(root *yaml.Node) {
+	apiVersion := yamledit.ScalarValue(yamledit.MappingValue(root, "apiVersion"))
+	kind := yamledit.ScalarValue(yamledit.MappingValue(root, "kind"))
+	if apiVersion == "" || kind == "" {
+		return
+	}
+	m.hasResources = true
+
+	name := yamledit.ScalarValue(yamledit.Lookup(root, "metadata", "name"))
+	namespace := namespaceOrDefault(yamledit.ScalarValue(yamledit.Lookup(root, "metadata", "namespace")))
+	resourceID := fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+	group, _, _ := strings.Cut(apiVersion, "/")
+
+	switch {
+	case kind == "HelmRelease" && group == "helm.toolkit.fluxcd.io":
+		m.addHelmRelease(root, resourceID, namespace)
+	case kind == "Application" && group == "argoproj.io":
+		m.addApplication(root, resourceID)
+	case kind == "HelmRepository" && group == "source.toolkit.fluxcd.io":
+		url := yamledit.ScalarValue(yamledit.Lookup(root, "spec", "url"))
+		if yamledit.ScalarValue(yamledit.Lookup(root, "spec", "type")) == "oci" && !strings.HasPrefix(url, "oci://") {
+			url = "oci://" + url
+		}
+		m.helmRepositories[namespace+"/"+name] = url
+	case kind == "ImageRepository" && group == "image.toolkit.fluxcd.io":
+		m.imageRepositories[namespace+"/"+name] = yamledit.ScalarValue(yamledit.Lookup(root, "spec", "image"))
+	case kind == "ImagePolicy" && group == "image.toolkit.fluxcd.io":
+		ref := yamledit.Lookup(root, "spec", "imageRepositoryRef")
+		refNamespace := yamledit.ScalarValue(yamledit.MappingValue(ref, "namespace"))
+		if refNamespace == "" {
+			refNamespace = namespace
+		}
+		m.imagePolicies[namespace+"/"+name] = refNamespace + "/" + yamledit.ScalarValue(yamledit.MappingValue(ref, "name"))
+	}
+
+	m.addImagePolicyMarkers(root, resourceID, "")
+}
+
+// addHelmRelease adds the chart version of a Flux HelmRelease
+func (m *Manifest) addHelmRelease(root *yaml.Node, resourceID, namespace string) {
+	chartSpec := yamledit.Lookup(root, "spec", "chart", "spec")
+	version := yamledit.Lookup(chartSpec, "version")
+	if version == nil || version.Kind != yaml.ScalarNode {
+		return
+	}
+
+	// Charts from GitRepository or Bucket sources have no published versions
+	sourceRef := yamledit.MappingValue(chartSpec, "sourceRef")
+	if sourceKind := yamledit.ScalarValue(yamledit.MappingValue(sourceRef, "kind")); sourceKind != "HelmRepository" {
+		return
+	}
+	sourceNamespace := yamledit.ScalarValue(yamledit.MappingValue(sourceRef, "namespace"))
+	if sourceNamespace == "" {
+		sourceNamespace = namespace
+	}
+
+	m.References = append(m.References, Reference{
+		ID:        resourceID,
+		Kind:      KindChart,
+		Value:     version.Value,
+		Chart:     yamledit.ScalarValue(yamledit.MappingValue(chartSpec, "chart")),
+		SourceRef: sourceNamespace + "/" + yamledit.ScalarValue(yamledit.MappingValue(sourceRef, "name")),
+		node:      version,
+	})
+}
+
+// addApplication adds the chart versions of the Helm sources of an Argo CD Application
+func (m *Manifest) addApplication(root *yaml.Node, resourceID string) {
+	if source := yamledit.Lookup(root, "spec", "source"); source != nil {
+		m.addApplicationSource(source, resourceID)
+	}
+
+	sources := yamledit.Lookup(root, "spec", "sources")
+	if sources == nil || sources.Kind != yaml.SequenceNode {
+		return
+	}
+	for i, source := range sources.Content {
+		m.addApplicationSource(source, resourceID+"#"+strconv.Itoa(i))
+	}
+}
+
+// addApplicationSource adds the chart version of an Application source if it is a Helm chart
+func (m *Manifest) addApplicationSource(source *yaml.Node, id string) {
+	chart := yamledit.ScalarValue(yamledit.MappingValue(source, "chart"))
+	revision := yamledit.MappingValue(source, "targetRevision")
+	if chart == "" || revision == nil || revision.Kind != yaml.ScalarNode {
+		return
+	}
+
+	// Argo CD reads Helm repositories without a scheme from OCI registries
+	repoURL := yamledit.ScalarValue(yamledit.MappingValue(source, "repoURL"))
+	if repoURL != "" && !strings.Contains(repoURL, "://") {
+		repoURL = "oci://" + repoURL
+	}
+
+	m.References = append(m.References, Reference{
+		ID:      id,
+		Kind:    KindChart,
+		Value:   revision.Value,
+		Chart:   chart,
+		RepoURL: repoURL,
+		node:    revision,
+	})
+}
+
+// addImagePolicyMarkers walks a node and adds the fields marked with a Flux image
+// policy setter comment, such as `image: app:1.0.0 # {"$imagepolicy": "flux-system:app"}`
+func (m *Manifest) addImagePolicyMarkers(node *yaml.Node, resourceID, path string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldPath := key.Value
+			if path != "" {
+				fieldPath = path + "." + key.Value
+			}
+
+			if value.Kind == yaml.ScalarNode {
+				m.addImagePolicyMarker(value, key.LineComment+value.LineComment, resourceID+":"+fieldPath)
+			} else {
+				m.addImagePolicyMarkers(value, resourceID, fieldPath)
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if item.Kind == yaml.ScalarNode {
+				m.addImagePolicyMarker(item, item.LineComment, resourceID+":"+itemPath)
+			} else {
+				m.addImagePolicyMarkers(item, resourceID, itemPath)
+			}
+		}
+	}
+}
+
+// addImagePolicyMarker adds a scalar as an image reference if its comment is a policy marker
+func (m *Manifest) addImagePolicyMarker(value *yaml.Node, comment, id string) {
+	_, markerJSON, found := strings.Cut(comment, "#")
+	if !found || !strings.Contains(markerJSON, imagePolicyMarker) {
+		return
+	}
+
+	var marker map[string]string
+	if err := yaml.Unmarshal([]byte(strings.TrimSpace(markerJSON)), &marker); err != nil {
+		return
+	}
+
+	// The setter is namespace:name for the full image, with a :tag or :name suffix for parts
+	parts := strings.Split(marker[imagePolicyMarker], ":")
+	if len(parts) < 2 {
+		return
+	}
+
+	reference := Reference{
+		ID:     id,
+		Kind:   KindImage,
+		Value:  value.Value,
+		Policy: parts[0] + "/" + parts[1],
+		node:   value,
+	}
+	if len(parts) > 2 {
+		if parts[2] != "tag" {
+			return
+		}
+		reference.Kind = KindImageTag
+	}
+
+	m.References = append(m.References, reference)
+}
+
+// namespaceOrDefault returns the default namespace when none is set
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return "default"
+	}
+	return namespace
+}
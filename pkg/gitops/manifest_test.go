@@ -0,0 +1,166 @@
+package gitops
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const fluxManifests = `apiVersion: source.toolkit.fluxcd.io/v1
+kind: HelmRepository
+metadata:
+  name: podinfo
+  namespace: flux-system
+spec:
+  url: https://stefanprodan.github.io/podinfo
+---
+apiVersion: helm.toolkit.fluxcd.io/v2
+kind: HelmRelease
+metadata:
+  name: podinfo
+  namespace: apps
+spec:
+  chart:
+    spec:
+      chart: podinfo
+      version: 6.5.0
+      sourceRef:
+        kind: HelmRepository
+        name: podinfo
+        namespace: flux-system
+---
+apiVersion: image.toolkit.fluxcd.io/v1beta2
+kind: ImageRepository
+metadata:
+  name: app
+  namespace: flux-system
+spec:
+  image: ghcr.io/example/app
+---
+apiVersion: image.toolkit.fluxcd.io/v1beta2
+kind: ImagePolicy
+metadata:
+  name: app
+  namespace: flux-system
+spec:
+  imageRepositoryRef:
+    name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: ghcr.io/example/app:1.2.0 # {"$imagepolicy": "flux-system:app"}
+        - name: sidecar
+          image: ghcr.io/example/sidecar:1.0.0
+      initContainers:
+        - name: migrate
+          env:
+            - name: TAG
+              value: "1.2.0" # {"$imagepolicy": "flux-system:app:tag"}
+`
+
+const argoManifest = `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: ingress
+  namespace: argocd
+spec:
+  sources:
+    - repoURL: https://kubernetes.github.io/ingress-nginx
+      chart: ingress-nginx
+      targetRevision: 4.8.0
+    - repoURL: https://gitlab.com/example/config.git
+      path: ingress
+      targetRevision: main
+    - repoURL: registry-1.docker.io/bitnamicharts
+      chart: redis
+      targetRevision: 18.1.0
+`
+
+func TestParseManifests(t *testing.T) {
+	dir := t.TempDir()
+	fluxPath := filepath.Join(dir, "flux.yaml")
+	argoPath := filepath.Join(dir, "argo.yaml")
+	if err := os.WriteFile(fluxPath, []byte(fluxManifests), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(argoPath, []byte(argoManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	index := NewIndex()
+	flux, err := ParseFile(fluxPath)
+	if err != nil {
+		t.Fatalf("ParseFile(flux) error = %v", err)
+	}
+	argo, err := ParseFile(argoPath)
+	if err != nil {
+		t.Fatalf("ParseFile(argo) error = %v", err)
+	}
+	index.Add(flux)
+	index.Add(argo)
+
+	if len(flux.References) != 3 {
+		t.Fatalf("flux references = %+v, want 3", flux.References)
+	}
+
+	release := flux.References[0]
+	if release.ID != "HelmRelease/apps/podinfo" || release.Kind != KindChart || release.Value != "6.5.0" || release.Chart != "podinfo" {
+		t.Errorf("HelmRelease reference = %+v", release)
+	}
+	if url, err := index.ChartRepository(release); err != nil || url != "https://stefanprodan.github.io/podinfo" {
+		t.Errorf("ChartRepository() = %s, %v", url, err)
+	}
+
+	image := flux.References[1]
+	if image.Kind != KindImage || image.Value != "ghcr.io/example/app:1.2.0" {
+		t.Errorf("image reference = %+v", image)
+	}
+
+	tag := flux.References[2]
+	if tag.Kind != KindImageTag || tag.Value != "1.2.0" {
+		t.Errorf("tag reference = %+v", tag)
+	}
+	if resolved, err := index.Image(tag); err != nil || resolved != "ghcr.io/example/app:1.2.0" {
+		t.Errorf("Image() = %s, %v", resolved, err)
+	}
+
+	if len(argo.References) != 2 {
+		t.Fatalf("argo references = %+v, want 2", argo.References)
+	}
+	if ref := argo.References[0]; ref.ID != "Application/argocd/ingress#0" || ref.Value != "4.8.0" || ref.RepoURL != "https://kubernetes.github.io/ingress-nginx" {
+		t.Errorf("Application reference = %+v", ref)
+	}
+	// Argo CD reads repositories without a scheme from OCI registries
+	if ref := argo.References[1]; ref.ID != "Application/argocd/ingress#2" || ref.RepoURL != "oci://registry-1.docker.io/bitnamicharts" {
+		t.Errorf("OCI Application reference = %+v", ref)
+	}
+
+	// Replace the values of the references
+	data, err := os.ReadFile(fluxPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err = ReplaceReference(data, release.ID, "6.5.0", "6.6.0"); err != nil {
+		t.Fatalf("ReplaceReference(chart) error = %v", err)
+	}
+	if data, err = ReplaceReference(data, tag.ID, "1.2.0", "1.3.0"); err != nil {
+		t.Fatalf("ReplaceReference(tag) error = %v", err)
+	}
+	if _, err := ReplaceReference(data, release.ID, "6.5.0", "6.7.0"); err == nil {
+		t.Error("ReplaceReference() with a stale value should fail")
+	}
+
+	want := strings.Replace(fluxManifests, "version: 6.5.0", "version: 6.6.0", 1)
+	want = strings.Replace(want, `value: "1.2.0"`, `value: "1.3.0"`, 1)
+	if string(data) != want {
+		t.Errorf("updated manifest =\n%s\nwant\n%s", data, want)
+	}
+}
@@ -0,0 +1,107 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is a log file rotated once it reaches a maximum size, the previous files
+// being kept as file.1 (the most recent) to file.N
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens a log file for appending, rotating it once it exceeds maxSize
+// bytes and keeping maxBackups previous files. A maxSize of zero disables rotation.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %w", err)
+		}
+	}
+
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the log file and reads its size
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write appends to the log file, rotating it first if the write would exceed its maximum size
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the previous files, renames the current one to file.1 and opens a new one
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	if r.maxBackups < 1 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+		return r.open()
+	}
+
+	os.Remove(backupPath(r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupPath(r.path, i), backupPath(r.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+	if err := os.Rename(r.path, backupPath(r.path, 1)); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	return r.open()
+}
+
+// Close closes the log file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+// backupPath returns the path of the nth previous log file
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "img-upgr.log")
+	file, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	defer file.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	expected := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", name, err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("more rotated files kept than the backups")
+	}
+}
+
+func TestLoggerFile(t *testing.T) {
+	var console, file strings.Builder
+	l := NewLogger(INFO, &console)
+	l.file = &file
+
+	l.log(INFO, "update %s", "\x1b[32m✓\x1b[0m")
+	l.log(DEBUG, "hidden")
+
+	if !strings.HasSuffix(file.String(), "[INFO] update ✓\n") {
+		t.Errorf("log file = %q, want the line without colors", file.String())
+	}
+	if !strings.HasSuffix(console.String(), "[INFO] update ✓\n") {
+		t.Errorf("console = %q, want the line without colors alongside the file", console.String())
+	}
+
+	console.Reset()
+	l.fileOnly = true
+	l.log(WARN, "only in the file")
+	if console.Len() != 0 {
+		t.Errorf("console = %q, want nothing with fileOnly", console.String())
+	}
+	if !strings.Contains(file.String(), "[WARN] only in the file") {
+		t.Errorf("log file = %q, want the warning", file.String())
+	}
+}
+
+func TestLoggerRunID(t *testing.T) {
+	var console, file strings.Builder
+	l := NewLogger(INFO, &console)
+	l.file = &file
+	l.runID = "4f2a9c1e"
+
+	l.log(INFO, "pushed")
+	for name, lines := range map[string]string{"log file": file.String(), "piped console": console.String()} {
+		if !strings.HasSuffix(lines, "[INFO] [run 4f2a9c1e] pushed\n") {
+			t.Errorf("%s = %q, want the run ID", name, lines)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -39,6 +40,9 @@ var (
 	warnColor  = color.New(color.FgYellow).SprintFunc()
 	errorColor = color.New(color.FgRed).SprintFunc()
 	fatalColor = color.New(color.FgHiRed, color.Bold).SprintFunc()
+
+	// ansiPattern matches the color escape sequences removed from log files
+	ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*m`)
 )
 
 // Logger represents a logger with configurable level and output
@@ -47,7 +51,22 @@ type Logger struct {
 	output      io.Writer
 	quiet       bool
 	useColors   bool
+	noColors    bool
 	errorOutput io.Writer
+	statusLine  StatusLine
+
+	// file receives the log lines without colors, instead of the outputs if fileOnly is set
+	file     io.Writer
+	fileOnly bool
+
+	// runID is written in the lines of the file, and of the output unless it is a terminal
+	runID string
+}
+
+// StatusLine is a line drawn below the log lines of a terminal, such as a progress bar
+type StatusLine interface {
+	// Print erases the line while write prints a log line, and draws it back below
+	Print(write func())
 }
 
 // LoggerOption defines a function that modifies a Logger
@@ -64,6 +83,7 @@ func WithErrorOutput(w io.Writer) LoggerOption {
 func WithoutColors() LoggerOption {
 	return func(l *Logger) {
 		l.useColors = false
+		l.noColors = true
 	}
 }
 
@@ -74,9 +94,10 @@ func WithQuiet() LoggerOption {
 	}
 }
 
-// init initializes the default logger
+// init initializes the default logger, writing to stderr so stdout only holds the
+// results of the commands, such as the JSON report of check
 func init() {
-	defaultLogger = NewLogger(INFO, os.Stdout)
+	defaultLogger = NewLogger(INFO, os.Stderr)
 }
 
 // NewLogger creates a new logger with the specified level and output
@@ -85,7 +106,7 @@ func NewLogger(level LogLevel, output io.Writer, options ...LoggerOption) *Logge
 		level:       level,
 		output:      output,
 		quiet:       false,
-		useColors:   true,
+		useColors:   ColorsSupported(output),
 		errorOutput: output, // Default error output is the same as normal output
 	}
 
@@ -107,14 +128,53 @@ func SetQuiet(quiet bool) {
 	defaultLogger.quiet = quiet
 }
 
-// SetOutput sets the output writer for the default logger
+// SetOutput sets the output writer for the default logger, used for errors too
 func SetOutput(w io.Writer) {
 	defaultLogger.output = w
+	defaultLogger.errorOutput = w
+	defaultLogger.useColors = !defaultLogger.noColors && ColorsSupported(w)
+}
+
+// SetFile writes the logs of the default logger to a file as well, or only to the file
+// when only is set. A nil file stops writing to it.
+func SetFile(w io.Writer, only bool) {
+	defaultLogger.file = w
+	defaultLogger.fileOnly = only && w != nil
 }
 
-// DisableColors disables colored output for the default logger
+// SetRunID writes the ID of the run in the log lines collected from a file or a pipe,
+// so that they can be traced back to the run. An empty ID removes it.
+func SetRunID(id string) {
+	defaultLogger.runID = id
+}
+
+// SetStatusLine sets the status line kept below the log lines of the default logger,
+// nil removing it
+func SetStatusLine(s StatusLine) {
+	defaultLogger.statusLine = s
+}
+
+// DisableColors disables colored output for the default logger and the messages it logs
 func DisableColors() {
 	defaultLogger.useColors = false
+	defaultLogger.noColors = true
+	color.NoColor = true
+}
+
+// IsTerminal reports whether a writer is an interactive terminal
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ColorsSupported reports whether colors can be written to a writer: it must be a
+// terminal and NO_COLOR (https://no-color.org) must not be set
+func ColorsSupported(w io.Writer) bool {
+	return os.Getenv("NO_COLOR") == "" && IsTerminal(w)
 }
 
 // GetLevel returns the current log level as a string
@@ -191,20 +251,50 @@ func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 		coloredLevel = levelStr
 	}
 
+	// Messages may hold colored parts, such as the symbols of updates, colored according
+	// to stdout which may not be where the logs go
 	message := fmt.Sprintf(format, args...)
-	logLine := fmt.Sprintf("%s [%s] %s\n", timestamp, coloredLevel, message)
+	plainMessage := ansiPattern.ReplaceAllString(message, "")
+	if !l.useColors {
+		message = plainMessage
+	}
+	run, consoleRun := "", ""
+	if l.runID != "" {
+		run = " [run " + l.runID + "]"
+		if !IsTerminal(l.output) {
+			consoleRun = run
+		}
+	}
+	logLine := fmt.Sprintf("%s [%s]%s %s\n", timestamp, coloredLevel, consoleRun, message)
+
+	if l.file != nil {
+		fileLine := fmt.Sprintf("%s [%s]%s %s\n", timestamp, levelStr, run, plainMessage)
+		if _, err := io.WriteString(l.file, fileLine); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Error writing to log file: %v\n", err)
+		}
+	}
+	if l.fileOnly {
+		if level == FATAL {
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Use errorOutput for ERROR and FATAL levels if set
+	output := l.output
 	if (level == ERROR || level == FATAL) && l.errorOutput != nil {
-		if _, err := fmt.Fprint(l.errorOutput, logLine); err != nil {
+		output = l.errorOutput
+	}
+	write := func() {
+		if _, err := fmt.Fprint(output, logLine); err != nil {
 			// Can't do much if logging itself fails, but at least try to write to stderr
 			_, _ = fmt.Fprintf(os.Stderr, "Error writing to log: %v\n", err)
 		}
+	}
+	if l.statusLine != nil {
+		l.statusLine.Print(write)
 	} else {
-		if _, err := fmt.Fprint(l.output, logLine); err != nil {
-			// Can't do much if logging itself fails, but at least try to write to stderr
-			_, _ = fmt.Fprintf(os.Stderr, "Error writing to log: %v\n", err)
-		}
+		write()
 	}
 
 	if level == FATAL {
@@ -0,0 +1,78 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// FormatVersion is the version of the plan file format
+const FormatVersion = 1
+
+// Update represents a single planned image update
+type Update struct {
+	File        string `json:"file"`
+	ServiceName string `json:"service"`
+	OldImage    string `json:"old_image"`
+	NewImage    string `json:"new_image"`
+	Repository  string `json:"repository"`
+	OldTag      string `json:"old_tag"`
+	NewTag      string `json:"new_tag"`
+	Kind        string `json:"kind,omitempty"`
+	Major       bool   `json:"major,omitempty"`
+	Downgrade   bool   `json:"downgrade,omitempty"`
+	// Verification describes the signature verified for the new image, if any
+	Verification string `json:"verification,omitempty"`
+}
+
+// Plan is a machine-readable list of updates found by a check run
+type Plan struct {
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+	GitLabRepo string    `json:"gitlab_repo,omitempty"`
+	Updates    []Update  `json:"updates"`
+}
+
+// New creates an empty plan for the given repository
+func New(gitlabRepo string) *Plan {
+	return &Plan{
+		Version:    FormatVersion,
+		CreatedAt:  time.Now().UTC(),
+		GitLabRepo: gitlabRepo,
+		Updates:    []Update{},
+	}
+}
+
+// Write saves the plan as indented JSON to the given path
+func (p *Plan) Write(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+
+	return nil
+}
+
+// Read loads a plan from the given path
+func Read(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file: %w", err)
+	}
+
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan file: %w", err)
+	}
+
+	if p.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported plan version %d (expected %d)", p.Version, FormatVersion)
+	}
+
+	return &p, nil
+}
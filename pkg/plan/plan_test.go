@@ -0,0 +1,74 @@
+package plan
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteRead(t *testing.T) {
+	p := New("https://gitlab.example.com/group/project")
+	p.Updates = append(p.Updates,
+		Update{
+			File: "deploy/docker-compose.yml", ServiceName: "web", Repository: "nginx",
+			OldImage: "nginx:1.25.0", NewImage: "nginx:2.0.0", OldTag: "1.25.0", NewTag: "2.0.0",
+			Major: true, Verification: "cosign signature",
+		},
+		Update{
+			File: "flux/podinfo.yml", ServiceName: "HelmRelease/apps/podinfo", Repository: "podinfo",
+			OldImage: "podinfo:6.5.0", NewImage: "podinfo:6.4.0", OldTag: "6.5.0", NewTag: "6.4.0",
+			Kind: "chart", Downgrade: true,
+		},
+	)
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.Write(path); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, p) {
+		t.Errorf("Read() = %+v, want %+v", got, p)
+	}
+
+	// An empty plan keeps an empty list of updates
+	empty := New("")
+	if err := empty.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Read(path); err != nil || got.Updates == nil || len(got.Updates) != 0 {
+		t.Errorf("Read() of an empty plan = %+v, %v", got, err)
+	}
+}
+
+func TestReadRejectsInvalidPlans(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"other version", `{"version": 2, "updates": []}`, "unsupported plan version 2"},
+		{"no version", `{"updates": []}`, "unsupported plan version 0"},
+		{"invalid JSON", `{"version": 1, "updates": [`, "failed to parse plan file"},
+		{"not a plan", `[]`, "failed to parse plan file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plan.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Read(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Read() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := Read(filepath.Join(t.TempDir(), "missing.json")); err == nil || !strings.Contains(err.Error(), "failed to read plan file") {
+		t.Errorf("Read() of a missing file error = %v", err)
+	}
+}
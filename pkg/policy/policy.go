@@ -0,0 +1,153 @@
+// Package policy decides how the merge request of an update is handled, such as
+// merged automatically or opened as a draft, from the severity of the version bump.
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Bump is the severity of a version change
+type Bump string
+
+const (
+	// BumpPatch changes the patch version only
+	BumpPatch Bump = "patch"
+	// BumpMinor changes the minor version
+	BumpMinor Bump = "minor"
+	// BumpMajor changes the major version
+	BumpMajor Bump = "major"
+	// BumpOther is a change between tags without comparable versions, such as a digest update
+	BumpOther Bump = "other"
+)
+
+// BumpAny matches any bump in a rule
+const BumpAny = "any"
+
+// bumpOrder ranks bumps from the least to the most severe, changes whose severity is
+// unknown being handled with the most care
+var bumpOrder = []Bump{BumpPatch, BumpMinor, BumpMajor, BumpOther}
+
+// ValidBumps lists the bumps a rule can match
+var ValidBumps = []string{string(BumpPatch), string(BumpMinor), string(BumpMajor), string(BumpOther), BumpAny}
+
+// versionPattern matches the numeric version of a tag, such as 1.25 in 1.25.3-alpine
+var versionPattern = regexp.MustCompile(`\d+(?:\.\d+){0,2}`)
+
+// Classify returns the bump from an old to a new tag, comparing the first numeric
+// version found in each tag, digests ignored
+func Classify(oldTag, newTag string) Bump {
+	oldVersion, okOld := parseVersion(oldTag)
+	newVersion, okNew := parseVersion(newTag)
+	if !okOld || !okNew {
+		return BumpOther
+	}
+
+	switch {
+	case oldVersion[0] != newVersion[0]:
+		return BumpMajor
+	case oldVersion[1] != newVersion[1]:
+		return BumpMinor
+	case oldVersion[2] != newVersion[2]:
+		return BumpPatch
+	}
+	return BumpOther
+}
+
+// parseVersion returns the major, minor and patch numbers of a tag, missing ones being zero
+func parseVersion(tag string) ([3]int, bool) {
+	var version [3]int
+	tag, _, _ = strings.Cut(tag, "@")
+	match := versionPattern.FindString(tag)
+	if match == "" {
+		return version, false
+	}
+
+	for i, part := range strings.Split(match, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return version, false
+		}
+		version[i] = number
+	}
+	return version, true
+}
+
+// Highest returns the most severe of several bumps, such as the ones of a group of updates
+func Highest(bumps ...Bump) Bump {
+	highest := BumpPatch
+	for _, bump := range bumps {
+		if slices.Index(bumpOrder, bump) > slices.Index(bumpOrder, highest) {
+			highest = bump
+		}
+	}
+	return highest
+}
+
+// Rule sets how the merge requests of a bump are handled
+type Rule struct {
+	// Bump is the bump the rule applies to: patch, minor, major, other or any
+	Bump string `yaml:"bump"`
+	// AutoMerge merges the merge request once its pipeline succeeds
+	AutoMerge bool `yaml:"auto_merge"`
+	// Draft opens the merge request as a draft, which cannot be merged until marked ready
+	Draft bool `yaml:"draft"`
+	// Assignees and Reviewers are GitLab usernames
+	Assignees []string `yaml:"assignees"`
+	Reviewers []string `yaml:"reviewers"`
+	// Labels are added to the merge request, such as the label a merge check requires
+	Labels []string `yaml:"labels"`
+}
+
+// Validate checks a rule
+func (r Rule) Validate() error {
+	if !slices.Contains(ValidBumps, r.Bump) {
+		return fmt.Errorf("invalid bump %q, must be one of: %s", r.Bump, strings.Join(ValidBumps, ", "))
+	}
+	if r.AutoMerge && r.Draft {
+		return fmt.Errorf("auto_merge and draft cannot be combined, draft merge requests cannot be merged")
+	}
+	return nil
+}
+
+// Decision is how the merge request of a bump is handled
+type Decision struct {
+	AutoMerge bool
+	Draft     bool
+	Assignees []string
+	Reviewers []string
+	Labels    []string
+}
+
+// Decide combines the rules matching a bump. A draft rule takes precedence over
+// auto-merge rules, so a rule for any bump cannot auto-merge a draft.
+func Decide(rules []Rule, bump Bump) Decision {
+	var decision Decision
+	for _, rule := range rules {
+		if rule.Bump != BumpAny && rule.Bump != string(bump) {
+			continue
+		}
+		decision.AutoMerge = decision.AutoMerge || rule.AutoMerge
+		decision.Draft = decision.Draft || rule.Draft
+		decision.Assignees = appendNew(decision.Assignees, rule.Assignees)
+		decision.Reviewers = appendNew(decision.Reviewers, rule.Reviewers)
+		decision.Labels = appendNew(decision.Labels, rule.Labels)
+	}
+	if decision.Draft {
+		decision.AutoMerge = false
+	}
+	return decision
+}
+
+// appendNew appends the values not in the list yet
+func appendNew(list, values []string) []string {
+	for _, value := range values {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
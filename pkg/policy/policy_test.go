@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	testCases := []struct {
+		oldTag   string
+		newTag   string
+		expected Bump
+	}{
+		{"1.25.3", "1.25.4", BumpPatch},
+		{"1.25.3-alpine", "1.26.0-alpine", BumpMinor},
+		{"v1.9", "v2.0", BumpMajor},
+		{"15", "16", BumpMajor},
+		{"1.2.3@sha256:aaa", "1.2.4@sha256:bbb", BumpPatch},
+		{"latest", "latest@sha256:bbb", BumpOther},
+		{"1.2.3@sha256:aaa", "1.2.3@sha256:bbb", BumpOther},
+	}
+
+	for _, tc := range testCases {
+		if got := Classify(tc.oldTag, tc.newTag); got != tc.expected {
+			t.Errorf("Classify(%q, %q) = %s, want %s", tc.oldTag, tc.newTag, got, tc.expected)
+		}
+	}
+}
+
+func TestHighest(t *testing.T) {
+	if got := Highest(BumpPatch, BumpMajor, BumpMinor); got != BumpMajor {
+		t.Errorf("Highest() = %s, want major", got)
+	}
+	if got := Highest(); got != BumpPatch {
+		t.Errorf("Highest() = %s, want patch", got)
+	}
+}
+
+func TestDecide(t *testing.T) {
+	rules := []Rule{
+		{Bump: "patch", AutoMerge: true},
+		{Bump: "minor", Assignees: []string{"team-a"}},
+		{Bump: "major", Draft: true, Labels: []string{"needs-review"}},
+		{Bump: "any", Labels: []string{"dependencies"}, AutoMerge: true},
+	}
+
+	testCases := []struct {
+		bump     Bump
+		expected Decision
+	}{
+		{BumpPatch, Decision{AutoMerge: true, Labels: []string{"dependencies"}}},
+		{BumpMinor, Decision{AutoMerge: true, Assignees: []string{"team-a"}, Labels: []string{"dependencies"}}},
+		{BumpMajor, Decision{Draft: true, Labels: []string{"needs-review", "dependencies"}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.bump), func(t *testing.T) {
+			if got := Decide(rules, tc.bump); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Decide() = %+v, want %+v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	if err := (Rule{Bump: "huge"}).Validate(); err == nil {
+		t.Error("Validate() accepted an invalid bump")
+	}
+	if err := (Rule{Bump: "major", Draft: true, AutoMerge: true}).Validate(); err == nil {
+		t.Error("Validate() accepted a draft auto-merge rule")
+	}
+	if err := (Rule{Bump: "any", Labels: []string{"dependencies"}}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+// Package progress draws a live status line of a scan, with the number of files and
+// images checked, below the log lines of a terminal.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshInterval is the delay between two redraws of the status line
+	refreshInterval = 120 * time.Millisecond
+
+	// barWidth is the number of characters of the bar
+	barWidth = 24
+
+	// clearLine moves to the start of the line and erases it
+	clearLine = "\r\033[K"
+)
+
+// spinnerFrames are drawn in turn to show the scan is running
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// contextKey is the key of the bar of a context
+type contextKey struct{}
+
+// Bar is the status line of a scan. A nil bar does nothing, so code reporting progress
+// works the same when no status line is drawn.
+type Bar struct {
+	out   io.Writer
+	label string
+	start time.Time
+
+	mu     sync.Mutex
+	total  int
+	files  int
+	images int
+	frame  int
+	shown  bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New creates a bar drawn on out and starts redrawing it
+func New(out io.Writer, label string) *Bar {
+	b := &Bar{out: out, label: label, start: time.Now(), done: make(chan struct{})}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.done:
+				return
+			case <-ticker.C:
+				b.mu.Lock()
+				b.frame++
+				b.draw()
+				b.mu.Unlock()
+			}
+		}
+	}()
+	return b
+}
+
+// WithBar returns a context carrying a bar
+func WithBar(ctx context.Context, b *Bar) context.Context {
+	return context.WithValue(ctx, contextKey{}, b)
+}
+
+// FromContext returns the bar of a context, nil if it has none
+func FromContext(ctx context.Context) *Bar {
+	b, _ := ctx.Value(contextKey{}).(*Bar)
+	return b
+}
+
+// SetTotal sets the number of files to check
+func (b *Bar) SetTotal(total int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total = total
+	b.draw()
+}
+
+// FileDone counts a checked file
+func (b *Bar) FileDone() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files++
+	b.draw()
+}
+
+// ImageDone counts a checked image
+func (b *Bar) ImageDone() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.images++
+	b.draw()
+}
+
+// Print erases the status line while write prints, such as a log line, and draws it back
+// below
+func (b *Bar) Print(write func()) {
+	if b == nil {
+		write()
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.shown {
+		fmt.Fprint(b.out, clearLine)
+		b.shown = false
+	}
+	write()
+	b.draw()
+}
+
+// Stop stops redrawing the bar and erases it
+func (b *Bar) Stop() {
+	if b == nil {
+		return
+	}
+	close(b.done)
+	b.wg.Wait()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.shown {
+		fmt.Fprint(b.out, clearLine)
+		b.shown = false
+	}
+}
+
+// draw writes the status line, the lock being held
+func (b *Bar) draw() {
+	select {
+	case <-b.done:
+		return
+	default:
+	}
+	fmt.Fprint(b.out, clearLine+b.render())
+	b.shown = true
+}
+
+// render returns the status line
+func (b *Bar) render() string {
+	var sb strings.Builder
+	sb.WriteString(spinnerFrames[b.frame%len(spinnerFrames)])
+	sb.WriteString(" ")
+	sb.WriteString(b.label)
+
+	if b.total > 0 {
+		filled := barWidth * min(b.files, b.total) / b.total
+		fmt.Fprintf(&sb, " [%s%s] %d/%d files", strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled), b.files, b.total)
+	} else {
+		fmt.Fprintf(&sb, " %d files", b.files)
+	}
+	fmt.Fprintf(&sb, ", %d images, %s", b.images, time.Since(b.start).Round(time.Second))
+	return sb.String()
+}
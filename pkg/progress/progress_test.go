@@ -0,0 +1,74 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a buffer written by the redrawing goroutine and read by the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestBar(t *testing.T) {
+	out := &syncBuffer{}
+	bar := New(out, "Checking images")
+	bar.SetTotal(4)
+	bar.FileDone()
+	bar.FileDone()
+	bar.ImageDone()
+	bar.Print(func() { out.Write([]byte("log line\n")) })
+	bar.Stop()
+
+	output := out.String()
+	if !strings.Contains(output, "Checking images [============            ] 2/4 files, 1 images") {
+		t.Errorf("status line not drawn, got %q", output)
+	}
+	if !strings.Contains(output, clearLine+"log line\n") {
+		t.Errorf("status line not erased before a log line, got %q", output)
+	}
+	if !strings.HasSuffix(output, clearLine) {
+		t.Errorf("status line not erased when stopped, got %q", output)
+	}
+
+	// Nothing is drawn once stopped
+	length := len(out.String())
+	bar.FileDone()
+	if len(out.String()) != length {
+		t.Error("stopped bar was drawn")
+	}
+}
+
+func TestNilBar(t *testing.T) {
+	bar := FromContext(context.Background())
+	if bar != nil {
+		t.Fatal("FromContext() returned a bar for a context without one")
+	}
+
+	bar.SetTotal(2)
+	bar.FileDone()
+	bar.ImageDone()
+	bar.Stop()
+
+	printed := false
+	bar.Print(func() { printed = true })
+	if !printed {
+		t.Error("Print() of a nil bar did not print")
+	}
+}
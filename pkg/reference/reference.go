@@ -0,0 +1,159 @@
+// Package reference parses image references such as registry.example.com:5000/app:1.2.3
+// or nginx:1.25@sha256:..., following the grammar of the OCI distribution specification.
+package reference
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// pathComponentPattern matches a component of a repository path, such as library or my-app
+	pathComponentPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	// hostPattern matches a registry host name, IPv4 or bracketed IPv6 address, with an optional port
+	hostPattern = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*|\[[a-fA-F0-9:]+\])(?::[0-9]+)?$`)
+	// tagPattern matches a tag
+	tagPattern = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	// digestPattern matches a digest, sha256 digests being 64 hexadecimal characters
+	digestPattern = regexp.MustCompile(`^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]{32,}$`)
+)
+
+// Reference is a parsed image reference
+type Reference struct {
+	// Host is the registry host with its port, empty for Docker Hub short names such as nginx
+	Host string
+	// Path is the repository path on the registry, such as library/nginx or nginx
+	Path string
+	// Tag is empty when the reference has none
+	Tag string
+	// Digest is empty when the reference is not pinned to a digest
+	Digest string
+}
+
+// Parse parses an image reference. The first path component is the registry host if it
+// contains a dot or a port, or is localhost, like Docker does.
+func Parse(image string) (Reference, error) {
+	var ref Reference
+	if image == "" {
+		return ref, fmt.Errorf("empty image reference")
+	}
+
+	name := image
+	if before, digest, found := strings.Cut(name, "@"); found {
+		if !digestPattern.MatchString(digest) || (strings.HasPrefix(digest, "sha256:") && len(digest) != len("sha256:")+64) {
+			return ref, fmt.Errorf("invalid digest in image reference %q", image)
+		}
+		name, ref.Digest = before, digest
+	}
+
+	// A colon after the last slash separates the tag, otherwise it belongs to a registry port
+	if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		ref.Tag = name[idx+1:]
+		name = name[:idx]
+		if !tagPattern.MatchString(ref.Tag) {
+			return ref, fmt.Errorf("invalid tag %q in image reference %q", ref.Tag, image)
+		}
+	}
+
+	if first, rest, found := strings.Cut(name, "/"); found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		if !hostPattern.MatchString(first) {
+			return ref, fmt.Errorf("invalid registry host %q in image reference %q", first, image)
+		}
+		ref.Host, name = first, rest
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if !pathComponentPattern.MatchString(component) {
+			return ref, fmt.Errorf("invalid repository %q in image reference %q", name, image)
+		}
+	}
+	ref.Path = name
+	return ref, nil
+}
+
+// Repository returns the repository of the reference, with its host when it has one
+func (r Reference) Repository() string {
+	if r.Host == "" {
+		return r.Path
+	}
+	return r.Host + "/" + r.Path
+}
+
+// Port returns the port of the registry host, empty if it has none
+func (r Reference) Port() string {
+	if idx := strings.LastIndex(r.Host, ":"); idx > strings.LastIndex(r.Host, "]") {
+		return r.Host[idx+1:]
+	}
+	return ""
+}
+
+// String returns the reference as written in images
+func (r Reference) String() string {
+	s := r.Repository()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// DockerHubHost is the host of Docker Hub in canonical repositories
+const DockerHubHost = "docker.io"
+
+// dockerHubHosts are the host names referring to Docker Hub
+var dockerHubHosts = []string{DockerHubHost, "index.docker.io", "registry-1.docker.io"}
+
+// IsDockerHub reports whether a host refers to Docker Hub
+func IsDockerHub(host string) bool {
+	for _, hubHost := range dockerHubHosts {
+		if strings.EqualFold(host, hubHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonical returns the repository in its canonical form, with a lower-case host and
+// Docker Hub repositories written as docker.io/library/nginx
+func (r Reference) Canonical() string {
+	if r.Host == "" || IsDockerHub(r.Host) {
+		path := r.Path
+		if !strings.Contains(path, "/") {
+			path = "library/" + path
+		}
+		return DockerHubHost + "/" + path
+	}
+	return strings.ToLower(r.Host) + "/" + r.Path
+}
+
+// Familiar returns the repository as written in images, without the Docker Hub host
+// and library/ prefix
+func (r Reference) Familiar() string {
+	if r.Host == "" || IsDockerHub(r.Host) {
+		return strings.TrimPrefix(r.Path, "library/")
+	}
+	return strings.ToLower(r.Host) + "/" + r.Path
+}
+
+// Normalize returns the canonical form of a repository, so nginx, library/nginx and
+// docker.io/library/nginx are the same repository. Invalid repositories are returned as is.
+func Normalize(repo string) string {
+	ref, err := Parse(repo)
+	if err != nil {
+		return repo
+	}
+	return ref.Canonical()
+}
+
+// Familiarize returns the short form of a repository, nginx for docker.io/library/nginx.
+// Invalid repositories are returned as is.
+func Familiarize(repo string) string {
+	ref, err := Parse(repo)
+	if err != nil {
+		return repo
+	}
+	return ref.Familiar()
+}
@@ -0,0 +1,88 @@
+package reference
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	testCases := []struct {
+		image    string
+		expected Reference
+		port     string
+	}{
+		{image: "nginx", expected: Reference{Path: "nginx"}},
+		{image: "nginx:1.25.3", expected: Reference{Path: "nginx", Tag: "1.25.3"}},
+		{image: "bitnami/redis:7.2.4-debian-12-r0", expected: Reference{Path: "bitnami/redis", Tag: "7.2.4-debian-12-r0"}},
+		{image: "registry.example.com:5000/app:1.2.3", expected: Reference{Host: "registry.example.com:5000", Path: "app", Tag: "1.2.3"}, port: "5000"},
+		{image: "registry.example.com:5000/team/app", expected: Reference{Host: "registry.example.com:5000", Path: "team/app"}, port: "5000"},
+		{image: "localhost/app:dev", expected: Reference{Host: "localhost", Path: "app", Tag: "dev"}},
+		{image: "[::1]:5000/app:1.0", expected: Reference{Host: "[::1]:5000", Path: "app", Tag: "1.0"}, port: "5000"},
+		{image: "nginx@" + digest, expected: Reference{Path: "nginx", Digest: digest}},
+		{image: "ghcr.io/org/app:1.2.3@" + digest, expected: Reference{Host: "ghcr.io", Path: "org/app", Tag: "1.2.3", Digest: digest}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.image, func(t *testing.T) {
+			ref, err := Parse(tc.image)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if ref != tc.expected {
+				t.Errorf("Parse() = %+v, want %+v", ref, tc.expected)
+			}
+			if ref.Port() != tc.port {
+				t.Errorf("Port() = %q, want %q", ref.Port(), tc.port)
+			}
+			if ref.String() != tc.image {
+				t.Errorf("String() = %q, want %q", ref.String(), tc.image)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, image := range []string{
+		"",
+		"Nginx:1.0",
+		"nginx:",
+		"nginx:-bad",
+		"nginx@sha256:abc",
+		"registry_example.com:5000/app",
+		"app//name:1.0",
+		"${REGISTRY}/app:1.0",
+	} {
+		if _, err := Parse(image); err == nil {
+			t.Errorf("Parse(%q) accepted an invalid reference", image)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		repo      string
+		canonical string
+		familiar  string
+	}{
+		{repo: "nginx", canonical: "docker.io/library/nginx", familiar: "nginx"},
+		{repo: "library/nginx", canonical: "docker.io/library/nginx", familiar: "nginx"},
+		{repo: "docker.io/library/nginx", canonical: "docker.io/library/nginx", familiar: "nginx"},
+		{repo: "index.docker.io/nginx:1.25", canonical: "docker.io/library/nginx", familiar: "nginx"},
+		{repo: "bitnami/redis", canonical: "docker.io/bitnami/redis", familiar: "bitnami/redis"},
+		{repo: "GHCR.io/org/app", canonical: "ghcr.io/org/app", familiar: "ghcr.io/org/app"},
+		{repo: "registry.example.com:5000/app", canonical: "registry.example.com:5000/app", familiar: "registry.example.com:5000/app"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.repo, func(t *testing.T) {
+			if got := Normalize(tc.repo); got != tc.canonical {
+				t.Errorf("Normalize() = %q, want %q", got, tc.canonical)
+			}
+			if got := Familiarize(tc.repo); got != tc.familiar {
+				t.Errorf("Familiarize() = %q, want %q", got, tc.familiar)
+			}
+		})
+	}
+}
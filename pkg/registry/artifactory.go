@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// ArtifactoryClient lists tags using the Artifactory Docker registry API.
+// Repositories are referenced as <repository key>/<image>.
+type ArtifactoryClient struct {
+	baseClient
+	pageSize int
+}
+
+// NewArtifactoryClient creates an Artifactory client for the given base URL
+func NewArtifactoryClient(baseURL string, credentials *Credentials) *ArtifactoryClient {
+	return &ArtifactoryClient{
+		baseClient: newBaseClient(baseURL, credentials),
+		pageSize:   DefaultPageSize,
+	}
+}
+
+// registryURL returns the registry v2 API URL of an image inside a repository key
+func (c *ArtifactoryClient) registryURL(repo string) (string, error) {
+	repoKey, image, found := strings.Cut(repo, "/")
+	if !found {
+		return "", fmt.Errorf("artifactory repository must be <repository key>/<image>: %s", repo)
+	}
+
+	return fmt.Sprintf("%s/artifactory/api/docker/%s/v2/%s", c.baseURL, url.PathEscape(repoKey), image), nil
+}
+
+// FetchAllTags fetches all tags of an image
+func (c *ArtifactoryClient) FetchAllTags(repo string) ([]string, error) {
+	return c.FetchAllTagsWithContext(context.Background(), repo)
+}
+
+// FetchAllTagsWithContext fetches all tags of an image with context
+func (c *ArtifactoryClient) FetchAllTagsWithContext(ctx context.Context, repo string) ([]string, error) {
+	registryURL, err := c.registryURL(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("Fetching Artifactory tags for %s", repo)
+
+	tags, err := c.listV2Tags(ctx, registryURL, c.pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Found %d tags for %s", len(tags), repo)
+	return tags, nil
+}
+
+// FetchTagDigest resolves the manifest digest of a tag
+func (c *ArtifactoryClient) FetchTagDigest(repo, tag string) (string, error) {
+	return c.FetchTagDigestWithContext(context.Background(), repo, tag)
+}
+
+// FetchTagDigestWithContext resolves the manifest digest of a tag with context
+func (c *ArtifactoryClient) FetchTagDigestWithContext(ctx context.Context, repo, tag string) (string, error) {
+	registryURL, err := c.registryURL(repo)
+	if err != nil {
+		return "", err
+	}
+
+	return c.fetchV2Digest(ctx, registryURL, tag)
+}
+
+// FetchImageDetails fetches the size and platforms of the image of a tag
+func (c *ArtifactoryClient) FetchImageDetails(repo, tag string) (*ImageDetails, error) {
+	registryURL, err := c.registryURL(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.fetchV2Details(context.Background(), registryURL, tag)
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestArtifactoryClient(t *testing.T) {
+	var pages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "ci" || password != "token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/artifactory/api/docker/docker-local/v2/team/app/tags/list":
+			pages = append(pages, r.URL.RawQuery)
+			// The registry API pages through Link headers
+			if r.URL.Query().Get("last") == "" {
+				w.Header().Set("Link", `</artifactory/api/docker/docker-local/v2/team/app/tags/list?n=2&last=1.1.0>; rel="next"`)
+				_, _ = fmt.Fprint(w, `{"name": "team/app", "tags": ["1.0.0", "1.1.0"]}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"name": "team/app", "tags": ["2.0.0"]}`)
+		case "/artifactory/api/docker/docker-local/v2/team/app/manifests/2.0.0":
+			w.Header().Set("Docker-Content-Digest", "sha256:c")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewArtifactoryClient(server.URL, &Credentials{Username: "ci", Password: "token"})
+	client.pageSize = 2
+
+	tags, err := client.FetchAllTags("docker-local/team/app")
+	if err != nil {
+		t.Fatalf("FetchAllTags() error = %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.0.0", "1.1.0", "2.0.0"}) {
+		t.Errorf("FetchAllTags() = %v", tags)
+	}
+	if want := []string{"n=2", "n=2&last=1.1.0"}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages = %v, want %v", pages, want)
+	}
+
+	digest, err := client.FetchTagDigest("docker-local/team/app", "2.0.0")
+	if err != nil || digest != "sha256:c" {
+		t.Errorf("FetchTagDigest() = %q, %v, want sha256:c", digest, err)
+	}
+
+	if _, err := client.FetchAllTags("app"); err == nil {
+		t.Error("FetchAllTags() of an image without repository key succeeded")
+	}
+}
@@ -0,0 +1,356 @@
+package registry
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+var (
+	// awsContainerCredentialsHost is the endpoint serving ECS task role credentials
+	awsContainerCredentialsHost = "http://169.254.170.2"
+
+	// awsMetadataHost is the EC2 instance metadata endpoint
+	awsMetadataHost = "http://169.254.169.254"
+
+	// awsMetadataTimeout bounds the first request to the instance metadata endpoint,
+	// which does not answer off EC2 and would otherwise stall the run
+	awsMetadataTimeout = time.Second
+)
+
+// awsCredentials holds AWS access keys
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"Token"`
+}
+
+// loadAWSCredentials resolves credentials from the environment variables, the static
+// keys of the shared credentials file, the ECS or EKS Pod Identity container
+// credentials and finally the EC2 instance metadata service. Web identity tokens and
+// the profiles of ~/.aws/config (SSO, credential_process, assumed roles) are not read.
+func loadAWSCredentials(ctx context.Context, httpClient *http.Client) (*awsCredentials, error) {
+	if accessKey := os.Getenv("AWS_ACCESS_KEY_ID"); accessKey != "" {
+		logger.Debug("Using AWS credentials from environment")
+		return &awsCredentials{
+			AccessKeyID:     accessKey,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	if creds, err := loadSharedAWSCredentials(); err == nil {
+		logger.Debug("Using AWS credentials from shared credentials file")
+		return creds, nil
+	}
+
+	if relativeURI := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relativeURI != "" {
+		logger.Debug("Using AWS container credentials")
+		return fetchAWSCredentials(ctx, httpClient, awsContainerCredentialsHost+relativeURI, nil)
+	}
+	if fullURI := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); fullURI != "" {
+		logger.Debug("Using AWS container credentials from %s", fullURI)
+		return fetchFullURICredentials(ctx, httpClient, fullURI)
+	}
+
+	logger.Debug("Using AWS instance metadata credentials")
+	return fetchInstanceCredentials(ctx, httpClient)
+}
+
+// loadSharedAWSCredentials reads the profile selected by AWS_PROFILE from the shared credentials file
+func loadSharedAWSCredentials() (*awsCredentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(homeDir, ".aws", "credentials")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	creds := &awsCredentials{}
+	inProfile := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inProfile = strings.TrimSpace(line[1:len(line)-1]) == profile
+			continue
+		}
+		if !inProfile {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if creds.AccessKeyID == "" {
+		return nil, fmt.Errorf("profile %s not found in %s", profile, path)
+	}
+	return creds, nil
+}
+
+// fetchFullURICredentials fetches the container credentials of
+// AWS_CONTAINER_CREDENTIALS_FULL_URI, as served by EKS Pod Identity, with the
+// authorization token of AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE or
+// AWS_CONTAINER_AUTHORIZATION_TOKEN. Like the AWS SDKs, plain HTTP is only accepted
+// for loopback and container credentials hosts, the token being sent in clear.
+func fetchFullURICredentials(ctx context.Context, httpClient *http.Client, fullURI string) (*awsCredentials, error) {
+	endpoint, err := url.Parse(fullURI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AWS_CONTAINER_CREDENTIALS_FULL_URI: %w", err)
+	}
+	if endpoint.Scheme != "https" && !isAWSContainerHost(endpoint.Hostname()) {
+		return nil, fmt.Errorf("AWS_CONTAINER_CREDENTIALS_FULL_URI host %s must use https", endpoint.Hostname())
+	}
+
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if path := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AWS container authorization token: %w", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+
+	var headers map[string]string
+	if token != "" {
+		headers = map[string]string{"Authorization": token}
+	}
+	return fetchAWSCredentials(ctx, httpClient, fullURI, headers)
+}
+
+// isAWSContainerHost reports whether a host may serve container credentials over
+// plain HTTP: a loopback address or the ECS and EKS Pod Identity agents
+func isAWSContainerHost(host string) bool {
+	switch host {
+	case "localhost", "169.254.170.2", "169.254.170.23", "fd00:ec2::23":
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// fetchInstanceCredentials retrieves the instance role credentials using IMDSv2
+func fetchInstanceCredentials(ctx context.Context, httpClient *http.Client) (*awsCredentials, error) {
+	tokenCtx, cancel := context.WithTimeout(ctx, awsMetadataTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(tokenCtx, http.MethodPut, awsMetadataHost+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials found: %w", err)
+	}
+	token, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("no AWS credentials found: instance metadata token request returned status %d", resp.StatusCode)
+	}
+
+	headers := map[string]string{"X-aws-ec2-metadata-token": string(token)}
+	roleURL := awsMetadataHost + "/latest/meta-data/iam/security-credentials/"
+	role, err := fetchAWSText(ctx, httpClient, roleURL, headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance role: %w", err)
+	}
+
+	return fetchAWSCredentials(ctx, httpClient, roleURL+strings.TrimSpace(role), headers)
+}
+
+// fetchAWSCredentials fetches JSON credentials from a metadata endpoint
+func fetchAWSCredentials(ctx context.Context, httpClient *http.Client, url string, headers map[string]string) (*awsCredentials, error) {
+	body, err := fetchAWSText(ctx, httpClient, url, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds awsCredentials
+	if err := json.Unmarshal([]byte(body), &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse AWS credentials: %w", err)
+	}
+	return &creds, nil
+}
+
+// fetchAWSText performs a GET request against a metadata endpoint
+func fetchAWSText(ctx context.Context, httpClient *http.Client, url string, headers map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// signAWSRequest signs a request with AWS Signature Version 4
+func signAWSRequest(req *http.Request, body []byte, creds *awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	// Canonical request over the host and x-amz-* headers
+	headerNames := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if creds.SessionToken != "" {
+		headerNames = append(headerNames, "x-amz-security-token")
+	}
+	if req.Header.Get("X-Amz-Target") != "" {
+		headerNames = append(headerNames, "x-amz-target")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := awsSigningKey(creds.SecretAccessKey, date, region, service)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// awsSigningKey derives the key signing the requests of a day to a service of a region
+func awsSigningKey(secretAccessKey, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+// canonicalQuery encodes query parameters sorted by name and value, every parameter
+// having a value, spaces being encoded as %20
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return awsEscape(names[i]) < awsEscape(names[j]) })
+
+	params := make([]string, 0, len(query))
+	for _, name := range names {
+		values := make([]string, 0, len(query[name]))
+		for _, value := range query[name] {
+			values = append(values, awsEscape(value))
+		}
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, awsEscape(name)+"="+value)
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// awsEscape URI-encodes a query parameter name or value as AWS expects
+func awsEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+// sha256Hex returns the hex encoded SHA-256 of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
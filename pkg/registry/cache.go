@@ -0,0 +1,81 @@
+package registry
+
+import (
+	"context"
+	"errors"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+)
+
+// lookupKey identifies a registry lookup by the canonical form of its repository, so
+// nginx and docker.io/library/nginx share their answers. The tag is empty for tag lists,
+// and the prefix is set for the lists of the tags starting with it.
+type lookupKey struct {
+	repo   string
+	tag    string
+	prefix string
+}
+
+// lookup is the answer of a registry lookup, shared by every reference to its repository
+type lookup struct {
+	done   chan struct{}
+	tags   []string
+	digest string
+	err    error
+}
+
+// lookupOnce returns the answer of a lookup, calling fetch for the first one only.
+// Concurrent lookups of the same key wait for the first answer, or until ctx is
+// cancelled. Answers of cancelled lookups are dropped so the next lookup fetches again.
+func (r *Resolver) lookupOnce(ctx context.Context, key lookupKey, fetch func(l *lookup)) (*lookup, error) {
+	key.repo = reference.Normalize(key.repo)
+
+	r.mu.Lock()
+	if r.lookups == nil {
+		r.lookups = make(map[lookupKey]*lookup)
+	}
+	l, found := r.lookups[key]
+	if !found {
+		l = &lookup{done: make(chan struct{})}
+		r.lookups[key] = l
+	}
+	r.mu.Unlock()
+
+	if found {
+		logger.Debug("Reusing the registry answer for %s", key.repo)
+		select {
+		case <-l.done:
+			return l, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	fetch(l)
+	if errors.Is(l.err, context.Canceled) || errors.Is(l.err, context.DeadlineExceeded) {
+		r.mu.Lock()
+		delete(r.lookups, key)
+		r.mu.Unlock()
+	}
+	close(l.done)
+	return l, nil
+}
+
+// cachedTags returns the tags of a repository fetched by an earlier lookup, false if none
+// completed successfully
+func (r *Resolver) cachedTags(repo string) ([]string, bool) {
+	r.mu.Lock()
+	l, found := r.lookups[lookupKey{repo: reference.Normalize(repo)}]
+	r.mu.Unlock()
+	if !found {
+		return nil, false
+	}
+
+	select {
+	case <-l.done:
+		return l.tags, l.err == nil
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,309 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+)
+
+// CatalogVersion is the version of the catalog file format
+const CatalogVersion = 1
+
+// OCI layout annotations naming the images of an index
+const (
+	annotationRefName       = "org.opencontainers.image.ref.name"
+	annotationContainerName = "io.containerd.image.name"
+)
+
+// Catalog holds the tags of repositories exported on a connected host, so images can be
+// checked without access to their registries
+type Catalog struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	// Repositories maps normalized repositories, such as docker.io/library/nginx, to their tags
+	Repositories map[string]*CatalogRepository `json:"repositories"`
+
+	mu sync.Mutex
+}
+
+// CatalogRepository holds the tags of a repository and the digests and last updates
+// known for them
+type CatalogRepository struct {
+	Tags    []string             `json:"tags"`
+	Digests map[string]string    `json:"digests,omitempty"`
+	Updated map[string]time.Time `json:"updated,omitempty"`
+}
+
+// NewCatalog creates an empty catalog
+func NewCatalog() *Catalog {
+	return &Catalog{
+		Version:      CatalogVersion,
+		ExportedAt:   time.Now().UTC(),
+		Repositories: make(map[string]*CatalogRepository),
+	}
+}
+
+// CatalogKey normalizes a repository, Docker Hub repositories being given their host
+// and official images their library namespace
+func CatalogKey(repo string) string {
+	return reference.Normalize(repo)
+}
+
+// LoadCatalog reads a catalog file written by export-tags, or builds one from an OCI image
+// layout directory whose index names its images
+func LoadCatalog(path string) (*Catalog, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tag catalog: %w", err)
+	}
+	if info.IsDir() {
+		return loadOCILayout(path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tag catalog: %w", err)
+	}
+
+	catalog := NewCatalog()
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse tag catalog %s: %w", path, err)
+	}
+	if catalog.Version != CatalogVersion {
+		return nil, fmt.Errorf("unsupported tag catalog version %d in %s", catalog.Version, path)
+	}
+	return catalog, nil
+}
+
+// loadOCILayout builds a catalog from the index of an OCI image layout. Images are named
+// by the containerd image name annotation or by a reference name holding a full reference,
+// as a reference name made of a tag alone does not tell the repository.
+func loadOCILayout(dir string) (*Catalog, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI layout index: %w", err)
+	}
+
+	var index struct {
+		Manifests []struct {
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse OCI layout index: %w", err)
+	}
+
+	catalog := NewCatalog()
+	if info, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		catalog.ExportedAt = info.ModTime().UTC()
+	}
+	for _, manifest := range index.Manifests {
+		name := manifest.Annotations[annotationContainerName]
+		if name == "" {
+			name = manifest.Annotations[annotationRefName]
+		}
+
+		repo, tag, ok := splitReference(name)
+		if !ok {
+			continue
+		}
+		catalog.Record(repo, tag, manifest.Digest)
+	}
+
+	if len(catalog.Repositories) == 0 {
+		return nil, fmt.Errorf("no named images in OCI layout %s", dir)
+	}
+	return catalog, nil
+}
+
+// splitReference splits a full image reference into its repository and tag
+func splitReference(image string) (string, string, bool) {
+	ref, err := reference.Parse(image)
+	if err != nil || ref.Tag == "" || !strings.Contains(ref.Repository(), "/") {
+		return "", "", false
+	}
+	return ref.Repository(), ref.Tag, true
+}
+
+// Record adds a tag of a repository, with its digest if known
+func (c *Catalog) Record(repo, tag, digest string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entry(repo)
+	if !slices.Contains(entry.Tags, tag) {
+		entry.Tags = append(entry.Tags, tag)
+	}
+	if digest != "" {
+		if entry.Digests == nil {
+			entry.Digests = make(map[string]string)
+		}
+		entry.Digests[tag] = digest
+	}
+}
+
+// RecordTags sets the tags of a repository
+func (c *Catalog) RecordTags(repo string, tags []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entry(repo).Tags = append([]string(nil), tags...)
+}
+
+// RecordTagInfo sets the tags of a repository along with their digests and last updates
+func (c *Catalog) RecordTagInfo(repo string, infos []TagInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entry(repo)
+	entry.Tags = make([]string, 0, len(infos))
+	for _, info := range infos {
+		entry.Tags = append(entry.Tags, info.Name)
+		if info.Digest != "" {
+			if entry.Digests == nil {
+				entry.Digests = make(map[string]string)
+			}
+			entry.Digests[info.Name] = info.Digest
+		}
+		if !info.LastUpdated.IsZero() {
+			if entry.Updated == nil {
+				entry.Updated = make(map[string]time.Time)
+			}
+			entry.Updated[info.Name] = info.LastUpdated
+		}
+	}
+}
+
+// entry returns the entry of a repository, creating it if needed
+func (c *Catalog) entry(repo string) *CatalogRepository {
+	key := CatalogKey(repo)
+	entry, ok := c.Repositories[key]
+	if !ok {
+		entry = &CatalogRepository{}
+		c.Repositories[key] = entry
+	}
+	return entry
+}
+
+// Write saves the catalog to a file, tags sorted so exports can be compared
+func (c *Catalog) Write(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entry := range c.Repositories {
+		sort.Strings(entry.Tags)
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tag catalog: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write tag catalog: %w", err)
+	}
+	return nil
+}
+
+// FetchAllTags returns the tags of a repository exported in the catalog
+func (c *Catalog) FetchAllTags(repo string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Repositories[CatalogKey(repo)]
+	if !ok {
+		return nil, fmt.Errorf("%s is not in the tag catalog exported on %s", repo, c.ExportedAt.Format(time.DateOnly))
+	}
+	return entry.Tags, nil
+}
+
+// FetchTagDigest returns the digest exported for a tag
+func (c *Catalog) FetchTagDigest(repo, tag string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.Repositories[CatalogKey(repo)]
+	if !ok || entry.Digests[tag] == "" {
+		return "", fmt.Errorf("no digest of %s:%s in the tag catalog", repo, tag)
+	}
+	return entry.Digests[tag], nil
+}
+
+// Resolver returns a resolver answering every repository from the catalog
+func (c *Catalog) Resolver() *Resolver {
+	resolver := NewResolver(&catalogHost{catalog: c, host: "docker.io"})
+	resolver.RegisterPattern(AnyHostPattern, func(host string) (Client, error) {
+		return &catalogHost{catalog: c, host: host}, nil
+	})
+	return resolver
+}
+
+// catalogHost serves the repositories of a registry host from a catalog
+type catalogHost struct {
+	catalog *Catalog
+	host    string
+}
+
+// FetchAllTags returns the tags of a repository of the host
+func (h *catalogHost) FetchAllTags(repo string) ([]string, error) {
+	return h.catalog.FetchAllTags(h.host + "/" + repo)
+}
+
+// FetchTagDigest returns the digest of a tag of a repository of the host
+func (h *catalogHost) FetchTagDigest(repo, tag string) (string, error) {
+	return h.catalog.FetchTagDigest(h.host+"/"+repo, tag)
+}
+
+// Recorder is a client recording the tags and digests returned by another one in a catalog
+type Recorder struct {
+	client  Client
+	catalog *Catalog
+}
+
+// NewRecorder creates a client recording the answers of client in catalog
+func NewRecorder(client Client, catalog *Catalog) *Recorder {
+	return &Recorder{client: client, catalog: catalog}
+}
+
+// FetchAllTags fetches and records the tags of a repository, with their digests and last
+// updates when the client lists them
+func (r *Recorder) FetchAllTags(repo string) ([]string, error) {
+	if infoClient, ok := r.client.(TagInfoClient); ok {
+		infos, err := infoClient.FetchTagInfo(repo)
+		if err != nil {
+			return nil, err
+		}
+		r.catalog.RecordTagInfo(repo, infos)
+
+		tags := make([]string, 0, len(infos))
+		for _, info := range infos {
+			tags = append(tags, info.Name)
+		}
+		return tags, nil
+	}
+
+	tags, err := r.client.FetchAllTags(repo)
+	if err != nil {
+		return nil, err
+	}
+	r.catalog.RecordTags(repo, tags)
+	return tags, nil
+}
+
+// FetchTagDigest fetches and records the digest of a tag
+func (r *Recorder) FetchTagDigest(repo, tag string) (string, error) {
+	digest, err := r.client.FetchTagDigest(repo, tag)
+	if err != nil {
+		return "", err
+	}
+	r.catalog.Record(repo, tag, digest)
+	return digest, nil
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// tagsClient is a registry client serving fixed tags and digests
+type tagsClient struct {
+	tags    map[string][]string
+	digests map[string]string
+}
+
+func (c *tagsClient) FetchAllTags(repo string) ([]string, error) {
+	return c.tags[repo], nil
+}
+
+func (c *tagsClient) FetchTagDigest(repo, tag string) (string, error) {
+	return c.digests[repo+":"+tag], nil
+}
+
+func TestCatalogRoundTrip(t *testing.T) {
+	online := NewResolver(&tagsClient{
+		tags:    map[string][]string{"nginx": {"1.25.4", "1.25.3"}},
+		digests: map[string]string{"nginx:latest": "sha256:abc"},
+	})
+	online.Register("ghcr.io", &tagsClient{tags: map[string][]string{"org/app": {"1.0.0"}}})
+
+	catalog := NewCatalog()
+	recorder := NewRecorder(online, catalog)
+	for _, repo := range []string{"nginx", "ghcr.io/org/app"} {
+		if _, err := recorder.FetchAllTags(repo); err != nil {
+			t.Fatalf("FetchAllTags(%s) error = %v", repo, err)
+		}
+	}
+	if _, err := recorder.FetchTagDigest("nginx", "latest"); err != nil {
+		t.Fatalf("FetchTagDigest() error = %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tags.json")
+	if err := catalog.Write(path); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	loaded, err := LoadCatalog(path)
+	if err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+
+	// Repositories are found whatever the way they are written
+	offline := loaded.Resolver()
+	tags, err := offline.FetchAllTags("docker.io/library/nginx")
+	if err != nil {
+		t.Fatalf("FetchAllTags() error = %v", err)
+	}
+	if want := []string{"1.25.3", "1.25.4", "latest"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("FetchAllTags() = %v, want %v", tags, want)
+	}
+	if tags, _ := offline.FetchAllTags("ghcr.io/org/app"); !reflect.DeepEqual(tags, []string{"1.0.0"}) {
+		t.Errorf("FetchAllTags(ghcr.io/org/app) = %v", tags)
+	}
+	if digest, err := offline.FetchTagDigest("nginx", "latest"); err != nil || digest != "sha256:abc" {
+		t.Errorf("FetchTagDigest() = %q, %v", digest, err)
+	}
+	if _, err := offline.FetchAllTags("redis"); err == nil {
+		t.Error("FetchAllTags() of a repository missing from the catalog should fail")
+	}
+}
+
+func TestLoadCatalogOCILayout(t *testing.T) {
+	dir := t.TempDir()
+	index := `{"schemaVersion": 2, "manifests": [
+		{"digest": "sha256:aaa", "annotations": {"io.containerd.image.name": "docker.io/library/nginx:1.25.4", "org.opencontainers.image.ref.name": "1.25.4"}},
+		{"digest": "sha256:bbb", "annotations": {"org.opencontainers.image.ref.name": "ghcr.io/org/app:1.0.0"}},
+		{"digest": "sha256:ccc", "annotations": {"org.opencontainers.image.ref.name": "latest"}}
+	]}`
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	catalog, err := LoadCatalog(dir)
+	if err != nil {
+		t.Fatalf("LoadCatalog() error = %v", err)
+	}
+	if len(catalog.Repositories) != 2 {
+		t.Errorf("Repositories = %v, want the 2 named images", catalog.Repositories)
+	}
+	if digest, err := catalog.FetchTagDigest("nginx", "1.25.4"); err != nil || digest != "sha256:aaa" {
+		t.Errorf("FetchTagDigest() = %q, %v", digest, err)
+	}
+}
+
+// infoClient is a registry client listing tags with their digests and last updates
+type infoClient struct {
+	tagsClient
+	infos []TagInfo
+}
+
+func (c *infoClient) FetchTagInfo(string) ([]TagInfo, error) {
+	return c.infos, nil
+}
+
+func TestRecorderTagInfo(t *testing.T) {
+	pushed := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	resolver := NewResolver(&infoClient{infos: []TagInfo{
+		{Name: "1.25.4", Digest: "sha256:aaa", LastUpdated: pushed},
+		{Name: "1.25.3"},
+	}})
+
+	catalog := NewCatalog()
+	tags, err := NewRecorder(resolver, catalog).FetchAllTags("nginx")
+	if err != nil {
+		t.Fatalf("FetchAllTags() error = %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.25.4", "1.25.3"}) {
+		t.Errorf("FetchAllTags() = %v", tags)
+	}
+
+	entry := catalog.Repositories["docker.io/library/nginx"]
+	if entry == nil || entry.Digests["1.25.4"] != "sha256:aaa" || !entry.Updated["1.25.4"].Equal(pushed) {
+		t.Errorf("catalog entry = %+v, want the digest and last update of 1.25.4", entry)
+	}
+	if _, ok := entry.Updated["1.25.3"]; ok {
+		t.Error("catalog entry records an unknown last update")
+	}
+}
@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// Credentials holds the basic authentication credentials for a registry
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// dockerConfig represents the parts of a Docker CLI config.json used for authentication
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+}
+
+// LoadDockerCredentials returns the credentials stored for host in the Docker CLI
+// config ($DOCKER_CONFIG/config.json or ~/.docker/config.json), or nil if there are none
+func LoadDockerCredentials(host string) *Credentials {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		configDir = filepath.Join(homeDir, ".docker")
+	}
+
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return nil
+	}
+
+	var cfg dockerConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		logger.Warn("Failed to parse Docker config: %v", err)
+		return nil
+	}
+
+	for key, entry := range cfg.Auths {
+		// Entries may be stored as host names or URLs
+		entryHost := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+		entryHost = strings.SplitN(entryHost, "/", 2)[0]
+		if !strings.EqualFold(entryHost, host) || entry.Auth == "" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			logger.Warn("Failed to decode Docker credentials for %s: %v", host, err)
+			return nil
+		}
+
+		username, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return nil
+		}
+
+		logger.Debug("Using Docker config credentials for %s", host)
+		return &Credentials{Username: username, Password: password}
+	}
+
+	return nil
+}
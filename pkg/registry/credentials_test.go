@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadDockerCredentials(t *testing.T) {
+	auth := func(credentials string) string {
+		return base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+	dir := t.TempDir()
+	config := `{"auths": {
+		"harbor.example.com": {"auth": "` + auth("robot:secret:with:colons") + `"},
+		"https://artifactory.example.com/v1/": {"auth": "` + auth("ci:token") + `"},
+		"empty.example.com": {"auth": ""},
+		"invalid.example.com": {"auth": "not base64!"},
+		"nocolon.example.com": {"auth": "` + auth("robot") + `"}
+	}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+
+	tests := []struct {
+		host string
+		want *Credentials
+	}{
+		{"harbor.example.com", &Credentials{Username: "robot", Password: "secret:with:colons"}},
+		{"HARBOR.example.com", &Credentials{Username: "robot", Password: "secret:with:colons"}},
+		{"artifactory.example.com", &Credentials{Username: "ci", Password: "token"}},
+		{"empty.example.com", nil},
+		{"invalid.example.com", nil},
+		{"nocolon.example.com", nil},
+		{"quay.io", nil},
+	}
+	for _, tt := range tests {
+		got := LoadDockerCredentials(tt.host)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("LoadDockerCredentials(%s) = %+v, want %+v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestLoadDockerCredentialsHomeDirectory(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", "")
+	t.Setenv("HOME", home)
+
+	if got := LoadDockerCredentials("harbor.example.com"); got != nil {
+		t.Errorf("LoadDockerCredentials() without config = %+v, want nil", got)
+	}
+
+	if err := os.MkdirAll(filepath.Join(home, ".docker"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config := `{"auths": {"harbor.example.com": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("robot:secret")) + `"}}}`
+	if err := os.WriteFile(filepath.Join(home, ".docker", "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := LoadDockerCredentials("harbor.example.com"); got == nil || got.Username != "robot" || got.Password != "secret" {
+		t.Errorf("LoadDockerCredentials() = %+v, want the credentials of ~/.docker/config.json", got)
+	}
+}
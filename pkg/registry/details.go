@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Platform is an operating system and architecture an image is published for
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String returns the platform formatted as os/arch[/variant]
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// ImageDetails describes the image published for a tag
+type ImageDetails struct {
+	// Size is the compressed size in bytes of the linux/amd64 image, or of the first
+	// platform when there is none, 0 if unknown
+	Size int64
+	// Platforms lists the platforms the image is published for, empty if unknown
+	Platforms []Platform
+}
+
+// DetailsClient is implemented by registry adapters able to describe the image of a tag
+type DetailsClient interface {
+	FetchImageDetails(repo, tag string) (*ImageDetails, error)
+}
+
+// MissingPlatforms returns the platforms of old that are not in details. Both must list
+// their platforms for a comparison, otherwise nothing is reported missing.
+func (d *ImageDetails) MissingPlatforms(old *ImageDetails) []Platform {
+	if len(d.Platforms) == 0 || old == nil {
+		return nil
+	}
+
+	var missing []Platform
+	for _, platform := range old.Platforms {
+		if !slices.Contains(d.Platforms, platform) {
+			missing = append(missing, platform)
+		}
+	}
+	return missing
+}
+
+// FetchImageDetails fetches the details of a tag from the repository's registry, if
+// its adapter supports it
+func (r *Resolver) FetchImageDetails(repo, tag string) (*ImageDetails, error) {
+	client, path, host, err := r.route(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	detailsClient, ok := client.(DetailsClient)
+	if !ok {
+		return nil, fmt.Errorf("registry of %s does not provide image details", repo)
+	}
+	defer r.acquire(host)()
+	return detailsClient.FetchImageDetails(path, tag)
+}
+
+// manifestResponse holds the fields of image manifests and manifest lists
+type manifestResponse struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		Digest string `json:"digest"`
+		Size   int64  `json:"size"`
+	} `json:"config"`
+	Layers []struct {
+		Size int64 `json:"size"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest   string    `json:"digest"`
+		Platform *Platform `json:"platform"`
+	} `json:"manifests"`
+}
+
+// size returns the compressed size of an image manifest
+func (m *manifestResponse) size() int64 {
+	size := m.Config.Size
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// fetchV2Details fetches the manifest of a tag through a registry v2 API URL, listing
+// the platforms of manifest lists and sizing the linux/amd64 image
+func (b *baseClient) fetchV2Details(ctx context.Context, registryURL, tag string) (*ImageDetails, error) {
+	var manifest manifestResponse
+	if _, err := b.do(ctx, http.MethodGet, registryURL+"/manifests/"+url.PathEscape(tag), ManifestAcceptHeader, &manifest); err != nil {
+		return nil, fmt.Errorf("error fetching manifest: %w", err)
+	}
+
+	// Single platform images declare their platform in their configuration
+	if len(manifest.Manifests) == 0 {
+		details := &ImageDetails{Size: manifest.size()}
+		var config Platform
+		if _, err := b.do(ctx, http.MethodGet, registryURL+"/blobs/"+manifest.Config.Digest, "", &config); err != nil {
+			return nil, fmt.Errorf("error fetching image configuration: %w", err)
+		}
+		if config.OS != "" {
+			details.Platforms = []Platform{config}
+		}
+		return details, nil
+	}
+
+	details := &ImageDetails{}
+	sizedDigest := ""
+	for _, entry := range manifest.Manifests {
+		// Attestation manifests are listed with an unknown platform
+		if entry.Platform == nil || entry.Platform.OS == "unknown" {
+			continue
+		}
+		details.Platforms = append(details.Platforms, *entry.Platform)
+		if sizedDigest == "" || (entry.Platform.OS == "linux" && entry.Platform.Architecture == "amd64") {
+			sizedDigest = entry.Digest
+		}
+	}
+
+	if sizedDigest != "" {
+		var image manifestResponse
+		if _, err := b.do(ctx, http.MethodGet, registryURL+"/manifests/"+sizedDigest, ManifestAcceptHeader, &image); err != nil {
+			return nil, fmt.Errorf("error fetching image manifest: %w", err)
+		}
+		details.Size = image.size()
+	}
+
+	return details, nil
+}
+
+// FormatSize formats a size in bytes with a binary unit, such as 12.3 MiB
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value := float64(size)
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	i := -1
+	for value >= unit && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", value), ".0") + " " + units[i]
+}
+
+// ParsePlatform parses a platform formatted as os/arch[/variant], such as linux/arm64
+func ParsePlatform(value string) (Platform, error) {
+	parts := strings.Split(value, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, must be os/arch[/variant]", value)
+	}
+
+	platform := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// Satisfies reports whether the platform fulfills a required one, any variant
+// satisfying a requirement without variant
+func (p Platform) Satisfies(required Platform) bool {
+	if p.OS != required.OS || p.Architecture != required.Architecture {
+		return false
+	}
+	return required.Variant == "" || p.Variant == required.Variant
+}
+
+// Supports reports whether the image is published for a platform
+func (d *ImageDetails) Supports(required Platform) bool {
+	for _, platform := range d.Platforms {
+		if platform.Satisfies(required) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestFetchImageDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/project/app/manifests/1.2.0":
+			_, _ = w.Write([]byte(`{"mediaType": "application/vnd.oci.image.index.v1+json", "manifests": [
+				{"digest": "sha256:arm", "platform": {"os": "linux", "architecture": "arm64"}},
+				{"digest": "sha256:amd", "platform": {"os": "linux", "architecture": "amd64"}},
+				{"digest": "sha256:att", "platform": {"os": "unknown", "architecture": "unknown"}}
+			]}`))
+		case "/v2/project/app/manifests/sha256:amd":
+			_, _ = w.Write([]byte(`{"config": {"size": 100}, "layers": [{"size": 1000}, {"size": 2000}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewHarborClient(server.URL, nil)
+	details, err := client.FetchImageDetails("project/app", "1.2.0")
+	if err != nil {
+		t.Fatalf("FetchImageDetails() error = %v", err)
+	}
+
+	if details.Size != 3100 {
+		t.Errorf("Size = %d, want 3100", details.Size)
+	}
+	want := []Platform{{OS: "linux", Architecture: "arm64"}, {OS: "linux", Architecture: "amd64"}}
+	if !reflect.DeepEqual(details.Platforms, want) {
+		t.Errorf("Platforms = %v, want %v", details.Platforms, want)
+	}
+}
+
+func TestMissingPlatforms(t *testing.T) {
+	amd64 := Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := Platform{OS: "linux", Architecture: "arm64"}
+
+	old := &ImageDetails{Platforms: []Platform{amd64, arm64}}
+	if missing := (&ImageDetails{Platforms: []Platform{amd64}}).MissingPlatforms(old); !reflect.DeepEqual(missing, []Platform{arm64}) {
+		t.Errorf("MissingPlatforms() = %v, want [%v]", missing, arm64)
+	}
+	if missing := (&ImageDetails{}).MissingPlatforms(old); missing != nil {
+		t.Errorf("MissingPlatforms() without platforms = %v, want none", missing)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	testCases := map[int64]string{
+		512:                    "512 B",
+		2048:                   "2 KiB",
+		47 * 1024 * 1024 / 10:  "4.7 MiB",
+		3 * 1024 * 1024 * 1024: "3 GiB",
+	}
+
+	for size, want := range testCases {
+		if got := FormatSize(size); got != want {
+			t.Errorf("FormatSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
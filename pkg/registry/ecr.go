@@ -0,0 +1,169 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// ECRHostPattern matches Amazon ECR private registry hosts and captures the region
+var ECRHostPattern = regexp.MustCompile(`^\d{12}\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// ecrAuthorizationResponse represents the response of the ECR GetAuthorizationToken action
+type ecrAuthorizationResponse struct {
+	AuthorizationData []struct {
+		AuthorizationToken string  `json:"authorizationToken"`
+		ExpiresAt          float64 `json:"expiresAt"`
+	} `json:"authorizationData"`
+}
+
+// ECRClient lists tags of Amazon ECR repositories. It exchanges AWS credentials
+// for a registry authorization token and then uses the registry v2 API.
+type ECRClient struct {
+	baseClient
+	region    string
+	pageSize  int
+	expiresAt time.Time
+	mu        sync.Mutex
+}
+
+// NewECRClient creates an ECR client for a registry host such as
+// 123456789012.dkr.ecr.us-east-1.amazonaws.com
+func NewECRClient(host string) (*ECRClient, error) {
+	matches := ECRHostPattern.FindStringSubmatch(host)
+	if matches == nil {
+		return nil, fmt.Errorf("not an ECR registry host: %s", host)
+	}
+
+	return &ECRClient{
+		baseClient: newBaseClient("https://"+host, nil),
+		region:     matches[1],
+		pageSize:   DefaultPageSize,
+	}, nil
+}
+
+// authenticate fetches a new authorization token when none is cached or it expired
+func (c *ECRClient) authenticate(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hasCredentials() && time.Now().Before(c.expiresAt) {
+		return nil
+	}
+
+	creds, err := loadAWSCredentials(ctx, c.httpClient)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS credentials: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("https://api.ecr.%s.amazonaws.com/", c.region)
+	if strings.HasPrefix(c.region, "cn-") {
+		endpoint = fmt.Sprintf("https://api.ecr.%s.amazonaws.com.cn/", c.region)
+	}
+	body := []byte("{}")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken")
+	signAWSRequest(req, body, creds, c.region, "ecr", time.Now())
+
+	logger.Debug("Requesting ECR authorization token in %s", c.region)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error requesting authorization token: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GetAuthorizationToken failed (status %d): %s", resp.StatusCode, string(respBody))
+	}
+
+	var parsed ecrAuthorizationResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return fmt.Errorf("JSON parse error: %w", err)
+	}
+	if len(parsed.AuthorizationData) == 0 {
+		return fmt.Errorf("no authorization data returned")
+	}
+
+	// The token is base64 encoded AWS:<password>
+	decoded, err := base64.StdEncoding.DecodeString(parsed.AuthorizationData[0].AuthorizationToken)
+	if err != nil {
+		return fmt.Errorf("failed to decode authorization token: %w", err)
+	}
+	username, password, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return fmt.Errorf("malformed authorization token")
+	}
+
+	c.setCredentials(&Credentials{Username: username, Password: password})
+	c.expiresAt = time.Unix(int64(parsed.AuthorizationData[0].ExpiresAt), 0).Add(-time.Minute)
+	return nil
+}
+
+// FetchAllTags fetches all tags of a repository
+func (c *ECRClient) FetchAllTags(repo string) ([]string, error) {
+	return c.FetchAllTagsWithContext(context.Background(), repo)
+}
+
+// FetchAllTagsWithContext fetches all tags of a repository with context
+func (c *ECRClient) FetchAllTagsWithContext(ctx context.Context, repo string) ([]string, error) {
+	if err := c.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("Fetching ECR tags for %s", repo)
+
+	tags, err := c.listV2Tags(ctx, c.baseURL+"/v2/"+repo, c.pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Found %d tags for %s", len(tags), repo)
+	return tags, nil
+}
+
+// FetchTagDigest resolves the manifest digest of a tag
+func (c *ECRClient) FetchTagDigest(repo, tag string) (string, error) {
+	return c.FetchTagDigestWithContext(context.Background(), repo, tag)
+}
+
+// FetchTagDigestWithContext resolves the manifest digest of a tag with context
+func (c *ECRClient) FetchTagDigestWithContext(ctx context.Context, repo, tag string) (string, error) {
+	if err := c.authenticate(ctx); err != nil {
+		return "", err
+	}
+
+	return c.fetchV2Digest(ctx, c.baseURL+"/v2/"+repo, tag)
+}
+
+// FetchImageDetails fetches the size and platforms of the image of a tag
+func (c *ECRClient) FetchImageDetails(repo, tag string) (*ImageDetails, error) {
+	ctx := context.Background()
+	if err := c.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.fetchV2Details(ctx, c.baseURL+"/v2/"+repo, tag)
+}
@@ -0,0 +1,128 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// ETagCacheVersion is the version of the ETag cache file format
+const ETagCacheVersion = 1
+
+// ETagCacheMaxAge is how long the pages not requested again are kept in the ETag cache
+const ETagCacheMaxAge = 30 * 24 * time.Hour
+
+// CachedPage is a page of a tag list kept with the ETag the registry sent it with
+type CachedPage struct {
+	ETag string `json:"etag"`
+	// Link is the Link header of the page, pointing to the next one
+	Link   string          `json:"link,omitempty"`
+	Body   json.RawMessage `json:"body"`
+	SeenAt time.Time       `json:"seen_at"`
+}
+
+// ETagCache keeps the pages of the tag lists of repositories between runs, by URL, so
+// registries answer the unchanged ones with a 304 instead of sending them again.
+// A nil ETagCache caches nothing.
+type ETagCache struct {
+	Version int                   `json:"version"`
+	Pages   map[string]CachedPage `json:"pages"`
+
+	path    string
+	changed bool
+	mu      sync.Mutex
+}
+
+// ETagClient is implemented by registry adapters sending the ETag of cached pages
+type ETagClient interface {
+	SetETagCache(cache *ETagCache)
+}
+
+// LoadETagCache reads the ETag cache file at path, returning an empty cache if it does
+// not exist yet. An unreadable cache is only logged, its pages being downloaded again.
+func LoadETagCache(path string) *ETagCache {
+	cache := &ETagCache{Version: ETagCacheVersion, Pages: make(map[string]CachedPage), path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache
+	}
+	if err != nil {
+		logger.Warn("Failed to read ETag cache, ignoring it: %v", err)
+		return cache
+	}
+
+	var loaded ETagCache
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		logger.Warn("Failed to parse ETag cache %s, ignoring it: %v", path, err)
+		return cache
+	}
+	if loaded.Version != ETagCacheVersion {
+		logger.Warn("Unsupported ETag cache version %d in %s, ignoring it", loaded.Version, path)
+		return cache
+	}
+
+	for url, page := range loaded.Pages {
+		if time.Since(page.SeenAt) < ETagCacheMaxAge {
+			cache.Pages[url] = page
+		}
+	}
+	logger.Debug("Loaded %d cached tag pages from %s", len(cache.Pages), path)
+	return cache
+}
+
+// Page returns the cached page of a URL
+func (c *ETagCache) Page(url string) (CachedPage, bool) {
+	if c == nil {
+		return CachedPage{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	page, ok := c.Pages[url]
+	return page, ok
+}
+
+// Store records the page of a URL, or that it was requested again
+func (c *ETagCache) Store(url string, page CachedPage) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	page.SeenAt = time.Now().UTC()
+	c.Pages[url] = page
+	c.changed = true
+}
+
+// Save writes the cache file if pages were stored since it was loaded
+func (c *ETagCache) Save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.changed {
+		return nil
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode ETag cache: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write ETag cache: %w", err)
+	}
+
+	c.changed = false
+	return nil
+}
@@ -0,0 +1,85 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOCIClientETagCache(t *testing.T) {
+	downloads := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		_, _ = w.Write([]byte(`{"name": "pause", "tags": ["3.9", "3.10"]}`))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "tags.json")
+	for run := range 2 {
+		cache := LoadETagCache(path)
+		client := NewOCIClient(strings.TrimPrefix(server.URL, "https://"), nil)
+		client.httpClient = server.Client()
+		client.SetETagCache(cache)
+
+		tags, err := client.FetchAllTagsWithContext(context.Background(), "pause")
+		if err != nil {
+			t.Fatalf("run %d: FetchAllTags() error = %v", run, err)
+		}
+		if !reflect.DeepEqual(tags, []string{"3.9", "3.10"}) {
+			t.Errorf("run %d: FetchAllTags() = %v", run, tags)
+		}
+		if err := cache.Save(); err != nil {
+			t.Fatalf("run %d: Save() error = %v", run, err)
+		}
+	}
+
+	// The second run is answered from the cache
+	if downloads != 1 {
+		t.Errorf("tag list downloaded %d times, want 1", downloads)
+	}
+}
+
+func TestLoadETagCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tags.json")
+
+	// Missing and unreadable caches start empty
+	if cache := LoadETagCache(path); len(cache.Pages) != 0 {
+		t.Errorf("LoadETagCache() of a missing file = %v, want empty", cache.Pages)
+	}
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cache := LoadETagCache(path); len(cache.Pages) != 0 {
+		t.Errorf("LoadETagCache() of an invalid file = %v, want empty", cache.Pages)
+	}
+
+	// Pages not requested for too long are dropped
+	cache := LoadETagCache(path)
+	cache.Store("https://registry.test/v2/old/tags/list", CachedPage{ETag: `"a"`, Body: []byte(`{}`)})
+	cache.Store("https://registry.test/v2/new/tags/list", CachedPage{ETag: `"b"`, Body: []byte(`{}`)})
+	old := cache.Pages["https://registry.test/v2/old/tags/list"]
+	old.SeenAt = time.Now().Add(-ETagCacheMaxAge - time.Hour)
+	cache.Pages["https://registry.test/v2/old/tags/list"] = old
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded := LoadETagCache(path)
+	if _, ok := loaded.Page("https://registry.test/v2/old/tags/list"); ok {
+		t.Error("LoadETagCache() kept an expired page")
+	}
+	if page, ok := loaded.Page("https://registry.test/v2/new/tags/list"); !ok || page.ETag != `"b"` {
+		t.Errorf("LoadETagCache() page = %+v, %v, want the recent page", page, ok)
+	}
+}
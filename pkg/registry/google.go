@@ -0,0 +1,303 @@
+package registry
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// googleCloudPlatformScope is the OAuth2 scope requested for registry access
+const googleCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+var (
+	// googleTokenURL is the OAuth2 token endpoint of Google
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+
+	// googleMetadataTokenURL serves access tokens of the attached service account on GCP
+	googleMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+	// googleMetadataTimeout bounds the request to the metadata server, which does not
+	// answer outside of GCP
+	googleMetadataTimeout = time.Second
+)
+
+// errNoGoogleCredentials is returned when no Application Default Credentials are found
+var errNoGoogleCredentials = errors.New("no Google credentials found")
+
+// GoogleHostPattern matches Container Registry and Artifact Registry hosts
+var GoogleHostPattern = regexp.MustCompile(`^([a-z]+\.)?gcr\.io$|^[a-z0-9-]+-docker\.pkg\.dev$`)
+
+// googleCredentialsFile represents a service account key or authorized user credentials file
+type googleCredentialsFile struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// googleTokenResponse represents an OAuth2 access token response
+type googleTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// GoogleClient lists tags of gcr.io and Artifact Registry repositories using
+// Application Default Credentials and the registry v2 API, anonymously when there are none
+type GoogleClient struct {
+	baseClient
+	pageSize  int
+	expiresAt time.Time
+	// anonymous is set once no credentials were found, public repositories being read
+	// with the tokens of the registry
+	anonymous bool
+	mu        sync.Mutex
+}
+
+// NewGoogleClient creates a client for a gcr.io or *-docker.pkg.dev host
+func NewGoogleClient(host string) (*GoogleClient, error) {
+	if !GoogleHostPattern.MatchString(host) {
+		return nil, fmt.Errorf("not a Google registry host: %s", host)
+	}
+
+	client := &GoogleClient{
+		baseClient: newBaseClient("https://"+host, nil),
+		pageSize:   DefaultPageSize,
+	}
+	// Anonymous requests are answered with a token challenge
+	client.tokens = &tokenSource{tokens: make(map[string]string)}
+	return client, nil
+}
+
+// authenticate obtains an access token when none is cached or it expired
+func (c *GoogleClient) authenticate(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.anonymous || (c.hasCredentials() && time.Now().Before(c.expiresAt)) {
+		return nil
+	}
+
+	token, err := c.fetchAccessToken(ctx)
+	if errors.Is(err, errNoGoogleCredentials) {
+		logger.Debug("Reading %s anonymously: %v", c.baseURL, err)
+		c.anonymous = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to obtain Google access token: %w", err)
+	}
+
+	c.setCredentials(&Credentials{Username: "oauth2accesstoken", Password: token.AccessToken})
+	c.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return nil
+}
+
+// fetchAccessToken resolves Application Default Credentials: the file referenced by
+// GOOGLE_APPLICATION_CREDENTIALS, the gcloud well-known file, then the metadata server,
+// returning errNoGoogleCredentials when the metadata server cannot be reached
+func (c *GoogleClient) fetchAccessToken(ctx context.Context) (*googleTokenResponse, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		if configDir, err := os.UserConfigDir(); err == nil {
+			wellKnown := filepath.Join(configDir, "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(wellKnown); err == nil {
+				path = wellKnown
+			}
+		}
+	}
+
+	if path == "" {
+		metadataCtx, cancel := context.WithTimeout(ctx, googleMetadataTimeout)
+		defer cancel()
+
+		token, err := c.requestToken(metadataCtx, http.MethodGet, googleMetadataTokenURL, nil, map[string]string{"Metadata-Flavor": "Google"})
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && ctx.Err() == nil {
+			return nil, fmt.Errorf("%w: metadata server not reachable: %v", errNoGoogleCredentials, err)
+		}
+		if err == nil {
+			logger.Debug("Using Google metadata server credentials")
+		}
+		return token, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	var creds googleCredentialsFile
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials file: %w", err)
+	}
+
+	switch creds.Type {
+	case "service_account":
+		logger.Debug("Using Google service account %s", creds.ClientEmail)
+		assertion, err := signServiceAccountJWT(&creds)
+		if err != nil {
+			return nil, err
+		}
+		form := url.Values{}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+		return c.requestToken(ctx, http.MethodPost, googleTokenURL, form, nil)
+	case "authorized_user":
+		logger.Debug("Using Google authorized user credentials")
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", creds.ClientID)
+		form.Set("client_secret", creds.ClientSecret)
+		form.Set("refresh_token", creds.RefreshToken)
+		return c.requestToken(ctx, http.MethodPost, googleTokenURL, form, nil)
+	default:
+		return nil, fmt.Errorf("unsupported credentials type: %s", creds.Type)
+	}
+}
+
+// requestToken performs an access token request
+func (c *GoogleClient) requestToken(ctx context.Context, method, tokenURL string, form url.Values, headers map[string]string) (*googleTokenResponse, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, tokenURL, body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting token: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed with status code: %d", resp.StatusCode)
+	}
+
+	var token googleTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("JSON parse error: %w", err)
+	}
+	return &token, nil
+}
+
+// signServiceAccountJWT builds the RS256 signed JWT assertion of a service account
+func signServiceAccountJWT(creds *googleCredentialsFile) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid service account private key")
+	}
+
+	parsedKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse service account private key: %w", err)
+	}
+	key, ok := parsedKey.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("service account private key is not an RSA key")
+	}
+
+	audience := creds.TokenURI
+	if audience == "" {
+		audience = googleTokenURL
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": googleCloudPlatformScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// FetchAllTags fetches all tags of a repository
+func (c *GoogleClient) FetchAllTags(repo string) ([]string, error) {
+	return c.FetchAllTagsWithContext(context.Background(), repo)
+}
+
+// FetchAllTagsWithContext fetches all tags of a repository with context
+func (c *GoogleClient) FetchAllTagsWithContext(ctx context.Context, repo string) ([]string, error) {
+	if err := c.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("Fetching Google registry tags for %s", repo)
+
+	tags, err := c.listV2Tags(ctx, c.baseURL+"/v2/"+repo, c.pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Found %d tags for %s", len(tags), repo)
+	return tags, nil
+}
+
+// FetchTagDigest resolves the manifest digest of a tag
+func (c *GoogleClient) FetchTagDigest(repo, tag string) (string, error) {
+	return c.FetchTagDigestWithContext(context.Background(), repo, tag)
+}
+
+// FetchTagDigestWithContext resolves the manifest digest of a tag with context
+func (c *GoogleClient) FetchTagDigestWithContext(ctx context.Context, repo, tag string) (string, error) {
+	if err := c.authenticate(ctx); err != nil {
+		return "", err
+	}
+
+	return c.fetchV2Digest(ctx, c.baseURL+"/v2/"+repo, tag)
+}
+
+// FetchImageDetails fetches the size and platforms of the image of a tag
+func (c *GoogleClient) FetchImageDetails(repo, tag string) (*ImageDetails, error) {
+	ctx := context.Background()
+	if err := c.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.fetchV2Details(ctx, c.baseURL+"/v2/"+repo, tag)
+}
@@ -0,0 +1,286 @@
+package registry
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// googleServer is a Google registry answering the tags of nginx to the access token
+// "access", with the OAuth2 token endpoint under /token and the metadata server under
+// /metadata
+type googleServer struct {
+	*httptest.Server
+	// forms are the token requests received
+	forms []map[string]string
+	// metadata counts the metadata server requests
+	metadata int
+}
+
+func newGoogleServer(t *testing.T) *googleServer {
+	t.Helper()
+	g := &googleServer{}
+	g.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if err := r.ParseForm(); err != nil {
+				t.Errorf("invalid token request: %v", err)
+			}
+			form := make(map[string]string)
+			for key := range r.PostForm {
+				form[key] = r.PostForm.Get(key)
+			}
+			g.forms = append(g.forms, form)
+			_, _ = w.Write([]byte(`{"access_token": "access", "expires_in": 3600}`))
+		case "/metadata":
+			g.metadata++
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "access", "expires_in": 3600}`))
+		case "/v2/project/nginx/tags/list":
+			if username, password, ok := r.BasicAuth(); !ok || username != "oauth2accesstoken" || password != "access" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"name": "project/nginx", "tags": ["1.25", "1.27"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(g.Close)
+	return g
+}
+
+// newGoogleTestClient returns a client of a Google server, reading credentials from the
+// given file, the metadata server being unreachable unless metadata is set
+func newGoogleTestClient(t *testing.T, server *googleServer, credentialsFile string, metadata bool) *GoogleClient {
+	t.Helper()
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentialsFile)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	tokenURL, metadataURL := googleTokenURL, googleMetadataTokenURL
+	googleTokenURL = server.URL + "/token"
+	googleMetadataTokenURL = server.URL + "/metadata"
+	if !metadata {
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+		googleMetadataTokenURL = closed.URL + "/metadata"
+	}
+	t.Cleanup(func() { googleTokenURL, googleMetadataTokenURL = tokenURL, metadataURL })
+
+	client, err := NewGoogleClient("europe-docker.pkg.dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.baseURL = server.URL
+	client.httpClient = server.Client()
+	return client
+}
+
+// writeGoogleCredentials writes a credentials file and returns its path
+func writeGoogleCredentials(t *testing.T, path string, creds googleCredentialsFile) string {
+	t.Helper()
+	data, err := json.Marshal(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newServiceAccountKey returns a service account private key and its PEM encoding
+func newServiceAccountKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func TestSignServiceAccountJWT(t *testing.T) {
+	key, privateKey := newServiceAccountKey(t)
+	creds := &googleCredentialsFile{ClientEmail: "ci@project.iam.gserviceaccount.com", PrivateKey: privateKey}
+
+	assertion, err := signServiceAccountJWT(creds)
+	if err != nil {
+		t.Fatalf("signServiceAccountJWT() error = %v", err)
+	}
+
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("signServiceAccountJWT() = %q, want three parts", assertion)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+
+	var header map[string]string
+	var claims map[string]any
+	for i, target := range []any{&header, &claims} {
+		data, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		t.Errorf("header = %v, want RS256 JWT", header)
+	}
+	if claims["iss"] != creds.ClientEmail || claims["aud"] != googleTokenURL || claims["scope"] != googleCloudPlatformScope {
+		t.Errorf("claims = %v", claims)
+	}
+	if iat, exp := claims["iat"].(float64), claims["exp"].(float64); exp-iat != time.Hour.Seconds() {
+		t.Errorf("claims valid from %v until %v, want one hour", iat, exp)
+	}
+
+	if _, err := signServiceAccountJWT(&googleCredentialsFile{PrivateKey: "not a key"}); err == nil {
+		t.Error("signServiceAccountJWT() with an invalid key succeeded")
+	}
+}
+
+func TestGoogleClientServiceAccount(t *testing.T) {
+	server := newGoogleServer(t)
+	key, privateKey := newServiceAccountKey(t)
+	path := writeGoogleCredentials(t, filepath.Join(t.TempDir(), "key.json"), googleCredentialsFile{
+		Type:        "service_account",
+		ClientEmail: "ci@project.iam.gserviceaccount.com",
+		PrivateKey:  privateKey,
+	})
+	client := newGoogleTestClient(t, server, path, false)
+
+	for range 2 {
+		tags, err := client.FetchAllTags("project/nginx")
+		if err != nil {
+			t.Fatalf("FetchAllTags() error = %v", err)
+		}
+		if !reflect.DeepEqual(tags, []string{"1.25", "1.27"}) {
+			t.Errorf("FetchAllTags() = %v", tags)
+		}
+	}
+
+	// The token is requested once with a signed assertion and cached until it expires
+	if len(server.forms) != 1 {
+		t.Fatalf("token requests = %v, want one", server.forms)
+	}
+	form := server.forms[0]
+	if form["grant_type"] != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		t.Errorf("grant_type = %q", form["grant_type"])
+	}
+	parts := strings.Split(form["assertion"], ".")
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+	digest := sha256.Sum256([]byte(strings.Join(parts[:len(parts)-1], ".")))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("assertion not signed by the service account key: %v", err)
+	}
+}
+
+func TestGoogleClientAuthorizedUser(t *testing.T) {
+	server := newGoogleServer(t)
+	client := newGoogleTestClient(t, server, "", false)
+
+	// The gcloud well-known file is used without GOOGLE_APPLICATION_CREDENTIALS
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeGoogleCredentials(t, filepath.Join(configDir, "gcloud", "application_default_credentials.json"), googleCredentialsFile{
+		Type:         "authorized_user",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RefreshToken: "refresh",
+	})
+
+	if _, err := client.FetchAllTags("project/nginx"); err != nil {
+		t.Fatalf("FetchAllTags() error = %v", err)
+	}
+	want := map[string]string{"grant_type": "refresh_token", "client_id": "client", "client_secret": "secret", "refresh_token": "refresh"}
+	if len(server.forms) != 1 || !reflect.DeepEqual(server.forms[0], want) {
+		t.Errorf("token requests = %v, want %v", server.forms, want)
+	}
+}
+
+func TestGoogleClientMetadataServer(t *testing.T) {
+	server := newGoogleServer(t)
+	client := newGoogleTestClient(t, server, "", true)
+
+	if _, err := client.FetchAllTags("project/nginx"); err != nil {
+		t.Fatalf("FetchAllTags() error = %v", err)
+	}
+	if server.metadata != 1 || len(server.forms) != 0 {
+		t.Errorf("metadata requests = %d, token requests = %v, want the metadata server only", server.metadata, server.forms)
+	}
+}
+
+func TestGoogleClientAnonymous(t *testing.T) {
+	tokenRequests := 0
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/token" {
+			tokenRequests++
+			if _, _, ok := r.BasicAuth(); ok {
+				t.Error("anonymous token requested with credentials")
+			}
+			_, _ = w.Write([]byte(`{"token": "anonymous"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer anonymous" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/v2/token",scope="repository:project/nginx:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "project/nginx", "tags": ["1.27"]}`))
+	}))
+	defer server.Close()
+
+	// No credentials file and no metadata server outside of GCP
+	client := newGoogleTestClient(t, &googleServer{Server: server}, "", false)
+
+	tags, err := client.FetchAllTagsWithContext(context.Background(), "project/nginx")
+	if err != nil {
+		t.Fatalf("FetchAllTags() error = %v, want anonymous access", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.27"}) || tokenRequests != 1 {
+		t.Errorf("FetchAllTags() = %v after %d token requests", tags, tokenRequests)
+	}
+
+	// Invalid credentials are not replaced by anonymous access
+	path := writeGoogleCredentials(t, filepath.Join(t.TempDir(), "key.json"), googleCredentialsFile{Type: "external_account"})
+	client = newGoogleTestClient(t, &googleServer{Server: server}, path, false)
+	if _, err := client.FetchAllTags("project/nginx"); err == nil || !strings.Contains(err.Error(), "unsupported credentials type") {
+		t.Errorf("FetchAllTags() error = %v, want the unsupported credentials", err)
+	}
+}
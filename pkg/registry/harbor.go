@@ -0,0 +1,144 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// harborArtifact represents an artifact returned by the Harbor v2.0 API
+type harborArtifact struct {
+	Digest string `json:"digest"`
+	Tags   []struct {
+		Name     string    `json:"name"`
+		PushTime time.Time `json:"push_time"`
+	} `json:"tags"`
+}
+
+// HarborClient lists tags using the Harbor v2.0 artifacts API
+type HarborClient struct {
+	baseClient
+	pageSize int
+}
+
+// NewHarborClient creates a Harbor client for the given base URL
+func NewHarborClient(baseURL string, credentials *Credentials) *HarborClient {
+	return &HarborClient{
+		baseClient: newBaseClient(baseURL, credentials),
+		pageSize:   DefaultPageSize,
+	}
+}
+
+// artifactsURL returns the artifacts API URL of a project/repository path
+func (c *HarborClient) artifactsURL(repo string) (string, error) {
+	project, name, found := strings.Cut(repo, "/")
+	if !found {
+		return "", fmt.Errorf("harbor repository must be <project>/<repository>: %s", repo)
+	}
+
+	// Slashes in repository names must be double encoded
+	return fmt.Sprintf("%s/api/v2.0/projects/%s/repositories/%s/artifacts",
+		c.baseURL, url.PathEscape(project), url.PathEscape(url.PathEscape(name))), nil
+}
+
+// FetchAllTags fetches all tags of a repository
+func (c *HarborClient) FetchAllTags(repo string) ([]string, error) {
+	return c.FetchAllTagsWithContext(context.Background(), repo)
+}
+
+// FetchAllTagsWithContext fetches all tags of a repository with context
+func (c *HarborClient) FetchAllTagsWithContext(ctx context.Context, repo string) ([]string, error) {
+	return c.FetchTagsWithPrefixWithContext(ctx, repo, "")
+}
+
+// FetchTagsWithPrefixWithContext fetches the tags of a repository starting with prefix,
+// Harbor only listing the artifacts with a tag containing it
+func (c *HarborClient) FetchTagsWithPrefixWithContext(ctx context.Context, repo, prefix string) ([]string, error) {
+	infos, err := c.fetchTagInfo(ctx, repo, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name, prefix) {
+			tags = append(tags, info.Name)
+		}
+	}
+	return tags, nil
+}
+
+// FetchTagInfo fetches all tags of a repository with the digest of their artifact and
+// the time they were pushed
+func (c *HarborClient) FetchTagInfo(repo string) ([]TagInfo, error) {
+	return c.fetchTagInfo(context.Background(), repo, "")
+}
+
+// fetchTagInfo fetches the pages of artifacts of a repository and lists their tags, only
+// the artifacts with a tag containing filter when set
+func (c *HarborClient) fetchTagInfo(ctx context.Context, repo, filter string) ([]TagInfo, error) {
+	artifactsURL, err := c.artifactsURL(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("Fetching Harbor tags for %s", repo)
+
+	var tags []TagInfo
+	for page := 1; ; page++ {
+		pageURL := fmt.Sprintf("%s?with_tag=true&page=%d&page_size=%d", artifactsURL, page, c.pageSize)
+		if filter != "" {
+			// Harbor matches ~ queries anywhere in the tag names
+			pageURL += "&q=" + url.QueryEscape("tags=~"+filter)
+		}
+
+		var artifacts []harborArtifact
+		if _, err := c.getPage(ctx, pageURL, &artifacts); err != nil {
+			return nil, fmt.Errorf("error fetching tags: %w", err)
+		}
+
+		for _, artifact := range artifacts {
+			for _, tag := range artifact.Tags {
+				tags = append(tags, TagInfo{Name: tag.Name, Digest: artifact.Digest, LastUpdated: tag.PushTime})
+			}
+		}
+
+		if len(artifacts) < c.pageSize {
+			break
+		}
+	}
+
+	logger.Info("Found %d tags for %s", len(tags), repo)
+	return tags, nil
+}
+
+// FetchTagDigest fetches the digest of the artifact a tag points to
+func (c *HarborClient) FetchTagDigest(repo, tag string) (string, error) {
+	return c.FetchTagDigestWithContext(context.Background(), repo, tag)
+}
+
+// FetchTagDigestWithContext fetches the digest of the artifact a tag points to with context
+func (c *HarborClient) FetchTagDigestWithContext(ctx context.Context, repo, tag string) (string, error) {
+	artifactsURL, err := c.artifactsURL(repo)
+	if err != nil {
+		return "", err
+	}
+
+	var artifact harborArtifact
+	if _, err := c.do(ctx, http.MethodGet, artifactsURL+"/"+url.PathEscape(tag), "application/json", &artifact); err != nil {
+		return "", fmt.Errorf("error fetching tag details: %w", err)
+	}
+
+	return artifact.Digest, nil
+}
+
+// FetchImageDetails fetches the size and platforms of the image of a tag through the
+// registry API Harbor serves next to its own API
+func (c *HarborClient) FetchImageDetails(repo, tag string) (*ImageDetails, error) {
+	return c.fetchV2Details(context.Background(), c.baseURL+"/v2/"+repo, tag)
+}
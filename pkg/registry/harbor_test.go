@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHarborClient(t *testing.T) {
+	pushed := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	// Three artifacts of the repository team/app, the last one tagged twice
+	artifacts := []string{
+		`{"digest": "sha256:a", "tags": [{"name": "1.0.0", "push_time": "2026-03-01T12:00:00Z"}]}`,
+		`{"digest": "sha256:b", "tags": [{"name": "1.1.0", "push_time": "2026-03-01T12:00:00Z"}]}`,
+		`{"digest": "sha256:c", "tags": [{"name": "2.0.0", "push_time": "2026-03-01T12:00:00Z"}, {"name": "latest", "push_time": "2026-03-01T12:00:00Z"}]}`,
+	}
+
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "robot" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Slashes of the repository name are double encoded
+		switch r.URL.EscapedPath() {
+		case "/api/v2.0/projects/library/repositories/team%252Fapp/artifacts":
+			queries = append(queries, r.URL.RawQuery)
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			size, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+			start := min((page-1)*size, len(artifacts))
+			end := min(start+size, len(artifacts))
+			body := "["
+			for i, artifact := range artifacts[start:end] {
+				if i > 0 {
+					body += ","
+				}
+				body += artifact
+			}
+			_, _ = fmt.Fprint(w, body+"]")
+		case "/api/v2.0/projects/library/repositories/team%252Fapp/artifacts/2.0.0":
+			_, _ = fmt.Fprint(w, artifacts[2])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewHarborClient(server.URL, &Credentials{Username: "robot", Password: "secret"})
+	client.pageSize = 2
+
+	tags, err := client.FetchAllTags("library/team/app")
+	if err != nil {
+		t.Fatalf("FetchAllTags() error = %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.0.0", "1.1.0", "2.0.0", "latest"}) {
+		t.Errorf("FetchAllTags() = %v", tags)
+	}
+	if want := []string{"with_tag=true&page=1&page_size=2", "with_tag=true&page=2&page_size=2"}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("queries = %v, want %v", queries, want)
+	}
+
+	infos, err := client.FetchTagInfo("library/team/app")
+	if err != nil {
+		t.Fatalf("FetchTagInfo() error = %v", err)
+	}
+	if len(infos) != 4 || infos[3] != (TagInfo{Name: "latest", Digest: "sha256:c", LastUpdated: pushed}) {
+		t.Errorf("FetchTagInfo() = %+v", infos)
+	}
+
+	// Prefixed lookups filter the artifacts in Harbor and the tags of each artifact
+	queries = nil
+	tags, err = client.FetchTagsWithPrefixWithContext(t.Context(), "library/team/app", "1.")
+	if err != nil {
+		t.Fatalf("FetchTagsWithPrefixWithContext() error = %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.0.0", "1.1.0"}) {
+		t.Errorf("FetchTagsWithPrefixWithContext() = %v", tags)
+	}
+	if len(queries) == 0 || queries[0] != "with_tag=true&page=1&page_size=2&q=tags%3D~1." {
+		t.Errorf("queries = %v, want the tag filter", queries)
+	}
+
+	digest, err := client.FetchTagDigest("library/team/app", "2.0.0")
+	if err != nil || digest != "sha256:c" {
+		t.Errorf("FetchTagDigest() = %q, %v, want sha256:c", digest, err)
+	}
+
+	if _, err := client.FetchAllTags("app"); err == nil {
+		t.Error("FetchAllTags() of a repository without project succeeded")
+	}
+	if _, err := NewHarborClient(server.URL, nil).FetchAllTags("library/team/app"); err == nil {
+		t.Error("FetchAllTags() without credentials succeeded")
+	}
+}
@@ -0,0 +1,306 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/trace"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/transport"
+)
+
+const (
+	// DefaultTimeout is the default timeout for HTTP requests
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultPageSize is the default page size for registry API requests
+	DefaultPageSize = 100
+
+	// ManifestAcceptHeader lists the manifest media types accepted when resolving digests
+	ManifestAcceptHeader = "application/vnd.oci.image.index.v1+json, " +
+		"application/vnd.docker.distribution.manifest.list.v2+json, " +
+		"application/vnd.oci.image.manifest.v1+json, " +
+		"application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// baseClient holds the HTTP plumbing shared by registry adapters
+type baseClient struct {
+	baseURL string
+	// credentials are refreshed by the adapters exchanging them for short-lived ones
+	// while other goroutines send requests, credentialsMu guards them
+	credentials   *Credentials
+	credentialsMu sync.Mutex
+	httpClient    *http.Client
+	// tokens answers bearer token challenges when set, credentials being used to request
+	// the tokens instead of being sent to the registry
+	tokens *tokenSource
+	// etags keeps the pages of tag lists between runs, nil to download them every time
+	etags *ETagCache
+}
+
+// newBaseClient creates the shared HTTP client for a registry base URL
+func newBaseClient(baseURL string, credentials *Credentials) baseClient {
+	return baseClient{
+		baseURL:     strings.TrimSuffix(baseURL, "/"),
+		credentials: credentials,
+		httpClient: &http.Client{
+			Timeout:   DefaultTimeout,
+			Transport: trace.Transport("registry", transport.Shared()),
+		},
+	}
+}
+
+// currentCredentials returns the credentials sent to the registry, nil if anonymous
+func (b *baseClient) currentCredentials() *Credentials {
+	b.credentialsMu.Lock()
+	defer b.credentialsMu.Unlock()
+	return b.credentials
+}
+
+// hasCredentials reports whether credentials are set
+func (b *baseClient) hasCredentials() bool {
+	return b.currentCredentials() != nil
+}
+
+// setCredentials replaces the credentials sent to the registry
+func (b *baseClient) setCredentials(credentials *Credentials) {
+	b.credentialsMu.Lock()
+	defer b.credentialsMu.Unlock()
+	b.credentials = credentials
+}
+
+// SetETagCache sets the cache of the pages of tag lists, sent again only when they changed
+func (b *baseClient) SetETagCache(cache *ETagCache) {
+	b.etags = cache
+}
+
+// do sends an authenticated request and decodes the JSON response into result if provided.
+// A bearer token challenge is answered once when the client has a token source.
+func (b *baseClient) do(ctx context.Context, method, url, accept string, result interface{}) (http.Header, error) {
+	resp, err := b.exchange(ctx, method, url, accept, "")
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp)
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("not found: %s", url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	if result != nil {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+		if err := json.Unmarshal(body, result); err != nil {
+			return nil, fmt.Errorf("JSON parse error: %w", err)
+		}
+	}
+
+	return resp.Header, nil
+}
+
+// getPage fetches a page of a tag list and decodes it into result. The ETag of the cached
+// page is sent along, and the cached page is decoded instead when the registry answers
+// that it did not change.
+func (b *baseClient) getPage(ctx context.Context, url string, result interface{}) (http.Header, error) {
+	if b.etags == nil {
+		return b.do(ctx, http.MethodGet, url, "application/json", result)
+	}
+
+	cached, found := b.etags.Page(url)
+	resp, err := b.exchange(ctx, http.MethodGet, url, "application/json", cached.ETag)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp)
+
+	page := cached
+	switch {
+	case resp.StatusCode == http.StatusNotModified && found:
+		logger.Debug("Tag page unchanged since the last run: %s", url)
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("not found: %s", url)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	default:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading response: %w", err)
+		}
+		page = CachedPage{ETag: resp.Header.Get("ETag"), Link: resp.Header.Get("Link"), Body: body}
+	}
+
+	if err := json.Unmarshal(page.Body, result); err != nil {
+		return nil, fmt.Errorf("JSON parse error: %w", err)
+	}
+	if page.ETag != "" {
+		b.etags.Store(url, page)
+	}
+
+	header := http.Header{}
+	if page.Link != "" {
+		header.Set("Link", page.Link)
+	}
+	return header, nil
+}
+
+// exchange sends an authenticated request, answering a bearer token challenge once when
+// the client has a token source. The ETag of a cached response is sent when set.
+func (b *baseClient) exchange(ctx context.Context, method, url, accept, etag string) (*http.Response, error) {
+	resp, err := b.send(ctx, method, url, accept, etag)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && b.tokens != nil {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		closeBody(resp)
+		if err := b.tokens.answer(ctx, b.httpClient, url, challenge, b.currentCredentials()); err != nil {
+			return nil, err
+		}
+		return b.send(ctx, method, url, accept, etag)
+	}
+	return resp, nil
+}
+
+// send sends a request with the token of its repository, or else the credentials
+func (b *baseClient) send(ctx context.Context, method, url, accept, etag string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if token := b.tokens.get(url); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if credentials := b.currentCredentials(); credentials != nil {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+
+	logger.Debug("Sending %s request to %s", method, url)
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	return resp, nil
+}
+
+// closeBody closes the body of a response
+func closeBody(resp *http.Response) {
+	if err := resp.Body.Close(); err != nil {
+		logger.Warn("Failed to close response body: %v", err)
+	}
+}
+
+// tagListResponse represents the response of the Docker registry v2 tags list endpoint
+type tagListResponse struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// listV2Tags lists the tags of an image through a registry v2 API URL (ending in
+// /v2/<name>), following Link header pagination
+func (b *baseClient) listV2Tags(ctx context.Context, registryURL string, pageSize int) ([]string, error) {
+	return b.listV2TagsWithPrefix(ctx, registryURL, pageSize, "")
+}
+
+// listV2TagsWithPrefix lists the tags of an image starting with prefix through a registry
+// v2 API URL. Registries list tags in lexical order, so listing starts after the prefix
+// and stops at the first tag past the ones starting with it.
+func (b *baseClient) listV2TagsWithPrefix(ctx context.Context, registryURL string, pageSize int, prefix string) ([]string, error) {
+	var tags []string
+	pageURL := fmt.Sprintf("%s/tags/list?n=%d", registryURL, pageSize)
+	if prefix != "" {
+		pageURL += "&last=" + url.QueryEscape(prefix)
+	}
+	for pageURL != "" {
+		var parsed tagListResponse
+		header, err := b.getPage(ctx, pageURL, &parsed)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching tags: %w", err)
+		}
+
+		for _, tag := range parsed.Tags {
+			if strings.HasPrefix(tag, prefix) {
+				tags = append(tags, tag)
+			} else if tag > prefix {
+				return tags, nil
+			}
+		}
+		pageURL = nextLink(header, b.baseURL)
+	}
+
+	return tags, nil
+}
+
+// fetchV2Digest resolves the manifest digest of a tag through a registry v2 API URL
+func (b *baseClient) fetchV2Digest(ctx context.Context, registryURL, tag string) (string, error) {
+	header, err := b.do(ctx, http.MethodHead, registryURL+"/manifests/"+url.PathEscape(tag), ManifestAcceptHeader, nil)
+	if err != nil {
+		return "", fmt.Errorf("error fetching manifest: %w", err)
+	}
+
+	return header.Get("Docker-Content-Digest"), nil
+}
+
+// nextLink extracts the URL of the next page from a Link header, resolved against baseURL
+func nextLink(header http.Header, baseURL string) string {
+	for _, link := range header.Values("Link") {
+		for _, part := range strings.Split(link, ",") {
+			if !strings.Contains(part, `rel="next"`) {
+				continue
+			}
+			start := strings.Index(part, "<")
+			end := strings.Index(part, ">")
+			if start == -1 || end <= start {
+				continue
+			}
+			next := part[start+1 : end]
+			if strings.HasPrefix(next, "/") {
+				next = baseURL + next
+			}
+			return next
+		}
+	}
+	return ""
+}
+
+// Ping checks that a registry answers on the Docker Registry HTTP API, an
+// authentication challenge counting as an answer
+func Ping(ctx context.Context, host string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/v2/", nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	client := &http.Client{Timeout: DefaultTimeout, Transport: trace.Transport("registry", transport.Shared())}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
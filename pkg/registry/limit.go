@@ -0,0 +1,134 @@
+package registry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnyHost sets the limit of the registries without a limit of their own
+const AnyHost = "*"
+
+// HostLimit bounds the requests sent to a registry
+type HostLimit struct {
+	// MaxInFlight is the number of concurrent requests, unlimited when zero
+	MaxInFlight int
+	// Interval is the minimum delay between the start of two requests
+	Interval time.Duration
+}
+
+// ParseHostLimit parses a limit written as max[/interval], such as 2 or 4/250ms
+func ParseHostLimit(value string) (HostLimit, error) {
+	maxValue, intervalValue, hasInterval := strings.Cut(value, "/")
+
+	var limit HostLimit
+	maxInFlight, err := strconv.Atoi(maxValue)
+	if err != nil || maxInFlight < 0 {
+		return limit, fmt.Errorf("invalid number of concurrent requests %q", maxValue)
+	}
+	limit.MaxInFlight = maxInFlight
+
+	if hasInterval {
+		interval, err := time.ParseDuration(intervalValue)
+		if err != nil || interval < 0 {
+			return limit, fmt.Errorf("invalid interval between requests %q", intervalValue)
+		}
+		limit.Interval = interval
+	}
+	return limit, nil
+}
+
+// limitKey normalizes a host for looking up its limit, Docker Hub hosts sharing docker.io
+func limitKey(host string) string {
+	if host == "" || IsDockerHub(host) {
+		return "docker.io"
+	}
+	return strings.ToLower(host)
+}
+
+// hostLimiter enforces the limit of a registry host
+type hostLimiter struct {
+	slots    chan struct{}
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// newHostLimiter creates the limiter of a limit
+func newHostLimiter(limit HostLimit) *hostLimiter {
+	limiter := &hostLimiter{interval: limit.Interval}
+	if limit.MaxInFlight > 0 {
+		limiter.slots = make(chan struct{}, limit.MaxInFlight)
+	}
+	return limiter
+}
+
+// acquire waits for a free slot and for the interval since the previous request, and
+// returns the function releasing the slot
+func (l *hostLimiter) acquire() func() {
+	if l.slots != nil {
+		l.slots <- struct{}{}
+	}
+
+	if l.interval > 0 {
+		l.mu.Lock()
+		start := time.Now()
+		if l.next.After(start) {
+			start = l.next
+		}
+		l.next = start.Add(l.interval)
+		l.mu.Unlock()
+
+		time.Sleep(time.Until(start))
+	}
+
+	return func() {
+		if l.slots != nil {
+			<-l.slots
+		}
+	}
+}
+
+// SetHostLimits sets the limits of the requests sent to each registry host, the AnyHost
+// limit applying to each host without one
+func (r *Resolver) SetHostLimits(limits map[string]HostLimit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.limits = make(map[string]HostLimit, len(limits))
+	for host, limit := range limits {
+		if host != AnyHost {
+			host = limitKey(host)
+		}
+		r.limits[host] = limit
+	}
+	r.limiters = make(map[string]*hostLimiter)
+}
+
+// acquire waits until a request can be sent to a host and returns the function to call
+// once it is answered
+func (r *Resolver) acquire(host string) func() {
+	key := limitKey(host)
+
+	r.mu.Lock()
+	limiter, ok := r.limiters[key]
+	if !ok {
+		limit, found := r.limits[key]
+		if !found {
+			limit, found = r.limits[AnyHost]
+		}
+		if found {
+			limiter = newHostLimiter(limit)
+			r.limiters[key] = limiter
+		}
+	}
+	r.mu.Unlock()
+
+	if limiter == nil {
+		return func() {}
+	}
+	return limiter.acquire()
+}
@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseHostLimit(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected HostLimit
+		wantErr  bool
+	}{
+		{value: "2", expected: HostLimit{MaxInFlight: 2}},
+		{value: "4/250ms", expected: HostLimit{MaxInFlight: 4, Interval: 250 * time.Millisecond}},
+		{value: "0/1s", expected: HostLimit{Interval: time.Second}},
+		{value: "many", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "2/soon", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			limit, err := ParseHostLimit(tc.value)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseHostLimit() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !tc.wantErr && limit != tc.expected {
+				t.Errorf("ParseHostLimit() = %+v, want %+v", limit, tc.expected)
+			}
+		})
+	}
+}
+
+// concurrencyClient records the highest number of concurrent requests it served
+type concurrencyClient struct {
+	inFlight atomic.Int32
+	highest  atomic.Int32
+}
+
+func (c *concurrencyClient) FetchAllTags(repo string) ([]string, error) {
+	current := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	for {
+		highest := c.highest.Load()
+		if current <= highest || c.highest.CompareAndSwap(highest, current) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return nil, nil
+}
+
+func (c *concurrencyClient) FetchTagDigest(repo, tag string) (string, error) {
+	return "", nil
+}
+
+func TestResolverHostLimits(t *testing.T) {
+	hub := &concurrencyClient{}
+	harbor := &concurrencyClient{}
+	quay := &concurrencyClient{}
+
+	resolver := NewResolver(hub)
+	resolver.Register("harbor.example.com", harbor)
+	resolver.Register("quay.io", quay)
+	resolver.SetHostLimits(map[string]HostLimit{
+		"index.docker.io": {MaxInFlight: 2},
+		"*":               {MaxInFlight: 3},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		// Distinct repositories, as the tags of a repository are only fetched once
+		for _, repo := range []string{"nginx", "docker.io/library/redis", "harbor.example.com/project/app", "quay.io/org/app"} {
+			repo = fmt.Sprintf("%s%d", repo, i)
+			wg.Add(1)
+			go func(repo string) {
+				defer wg.Done()
+				resolver.FetchAllTags(repo)
+			}(repo)
+		}
+	}
+	wg.Wait()
+
+	if got := hub.highest.Load(); got != 2 {
+		t.Errorf("Docker Hub served %d concurrent requests, want 2", got)
+	}
+	// Each host other than Docker Hub gets its own limit of 3
+	if got := harbor.highest.Load(); got != 3 {
+		t.Errorf("Harbor served %d concurrent requests, want 3", got)
+	}
+	if got := quay.highest.Load(); got != 3 {
+		t.Errorf("Quay served %d concurrent requests, want 3", got)
+	}
+}
+
+func TestResolverHostInterval(t *testing.T) {
+	resolver := NewResolver(&stubClient{})
+	resolver.SetHostLimits(map[string]HostLimit{"docker.io": {Interval: 20 * time.Millisecond}})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := resolver.FetchAllTags(fmt.Sprintf("nginx%d", i)); err != nil {
+			t.Fatalf("FetchAllTags() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("3 requests took %s, want at least 40ms with a 20ms interval", elapsed)
+	}
+}
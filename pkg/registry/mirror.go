@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"sort"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+)
+
+// Mirror rewrites repositories under a source prefix to a target prefix,
+// such as Docker Hub images served by an internal pull-through cache
+type Mirror struct {
+	Source string
+	Target string
+}
+
+// Mirrors is a set of rewrite rules, the longest matching source prefix wins
+type Mirrors []Mirror
+
+// NewMirrors creates rewrite rules from a map of source prefix to target prefix
+func NewMirrors(rules map[string]string) Mirrors {
+	mirrors := make(Mirrors, 0, len(rules))
+	for source, target := range rules {
+		mirrors = append(mirrors, Mirror{
+			Source: strings.TrimSuffix(source, "/"),
+			Target: strings.TrimSuffix(target, "/"),
+		})
+	}
+
+	// Prefer the most specific rule
+	sort.Slice(mirrors, func(i, j int) bool {
+		return len(mirrors[i].Source) > len(mirrors[j].Source)
+	})
+
+	return mirrors
+}
+
+// Rewrite returns the repository rewritten by the first matching rule, or unchanged if none match.
+// Docker Hub repositories are matched in their fully qualified docker.io/library/ form.
+func (m Mirrors) Rewrite(repo string) string {
+	if len(m) == 0 {
+		return repo
+	}
+
+	qualified := reference.Normalize(repo)
+	for _, mirror := range m {
+		if rest, ok := strings.CutPrefix(qualified, mirror.Source+"/"); ok {
+			return mirror.Target + "/" + rest
+		}
+	}
+
+	return repo
+}
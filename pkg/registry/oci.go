@@ -0,0 +1,211 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+var (
+	// AnyHostPattern matches every registry host, to fall back to the OCI client
+	AnyHostPattern = regexp.MustCompile(`.*`)
+
+	// challengeParamPattern matches a parameter of a WWW-Authenticate challenge
+	challengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+	// v2RepositoryPattern extracts the repository of a registry v2 API URL
+	v2RepositoryPattern = regexp.MustCompile(`/v2/(.+?)/(?:tags|manifests|blobs)/`)
+)
+
+// OCIClient lists tags of any registry implementing the OCI distribution API, answering
+// its bearer token challenges so public images of unknown hosts are read anonymously
+type OCIClient struct {
+	baseClient
+	pageSize int
+}
+
+// NewOCIClient creates a client for a registry host, using the credentials, if any, to
+// request its tokens
+func NewOCIClient(host string, credentials *Credentials) *OCIClient {
+	client := &OCIClient{
+		baseClient: newBaseClient("https://"+host, credentials),
+		pageSize:   DefaultPageSize,
+	}
+	client.tokens = &tokenSource{tokens: make(map[string]string)}
+	return client
+}
+
+// FetchAllTags fetches all tags of a repository
+func (c *OCIClient) FetchAllTags(repo string) ([]string, error) {
+	return c.FetchAllTagsWithContext(context.Background(), repo)
+}
+
+// FetchAllTagsWithContext fetches all tags of a repository with context
+func (c *OCIClient) FetchAllTagsWithContext(ctx context.Context, repo string) ([]string, error) {
+	logger.Debug("Fetching tags of %s from %s", repo, c.baseURL)
+
+	tags, err := c.listV2Tags(ctx, c.baseURL+"/v2/"+repo, c.pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Found %d tags for %s", len(tags), repo)
+	return tags, nil
+}
+
+// FetchTagsWithPrefixWithContext fetches the tags of a repository starting with prefix,
+// skipping the pages of the other tags
+func (c *OCIClient) FetchTagsWithPrefixWithContext(ctx context.Context, repo, prefix string) ([]string, error) {
+	logger.Debug("Fetching tags of %s starting with %s from %s", repo, prefix, c.baseURL)
+
+	tags, err := c.listV2TagsWithPrefix(ctx, c.baseURL+"/v2/"+repo, c.pageSize, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("Found %d tags starting with %s for %s", len(tags), prefix, repo)
+	return tags, nil
+}
+
+// FetchTagDigest fetches the digest currently published for a tag
+func (c *OCIClient) FetchTagDigest(repo, tag string) (string, error) {
+	return c.FetchTagDigestWithContext(context.Background(), repo, tag)
+}
+
+// FetchTagDigestWithContext fetches the digest currently published for a tag with context
+func (c *OCIClient) FetchTagDigestWithContext(ctx context.Context, repo, tag string) (string, error) {
+	return c.fetchV2Digest(ctx, c.baseURL+"/v2/"+repo, tag)
+}
+
+// FetchImageDetails fetches the size and platforms of the image of a tag
+func (c *OCIClient) FetchImageDetails(repo, tag string) (*ImageDetails, error) {
+	return c.fetchV2Details(context.Background(), c.baseURL+"/v2/"+repo, tag)
+}
+
+// tokenSource holds the bearer tokens issued for the repositories of a registry
+type tokenSource struct {
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// get returns the token of the repository of a registry API URL, empty if none was issued.
+// Tokens issued without a repository are only used for URLs without one, such as /v2/.
+func (t *tokenSource) get(apiURL string) string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.tokens[urlRepository(apiURL)]
+}
+
+// answer requests the token asked for by a bearer challenge to a request of apiURL, such as
+// Bearer realm="https://auth.example.com/token",service="registry",scope="repository:app:pull".
+// Credentials are only sent to https realms.
+func (t *tokenSource) answer(ctx context.Context, httpClient *http.Client, apiURL, challenge string, credentials *Credentials) error {
+	scheme, rest, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return fmt.Errorf("registry requires authentication without a bearer token challenge")
+	}
+
+	params := make(map[string]string)
+	for _, match := range challengeParamPattern.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	if params["realm"] == "" {
+		return fmt.Errorf("bearer token challenge without realm: %s", challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || !realm.IsAbs() || realm.Host == "" {
+		return fmt.Errorf("invalid bearer token realm: %s", params["realm"])
+	}
+	if credentials != nil && realm.Scheme != "https" {
+		return fmt.Errorf("refusing to send credentials to the bearer token realm %s over %s", params["realm"], realm.Scheme)
+	}
+
+	// The parameters of the challenge are added to those of the realm
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope := params["scope"]; scope != "" {
+		query.Set("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+	tokenURL := realm.String()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if credentials != nil {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+
+	logger.Debug("Requesting registry token from %s", tokenURL)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error requesting registry token: %w", err)
+	}
+	defer closeBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code requesting registry token: %d", resp.StatusCode)
+	}
+
+	var parsed struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return fmt.Errorf("JSON parse error: %w", err)
+	}
+	token := parsed.Token
+	if token == "" {
+		token = parsed.AccessToken
+	}
+	if token == "" {
+		return fmt.Errorf("registry token response without token")
+	}
+
+	// A token without repository in its scope is kept for the repository it was issued for
+	repository := scopeRepository(params["scope"])
+	if repository == "" {
+		repository = urlRepository(apiURL)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens[repository] = token
+	return nil
+}
+
+// urlRepository returns the repository of a registry v2 API URL, empty for URLs without one
+func urlRepository(apiURL string) string {
+	if match := v2RepositoryPattern.FindStringSubmatch(apiURL); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// scopeRepository returns the repository of a token scope such as repository:app:pull,
+// empty for scopes without one
+func scopeRepository(scope string) string {
+	for _, part := range strings.Fields(scope) {
+		if rest, ok := strings.CutPrefix(part, "repository:"); ok {
+			if idx := strings.LastIndex(rest, ":"); idx > 0 {
+				return rest[:idx]
+			}
+		}
+	}
+	return ""
+}
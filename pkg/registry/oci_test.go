@@ -0,0 +1,191 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOCIClientTokenFlow(t *testing.T) {
+	tokenRequests := 0
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenRequests++
+			if r.URL.Query().Get("scope") != "repository:pause:pull" || r.URL.Query().Get("service") != "registry.test" {
+				t.Errorf("token requested for %s", r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`{"token": "anonymous"}`))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer anonymous" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry.test",scope="repository:pause:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v2/pause/tags/list":
+			_, _ = w.Write([]byte(`{"name": "pause", "tags": ["3.9", "3.10"]}`))
+		case "/v2/pause/manifests/3.10":
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := NewOCIClient(strings.TrimPrefix(server.URL, "https://"), nil)
+	client.httpClient = server.Client()
+
+	tags, err := client.FetchAllTags("pause")
+	if err != nil {
+		t.Fatalf("FetchAllTags() error = %v", err)
+	}
+	if !reflect.DeepEqual(tags, []string{"3.9", "3.10"}) {
+		t.Errorf("FetchAllTags() = %v", tags)
+	}
+
+	digest, err := client.FetchTagDigest("pause", "3.10")
+	if err != nil {
+		t.Fatalf("FetchTagDigest() error = %v", err)
+	}
+	if digest != "sha256:abc" {
+		t.Errorf("FetchTagDigest() = %q, want sha256:abc", digest)
+	}
+
+	// The token of the repository is reused
+	if tokenRequests != 1 {
+		t.Errorf("requested %d tokens, want 1", tokenRequests)
+	}
+}
+
+func TestOCIClientTagsWithPrefix(t *testing.T) {
+	tags := []string{"1.0.0", "2.0.0", "2.1.0", "2.1.0-alpine", "3.0.0", "4.0.0"}
+	pages := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		// Tags are listed in lexical order after the last one, two per page
+		last := r.URL.Query().Get("last")
+		var page []string
+		for _, tag := range tags {
+			if tag > last && len(page) < 2 {
+				page = append(page, tag)
+			}
+		}
+		if len(page) == 2 {
+			w.Header().Set("Link", `</v2/app/tags/list?n=2&last=`+page[1]+`>; rel="next"`)
+		}
+		_ = json.NewEncoder(w).Encode(tagListResponse{Tags: page})
+	}))
+	defer server.Close()
+
+	client := NewOCIClient(strings.TrimPrefix(server.URL, "https://"), nil)
+	client.httpClient = server.Client()
+	client.pageSize = 2
+
+	got, err := client.FetchTagsWithPrefixWithContext(context.Background(), "app", "2.")
+	if err != nil {
+		t.Fatalf("FetchTagsWithPrefixWithContext() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"2.0.0", "2.1.0", "2.1.0-alpine"}) {
+		t.Errorf("FetchTagsWithPrefixWithContext() = %v", got)
+	}
+	if pages != 2 {
+		t.Errorf("fetched %d pages, want listing to stop after the prefix", pages)
+	}
+}
+
+func TestOCIClientWithoutChallenge(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	client := NewOCIClient(strings.TrimPrefix(server.URL, "https://"), nil)
+	client.httpClient = server.Client()
+
+	if _, err := client.FetchAllTags("private/app"); err == nil {
+		t.Error("FetchAllTags() should fail without a bearer challenge")
+	}
+}
+
+func TestTokenSourceAnswer(t *testing.T) {
+	var queries []string
+	var authorized []bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		_, _, ok := r.BasicAuth()
+		authorized = append(authorized, ok)
+		_, _ = w.Write([]byte(`{"access_token": "issued"}`))
+	})
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+
+	credentials := &Credentials{Username: "robot", Password: "secret"}
+	tagsURL := secure.URL + "/v2/team/app/tags/list"
+
+	t.Run("realm query merged", func(t *testing.T) {
+		queries, authorized = nil, nil
+		tokens := &tokenSource{tokens: make(map[string]string)}
+		challenge := `Bearer realm="` + secure.URL + `/token?account=robot",service="registry.test",scope="repository:team/app:pull"`
+		if err := tokens.answer(t.Context(), secure.Client(), tagsURL, challenge, credentials); err != nil {
+			t.Fatalf("answer() error = %v", err)
+		}
+		if want := "account=robot&scope=repository%3Ateam%2Fapp%3Apull&service=registry.test"; len(queries) != 1 || queries[0] != want || !authorized[0] {
+			t.Errorf("token requests = %v with credentials %v, want %s with credentials", queries, authorized, want)
+		}
+		if tokens.get(tagsURL) != "issued" || tokens.get(secure.URL+"/v2/team/other/tags/list") != "" {
+			t.Errorf("token of team/app used for %v", tokens.tokens)
+		}
+	})
+
+	t.Run("credentials not sent over http", func(t *testing.T) {
+		queries = nil
+		tokens := &tokenSource{tokens: make(map[string]string)}
+		challenge := `Bearer realm="` + plain.URL + `/token",scope="repository:team/app:pull"`
+		if err := tokens.answer(t.Context(), plain.Client(), tagsURL, challenge, credentials); err == nil || len(queries) != 0 {
+			t.Errorf("answer() error = %v after %d requests, want the http realm refused", err, len(queries))
+		}
+
+		// Anonymous tokens may be requested over http
+		if err := tokens.answer(t.Context(), plain.Client(), tagsURL, challenge, nil); err != nil {
+			t.Errorf("anonymous answer() error = %v", err)
+		}
+	})
+
+	t.Run("invalid realm", func(t *testing.T) {
+		tokens := &tokenSource{tokens: make(map[string]string)}
+		for _, realm := range []string{"/token", "https://"} {
+			if err := tokens.answer(t.Context(), secure.Client(), tagsURL, `Bearer realm="`+realm+`"`, nil); err == nil {
+				t.Errorf("answer() with realm %q succeeded", realm)
+			}
+		}
+	})
+
+	t.Run("token without repository", func(t *testing.T) {
+		tokens := &tokenSource{tokens: make(map[string]string)}
+		challenge := `Bearer realm="` + secure.URL + `/token",service="registry.test"`
+		if err := tokens.answer(t.Context(), secure.Client(), tagsURL, challenge, credentials); err != nil {
+			t.Fatalf("answer() error = %v", err)
+		}
+		// The token is only used for the repository it was issued for
+		if tokens.get(tagsURL) != "issued" || tokens.get(secure.URL+"/v2/team/other/tags/list") != "" || tokens.get(secure.URL+"/v2/") != "" {
+			t.Errorf("tokens = %v, want the token of team/app only", tokens.tokens)
+		}
+
+		if err := tokens.answer(t.Context(), secure.Client(), secure.URL+"/v2/", challenge, credentials); err != nil {
+			t.Fatalf("answer() error = %v", err)
+		}
+		if tokens.get(secure.URL+"/v2/") != "issued" || tokens.get(secure.URL+"/v2/team/other/tags/list") != "" {
+			t.Errorf("tokens = %v, want the token of /v2/ not used for other repositories", tokens.tokens)
+		}
+	})
+}
@@ -0,0 +1,400 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+)
+
+// Registry types that can be configured for a host
+const (
+	TypeHarbor      = "harbor"
+	TypeArtifactory = "artifactory"
+)
+
+// ValidTypes contains the list of registry types that can be configured for a host
+var ValidTypes = []string{TypeHarbor, TypeArtifactory}
+
+// Client is implemented by registry adapters able to list the tags of a repository
+type Client interface {
+	// FetchAllTags returns all tag names of a repository
+	FetchAllTags(repo string) ([]string, error)
+	// FetchTagDigest returns the digest currently published for a tag
+	FetchTagDigest(repo, tag string) (string, error)
+}
+
+// ContextClient is implemented by registry adapters whose requests are aborted when
+// their context is cancelled
+type ContextClient interface {
+	FetchAllTagsWithContext(ctx context.Context, repo string) ([]string, error)
+	FetchTagDigestWithContext(ctx context.Context, repo, tag string) (string, error)
+}
+
+// FetchAllTags fetches the tags of a repository with client, through its context-aware
+// method when it implements ContextClient
+func FetchAllTags(ctx context.Context, client Client, repo string) ([]string, error) {
+	if contextClient, ok := client.(ContextClient); ok {
+		return contextClient.FetchAllTagsWithContext(ctx, repo)
+	}
+	return client.FetchAllTags(repo)
+}
+
+// FetchTagDigest fetches the digest of a tag with client, through its context-aware
+// method when it implements ContextClient
+func FetchTagDigest(ctx context.Context, client Client, repo, tag string) (string, error) {
+	if contextClient, ok := client.(ContextClient); ok {
+		return contextClient.FetchTagDigestWithContext(ctx, repo, tag)
+	}
+	return client.FetchTagDigest(repo, tag)
+}
+
+// EarlyExitClient is implemented by registry adapters listing tags page by page from the
+// most recently updated one, which can stop before the last page
+type EarlyExitClient interface {
+	FetchTagsUntilWithContext(ctx context.Context, repo string, stop func(page []TagInfo) bool) ([]string, error)
+}
+
+// PrefixClient is implemented by registry adapters filtering tags by name server-side,
+// which only fetch the pages of the tags starting with a prefix
+type PrefixClient interface {
+	FetchTagsWithPrefixWithContext(ctx context.Context, repo, prefix string) ([]string, error)
+}
+
+// FetchTagsWithPrefix fetches the tags of a repository starting with prefix, filtered by
+// the registry when client implements PrefixClient, or locally otherwise
+func FetchTagsWithPrefix(ctx context.Context, client Client, repo, prefix string) ([]string, error) {
+	if prefixClient, ok := client.(PrefixClient); ok {
+		return prefixClient.FetchTagsWithPrefixWithContext(ctx, repo, prefix)
+	}
+	tags, err := FetchAllTags(ctx, client, repo)
+	if err != nil {
+		return nil, err
+	}
+	return filterPrefix(tags, prefix), nil
+}
+
+// filterPrefix returns the tags starting with prefix
+func filterPrefix(tags []string, prefix string) []string {
+	var filtered []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, prefix) {
+			filtered = append(filtered, tag)
+		}
+	}
+	return filtered
+}
+
+// FetchTagsUntil fetches the tags of a repository until stop returns true for a page, the
+// tags of that page included, when client implements EarlyExitClient, or all of them otherwise
+func FetchTagsUntil(ctx context.Context, client Client, repo string, stop func(page []TagInfo) bool) ([]string, error) {
+	if earlyExitClient, ok := client.(EarlyExitClient); ok {
+		return earlyExitClient.FetchTagsUntilWithContext(ctx, repo, stop)
+	}
+	return FetchAllTags(ctx, client, repo)
+}
+
+// Resolver routes requests to the registry client responsible for an image's host.
+// Repositories without a host, or hosted on Docker Hub, use the default client.
+type Resolver struct {
+	defaultClient Client
+	clients       map[string]Client
+	factories     []hostFactory
+	mirrors       Mirrors
+	limits        map[string]HostLimit
+	limiters      map[string]*hostLimiter
+	lookups       map[lookupKey]*lookup
+	etags         *ETagCache
+	mu            sync.Mutex
+}
+
+// hostFactory creates clients for hosts matching a pattern, such as cloud registries
+// whose host names embed an account or region
+type hostFactory struct {
+	pattern *regexp.Regexp
+	create  func(host string) (Client, error)
+}
+
+// NewResolver creates a resolver falling back to the given Docker Hub client
+func NewResolver(defaultClient Client) *Resolver {
+	return &Resolver{
+		defaultClient: defaultClient,
+		clients:       make(map[string]Client),
+	}
+}
+
+// Register sets the client used for repositories hosted on host
+func (r *Resolver) Register(host string, client Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clients[strings.ToLower(host)] = client
+	setETagCache(client, r.etags)
+}
+
+// RegisterPattern sets a factory creating the client of any host matching pattern.
+// Explicitly registered hosts take precedence over patterns.
+func (r *Resolver) RegisterPattern(pattern *regexp.Regexp, create func(host string) (Client, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.factories = append(r.factories, hostFactory{pattern: pattern, create: create})
+}
+
+// SetMirrors sets the rules rewriting repositories before they are looked up
+func (r *Resolver) SetMirrors(mirrors Mirrors) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.mirrors = mirrors
+}
+
+// SetETagCache sets the cache of the pages of tag lists kept between runs, used by the
+// clients of every registry supporting it
+func (r *Resolver) SetETagCache(cache *ETagCache) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.etags = cache
+	setETagCache(r.defaultClient, cache)
+	for _, client := range r.clients {
+		setETagCache(client, cache)
+	}
+}
+
+// SaveETagCache writes the cache of the pages of tag lists for the next run, if one is set
+func (r *Resolver) SaveETagCache() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.etags.Save()
+}
+
+// setETagCache sets the ETag cache of a client implementing ETagClient
+func setETagCache(client Client, cache *ETagCache) {
+	if etagClient, ok := client.(ETagClient); ok && cache != nil {
+		etagClient.SetETagCache(cache)
+	}
+}
+
+// ClientFor returns the client responsible for a repository and the repository path on that registry
+func (r *Resolver) ClientFor(repo string) (Client, string, error) {
+	client, path, _, err := r.route(repo)
+	return client, path, err
+}
+
+// route returns the client responsible for a repository, the repository path on that
+// registry and the host queried, after the mirror rewrites
+func (r *Resolver) route(repo string) (Client, string, string, error) {
+	if rewritten := r.mirrors.Rewrite(repo); rewritten != repo {
+		logger.Debug("Looking up %s through mirror %s", repo, rewritten)
+		repo = rewritten
+	}
+
+	host, path := SplitHost(repo)
+	if host == "" || IsDockerHub(host) {
+		return r.defaultClient, path, host, nil
+	}
+
+	client, err := r.clientForHost(strings.ToLower(host))
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	logger.Debug("Using %s adapter for %s", host, path)
+	return client, path, host, nil
+}
+
+// clientForHost returns the registered client of a host, creating it from a matching pattern if needed
+func (r *Resolver) clientForHost(host string) (Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if client, ok := r.clients[host]; ok {
+		return client, nil
+	}
+
+	for _, factory := range r.factories {
+		if !factory.pattern.MatchString(host) {
+			continue
+		}
+
+		client, err := factory.create(host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create registry client for %s: %w", host, err)
+		}
+		r.clients[host] = client
+		setETagCache(client, r.etags)
+		return client, nil
+	}
+
+	return nil, fmt.Errorf("no registry adapter configured for host %s", host)
+}
+
+// FetchAllTags fetches all tags of a repository from its registry. The tags are fetched
+// once per resolver, however the repository is written.
+func (r *Resolver) FetchAllTags(repo string) ([]string, error) {
+	return r.FetchAllTagsWithContext(context.Background(), repo)
+}
+
+// FetchAllTagsWithContext fetches all tags of a repository from its registry with context
+func (r *Resolver) FetchAllTagsWithContext(ctx context.Context, repo string) ([]string, error) {
+	l, err := r.lookupOnce(ctx, lookupKey{repo: repo}, func(l *lookup) {
+		client, path, host, err := r.route(repo)
+		if err != nil {
+			l.err = err
+			return
+		}
+		defer r.acquire(host)()
+		l.tags, l.err = FetchAllTags(ctx, client, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l.tags, l.err
+}
+
+// FetchTagsUntilWithContext fetches the tags of a repository from its registry until stop
+// returns true for a page. The tags already fetched for the repository are reused, but
+// the ones of early exits are not shared, as they depend on where they stopped.
+func (r *Resolver) FetchTagsUntilWithContext(ctx context.Context, repo string, stop func(page []TagInfo) bool) ([]string, error) {
+	if tags, ok := r.cachedTags(repo); ok {
+		return tags, nil
+	}
+
+	client, path, host, err := r.route(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer r.acquire(host)()
+	return FetchTagsUntil(ctx, client, path, stop)
+}
+
+// FetchTagsWithPrefixWithContext fetches the tags of a repository starting with prefix
+// from its registry. The tags already fetched for the repository are filtered instead.
+func (r *Resolver) FetchTagsWithPrefixWithContext(ctx context.Context, repo, prefix string) ([]string, error) {
+	if tags, ok := r.cachedTags(repo); ok {
+		return filterPrefix(tags, prefix), nil
+	}
+
+	l, err := r.lookupOnce(ctx, lookupKey{repo: repo, prefix: prefix}, func(l *lookup) {
+		client, path, host, err := r.route(repo)
+		if err != nil {
+			l.err = err
+			return
+		}
+		defer r.acquire(host)()
+		l.tags, l.err = FetchTagsWithPrefix(ctx, client, path, prefix)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l.tags, l.err
+}
+
+// FetchTagDigest fetches the digest of a tag from the repository's registry. The digest
+// is fetched once per resolver, however the repository is written.
+func (r *Resolver) FetchTagDigest(repo, tag string) (string, error) {
+	return r.FetchTagDigestWithContext(context.Background(), repo, tag)
+}
+
+// FetchTagDigestWithContext fetches the digest of a tag from the repository's registry with context
+func (r *Resolver) FetchTagDigestWithContext(ctx context.Context, repo, tag string) (string, error) {
+	l, err := r.lookupOnce(ctx, lookupKey{repo: repo, tag: tag}, func(l *lookup) {
+		client, path, host, err := r.route(repo)
+		if err != nil {
+			l.err = err
+			return
+		}
+		defer r.acquire(host)()
+		l.digest, l.err = FetchTagDigest(ctx, client, path, tag)
+	})
+	if err != nil {
+		return "", err
+	}
+	return l.digest, l.err
+}
+
+// ManifestClient is implemented by registry adapters checking that the manifest of a tag
+// can be pulled without resolving its digest through another API
+type ManifestClient interface {
+	HeadManifest(repo, tag string) error
+}
+
+// CheckPullable checks that a tag can be pulled by requesting its manifest from the
+// registry, bypassing the answers cached during the run
+func (r *Resolver) CheckPullable(repo, tag string) error {
+	client, path, host, err := r.route(repo)
+	if err != nil {
+		return err
+	}
+	defer r.acquire(host)()
+
+	if manifestClient, ok := client.(ManifestClient); ok {
+		return manifestClient.HeadManifest(path, tag)
+	}
+	_, err = client.FetchTagDigest(path, tag)
+	return err
+}
+
+// SplitHost splits a repository into its registry host and path. The first path
+// component is a host if it contains a dot or a port, or is localhost.
+func SplitHost(repo string) (string, string) {
+	first, rest, found := strings.Cut(repo, "/")
+	if !found {
+		return "", repo
+	}
+
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return first, rest
+	}
+
+	return "", repo
+}
+
+// IsDockerHub reports whether a host refers to Docker Hub
+func IsDockerHub(host string) bool {
+	return reference.IsDockerHub(host)
+}
+
+// TagInfo describes a tag as listed by registries that give more than its name
+type TagInfo struct {
+	Name        string    `json:"name"`
+	Digest      string    `json:"digest,omitempty"`
+	LastUpdated time.Time `json:"last_updated,omitempty"`
+}
+
+// TagInfoClient is implemented by registry adapters listing tags with their digest and
+// last update in a single request per page
+type TagInfoClient interface {
+	FetchTagInfo(repo string) ([]TagInfo, error)
+}
+
+// FetchTagInfo fetches the tags of a repository with their digest and last update when
+// its adapter lists them, only their names otherwise
+func (r *Resolver) FetchTagInfo(repo string) ([]TagInfo, error) {
+	client, path, host, err := r.route(repo)
+	if err != nil {
+		return nil, err
+	}
+	defer r.acquire(host)()
+
+	if infoClient, ok := client.(TagInfoClient); ok {
+		return infoClient.FetchTagInfo(path)
+	}
+
+	tags, err := client.FetchAllTags(path)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]TagInfo, 0, len(tags))
+	for _, tag := range tags {
+		infos = append(infos, TagInfo{Name: tag})
+	}
+	return infos, nil
+}
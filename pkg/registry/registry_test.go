@@ -0,0 +1,299 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSplitHost(t *testing.T) {
+	testCases := []struct {
+		repo         string
+		expectedHost string
+		expectedPath string
+	}{
+		{repo: "nginx", expectedHost: "", expectedPath: "nginx"},
+		{repo: "bitnami/redis", expectedHost: "", expectedPath: "bitnami/redis"},
+		{repo: "docker.io/library/nginx", expectedHost: "docker.io", expectedPath: "library/nginx"},
+		{repo: "harbor.example.com/project/app", expectedHost: "harbor.example.com", expectedPath: "project/app"},
+		{repo: "registry:5000/app", expectedHost: "registry:5000", expectedPath: "app"},
+		{repo: "localhost/app", expectedHost: "localhost", expectedPath: "app"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.repo, func(t *testing.T) {
+			host, path := SplitHost(tc.repo)
+			if host != tc.expectedHost || path != tc.expectedPath {
+				t.Errorf("SplitHost(%q) = (%q, %q), want (%q, %q)",
+					tc.repo, host, path, tc.expectedHost, tc.expectedPath)
+			}
+		})
+	}
+}
+
+// stubClient records the repository it was asked about
+type stubClient struct {
+	repo string
+}
+
+func (s *stubClient) FetchAllTags(repo string) ([]string, error) {
+	s.repo = repo
+	return nil, nil
+}
+
+func (s *stubClient) FetchTagDigest(repo, tag string) (string, error) {
+	s.repo = repo
+	return "", nil
+}
+
+func TestResolverRouting(t *testing.T) {
+	hub := &stubClient{}
+	harbor := &stubClient{}
+
+	resolver := NewResolver(hub)
+	resolver.Register("harbor.example.com", harbor)
+
+	if _, err := resolver.FetchAllTags("docker.io/library/nginx"); err != nil {
+		t.Fatalf("FetchAllTags() error = %v", err)
+	}
+	if hub.repo != "library/nginx" {
+		t.Errorf("Docker Hub client got %q, want %q", hub.repo, "library/nginx")
+	}
+
+	if _, err := resolver.FetchAllTags("harbor.example.com/project/app"); err != nil {
+		t.Fatalf("FetchAllTags() error = %v", err)
+	}
+	if harbor.repo != "project/app" {
+		t.Errorf("Harbor client got %q, want %q", harbor.repo, "project/app")
+	}
+
+	if _, err := resolver.FetchAllTags("unknown.example.com/app"); err == nil {
+		t.Error("FetchAllTags() for an unknown host should fail")
+	}
+}
+
+// countingClient counts the lookups of each repository
+type countingClient struct {
+	tagLookups    map[string]int
+	digestLookups map[string]int
+}
+
+func (c *countingClient) FetchAllTags(repo string) ([]string, error) {
+	c.tagLookups[repo]++
+	return []string{"1.0"}, nil
+}
+
+func (c *countingClient) FetchTagDigest(repo, tag string) (string, error) {
+	c.digestLookups[repo+":"+tag]++
+	return "sha256:abc", nil
+}
+
+func TestResolverFetchesOnce(t *testing.T) {
+	hub := &countingClient{tagLookups: make(map[string]int), digestLookups: make(map[string]int)}
+	resolver := NewResolver(hub)
+
+	for _, repo := range []string{"nginx", "library/nginx", "docker.io/library/nginx", "index.docker.io/nginx"} {
+		if tags, err := resolver.FetchAllTags(repo); err != nil || len(tags) != 1 {
+			t.Fatalf("FetchAllTags(%q) = %v, %v", repo, tags, err)
+		}
+		if _, err := resolver.FetchTagDigest(repo, "1.0"); err != nil {
+			t.Fatalf("FetchTagDigest(%q) error = %v", repo, err)
+		}
+	}
+	if _, err := resolver.FetchTagDigest("nginx", "1.1"); err != nil {
+		t.Fatalf("FetchTagDigest() error = %v", err)
+	}
+
+	if len(hub.tagLookups) != 1 || hub.tagLookups["nginx"] != 1 {
+		t.Errorf("tags fetched %v, want nginx once", hub.tagLookups)
+	}
+	if len(hub.digestLookups) != 2 || hub.digestLookups["nginx:1.0"] != 1 {
+		t.Errorf("digests fetched %v, want each tag once", hub.digestLookups)
+	}
+}
+
+func TestResolverFetchTagsUntil(t *testing.T) {
+	hub := &countingClient{tagLookups: make(map[string]int), digestLookups: make(map[string]int)}
+	resolver := NewResolver(hub)
+	stop := func([]TagInfo) bool { return true }
+
+	// Early exits are not shared, clients without pages list every tag
+	for range 2 {
+		if tags, err := resolver.FetchTagsUntilWithContext(context.Background(), "nginx", stop); err != nil || len(tags) != 1 {
+			t.Fatalf("FetchTagsUntilWithContext() = %v, %v", tags, err)
+		}
+	}
+	if hub.tagLookups["nginx"] != 2 {
+		t.Errorf("tags fetched %d times, want 2", hub.tagLookups["nginx"])
+	}
+
+	// Tags listed in full are reused
+	if _, err := resolver.FetchAllTags("nginx"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolver.FetchTagsUntilWithContext(context.Background(), "library/nginx", stop); err != nil {
+		t.Fatal(err)
+	}
+	if hub.tagLookups["nginx"] != 3 {
+		t.Errorf("tags fetched %d times, want the full list reused", hub.tagLookups["nginx"])
+	}
+}
+
+func TestResolverFetchTagsWithPrefix(t *testing.T) {
+	hub := &countingClient{tagLookups: make(map[string]int), digestLookups: make(map[string]int)}
+	resolver := NewResolver(hub)
+
+	// Clients without filters list every tag, filtered once per prefix
+	for range 2 {
+		if tags, err := resolver.FetchTagsWithPrefixWithContext(context.Background(), "nginx", "1."); err != nil || len(tags) != 1 {
+			t.Fatalf("FetchTagsWithPrefixWithContext() = %v, %v", tags, err)
+		}
+	}
+	if tags, err := resolver.FetchTagsWithPrefixWithContext(context.Background(), "nginx", "2."); err != nil || len(tags) != 0 {
+		t.Fatalf("FetchTagsWithPrefixWithContext() = %v, %v, want no tags", tags, err)
+	}
+	if hub.tagLookups["nginx"] != 2 {
+		t.Errorf("tags fetched %d times, want once per prefix", hub.tagLookups["nginx"])
+	}
+
+	// Tags listed in full are reused
+	if _, err := resolver.FetchAllTags("nginx"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolver.FetchTagsWithPrefixWithContext(context.Background(), "nginx", "3."); err != nil {
+		t.Fatal(err)
+	}
+	if hub.tagLookups["nginx"] != 3 {
+		t.Errorf("tags fetched %d times, want the full list reused", hub.tagLookups["nginx"])
+	}
+}
+
+// contextClient is a registry client whose lookups fail once their context is cancelled
+type contextClient struct {
+	countingClient
+}
+
+func (c *contextClient) FetchAllTagsWithContext(ctx context.Context, repo string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.FetchAllTags(repo)
+}
+
+func (c *contextClient) FetchTagDigestWithContext(ctx context.Context, repo, tag string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return c.FetchTagDigest(repo, tag)
+}
+
+func TestResolverCancelledLookup(t *testing.T) {
+	hub := &contextClient{countingClient{tagLookups: make(map[string]int), digestLookups: make(map[string]int)}}
+	resolver := NewResolver(hub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := resolver.FetchAllTagsWithContext(ctx, "nginx"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("FetchAllTagsWithContext() error = %v, want cancellation", err)
+	}
+
+	// The cancelled answer is not reused by later lookups
+	if tags, err := resolver.FetchAllTags("nginx"); err != nil || len(tags) != 1 {
+		t.Fatalf("FetchAllTags() = %v, %v after a cancelled lookup", tags, err)
+	}
+	if hub.tagLookups["nginx"] != 1 {
+		t.Errorf("tags fetched %d times, want once", hub.tagLookups["nginx"])
+	}
+}
+
+// manifestClient is a registry client checking manifests, which only has tag 1.0
+type manifestClient struct {
+	countingClient
+}
+
+func (c *manifestClient) HeadManifest(repo, tag string) error {
+	if tag != "1.0" {
+		return fmt.Errorf("manifest of %s:%s not found", repo, tag)
+	}
+	return nil
+}
+
+func TestResolverCheckPullable(t *testing.T) {
+	hub := &manifestClient{countingClient{tagLookups: make(map[string]int), digestLookups: make(map[string]int)}}
+	resolver := NewResolver(hub)
+
+	if err := resolver.CheckPullable("nginx", "1.0"); err != nil {
+		t.Errorf("CheckPullable() error = %v", err)
+	}
+	if err := resolver.CheckPullable("nginx", "1.1"); err == nil {
+		t.Error("CheckPullable() should fail for a deleted tag")
+	}
+	if len(hub.digestLookups) != 0 {
+		t.Errorf("digests fetched %v, want the manifests checked instead", hub.digestLookups)
+	}
+}
+
+func TestMirrorsRewrite(t *testing.T) {
+	mirrors := NewMirrors(map[string]string{
+		"docker.io":         "mirror.example.com/dockerhub",
+		"docker.io/bitnami": "mirror.example.com/bitnami",
+	})
+
+	testCases := []struct {
+		repo     string
+		expected string
+	}{
+		{repo: "nginx", expected: "mirror.example.com/dockerhub/library/nginx"},
+		{repo: "docker.io/library/nginx", expected: "mirror.example.com/dockerhub/library/nginx"},
+		{repo: "bitnami/redis", expected: "mirror.example.com/bitnami/redis"},
+		{repo: "ghcr.io/org/app", expected: "ghcr.io/org/app"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.repo, func(t *testing.T) {
+			if rewritten := mirrors.Rewrite(tc.repo); rewritten != tc.expected {
+				t.Errorf("Rewrite(%q) = %q, want %q", tc.repo, rewritten, tc.expected)
+			}
+		})
+	}
+}
+
+// webStubClient is a registry client with a web UI
+type webStubClient struct {
+	stubClient
+}
+
+func (w *webStubClient) TagURL(repo, tag string) (string, error) {
+	return "https://registry.example.com/ui/" + repo + "/" + tag, nil
+}
+
+func TestResolverTagURL(t *testing.T) {
+	resolver := NewResolver(&webStubClient{})
+	resolver.Register("artifactory.example.com", &stubClient{})
+
+	testCases := []struct {
+		repo     string
+		tag      string
+		expected string
+		wantErr  bool
+	}{
+		{repo: "nginx", tag: "1.25.4", expected: "https://registry.example.com/ui/nginx/1.25.4"},
+		{repo: "ghcr.io/org/app", tag: "1.2.0", expected: "https://ghcr.io/org/app"},
+		{repo: "quay.io/org/app", tag: "1.2.0@sha256:abc", expected: "https://quay.io/repository/org/app?tab=tags&tag=1.2.0"},
+		{repo: "artifactory.example.com/app", tag: "1.2.0", wantErr: true},
+		{repo: "unknown.example.com/app", tag: "1.2.0", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.repo, func(t *testing.T) {
+			got, err := resolver.TagURL(tc.repo, tc.tag)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("TagURL() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.expected {
+				t.Errorf("TagURL() = %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WebClient is implemented by registry adapters whose registry has a web UI
+type WebClient interface {
+	// TagURL returns the web page of a tag
+	TagURL(repo, tag string) (string, error)
+}
+
+// TagURL returns the web page of a tag on the registry of a repository. Public registries
+// without an adapter, GHCR and Quay, are linked directly; GHCR has no page per tag so the
+// package page is returned. Mirrors are not applied, reviewers being sent to the original.
+func (r *Resolver) TagURL(repo, tag string) (string, error) {
+	// Digests pinned next to tags are not part of the page address
+	tag, _, _ = strings.Cut(tag, "@")
+
+	host, path := SplitHost(repo)
+	switch strings.ToLower(host) {
+	case "ghcr.io":
+		return "https://ghcr.io/" + path, nil
+	case "quay.io":
+		return fmt.Sprintf("https://quay.io/repository/%s?tab=tags&tag=%s", path, url.QueryEscape(tag)), nil
+	}
+
+	client := r.defaultClient
+	if host != "" && !IsDockerHub(host) {
+		var err error
+		if client, err = r.clientForHost(strings.ToLower(host)); err != nil {
+			return "", err
+		}
+	}
+
+	webClient, ok := client.(WebClient)
+	if !ok {
+		return "", fmt.Errorf("no web UI known for the registry of %s", repo)
+	}
+	return webClient.TagURL(path, tag)
+}
+
+// TagURL returns the page of the artifact of a tag in the Harbor portal, which addresses
+// projects by ID and artifacts by digest
+func (c *HarborClient) TagURL(repo, tag string) (string, error) {
+	projectName, name, found := strings.Cut(repo, "/")
+	if !found {
+		return "", fmt.Errorf("harbor repository must be <project>/<repository>: %s", repo)
+	}
+
+	var project struct {
+		ProjectID int `json:"project_id"`
+	}
+	projectURL := fmt.Sprintf("%s/api/v2.0/projects/%s", c.baseURL, url.PathEscape(projectName))
+	if _, err := c.do(context.Background(), http.MethodGet, projectURL, "application/json", &project); err != nil {
+		return "", fmt.Errorf("error fetching project %s: %w", projectName, err)
+	}
+
+	digest, err := c.FetchTagDigest(repo, tag)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/harbor/projects/%d/repositories/%s/artifacts-tab/artifacts/%s",
+		c.baseURL, project.ProjectID, url.PathEscape(url.PathEscape(name)), digest), nil
+}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+)
+
+// harborPushType is the event type of Harbor webhooks sent when an artifact is pushed
+const harborPushType = "PUSH_ARTIFACT"
+
+// PushEvent is a registry webhook announcing pushed tags
+type PushEvent struct {
+	// Repositories are the pushed repositories, with the host of the registry
+	// except for Docker Hub
+	Repositories []string
+	// Tags are the pushed tags
+	Tags []string
+}
+
+// webhookPayload holds the fields of Harbor and Docker Hub push webhooks
+type webhookPayload struct {
+	// Harbor
+	Type      string `json:"type"`
+	EventData *struct {
+		Resources []struct {
+			Tag         string `json:"tag"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+	} `json:"event_data"`
+
+	// Docker Hub
+	PushData *struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository *struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+// ParsePushEvent parses a Harbor or Docker Hub push webhook. Other Harbor events
+// are returned without repositories.
+func ParsePushEvent(body []byte) (*PushEvent, error) {
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+
+	event := &PushEvent{}
+	switch {
+	case payload.EventData != nil:
+		if payload.Type != harborPushType {
+			return event, nil
+		}
+		for _, resource := range payload.EventData.Resources {
+			repo := trimReference(resource.ResourceURL)
+			if repo == "" {
+				continue
+			}
+			event.Repositories = appendUnique(event.Repositories, repo)
+			if resource.Tag != "" {
+				event.Tags = appendUnique(event.Tags, resource.Tag)
+			}
+		}
+
+	case payload.PushData != nil && payload.Repository != nil:
+		if payload.Repository.RepoName == "" {
+			return nil, fmt.Errorf("docker hub webhook without repository name")
+		}
+		event.Repositories = []string{payload.Repository.RepoName}
+		if payload.PushData.Tag != "" {
+			event.Tags = []string{payload.PushData.Tag}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported webhook payload, expected a Harbor or Docker Hub push event")
+	}
+
+	return event, nil
+}
+
+// SameRepository reports whether two repositories without tag are the same, Docker Hub
+// repositories being equal with or without host and library/ prefix
+func SameRepository(a, b string) bool {
+	return strings.EqualFold(reference.Normalize(a), reference.Normalize(b))
+}
+
+// trimReference returns the repository of an image reference without its tag or digest
+func trimReference(image string) string {
+	ref, err := reference.Parse(image)
+	if err != nil {
+		return image
+	}
+	return ref.Repository()
+}
+
+// appendUnique appends a value to a list unless it is already there
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
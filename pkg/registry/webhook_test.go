@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePushEvent(t *testing.T) {
+	testCases := []struct {
+		name         string
+		body         string
+		repositories []string
+		tags         []string
+	}{
+		{
+			name: "harbor",
+			body: `{"type": "PUSH_ARTIFACT", "event_data": {"resources": [
+				{"tag": "1.2.0", "resource_url": "harbor.example.com/project/app:1.2.0"},
+				{"tag": "latest", "resource_url": "harbor.example.com/project/app:latest"}
+			], "repository": {"repo_full_name": "project/app"}}}`,
+			repositories: []string{"harbor.example.com/project/app"},
+			tags:         []string{"1.2.0", "latest"},
+		},
+		{
+			name:         "harbor pull",
+			body:         `{"type": "PULL_ARTIFACT", "event_data": {"resources": [{"tag": "1.2.0", "resource_url": "harbor.example.com/project/app:1.2.0"}]}}`,
+			repositories: nil,
+			tags:         nil,
+		},
+		{
+			name:         "docker hub",
+			body:         `{"callback_url": "https://registry.hub.docker.com/u/user/app/hook/1/", "push_data": {"tag": "2.0.1"}, "repository": {"repo_name": "user/app"}}`,
+			repositories: []string{"user/app"},
+			tags:         []string{"2.0.1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			event, err := ParsePushEvent([]byte(tc.body))
+			if err != nil {
+				t.Fatalf("ParsePushEvent() error = %v", err)
+			}
+			if !reflect.DeepEqual(event.Repositories, tc.repositories) || !reflect.DeepEqual(event.Tags, tc.tags) {
+				t.Errorf("ParsePushEvent() = %v %v, want %v %v", event.Repositories, event.Tags, tc.repositories, tc.tags)
+			}
+		})
+	}
+
+	if _, err := ParsePushEvent([]byte(`{"object_kind": "push"}`)); err == nil {
+		t.Error("ParsePushEvent() accepted a GitLab event")
+	}
+}
+
+func TestSameRepository(t *testing.T) {
+	testCases := []struct {
+		a, b string
+		want bool
+	}{
+		{"nginx", "docker.io/library/nginx", true},
+		{"user/app", "index.docker.io/user/app", true},
+		{"harbor.example.com/project/app", "harbor.example.com/project/app", true},
+		{"harbor.example.com/project/app", "project/app", false},
+		{"user/app", "user/api", false},
+	}
+
+	for _, tc := range testCases {
+		if got := SameRepository(tc.a, tc.b); got != tc.want {
+			t.Errorf("SameRepository(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
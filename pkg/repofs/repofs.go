@@ -0,0 +1,222 @@
+// Package repofs reads the files of the scanned repository. They are read from the disk,
+// except for repositories fetched through the GitLab API, whose files are held in memory
+// under a directory that does not exist on the disk so that no file is written.
+package repofs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tree holds the files mounted under a directory, by slash-separated relative path
+type tree struct {
+	files map[string][]byte
+}
+
+var (
+	// mounts are the trees held in memory, by the directory they are mounted on
+	mounts = make(map[string]*tree)
+	// mu guards mounts
+	mu sync.RWMutex
+)
+
+// Mount holds files, given by slash-separated path relative to root, in memory under root
+func Mount(root string, files map[string][]byte) {
+	t := &tree{files: make(map[string][]byte, len(files))}
+	for name, content := range files {
+		t.files[filepath.ToSlash(filepath.Clean(name))] = content
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	mounts[filepath.Clean(root)] = t
+}
+
+// Unmount releases the files held in memory under root
+func Unmount(root string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(mounts, filepath.Clean(root))
+}
+
+// IsMounted reports whether the files under root are held in memory
+func IsMounted(root string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := mounts[filepath.Clean(root)]
+	return ok
+}
+
+// lookup returns the tree holding a path and the path relative to its root, nil if the
+// path is on the disk
+func lookup(name string) (*tree, string) {
+	name = filepath.Clean(name)
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for root, t := range mounts {
+		if name == root {
+			return t, "."
+		}
+		if rel, ok := strings.CutPrefix(name, root+string(filepath.Separator)); ok {
+			return t, filepath.ToSlash(rel)
+		}
+	}
+	return nil, ""
+}
+
+// isDir reports whether a relative path is a directory of the tree
+func (t *tree) isDir(rel string) bool {
+	if rel == "." {
+		return true
+	}
+	for name := range t.files {
+		if strings.HasPrefix(name, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// children returns the names of the files and directories directly in a directory
+func (t *tree) children(rel string) (files, dirs []string) {
+	prefix := rel + "/"
+	if rel == "." {
+		prefix = ""
+	}
+	for name := range t.files {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		if child, _, nested := strings.Cut(rest, "/"); nested {
+			if !slices.Contains(dirs, child) {
+				dirs = append(dirs, child)
+			}
+		} else {
+			files = append(files, child)
+		}
+	}
+	return files, dirs
+}
+
+// ReadFile reads a file of the repository
+func ReadFile(name string) ([]byte, error) {
+	t, rel := lookup(name)
+	if t == nil {
+		return os.ReadFile(name)
+	}
+
+	content, ok := t.files[rel]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return bytes.Clone(content), nil
+}
+
+// Open opens a file of the repository for reading
+func Open(name string) (io.ReadCloser, error) {
+	if t, _ := lookup(name); t == nil {
+		return os.Open(name)
+	}
+
+	content, err := ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// Stat returns the information of a file or directory of the repository
+func Stat(name string) (fs.FileInfo, error) {
+	t, rel := lookup(name)
+	if t == nil {
+		return os.Stat(name)
+	}
+
+	if content, ok := t.files[rel]; ok {
+		return &fileInfo{name: filepath.Base(name), size: int64(len(content))}, nil
+	}
+	if t.isDir(rel) {
+		return &fileInfo{name: filepath.Base(name), dir: true}, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// Walk walks the files and directories of the repository under root in lexical order,
+// as filepath.Walk does
+func Walk(root string, fn filepath.WalkFunc) error {
+	t, rel := lookup(root)
+	if t == nil {
+		return filepath.Walk(root, fn)
+	}
+
+	info, err := Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	err = t.walk(root, rel, info, fn)
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walk walks a path of the tree and, if it is a directory, its content
+func (t *tree) walk(path, rel string, info fs.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	if err := fn(path, info, nil); err != nil {
+		return err
+	}
+
+	files, dirs := t.children(rel)
+	names := append(files, dirs...)
+	slices.Sort(names)
+	for _, name := range names {
+		childRel := name
+		if rel != "." {
+			childRel = rel + "/" + name
+		}
+		childInfo := &fileInfo{name: name, dir: slices.Contains(dirs, name), size: int64(len(t.files[childRel]))}
+		err := t.walk(filepath.Join(path, name), childRel, childInfo, fn)
+		if err == filepath.SkipDir {
+			if !childInfo.dir {
+				// Skipping from a file skips the rest of its directory
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileInfo describes a file or directory held in memory
+type fileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i *fileInfo) Name() string { return i.name }
+func (i *fileInfo) Size() int64  { return i.size }
+func (i *fileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+func (i *fileInfo) ModTime() time.Time { return time.Time{} }
+func (i *fileInfo) IsDir() bool        { return i.dir }
+func (i *fileInfo) Sys() any           { return nil }
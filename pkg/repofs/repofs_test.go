@@ -0,0 +1,94 @@
+package repofs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMount(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "mirror")
+	Mount(root, map[string][]byte{
+		"docker-compose.yml":         []byte("services: {}\n"),
+		"deploy/prod/compose.yml":    []byte("services:\n  web: {}\n"),
+		"deploy/staging/compose.yml": []byte(""),
+	})
+	defer Unmount(root)
+
+	content, err := ReadFile(filepath.Join(root, "deploy", "prod", "compose.yml"))
+	if err != nil || string(content) != "services:\n  web: {}\n" {
+		t.Errorf("ReadFile() = %q, %v", content, err)
+	}
+	if _, err := ReadFile(filepath.Join(root, "missing.yml")); !os.IsNotExist(err) {
+		t.Errorf("ReadFile() of a missing file error = %v, want not exist", err)
+	}
+
+	for path, dir := range map[string]bool{root: true, filepath.Join(root, "deploy"): true, filepath.Join(root, "docker-compose.yml"): false} {
+		info, err := Stat(path)
+		if err != nil || info.IsDir() != dir {
+			t.Errorf("Stat(%s) = %v, %v, want directory %v", path, info, err, dir)
+		}
+	}
+	if _, err := Stat(filepath.Join(root, "dep")); !os.IsNotExist(err) {
+		t.Errorf("Stat() of a path prefix error = %v, want not exist", err)
+	}
+
+	// Nothing is written to the disk
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("mount directory exists on the disk: %v", err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "mirror")
+	Mount(root, map[string][]byte{
+		"b.yml":           nil,
+		"a/compose.yml":   nil,
+		"a/b/deep.yml":    nil,
+		"skip/hidden.yml": nil,
+	})
+	defer Unmount(root)
+
+	var visited []string
+	err := Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if info.IsDir() {
+			rel += "/"
+			if info.Name() == "skip" {
+				return filepath.SkipDir
+			}
+		}
+		visited = append(visited, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+
+	// Entries are walked in lexical order, like filepath.Walk
+	if got, want := strings.Join(visited, " "), "./ a/ a/b/ a/b/deep.yml a/compose.yml b.yml"; got != want {
+		t.Errorf("Walk() visited %s, want %s", got, want)
+	}
+}
+
+func TestDiskFallback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "compose.yml")
+	if err := os.WriteFile(path, []byte("services: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := ReadFile(path); err != nil || string(content) != "services: {}\n" {
+		t.Errorf("ReadFile() = %q, %v", content, err)
+	}
+	if info, err := Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("Stat() = %v, %v", info, err)
+	}
+	if IsMounted(dir) {
+		t.Errorf("IsMounted(%s) = true for a directory of the disk", dir)
+	}
+}
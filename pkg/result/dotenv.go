@@ -0,0 +1,24 @@
+package result
+
+import (
+	"fmt"
+	"io"
+)
+
+// reportDotenv prints the counts of the result as KEY=value lines, the format of GitLab CI
+// dotenv reports exposing them as variables to the jobs of the following stages
+func reportDotenv(w io.Writer, r *ScanResult) error {
+	var major, downgrades int
+	for _, update := range r.Updates {
+		if update.Major {
+			major++
+		}
+		if update.Downgrade {
+			downgrades++
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "IMG_UPGR_UPDATES=%d\nIMG_UPGR_MAJOR_UPDATES=%d\nIMG_UPGR_DOWNGRADES=%d\nIMG_UPGR_ERRORS=%d\nIMG_UPGR_END_OF_LIFE=%d\n",
+		len(r.Updates), major, downgrades, len(r.Errors), len(r.EndOfLife))
+	return err
+}
@@ -0,0 +1,111 @@
+package result
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// junitSuiteName is the name of the test suite holding the checked images
+const junitSuiteName = "img-upgr"
+
+// junitTestSuites is the root element of a JUnit XML report
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite groups the test cases of a run
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is an outdated image or a failed check
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+// junitMessage describes why a test case did not pass
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// reportJUnit prints the result as a JUnit XML report, outdated and end of life
+// images being failures and the images that could not be checked errors, so that
+// CI systems show them as failed tests
+func reportJUnit(w io.Writer, r *ScanResult) error {
+	suite := junitTestSuite{Name: junitSuiteName}
+
+	for _, update := range r.Updates {
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      update.ServiceName,
+			ClassName: r.RelPath(update.FilePath),
+			Failure: &junitMessage{
+				Message: fmt.Sprintf("%s can be updated from %s to %s", update.Repository, update.OldTag, update.NewTag),
+				Type:    "outdated",
+				Text:    fmt.Sprintf("%s → %s", update.OldImage, update.NewImage),
+			},
+		})
+	}
+	for _, notice := range r.EndOfLife {
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      notice.ServiceName,
+			ClassName: r.RelPath(notice.FilePath),
+			Failure: &junitMessage{
+				Message: notice.Message(),
+				Type:    "end-of-life",
+				Text:    notice.Image,
+			},
+		})
+	}
+	suite.Failures = len(r.Updates) + len(r.EndOfLife)
+
+	for _, fileError := range r.Errors {
+		name := fileError.ServiceName
+		if name == "" {
+			name = "parse"
+		}
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      name,
+			ClassName: r.RelPath(fileError.FilePath),
+			Error:     &junitMessage{Message: fileError.Error, Type: "error"},
+		})
+	}
+	suite.Errors = len(r.Errors)
+
+	// A run without findings still reports a passing test
+	if len(suite.Cases) == 0 {
+		suite.Cases = append(suite.Cases, junitTestCase{Name: "images up to date", ClassName: junitSuiteName})
+	}
+	suite.Tests = len(suite.Cases)
+
+	report := junitTestSuites{
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
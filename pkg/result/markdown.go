@@ -0,0 +1,59 @@
+package result
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// reportMarkdown prints the result as Markdown tables, e.g. for a merge request comment
+func reportMarkdown(w io.Writer, r *ScanResult) error {
+	var b strings.Builder
+
+	b.WriteString("## Image updates\n\n")
+	if !r.HasUpdates() {
+		b.WriteString("All images are up to date.\n")
+	} else {
+		b.WriteString("| File | Service | Image | Current | Latest |\n")
+		b.WriteString("|------|---------|-------|---------|--------|\n")
+		for _, update := range r.Updates {
+			fmt.Fprintf(&b, "| `%s` | `%s` | `%s` | `%s` | `%s` |\n",
+				escapeMarkdownCell(r.RelPath(update.FilePath)), escapeMarkdownCell(update.ServiceName),
+				escapeMarkdownCell(update.Repository), escapeMarkdownCell(update.OldTag), escapeMarkdownCell(update.NewTag))
+		}
+	}
+
+	if r.HasEndOfLife() {
+		b.WriteString("\n## End of life\n\n")
+		b.WriteString("| File | Service | Image | Status |\n")
+		b.WriteString("|------|---------|-------|--------|\n")
+		for _, notice := range r.EndOfLife {
+			fmt.Fprintf(&b, "| `%s` | `%s` | `%s` | %s |\n",
+				escapeMarkdownCell(r.RelPath(notice.FilePath)), escapeMarkdownCell(notice.ServiceName),
+				escapeMarkdownCell(notice.Image), escapeMarkdownCell(notice.Message()))
+		}
+	}
+
+	if r.HasErrors() {
+		b.WriteString("\n## Errors\n\n")
+		b.WriteString("| File | Service | Error |\n")
+		b.WriteString("|------|---------|-------|\n")
+		for _, fileError := range r.Errors {
+			service := ""
+			if fileError.ServiceName != "" {
+				service = "`" + escapeMarkdownCell(fileError.ServiceName) + "`"
+			}
+			fmt.Fprintf(&b, "| `%s` | %s | %s |\n",
+				escapeMarkdownCell(r.RelPath(fileError.FilePath)), service, escapeMarkdownCell(fileError.Error))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// escapeMarkdownCell keeps a value on one line and from closing its table cell
+func escapeMarkdownCell(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	return strings.Join(strings.Fields(value), " ")
+}
@@ -0,0 +1,112 @@
+package result
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Reporter prints the result of a run in an output format
+type Reporter interface {
+	Report(w io.Writer, r *ScanResult) error
+}
+
+// ReporterFunc adapts a function to the Reporter interface
+type ReporterFunc func(w io.Writer, r *ScanResult) error
+
+// Report calls f(w, r)
+func (f ReporterFunc) Report(w io.Writer, r *ScanResult) error {
+	return f(w, r)
+}
+
+var (
+	reportersMu sync.RWMutex
+	reporters   = map[string]Reporter{
+		"text":     TextReporter{},
+		"json":     ReporterFunc(reportJSON),
+		"yaml":     ReporterFunc(reportYAML),
+		"markdown": ReporterFunc(reportMarkdown),
+		"junit":    ReporterFunc(reportJUnit),
+		"sarif":    ReporterFunc(reportSARIF),
+		"dotenv":   ReporterFunc(reportDotenv),
+	}
+)
+
+// Register makes a reporter available for an output format, replacing any
+// reporter registered for it before
+func Register(format string, reporter Reporter) {
+	reportersMu.Lock()
+	defer reportersMu.Unlock()
+	reporters[format] = reporter
+}
+
+// ReporterFor returns the reporter of an output format
+func ReporterFor(format string) (Reporter, error) {
+	reportersMu.RLock()
+	defer reportersMu.RUnlock()
+
+	reporter, ok := reporters[format]
+	if !ok {
+		return nil, fmt.Errorf("no reporter for output format %q, must be one of: %s", format, strings.Join(formatsLocked(), ", "))
+	}
+	return reporter, nil
+}
+
+// Formats returns the output formats that have a reporter
+func Formats() []string {
+	reportersMu.RLock()
+	defer reportersMu.RUnlock()
+	return formatsLocked()
+}
+
+// formatsLocked returns the sorted output formats, the caller holding reportersMu
+func formatsLocked() []string {
+	formats := make([]string, 0, len(reporters))
+	for format := range reporters {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// WriteFile writes the result to a file with the reporter of an output format, e.g. for
+// the artifacts of a CI job
+func WriteFile(path, format string, r *ScanResult) error {
+	reporter, err := ReporterFor(format)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if err := reporter.Report(&b, r); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s report: %w", format, err)
+	}
+	return nil
+}
+
+// reportJSON prints the result as indented JSON
+func reportJSON(w io.Writer, r *ScanResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r.normalized())
+}
+
+// reportYAML prints the result as YAML
+func reportYAML(w io.Writer, r *ScanResult) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(r.normalized()); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
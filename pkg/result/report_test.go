@@ -0,0 +1,263 @@
+package result
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReportJSONKeepsEmptyLists(t *testing.T) {
+	reporter, err := ReporterFor("json")
+	if err != nil {
+		t.Fatalf("ReporterFor() error = %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := reporter.Report(&out, New(nil, nil)); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+
+	var decoded struct {
+		Updates []any `json:"updates"`
+		Errors  []any `json:"errors"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if decoded.Updates == nil || decoded.Errors == nil {
+		t.Errorf("Report() = %s, want empty lists", out.String())
+	}
+}
+
+func TestReportYAML(t *testing.T) {
+	reporter, err := ReporterFor("yaml")
+	if err != nil {
+		t.Fatalf("ReporterFor() error = %v", err)
+	}
+
+	res := New([]UpdateCandidate{{
+		FilePath:    "docker-compose.yml",
+		ServiceName: "web",
+		OldImage:    "nginx:1.25.0",
+		NewImage:    "nginx:1.25.3",
+		Repository:  "nginx",
+		OldTag:      "1.25.0",
+		NewTag:      "1.25.3",
+	}}, nil)
+
+	var out bytes.Buffer
+	if err := reporter.Report(&out, res); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	for _, want := range []string{"updates:\n  - file: docker-compose.yml", "new_tag: 1.25.3", "errors: []"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Report() = %q, want it to contain %q", out.String(), want)
+		}
+	}
+	if strings.Contains(out.String(), "kind") {
+		t.Errorf("Report() = %q, want the empty kind omitted", out.String())
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if _, err := ReporterFor("custom"); err == nil {
+		t.Fatal("ReporterFor() should fail for an unknown format")
+	}
+
+	var reported *ScanResult
+	Register("custom", ReporterFunc(func(_ io.Writer, r *ScanResult) error {
+		reported = r
+		return nil
+	}))
+
+	reporter, err := ReporterFor("custom")
+	if err != nil {
+		t.Fatalf("ReporterFor() error = %v", err)
+	}
+	res := New(nil, []FileError{{FilePath: "compose.yml", Error: "boom"}})
+	if err := reporter.Report(io.Discard, res); err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if reported != res {
+		t.Error("Report() did not call the registered reporter")
+	}
+}
+
+// sampleResult returns a result with one update and one error below root
+func sampleResult(t *testing.T) *ScanResult {
+	t.Helper()
+
+	root := t.TempDir()
+	composePath := filepath.Join(root, "apps", "docker-compose.yml")
+	if err := os.MkdirAll(filepath.Dir(composePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "services:\n  web:\n    image: nginx:1.25.0\n"
+	if err := os.WriteFile(composePath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res := New([]UpdateCandidate{{
+		FilePath:    composePath,
+		ServiceName: "web",
+		OldImage:    "nginx:1.25.0",
+		NewImage:    "nginx:1.25.3",
+		Repository:  "nginx",
+		OldTag:      "1.25.0",
+		NewTag:      "1.25.3",
+		Line:        3,
+	}}, []FileError{{FilePath: filepath.Join(root, "broken.yml"), Error: "failed to parse YAML | bad"}})
+	res.Root = root
+	return res
+}
+
+func TestReportMarkdown(t *testing.T) {
+	var out bytes.Buffer
+	if err := reportMarkdown(&out, sampleResult(t)); err != nil {
+		t.Fatalf("reportMarkdown() error = %v", err)
+	}
+
+	for _, want := range []string{
+		"| `apps/docker-compose.yml` | `web` | `nginx` | `1.25.0` | `1.25.3` |",
+		"| `broken.yml` |  | failed to parse YAML \\| bad |",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("reportMarkdown() = %q, want it to contain %q", out.String(), want)
+		}
+	}
+}
+
+func TestReportMarkdownEndOfLife(t *testing.T) {
+	res := New(nil, nil)
+	res.EndOfLife = []EndOfLifeNotice{{
+		FilePath:    "docker-compose.yml",
+		ServiceName: "app",
+		Image:       "node:16.20.2",
+		Product:     "nodejs",
+		Cycle:       "16",
+		EOL:         "2023-09-11",
+		NextCycle:   "18",
+	}}
+
+	var out bytes.Buffer
+	if err := reportMarkdown(&out, res); err != nil {
+		t.Fatalf("reportMarkdown() error = %v", err)
+	}
+
+	want := "| `docker-compose.yml` | `app` | `node:16.20.2` | nodejs 16 reached its end of life on 2023-09-11, 18 is the next supported series |"
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("reportMarkdown() = %q, want it to contain %q", out.String(), want)
+	}
+}
+
+func TestReportJUnit(t *testing.T) {
+	var out bytes.Buffer
+	if err := reportJUnit(&out, sampleResult(t)); err != nil {
+		t.Fatalf("reportJUnit() error = %v", err)
+	}
+
+	var report junitTestSuites
+	if err := xml.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("invalid XML %q: %v", out.String(), err)
+	}
+	if report.Tests != 2 || report.Failures != 1 || report.Errors != 1 {
+		t.Errorf("counts = %d tests, %d failures, %d errors, want 2, 1, 1", report.Tests, report.Failures, report.Errors)
+	}
+	if got := report.Suites[0].Cases[0].ClassName; got != "apps/docker-compose.yml" {
+		t.Errorf("classname = %q, want the relative path", got)
+	}
+
+	// A clean run has a single passing test
+	out.Reset()
+	if err := reportJUnit(&out, New(nil, nil)); err != nil {
+		t.Fatalf("reportJUnit() error = %v", err)
+	}
+	if err := xml.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Tests != 1 || report.Failures != 0 {
+		t.Errorf("clean run counts = %d tests, %d failures, want 1, 0", report.Tests, report.Failures)
+	}
+}
+
+func TestReportSARIF(t *testing.T) {
+	var out bytes.Buffer
+	if err := reportSARIF(&out, sampleResult(t)); err != nil {
+		t.Fatalf("reportSARIF() error = %v", err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	results := log.Runs[0].Results
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+
+	outdated := results[0]
+	if outdated.RuleID != sarifRuleOutdated || outdated.Level != "warning" {
+		t.Errorf("first result = %s/%s, want %s/warning", outdated.RuleID, outdated.Level, sarifRuleOutdated)
+	}
+	location := outdated.Locations[0].PhysicalLocation
+	if location.ArtifactLocation.URI != "apps/docker-compose.yml" {
+		t.Errorf("uri = %q, want the relative path", location.ArtifactLocation.URI)
+	}
+	if location.Region == nil || location.Region.StartLine != 3 {
+		t.Errorf("region = %+v, want line 3", location.Region)
+	}
+
+	if results[1].RuleID != sarifRuleError || results[1].Locations[0].PhysicalLocation.Region != nil {
+		t.Errorf("second result = %+v, want an error without region", results[1])
+	}
+}
+
+func TestReportDotenv(t *testing.T) {
+	var out bytes.Buffer
+	if err := reportDotenv(&out, sampleResult(t)); err != nil {
+		t.Fatalf("reportDotenv() error = %v", err)
+	}
+
+	want := "IMG_UPGR_UPDATES=1\nIMG_UPGR_MAJOR_UPDATES=0\nIMG_UPGR_DOWNGRADES=0\nIMG_UPGR_ERRORS=1\nIMG_UPGR_END_OF_LIFE=0\n"
+	if out.String() != want {
+		t.Errorf("reportDotenv() = %q, want %q", out.String(), want)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := WriteFile(path, "json", sampleResult(t)); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ScanResult
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded.Updates) != 1 {
+		t.Errorf("WriteFile() wrote %q, want the JSON result", data)
+	}
+
+	if err := WriteFile(path, "unknown", sampleResult(t)); err == nil {
+		t.Error("WriteFile() should fail for an unknown format")
+	}
+}
+
+func TestRelPath(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "repo")
+	res := &ScanResult{Root: root}
+
+	if got := res.RelPath(filepath.Join(root, "a", "compose.yml")); got != "a/compose.yml" {
+		t.Errorf("RelPath() = %q, want a/compose.yml", got)
+	}
+	outside := filepath.Join(string(filepath.Separator), "other", "compose.yml")
+	if got := res.RelPath(outside); got != outside {
+		t.Errorf("RelPath() = %q, want the path outside the root unchanged", got)
+	}
+}
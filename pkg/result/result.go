@@ -0,0 +1,134 @@
+// Package result defines the results of the check and scan commands and the
+// reporters that print them.
+package result
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/runid"
+)
+
+// SchemaVersion is the version of the structured output described by schema.json. It
+// only changes when fields are removed, renamed or change type; new optional fields
+// keep the version.
+const SchemaVersion = 1
+
+// UpdateCandidate is an image reference that can be updated to a newer version
+type UpdateCandidate struct {
+	FilePath    string `json:"file" yaml:"file"`
+	ServiceName string `json:"service" yaml:"service"`
+	OldImage    string `json:"old_image" yaml:"old_image"`
+	NewImage    string `json:"new_image" yaml:"new_image"`
+	Repository  string `json:"repository" yaml:"repository"`
+	OldTag      string `json:"old_tag" yaml:"old_tag"`
+	NewTag      string `json:"new_tag" yaml:"new_tag"`
+	// Kind is empty for compose images, otherwise the gitops reference kind
+	Kind string `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// Major is true when the update changes the major version
+	Major bool `json:"major,omitempty" yaml:"major,omitempty"`
+	// Downgrade is true when the update rolls back to an older version
+	Downgrade bool `json:"downgrade,omitempty" yaml:"downgrade,omitempty"`
+	// Verification describes the signature or attestation verified for the new image
+	Verification string `json:"verification,omitempty" yaml:"verification,omitempty"`
+	// Line is the 1-based line of the old image or version in the file, 0 if unknown
+	Line int `json:"line,omitempty" yaml:"line,omitempty"`
+}
+
+// FileError records a failure while processing a file or one of its images
+type FileError struct {
+	FilePath    string `json:"file" yaml:"file"`
+	ServiceName string `json:"service,omitempty" yaml:"service,omitempty"`
+	Error       string `json:"error" yaml:"error"`
+}
+
+// EndOfLifeNotice flags an image whose release series reached its end of life
+type EndOfLifeNotice struct {
+	FilePath    string `json:"file" yaml:"file"`
+	ServiceName string `json:"service" yaml:"service"`
+	Image       string `json:"image" yaml:"image"`
+	Product     string `json:"product" yaml:"product"`
+	Cycle       string `json:"cycle" yaml:"cycle"`
+	// EOL is the end of life date of the series as YYYY-MM-DD, empty if unknown
+	EOL string `json:"eol,omitempty" yaml:"eol,omitempty"`
+	// NextCycle is the oldest supported series newer than the current one, if any
+	NextCycle string `json:"next_cycle,omitempty" yaml:"next_cycle,omitempty"`
+	// Line is the 1-based line of the image in the file, 0 if unknown
+	Line int `json:"line,omitempty" yaml:"line,omitempty"`
+}
+
+// ScanResult is the structured result of a check or scan run
+type ScanResult struct {
+	// SchemaVersion is set to the SchemaVersion constant by the structured reporters
+	SchemaVersion int `json:"schema_version" yaml:"schema_version"`
+	// RunID identifies the run that produced the result, see package runid
+	RunID string `json:"run_id,omitempty" yaml:"run_id,omitempty"`
+
+	Updates   []UpdateCandidate `json:"updates" yaml:"updates"`
+	Errors    []FileError       `json:"errors" yaml:"errors"`
+	EndOfLife []EndOfLifeNotice `json:"end_of_life,omitempty" yaml:"end_of_life,omitempty"`
+
+	// Root is the directory the reporters show file paths relative to, if set
+	Root string `json:"-" yaml:"-"`
+}
+
+// New creates a result from the updates and errors of a run
+func New(updates []UpdateCandidate, errors []FileError) *ScanResult {
+	return &ScanResult{RunID: runid.ID(), Updates: updates, Errors: errors}
+}
+
+// HasUpdates reports whether updates were found
+func (r *ScanResult) HasUpdates() bool {
+	return len(r.Updates) > 0
+}
+
+// HasErrors reports whether some files or images could not be checked
+func (r *ScanResult) HasErrors() bool {
+	return len(r.Errors) > 0
+}
+
+// HasEndOfLife reports whether images of series past their end of life were found
+func (r *ScanResult) HasEndOfLife() bool {
+	return len(r.EndOfLife) > 0
+}
+
+// RelPath returns a slash-separated path relative to the root of the result, or the
+// path itself when it is not below the root
+func (r *ScanResult) RelPath(path string) string {
+	if r.Root == "" {
+		return path
+	}
+
+	relPath, err := filepath.Rel(r.Root, path)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return path
+	}
+	return filepath.ToSlash(relPath)
+}
+
+// Message describes the notice for reports
+func (n EndOfLifeNotice) Message() string {
+	message := fmt.Sprintf("%s %s reached its end of life", n.Product, n.Cycle)
+	if n.EOL != "" {
+		message += " on " + n.EOL
+	}
+	if n.NextCycle != "" {
+		message += fmt.Sprintf(", %s is the next supported series", n.NextCycle)
+	}
+	return message
+}
+
+// normalized returns a copy of the result with the schema version and empty lists
+// instead of nil ones, so that consumers of structured output always see lists
+func (r *ScanResult) normalized() *ScanResult {
+	normalized := *r
+	normalized.SchemaVersion = SchemaVersion
+	if normalized.Updates == nil {
+		normalized.Updates = []UpdateCandidate{}
+	}
+	if normalized.Errors == nil {
+		normalized.Errors = []FileError{}
+	}
+	return &normalized
+}
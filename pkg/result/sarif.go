@@ -0,0 +1,142 @@
+package result
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/version"
+)
+
+// SARIF format constants
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+
+	// sarifRuleOutdated is reported for every image with a newer version
+	sarifRuleOutdated = "outdated-image"
+	// sarifRuleEndOfLife is reported for every image of a series past its end of life
+	sarifRuleEndOfLife = "end-of-life"
+	// sarifRuleError is reported for every file or image that could not be checked
+	sarifRuleError = "check-error"
+)
+
+// sarifLog is the root object of a SARIF report
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// reportSARIF prints the result as a SARIF log for code scanning dashboards
+func reportSARIF(w io.Writer, r *ScanResult) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "img-upgr",
+			Version:        version.GetVersion(),
+			InformationURI: "https://gitlab.com/sdko-core/appli/img-upgr",
+			Rules: []sarifRule{
+				{ID: sarifRuleOutdated, ShortDescription: sarifMessage{Text: "A newer version of the image is available"}},
+				{ID: sarifRuleEndOfLife, ShortDescription: sarifMessage{Text: "The release series of the image reached its end of life"}},
+				{ID: sarifRuleError, ShortDescription: sarifMessage{Text: "The file or image could not be checked"}},
+			},
+		}},
+		Results: []sarifResult{},
+	}
+
+	for _, update := range r.Updates {
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    sarifRuleOutdated,
+			Level:     "warning",
+			Message:   sarifMessage{Text: fmt.Sprintf("%s can be updated from %s to %s (service %s)", update.Repository, update.OldTag, update.NewTag, update.ServiceName)},
+			Locations: []sarifLocation{sarifLocationOf(r, update.FilePath, update.Line)},
+		})
+	}
+
+	for _, notice := range r.EndOfLife {
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    sarifRuleEndOfLife,
+			Level:     "warning",
+			Message:   sarifMessage{Text: fmt.Sprintf("%s: %s (service %s)", notice.Image, notice.Message(), notice.ServiceName)},
+			Locations: []sarifLocation{sarifLocationOf(r, notice.FilePath, notice.Line)},
+		})
+	}
+
+	for _, fileError := range r.Errors {
+		message := fileError.Error
+		if fileError.ServiceName != "" {
+			message = fmt.Sprintf("%s (service %s)", message, fileError.ServiceName)
+		}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    sarifRuleError,
+			Level:     "error",
+			Message:   sarifMessage{Text: message},
+			Locations: []sarifLocation{sarifLocationOf(r, fileError.FilePath, 0)},
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}})
+}
+
+// sarifLocationOf returns the location of a file, with the line recorded by the parser
+// when it is known
+func sarifLocationOf(r *ScanResult, path string, line int) sarifLocation {
+	location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{URI: r.RelPath(path)},
+	}}
+	if line > 0 {
+		location.PhysicalLocation.Region = &sarifRegion{StartLine: line}
+	}
+	return location
+}
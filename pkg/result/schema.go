@@ -0,0 +1,8 @@
+package result
+
+import _ "embed"
+
+// Schema is the JSON Schema of the results printed by the json and yaml reporters
+//
+//go:embed schema.json
+var Schema []byte
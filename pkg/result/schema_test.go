@@ -0,0 +1,230 @@
+package result
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// schemaResult is a result with every field set, for the compatibility tests
+var schemaResult = &ScanResult{
+	Updates: []UpdateCandidate{{
+		FilePath:     "deploy/docker-compose.yml",
+		ServiceName:  "web",
+		OldImage:     "nginx:1.25.0",
+		NewImage:     "nginx:2.0.0",
+		Repository:   "nginx",
+		OldTag:       "1.25.0",
+		NewTag:       "2.0.0",
+		Kind:         "helm",
+		Major:        true,
+		Downgrade:    true,
+		Verification: "cosign",
+		Line:         3,
+	}},
+	Errors: []FileError{{FilePath: "deploy/broken.yml", ServiceName: "db", Error: "registry unavailable"}},
+	EndOfLife: []EndOfLifeNotice{{
+		FilePath:    "deploy/docker-compose.yml",
+		ServiceName: "db",
+		Image:       "postgres:12",
+		Product:     "postgresql",
+		Cycle:       "12",
+		EOL:         "2024-11-21",
+		NextCycle:   "13",
+		Line:        5,
+	}},
+}
+
+// loadSchema decodes the embedded JSON Schema
+func loadSchema(t *testing.T) map[string]any {
+	t.Helper()
+
+	var schema map[string]any
+	if err := json.Unmarshal(Schema, &schema); err != nil {
+		t.Fatalf("invalid schema: %v", err)
+	}
+	return schema
+}
+
+// reportJSONValue prints the result with the json reporter and decodes it
+func reportJSONValue(t *testing.T, r *ScanResult) map[string]any {
+	t.Helper()
+
+	var out bytes.Buffer
+	if err := reportJSON(&out, r); err != nil {
+		t.Fatalf("reportJSON() error = %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	return decoded
+}
+
+func TestSchemaDescribesResult(t *testing.T) {
+	schema := loadSchema(t)
+	defs := schema["$defs"].(map[string]any)
+
+	tests := []struct {
+		typ    reflect.Type
+		schema map[string]any
+	}{
+		{reflect.TypeOf(ScanResult{}), schema},
+		{reflect.TypeOf(UpdateCandidate{}), defs["update"].(map[string]any)},
+		{reflect.TypeOf(FileError{}), defs["error"].(map[string]any)},
+		{reflect.TypeOf(EndOfLifeNotice{}), defs["end_of_life"].(map[string]any)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ.Name(), func(t *testing.T) {
+			properties := tt.schema["properties"].(map[string]any)
+			var required []string
+			for _, name := range tt.schema["required"].([]any) {
+				required = append(required, name.(string))
+			}
+
+			fields := make(map[string]bool)
+			for i := range tt.typ.NumField() {
+				name, options, _ := strings.Cut(tt.typ.Field(i).Tag.Get("json"), ",")
+				if name == "-" {
+					continue
+				}
+				fields[name] = true
+
+				if _, ok := properties[name]; !ok {
+					t.Errorf("field %q is not described by the schema", name)
+				}
+				if omitted := options == "omitempty"; omitted == slices.Contains(required, name) {
+					t.Errorf("field %q: omitempty = %v, but required in the schema = %v", name, omitted, !omitted)
+				}
+			}
+			for name := range properties {
+				if !fields[name] {
+					t.Errorf("schema property %q is not a field of %s", name, tt.typ.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestReportJSONMatchesSchema(t *testing.T) {
+	schema := loadSchema(t)
+
+	for name, r := range map[string]*ScanResult{"empty": New(nil, nil), "full": schemaResult} {
+		t.Run(name, func(t *testing.T) {
+			validateSchema(t, schema, schema, reportJSONValue(t, r), "$")
+		})
+	}
+}
+
+func TestReportJSONBackwardCompatible(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "report.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var golden map[string]any
+	if err := json.Unmarshal(data, &golden); err != nil {
+		t.Fatalf("invalid golden report: %v", err)
+	}
+
+	// Fields may be added to the output, but the ones of released versions must keep
+	// their name, type and value
+	assertContains(t, reportJSONValue(t, schemaResult), golden, "$")
+}
+
+// validateSchema checks a decoded JSON value against the subset of JSON Schema used by
+// schema.json
+func validateSchema(t *testing.T, root, schema map[string]any, value any, path string) {
+	t.Helper()
+
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		schema = root["$defs"].(map[string]any)[name].(map[string]any)
+	}
+	if want, ok := schema["const"]; ok && !reflect.DeepEqual(value, want) {
+		t.Errorf("%s = %v, want %v", path, value, want)
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			t.Errorf("%s = %v, want an object", path, value)
+			return
+		}
+		for _, name := range schema["required"].([]any) {
+			if _, ok := object[name.(string)]; !ok {
+				t.Errorf("%s misses the required field %q", path, name)
+			}
+		}
+		properties := schema["properties"].(map[string]any)
+		for name, field := range object {
+			property, ok := properties[name].(map[string]any)
+			if !ok {
+				t.Errorf("%s.%s is not described by the schema", path, name)
+				continue
+			}
+			validateSchema(t, root, property, field, path+"."+name)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			t.Errorf("%s = %v, want an array", path, value)
+			return
+		}
+		for _, item := range items {
+			validateSchema(t, root, schema["items"].(map[string]any), item, path+"[]")
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			t.Errorf("%s = %v, want a string", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			t.Errorf("%s = %v, want a boolean", path, value)
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int(number)) {
+			t.Errorf("%s = %v, want an integer", path, value)
+		}
+	}
+}
+
+// assertContains checks that got holds every field of want with the same value
+func assertContains(t *testing.T, got, want any, path string) {
+	t.Helper()
+
+	switch want := want.(type) {
+	case map[string]any:
+		object, ok := got.(map[string]any)
+		if !ok {
+			t.Errorf("%s = %v, want an object", path, got)
+			return
+		}
+		for name, field := range want {
+			if _, ok := object[name]; !ok {
+				t.Errorf("%s.%s was removed", path, name)
+				continue
+			}
+			assertContains(t, object[name], field, path+"."+name)
+		}
+	case []any:
+		items, ok := got.([]any)
+		if !ok || len(items) != len(want) {
+			t.Errorf("%s = %v, want %d items", path, got, len(want))
+			return
+		}
+		for i := range want {
+			assertContains(t, items[i], want[i], path+"[]")
+		}
+	default:
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", path, got, want)
+		}
+	}
+}
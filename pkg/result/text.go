@@ -0,0 +1,37 @@
+package result
+
+import (
+	"io"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+// TextReporter logs a summary of the errors and end of life images of a run, the
+// updates being logged while they are found
+type TextReporter struct{}
+
+// Report logs the errors and end of life images of the result
+func (TextReporter) Report(_ io.Writer, r *ScanResult) error {
+	if r.HasEndOfLife() {
+		logger.Warn("Found %d images of series past their end of life:", len(r.EndOfLife))
+		for _, notice := range r.EndOfLife {
+			logger.Warn("  %s (service %s): %s", r.RelPath(notice.FilePath), notice.ServiceName, notice.Message())
+		}
+	}
+
+	if !r.HasErrors() {
+		return nil
+	}
+
+	logger.Warn("Encountered %d errors while checking files:", len(r.Errors))
+	for _, fileError := range r.Errors {
+		path := r.RelPath(fileError.FilePath)
+		if fileError.ServiceName != "" {
+			logger.Warn("  %s (service %s): %s", path, fileError.ServiceName, fileError.Error)
+		} else {
+			logger.Warn("  %s: %s", path, fileError.Error)
+		}
+	}
+
+	return nil
+}
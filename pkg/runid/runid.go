@@ -0,0 +1,83 @@
+// Package runid identifies the run of img-upgr, so that the merge requests, commits,
+// logs, audit events and results it produces can be traced back to it. The scans of a
+// server each get their own run ID, carried by their context.
+package runid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// pattern matches the run IDs, safe in logs, commit trailers and markdown
+var pattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+var (
+	// id is the ID of the run, generated once the first time it is needed
+	id string
+	// mu guards id
+	mu sync.Mutex
+)
+
+// ID returns the ID of the run, a random ID unless one was set
+func ID() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if id == "" {
+		id = generate()
+	}
+	return id
+}
+
+// Set replaces the ID of the run, such as with the ID of the CI job running it
+func Set(value string) error {
+	if err := Validate(value); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	id = value
+	return nil
+}
+
+// New returns a new random run ID, for each of the scans of a server
+func New() string {
+	return generate()
+}
+
+// contextKey is the key of the run ID in a context
+type contextKey struct{}
+
+// NewContext returns a context carrying the ID of a run, such as a scan of a server
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the run ID carried by the context, the ID of the process if none
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok {
+		return id
+	}
+	return ID()
+}
+
+// Validate checks that a run ID is made of letters, digits, dots, dashes and underscores
+func Validate(value string) error {
+	if !pattern.MatchString(value) {
+		return fmt.Errorf("invalid run ID %q: use up to 64 letters, digits, dots, dashes and underscores", value)
+	}
+	return nil
+}
+
+// generate returns a random run ID
+func generate() string {
+	// crypto/rand never returns an error
+	data := make([]byte, 8)
+	_, _ = rand.Read(data)
+	return hex.EncodeToString(data)
+}
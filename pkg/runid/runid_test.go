@@ -0,0 +1,39 @@
+package runid
+
+import (
+	"context"
+	"testing"
+)
+
+func TestID(t *testing.T) {
+	generated := ID()
+	if Validate(generated) != nil || ID() != generated {
+		t.Fatalf("ID() = %q, want a valid ID kept for the run", generated)
+	}
+
+	if err := Set("pipeline-1234.5"); err != nil || ID() != "pipeline-1234.5" {
+		t.Errorf("Set() error = %v, ID() = %q, want pipeline-1234.5", err, ID())
+	}
+	for _, invalid := range []string{"", "-leading", "with space", "new\nline"} {
+		if err := Set(invalid); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", invalid)
+		}
+	}
+	if ID() != "pipeline-1234.5" {
+		t.Errorf("ID() = %q after invalid IDs, want it unchanged", ID())
+	}
+}
+
+func TestContext(t *testing.T) {
+	if got := FromContext(context.Background()); got != ID() {
+		t.Errorf("FromContext() = %q without a run ID, want the ID of the process %q", got, ID())
+	}
+
+	first, second := New(), New()
+	if Validate(first) != nil || first == second || first == ID() {
+		t.Fatalf("New() = %q, %q, want distinct valid IDs", first, second)
+	}
+	if got := FromContext(NewContext(context.Background(), first)); got != first {
+		t.Errorf("FromContext() = %q, want %q", got, first)
+	}
+}
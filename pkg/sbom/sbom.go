@@ -0,0 +1,323 @@
+// Package sbom fetches or generates the software bill of materials of images and
+// compares the packages of two images.
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/signature"
+)
+
+const (
+	// DefaultTimeout is the default timeout of fetching or generating an SBOM, syft
+	// pulling the whole image
+	DefaultTimeout = 5 * time.Minute
+
+	// SourceAttestation reads the SBOM attested to the image with cosign, verifying the
+	// attestation when a verifier is configured
+	SourceAttestation = "attestation"
+	// SourceSyft generates the SBOM by scanning the image with syft
+	SourceSyft = "syft"
+	// SourceAuto reads the attested SBOM, generating one with syft when there is none
+	SourceAuto = "auto"
+)
+
+// Sources lists the valid SBOM sources
+var Sources = []string{SourceAttestation, SourceSyft, SourceAuto}
+
+// predicateTypes are the SBOM attestation predicate types tried, in order
+var predicateTypes = []string{"spdxjson", "cyclonedx"}
+
+// Package is a package listed in an SBOM
+type Package struct {
+	Name    string
+	Version string
+	// Type is the ecosystem of the package such as apk, deb or npm, empty if unknown
+	Type string
+}
+
+// key identifies a package independently of its version
+func (p Package) key() string {
+	return p.Type + "/" + p.Name
+}
+
+// Fetcher returns the packages of images from the configured source
+type Fetcher struct {
+	source   string
+	verifier *signature.Verifier
+	timeout  time.Duration
+	run      func(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// NewFetcher creates a fetcher reading SBOMs from the source. Attestations are verified
+// with verifier, and only downloaded when it is nil.
+func NewFetcher(source string, verifier *signature.Verifier) *Fetcher {
+	return &Fetcher{source: source, verifier: verifier, timeout: DefaultTimeout, run: runCommand}
+}
+
+// Packages returns the packages of an image and whether they were read from an
+// attestation verified against the signer of the image
+func (f *Fetcher) Packages(ctx context.Context, image string) ([]Package, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	switch f.source {
+	case SourceSyft:
+		return f.generate(ctx, image)
+	case SourceAttestation:
+		return f.attested(ctx, image)
+	default:
+		packages, verified, err := f.attested(ctx, image)
+		if err == nil {
+			return packages, verified, nil
+		}
+		logger.Debug("No SBOM attested to %s, generating one: %v", image, err)
+		return f.generate(ctx, image)
+	}
+}
+
+// generate scans an image with syft
+func (f *Fetcher) generate(ctx context.Context, image string) ([]Package, bool, error) {
+	output, err := f.run(ctx, "syft", "scan", "--quiet", "--output", "syft-json", image)
+	if err != nil {
+		return nil, false, fmt.Errorf("syft scan of %s failed: %w", image, err)
+	}
+	packages, err := parseSyft(output)
+	return packages, false, err
+}
+
+// attested reads the SBOM attested to an image with cosign, through verify-attestation
+// when a verifier is configured
+func (f *Fetcher) attested(ctx context.Context, image string) ([]Package, bool, error) {
+	var lastErr error
+	for _, predicateType := range predicateTypes {
+		args := []string{"download", "attestation", "--predicate-type", predicateType, image}
+		if f.verifier != nil {
+			args = f.verifier.AttestationArguments(predicateType, image)
+		}
+		output, err := f.run(ctx, signature.Binary, args...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		packages, err := parseAttestations(output)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return packages, f.verifier != nil, nil
+	}
+	return nil, false, fmt.Errorf("no SBOM attestation found for %s: %w", image, lastErr)
+}
+
+// runCommand runs a command and returns its output, or an error with its last error line
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if lines := strings.Split(strings.TrimSpace(stderr.String()), "\n"); lines[len(lines)-1] != "" {
+			return nil, fmt.Errorf("%w: %s", err, lines[len(lines)-1])
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseSyft parses the packages of a syft JSON document
+func parseSyft(data []byte) ([]Package, error) {
+	var document struct {
+		Artifacts []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Type    string `json:"type"`
+		} `json:"artifacts"`
+	}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("JSON parse error: %w", err)
+	}
+
+	packages := make([]Package, 0, len(document.Artifacts))
+	for _, artifact := range document.Artifacts {
+		packages = append(packages, Package{Name: artifact.Name, Version: artifact.Version, Type: artifact.Type})
+	}
+	return packages, nil
+}
+
+// parseAttestations parses the packages of the SPDX or CycloneDX predicate of the first
+// attestation of the DSSE envelopes printed by cosign, one per line
+func parseAttestations(data []byte) ([]Package, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var envelope struct {
+			Payload string `json:"payload"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &envelope); err != nil || envelope.Payload == "" {
+			continue
+		}
+
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid attestation payload: %w", err)
+		}
+
+		var statement struct {
+			Predicate struct {
+				// SPDX packages
+				Packages []struct {
+					Name        string `json:"name"`
+					VersionInfo string `json:"versionInfo"`
+				} `json:"packages"`
+				// CycloneDX components
+				Components []struct {
+					Name    string `json:"name"`
+					Version string `json:"version"`
+					Type    string `json:"type"`
+				} `json:"components"`
+			} `json:"predicate"`
+		}
+		if err := json.Unmarshal(payload, &statement); err != nil {
+			return nil, fmt.Errorf("invalid attestation statement: %w", err)
+		}
+
+		var packages []Package
+		for _, p := range statement.Predicate.Packages {
+			packages = append(packages, Package{Name: p.Name, Version: p.VersionInfo})
+		}
+		for _, c := range statement.Predicate.Components {
+			packages = append(packages, Package{Name: c.Name, Version: c.Version, Type: c.Type})
+		}
+		return packages, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading attestations: %w", err)
+	}
+	return nil, fmt.Errorf("no attestation in cosign output")
+}
+
+// Change is a package whose version differs between two images
+type Change struct {
+	Name string
+	Type string
+	From string
+	To   string
+}
+
+// Diff lists the packages added, removed and changed by a new image
+type Diff struct {
+	Added   []Package
+	Removed []Package
+	Changed []Change
+}
+
+// Empty reports whether both images have the same packages
+func (d *Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Compare returns the differences between the packages of an old and a new image,
+// sorted by name. Packages listed with several versions are compared by their
+// sorted versions.
+func Compare(oldPackages, newPackages []Package) *Diff {
+	oldVersions := versionsByKey(oldPackages)
+	newVersions := versionsByKey(newPackages)
+
+	diff := &Diff{}
+	for key, versions := range newVersions {
+		oldVersion, ok := oldVersions[key]
+		name, packageType := splitKey(key)
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, Package{Name: name, Type: packageType, Version: versions})
+		case oldVersion != versions:
+			diff.Changed = append(diff.Changed, Change{Name: name, Type: packageType, From: oldVersion, To: versions})
+		}
+	}
+	for key, versions := range oldVersions {
+		if _, ok := newVersions[key]; !ok {
+			name, packageType := splitKey(key)
+			diff.Removed = append(diff.Removed, Package{Name: name, Type: packageType, Version: versions})
+		}
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].key() < diff.Added[j].key() })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].key() < diff.Removed[j].key() })
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].Type+"/"+diff.Changed[i].Name < diff.Changed[j].Type+"/"+diff.Changed[j].Name
+	})
+	return diff
+}
+
+// versionsByKey joins the versions of each package
+func versionsByKey(packages []Package) map[string]string {
+	versions := make(map[string][]string)
+	for _, p := range packages {
+		if p.Name == "" {
+			continue
+		}
+		versions[p.key()] = append(versions[p.key()], p.Version)
+	}
+
+	joined := make(map[string]string, len(versions))
+	for key, list := range versions {
+		sort.Strings(list)
+		joined[key] = strings.Join(list, ", ")
+	}
+	return joined
+}
+
+// splitKey returns the name and type of a package key
+func splitKey(key string) (string, string) {
+	packageType, name, _ := strings.Cut(key, "/")
+	return name, packageType
+}
+
+// Format renders a diff as Markdown lists, listing at most maxItems packages
+func Format(diff *Diff, maxItems int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d added, %d removed, %d changed packages\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+
+	listed := 0
+	write := func(line string) {
+		if listed < maxItems {
+			sb.WriteString(line)
+		}
+		listed++
+	}
+
+	if len(diff.Changed) > 0 {
+		sb.WriteString("\n**Changed:**\n\n")
+		for _, change := range diff.Changed {
+			write(fmt.Sprintf("- `%s` %s → %s\n", change.Name, change.From, change.To))
+		}
+	}
+	if len(diff.Added) > 0 {
+		sb.WriteString("\n**Added:**\n\n")
+		for _, p := range diff.Added {
+			write(fmt.Sprintf("- `%s` %s\n", p.Name, p.Version))
+		}
+	}
+	if len(diff.Removed) > 0 {
+		sb.WriteString("\n**Removed:**\n\n")
+		for _, p := range diff.Removed {
+			write(fmt.Sprintf("- `%s` %s\n", p.Name, p.Version))
+		}
+	}
+
+	if listed > maxItems {
+		fmt.Fprintf(&sb, "\n*%d more packages not listed*\n", listed-maxItems)
+	}
+	return sb.String()
+}
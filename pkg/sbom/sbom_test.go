@@ -0,0 +1,117 @@
+package sbom
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/signature"
+)
+
+func TestCompare(t *testing.T) {
+	oldPackages := []Package{
+		{Name: "openssl", Version: "3.1.4", Type: "apk"},
+		{Name: "busybox", Version: "1.36.1", Type: "apk"},
+		{Name: "zlib", Version: "1.3", Type: "apk"},
+	}
+	newPackages := []Package{
+		{Name: "openssl", Version: "3.1.5", Type: "apk"},
+		{Name: "busybox", Version: "1.36.1", Type: "apk"},
+		{Name: "curl", Version: "8.5.0", Type: "apk"},
+	}
+
+	diff := Compare(oldPackages, newPackages)
+	expected := &Diff{
+		Added:   []Package{{Name: "curl", Version: "8.5.0", Type: "apk"}},
+		Removed: []Package{{Name: "zlib", Version: "1.3", Type: "apk"}},
+		Changed: []Change{{Name: "openssl", Type: "apk", From: "3.1.4", To: "3.1.5"}},
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("Compare() = %+v, want %+v", diff, expected)
+	}
+
+	if !Compare(oldPackages, oldPackages).Empty() {
+		t.Error("Compare() of the same packages is not empty")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	diff := &Diff{
+		Added:   []Package{{Name: "curl", Version: "8.5.0"}, {Name: "jq", Version: "1.7"}},
+		Changed: []Change{{Name: "openssl", From: "3.1.4", To: "3.1.5"}},
+	}
+
+	formatted := Format(diff, 2)
+	for _, expected := range []string{"2 added, 0 removed, 1 changed packages", "- `openssl` 3.1.4 → 3.1.5", "- `curl` 8.5.0", "*1 more packages not listed*"} {
+		if !strings.Contains(formatted, expected) {
+			t.Errorf("Format() = %q, missing %q", formatted, expected)
+		}
+	}
+	if strings.Contains(formatted, "jq") {
+		t.Errorf("Format() = %q, lists more than 2 packages", formatted)
+	}
+}
+
+func TestPackages(t *testing.T) {
+	statement := `{"predicateType":"https://spdx.dev/Document","predicate":{"packages":[{"name":"openssl","versionInfo":"3.1.5"}]}}`
+	envelope := `{"payloadType":"application/vnd.in-toto+json","payload":"` + base64.StdEncoding.EncodeToString([]byte(statement)) + `"}` + "\n"
+	syft := `{"artifacts":[{"name":"curl","version":"8.5.0","type":"apk"}]}`
+
+	// Only the attested image has an SBOM attestation, which only verifies against
+	// cosign.pub, and syft scans any image
+	run := func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		image := args[len(args)-1]
+		switch {
+		case name == "cosign" && image == "app:attested" && args[0] == "download":
+			return []byte(envelope), nil
+		case name == "cosign" && image == "app:attested" && args[0] == "verify-attestation":
+			if !strings.Contains(strings.Join(args, " "), "--key cosign.pub") {
+				return nil, errors.New("no matching attestations")
+			}
+			return []byte(envelope), nil
+		case name == "syft":
+			return []byte(syft), nil
+		}
+		return nil, errors.New("no attestations found")
+	}
+	attested := []Package{{Name: "openssl", Version: "3.1.5"}}
+	generated := []Package{{Name: "curl", Version: "8.5.0", Type: "apk"}}
+
+	testCases := []struct {
+		name         string
+		source       string
+		verifier     *signature.Verifier
+		image        string
+		expected     []Package
+		wantVerified bool
+		wantErr      bool
+	}{
+		{name: "attestation", source: SourceAttestation, image: "app:attested", expected: attested},
+		{name: "verified attestation", source: SourceAttestation, verifier: signature.NewVerifier("cosign.pub", "", "", ""), image: "app:attested", expected: attested, wantVerified: true},
+		{name: "attestation of another signer", source: SourceAttestation, verifier: signature.NewVerifier("other.pub", "", "", ""), image: "app:attested", wantErr: true},
+		{name: "missing attestation", source: SourceAttestation, image: "app:plain", wantErr: true},
+		{name: "syft", source: SourceSyft, verifier: signature.NewVerifier("cosign.pub", "", "", ""), image: "app:attested", expected: generated},
+		{name: "auto falls back to syft", source: SourceAuto, verifier: signature.NewVerifier("cosign.pub", "", "", ""), image: "app:plain", expected: generated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fetcher := NewFetcher(tc.source, tc.verifier)
+			fetcher.run = run
+
+			packages, verified, err := fetcher.Packages(context.Background(), tc.image)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Packages() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(packages, tc.expected) {
+				t.Errorf("Packages() = %+v, want %+v", packages, tc.expected)
+			}
+			if verified != tc.wantVerified {
+				t.Errorf("Packages() verified = %v, want %v", verified, tc.wantVerified)
+			}
+		})
+	}
+}
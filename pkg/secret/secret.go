@@ -0,0 +1,240 @@
+// Package secret resolves settings referencing a secret stored in HashiCorp Vault or in
+// a Kubernetes secret, so tokens are not passed as plain environment variables.
+package secret
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+const (
+	// PrefixVault starts a reference to a field of a Vault secret, such as
+	// vault:secret/data/img-upgr#gl_token
+	PrefixVault = "vault:"
+	// PrefixKubernetes starts a reference to a key of a Kubernetes secret, such as
+	// k8s:ci/img-upgr#gl-token, the namespace of the pod being used when omitted
+	PrefixKubernetes = "k8s:"
+
+	// DefaultTimeout is the timeout of resolving a secret
+	DefaultTimeout = 10 * time.Second
+
+	// serviceAccountDir holds the credentials of the service account of a pod
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// IsReference reports whether a value references a secret instead of holding it
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, PrefixVault) || strings.HasPrefix(value, PrefixKubernetes)
+}
+
+// Resolver reads the secrets referenced by settings
+type Resolver struct {
+	httpClient *http.Client
+
+	// Vault address and token, read from VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE
+	// like the vault CLI does
+	vaultAddr      string
+	vaultToken     string
+	vaultNamespace string
+
+	// Kubernetes API server and service account directory of the pod
+	kubeURL string
+	kubeDir string
+}
+
+// NewResolver creates a resolver configured from the environment
+func NewResolver() *Resolver {
+	r := &Resolver{
+		httpClient:     &http.Client{Timeout: DefaultTimeout},
+		vaultAddr:      strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"),
+		vaultToken:     os.Getenv("VAULT_TOKEN"),
+		vaultNamespace: os.Getenv("VAULT_NAMESPACE"),
+		kubeDir:        serviceAccountDir,
+	}
+	if host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"); host != "" && port != "" {
+		r.kubeURL = "https://" + net.JoinHostPort(host, port)
+	}
+	return r
+}
+
+// Resolve returns the secret a value references, or the value itself when it is not a reference
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, PrefixVault):
+		return r.vault(ctx, strings.TrimPrefix(value, PrefixVault))
+	case strings.HasPrefix(value, PrefixKubernetes):
+		return r.kubernetes(ctx, strings.TrimPrefix(value, PrefixKubernetes))
+	}
+	return value, nil
+}
+
+// splitReference splits a reference into the path of the secret and the field to read
+func splitReference(reference string) (string, string, error) {
+	secretPath, field, found := strings.Cut(reference, "#")
+	if !found || secretPath == "" || field == "" {
+		return "", "", fmt.Errorf("invalid secret reference %q, expected path#field", reference)
+	}
+	return strings.Trim(secretPath, "/"), field, nil
+}
+
+// vault reads a field of a Vault secret, from the KV version 2 or version 1 engine
+func (r *Resolver) vault(ctx context.Context, reference string) (string, error) {
+	secretPath, field, err := splitReference(reference)
+	if err != nil {
+		return "", err
+	}
+	if r.vaultAddr == "" {
+		return "", fmt.Errorf("VAULT_ADDR must be set to read %s%s", PrefixVault, reference)
+	}
+
+	token := r.vaultToken
+	if token == "" {
+		// The vault CLI stores the token of vault login in ~/.vault-token
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			if data, err := os.ReadFile(filepath.Join(homeDir, ".vault-token")); err == nil {
+				token = strings.TrimSpace(string(data))
+			}
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("VAULT_TOKEN must be set to read %s%s", PrefixVault, reference)
+	}
+
+	headers := map[string]string{"X-Vault-Token": token}
+	if r.vaultNamespace != "" {
+		headers["X-Vault-Namespace"] = r.vaultNamespace
+	}
+
+	var response struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := r.get(ctx, r.vaultAddr+"/v1/"+secretPath, headers, &response); err != nil {
+		return "", fmt.Errorf("failed to read Vault secret %s: %w", secretPath, err)
+	}
+
+	// KV version 2 secrets nest their fields in data.data
+	fields := response.Data
+	if nested, ok := response.Data["data"]; ok {
+		var data map[string]json.RawMessage
+		if err := json.Unmarshal(nested, &data); err == nil {
+			fields = data
+		}
+	}
+
+	var value string
+	if err := json.Unmarshal(fields[field], &value); err != nil {
+		return "", fmt.Errorf("no string field %s in Vault secret %s", field, secretPath)
+	}
+	logger.Debug("Read field %s of Vault secret %s", field, secretPath)
+	return value, nil
+}
+
+// kubernetes reads a key of a Kubernetes secret with the service account of the pod
+func (r *Resolver) kubernetes(ctx context.Context, reference string) (string, error) {
+	secretPath, key, err := splitReference(reference)
+	if err != nil {
+		return "", err
+	}
+	if r.kubeURL == "" {
+		return "", fmt.Errorf("%s%s can only be read in a Kubernetes pod", PrefixKubernetes, reference)
+	}
+
+	namespace, name, found := strings.Cut(secretPath, "/")
+	if !found {
+		name = namespace
+		data, err := os.ReadFile(filepath.Join(r.kubeDir, "namespace"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read the namespace of the pod: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	token, err := os.ReadFile(filepath.Join(r.kubeDir, "token"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	if err := r.trustClusterCA(); err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Data map[string]string `json:"data"`
+	}
+	headers := map[string]string{"Authorization": "Bearer " + strings.TrimSpace(string(token))}
+	secretURL := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", r.kubeURL, url.PathEscape(namespace), url.PathEscape(name))
+	if err := r.get(ctx, secretURL, headers, &response); err != nil {
+		return "", fmt.Errorf("failed to read Kubernetes secret %s/%s: %w", namespace, name, err)
+	}
+
+	encoded, ok := response.Data[key]
+	if !ok {
+		return "", fmt.Errorf("no key %s in Kubernetes secret %s/%s", key, namespace, name)
+	}
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid value of key %s in Kubernetes secret %s/%s: %w", key, namespace, name, err)
+	}
+	logger.Debug("Read key %s of Kubernetes secret %s/%s", key, namespace, name)
+	return string(value), nil
+}
+
+// trustClusterCA makes the HTTP client trust the certificate authority of the cluster,
+// which signs the certificate of the API server
+func (r *Resolver) trustClusterCA() error {
+	data, err := os.ReadFile(filepath.Join(r.kubeDir, "ca.crt"))
+	if err != nil {
+		// The API server may have a publicly trusted certificate
+		return nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("invalid cluster certificate authority in %s", filepath.Join(r.kubeDir, "ca.crt"))
+	}
+	r.httpClient = &http.Client{
+		Timeout:   r.httpClient.Timeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	return nil
+}
+
+// get sends a GET request and decodes its JSON response
+func (r *Resolver) get(ctx context.Context, requestURL string, headers map[string]string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
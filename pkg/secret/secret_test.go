@@ -0,0 +1,97 @@
+package secret
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/img-upgr":
+			w.Write([]byte(`{"data":{"data":{"gl_token":"glpat-v2"},"metadata":{"version":3}}}`))
+		case "/v1/kv/img-upgr":
+			w.Write([]byte(`{"data":{"gl_token":"glpat-v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	resolver := &Resolver{httpClient: server.Client(), vaultAddr: server.URL, vaultToken: "root"}
+	testCases := []struct {
+		reference string
+		expected  string
+		wantErr   bool
+	}{
+		{"vault:secret/data/img-upgr#gl_token", "glpat-v2", false},
+		{"vault:kv/img-upgr#gl_token", "glpat-v1", false},
+		{"vault:secret/data/img-upgr#missing", "", true},
+		{"vault:secret/data/other#gl_token", "", true},
+		{"vault:secret/data/img-upgr", "", true},
+		{"plain-token", "plain-token", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.reference, func(t *testing.T) {
+			value, err := resolver.Resolve(context.Background(), tc.reference)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if value != tc.expected {
+				t.Errorf("Resolve() = %q, want %q", value, tc.expected)
+			}
+		})
+	}
+}
+
+func TestResolveKubernetes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/ci/secrets/img-upgr", "/api/v1/namespaces/runners/secrets/img-upgr":
+			// glpat-k8s encoded in base64
+			w.Write([]byte(`{"data":{"gl-token":"Z2xwYXQtazhz"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "token"), []byte("sa-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "namespace"), []byte("runners"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &Resolver{httpClient: server.Client(), kubeURL: server.URL, kubeDir: dir}
+	for _, reference := range []string{"k8s:ci/img-upgr#gl-token", "k8s:img-upgr#gl-token"} {
+		value, err := resolver.Resolve(context.Background(), reference)
+		if err != nil {
+			t.Fatalf("Resolve(%q) error = %v", reference, err)
+		}
+		if value != "glpat-k8s" {
+			t.Errorf("Resolve(%q) = %q, want glpat-k8s", reference, value)
+		}
+	}
+
+	if _, err := resolver.Resolve(context.Background(), "k8s:ci/img-upgr#missing"); err == nil {
+		t.Error("Resolve() accepted a missing key")
+	}
+	if _, err := (&Resolver{}).Resolve(context.Background(), "k8s:ci/img-upgr#gl-token"); err == nil {
+		t.Error("Resolve() accepted a Kubernetes reference outside a pod")
+	}
+}
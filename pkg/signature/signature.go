@@ -0,0 +1,117 @@
+// Package signature verifies the cosign signatures and attestations of images
+// before updates to them are suggested, using the cosign command line.
+package signature
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+)
+
+const (
+	// DefaultTimeout is the default timeout of a verification, which may download
+	// transparency log entries and certificates
+	DefaultTimeout = 60 * time.Second
+
+	// Binary is the cosign command run to verify images
+	Binary = "cosign"
+)
+
+// Verifier checks images against a public key or a keyless identity
+type Verifier struct {
+	// Key is the public key reference, a file path or any key URI supported by cosign
+	Key string
+	// Identity and Issuer are the certificate identity and OIDC issuer of keyless signatures
+	Identity string
+	Issuer   string
+	// Attestation is the predicate type of the attestation to verify, such as slsaprovenance,
+	// only the signature being verified when empty
+	Attestation string
+
+	binary  string
+	timeout time.Duration
+}
+
+// NewVerifier creates a verifier of signatures made with a key, or keyless signatures of
+// an identity when key is empty
+func NewVerifier(key, identity, issuer, attestation string) *Verifier {
+	return &Verifier{
+		Key:         key,
+		Identity:    identity,
+		Issuer:      issuer,
+		Attestation: attestation,
+		binary:      Binary,
+		timeout:     DefaultTimeout,
+	}
+}
+
+// Verify checks the signature, or the attestation, of an image and returns a description
+// of what was verified, or an error with the reason given by cosign
+func (v *Verifier) Verify(ctx context.Context, image string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	logger.Debug("Verifying %s with cosign", image)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, v.binary, v.arguments(image)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("verification of %s timed out after %s", image, v.timeout)
+		}
+		if reason := lastLine(stderr.String()); reason != "" {
+			return "", fmt.Errorf("verification of %s failed: %s", image, reason)
+		}
+		return "", fmt.Errorf("verification of %s failed: %w", image, err)
+	}
+
+	return v.describe(), nil
+}
+
+// arguments returns the cosign arguments verifying an image
+func (v *Verifier) arguments(image string) []string {
+	if v.Attestation != "" {
+		return v.AttestationArguments(v.Attestation, image)
+	}
+	return v.withTrust([]string{"verify"}, image)
+}
+
+// AttestationArguments returns the cosign arguments verifying the attestation of a
+// predicate type and printing its envelopes, whatever the configured attestation
+func (v *Verifier) AttestationArguments(predicateType, image string) []string {
+	return v.withTrust([]string{"verify-attestation", "--type", predicateType}, image)
+}
+
+// withTrust appends the key or keyless identity to the arguments of a cosign command
+func (v *Verifier) withTrust(args []string, image string) []string {
+	if v.Key != "" {
+		args = append(args, "--key", v.Key)
+	} else {
+		args = append(args, "--certificate-identity", v.Identity, "--certificate-oidc-issuer", v.Issuer)
+	}
+
+	return append(args, "--output", "json", image)
+}
+
+// describe returns what a successful verification proved
+func (v *Verifier) describe() string {
+	what := "signature"
+	if v.Attestation != "" {
+		what = v.Attestation + " attestation"
+	}
+	if v.Key != "" {
+		return fmt.Sprintf("%s verified with key %s", what, v.Key)
+	}
+	return fmt.Sprintf("%s verified for identity %s issued by %s", what, v.Identity, v.Issuer)
+}
+
+// lastLine returns the last non-empty line of the output of cosign, holding the error
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(strings.TrimPrefix(lines[len(lines)-1], "Error: "))
+}
@@ -0,0 +1,73 @@
+package signature
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestArguments(t *testing.T) {
+	testCases := []struct {
+		name     string
+		verifier *Verifier
+		expected []string
+	}{
+		{
+			name:     "key",
+			verifier: NewVerifier("cosign.pub", "", "", ""),
+			expected: []string{"verify", "--key", "cosign.pub", "--output", "json", "app:1.2.0"},
+		},
+		{
+			name:     "keyless attestation",
+			verifier: NewVerifier("", "https://github.com/org/app/.github/workflows/release.yml@refs/heads/main", "https://token.actions.githubusercontent.com", "slsaprovenance"),
+			expected: []string{"verify-attestation", "--type", "slsaprovenance",
+				"--certificate-identity", "https://github.com/org/app/.github/workflows/release.yml@refs/heads/main",
+				"--certificate-oidc-issuer", "https://token.actions.githubusercontent.com", "--output", "json", "app:1.2.0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.verifier.arguments("app:1.2.0"); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("arguments() = %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestAttestationArguments(t *testing.T) {
+	// The predicate type asked for wins over the configured attestation
+	verifier := NewVerifier("cosign.pub", "", "", "slsaprovenance")
+	expected := []string{"verify-attestation", "--type", "spdxjson", "--key", "cosign.pub", "--output", "json", "app:1.2.0"}
+	if got := verifier.AttestationArguments("spdxjson", "app:1.2.0"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("AttestationArguments() = %v, want %v", got, expected)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	// A fake cosign accepting only the signed tag
+	binary := filepath.Join(t.TempDir(), "cosign")
+	script := "#!/bin/sh\nfor arg; do last=$arg; done\n" +
+		"[ \"$last\" = app:signed ] && exit 0\necho 'Error: no matching signatures' >&2\nexit 1\n"
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewVerifier("cosign.pub", "", "", "")
+	verifier.binary = binary
+
+	description, err := verifier.Verify(context.Background(), "app:signed")
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if description != "signature verified with key cosign.pub" {
+		t.Errorf("Verify() = %q", description)
+	}
+
+	if _, err := verifier.Verify(context.Background(), "app:unsigned"); err == nil || !strings.Contains(err.Error(), "no matching signatures") {
+		t.Errorf("Verify() error = %v, want the reason given by cosign", err)
+	}
+}
@@ -0,0 +1,225 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// FormatVersion is the version of the state file format
+const FormatVersion = 1
+
+// Entry records what was last seen in the registry for an image reference
+// and where the reference is used
+type Entry struct {
+	LatestTag string    `json:"latest_tag,omitempty"`
+	Digest    string    `json:"digest,omitempty"`
+	SeenAt    time.Time `json:"seen_at"`
+	Usages    []Usage   `json:"usages,omitempty"`
+}
+
+// Usage locates a service using an image reference
+type Usage struct {
+	Repository string `json:"repository"`
+	File       string `json:"file"`
+	Service    string `json:"service"`
+}
+
+// State holds the entries recorded by previous runs, keyed by image reference.
+// A nil State is valid and records nothing.
+type State struct {
+	Version int              `json:"version"`
+	Images  map[string]Entry `json:"images"`
+
+	// recorded are the usages recorded since the state was loaded
+	recorded map[Usage]bool
+	mu       sync.Mutex
+}
+
+// Store loads and saves the state between runs
+type Store interface {
+	Load(ctx context.Context) (*State, error)
+	Save(ctx context.Context, st *State) error
+}
+
+// New creates an empty state
+func New() *State {
+	return &State{
+		Version: FormatVersion,
+		Images:  make(map[string]Entry),
+	}
+}
+
+// Get returns the entry recorded for an image reference
+func (s *State) Get(image string) (Entry, bool) {
+	if s == nil {
+		return Entry{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.Images[image]
+	return entry, ok
+}
+
+// RecordLatestTag records the latest matching tag seen for an image reference
+func (s *State) RecordLatestTag(image, latestTag string) {
+	s.update(image, func(entry *Entry) {
+		entry.LatestTag = latestTag
+	})
+}
+
+// RecordDigest records the digest currently published for an image reference
+func (s *State) RecordDigest(image, digest string) {
+	s.update(image, func(entry *Entry) {
+		entry.Digest = digest
+	})
+}
+
+// RecordUsage records a service using an image reference
+func (s *State) RecordUsage(image string, usage Usage) {
+	s.update(image, func(entry *Entry) {
+		if s.recorded == nil {
+			s.recorded = make(map[Usage]bool)
+		}
+		s.recorded[usage] = true
+
+		for _, existing := range entry.Usages {
+			if existing == usage {
+				return
+			}
+		}
+		entry.Usages = append(entry.Usages, usage)
+	})
+}
+
+// Usages returns the usages of the image references matching a predicate
+func (s *State) Usages(match func(image string) bool) []Usage {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var usages []Usage
+	for image, entry := range s.Images {
+		if match(image) {
+			usages = append(usages, entry.Usages...)
+		}
+	}
+	return usages
+}
+
+// PruneUsages removes the usages of a repository that were not recorded since the state
+// was loaded and that stale reports as no longer current, such as those of files scanned
+// again or deleted
+func (s *State) PruneUsages(repository string, stale func(usage Usage) bool) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for image, entry := range s.Images {
+		usages := slices.DeleteFunc(slices.Clone(entry.Usages), func(usage Usage) bool {
+			return usage.Repository == repository && !s.recorded[usage] && stale(usage)
+		})
+		if len(usages) != len(entry.Usages) {
+			entry.Usages = usages
+			s.Images[image] = entry
+		}
+	}
+}
+
+// update applies a change to the entry of an image reference
+func (s *State) update(image string, change func(entry *Entry)) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.Images[image]
+	change(&entry)
+	entry.SeenAt = time.Now().UTC()
+	s.Images[image] = entry
+}
+
+// Marshal encodes the state as indented JSON
+func (s *State) Marshal() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode state: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Unmarshal decodes a state from JSON, returning an empty state for empty input
+func Unmarshal(data []byte) (*State, error) {
+	st := New()
+	if len(data) == 0 {
+		return st, nil
+	}
+
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to parse state: %w", err)
+	}
+
+	if st.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported state version %d (expected %d)", st.Version, FormatVersion)
+	}
+
+	if st.Images == nil {
+		st.Images = make(map[string]Entry)
+	}
+
+	return st, nil
+}
+
+// FileStore keeps the state in a local JSON file
+type FileStore struct {
+	path string
+}
+
+// NewFileStore creates a store backed by the file at path
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Load reads the state file, returning an empty state if it does not exist yet
+func (f *FileStore) Load(ctx context.Context) (*State, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	return Unmarshal(data)
+}
+
+// Save writes the state file
+func (f *FileStore) Save(ctx context.Context, st *State) error {
+	data, err := st.Marshal()
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(f.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileStore(filepath.Join(t.TempDir(), "state.json"))
+
+	// A missing file is an empty state
+	st, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() without file error = %v", err)
+	}
+	if len(st.Images) != 0 || st.Version != FormatVersion {
+		t.Errorf("Load() without file = %+v, want an empty state", st)
+	}
+
+	st.RecordLatestTag("nginx:1.25", "1.27.0")
+	st.RecordDigest("nginx:1.25", "sha256:abc")
+	st.RecordUsage("nginx:1.25", Usage{Repository: "https://gitlab.example.com/group/project", File: "compose.yml", Service: "web"})
+	st.RecordUsage("nginx:1.25", Usage{Repository: "https://gitlab.example.com/group/project", File: "compose.yml", Service: "web"})
+	st.RecordDigest("redis:7", "sha256:def")
+	if err := store.Save(ctx, st); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := store.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	// Times are read back with the precision they are written with
+	for image, entry := range st.Images {
+		got := loaded.Images[image]
+		if !got.SeenAt.Equal(entry.SeenAt) {
+			t.Errorf("SeenAt of %s = %v, want %v", image, got.SeenAt, entry.SeenAt)
+		}
+		got.SeenAt = entry.SeenAt
+		if !reflect.DeepEqual(got, entry) {
+			t.Errorf("entry of %s = %+v, want %+v", image, got, entry)
+		}
+	}
+	if len(loaded.Images) != len(st.Images) {
+		t.Errorf("Load() = %d images, want %d", len(loaded.Images), len(st.Images))
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		images  int
+		wantErr string
+	}{
+		{"empty", "", 0, ""},
+		{"no images", `{"version": 1}`, 0, ""},
+		{"images", `{"version": 1, "images": {"nginx:1.25": {"latest_tag": "1.27.0", "seen_at": "2026-03-01T12:00:00Z"}}}`, 1, ""},
+		{"other version", `{"version": 2, "images": {}}`, 0, "unsupported state version 2"},
+		{"invalid", `{"version": `, 0, "failed to parse state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := Unmarshal([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Unmarshal() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if st.Images == nil || len(st.Images) != tt.images {
+				t.Errorf("Unmarshal() images = %v, want %d", st.Images, tt.images)
+			}
+		})
+	}
+
+	// Unreadable files are reported
+	dir := t.TempDir()
+	if _, err := NewFileStore(dir).Load(context.Background()); err == nil {
+		t.Error("Load() of a directory succeeded")
+	}
+	if err := os.WriteFile(filepath.Join(dir, "state.json"), []byte(`{"version": 0}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(filepath.Join(dir, "state.json")).Load(context.Background()); err == nil {
+		t.Error("Load() of a state without version succeeded")
+	}
+}
+
+func TestUsages(t *testing.T) {
+	repo := "https://gitlab.example.com/group/project"
+	web := Usage{Repository: repo, File: "compose.yml", Service: "web"}
+	proxy := Usage{Repository: repo, File: "infra/compose.yml", Service: "proxy"}
+	cache := Usage{Repository: repo, File: "compose.yml", Service: "cache"}
+
+	st := New()
+	st.RecordUsage("nginx:1.25", web)
+	st.RecordUsage("docker.io/library/nginx:1.24", proxy)
+	st.RecordUsage("redis:7", cache)
+	st.RecordDigest("postgres:16", "sha256:abc")
+
+	// Usages survive a round trip
+	data, err := st.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, err = Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+
+	usages := st.Usages(func(image string) bool { return strings.Contains(image, "nginx") })
+	slices.SortFunc(usages, func(a, b Usage) int { return strings.Compare(a.File, b.File) })
+	if want := []Usage{web, proxy}; !reflect.DeepEqual(usages, want) {
+		t.Errorf("Usages(nginx) = %+v, want %+v", usages, want)
+	}
+	if usages := st.Usages(func(image string) bool { return image == "postgres:16" }); len(usages) != 0 {
+		t.Errorf("Usages(postgres) = %+v, want none", usages)
+	}
+
+	// A nil state has no usages
+	var empty *State
+	if usages := empty.Usages(func(string) bool { return true }); usages != nil {
+		t.Errorf("Usages() of a nil state = %+v", usages)
+	}
+}
+
+func TestPruneUsages(t *testing.T) {
+	repo := "https://gitlab.example.com/group/project"
+	other := "https://gitlab.example.com/group/other"
+	web := Usage{Repository: repo, File: "compose.yml", Service: "web"}
+	moved := Usage{Repository: repo, File: "compose.yml", Service: "proxy"}
+	deleted := Usage{Repository: repo, File: "old/compose.yml", Service: "web"}
+	unscanned := Usage{Repository: repo, File: "infra/compose.yml", Service: "web"}
+	elsewhere := Usage{Repository: other, File: "compose.yml", Service: "web"}
+
+	st := New()
+	for _, usage := range []Usage{web, moved, deleted, unscanned, elsewhere} {
+		st.RecordUsage("nginx:1.25", usage)
+	}
+
+	// The next run loads the state and finds web again only
+	data, err := st.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err = Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.RecordUsage("nginx:1.25", web)
+
+	st.PruneUsages(repo, func(usage Usage) bool {
+		return usage.File == "compose.yml" || usage.File == "old/compose.yml"
+	})
+
+	entry, _ := st.Get("nginx:1.25")
+	if want := []Usage{web, unscanned, elsewhere}; !reflect.DeepEqual(entry.Usages, want) {
+		t.Errorf("usages = %+v, want %+v", entry.Usages, want)
+	}
+
+	// A nil state records nothing
+	var empty *State
+	empty.PruneUsages(repo, func(Usage) bool { return true })
+}
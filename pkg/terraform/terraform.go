@@ -0,0 +1,210 @@
+package terraform
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
+)
+
+// Kind is the update kind of image references found in Terraform files
+const Kind = "terraform"
+
+var (
+	// blockPattern matches a block header such as `resource "docker_image" "app" {`
+	blockPattern = regexp.MustCompile(`^\s*([A-Za-z_][\w-]*)((?:\s+(?:"[^"]*"|[A-Za-z_][\w-]*))*)\s*\{`)
+
+	// labelPattern matches the labels of a block header
+	labelPattern = regexp.MustCompile(`"([^"]*)"|([A-Za-z_][\w-]*)`)
+
+	// attributePattern matches an attribute with a literal string value
+	attributePattern = regexp.MustCompile(`^\s*([A-Za-z_][\w-]*)\s*=\s*"([^"]*)"`)
+
+	// heredocPattern matches the start of a heredoc string
+	heredocPattern = regexp.MustCompile(`<<-?\s*([A-Za-z_]\w*)\s*$`)
+)
+
+// Reference is an image referenced by a literal string in a Terraform file
+type Reference struct {
+	// ID is the resource address, with the container block for Kubernetes resources
+	ID    string
+	Image string
+	Line  int
+}
+
+// block is an open HCL block
+type block struct {
+	kind       string
+	labels     []string
+	containers map[string]int
+}
+
+// ParseFile finds the images of docker_image and docker_container resources and of
+// the container blocks of kubernetes provider resources
+func ParseFile(filename string) ([]Reference, error) {
+	data, err := repofs.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return parse(data)
+}
+
+// parse scans HCL content line by line, tracking the enclosing blocks
+func parse(data []byte) ([]Reference, error) {
+	var references []Reference
+	var stack []*block
+	var heredoc string
+	inComment := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+
+		// Skip heredoc bodies and block comments
+		if heredoc != "" {
+			if strings.TrimSpace(line) == heredoc {
+				heredoc = ""
+			}
+			continue
+		}
+		line, inComment = stripComments(line, inComment)
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if matches := heredocPattern.FindStringSubmatch(line); matches != nil {
+			heredoc = matches[1]
+		}
+
+		if matches := blockPattern.FindStringSubmatch(line); matches != nil {
+			current := &block{kind: matches[1], containers: make(map[string]int)}
+			for _, label := range labelPattern.FindAllStringSubmatch(matches[2], -1) {
+				current.labels = append(current.labels, label[1]+label[2])
+			}
+
+			// Container blocks are numbered within their resource
+			if resource := enclosingResource(stack); resource != nil && (current.kind == "container" || current.kind == "init_container") {
+				current.labels = []string{fmt.Sprintf("%s[%d]", current.kind, resource.containers[current.kind])}
+				resource.containers[current.kind]++
+			}
+			stack = append(stack, current)
+		} else if matches := attributePattern.FindStringSubmatch(line); matches != nil && len(stack) > 0 {
+			if id, ok := imageAttributeID(stack, matches[1]); ok && !strings.Contains(matches[2], "${") {
+				references = append(references, Reference{ID: id, Image: matches[2], Line: lineNumber})
+			}
+		}
+
+		// Balance the braces of the line: blocks closed on the same line are popped and
+		// other braces, such as map or object values, are tracked as anonymous blocks
+		depth := strings.Count(line, "{") - strings.Count(line, "}")
+		if blockPattern.MatchString(line) {
+			depth--
+		}
+		for ; depth > 0; depth-- {
+			stack = append(stack, &block{})
+		}
+		for ; depth < 0 && len(stack) > 0; depth++ {
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read Terraform file: %w", err)
+	}
+
+	return references, nil
+}
+
+// imageAttributeID returns the reference ID when an attribute of the innermost block holds an image
+func imageAttributeID(stack []*block, attribute string) (string, bool) {
+	current := stack[len(stack)-1]
+	resource := enclosingResource(stack)
+	if resource == nil {
+		return "", false
+	}
+	address := resource.labels[0] + "." + resource.labels[1]
+
+	switch {
+	case current == resource && resource.labels[0] == "docker_image" && attribute == "name":
+		return address, true
+	case current == resource && resource.labels[0] == "docker_container" && attribute == "image":
+		return address, true
+	case (current.kind == "container" || current.kind == "init_container") &&
+		strings.HasPrefix(resource.labels[0], "kubernetes_") && attribute == "image":
+		return address + "." + current.labels[0], true
+	}
+	return "", false
+}
+
+// enclosingResource returns the resource block enclosing the current position
+func enclosingResource(stack []*block) *block {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].kind == "resource" && len(stack[i].labels) == 2 {
+			return stack[i]
+		}
+	}
+	return nil
+}
+
+// stripComments removes comments from a line, outside of string literals
+func stripComments(line string, inComment bool) (string, bool) {
+	var result strings.Builder
+	inString := false
+
+	for i := 0; i < len(line); i++ {
+		if inComment {
+			if strings.HasPrefix(line[i:], "*/") {
+				inComment = false
+				i++
+			}
+			continue
+		}
+
+		c := line[i]
+		switch {
+		case inString && c == '\\' && i+1 < len(line):
+			result.WriteByte(c)
+			i++
+			c = line[i]
+		case c == '"':
+			inString = !inString
+		case !inString && (c == '#' || strings.HasPrefix(line[i:], "//")):
+			return result.String(), false
+		case !inString && strings.HasPrefix(line[i:], "/*"):
+			inComment = true
+			i++
+			continue
+		}
+		result.WriteByte(c)
+	}
+
+	return result.String(), inComment
+}
+
+// ReplaceReference returns Terraform content with the image of a reference replaced
+func ReplaceReference(data []byte, id, oldImage, newImage string) ([]byte, error) {
+	references, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range references {
+		if ref.ID != id {
+			continue
+		}
+		if ref.Image != oldImage {
+			return nil, fmt.Errorf("%s uses image %s, expected %s", id, ref.Image, oldImage)
+		}
+
+		lines := strings.SplitAfter(string(data), "\n")
+		lines[ref.Line-1] = strings.Replace(lines[ref.Line-1], `"`+oldImage+`"`, `"`+newImage+`"`, 1)
+		return []byte(strings.Join(lines, "")), nil
+	}
+
+	return nil, fmt.Errorf("%s not found", id)
+}
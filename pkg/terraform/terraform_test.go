@@ -0,0 +1,104 @@
+package terraform
+
+import (
+	"strings"
+	"testing"
+)
+
+const config = `# Images managed by Terraform
+resource "docker_image" "nginx" {
+  name = "nginx:1.25.3" # pinned
+  keep_locally = false
+}
+
+resource "docker_container" "web" {
+  name  = "web"
+  image = docker_image.nginx.image_id
+  labels {
+    label = "app"
+    value = "web"
+  }
+}
+
+resource "docker_container" "cache" {
+  image = "redis:7.2.0"
+  /* image = "redis:6.0.0" */
+}
+
+resource "kubernetes_deployment" "api" {
+  metadata {
+    name = "api"
+    labels = {
+      app = "api"
+    }
+  }
+
+  spec {
+    template {
+      spec {
+        init_container {
+          name  = "migrate"
+          image = "registry.example.com/api:2.0.0"
+        }
+        container {
+          name  = "api"
+          image = "registry.example.com/api:2.0.0"
+          command = [<<EOT
+image = "not-an-image:1.0.0"
+EOT
+          ]
+        }
+        container {
+          name  = "sidecar"
+          image = "envoyproxy/envoy:v1.28.0"
+        }
+      }
+    }
+  }
+}
+
+variable "image" {
+  default = "ignored:1.0.0"
+}
+`
+
+func TestParse(t *testing.T) {
+	references, err := parse([]byte(config))
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+
+	want := []Reference{
+		{ID: "docker_image.nginx", Image: "nginx:1.25.3", Line: 3},
+		{ID: "docker_container.cache", Image: "redis:7.2.0", Line: 17},
+		{ID: "kubernetes_deployment.api.init_container[0]", Image: "registry.example.com/api:2.0.0", Line: 34},
+		{ID: "kubernetes_deployment.api.container[0]", Image: "registry.example.com/api:2.0.0", Line: 38},
+		{ID: "kubernetes_deployment.api.container[1]", Image: "envoyproxy/envoy:v1.28.0", Line: 46},
+	}
+	if len(references) != len(want) {
+		t.Fatalf("parse() = %+v, want %+v", references, want)
+	}
+	for i := range want {
+		if references[i] != want[i] {
+			t.Errorf("parse()[%d] = %+v, want %+v", i, references[i], want[i])
+		}
+	}
+}
+
+func TestReplaceReference(t *testing.T) {
+	data, err := ReplaceReference([]byte(config), "kubernetes_deployment.api.container[0]", "registry.example.com/api:2.0.0", "registry.example.com/api:2.1.0")
+	if err != nil {
+		t.Fatalf("ReplaceReference() error = %v", err)
+	}
+	if _, err := ReplaceReference(data, "docker_image.nginx", "nginx:1.0.0", "nginx:1.26.0"); err == nil {
+		t.Error("ReplaceReference() with a stale image should fail")
+	}
+
+	// Only the container block is updated, not the init container using the same image
+	want := strings.Replace(config, `          image = "registry.example.com/api:2.0.0"
+          command`, `          image = "registry.example.com/api:2.1.0"
+          command`, 1)
+	if string(data) != want {
+		t.Errorf("updated file =\n%s\nwant\n%s", data, want)
+	}
+}
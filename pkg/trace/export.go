@@ -0,0 +1,302 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/transport"
+)
+
+const (
+	// exportInterval is how often the ended spans are sent to the collector
+	exportInterval = 5 * time.Second
+
+	// exportBatchSize is the number of ended spans sent right away without waiting
+	exportBatchSize = 512
+
+	// exportTimeout is the timeout of sending spans to the collector
+	exportTimeout = 10 * time.Second
+
+	// scopeName is the instrumentation scope of the spans
+	scopeName = "gitlab.com/sdko-core/appli/img-upgr"
+)
+
+// Exporter sends the ended spans in batches to the traces endpoint of an OTLP collector,
+// encoded as OTLP JSON
+type Exporter struct {
+	endpoint   string
+	headers    map[string]string
+	resource   []Attribute
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	spans   []*Span
+	flushMu sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Configure starts exporting spans to the collector at endpoint, the base URL the
+// /v1/traces path is added to, with the headers of each request. The resource
+// attributes, such as service.name, describe the process.
+func Configure(endpoint string, headers map[string]string, resource ...Attribute) (*Exporter, error) {
+	tracesURL, err := TracesURL(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Exporter{
+		endpoint: tracesURL,
+		headers:  headers,
+		resource: resource,
+		// The requests of the exporter are not traced themselves
+		httpClient: &http.Client{Timeout: exportTimeout, Transport: transport.Shared()},
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go e.run()
+
+	exporterMu.Lock()
+	exporter = e
+	exporterMu.Unlock()
+
+	logger.Debug("Exporting traces to %s", tracesURL)
+	return e, nil
+}
+
+// TracesURL returns the traces endpoint of an OTLP collector base URL
+func TracesURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid OTLP endpoint %q: must be an http(s) URL", endpoint)
+	}
+	return strings.TrimSuffix(endpoint, "/") + "/v1/traces", nil
+}
+
+// ParseHeaders parses OTLP headers written as comma-separated key=value pairs with
+// URL-encoded values, like OTEL_EXPORTER_OTLP_HEADERS
+func ParseHeaders(value string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, rawValue, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid OTLP header %q: expected key=value", pair)
+		}
+		headerValue, err := url.QueryUnescape(strings.TrimSpace(rawValue))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTLP header %q: %w", key, err)
+		}
+		headers[key] = headerValue
+	}
+	return headers, nil
+}
+
+// Shutdown stops recording spans and sends the remaining ones
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	exporterMu.Lock()
+	if exporter == e {
+		exporter = nil
+	}
+	exporterMu.Unlock()
+
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	<-e.done
+	return e.flush(ctx)
+}
+
+// add queues an ended span, sending the batch once it is full
+func (e *Exporter) add(span *Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	full := len(e.spans) >= exportBatchSize
+	e.mu.Unlock()
+
+	if full {
+		go e.flushInBackground()
+	}
+}
+
+// run sends the ended spans periodically until the exporter is shut down
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.flushInBackground()
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// flushInBackground sends the ended spans, logging a failure
+func (e *Exporter) flushInBackground() {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	if err := e.flush(ctx); err != nil {
+		logger.Warn("Failed to export traces: %v", err)
+	}
+}
+
+// flush sends the ended spans to the collector
+func (e *Exporter) flush(ctx context.Context) error {
+	e.flushMu.Lock()
+	defer e.flushMu.Unlock()
+
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warn("Failed to close response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("collector returned status %d for %d spans", resp.StatusCode, len(spans))
+	}
+	logger.Debug("Exported %d spans", len(spans))
+	return nil
+}
+
+// OTLP JSON encoding of the export request, see opentelemetry-proto
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+)
+
+// statusError is the OTLP status code of failed spans, the others being left unset
+const statusError = 2
+
+// request builds the export request of spans
+func (e *Exporter) request(spans []*Span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.traceID[:]),
+			SpanID:            hex.EncodeToString(span.spanID[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        encodeAttributes(span.attributes),
+		}
+		if span.parentID != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(span.parentID[:])
+		}
+		if span.err != nil {
+			s.Status = otlpStatus{Code: statusError, Message: span.err.Error()}
+		}
+		span.mu.Unlock()
+		encoded = append(encoded, s)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: encodeAttributes(e.resource)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: encoded}},
+	}}}
+}
+
+// encodeAttributes encodes attributes, formatting values of other types as strings
+func encodeAttributes(attributes []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		var value otlpValue
+		switch v := attribute.Value.(type) {
+		case int:
+			s := strconv.Itoa(v)
+			value.IntValue = &s
+		case string:
+			value.StringValue = &v
+		default:
+			s := fmt.Sprint(v)
+			value.StringValue = &s
+		}
+		encoded = append(encoded, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+	return encoded
+}
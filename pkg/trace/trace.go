@@ -0,0 +1,208 @@
+// Package trace records spans of the registry, git and GitLab operations of a run and
+// exports them to an OpenTelemetry collector with OTLP over HTTP, showing where the time
+// of slow scans goes. Spans are only recorded once an exporter is configured, a nil
+// *Span being valid and recording nothing.
+package trace
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Kinds of spans, as numbered by OTLP
+const (
+	kindInternal = 1
+	kindClient   = 3
+)
+
+// Attribute is a key and a string or int value describing a span
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an int attribute
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a timed operation of a trace
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	err      error
+
+	mu         sync.Mutex
+	attributes []Attribute
+	ended      bool
+}
+
+// spanKey is the context key of the current span
+type spanKey struct{}
+
+var (
+	// exporter receives the ended spans, nil when tracing is disabled
+	exporter *Exporter
+	// exporterMu guards exporter
+	exporterMu sync.RWMutex
+)
+
+// Start starts a span as a child of the span of ctx, or as the root of a new trace, and
+// returns a context holding it. It returns ctx and a nil span when tracing is disabled.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	return start(ctx, name, kindInternal, attributes)
+}
+
+// start starts a span of a kind
+func start(ctx context.Context, name string, kind int, attributes []Attribute) (context.Context, *Span) {
+	if currentExporter() == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+	binary.BigEndian.PutUint64(span.spanID[:], rand.Uint64())
+	if parent := FromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		binary.BigEndian.PutUint64(span.traceID[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(span.traceID[8:], rand.Uint64())
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the span of ctx, nil if none
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithSpan returns a context holding span, whose children it parents
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// End ends the span, failed if err is not nil, and hands it to the exporter. Ending a
+// span again does nothing.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.err = err
+	s.mu.Unlock()
+
+	if e := currentExporter(); e != nil {
+		e.add(s)
+	}
+}
+
+// TraceID returns the hex ID of the trace of the span, empty for a nil span
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// traceparent returns the W3C trace context header of the span
+func (s *Span) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]))
+}
+
+// tracingTransport records a client span for each request and propagates the trace context
+// to trusted hosts
+type tracingTransport struct {
+	component string
+	base      http.RoundTripper
+	hosts     []string
+}
+
+// Transport returns a round tripper recording a client span named after the component,
+// such as registry or gitlab, for each request sent with base. The trace context is only
+// sent to the given hosts, such as the configured GitLab instance, as the IDs of the run
+// must not leak to registries and other third parties.
+func Transport(component string, base http.RoundTripper, hosts ...string) http.RoundTripper {
+	return &tracingTransport{component: component, base: base, hosts: hosts}
+}
+
+// RoundTrip sends the request within a client span
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := start(req.Context(), t.component+" "+req.Method, kindClient, []Attribute{
+		String("http.request.method", req.Method),
+		String("server.address", req.URL.Host),
+		String("url.path", req.URL.Path),
+	})
+	if span == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	// The request must not be modified, its clone carries the trace context
+	req = req.Clone(ctx)
+	if slices.Contains(t.hosts, req.URL.Host) {
+		req.Header.Set("traceparent", span.traceparent())
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.End(err)
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.End(fmt.Errorf("status %d", resp.StatusCode))
+	} else {
+		span.End(nil)
+	}
+	return resp, nil
+}
+
+// currentExporter returns the configured exporter, nil if tracing is disabled
+func currentExporter() *Exporter {
+	exporterMu.RLock()
+	defer exporterMu.RUnlock()
+	return exporter
+}
@@ -0,0 +1,171 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStartDisabled(t *testing.T) {
+	ctx, span := Start(context.Background(), "scan")
+	if span != nil {
+		t.Fatalf("Start() = %+v without an exporter, want nil", span)
+	}
+	if FromContext(ctx) != nil {
+		t.Error("context holds a span without an exporter")
+	}
+
+	// A nil span records nothing
+	span.SetAttributes(String("file", "compose.yml"))
+	span.End(errors.New("failed"))
+	if span.TraceID() != "" {
+		t.Errorf("TraceID() = %q, want empty", span.TraceID())
+	}
+}
+
+func TestExport(t *testing.T) {
+	var requests []otlpRequest
+	var authorization []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("collector received %s %s", r.Header.Get("Content-Type"), r.URL.Path)
+		}
+		var request otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("invalid export request: %v", err)
+		}
+		requests = append(requests, request)
+		authorization = append(authorization, r.Header.Get("Authorization"))
+	}))
+	defer collector.Close()
+
+	// The traced server checks the trace context of the requests
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	exporter, err := Configure(collector.URL+"/", map[string]string{"Authorization": "Bearer secret"}, String("service.name", "img-upgr"))
+	if err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+
+	ctx, root := Start(context.Background(), "check", String("img_upgr.run_id", "run-1"))
+	// The trace context is only sent to the trusted hosts
+	client := &http.Client{Transport: Transport("registry", http.DefaultTransport)}
+	untrusted, err := http.NewRequestWithContext(ctx, http.MethodHead, server.URL+"/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(untrusted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if traceparent != "" {
+		t.Errorf("traceparent %q sent to an untrusted host", traceparent)
+	}
+
+	client = &http.Client{Transport: Transport("registry", http.DefaultTransport, strings.TrimPrefix(server.URL, "http://"))}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/v2/library/nginx/tags/list", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if req.Header.Get("traceparent") != "" {
+		t.Error("transport modified the request")
+	}
+	_, child := Start(ctx, "git push")
+	child.End(errors.New("rejected"))
+	root.End(nil)
+	root.End(errors.New("ended twice"))
+
+	if err := exporter.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if _, span := Start(context.Background(), "after shutdown"); span != nil {
+		t.Error("spans are recorded after shutdown")
+	}
+
+	if len(requests) != 1 || authorization[0] != "Bearer secret" {
+		t.Fatalf("collector received %d requests with authorization %q, want 1 with the headers", len(requests), authorization)
+	}
+	resourceSpans := requests[0].ResourceSpans
+	if len(resourceSpans) != 1 || len(resourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("request = %+v, want a single resource and scope", requests[0])
+	}
+	if attributes := resourceSpans[0].Resource.Attributes; len(attributes) != 1 || *attributes[0].Value.StringValue != "img-upgr" {
+		t.Errorf("resource attributes = %+v, want service.name", attributes)
+	}
+
+	spans := make(map[string]otlpSpan)
+	for _, span := range resourceSpans[0].ScopeSpans[0].Spans {
+		spans[span.Name] = span
+	}
+	if len(spans) != 4 {
+		t.Fatalf("exported spans = %+v, want check, registry HEAD, registry GET and git push", spans)
+	}
+
+	check, get, push := spans["check"], spans["registry GET"], spans["git push"]
+	if check.TraceID != root.TraceID() || check.ParentSpanID != "" || check.Status.Code != 0 {
+		t.Errorf("check span = %+v, want an unfailed root of trace %s", check, root.TraceID())
+	}
+	for _, span := range []otlpSpan{get, push} {
+		if span.TraceID != check.TraceID || span.ParentSpanID != check.SpanID {
+			t.Errorf("%s span = %+v, want a child of the check span", span.Name, span)
+		}
+	}
+	if get.Kind != kindClient || get.Status.Code != statusError {
+		t.Errorf("registry GET span = %+v, want a failed client span", get)
+	}
+	if want := "00-" + check.TraceID + "-" + get.SpanID + "-01"; traceparent != want {
+		t.Errorf("traceparent = %q, want %q", traceparent, want)
+	}
+	if push.Status.Code != statusError || push.Status.Message != "rejected" {
+		t.Errorf("git push status = %+v, want the error", push.Status)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("Authorization=Basic%20dXNlcg==, x-scope = team ,")
+	if err != nil {
+		t.Fatalf("ParseHeaders() error = %v", err)
+	}
+	if len(headers) != 2 || headers["Authorization"] != "Basic dXNlcg==" || headers["x-scope"] != "team" {
+		t.Errorf("ParseHeaders() = %v", headers)
+	}
+
+	for _, value := range []string{"token", "=value", "key=%zz"} {
+		if _, err := ParseHeaders(value); err == nil {
+			t.Errorf("ParseHeaders(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestTracesURL(t *testing.T) {
+	tests := map[string]string{
+		"http://collector:4318":          "http://collector:4318/v1/traces",
+		"https://otlp.example.com/otlp/": "https://otlp.example.com/otlp/v1/traces",
+	}
+	for endpoint, want := range tests {
+		if got, err := TracesURL(endpoint); err != nil || got != want {
+			t.Errorf("TracesURL(%q) = %q, %v, want %q", endpoint, got, err, want)
+		}
+	}
+
+	for _, endpoint := range []string{"collector:4318", "ftp://collector", "http://"} {
+		if _, err := TracesURL(endpoint); err == nil || !strings.Contains(err.Error(), "invalid OTLP endpoint") {
+			t.Errorf("TracesURL(%q) error = %v, want invalid endpoint", endpoint, err)
+		}
+	}
+}
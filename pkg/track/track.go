@@ -0,0 +1,111 @@
+// Package track knows the long-term support releases of well-known images, such as the
+// even majors of node, so updates can be kept on supported release tracks.
+package track
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+)
+
+// Tracks an image can follow
+const (
+	// Any suggests any version
+	Any = "any"
+	// LTS suggests long-term support releases only
+	LTS = "lts"
+)
+
+// ValidTracks contains the list of tracks an image can follow
+var ValidTracks = []string{Any, LTS}
+
+// KnownLTS maps image repositories to their long-term support releases, written as
+// comma-separated major[.minor] terms where the major is a number, even or *
+var KnownLTS = map[string]string{
+	"node":            "even",
+	"mongo":           "*.0",
+	"ubuntu":          "even.4",
+	"eclipse-temurin": "8,11,17,21,25",
+	"amazoncorretto":  "8,11,17,21,25",
+}
+
+// Major values of a term that are not numbers
+const (
+	anyMajor  = -1
+	evenMajor = -2
+)
+
+// term matches the versions of a major, and of a minor when it is not negative
+type term struct {
+	major int
+	minor int
+}
+
+// Rule tells which versions of an image are long-term support releases
+type Rule struct {
+	terms []term
+}
+
+// Parse parses the long-term support releases of an image, such as even or 8,11,17
+func Parse(spec string) (*Rule, error) {
+	rule := &Rule{}
+	for _, value := range strings.Split(spec, ",") {
+		value = strings.TrimSpace(value)
+		majorValue, minorValue, hasMinor := strings.Cut(value, ".")
+
+		t := term{minor: -1}
+		switch majorValue {
+		case "*":
+			t.major = anyMajor
+		case "even":
+			t.major = evenMajor
+		default:
+			major, err := strconv.Atoi(majorValue)
+			if err != nil || major < 0 {
+				return nil, fmt.Errorf("invalid LTS release %q, expected major[.minor] with a number, even or * as major", value)
+			}
+			t.major = major
+		}
+
+		if hasMinor {
+			minor, err := strconv.Atoi(minorValue)
+			if err != nil || minor < 0 {
+				return nil, fmt.Errorf("invalid minor version in LTS release %q", value)
+			}
+			t.minor = minor
+		}
+		rule.terms = append(rule.terms, t)
+	}
+	return rule, nil
+}
+
+// Includes reports whether a version is a long-term support release
+func (r *Rule) Includes(version *semver.Version) bool {
+	for _, t := range r.terms {
+		switch {
+		case t.major == evenMajor && version.Major()%2 != 0:
+			continue
+		case t.major >= 0 && version.Major() != uint64(t.major):
+			continue
+		case t.minor >= 0 && version.Minor() != uint64(t.minor):
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Known returns the long-term support releases of an image repository, from the
+// releases set by the user or else the built-in ones
+func Known(repository string, releases map[string]string) (string, bool) {
+	for name, spec := range releases {
+		if reference.Normalize(name) == reference.Normalize(repository) {
+			return spec, true
+		}
+	}
+	spec, ok := KnownLTS[reference.Familiarize(repository)]
+	return spec, ok
+}
@@ -0,0 +1,56 @@
+package track
+
+import (
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+func TestRuleIncludes(t *testing.T) {
+	testCases := []struct {
+		spec     string
+		version  string
+		expected bool
+	}{
+		{spec: "even", version: "22.11.0", expected: true},
+		{spec: "even", version: "23.1.0", expected: false},
+		{spec: "*.0", version: "7.0.12", expected: true},
+		{spec: "*.0", version: "7.3.1", expected: false},
+		{spec: "even.4", version: "24.4.0", expected: true},
+		{spec: "even.4", version: "24.10.0", expected: false},
+		{spec: "8,11,17,21", version: "17.0.9", expected: true},
+		{spec: "8,11,17,21", version: "19.0.2", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.spec+"/"+tc.version, func(t *testing.T) {
+			rule, err := Parse(tc.spec)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := rule.Includes(semver.MustParse(tc.version)); got != tc.expected {
+				t.Errorf("Includes() = %t, want %t", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "odd", "8.x", "-1"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) accepted an invalid rule", spec)
+		}
+	}
+}
+
+func TestKnown(t *testing.T) {
+	if spec, ok := Known("docker.io/library/node", nil); !ok || spec != "even" {
+		t.Errorf("Known(node) = %q, %t, want the built-in rule", spec, ok)
+	}
+	if spec, ok := Known("node", map[string]string{"library/node": "22"}); !ok || spec != "22" {
+		t.Errorf("Known(node) = %q, %t, want the user rule", spec, ok)
+	}
+	if _, ok := Known("registry.example.com/app", nil); ok {
+		t.Error("Known() should not know a private image")
+	}
+}
@@ -0,0 +1,63 @@
+// Package transport provides the HTTP transport shared by the registry and GitLab
+// clients, so concurrent checks reuse their connections to each host.
+package transport
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxIdleConnsPerHost is the default number of idle connections kept per host,
+	// above the two of the standard library so concurrent checks reuse them
+	DefaultMaxIdleConnsPerHost = 16
+
+	// idleConnTimeout is how long idle connections are kept open
+	idleConnTimeout = 90 * time.Second
+)
+
+var (
+	shared *http.Transport
+	mu     sync.Mutex
+)
+
+// Shared returns the transport shared by every client, created with the default
+// settings unless Configure was called before
+func Shared() *http.Transport {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if shared == nil {
+		shared = newTransport(DefaultMaxIdleConnsPerHost, true)
+	}
+	return shared
+}
+
+// Configure sets the number of idle connections kept per host and whether HTTP/2 is
+// negotiated with the hosts supporting it. It only applies to the clients created after.
+func Configure(maxIdleConnsPerHost int, http2 bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if shared != nil {
+		shared.CloseIdleConnections()
+	}
+	shared = newTransport(maxIdleConnsPerHost, http2)
+}
+
+// newTransport creates a transport from the defaults of the standard library, with
+// the proxy settings of the environment
+func newTransport(maxIdleConnsPerHost int, http2 bool) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 0
+	t.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	t.IdleConnTimeout = idleConnTimeout
+	t.ForceAttemptHTTP2 = http2
+	if !http2 {
+		// A non-nil empty map disables HTTP/2
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return t
+}
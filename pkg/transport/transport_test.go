@@ -0,0 +1,22 @@
+package transport
+
+import "testing"
+
+func TestConfigure(t *testing.T) {
+	defaults := Shared()
+	if defaults.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost || !defaults.ForceAttemptHTTP2 {
+		t.Errorf("Shared() keeps %d idle connections, HTTP/2 %v, want the defaults", defaults.MaxIdleConnsPerHost, defaults.ForceAttemptHTTP2)
+	}
+	if Shared() != defaults {
+		t.Error("Shared() should return the same transport")
+	}
+
+	Configure(4, false)
+	configured := Shared()
+	if configured == defaults || configured.MaxIdleConnsPerHost != 4 {
+		t.Errorf("Shared() after Configure() keeps %d idle connections, want 4", configured.MaxIdleConnsPerHost)
+	}
+	if configured.ForceAttemptHTTP2 || configured.TLSNextProto == nil {
+		t.Error("Configure() without HTTP/2 should disable it")
+	}
+}
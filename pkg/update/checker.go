@@ -1,22 +1,22 @@
 package update
 
 import (
+	"context"
 	"fmt"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
 
 	"github.com/Masterminds/semver/v3"
-	"gitlab.com/sdko-core/appli/img-upgr/pkg/docker"
 	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/track"
 )
 
-const (
-	// ImageTagPattern is the regex pattern for parsing image name and tag
-	ImageTagPattern = `^([^:]+):(.+)$`
-	// SemverTagPattern is the regex pattern for extracting prefix and semver from a tag
-	SemverTagPattern = `^(.*?)(\d+\.\d+\.\d+)$`
-)
+// SemverTagPattern is the regex pattern for extracting prefix and semver from a tag
+const SemverTagPattern = `^(.*?)(\d+\.\d+\.\d+)$`
 
 // VersionInfo represents a tag with its parsed semantic version
 type VersionInfo struct {
@@ -33,10 +33,81 @@ type ImageInfo struct {
 	LatestTag     string
 	LatestVersion *semver.Version
 	HasUpdate     bool
+	// IsMajor is true when the update changes the major version
+	IsMajor bool
+	// IsDowngrade is true when the update goes back to an older version, only
+	// proposed with Options.AllowDowngrade
+	IsDowngrade bool
+}
+
+// Options restricts the versions an image can be updated to
+type Options struct {
+	// Constraint is the range the suggested version must satisfy, nil allowing any version
+	Constraint *semver.Constraints
+	// AllowMajor allows versions with another major version than the current one
+	AllowMajor bool
+	// Platform is the platform the suggested tag must be published for, nil allowing
+	// any tag. Checking it requires a registry client implementing registry.DetailsClient.
+	Platform *registry.Platform
+	// AllowDowngrade proposes the latest allowed version even when the current version is
+	// newer, such as a tag advanced by hand beyond the range, to roll it back
+	AllowDowngrade bool
+	// ExcludeTags are the patterns of tags never suggested, such as nightly builds
+	ExcludeTags []*regexp.Regexp
+	// Channel is a floating tag, such as stable or lts, whose version is the highest one
+	// suggested. Its version is found by comparing its digest with the digests of the tags.
+	Channel string
+	// LTS is the rule of the long-term support releases, the only versions suggested
+	// when set
+	LTS *track.Rule
+	// EarlyExit stops listing tags, on registries listing the most recently updated first,
+	// after the page holding the current tag or only lower versions. Tags pushed again since
+	// the current one can hide newer versions, and channels and downgrades list every tag.
+	EarlyExit bool
+	// Filter only lists the tags starting with the prefix of the current version, and with
+	// its major version unless other majors are allowed, on registries filtering tags by
+	// name. Channels list every tag, the channel tag having another name.
+	Filter bool
+
+	// channelVersion is the version the channel points to, nil when it has none
+	channelVersion *semver.Version
+}
+
+// maxChannelCandidates is the number of the latest versions whose digests are compared
+// with the digest of a channel before giving up
+const maxChannelCandidates = 20
+
+// maxPlatformCandidates is the number of newer versions whose platforms are checked
+// before giving up on finding one published for the required platform
+const maxPlatformCandidates = 10
+
+// allows reports whether the options allow updating the current version to a candidate
+func (o Options) allows(current, candidate *semver.Version) bool {
+	if o.Constraint != nil && !o.Constraint.Check(candidate) {
+		return false
+	}
+	if o.channelVersion != nil && candidate.GreaterThan(o.channelVersion) {
+		return false
+	}
+	if o.LTS != nil && !o.LTS.Includes(candidate) {
+		return false
+	}
+	return o.AllowMajor || current == nil || candidate.Major() == current.Major()
 }
 
 // CheckImage checks if an image has an update available
-func CheckImage(image string, dockerClient *docker.Client) (*ImageInfo, error) {
+func CheckImage(image string, registryClient registry.Client) (*ImageInfo, error) {
+	return CheckImageWithOptions(image, registryClient, Options{})
+}
+
+// CheckImageWithOptions checks if an image has an update available among the versions allowed by the options
+func CheckImageWithOptions(image string, registryClient registry.Client, opts Options) (*ImageInfo, error) {
+	return CheckImageWithContext(context.Background(), image, registryClient, opts)
+}
+
+// CheckImageWithContext checks if an image has an update available among the versions allowed
+// by the options, aborting the registry requests when ctx is cancelled
+func CheckImageWithContext(ctx context.Context, image string, registryClient registry.Client, opts Options) (*ImageInfo, error) {
 	logger.Debug("Checking image: %s", image)
 
 	repo, tag, err := parseImageString(image)
@@ -62,7 +133,7 @@ func CheckImage(image string, dockerClient *docker.Client) (*ImageInfo, error) {
 		Version:    currentVer,
 	}
 
-	latestVersion, err := findLatestVersion(repo, prefix, dockerClient)
+	latestVersion, err := findLatestVersion(ctx, repo, prefix, currentVer, registryClient, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find latest version: %w", err)
 	}
@@ -70,11 +141,19 @@ func CheckImage(image string, dockerClient *docker.Client) (*ImageInfo, error) {
 	if latestVersion != nil {
 		info.LatestTag = latestVersion.FullTag
 		info.LatestVersion = latestVersion.Version
-		info.HasUpdate = latestVersion.Version.GreaterThan(currentVer)
+		info.IsDowngrade = latestVersion.Version.LessThan(currentVer)
+		info.HasUpdate = latestVersion.Version.GreaterThan(currentVer) || (info.IsDowngrade && opts.AllowDowngrade)
+		info.IsMajor = info.HasUpdate && latestVersion.Version.Major() != currentVer.Major()
 
-		if info.HasUpdate {
+		switch {
+		case info.HasUpdate && info.IsDowngrade:
+			logger.Info("Downgrade proposed for %s: %s → %s", repo, tag, latestVersion.FullTag)
+		case info.HasUpdate:
 			logger.Info("Update available for %s: %s → %s", repo, tag, latestVersion.FullTag)
-		} else {
+		case info.IsDowngrade:
+			// A tag advanced by hand, such as a release candidate, is never downgraded unless asked
+			logger.Debug("Not downgrading %s: %s is newer than the latest allowed version %s", repo, tag, latestVersion.FullTag)
+		default:
 			logger.Debug("No update available for %s: %s is already the latest version", repo, tag)
 		}
 	}
@@ -82,19 +161,40 @@ func CheckImage(image string, dockerClient *docker.Client) (*ImageInfo, error) {
 	return info, nil
 }
 
-// parseImageString parses a Docker image string into repository and tag
+// parseImageString parses a Docker image string into repository and tag. Images pinned
+// to a digest are not semver-like, their content being checked with CheckDigest instead.
 func parseImageString(image string) (string, string, error) {
-	re := regexp.MustCompile(ImageTagPattern)
-	matches := re.FindStringSubmatch(image)
-	if matches == nil {
+	// Images set with compose variables, such as app:${TAG}, are skipped like before
+	if strings.Contains(image, "$") {
+		return "", "", fmt.Errorf("tag not semver-like: %s is set with variables", image)
+	}
+
+	ref, err := reference.Parse(image)
+	if err != nil {
+		return "", "", err
+	}
+	if ref.Tag == "" {
 		logger.Debug("No tag found in image: %s", image)
 		return "", "", fmt.Errorf("no tag found in image: %s", image)
 	}
+	if ref.Digest != "" {
+		return "", "", fmt.Errorf("tag not semver-like: %s@%s", ref.Tag, ref.Digest)
+	}
+
+	logger.Debug("Parsed repository: %s, tag: %s", ref.Repository(), ref.Tag)
+	return ref.Repository(), ref.Tag, nil
+}
+
+// ImageRepository returns the repository of an image reference without its tag or digest
+func ImageRepository(image string) string {
+	repo, _, _ := parseDigestReference(image)
+	return repo
+}
 
-	repo := matches[1]
-	tag := matches[2]
-	logger.Debug("Parsed repository: %s, tag: %s", repo, tag)
-	return repo, tag, nil
+// ImageTag returns the tag of an image reference, latest when it has none
+func ImageTag(image string) string {
+	_, tag, _ := parseDigestReference(image)
+	return tag
 }
 
 // extractVersionFromTag extracts prefix and semver from a tag
@@ -112,36 +212,205 @@ func extractVersionFromTag(tag string) (string, string, error) {
 	return prefix, versionStr, nil
 }
 
-// findLatestVersion finds the latest version for a repository with a given prefix
-func findLatestVersion(repo, prefix string, dockerClient *docker.Client) (*VersionInfo, error) {
-	// Fetch all tags and find matching versions
-	tags, err := dockerClient.FetchAllTags(repo)
+// findLatestVersion finds the latest version allowed by the options for a repository with a given prefix
+func findLatestVersion(ctx context.Context, repo, prefix string, current *semver.Version, registryClient registry.Client, opts Options) (*VersionInfo, error) {
+	// Fetch the tags and find matching versions
+	tags, err := fetchTags(ctx, repo, prefix, current, registryClient, opts)
 	if err != nil {
 		logger.Error("Failed to fetch tags: %v", err)
 		return nil, fmt.Errorf("failed to fetch tags: %w", err)
 	}
 
-	matchedVersions := findMatchingVersions(tags, prefix)
-	logger.Debug("Found %d matching versions", len(matchedVersions))
+	if opts.Channel != "" {
+		opts.channelVersion, err = findChannelVersion(ctx, repo, prefix, opts.Channel, tags, registryClient, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	if len(matchedVersions) == 0 {
+	if opts.Platform == nil {
+		return latestMatchingVersion(tags, prefix, current, opts), nil
+	}
+
+	detailsClient, ok := registryClient.(registry.DetailsClient)
+	if !ok {
+		return nil, fmt.Errorf("registry of %s does not provide the platforms of tags", repo)
+	}
+	return latestPlatformVersion(repo, allowedVersions(tags, prefix, current, opts), current, opts, detailsClient)
+}
+
+// fetchTags fetches the tags of a repository, only down to the current version with early
+// exit, or only the ones starting with the prefix of the current version when filtering
+func fetchTags(ctx context.Context, repo, prefix string, current *semver.Version, registryClient registry.Client, opts Options) ([]string, error) {
+	if !opts.EarlyExit || opts.Channel != "" || opts.AllowDowngrade {
+		if filter := tagFilter(prefix, current, opts); filter != "" {
+			logger.Debug("Fetching the tags of %s starting with %s", repo, filter)
+			return registry.FetchTagsWithPrefix(ctx, registryClient, repo, filter)
+		}
+		return registry.FetchAllTags(ctx, registryClient, repo)
+	}
+
+	currentTag := prefix + current.Original()
+	return registry.FetchTagsUntil(ctx, registryClient, repo, func(page []registry.TagInfo) bool {
+		names := make([]string, 0, len(page))
+		for _, tag := range page {
+			if tag.Name == currentTag {
+				return true
+			}
+			names = append(names, tag.Name)
+		}
+
+		// Pages of other variants, such as -alpine tags, say nothing about the current one
+		versions := findMatchingVersions(names, prefix, nil)
+		if len(versions) == 0 {
+			return false
+		}
+		for _, version := range versions {
+			if !version.Version.LessThan(current) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// tagFilter returns the prefix of the tags that can be suggested for the current version,
+// empty when every tag has to be listed
+func tagFilter(prefix string, current *semver.Version, opts Options) string {
+	if !opts.Filter || opts.Channel != "" {
+		return ""
+	}
+	if opts.AllowMajor || current == nil {
+		return prefix
+	}
+	return fmt.Sprintf("%s%d.", prefix, current.Major())
+}
+
+// findChannelVersion returns the version of the tags matching the prefix that the channel
+// tag points to, nil if the repository does not publish the channel
+func findChannelVersion(ctx context.Context, repo, prefix, channel string, tags []string, registryClient registry.Client, opts Options) (*semver.Version, error) {
+	if !slices.Contains(tags, channel) {
+		logger.Debug("No %s tag published for %s, suggesting any version", channel, repo)
 		return nil, nil
 	}
 
+	channelDigest, err := registry.FetchTagDigest(ctx, registryClient, repo, channel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch digest of %s:%s: %w", repo, channel, err)
+	}
+
+	versions := findMatchingVersions(tags, prefix, opts.ExcludeTags)
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version.GreaterThan(versions[j].Version)
+	})
+
+	for i, version := range versions {
+		if i >= maxChannelCandidates {
+			break
+		}
+		digest, err := registry.FetchTagDigest(ctx, registryClient, repo, version.FullTag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch digest of %s:%s: %w", repo, version.FullTag, err)
+		}
+		if digest == channelDigest {
+			logger.Debug("Channel %s of %s points to %s", channel, repo, version.FullTag)
+			return version.Version, nil
+		}
+	}
+
+	return nil, fmt.Errorf("none of the %d latest versions of %s is the %s tag", min(len(versions), maxChannelCandidates), repo, channel)
+}
+
+// latestPlatformVersion returns the highest of the versions, sorted in descending order,
+// whose tag is published for the platform of the options. The current version, and older
+// ones unless downgrades are allowed, are returned without checking, keeping the current tag.
+func latestPlatformVersion(repo string, versions []VersionInfo, current *semver.Version, opts Options, detailsClient registry.DetailsClient) (*VersionInfo, error) {
+	platform := *opts.Platform
+	for i, version := range versions {
+		if version.Version.Equal(current) || (version.Version.LessThan(current) && !opts.AllowDowngrade) {
+			return &versions[i], nil
+		}
+		if i >= maxPlatformCandidates {
+			logger.Debug("No version of %s among the %d latest is published for %s", repo, maxPlatformCandidates, platform)
+			return nil, nil
+		}
+
+		details, err := detailsClient.FetchImageDetails(repo, version.FullTag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch platforms of %s: %w", version.FullTag, err)
+		}
+		if details.Supports(platform) {
+			return &versions[i], nil
+		}
+		logger.Debug("Skipping %s:%s, not published for %s", repo, version.FullTag, platform)
+	}
+
+	return nil, nil
+}
+
+// latestMatchingVersion returns the highest version the options allow updating the current
+// version to among the tags matching the prefix, current being nil if unknown
+func latestMatchingVersion(tags []string, prefix string, current *semver.Version, opts Options) *VersionInfo {
+	matchedVersions := allowedVersions(tags, prefix, current, opts)
+	if len(matchedVersions) == 0 {
+		return nil
+	}
+	return &matchedVersions[0]
+}
+
+// allowedVersions returns the versions the options allow updating the current version to
+// among the tags matching the prefix, sorted in descending order
+func allowedVersions(tags []string, prefix string, current *semver.Version, opts Options) []VersionInfo {
+	matchedVersions := findMatchingVersions(tags, prefix, opts.ExcludeTags)
+	logger.Debug("Found %d matching versions", len(matchedVersions))
+
+	matchedVersions = slices.DeleteFunc(matchedVersions, func(v VersionInfo) bool {
+		return !opts.allows(current, v.Version)
+	})
+	logger.Debug("Kept %d versions allowed by the update options", len(matchedVersions))
+
 	// Sort by version descending
 	sort.Slice(matchedVersions, func(i, j int) bool {
 		return matchedVersions[i].Version.GreaterThan(matchedVersions[j].Version)
 	})
 
-	return &matchedVersions[0], nil
+	return matchedVersions
 }
 
-// findMatchingVersions finds all tags that match the prefix and can be parsed as semver
-func findMatchingVersions(tags []string, prefix string) []VersionInfo {
+// CheckVersion compares a version string, such as a Helm chart version, with the
+// available versions sharing its prefix and returns the latest one. The returned
+// version is nil when none of the available versions match.
+func CheckVersion(current string, available []string) (*VersionInfo, bool, error) {
+	prefix, versionStr, err := extractVersionFromTag(current)
+	if err != nil {
+		return nil, false, err
+	}
+
+	currentVer, err := semver.NewVersion(versionStr)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid semantic version: %s: %w", versionStr, err)
+	}
+
+	// Charts are not subject to the major version opt-in of images
+	latest := latestMatchingVersion(available, prefix, currentVer, Options{AllowMajor: true})
+	if latest == nil {
+		return nil, false, nil
+	}
+
+	return latest, latest.Version.GreaterThan(currentVer), nil
+}
+
+// findMatchingVersions finds all tags that match the prefix and can be parsed as semver,
+// skipping the tags matching an excluded pattern
+func findMatchingVersions(tags []string, prefix string, exclude []*regexp.Regexp) []VersionInfo {
 	var matchedVersions []VersionInfo
 
 	logger.Debug("Looking for tags with prefix: '%s'", prefix)
 	for _, tag := range tags {
+		if excluded(tag, exclude) {
+			logger.Debug("Skipping excluded tag: %s", tag)
+			continue
+		}
 		if strings.HasPrefix(tag, prefix) {
 			suffix := strings.TrimPrefix(tag, prefix)
 			if version, err := semver.NewVersion(suffix); err == nil {
@@ -156,3 +425,13 @@ func findMatchingVersions(tags []string, prefix string) []VersionInfo {
 
 	return matchedVersions
 }
+
+// excluded reports whether a tag matches one of the patterns
+func excluded(tag string, patterns []*regexp.Regexp) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,354 @@
+package update
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/track"
+)
+
+func TestLatestMatchingVersionConstraint(t *testing.T) {
+	tags := []string{"1.24.0", "1.25.1", "1.25.3", "1.26.0", "2.0.0", "alpine-1.25.4"}
+
+	testCases := []struct {
+		constraint string
+		expected   string
+	}{
+		{constraint: "", expected: "2.0.0"},
+		{constraint: "~1.25", expected: "1.25.3"},
+		{constraint: ">=1.24 <2", expected: "1.26.0"},
+		{constraint: ">=3", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.constraint, func(t *testing.T) {
+			var opts Options
+			if tc.constraint != "" {
+				constraint, err := semver.NewConstraint(tc.constraint)
+				if err != nil {
+					t.Fatal(err)
+				}
+				opts.Constraint = constraint
+			}
+
+			latest := latestMatchingVersion(tags, "", nil, opts)
+			got := ""
+			if latest != nil {
+				got = latest.FullTag
+			}
+			if got != tc.expected {
+				t.Errorf("latestMatchingVersion() = %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestLatestMatchingVersionMajor(t *testing.T) {
+	tags := []string{"1.25.3", "1.26.0", "2.0.0", "2.1.0"}
+	current := semver.MustParse("1.25.3")
+
+	if latest := latestMatchingVersion(tags, "", current, Options{}); latest == nil || latest.FullTag != "1.26.0" {
+		t.Errorf("latestMatchingVersion() without majors = %v, want 1.26.0", latest)
+	}
+	if latest := latestMatchingVersion(tags, "", current, Options{AllowMajor: true}); latest == nil || latest.FullTag != "2.1.0" {
+		t.Errorf("latestMatchingVersion() with majors = %v, want 2.1.0", latest)
+	}
+}
+
+func TestLatestMatchingVersionExcludeTags(t *testing.T) {
+	tags := []string{"1.25.3", "1.26.0-nightly", "1.26.0-beta1", "1.26.0-rc.1"}
+	exclude := []*regexp.Regexp{regexp.MustCompile(`^(?:.*-nightly)$`), regexp.MustCompile(`^(?:.*-beta\d+)$`)}
+
+	latest := latestMatchingVersion(tags, "", nil, Options{ExcludeTags: exclude})
+	if latest == nil || latest.FullTag != "1.26.0-rc.1" {
+		t.Errorf("latestMatchingVersion() = %v, want 1.26.0-rc.1", latest)
+	}
+}
+
+// platformClient is a registry client publishing tags for the listed platforms
+type platformClient struct {
+	tags map[string][]registry.Platform
+}
+
+func (c *platformClient) FetchAllTags(string) ([]string, error) {
+	tags := make([]string, 0, len(c.tags))
+	for tag := range c.tags {
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func (c *platformClient) FetchTagDigest(string, string) (string, error) {
+	return "", nil
+}
+
+func (c *platformClient) FetchImageDetails(_, tag string) (*registry.ImageDetails, error) {
+	return &registry.ImageDetails{Platforms: c.tags[tag]}, nil
+}
+
+func TestCheckImagePlatform(t *testing.T) {
+	amd64 := registry.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := registry.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	client := &platformClient{tags: map[string][]registry.Platform{
+		"1.0.0": {amd64, arm64},
+		"1.1.0": {amd64, arm64},
+		"1.2.0": {amd64},
+	}}
+
+	info, err := CheckImageWithOptions("app:1.0.0", client, Options{Platform: &registry.Platform{OS: "linux", Architecture: "arm64"}})
+	if err != nil {
+		t.Fatalf("CheckImageWithOptions() error = %v", err)
+	}
+	if !info.HasUpdate || info.LatestTag != "1.1.0" {
+		t.Errorf("CheckImageWithOptions() = %s, want 1.1.0", info.LatestTag)
+	}
+
+	info, err = CheckImageWithOptions("app:1.0.0", client, Options{Platform: &amd64})
+	if err != nil {
+		t.Fatalf("CheckImageWithOptions() error = %v", err)
+	}
+	if info.LatestTag != "1.2.0" {
+		t.Errorf("CheckImageWithOptions() = %s, want 1.2.0", info.LatestTag)
+	}
+}
+
+// digestClient is a registry client publishing tags with their digest
+type digestClient struct {
+	digests map[string]string
+}
+
+func (c *digestClient) FetchAllTags(string) ([]string, error) {
+	tags := make([]string, 0, len(c.digests))
+	for tag := range c.digests {
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func (c *digestClient) FetchTagDigest(_, tag string) (string, error) {
+	return c.digests[tag], nil
+}
+
+func TestCheckImageChannel(t *testing.T) {
+	// Release candidates are published as plain versions, stable points to 1.1.0
+	client := &digestClient{digests: map[string]string{
+		"1.0.0":  "sha256:a",
+		"1.1.0":  "sha256:b",
+		"1.2.0":  "sha256:c",
+		"stable": "sha256:b",
+	}}
+
+	info, err := CheckImageWithOptions("app:1.0.0", client, Options{Channel: "stable"})
+	if err != nil {
+		t.Fatalf("CheckImageWithOptions() error = %v", err)
+	}
+	if !info.HasUpdate || info.LatestTag != "1.1.0" {
+		t.Errorf("CheckImageWithOptions() = %s, want 1.1.0", info.LatestTag)
+	}
+
+	// Repositories without the channel tag get any version
+	info, err = CheckImageWithOptions("app:1.0.0", client, Options{Channel: "lts"})
+	if err != nil {
+		t.Fatalf("CheckImageWithOptions() error = %v", err)
+	}
+	if info.LatestTag != "1.2.0" {
+		t.Errorf("CheckImageWithOptions() = %s, want 1.2.0", info.LatestTag)
+	}
+
+	// A channel pointing to none of the versions is an error
+	client.digests["stable"] = "sha256:d"
+	if _, err := CheckImageWithOptions("app:1.0.0", client, Options{Channel: "stable"}); err == nil {
+		t.Error("CheckImageWithOptions() should fail when the channel matches no version")
+	}
+}
+
+func TestCheckImageLTS(t *testing.T) {
+	client := &platformClient{tags: map[string][]registry.Platform{"20.11.0": nil, "22.3.0": nil, "23.1.0": nil}}
+	rule, err := track.Parse("even")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := CheckImageWithOptions("node:20.11.0", client, Options{AllowMajor: true, LTS: rule})
+	if err != nil {
+		t.Fatalf("CheckImageWithOptions() error = %v", err)
+	}
+	if !info.HasUpdate || info.LatestTag != "22.3.0" {
+		t.Errorf("CheckImageWithOptions() = %s, want 22.3.0", info.LatestTag)
+	}
+}
+
+func TestCheckImageDowngrade(t *testing.T) {
+	client := &platformClient{tags: map[string][]registry.Platform{"1.25.3": nil, "1.25.4": nil, "1.26.0": nil}}
+	constraint, err := semver.NewConstraint("~1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A tag advanced by hand beyond the range is kept
+	info, err := CheckImageWithOptions("app:1.26.0", client, Options{Constraint: constraint})
+	if err != nil {
+		t.Fatalf("CheckImageWithOptions() error = %v", err)
+	}
+	if info.HasUpdate || !info.IsDowngrade {
+		t.Errorf("CheckImageWithOptions() HasUpdate = %v, IsDowngrade = %v, want no update of a newer tag", info.HasUpdate, info.IsDowngrade)
+	}
+
+	info, err = CheckImageWithOptions("app:1.26.0", client, Options{Constraint: constraint, AllowDowngrade: true})
+	if err != nil {
+		t.Fatalf("CheckImageWithOptions() error = %v", err)
+	}
+	if !info.HasUpdate || !info.IsDowngrade || info.LatestTag != "1.25.4" {
+		t.Errorf("CheckImageWithOptions() = %s (downgrade %v), want downgrade to 1.25.4", info.LatestTag, info.IsDowngrade)
+	}
+}
+
+// pagedClient is a registry client listing tags page by page from the most recently updated
+type pagedClient struct {
+	pages [][]string
+	read  int
+}
+
+func (c *pagedClient) FetchAllTags(string) ([]string, error) {
+	return c.FetchTagsUntilWithContext(context.Background(), "", nil)
+}
+
+func (c *pagedClient) FetchTagDigest(string, string) (string, error) {
+	return "", nil
+}
+
+func (c *pagedClient) FetchTagsUntilWithContext(_ context.Context, _ string, stop func(page []registry.TagInfo) bool) ([]string, error) {
+	var tags []string
+	for _, page := range c.pages {
+		c.read++
+		tags = append(tags, page...)
+
+		infos := make([]registry.TagInfo, 0, len(page))
+		for _, tag := range page {
+			infos = append(infos, registry.TagInfo{Name: tag})
+		}
+		if stop != nil && stop(infos) {
+			break
+		}
+	}
+	return tags, nil
+}
+
+func TestCheckImageEarlyExit(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages [][]string
+		want  string
+		read  int
+	}{
+		{"current tag", [][]string{{"1.27.0-alpine", "1.27.0"}, {"1.26.1", "1.25.3"}, {"1.25.2"}}, "1.27.0", 2},
+		{"lower versions", [][]string{{"1.26.0-alpine", "1.26.0"}, {"1.24.9-alpine", "1.24.9"}, {"1.25.3"}}, "1.26.0", 2},
+		{"other variants", [][]string{{"1.26.0"}, {"1.26.0-alpine"}, {"1.25.3"}}, "1.26.0", 3},
+	}
+	for _, tt := range tests {
+		client := &pagedClient{pages: tt.pages}
+		info, err := CheckImageWithOptions("nginx:1.25.3", client, Options{AllowMajor: true, EarlyExit: true})
+		if err != nil {
+			t.Fatalf("%s: CheckImageWithOptions() error = %v", tt.name, err)
+		}
+		if info.LatestTag != tt.want || client.read != tt.read {
+			t.Errorf("%s: CheckImageWithOptions() = %s after %d pages, want %s after %d", tt.name, info.LatestTag, client.read, tt.want, tt.read)
+		}
+	}
+
+	// Downgrades list every tag
+	client := &pagedClient{pages: [][]string{{"1.25.3"}, {"1.24.0"}}}
+	if _, err := CheckImageWithOptions("nginx:1.25.3", client, Options{EarlyExit: true, AllowDowngrade: true}); err != nil || client.read != 2 {
+		t.Errorf("CheckImageWithOptions() with downgrades read %d pages, %v, want all of them", client.read, err)
+	}
+}
+
+// prefixClient is a registry client filtering tags by name, recording the prefixes asked
+type prefixClient struct {
+	tags     []string
+	prefixes []string
+}
+
+func (c *prefixClient) FetchAllTags(string) ([]string, error) {
+	return c.tags, nil
+}
+
+func (c *prefixClient) FetchTagDigest(string, string) (string, error) {
+	return "", nil
+}
+
+func (c *prefixClient) FetchTagsWithPrefixWithContext(_ context.Context, _ string, prefix string) ([]string, error) {
+	c.prefixes = append(c.prefixes, prefix)
+	var tags []string
+	for _, tag := range c.tags {
+		if strings.HasPrefix(tag, prefix) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func TestCheckImageFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		image  string
+		opts   Options
+		want   string
+		prefix []string
+	}{
+		{"same major", "app:v1.2.0", Options{Filter: true}, "v1.3.0", []string{"v1."}},
+		{"any major", "app:v1.2.0", Options{Filter: true, AllowMajor: true}, "v10.0.0", []string{"v"}},
+		{"no prefix", "app:1.2.0", Options{Filter: true, AllowMajor: true}, "v10.0.0", nil},
+		{"disabled", "app:v1.2.0", Options{}, "v1.3.0", nil},
+		{"channel", "app:v1.2.0", Options{Filter: true, Channel: "stable"}, "v1.3.0", nil},
+	}
+	for _, tt := range tests {
+		client := &prefixClient{tags: []string{"1.3.0", "v1.2.0", "v1.3.0", "v10.0.0", "v2.0.0"}}
+		info, err := CheckImageWithOptions(tt.image, client, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: CheckImageWithOptions() error = %v", tt.name, err)
+		}
+		if info.LatestTag != tt.want || !slices.Equal(client.prefixes, tt.prefix) {
+			t.Errorf("%s: CheckImageWithOptions() = %s filtered by %v, want %s filtered by %v", tt.name, info.LatestTag, client.prefixes, tt.want, tt.prefix)
+		}
+	}
+}
+
+func TestParseImageString(t *testing.T) {
+	testCases := []struct {
+		image        string
+		expectedRepo string
+		expectedTag  string
+		wantErr      string
+	}{
+		{image: "nginx:1.25.3", expectedRepo: "nginx", expectedTag: "1.25.3"},
+		{image: "registry.example.com:5000/app:1.2.3", expectedRepo: "registry.example.com:5000/app", expectedTag: "1.2.3"},
+		{image: "registry.example.com:5000/app", wantErr: "no tag found"},
+		{image: "nginx:1.25.3@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", wantErr: "tag not semver-like"},
+		{image: "app:${TAG}", wantErr: "tag not semver-like"},
+		{image: "Nginx:1.0", wantErr: "invalid repository"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.image, func(t *testing.T) {
+			repo, tag, err := parseImageString(tc.image)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("parseImageString() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseImageString() error = %v", err)
+			}
+			if repo != tc.expectedRepo || tag != tc.expectedTag {
+				t.Errorf("parseImageString() = (%q, %q), want (%q, %q)", repo, tag, tc.expectedRepo, tc.expectedTag)
+			}
+		})
+	}
+}
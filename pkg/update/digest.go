@@ -0,0 +1,100 @@
+package update
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/logger"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/reference"
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/registry"
+)
+
+// DefaultTag is the tag used by Docker when a reference has none
+const DefaultTag = "latest"
+
+// DigestInfo represents the digest state of an image pinned to a mutable tag
+type DigestInfo struct {
+	Repository   string
+	Tag          string
+	Digest       string
+	LatestDigest string
+	HasUpdate    bool
+}
+
+// Reference returns the image reference pinned to the given digest
+func (d *DigestInfo) Reference(digest string) string {
+	return fmt.Sprintf("%s:%s@%s", d.Repository, d.Tag, digest)
+}
+
+// CheckDigest checks if the content behind a mutable tag such as latest changed
+// by comparing the referenced digest with the digest currently published for the tag
+func CheckDigest(image string, registryClient registry.Client) (*DigestInfo, error) {
+	return CheckDigestWithContext(context.Background(), image, registryClient)
+}
+
+// CheckDigestWithContext checks if the content behind a mutable tag changed, aborting the
+// registry request when ctx is cancelled
+func CheckDigestWithContext(ctx context.Context, image string, registryClient registry.Client) (*DigestInfo, error) {
+	logger.Debug("Checking digest of image: %s", image)
+
+	repo, tag, digest := parseDigestReference(image)
+	info := &DigestInfo{
+		Repository: repo,
+		Tag:        tag,
+		Digest:     digest,
+	}
+
+	latestDigest, err := registry.FetchTagDigest(ctx, registryClient, repo, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tag digest: %w", err)
+	}
+	if latestDigest == "" {
+		return nil, fmt.Errorf("registry did not report a digest for %s:%s", repo, tag)
+	}
+	info.LatestDigest = latestDigest
+
+	// Only references pinned to a digest can be compared
+	if digest != "" && digest != latestDigest {
+		info.HasUpdate = true
+		logger.Info("Image content changed for %s:%s: %s → %s", repo, tag, ShortDigest(digest), ShortDigest(latestDigest))
+	}
+
+	return info, nil
+}
+
+// parseDigestReference splits an image reference into repository, tag and optional digest.
+// Invalid references are returned as the repository, for the registry to reject them.
+func parseDigestReference(image string) (string, string, string) {
+	ref, err := reference.Parse(image)
+	if err != nil {
+		logger.Debug("Invalid image reference %s: %v", image, err)
+		return image, DefaultTag, ""
+	}
+
+	tag := ref.Tag
+	if tag == "" {
+		tag = DefaultTag
+	}
+	return ref.Repository(), tag, ref.Digest
+}
+
+// WithoutDigest returns an image reference without its digest, such as nginx:1.25 for
+// nginx:1.25@sha256:... Invalid references are returned as is.
+func WithoutDigest(image string) string {
+	ref, err := reference.Parse(image)
+	if err != nil || ref.Digest == "" {
+		return image
+	}
+	ref.Digest = ""
+	return ref.String()
+}
+
+// ShortDigest returns an abbreviated digest for display
+func ShortDigest(digest string) string {
+	hash := strings.TrimPrefix(digest, "sha256:")
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	return "sha256:" + hash
+}
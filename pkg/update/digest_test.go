@@ -0,0 +1,75 @@
+package update
+
+import (
+	"testing"
+)
+
+func TestParseDigestReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	testCases := []struct {
+		name           string
+		image          string
+		expectedRepo   string
+		expectedTag    string
+		expectedDigest string
+	}{
+		{
+			name:         "no tag",
+			image:        "nginx",
+			expectedRepo: "nginx",
+			expectedTag:  "latest",
+		},
+		{
+			name:         "mutable tag",
+			image:        "library/nginx:stable",
+			expectedRepo: "library/nginx",
+			expectedTag:  "stable",
+		},
+		{
+			name:           "tag and digest",
+			image:          "nginx:latest@" + digest,
+			expectedRepo:   "nginx",
+			expectedTag:    "latest",
+			expectedDigest: digest,
+		},
+		{
+			name:           "digest without tag",
+			image:          "nginx@" + digest,
+			expectedRepo:   "nginx",
+			expectedTag:    "latest",
+			expectedDigest: digest,
+		},
+		{
+			name:         "registry with port",
+			image:        "registry.example.com:5000/app",
+			expectedRepo: "registry.example.com:5000/app",
+			expectedTag:  "latest",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo, tag, digest := parseDigestReference(tc.image)
+			if repo != tc.expectedRepo || tag != tc.expectedTag || digest != tc.expectedDigest {
+				t.Errorf("parseDigestReference(%q) = (%q, %q, %q), want (%q, %q, %q)",
+					tc.image, repo, tag, digest, tc.expectedRepo, tc.expectedTag, tc.expectedDigest)
+			}
+		})
+	}
+}
+
+func TestWithoutDigest(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	for image, expected := range map[string]string{
+		"nginx:1.25.3@" + digest:                      "nginx:1.25.3",
+		"registry.example.com:5000/app:1.0@" + digest: "registry.example.com:5000/app:1.0",
+		"nginx:1.25.3":                                "nginx:1.25.3",
+		"${REGISTRY}/app:1.0":                         "${REGISTRY}/app:1.0",
+	} {
+		if got := WithoutDigest(image); got != expected {
+			t.Errorf("WithoutDigest(%q) = %q, want %q", image, got, expected)
+		}
+	}
+}
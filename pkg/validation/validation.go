@@ -7,6 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"gitlab.com/sdko-core/appli/img-upgr/pkg/repofs"
 )
 
 // ValidationError represents a validation error
@@ -84,7 +86,7 @@ func ValidateDirectory(path string) error {
 		return nil
 	}
 
-	info, err := os.Stat(path)
+	info, err := repofs.Stat(path)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("directory does not exist: %s", path)
 	}
@@ -104,7 +106,7 @@ func ValidateFile(path string) error {
 		return nil
 	}
 
-	info, err := os.Stat(path)
+	info, err := repofs.Stat(path)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("file does not exist: %s", path)
 	}
@@ -150,7 +152,7 @@ func ValidateFileOrDir(path string) error {
 		return nil
 	}
 
-	_, err := os.Stat(path)
+	_, err := repofs.Stat(path)
 	if os.IsNotExist(err) {
 		return fmt.Errorf("path does not exist: %s", path)
 	}
@@ -221,8 +223,13 @@ func ValidateRequiredVars(requiredVars map[string]string) error {
 
 // IsValidOutputFormat checks if an output format is valid
 func IsValidOutputFormat(format string, validFormats []string) bool {
-	for _, validFormat := range validFormats {
-		if format == validFormat {
+	return IsValidChoice(format, validFormats)
+}
+
+// IsValidChoice checks if a value is one of the allowed choices
+func IsValidChoice(value string, choices []string) bool {
+	for _, choice := range choices {
+		if value == choice {
 			return true
 		}
 	}
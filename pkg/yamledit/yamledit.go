@@ -0,0 +1,112 @@
+package yamledit
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MappingValue returns the value of a key in a mapping node, following aliases and
+// merge keys the way the YAML decoder does: explicit keys win over merged ones and
+// earlier merged mappings win over later ones
+func MappingValue(node *yaml.Node, key string) *yaml.Node {
+	node = ResolveAlias(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var merged []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+		if keyNode.Value == key {
+			return ResolveAlias(valueNode)
+		}
+		if keyNode.Tag == "!!merge" || keyNode.Value == "<<" {
+			merged = append(merged, valueNode)
+		}
+	}
+
+	for _, mergeNode := range merged {
+		mergeNode = ResolveAlias(mergeNode)
+		sources := []*yaml.Node{mergeNode}
+		if mergeNode.Kind == yaml.SequenceNode {
+			sources = mergeNode.Content
+		}
+		for _, source := range sources {
+			if value := MappingValue(source, key); value != nil {
+				return value
+			}
+		}
+	}
+
+	return nil
+}
+
+// Lookup follows a path of mapping keys from a node
+func Lookup(node *yaml.Node, keys ...string) *yaml.Node {
+	for _, key := range keys {
+		if node = MappingValue(node, key); node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+// ScalarValue returns the value of a scalar node, or an empty string for other nodes
+func ScalarValue(node *yaml.Node) string {
+	node = ResolveAlias(node)
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return node.Value
+}
+
+// ResolveAlias returns the node an alias points to
+func ResolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// ReplaceScalar returns content with old replaced by replacement inside the given
+// scalar node. Only the scalar text changes so comments and formatting are preserved.
+func ReplaceScalar(content []byte, node *yaml.Node, old, replacement string) ([]byte, error) {
+	return ReplaceAt(content, node.Line, node.Column, old, replacement)
+}
+
+// ReplaceAt replaces the first occurrence of old starting at a 1-based line and column
+func ReplaceAt(content []byte, line, column int, old, replacement string) ([]byte, error) {
+	offset := 0
+	for i := 1; i < line; i++ {
+		next := bytes.IndexByte(content[offset:], '\n')
+		if next < 0 {
+			return nil, fmt.Errorf("line %d out of range", line)
+		}
+		offset += next + 1
+	}
+
+	lineEnd := len(content)
+	if next := bytes.IndexByte(content[offset:], '\n'); next >= 0 {
+		lineEnd = offset + next
+	}
+
+	// The column points at the scalar, or at its anchor or tag when it has one
+	start := offset + column - 1
+	if start > lineEnd {
+		return nil, fmt.Errorf("column %d out of range on line %d", column, line)
+	}
+	index := bytes.Index(content[start:lineEnd], []byte(old))
+	if index < 0 {
+		return nil, fmt.Errorf("%s not found on line %d", old, line)
+	}
+	start += index
+
+	var result bytes.Buffer
+	result.Grow(len(content) - len(old) + len(replacement))
+	result.Write(content[:start])
+	result.WriteString(replacement)
+	result.Write(content[start+len(old):])
+	return result.Bytes(), nil
+}
@@ -0,0 +1,93 @@
+package yamledit
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func parse(t *testing.T, content string) *yaml.Node {
+	t.Helper()
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &document); err != nil {
+		t.Fatal(err)
+	}
+	return document.Content[0]
+}
+
+func TestLookup(t *testing.T) {
+	root := parse(t, `defaults: &defaults
+  image: nginx:1.25.0
+  replicas: 1
+overrides: &overrides
+  replicas: 2
+spec:
+  <<: [*overrides, *defaults]
+  replicas: 3
+merged:
+  <<: [*overrides, *defaults]
+alias: *defaults
+`)
+
+	tests := []struct {
+		name string
+		keys []string
+		want string
+	}{
+		{"explicit key over merged ones", []string{"spec", "replicas"}, "3"},
+		{"earlier merged mapping first", []string{"merged", "replicas"}, "2"},
+		{"later merged mapping", []string{"merged", "image"}, "nginx:1.25.0"},
+		{"through an alias", []string{"alias", "image"}, "nginx:1.25.0"},
+		{"missing key", []string{"spec", "missing"}, ""},
+		{"not a mapping", []string{"spec", "replicas", "value"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ScalarValue(Lookup(root, tt.keys...)); got != tt.want {
+				t.Errorf("Lookup(%v) = %q, want %q", tt.keys, got, tt.want)
+			}
+		})
+	}
+
+	if ScalarValue(Lookup(root, "spec")) != "" {
+		t.Error("ScalarValue() of a mapping is not empty")
+	}
+}
+
+func TestReplaceScalar(t *testing.T) {
+	content := `# podinfo
+spec:
+  chart: podinfo
+  version: &version "6.5.0" # pinned
+  image: nginx:6.5.0
+`
+	root := parse(t, content)
+
+	got, err := ReplaceScalar([]byte(content), Lookup(root, "spec", "version"), "6.5.0", "6.6.0")
+	if err != nil {
+		t.Fatalf("ReplaceScalar() error = %v", err)
+	}
+	want := strings.Replace(content, `"6.5.0"`, `"6.6.0"`, 1)
+	if string(got) != want {
+		t.Errorf("ReplaceScalar() = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name         string
+		line, column int
+		old          string
+		wantErr      string
+	}{
+		{"line out of range", 10, 1, "6.5.0", "line 10 out of range"},
+		{"column out of range", 3, 40, "podinfo", "column 40 out of range on line 3"},
+		{"value on another line", 3, 3, "6.5.0", "6.5.0 not found on line 3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReplaceAt([]byte(content), tt.line, tt.column, tt.old, "6.6.0"); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReplaceAt() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}